@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/gorilla/websocket"
+)
+
+// consoleEscape detaches from an instance console, as in telnet.
+const consoleEscape = 0x1d
+
+type instanceConsoleCommand struct {
+	Flag     flag.FlagSet
+	instance string
+}
+
+func (cmd *instanceConsoleCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance console [flags]
+
+Attach the terminal to the serial console of a Ciao instance.
+Type Ctrl-] to detach.
+
+The console flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceConsoleCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceConsoleCommand) run([]string) error {
+	if *tenantID == "" {
+		errorf("Missing required -tenant-id parameter")
+		cmd.usage()
+	}
+
+	if cmd.instance == "" {
+		errorf("Missing required -instance parameter")
+		cmd.usage()
+	}
+
+	consoleURL := getSerialConsoleURL(cmd.instance)
+
+	warningf("Skipping TLS verification\n")
+	dialer := websocket.Dialer{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	ws, _, err := dialer.Dial(consoleURL, nil)
+	if err != nil {
+		fatalf("Unable to connect to console: %v", err)
+	}
+	defer func() { _ = ws.Close() }()
+
+	restore, err := rawTerminal()
+	if err != nil {
+		fatalf("Unable to set terminal in raw mode: %v", err)
+	}
+	defer restore()
+
+	fmt.Printf("Connected to the console of %s, type Ctrl-] to detach\r\n", cmd.instance)
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				_ = ws.Close()
+				return
+			}
+
+			input := buf[:n]
+			escape := bytes.IndexByte(input, consoleEscape)
+			if escape >= 0 {
+				input = input[:escape]
+			}
+
+			if len(input) > 0 {
+				err = ws.WriteMessage(websocket.BinaryMessage, input)
+				if err != nil {
+					return
+				}
+			}
+
+			if escape >= 0 {
+				_ = ws.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		_, _ = os.Stdout.Write(data)
+	}
+
+	fmt.Printf("\r\nDisconnected from the console of %s\r\n", cmd.instance)
+	return nil
+}
+
+func getSerialConsoleURL(instance string) string {
+	action := payloads.ComputeSerialConsoleAction{
+		GetSerialConsole: &payloads.ComputeSerialConsole{
+			Type: "serial",
+		},
+	}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/servers/%s/action", *tenantID, instance)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Serial console request failed: %s", resp.Status)
+	}
+
+	var console payloads.ComputeSerialConsoleResponse
+	err = unmarshalHTTPResponse(resp, &console)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	return console.Console.URL
+}
+
+// rawTerminal puts the terminal in raw mode, so that key presses are sent
+// to the console as they are typed, and returns a function restoring the
+// original terminal settings.
+func rawTerminal() (func(), error) {
+	stty := func(args ...string) (string, error) {
+		c := exec.Command("stty", args...)
+		c.Stdin = os.Stdin
+		out, err := c.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = stty("raw", "-echo")
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { _, _ = stty(state) }, nil
+}
@@ -17,10 +17,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/01org/ciao/payloads"
 )
@@ -28,6 +33,7 @@ import (
 var eventCommand = &command{
 	SubCommands: map[string]subCommand{
 		"list":   new(eventListCommand),
+		"watch":  new(eventWatchCommand),
 		"delete": new(eventDeleteCommand),
 	},
 }
@@ -94,6 +100,104 @@ func (cmd *eventListCommand) run(args []string) error {
 	return nil
 }
 
+type eventWatchCommand struct {
+	Flag   flag.FlagSet
+	all    bool
+	tenant string
+	since  uint64
+}
+
+func (cmd *eventWatchCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] event watch [flags]
+
+Watch prints the events of the ciao cluster as they happen
+
+The watch flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *eventWatchCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.all, "all", false, "Watch events for all tenants in a cluster")
+	cmd.Flag.StringVar(&cmd.tenant, "tenant-id", "", "Tenant ID")
+	cmd.Flag.Uint64Var(&cmd.since, "since", 0, "ID of the event to resume watching after")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+// watchEvents prints the events of an event stream, returning the ID of
+// the last event received once the stream ends.
+func watchEvents(resp *http.Response, since uint64) (uint64, error) {
+	defer resp.Body.Close()
+
+	stream := bufio.NewReader(resp.Body)
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			return since, err
+		}
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var event payloads.CiaoStreamEvent
+		err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+		if err != nil {
+			return since, err
+		}
+		since = event.ID
+
+		fmt.Printf("[%d] %v: %s: %s", event.ID, event.Timestamp, event.Kind, event.Message)
+		if event.TenantID != "" {
+			fmt.Printf(" (Tenant %s)", event.TenantID)
+		}
+		fmt.Printf("\n")
+	}
+}
+
+func (cmd *eventWatchCommand) run(args []string) error {
+	if cmd.tenant == "" {
+		cmd.tenant = *tenantID
+	}
+
+	if cmd.all == false && cmd.tenant == "" {
+		errorf("Missing required -tenant-id parameter")
+		cmd.usage()
+	}
+
+	var url string
+
+	if cmd.all == true {
+		url = buildComputeURL("events/stream")
+	} else {
+		url = buildComputeURL("%s/events/stream", cmd.tenant)
+	}
+
+	// The controller ends the streams of the watchers falling behind,
+	// which resume from the last event they received.
+	since := cmd.since
+	for {
+		var values []queryValue
+
+		if since > 0 {
+			values = []queryValue{{name: "since", value: strconv.FormatUint(since, 10)}}
+		}
+
+		resp, err := sendHTTPRequest("GET", url, values, nil)
+		if err != nil {
+			fatalf(err.Error())
+		}
+
+		since, err = watchEvents(resp, since)
+		warningf("Event stream ended: %v\n", err)
+		time.Sleep(time.Second)
+	}
+}
+
 type eventDeleteCommand struct {
 	Flag   flag.FlagSet
 	all    bool
@@ -0,0 +1,304 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/01org/ciao/payloads"
+)
+
+var floatingIPCommand = &command{
+	SubCommands: map[string]subCommand{
+		"list":         new(floatingIPListCommand),
+		"create":       new(floatingIPCreateCommand),
+		"delete":       new(floatingIPDeleteCommand),
+		"associate":    new(floatingIPAssociateCommand),
+		"disassociate": new(floatingIPDisassociateCommand),
+	},
+}
+
+func dumpFloatingIP(ip payloads.FloatingIP) {
+	fmt.Printf("\tUUID: %s\n", ip.ID)
+	fmt.Printf("\tAddress: %s\n", ip.IP)
+	fmt.Printf("\tPool: %s\n", ip.Pool)
+	if ip.InstanceID != nil {
+		fmt.Printf("\tInstance UUID: %s\n", *ip.InstanceID)
+	}
+	if ip.FixedIP != nil {
+		fmt.Printf("\tFixed IP: %s\n", *ip.FixedIP)
+	}
+}
+
+type floatingIPListCommand struct {
+	Flag flag.FlagSet
+}
+
+func (cmd *floatingIPListCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] floatingip list
+
+List the floating IPs allocated to a tenant
+`)
+	os.Exit(2)
+}
+
+func (cmd *floatingIPListCommand) parseArgs(args []string) []string {
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *floatingIPListCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	var ips payloads.ComputeFloatingIPs
+
+	url := buildComputeURL("%s/os-floating-ips", *tenantID)
+
+	resp, err := sendHTTPRequest("GET", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = unmarshalHTTPResponse(resp, &ips)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	for i, ip := range ips.FloatingIPs {
+		fmt.Printf("Floating IP %d\n", i+1)
+		dumpFloatingIP(ip)
+	}
+	return nil
+}
+
+type floatingIPCreateCommand struct {
+	Flag flag.FlagSet
+	pool string
+}
+
+func (cmd *floatingIPCreateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] floatingip create [flags]
+
+Allocate a floating IP to a tenant
+
+The create flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *floatingIPCreateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.pool, "pool", "", "Floating IP pool name")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *floatingIPCreateCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	b, err := json.Marshal(payloads.ComputeCreateFloatingIP{Pool: cmd.pool})
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/os-floating-ips", *tenantID)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Floating IP allocation failed: %s", resp.Status)
+	}
+
+	var ip payloads.ComputeFloatingIP
+	err = unmarshalHTTPResponse(resp, &ip)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Allocated floating IP %s: %s\n", ip.FloatingIP.IP, ip.FloatingIP.ID)
+	return nil
+}
+
+type floatingIPDeleteCommand struct {
+	Flag       flag.FlagSet
+	floatingIP string
+}
+
+func (cmd *floatingIPDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] floatingip delete [flags]
+
+Release a floating IP back to the pool
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *floatingIPDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.floatingIP, "floating-ip", "", "Floating IP UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *floatingIPDeleteCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	if cmd.floatingIP == "" {
+		errorf("Missing required -floating-ip parameter")
+		cmd.usage()
+	}
+
+	url := buildComputeURL("%s/os-floating-ips/%s", *tenantID, cmd.floatingIP)
+
+	resp, err := sendHTTPRequest("DELETE", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Floating IP release failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Released floating IP: %s\n", cmd.floatingIP)
+	return nil
+}
+
+func floatingIPAction(instance string, action payloads.ComputeFloatingIPAction) {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/servers/%s/action", *tenantID, instance)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Floating IP action failed: %s", resp.Status)
+	}
+}
+
+type floatingIPAssociateCommand struct {
+	Flag     flag.FlagSet
+	address  string
+	instance string
+}
+
+func (cmd *floatingIPAssociateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] floatingip associate [flags]
+
+Associate a floating IP with an instance
+
+The associate flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *floatingIPAssociateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.address, "address", "", "Floating IP address")
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *floatingIPAssociateCommand) run(args []string) error {
+	if cmd.address == "" || cmd.instance == "" {
+		errorf("Missing required -address or -instance parameter")
+		cmd.usage()
+	}
+
+	floatingIPAction(cmd.instance, payloads.ComputeFloatingIPAction{
+		AddFloatingIP: &payloads.FloatingIPAddress{Address: cmd.address},
+	})
+
+	fmt.Printf("Associated floating IP %s with instance %s\n", cmd.address, cmd.instance)
+	return nil
+}
+
+type floatingIPDisassociateCommand struct {
+	Flag     flag.FlagSet
+	address  string
+	instance string
+}
+
+func (cmd *floatingIPDisassociateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] floatingip disassociate [flags]
+
+Disassociate a floating IP from an instance
+
+The disassociate flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *floatingIPDisassociateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.address, "address", "", "Floating IP address")
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *floatingIPDisassociateCommand) run(args []string) error {
+	if cmd.address == "" || cmd.instance == "" {
+		errorf("Missing required -address or -instance parameter")
+		cmd.usage()
+	}
+
+	floatingIPAction(cmd.instance, payloads.ComputeFloatingIPAction{
+		RemoveFloatingIP: &payloads.FloatingIPAddress{Address: cmd.address},
+	})
+
+	fmt.Printf("Disassociated floating IP %s from instance %s\n", cmd.address, cmd.instance)
+	return nil
+}
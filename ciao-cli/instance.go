@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"os"
 	"text/tabwriter"
+	"time"
 
 	"github.com/01org/ciao/payloads"
 )
@@ -43,6 +44,32 @@ var instanceCommand = &command{
 		"show":    new(instanceShowCommand),
 		"restart": new(instanceRestartCommand),
 		"stop":    new(instanceStopCommand),
+		"reboot":  new(instanceRebootCommand),
+		"pause": &instanceActionCommand{
+			name:        "pause",
+			description: "Pause a running Ciao instance",
+			done:        "paused",
+		},
+		"unpause": &instanceActionCommand{
+			name:        "unpause",
+			description: "Unpause a paused Ciao instance",
+			done:        "unpaused",
+		},
+		"suspend": &instanceActionCommand{
+			name:        "suspend",
+			description: "Suspend a running Ciao instance",
+			done:        "suspended",
+		},
+		"resume": &instanceActionCommand{
+			name:        "resume",
+			description: "Resume a suspended Ciao instance",
+			done:        "resumed",
+		},
+		"resize":       new(instanceResizeCommand),
+		"migrate":      new(instanceMigrateCommand),
+		"console-log":  new(instanceConsoleLogCommand),
+		"create-image": new(instanceCreateImageCommand),
+		"console":      new(instanceConsoleCommand),
 	},
 }
 
@@ -51,6 +78,9 @@ type instanceAddCommand struct {
 	workload  string
 	instances int
 	label     string
+	key       string
+	recovery  string
+	wait      bool
 }
 
 func (cmd *instanceAddCommand) usage(...string) {
@@ -69,6 +99,9 @@ func (cmd *instanceAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.workload, "workload", "", "Workload UUID")
 	cmd.Flag.IntVar(&cmd.instances, "instances", 1, "Number of instances to create")
 	cmd.Flag.StringVar(&cmd.label, "label", "", "Set a frame label. This will trigger frame tracing")
+	cmd.Flag.StringVar(&cmd.key, "key", "", "Name of the keypair to inject into the instance")
+	cmd.Flag.StringVar(&cmd.recovery, "recovery", "", "Recovery policy overriding the workload one (none, restart-on-reconnect or reschedule-elsewhere)")
+	cmd.Flag.BoolVar(&cmd.wait, "wait", false, "Wait for the instances to be running")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -88,10 +121,12 @@ func (cmd *instanceAddCommand) run(args []string) error {
 	var server payloads.ComputeCreateServer
 	var servers payloads.ComputeServers
 
-	server.Server.Name = cmd.label
+	server.Server.TraceLabel = cmd.label
 	server.Server.Workload = cmd.workload
 	server.Server.MaxInstances = cmd.instances
 	server.Server.MinInstances = 1
+	server.Server.KeyName = cmd.key
+	server.Server.RecoveryPolicy = cmd.recovery
 
 	serverBytes, err := json.Marshal(server)
 	if err != nil {
@@ -118,6 +153,14 @@ func (cmd *instanceAddCommand) run(args []string) error {
 	for _, server := range servers.Servers {
 		fmt.Printf("Created new instance: %s\n", server.ID)
 	}
+
+	if cmd.wait {
+		err = waitForTask(resp)
+		if err != nil {
+			fatalf(err.Error())
+		}
+		fmt.Printf("Instances running\n")
+	}
 	return nil
 }
 
@@ -125,6 +168,7 @@ type instanceDeleteCommand struct {
 	Flag     flag.FlagSet
 	instance string
 	all      bool
+	wait     bool
 }
 
 func (cmd *instanceDeleteCommand) usage(...string) {
@@ -142,6 +186,7 @@ The delete flags are:
 func (cmd *instanceDeleteCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
 	cmd.Flag.BoolVar(&cmd.all, "all", false, "Delete all instances for the given tenant")
+	cmd.Flag.BoolVar(&cmd.wait, "wait", false, "Wait for the instance to be deleted")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -170,6 +215,13 @@ func (cmd *instanceDeleteCommand) run(args []string) error {
 		fatalf("Instance deletion failed: %s", resp.Status)
 	}
 
+	if cmd.wait {
+		err = waitForTask(resp)
+		if err != nil {
+			fatalf(err.Error())
+		}
+	}
+
 	fmt.Printf("Deleted instance: %s\n", cmd.instance)
 	return nil
 }
@@ -247,12 +299,12 @@ func startStopInstance(instance string, stop bool) error {
 		return errors.New("Missing required -instance parameter")
 	}
 
-	actionBytes := []byte(osStart)
+	action := osStart
 	if stop == true {
-		actionBytes = []byte(osStop)
+		action = osStop
 	}
 
-	body := bytes.NewReader(actionBytes)
+	body := bytes.NewReader([]byte(fmt.Sprintf(`{"%s":null}`, action)))
 
 	url := buildComputeURL("%s/servers/%s/action", *tenantID, instance)
 
@@ -273,6 +325,359 @@ func startStopInstance(instance string, stop bool) error {
 	return nil
 }
 
+type instanceRebootCommand struct {
+	Flag     flag.FlagSet
+	instance string
+	hard     bool
+}
+
+func (cmd *instanceRebootCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance reboot [flags]
+
+Reboot a Ciao instance
+
+The reboot flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceRebootCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.BoolVar(&cmd.hard, "hard", false, "Power the instance off instead of shutting it down cleanly")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceRebootCommand) run([]string) error {
+	rebootType := "SOFT"
+	if cmd.hard {
+		rebootType = "HARD"
+	}
+
+	action := payloads.ComputeRebootAction{
+		Reboot: &payloads.ComputeReboot{
+			Type: rebootType,
+		},
+	}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = sendInstanceAction(cmd.instance, b)
+	if err != nil {
+		cmd.usage()
+	}
+
+	fmt.Printf("Instance %s rebooted\n", cmd.instance)
+	return nil
+}
+
+type instanceResizeCommand struct {
+	Flag     flag.FlagSet
+	instance string
+	workload string
+}
+
+func (cmd *instanceResizeCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance resize [flags]
+
+Resize a stopped Ciao instance to the resources of another workload
+
+The resize flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceResizeCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.StringVar(&cmd.workload, "workload", "", "UUID of the workload to take the resources from")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceResizeCommand) run([]string) error {
+	if cmd.workload == "" {
+		errorf("Missing required -workload parameter")
+		cmd.usage()
+	}
+
+	action := payloads.ComputeResizeAction{
+		Resize: &payloads.ComputeResize{
+			FlavorRef: cmd.workload,
+		},
+	}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = sendInstanceAction(cmd.instance, b)
+	if err != nil {
+		cmd.usage()
+	}
+
+	fmt.Printf("Instance %s resized\n", cmd.instance)
+	return nil
+}
+
+type instanceMigrateCommand struct {
+	Flag     flag.FlagSet
+	instance string
+	target   string
+}
+
+func (cmd *instanceMigrateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance migrate [flags]
+
+Live migrate a running Ciao instance to another compute node
+
+The migrate flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceMigrateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.StringVar(&cmd.target, "target", "", "UUID of the target node, chosen by the scheduler if not set")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceMigrateCommand) run([]string) error {
+	action := payloads.ComputeMigrateLiveAction{
+		MigrateLive: &payloads.ComputeMigrateLive{
+			Host: cmd.target,
+		},
+	}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = sendInstanceAction(cmd.instance, b)
+	if err != nil {
+		cmd.usage()
+	}
+
+	fmt.Printf("Instance %s migrating\n", cmd.instance)
+	return nil
+}
+
+// instanceActionCommand implements the payloadless pause, unpause,
+// suspend and resume server actions.
+type instanceActionCommand struct {
+	Flag        flag.FlagSet
+	instance    string
+	name        string
+	description string
+	done        string
+}
+
+func (cmd *instanceActionCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance %s [flags]
+
+%s
+
+The %s flags are:
+
+`, cmd.name, cmd.description, cmd.name)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceActionCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceActionCommand) run([]string) error {
+	err := sendInstanceAction(cmd.instance, []byte(fmt.Sprintf(`{"%s":null}`, cmd.name)))
+	if err != nil {
+		cmd.usage()
+	}
+
+	fmt.Printf("Instance %s %s\n", cmd.instance, cmd.done)
+	return nil
+}
+
+type instanceConsoleLogCommand struct {
+	Flag     flag.FlagSet
+	instance string
+	lines    int
+}
+
+func (cmd *instanceConsoleLogCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance console-log [flags]
+
+Print the console output of a Ciao instance
+
+The console-log flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceConsoleLogCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.IntVar(&cmd.lines, "lines", 0, "Number of lines to print, 0 for the whole log")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceConsoleLogCommand) run([]string) error {
+	if *tenantID == "" {
+		errorf("Missing required -tenant-id parameter")
+		cmd.usage()
+	}
+
+	if cmd.instance == "" {
+		errorf("Missing required -instance parameter")
+		cmd.usage()
+	}
+
+	var action payloads.ComputeConsoleOutputAction
+	action.GetConsoleOutput = &payloads.ComputeConsoleOutput{}
+	if cmd.lines > 0 {
+		action.GetConsoleOutput.Length = &cmd.lines
+	}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/servers/%s/action", *tenantID, cmd.instance)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Console log retrieval failed: %s", resp.Status)
+	}
+
+	var console payloads.ComputeConsoleOutputResponse
+	err = unmarshalHTTPResponse(resp, &console)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Print(console.Output)
+	return nil
+}
+
+type instanceCreateImageCommand struct {
+	Flag     flag.FlagSet
+	instance string
+	name     string
+}
+
+func (cmd *instanceCreateImageCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance create-image [flags]
+
+Create a private image from the rootfs of a Ciao instance
+
+The create-image flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceCreateImageCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Image name")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceCreateImageCommand) run([]string) error {
+	if *tenantID == "" {
+		errorf("Missing required -tenant-id parameter")
+		cmd.usage()
+	}
+
+	if cmd.instance == "" {
+		errorf("Missing required -instance parameter")
+		cmd.usage()
+	}
+
+	if cmd.name == "" {
+		errorf("Missing required -name parameter")
+		cmd.usage()
+	}
+
+	var action payloads.ComputeCreateImageAction
+	action.CreateImage = &payloads.ComputeCreateImage{Name: cmd.name}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/servers/%s/action", *tenantID, cmd.instance)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Image creation failed: %s", resp.Status)
+	}
+
+	var image payloads.ComputeCreateImageResponse
+	err = unmarshalHTTPResponse(resp, &image)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Creating image %s\n", image.ImageID)
+	return nil
+}
+
+func sendInstanceAction(instance string, action []byte) error {
+	if *tenantID == "" {
+		return errors.New("Missing required -tenant-id parameter")
+	}
+
+	if instance == "" {
+		return errors.New("Missing required -instance parameter")
+	}
+
+	url := buildComputeURL("%s/servers/%s/action", *tenantID, instance)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(action))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Instance action failed: %s", resp.Status)
+	}
+
+	return nil
+}
+
 type instanceListCommand struct {
 	Flag     flag.FlagSet
 	workload string
@@ -390,6 +795,7 @@ func (cmd *instanceListCommand) run(args []string) error {
 type instanceShowCommand struct {
 	Flag     flag.FlagSet
 	instance string
+	history  bool
 }
 
 func (cmd *instanceShowCommand) usage(...string) {
@@ -406,6 +812,7 @@ The show flags are:
 
 func (cmd *instanceShowCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.BoolVar(&cmd.history, "history", false, "Print the state transitions of the instance")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -417,6 +824,10 @@ func (cmd *instanceShowCommand) run(args []string) error {
 		cmd.usage()
 	}
 
+	if cmd.history {
+		return showInstanceHistory(cmd.instance)
+	}
+
 	var server payloads.ComputeServer
 	url := buildComputeURL("%s/servers/%s", *tenantID, cmd.instance)
 
@@ -433,6 +844,38 @@ func (cmd *instanceShowCommand) run(args []string) error {
 	return nil
 }
 
+func showInstanceHistory(instance string) error {
+	var history payloads.ComputeServerHistory
+	url := buildComputeURL("%s/servers/%s/history", *tenantID, instance)
+
+	resp, err := sendHTTPRequest("GET", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+	err = unmarshalHTTPResponse(resp, &history)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("History of instance %s\n", history.ID)
+	for _, t := range history.Transitions {
+		from := t.From
+		if from == "" {
+			from = "-"
+		}
+
+		fmt.Printf("\t%s %s -> %s (%s)", t.Timestamp.Format(time.RFC3339), from, t.To, t.Source)
+		if t.NodeID != "" {
+			fmt.Printf(" node %s", t.NodeID)
+		}
+		if t.Reason != "" {
+			fmt.Printf(": %s", t.Reason)
+		}
+		fmt.Printf("\n")
+	}
+	return nil
+}
+
 func dumpInstance(server *payloads.Server) {
 	fmt.Printf("\tUUID: %s\n", server.ID)
 	fmt.Printf("\tStatus: %s\n", server.Status)
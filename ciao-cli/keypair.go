@@ -0,0 +1,208 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/01org/ciao/payloads"
+)
+
+var keyPairCommand = &command{
+	SubCommands: map[string]subCommand{
+		"list":   new(keyPairListCommand),
+		"create": new(keyPairCreateCommand),
+		"delete": new(keyPairDeleteCommand),
+	},
+}
+
+type keyPairListCommand struct {
+	Flag flag.FlagSet
+}
+
+func (cmd *keyPairListCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] keypair list
+
+List the SSH keypairs of a tenant
+`)
+	os.Exit(2)
+}
+
+func (cmd *keyPairListCommand) parseArgs(args []string) []string {
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *keyPairListCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	var keys payloads.ComputeKeyPairs
+
+	url := buildComputeURL("%s/os-keypairs", *tenantID)
+
+	resp, err := sendHTTPRequest("GET", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = unmarshalHTTPResponse(resp, &keys)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	for i, key := range keys.KeyPairs {
+		fmt.Printf("Keypair %d\n", i+1)
+		fmt.Printf("\tName: %s\n", key.KeyPair.Name)
+		fmt.Printf("\tFingerprint: %s\n", key.KeyPair.Fingerprint)
+	}
+	return nil
+}
+
+type keyPairCreateCommand struct {
+	Flag          flag.FlagSet
+	name          string
+	publicKeyFile string
+}
+
+func (cmd *keyPairCreateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] keypair create [flags]
+
+Upload an SSH public key, or generate a new keypair when no public key
+file is given. The private key of a generated keypair is only printed once.
+
+The create flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *keyPairCreateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Keypair name")
+	cmd.Flag.StringVar(&cmd.publicKeyFile, "public-key-file", "", "SSH public key file to upload")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *keyPairCreateCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	if cmd.name == "" {
+		errorf("Missing required -name parameter")
+		cmd.usage()
+	}
+
+	var req payloads.ComputeCreateKeyPair
+	req.KeyPair.Name = cmd.name
+
+	if cmd.publicKeyFile != "" {
+		publicKey, err := ioutil.ReadFile(cmd.publicKeyFile)
+		if err != nil {
+			fatalf("Could not read public key: %v", err)
+		}
+		req.KeyPair.PublicKey = string(publicKey)
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/os-keypairs", *tenantID)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Keypair creation failed: %s", resp.Status)
+	}
+
+	var key payloads.ComputeKeyPair
+	err = unmarshalHTTPResponse(resp, &key)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Created keypair %s: %s\n", key.KeyPair.Name, key.KeyPair.Fingerprint)
+	if key.KeyPair.PrivateKey != "" {
+		fmt.Print(key.KeyPair.PrivateKey)
+	}
+	return nil
+}
+
+type keyPairDeleteCommand struct {
+	Flag flag.FlagSet
+	name string
+}
+
+func (cmd *keyPairDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] keypair delete [flags]
+
+Delete an SSH keypair
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *keyPairDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Keypair name")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *keyPairDeleteCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	if cmd.name == "" {
+		errorf("Missing required -name parameter")
+		cmd.usage()
+	}
+
+	url := buildComputeURL("%s/os-keypairs/%s", *tenantID, cmd.name)
+
+	resp, err := sendHTTPRequest("DELETE", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Keypair deletion failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Deleted keypair %s\n", cmd.name)
+	return nil
+}
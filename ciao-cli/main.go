@@ -71,6 +71,19 @@ Use "ciao-cli `+name[0]+` sub-command -help" for more information about that ite
 	os.Exit(2)
 }
 
+// nestedCommand is a group of related commands within an item
+type nestedCommand struct {
+	command
+	name string
+}
+
+func (c *nestedCommand) run(args []string) error {
+	if len(args) < 1 {
+		c.usage(c.name)
+	}
+	return c.command.run(append([]string{c.name}, args...))
+}
+
 // subCommand is the interface that all cli commands should implement
 type subCommand interface {
 	usage(...string)
@@ -79,14 +92,18 @@ type subCommand interface {
 }
 
 var commands = map[string]subCommand{
-	"instance": instanceCommand,
-	"workload": workloadCommand,
-	"tenant":   tenantCommand,
-	"event":    eventCommand,
-	"node":     nodeCommand,
-	"trace":    traceCommand,
-	"image":    imageCommand,
-	"volume":   volumeCommand,
+	"instance":      instanceCommand,
+	"workload":      workloadCommand,
+	"tenant":        tenantCommand,
+	"event":         eventCommand,
+	"node":          nodeCommand,
+	"trace":         traceCommand,
+	"image":         imageCommand,
+	"volume":        volumeCommand,
+	"floatingip":    floatingIPCommand,
+	"securitygroup": securityGroupCommand,
+	"keypair":       keyPairCommand,
+	"webhook":       webhookCommand,
 }
 
 var scopedToken string
@@ -0,0 +1,445 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/01org/ciao/payloads"
+)
+
+var securityGroupCommand = &command{
+	SubCommands: map[string]subCommand{
+		"list":        new(securityGroupListCommand),
+		"create":      new(securityGroupCreateCommand),
+		"delete":      new(securityGroupDeleteCommand),
+		"add-rule":    new(securityGroupAddRuleCommand),
+		"delete-rule": new(securityGroupDeleteRuleCommand),
+		"add":         new(securityGroupAddCommand),
+		"remove":      new(securityGroupRemoveCommand),
+	},
+}
+
+func dumpSecurityGroup(group payloads.SecurityGroupDetails) {
+	fmt.Printf("\tUUID: %s\n", group.ID)
+	fmt.Printf("\tName: %s\n", group.Name)
+	fmt.Printf("\tDescription: %s\n", group.Description)
+	for _, rule := range group.Rules {
+		fmt.Printf("\tRule %s: %s %d:%d from %s\n", rule.ID, rule.IPProtocol,
+			rule.FromPort, rule.ToPort, rule.IPRange.CIDR)
+	}
+}
+
+type securityGroupListCommand struct {
+	Flag flag.FlagSet
+}
+
+func (cmd *securityGroupListCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] securitygroup list
+
+List the security groups of a tenant
+`)
+	os.Exit(2)
+}
+
+func (cmd *securityGroupListCommand) parseArgs(args []string) []string {
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *securityGroupListCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	var groups payloads.ComputeSecurityGroups
+
+	url := buildComputeURL("%s/os-security-groups", *tenantID)
+
+	resp, err := sendHTTPRequest("GET", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = unmarshalHTTPResponse(resp, &groups)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	for i, group := range groups.SecurityGroups {
+		fmt.Printf("Security group %d\n", i+1)
+		dumpSecurityGroup(group)
+	}
+	return nil
+}
+
+type securityGroupCreateCommand struct {
+	Flag        flag.FlagSet
+	name        string
+	description string
+}
+
+func (cmd *securityGroupCreateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] securitygroup create [flags]
+
+Create a security group
+
+The create flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *securityGroupCreateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Security group name")
+	cmd.Flag.StringVar(&cmd.description, "description", "", "Security group description")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *securityGroupCreateCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	if cmd.name == "" {
+		errorf("Missing required -name parameter")
+		cmd.usage()
+	}
+
+	var req payloads.ComputeCreateSecurityGroup
+	req.SecurityGroup.Name = cmd.name
+	req.SecurityGroup.Description = cmd.description
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/os-security-groups", *tenantID)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Security group creation failed: %s", resp.Status)
+	}
+
+	var group payloads.ComputeSecurityGroup
+	err = unmarshalHTTPResponse(resp, &group)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Created security group %s: %s\n", group.SecurityGroup.Name, group.SecurityGroup.ID)
+	return nil
+}
+
+type securityGroupDeleteCommand struct {
+	Flag  flag.FlagSet
+	group string
+}
+
+func (cmd *securityGroupDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] securitygroup delete [flags]
+
+Delete a security group that has no instances
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *securityGroupDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.group, "group", "", "Security group UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *securityGroupDeleteCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	if cmd.group == "" {
+		errorf("Missing required -group parameter")
+		cmd.usage()
+	}
+
+	url := buildComputeURL("%s/os-security-groups/%s", *tenantID, cmd.group)
+
+	resp, err := sendHTTPRequest("DELETE", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Security group deletion failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Deleted security group: %s\n", cmd.group)
+	return nil
+}
+
+type securityGroupAddRuleCommand struct {
+	Flag     flag.FlagSet
+	group    string
+	protocol string
+	fromPort int
+	toPort   int
+	cidr     string
+}
+
+func (cmd *securityGroupAddRuleCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] securitygroup add-rule [flags]
+
+Add an ingress rule to a security group
+
+The add-rule flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *securityGroupAddRuleCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.group, "group", "", "Security group UUID")
+	cmd.Flag.StringVar(&cmd.protocol, "protocol", "tcp", "Protocol (tcp, udp or icmp)")
+	cmd.Flag.IntVar(&cmd.fromPort, "from-port", 0, "First port of the range, or icmp type (-1 for any)")
+	cmd.Flag.IntVar(&cmd.toPort, "to-port", 0, "Last port of the range (defaults to -from-port)")
+	cmd.Flag.StringVar(&cmd.cidr, "cidr", "0.0.0.0/0", "Source network")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *securityGroupAddRuleCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	if cmd.group == "" {
+		errorf("Missing required -group parameter")
+		cmd.usage()
+	}
+
+	if cmd.toPort == 0 {
+		cmd.toPort = cmd.fromPort
+	}
+
+	var req payloads.ComputeCreateSecurityGroupRule
+	req.SecurityGroupRule.ParentGroupID = cmd.group
+	req.SecurityGroupRule.IPProtocol = cmd.protocol
+	req.SecurityGroupRule.FromPort = cmd.fromPort
+	req.SecurityGroupRule.ToPort = cmd.toPort
+	req.SecurityGroupRule.CIDR = cmd.cidr
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/os-security-group-rules", *tenantID)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Security group rule creation failed: %s", resp.Status)
+	}
+
+	var rule payloads.ComputeSecurityGroupRule
+	err = unmarshalHTTPResponse(resp, &rule)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Created security group rule: %s\n", rule.SecurityGroupRule.ID)
+	return nil
+}
+
+type securityGroupDeleteRuleCommand struct {
+	Flag flag.FlagSet
+	rule string
+}
+
+func (cmd *securityGroupDeleteRuleCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] securitygroup delete-rule [flags]
+
+Delete a security group rule
+
+The delete-rule flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *securityGroupDeleteRuleCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.rule, "rule", "", "Security group rule UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *securityGroupDeleteRuleCommand) run(args []string) error {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	if cmd.rule == "" {
+		errorf("Missing required -rule parameter")
+		cmd.usage()
+	}
+
+	url := buildComputeURL("%s/os-security-group-rules/%s", *tenantID, cmd.rule)
+
+	resp, err := sendHTTPRequest("DELETE", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Security group rule deletion failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Deleted security group rule: %s\n", cmd.rule)
+	return nil
+}
+
+func securityGroupAction(instance string, action payloads.ComputeSecurityGroupAction) {
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	b, err := json.Marshal(action)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("%s/servers/%s/action", *tenantID, instance)
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Security group action failed: %s", resp.Status)
+	}
+}
+
+type securityGroupAddCommand struct {
+	Flag     flag.FlagSet
+	name     string
+	instance string
+}
+
+func (cmd *securityGroupAddCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] securitygroup add [flags]
+
+Add an instance to a security group
+
+The add flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *securityGroupAddCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Security group name")
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *securityGroupAddCommand) run(args []string) error {
+	if cmd.name == "" || cmd.instance == "" {
+		errorf("Missing required -name or -instance parameter")
+		cmd.usage()
+	}
+
+	securityGroupAction(cmd.instance, payloads.ComputeSecurityGroupAction{
+		AddSecurityGroup: &payloads.SecurityGroup{Name: cmd.name},
+	})
+
+	fmt.Printf("Added instance %s to security group %s\n", cmd.instance, cmd.name)
+	return nil
+}
+
+type securityGroupRemoveCommand struct {
+	Flag     flag.FlagSet
+	name     string
+	instance string
+}
+
+func (cmd *securityGroupRemoveCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] securitygroup remove [flags]
+
+Remove an instance from a security group
+
+The remove flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *securityGroupRemoveCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Security group name")
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *securityGroupRemoveCommand) run(args []string) error {
+	if cmd.name == "" || cmd.instance == "" {
+		errorf("Missing required -name or -instance parameter")
+		cmd.usage()
+	}
+
+	securityGroupAction(cmd.instance, payloads.ComputeSecurityGroupAction{
+		RemoveSecurityGroup: &payloads.SecurityGroup{Name: cmd.name},
+	})
+
+	fmt.Printf("Removed instance %s from security group %s\n", cmd.instance, cmd.name)
+	return nil
+}
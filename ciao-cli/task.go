@@ -0,0 +1,68 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+)
+
+// taskPollPeriod is how often -wait polls the task of an operation.
+const taskPollPeriod = time.Second
+
+// waitForTask polls the controller task whose location is returned in the
+// Location header of the response to an asynchronous operation, until the
+// task ends.
+func waitForTask(resp *http.Response) error {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return errors.New("No task to wait for")
+	}
+
+	url := fmt.Sprintf("https://%s:%d%s", *controllerURL, *computePort, location)
+
+	for {
+		var task payloads.ComputeTask
+
+		resp, err := sendHTTPRequest("GET", url, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		err = unmarshalHTTPResponse(resp, &task)
+		if err != nil {
+			return err
+		}
+
+		infof("Task %s %s (%d%%)\n", task.Task.ID, task.Task.Status, task.Task.Progress)
+
+		switch task.Task.Status {
+		case payloads.TaskCompleted:
+			return nil
+		case payloads.TaskFailed:
+			return fmt.Errorf("Task %s failed: %s", task.Task.ID,
+				strings.Join(task.Task.Errors, ", "))
+		}
+
+		time.Sleep(taskPollPeriod)
+	}
+}
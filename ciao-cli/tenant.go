@@ -17,8 +17,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
@@ -27,7 +30,20 @@ import (
 
 var tenantCommand = &command{
 	SubCommands: map[string]subCommand{
-		"list": new(tenantListCommand),
+		"list":   new(tenantListCommand),
+		"delete": new(tenantDeleteCommand),
+		"quota":  tenantQuotaCommand,
+	},
+}
+
+var tenantQuotaCommand = &nestedCommand{
+	name: "tenant quota",
+	command: command{
+		SubCommands: map[string]subCommand{
+			"show":   new(tenantQuotaShowCommand),
+			"set":    new(tenantQuotaSetCommand),
+			"delete": new(tenantQuotaDeleteCommand),
+		},
 	},
 }
 
@@ -122,6 +138,7 @@ func listTenantQuotas() error {
 	fmt.Printf("\tCPUs:      %d | %s\n", resources.VCPUUsage, limitToString(resources.VCPULimit))
 	fmt.Printf("\tMemory:    %d | %s\n", resources.MemUsage, limitToString(resources.MemLimit))
 	fmt.Printf("\tDisk:      %d | %s\n", resources.DiskUsage, limitToString(resources.DiskLimit))
+	fmt.Printf("\tVolumes:   %d | %s\n", resources.VolumeUsage, limitToString(resources.VolumeLimit))
 
 	return nil
 }
@@ -168,3 +185,253 @@ func listTenantResources() error {
 
 	return nil
 }
+
+func quotasURL(defaults bool, tenant string) string {
+	if defaults {
+		return buildComputeURL("quotas/defaults")
+	}
+
+	if tenant == "" {
+		tenant = *tenantID
+	}
+
+	return buildComputeURL("%s/quotas", tenant)
+}
+
+type tenantDeleteCommand struct {
+	Flag   flag.FlagSet
+	tenant string
+	wait   bool
+}
+
+func (cmd *tenantDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant delete [flags]
+
+Delete a tenant along with its instances, volumes and CNCI (Privileged)
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *tenantDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.tenant, "tenant", "", "Tenant UUID")
+	cmd.Flag.BoolVar(&cmd.wait, "wait", false, "Wait for the tenant to be deleted")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantDeleteCommand) run(args []string) error {
+	if cmd.tenant == "" {
+		errorf("Missing required -tenant parameter")
+		cmd.usage()
+	}
+
+	url := buildComputeURL("tenants/%s", cmd.tenant)
+
+	resp, err := sendHTTPRequest("DELETE", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Tenant deletion failed: %s", resp.Status)
+	}
+
+	if cmd.wait {
+		err = waitForTask(resp)
+		if err != nil {
+			fatalf(err.Error())
+		}
+	}
+
+	fmt.Printf("Deleted tenant: %s\n", cmd.tenant)
+	return nil
+}
+
+type tenantQuotaShowCommand struct {
+	Flag     flag.FlagSet
+	defaults bool
+}
+
+func (cmd *tenantQuotaShowCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant quota show [flags]
+
+Show the quotas of a tenant, or the cluster wide default quotas
+
+The show flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *tenantQuotaShowCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.defaults, "defaults", false, "Show the cluster wide default quotas (Privileged)")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantQuotaShowCommand) run(args []string) error {
+	if !cmd.defaults {
+		return listTenantQuotas()
+	}
+
+	var quotaSet payloads.CiaoQuotaSet
+
+	resp, err := sendHTTPRequest("GET", quotasURL(true, ""), nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = unmarshalHTTPResponse(resp, &quotaSet)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	q := quotaSet.Quotas
+	limit := func(l *int) string {
+		if l == nil {
+			return limitToString(-1)
+		}
+		return limitToString(*l)
+	}
+
+	fmt.Printf("Default quotas:\n")
+	fmt.Printf("\tInstances: %s\n", limit(q.Instances))
+	fmt.Printf("\tCPUs:      %s\n", limit(q.VCPUs))
+	fmt.Printf("\tMemory:    %s\n", limit(q.MemMB))
+	fmt.Printf("\tDisk:      %s\n", limit(q.DiskMB))
+	fmt.Printf("\tVolumes:   %s\n", limit(q.Volumes))
+
+	return nil
+}
+
+type tenantQuotaSetCommand struct {
+	Flag     flag.FlagSet
+	tenant   string
+	defaults bool
+	quotas   payloads.CiaoQuotas
+}
+
+func (cmd *tenantQuotaSetCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant quota set [flags]
+
+Set the quotas of a tenant, or the cluster wide default quotas (Privileged)
+
+A quota of -1 means unlimited. Quotas that are not given are left unchanged.
+
+The set flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// quotaFlag sets a quota limit only when the flag is given.
+type quotaFlag struct {
+	limit **int
+}
+
+func (f quotaFlag) String() string {
+	if f.limit == nil || *f.limit == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", **f.limit)
+}
+
+func (f quotaFlag) Set(value string) error {
+	var limit int
+
+	_, err := fmt.Sscanf(value, "%d", &limit)
+	if err != nil {
+		return err
+	}
+
+	*f.limit = &limit
+	return nil
+}
+
+func (cmd *tenantQuotaSetCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.tenant, "tenant", "", "Tenant UUID, defaults to -tenant-id")
+	cmd.Flag.BoolVar(&cmd.defaults, "defaults", false, "Set the cluster wide default quotas")
+	cmd.Flag.Var(quotaFlag{&cmd.quotas.Instances}, "instances", "Maximum number of instances")
+	cmd.Flag.Var(quotaFlag{&cmd.quotas.VCPUs}, "vcpus", "Maximum number of virtual CPUs")
+	cmd.Flag.Var(quotaFlag{&cmd.quotas.MemMB}, "mem-mb", "Maximum memory in MB")
+	cmd.Flag.Var(quotaFlag{&cmd.quotas.DiskMB}, "disk-mb", "Maximum instance disk space in MB")
+	cmd.Flag.Var(quotaFlag{&cmd.quotas.Volumes}, "volumes", "Maximum number of volumes")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantQuotaSetCommand) run(args []string) error {
+	if cmd.Flag.NFlag() == 0 {
+		errorf("Missing quota parameters")
+		cmd.usage()
+	}
+
+	b, err := json.Marshal(payloads.CiaoQuotaSet{Quotas: cmd.quotas})
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	resp, err := sendHTTPRequest("PUT", quotasURL(cmd.defaults, cmd.tenant), nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		fatalf("Quota update failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Quotas updated\n")
+	return nil
+}
+
+type tenantQuotaDeleteCommand struct {
+	Flag     flag.FlagSet
+	tenant   string
+	defaults bool
+}
+
+func (cmd *tenantQuotaDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant quota delete [flags]
+
+Delete the quotas of a tenant, which then gets the cluster wide default
+quotas, or delete the cluster wide default quotas (Privileged)
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *tenantQuotaDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.tenant, "tenant", "", "Tenant UUID, defaults to -tenant-id")
+	cmd.Flag.BoolVar(&cmd.defaults, "defaults", false, "Delete the cluster wide default quotas")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantQuotaDeleteCommand) run(args []string) error {
+	resp, err := sendHTTPRequest("DELETE", quotasURL(cmd.defaults, cmd.tenant), nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		fatalf("Quota deletion failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Quotas deleted\n")
+	return nil
+}
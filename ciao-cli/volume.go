@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/rackspace/gophercloud"
@@ -273,6 +274,7 @@ type volumeAttachCommand struct {
 	instance   string
 	mountpoint string
 	mode       string
+	wait       bool
 }
 
 func (cmd *volumeAttachCommand) usage(...string) {
@@ -291,6 +293,7 @@ func (cmd *volumeAttachCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
 	cmd.Flag.StringVar(&cmd.mountpoint, "mountpoint", "/mnt", "Mount point")
 	cmd.Flag.StringVar(&cmd.mountpoint, "mode", "rw", "Access mode")
+	cmd.Flag.BoolVar(&cmd.wait, "wait", false, "Wait for the volume to be attached")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -320,11 +323,29 @@ func (cmd *volumeAttachCommand) run(args []string) error {
 		InstanceUUID: cmd.instance,
 	}
 
-	err = volumeactions.Attach(client, cmd.volume, options).ExtractErr()
-	if err == nil {
-		fmt.Printf("Attached volume: %s\n", cmd.volume)
+	reqBody, err := options.ToVolumeAttachMap()
+	if err != nil {
+		return err
 	}
-	return err
+
+	// volumeactions.Attach does not return the response headers, and
+	// the Location of the attachment task in particular.
+	resp, err := client.Post(client.ServiceURL("volumes", cmd.volume, "action"), reqBody, nil,
+		&gophercloud.RequestOpts{OkCodes: []int{http.StatusAccepted}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if cmd.wait {
+		err = waitForTask(resp)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Attached volume: %s\n", cmd.volume)
+	return nil
 }
 
 type volumeDetachCommand struct {
@@ -0,0 +1,305 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/01org/ciao/payloads"
+)
+
+var webhookCommand = &command{
+	SubCommands: map[string]subCommand{
+		"list":       new(webhookListCommand),
+		"create":     new(webhookCreateCommand),
+		"delete":     new(webhookDeleteCommand),
+		"deliveries": new(webhookDeliveriesCommand),
+	},
+}
+
+// webhookURL returns the URL of the webhooks of the tenant, or of the
+// administrators if all is set.
+func webhookURL(all bool, format string, args ...interface{}) string {
+	path := fmt.Sprintf(format, args...)
+
+	if all == true {
+		return buildComputeURL("webhooks%s", path)
+	}
+
+	if *tenantID == "" {
+		fatalf("Missing required -tenant-id parameter")
+	}
+
+	return buildComputeURL("%s/webhooks%s", *tenantID, path)
+}
+
+type webhookListCommand struct {
+	Flag flag.FlagSet
+	all  bool
+}
+
+func (cmd *webhookListCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] webhook list [flags]
+
+List the webhooks of a tenant, or of the administrators
+
+The list flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *webhookListCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.all, "all", false, "List the webhooks receiving the events of all tenants")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *webhookListCommand) run(args []string) error {
+	var webhooks payloads.CiaoWebhooks
+
+	url := webhookURL(cmd.all, "")
+
+	resp, err := sendHTTPRequest("GET", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = unmarshalHTTPResponse(resp, &webhooks)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	for i, webhook := range webhooks.Webhooks {
+		fmt.Printf("Webhook %d\n", i+1)
+		fmt.Printf("\tUUID: %s\n", webhook.ID)
+		fmt.Printf("\tURL: %s\n", webhook.URL)
+		if len(webhook.EventTypes) > 0 {
+			fmt.Printf("\tEvent types: %s\n", strings.Join(webhook.EventTypes, ", "))
+		} else {
+			fmt.Printf("\tEvent types: all\n")
+		}
+		fmt.Printf("\tCreated: %v\n", webhook.CreatedAt)
+	}
+	return nil
+}
+
+type webhookCreateCommand struct {
+	Flag       flag.FlagSet
+	all        bool
+	url        string
+	eventTypes string
+	secret     string
+}
+
+func (cmd *webhookCreateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] webhook create [flags]
+
+Register a webhook receiving the events of a tenant, or of all tenants.
+The events are POSTed as JSON, signed with the secret in the
+X-Ciao-Signature header.
+
+The event types are log, instance_state, start_failure, node_connected,
+node_disconnected and trace_report.
+
+The create flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *webhookCreateCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.all, "all", false, "Receive the events of all tenants")
+	cmd.Flag.StringVar(&cmd.url, "url", "", "URL the events are POSTed to")
+	cmd.Flag.StringVar(&cmd.eventTypes, "event-types", "", "Comma separated event types, all if empty")
+	cmd.Flag.StringVar(&cmd.secret, "secret", "", "HMAC secret signing the deliveries")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *webhookCreateCommand) run(args []string) error {
+	if cmd.url == "" {
+		errorf("Missing required -url parameter")
+		cmd.usage()
+	}
+
+	var req payloads.CiaoCreateWebhook
+	req.Webhook.URL = cmd.url
+	req.Webhook.Secret = cmd.secret
+	if cmd.eventTypes != "" {
+		req.Webhook.EventTypes = strings.Split(cmd.eventTypes, ",")
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := webhookURL(cmd.all, "")
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		fatalf("Webhook creation failed: %s", resp.Status)
+	}
+
+	var webhook payloads.CiaoWebhookResponse
+	err = unmarshalHTTPResponse(resp, &webhook)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Created webhook %s\n", webhook.Webhook.ID)
+	return nil
+}
+
+type webhookDeleteCommand struct {
+	Flag    flag.FlagSet
+	all     bool
+	webhook string
+}
+
+func (cmd *webhookDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] webhook delete [flags]
+
+Delete a webhook
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *webhookDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.all, "all", false, "Delete a webhook receiving the events of all tenants")
+	cmd.Flag.StringVar(&cmd.webhook, "webhook", "", "Webhook UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *webhookDeleteCommand) run(args []string) error {
+	if cmd.webhook == "" {
+		errorf("Missing required -webhook parameter")
+		cmd.usage()
+	}
+
+	url := webhookURL(cmd.all, "/%s", cmd.webhook)
+
+	resp, err := sendHTTPRequest("DELETE", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Webhook deletion failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Deleted webhook %s\n", cmd.webhook)
+	return nil
+}
+
+type webhookDeliveriesCommand struct {
+	Flag    flag.FlagSet
+	all     bool
+	webhook string
+	offset  int
+	limit   int
+}
+
+func (cmd *webhookDeliveriesCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] webhook deliveries [flags]
+
+List the delivery attempts of a webhook
+
+The deliveries flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *webhookDeliveriesCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.all, "all", false, "List the deliveries of a webhook receiving the events of all tenants")
+	cmd.Flag.StringVar(&cmd.webhook, "webhook", "", "Webhook UUID")
+	cmd.Flag.IntVar(&cmd.offset, "offset", 0, "Show delivery list starting from delivery <offset>")
+	cmd.Flag.IntVar(&cmd.limit, "limit", 0, "Limit list to <limit> results")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *webhookDeliveriesCommand) run(args []string) error {
+	if cmd.webhook == "" {
+		errorf("Missing required -webhook parameter")
+		cmd.usage()
+	}
+
+	var deliveries payloads.CiaoWebhookDeliveries
+
+	url := webhookURL(cmd.all, "/%s/deliveries", cmd.webhook)
+
+	var values []queryValue
+	if cmd.limit > 0 {
+		values = append(values, queryValue{
+			name:  "limit",
+			value: fmt.Sprintf("%d", cmd.limit),
+		})
+	}
+
+	if cmd.offset > 0 {
+		values = append(values, queryValue{
+			name:  "offset",
+			value: fmt.Sprintf("%d", cmd.offset),
+		})
+	}
+
+	resp, err := sendHTTPRequest("GET", url, values, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	err = unmarshalHTTPResponse(resp, &deliveries)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	for _, d := range deliveries.Deliveries {
+		status := "delivered"
+		if !d.Delivered {
+			status = "failed: " + d.Error
+		}
+
+		fmt.Printf("%v: event %d (%s) attempt %d: %s\n", d.Timestamp, d.EventID,
+			d.EventKind, d.Attempt, status)
+	}
+	return nil
+}
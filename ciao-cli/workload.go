@@ -17,8 +17,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 
 	"github.com/01org/ciao/payloads"
@@ -26,7 +30,9 @@ import (
 
 var workloadCommand = &command{
 	SubCommands: map[string]subCommand{
-		"list": new(workloadListCommand),
+		"list":   new(workloadListCommand),
+		"create": new(workloadCreateCommand),
+		"delete": new(workloadDeleteCommand),
 	},
 }
 
@@ -77,3 +83,170 @@ func (cmd *workloadListCommand) run(args []string) error {
 	}
 	return nil
 }
+
+type workloadCreateCommand struct {
+	Flag        flag.FlagSet
+	description string
+	config      string
+	fwType      string
+	vmType      string
+	imageID     string
+	imageName   string
+	vcpus       int
+	memMB       int
+	diskMB      int
+	storageSize int
+	override    bool
+	recovery    string
+}
+
+func (cmd *workloadCreateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] workload create [flags]
+
+Create a new workload
+
+The create flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *workloadCreateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.description, "description", "", "Workload description")
+	cmd.Flag.StringVar(&cmd.config, "config", "", "Path to the workload cloud-init template")
+	cmd.Flag.StringVar(&cmd.fwType, "fw-type", string(payloads.EFI), "Firmware type (efi or legacy)")
+	cmd.Flag.StringVar(&cmd.vmType, "vm-type", string(payloads.QEMU), "VM type (qemu or docker)")
+	cmd.Flag.StringVar(&cmd.imageID, "image-id", "", "Image UUID")
+	cmd.Flag.StringVar(&cmd.imageName, "image-name", "", "Docker image name")
+	cmd.Flag.IntVar(&cmd.vcpus, "vcpus", 2, "Default number of VCPUs")
+	cmd.Flag.IntVar(&cmd.memMB, "mem-mb", 128, "Default memory in MB")
+	cmd.Flag.IntVar(&cmd.diskMB, "disk-mb", 80, "Default disk size in MB")
+	cmd.Flag.BoolVar(&cmd.override, "image-override", false, "Allow instances to be started from another image")
+	cmd.Flag.StringVar(&cmd.recovery, "recovery", "", "Recovery policy of the instances when their node disconnects (none, restart-on-reconnect or reschedule-elsewhere)")
+	cmd.Flag.IntVar(&cmd.storageSize, "storage-size", 0, "Size in GB of the volume created from the image (0 for the image size)")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *workloadCreateCommand) run(args []string) error {
+	if cmd.description == "" {
+		errorf("Missing required -description parameter")
+		cmd.usage()
+	}
+
+	if cmd.config == "" {
+		errorf("Missing required -config parameter")
+		cmd.usage()
+	}
+
+	config, err := ioutil.ReadFile(cmd.config)
+	if err != nil {
+		fatalf("Could not read workload config %s: %s", cmd.config, err)
+	}
+
+	fwType := payloads.Firmware(cmd.fwType)
+	if payloads.Hypervisor(cmd.vmType) == payloads.Docker {
+		fwType = ""
+	}
+
+	var request payloads.CiaoWorkloadRequest
+	request.Workload = payloads.CiaoWorkload{
+		Description: cmd.description,
+		FWType:      fwType,
+		VMType:      payloads.Hypervisor(cmd.vmType),
+		ImageID:     cmd.imageID,
+		ImageName:   cmd.imageName,
+		Config:      string(config),
+
+		ImageOverride:  cmd.override,
+		RecoveryPolicy: cmd.recovery,
+		Defaults: []payloads.CiaoWorkloadResource{
+			{Type: payloads.VCPUs, Value: cmd.vcpus, Mandatory: true},
+			{Type: payloads.MemMB, Value: cmd.memMB, Mandatory: true},
+			{Type: payloads.DiskMB, Value: cmd.diskMB, Mandatory: true},
+		},
+	}
+
+	if cmd.storageSize > 0 {
+		request.Workload.Storage = &payloads.CiaoWorkloadStorage{
+			Bootable:   true,
+			Persistent: true,
+			Size:       cmd.storageSize,
+			SourceType: "image",
+		}
+	}
+
+	b, err := json.Marshal(request)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url := buildComputeURL("flavors")
+
+	resp, err := sendHTTPRequest("POST", url, nil, bytes.NewReader(b))
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		fatalf("Workload creation failed: %s", resp.Status)
+	}
+
+	var result payloads.CiaoWorkloadRequest
+	err = unmarshalHTTPResponse(resp, &result)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Created new workload: %s\n", result.Workload.ID)
+	return nil
+}
+
+type workloadDeleteCommand struct {
+	Flag     flag.FlagSet
+	workload string
+}
+
+func (cmd *workloadDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] workload delete [flags]
+
+Deletes a workload
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *workloadDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.workload, "workload", "", "Workload UUID")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *workloadDeleteCommand) run(args []string) error {
+	if cmd.workload == "" {
+		errorf("Missing required -workload parameter")
+		cmd.usage()
+	}
+
+	url := buildComputeURL("flavors/%s", cmd.workload)
+
+	resp, err := sendHTTPRequest("DELETE", url, nil, nil)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Workload deletion failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Deleted workload: %s\n", cmd.workload)
+	return nil
+}
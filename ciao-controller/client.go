@@ -17,18 +17,43 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/ssntp/uuid"
 	"github.com/golang/glog"
 	"gopkg.in/yaml.v2"
 )
 
+// consoleLogTimeout is how long we wait for a launcher to return the
+// console log of an instance.
+const consoleLogTimeout = 30 * time.Second
+
+var errConsoleLogTimeout = errors.New("Timed out waiting for console log")
+
+// consoleDialTimeout is how long we wait for the connection to the console
+// server of a launcher.
+const consoleDialTimeout = 10 * time.Second
+
 type ssntpClient struct {
 	context *controller
 	ssntp   ssntp.Client
+	config  *ssntp.Config
 	name    string
+
+	// consoleLogs holds the channels of the GetConsoleLog requests
+	// waiting for a ConsoleLog event, indexed by request ID.
+	consoleLogLock sync.Mutex
+	consoleLogs    map[string]chan payloads.ConsoleLogEvent
 }
 
 func (client *ssntpClient) ConnectNotify() {
@@ -41,6 +66,7 @@ func (client *ssntpClient) DisconnectNotify() {
 
 func (client *ssntpClient) StatusNotify(status ssntp.Status, frame *ssntp.Frame) {
 	glog.Info("STATUS for ", client.name)
+	ssntpFrames.Inc("status", status.String())
 }
 
 func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {
@@ -48,6 +74,7 @@ func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Fra
 	payload := frame.Payload
 
 	glog.Info("COMMAND ", command, " for ", client.name)
+	ssntpFrames.Inc("command", command.String())
 
 	if command == ssntp.STATS {
 		stats.Init()
@@ -57,6 +84,8 @@ func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Fra
 			return
 		}
 		client.context.ds.HandleStats(stats)
+		client.context.nodeStats(stats)
+		client.context.taskStats(stats)
 	}
 	glog.V(1).Info(string(payload))
 }
@@ -65,6 +94,7 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 	payload := frame.Payload
 
 	glog.Info("EVENT ", event, " for ", client.name)
+	ssntpFrames.Inc("event", event.String())
 	switch event {
 	case ssntp.InstanceDeleted:
 		var event payloads.EventInstanceDeleted
@@ -73,7 +103,12 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 			glog.Warning("Error unmarshalling InstanceDeleted")
 			return
 		}
-		client.context.ds.DeleteInstance(event.InstanceDeleted.InstanceUUID)
+		instanceID := event.InstanceDeleted.InstanceUUID
+		client.context.ds.DeleteInstance(instanceID)
+		client.context.tasks.received(taskDeleteInstance, ssntp.InstanceDeleted.String(), instanceID, nil)
+		client.context.tasks.received(taskDeleteTenant, ssntp.InstanceDeleted.String(), instanceID, nil)
+		client.context.tasks.received(taskStartInstances, ssntp.InstanceDeleted.String(), instanceID,
+			errors.New("Instance deleted"))
 	case ssntp.ConcentratorInstanceAdded:
 		var event payloads.EventConcentratorInstanceAdded
 		err := yaml.Unmarshal(payload, &event)
@@ -83,6 +118,7 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 		}
 		newCNCI := event.CNCIAdded
 		client.context.ds.AddCNCIIP(newCNCI.ConcentratorMAC, newCNCI.ConcentratorIP)
+		client.context.restoreSecurityRules(newCNCI.ConcentratorMAC)
 	case ssntp.TraceReport:
 		var trace payloads.Trace
 		err := yaml.Unmarshal(payload, &trace)
@@ -100,6 +136,8 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 			return
 		}
 		glog.Infof("Node %s connected", nodeConnected.Connected.NodeUUID)
+		client.context.ds.NodeConnected(nodeConnected.Connected.NodeUUID)
+		client.context.nodeConnected(nodeConnected.Connected.NodeUUID)
 
 	case ssntp.NodeDisconnected:
 		var nodeDisconnected payloads.NodeDisconnected
@@ -110,7 +148,81 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 		}
 
 		glog.Infof("Node %s disconnected", nodeDisconnected.Disconnected.NodeUUID)
-		client.context.ds.DeleteNode(nodeDisconnected.Disconnected.NodeUUID)
+		client.context.nodeDisconnected(nodeDisconnected.Disconnected.NodeUUID)
+
+	case ssntp.NodeEvacuation:
+		var nodeEvacuation payloads.NodeEvacuation
+		err := yaml.Unmarshal(payload, &nodeEvacuation)
+		if err != nil {
+			glog.Warning("error unmarshalling NodeEvacuation")
+			return
+		}
+
+		evacuation := nodeEvacuation.Evacuation
+		glog.Infof("Node %s evacuation %s, next state %s",
+			evacuation.NodeUUID, evacuation.Status, evacuation.NextState)
+		client.context.ds.NodeEvacuation(evacuation)
+		client.context.taskEvacuation(evacuation)
+
+	case ssntp.PublicIPAssigned:
+		var event payloads.EventPublicIPAssigned
+		err := yaml.Unmarshal(payload, &event)
+		if err != nil {
+			glog.Warning("error unmarshalling PublicIPAssigned")
+			return
+		}
+
+		assigned := event.AssignedIP
+		glog.Infof("Public IP %s assigned to instance %s", assigned.PublicIP, assigned.InstanceUUID)
+		err = client.context.ds.PublicIPAssigned(assigned)
+		if err != nil {
+			glog.Warningf("Unable to record public IP %s: %v", assigned.PublicIP, err)
+		}
+
+	case ssntp.ConsoleLog:
+		var event payloads.EventConsoleLog
+		err := yaml.Unmarshal(payload, &event)
+		if err != nil {
+			glog.Warning("error unmarshalling ConsoleLog")
+			return
+		}
+
+		client.consoleLogReceived(event.ConsoleLog)
+
+	case ssntp.ImageCreated:
+		var event payloads.EventImageCreated
+		err := yaml.Unmarshal(payload, &event)
+		if err != nil {
+			glog.Warning("error unmarshalling ImageCreated")
+			return
+		}
+
+		client.context.imageCreated(event.ImageCreated)
+
+	case ssntp.InstanceMigrated:
+		var event payloads.EventInstanceMigrated
+		err := yaml.Unmarshal(payload, &event)
+		if err != nil {
+			glog.Warning("error unmarshalling InstanceMigrated")
+			return
+		}
+
+		migrated := event.InstanceMigrated
+		err = client.context.ds.InstanceMigrated(migrated)
+		if err != nil {
+			glog.Warningf("Unable to record migration of instance %s: %v",
+				migrated.InstanceUUID, err)
+		}
+
+	case ssntp.ControllerRole:
+		var event payloads.ControllerRole
+		err := yaml.Unmarshal(payload, &event)
+		if err != nil {
+			glog.Warning("error unmarshalling ControllerRole")
+			return
+		}
+
+		glog.Warningf("Controller is now %s", event.ControllerRole.Role)
 
 	}
 	glog.V(1).Info(string(payload))
@@ -120,6 +232,7 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 	payload := frame.Payload
 
 	glog.Info("ERROR (", err, ") for ", client.name)
+	ssntpFrames.Inc("error", err.String())
 	switch err {
 	case ssntp.StartFailure:
 		var failure payloads.ErrorStartFailure
@@ -129,6 +242,8 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 			return
 		}
 		client.context.ds.StartFailure(failure.InstanceUUID, failure.Reason)
+		client.context.tasks.received(taskStartInstances, ssntp.StartFailure.String(), failure.InstanceUUID,
+			errors.New(failure.Reason.String()))
 	case ssntp.StopFailure:
 		var failure payloads.ErrorStopFailure
 		err := yaml.Unmarshal(payload, &failure)
@@ -153,6 +268,8 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 			return
 		}
 		client.context.ds.AttachVolumeFailure(failure.InstanceUUID, failure.VolumeUUID, failure.Reason)
+		client.context.tasks.received(taskAttachVolume, ssntp.AttachVolumeFailure.String(), failure.VolumeUUID,
+			errors.New(failure.Reason.String()))
 
 	case ssntp.DetachVolumeFailure:
 		var failure payloads.ErrorDetachVolumeFailure
@@ -163,12 +280,30 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 		}
 		client.context.ds.DetachVolumeFailure(failure.InstanceUUID, failure.VolumeUUID, failure.Reason)
 
+	case ssntp.DeleteFailure:
+		var failure payloads.ErrorDeleteFailure
+		err := yaml.Unmarshal(payload, &failure)
+		if err != nil {
+			glog.Warning("Error unmarshalling DeleteFailure")
+			return
+		}
+		glog.Warningf("Unable to delete instance %s: %s", failure.InstanceUUID, failure.Reason)
+		client.context.tasks.received(taskDeleteInstance, ssntp.DeleteFailure.String(),
+			failure.InstanceUUID, errors.New(failure.Reason.String()))
+		client.context.tasks.received(taskDeleteTenant, ssntp.DeleteFailure.String(),
+			failure.InstanceUUID, errors.New(failure.Reason.String()))
+
 	}
 	glog.V(1).Info(string(payload))
 }
 
 func newSSNTPClient(context *controller, config *ssntp.Config) (*ssntpClient, error) {
-	client := &ssntpClient{name: "ciao Controller", context: context}
+	client := &ssntpClient{
+		name:        "ciao Controller",
+		context:     context,
+		config:      config,
+		consoleLogs: make(map[string]chan payloads.ConsoleLogEvent),
+	}
 
 	err := client.ssntp.Dial(config, client)
 	return client, err
@@ -198,6 +333,26 @@ func (client *ssntpClient) StartWorkload(config string) error {
 	return err
 }
 
+func (client *ssntpClient) StartWorkloadBatch(configs []string, gang bool) error {
+	glog.V(1).Infof("StartBatch of %d instances, gang %v", len(configs), gang)
+
+	batch := payloads.StartBatch{
+		Batch: payloads.StartBatchCmd{
+			Gang:   gang,
+			Starts: configs,
+		},
+	}
+
+	y, err := yaml.Marshal(&batch)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.ssntp.SendCommand(ssntp.StartBatch, y)
+
+	return err
+}
+
 func (client *ssntpClient) DeleteInstance(instanceID string, nodeID string) error {
 	stopCmd := payloads.StopCmd{
 		InstanceUUID:      instanceID,
@@ -267,9 +422,225 @@ func (client *ssntpClient) RestartInstance(instanceID string, nodeID string) err
 	return err
 }
 
-func (client *ssntpClient) EvacuateNode(nodeID string) error {
+// ResizeInstance sends a RESTART command carrying the new resources of a
+// resized instance.
+func (client *ssntpClient) ResizeInstance(tenantID string, instanceID string, nodeID string,
+	resources []payloads.RequestedResource) error {
+	restartCmd := payloads.RestartCmd{
+		TenantUUID:         tenantID,
+		InstanceUUID:       instanceID,
+		WorkloadAgentUUID:  nodeID,
+		RequestedResources: resources,
+		Resize:             true,
+	}
+
+	payload := payloads.Restart{
+		Restart: restartCmd,
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("RESTART resized instance: ", instanceID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.RESTART, y)
+
+	return err
+}
+
+// MigrateInstance sends a MIGRATE command for a running instance.  The
+// scheduler picks the target node when nodeID is empty.
+func (client *ssntpClient) MigrateInstance(instanceID string, nodeID string, targetID string,
+	resources []payloads.RequestedResource) error {
+	payload := payloads.Migrate{
+		Migrate: payloads.MigrateCmd{
+			InstanceUUID:       instanceID,
+			WorkloadAgentUUID:  nodeID,
+			TargetAgentUUID:    targetID,
+			RequestedResources: resources,
+		},
+	}
+
+	return client.sendInstanceCommand(ssntp.MIGRATE, instanceID, payload)
+}
+
+func (client *ssntpClient) RebootInstance(instanceID string, nodeID string, hard bool) error {
+	rebootCmd := payloads.RebootCmd{
+		InstanceUUID:      instanceID,
+		WorkloadAgentUUID: nodeID,
+		Type:              payloads.RebootSoft,
+	}
+	if hard {
+		rebootCmd.Type = payloads.RebootHard
+	}
+
+	payload := payloads.Reboot{
+		Reboot: rebootCmd,
+	}
+
+	return client.sendInstanceCommand(ssntp.REBOOT, instanceID, payload)
+}
+
+func (client *ssntpClient) PauseInstance(instanceID string, nodeID string) error {
+	payload := payloads.Pause{
+		Pause: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ssntp.PAUSE, instanceID, payload)
+}
+
+func (client *ssntpClient) UnpauseInstance(instanceID string, nodeID string) error {
+	payload := payloads.Unpause{
+		Unpause: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ssntp.UNPAUSE, instanceID, payload)
+}
+
+func (client *ssntpClient) SuspendInstance(instanceID string, nodeID string) error {
+	payload := payloads.Suspend{
+		Suspend: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ssntp.SUSPEND, instanceID, payload)
+}
+
+func (client *ssntpClient) ResumeInstance(instanceID string, nodeID string) error {
+	payload := payloads.Resume{
+		Resume: payloads.StopCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	return client.sendInstanceCommand(ssntp.RESUME, instanceID, payload)
+}
+
+// GetConsoleLog asks the node running an instance for the last lines of
+// its console log and waits for the reply.
+func (client *ssntpClient) GetConsoleLog(instanceID string, nodeID string, lines int) (string, error) {
+	requestID := uuid.Generate().String()
+	ch := make(chan payloads.ConsoleLogEvent, 1)
+
+	client.consoleLogLock.Lock()
+	client.consoleLogs[requestID] = ch
+	client.consoleLogLock.Unlock()
+
+	defer func() {
+		client.consoleLogLock.Lock()
+		delete(client.consoleLogs, requestID)
+		client.consoleLogLock.Unlock()
+	}()
+
+	payload := payloads.GetConsoleLog{
+		GetConsoleLog: payloads.ConsoleLogCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			RequestID:         requestID,
+			Lines:             lines,
+		},
+	}
+
+	err := client.sendInstanceCommand(ssntp.GetConsoleLog, instanceID, payload)
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case event := <-ch:
+		if event.Error != "" {
+			return "", errors.New(event.Error)
+		}
+		return event.Output, nil
+	case <-time.After(consoleLogTimeout):
+		return "", errConsoleLogTimeout
+	}
+}
+
+// AttachConsole opens a direct TLS connection to the console server of the
+// launcher running an instance, and attaches it to the serial console of
+// the instance.
+func (client *ssntpClient) AttachConsole(instanceID string, nodeIP string) (net.Conn, error) {
+	tlsConfig, err := client.config.PeerTLSConfig(false, ssntp.AGENT)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: consoleDialTimeout}
+	addr := net.JoinHostPort(nodeIP, strconv.Itoa(launcherConsolePort))
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fmt.Fprintf(conn, "%s\n", instanceID)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	glog.Infof("Console of instance %s attached through %s", instanceID, addr)
+
+	return conn, nil
+}
+
+// CreateImage asks the node running an instance to snapshot its rootfs
+// into a new image.  Completion is reported by an ImageCreated event.
+func (client *ssntpClient) CreateImage(instanceID string, nodeID string, imageID string) error {
+	payload := payloads.CreateImage{
+		CreateImage: payloads.CreateImageCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			ImageUUID:         imageID,
+		},
+	}
+
+	return client.sendInstanceCommand(ssntp.CreateImage, instanceID, payload)
+}
+
+func (client *ssntpClient) consoleLogReceived(event payloads.ConsoleLogEvent) {
+	client.consoleLogLock.Lock()
+	ch, ok := client.consoleLogs[event.RequestID]
+	client.consoleLogLock.Unlock()
+
+	if !ok {
+		glog.Warningf("Unexpected console log for instance %s", event.InstanceUUID)
+		return
+	}
+
+	ch <- event
+}
+
+func (client *ssntpClient) sendInstanceCommand(cmd ssntp.Command, instanceID string, payload interface{}) error {
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info(cmd, " instance: ", instanceID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(cmd, y)
+
+	return err
+}
+
+func (client *ssntpClient) EvacuateNode(nodeID string, nextState payloads.NodeNextState) error {
 	evacuateCmd := payloads.EvacuateCmd{
 		WorkloadAgentUUID: nodeID,
+		NextState:         nextState,
 	}
 
 	payload := payloads.Evacuate{
@@ -289,6 +660,26 @@ func (client *ssntpClient) EvacuateNode(nodeID string) error {
 	return err
 }
 
+func (client *ssntpClient) RestoreNode(nodeID string) error {
+	payload := payloads.Restore{
+		Restore: payloads.RestoreCmd{
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("RESTORE node: ", nodeID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.RESTORE, y)
+
+	return err
+}
+
 func (client *ssntpClient) attachVolume(volID string, instanceID string, nodeID string) error {
 	payload := payloads.AttachVolume{
 		Attach: payloads.VolumeCmd{
@@ -333,6 +724,151 @@ func (client *ssntpClient) detachVolume(volID string, instanceID string, nodeID
 	return err
 }
 
+func publicIPCommand(tenant *types.Tenant, instance *types.Instance, publicIP string) payloads.PublicIPCommand {
+	return payloads.PublicIPCommand{
+		ConcentratorUUID: tenant.CNCIID,
+		TenantUUID:       tenant.ID,
+		InstanceUUID:     instance.ID,
+		PublicIP:         publicIP,
+		PrivateIP:        instance.IPAddress,
+		VnicMAC:          instance.MACAddress,
+	}
+}
+
+func (client *ssntpClient) AssignPublicIP(tenant *types.Tenant, instance *types.Instance, publicIP string) error {
+	payload := payloads.CommandAssignPublicIP{
+		AssignIP: publicIPCommand(tenant, instance, publicIP),
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("AssignPublicIP public_ip: ", publicIP, " instance_id: ", instance.ID, " cnci_id: ", tenant.CNCIID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.AssignPublicIP, y)
+
+	return err
+}
+
+func (client *ssntpClient) ReleasePublicIP(tenant *types.Tenant, instance *types.Instance, publicIP string) error {
+	payload := payloads.CommandReleasePublicIP{
+		ReleaseIP: publicIPCommand(tenant, instance, publicIP),
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("ReleasePublicIP public_ip: ", publicIP, " instance_id: ", instance.ID, " cnci_id: ", tenant.CNCIID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.ReleasePublicIP, y)
+
+	return err
+}
+
+// TenantRemoved asks the tenant CNCI to remove its tunnel to a subnet of
+// the tenant on a node.
+func (client *ssntpClient) TenantRemoved(tenant *types.Tenant, nodeID string, nodeIP string, subnet *net.IPNet) error {
+	payload := payloads.EventTenantRemoved{
+		TenantRemoved: payloads.TenantAddedEvent{
+			AgentUUID:        nodeID,
+			AgentIP:          nodeIP,
+			TenantUUID:       tenant.ID,
+			TenantSubnet:     subnet.String(),
+			ConcentratorUUID: tenant.CNCIID,
+			ConcentratorIP:   tenant.CNCIIP,
+			SubnetKey:        int(binary.LittleEndian.Uint32(subnet.IP.To4())),
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("TenantRemoved subnet: ", subnet, " node_id: ", nodeID, " cnci_id: ", tenant.CNCIID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendEvent(ssntp.TenantRemoved, y)
+
+	return err
+}
+
+// SecurityRules contains the security rules of a tenant instance, as
+// computed from all the security groups it belongs to.
+type SecurityRules struct {
+	Instance *types.Instance
+	Rules    []types.SecurityGroupRule
+}
+
+func securityRulesCommand(tenant *types.Tenant, instances []SecurityRules) payloads.SecurityRulesCommand {
+	cmd := payloads.SecurityRulesCommand{
+		ConcentratorUUID: tenant.CNCIID,
+		TenantUUID:       tenant.ID,
+	}
+
+	for _, i := range instances {
+		rules := payloads.InstanceSecurityRules{
+			InstanceUUID: i.Instance.ID,
+			PrivateIP:    i.Instance.IPAddress,
+		}
+
+		for _, r := range i.Rules {
+			rules.Rules = append(rules.Rules, payloads.SecurityRule{
+				Protocol: r.Protocol,
+				FromPort: r.FromPort,
+				ToPort:   r.ToPort,
+				CIDR:     r.CIDR,
+			})
+		}
+
+		cmd.Instances = append(cmd.Instances, rules)
+	}
+
+	return cmd
+}
+
+func (client *ssntpClient) ApplySecurityRules(tenant *types.Tenant, instances []SecurityRules) error {
+	payload := payloads.CommandApplySecurityRules{
+		Apply: securityRulesCommand(tenant, instances),
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("ApplySecurityRules instances: ", len(instances), " cnci_id: ", tenant.CNCIID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.ApplySecurityRules, y)
+
+	return err
+}
+
+func (client *ssntpClient) ClearSecurityRules(tenant *types.Tenant, instances []SecurityRules) error {
+	payload := payloads.CommandClearSecurityRules{
+		Clear: securityRulesCommand(tenant, instances),
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("ClearSecurityRules instances: ", len(instances), " cnci_id: ", tenant.CNCIID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.ClearSecurityRules, y)
+
+	return err
+}
+
 func (client *ssntpClient) Disconnect() {
 	client.ssntp.Close()
 }
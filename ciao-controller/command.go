@@ -21,14 +21,26 @@ import (
 	"fmt"
 	"time"
 
+	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
 )
 
-func (c *controller) evacuateNode(nodeID string) error {
+func (c *controller) evacuateNode(nodeID string, nextState payloads.NodeNextState) error {
 	// should I bother to see if nodeID is valid?
-	go c.client.EvacuateNode(nodeID)
+	task := c.tasks.add(taskEvacuateNode, "", []string{nodeID})
+	c.tasks.sent(task, ssntp.EVACUATE.String(), nodeID)
+
+	go c.client.EvacuateNode(nodeID, nextState)
+	return nil
+}
+
+// restoreNode puts a node left in maintenance mode by an evacuation
+// back in service.
+func (c *controller) restoreNode(nodeID string) error {
+	go c.client.RestoreNode(nodeID)
 	return nil
 }
 
@@ -52,6 +64,108 @@ func (c *controller) restartInstance(instanceID string) error {
 	return nil
 }
 
+var (
+	errUnknownFlavor = errors.New("Unknown flavor")
+	errResizeVM      = errors.New("Only VMs can be resized")
+	errResizeDisk    = errors.New("The disk of an instance cannot shrink")
+	errOverLimits    = errors.New("Over Tenant Limits")
+)
+
+// resizeInstance assigns the resources of another workload to a stopped
+// instance and restarts it.  Only the resources the instance gains are
+// checked against the limits of its tenant.  The scheduler checks that the
+// node of the instance can still host it.  The instance is moved back to its
+// previous workload if it cannot be restarted with its new resources.
+func (c *controller) resizeInstance(instanceID string, workloadID string) error {
+	i, err := c.getActionInstance(instanceID, payloads.Exited)
+	if err != nil {
+		return err
+	}
+
+	wl, err := c.ds.GetWorkload(workloadID)
+	if err != nil {
+		return errUnknownFlavor
+	}
+
+	current, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return err
+	}
+
+	if wl.VMType != payloads.QEMU || current.VMType != payloads.QEMU || isCNCIWorkload(wl) {
+		return errResizeVM
+	}
+
+	usage := make(map[string]int)
+	for _, r := range wl.Defaults {
+		usage[string(r.Type)] = r.Value
+	}
+
+	if usage[string(payloads.DiskMB)] < i.Usage[string(payloads.DiskMB)] {
+		return errResizeDisk
+	}
+
+	tenant, err := c.ds.GetTenant(i.TenantID)
+	if err != nil {
+		return err
+	}
+
+	if tenant != nil {
+		for _, res := range tenant.Resources {
+			delta := usage[res.Rname] - i.Usage[res.Rname]
+			if delta > 0 && res.OverLimit(delta) {
+				return errOverLimits
+			}
+		}
+	}
+
+	err = c.ds.ResizeInstance(instanceID, workloadID, usage)
+	if err != nil {
+		return err
+	}
+
+	go c.client.ResizeInstance(i.TenantID, instanceID, i.NodeID, wl.Defaults)
+	return nil
+}
+
+var (
+	errMigrateVM     = errors.New("Only VMs can be migrated")
+	errMigrateNode   = errors.New("Instance already runs on the target node")
+	errMigrateTarget = errors.New("Unknown target node")
+)
+
+// migrateInstance live migrates a running VM to another node.  The
+// scheduler picks the target node unless one is given.
+func (c *controller) migrateInstance(instanceID string, nodeID string) error {
+	i, err := c.getActionInstance(instanceID, payloads.Running)
+	if err != nil {
+		return err
+	}
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return err
+	}
+
+	if wl.VMType != payloads.QEMU || isCNCIWorkload(wl) {
+		return errMigrateVM
+	}
+
+	if nodeID != "" {
+		if nodeID == i.NodeID {
+			return errMigrateNode
+		}
+
+		_, err = c.ds.GetNode(nodeID)
+		if err != nil {
+			return errMigrateTarget
+		}
+	}
+
+	go c.client.MigrateInstance(instanceID, i.NodeID, nodeID, wl.Defaults)
+	return nil
+}
+
 func (c *controller) stopInstance(instanceID string) error {
 	// get node id.  If there is no node id we can't send a delete
 	i, err := c.ds.GetInstance(instanceID)
@@ -71,6 +185,93 @@ func (c *controller) stopInstance(instanceID string) error {
 	return nil
 }
 
+// errInstanceState is returned when an instance action is not allowed
+// in the current state of the instance.
+var errInstanceState = errors.New("Action not allowed in the current instance state")
+
+// getActionInstance returns the instance an action is performed on, after
+// checking that it is running on a node and in one of the given states.
+func (c *controller) getActionInstance(instanceID string, states ...string) (*types.Instance, error) {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.NodeID == "" {
+		return nil, errors.New("Instance is not assigned to Node")
+	}
+
+	for _, state := range states {
+		if i.State == state {
+			return i, nil
+		}
+	}
+
+	return nil, errInstanceState
+}
+
+func (c *controller) rebootInstance(instanceID string, hard bool) error {
+	i, err := c.getActionInstance(instanceID, payloads.Running, payloads.Paused)
+	if err != nil {
+		return err
+	}
+
+	go c.client.RebootInstance(instanceID, i.NodeID, hard)
+	return nil
+}
+
+func (c *controller) pauseInstance(instanceID string) error {
+	i, err := c.getActionInstance(instanceID, payloads.Running)
+	if err != nil {
+		return err
+	}
+
+	go c.client.PauseInstance(instanceID, i.NodeID)
+	return nil
+}
+
+func (c *controller) unpauseInstance(instanceID string) error {
+	i, err := c.getActionInstance(instanceID, payloads.Paused)
+	if err != nil {
+		return err
+	}
+
+	go c.client.UnpauseInstance(instanceID, i.NodeID)
+	return nil
+}
+
+func (c *controller) suspendInstance(instanceID string) error {
+	i, err := c.getActionInstance(instanceID, payloads.Running)
+	if err != nil {
+		return err
+	}
+
+	go c.client.SuspendInstance(instanceID, i.NodeID)
+	return nil
+}
+
+func (c *controller) resumeInstance(instanceID string) error {
+	i, err := c.getActionInstance(instanceID, payloads.Paused)
+	if err != nil {
+		return err
+	}
+
+	go c.client.ResumeInstance(instanceID, i.NodeID)
+	return nil
+}
+
+// getConsoleOutput returns the last lines of the console log of an
+// instance, or the whole log if lines is not positive.
+func (c *controller) getConsoleOutput(instanceID string, lines int) (string, error) {
+	i, err := c.getActionInstance(instanceID, payloads.Running, payloads.Paused,
+		payloads.Exited)
+	if err != nil {
+		return "", err
+	}
+
+	return c.client.GetConsoleLog(instanceID, i.NodeID, lines)
+}
+
 func (c *controller) deleteInstance(instanceID string) error {
 	// get node id.  If there is no node id we can't send a delete
 	i, err := c.ds.GetInstance(instanceID)
@@ -82,10 +283,268 @@ func (c *controller) deleteInstance(instanceID string) error {
 		return errors.New("Cannot delete instance not assigned to Node")
 	}
 
+	ip, err := c.ds.GetInstanceFloatingIP(instanceID)
+	if err == nil {
+		c.releasePublicIP(i, ip.Address)
+	}
+
+	if len(i.SecurityGroups) > 0 {
+		c.clearSecurityRules(i.TenantID, []*types.Instance{i})
+	}
+
+	task := c.tasks.add(taskDeleteInstance, i.TenantID, []string{instanceID})
+	c.tasks.sent(task, ssntp.DELETE.String(), instanceID)
+
 	go c.client.DeleteInstance(instanceID, i.NodeID)
 	return nil
 }
 
+// releasePublicIP asks the tenant CNCI to stop forwarding traffic
+// from a floating IP to an instance.
+func (c *controller) releasePublicIP(instance *types.Instance, address string) {
+	tenant, err := c.ds.GetTenant(instance.TenantID)
+	if err != nil || tenant == nil || tenant.CNCIID == "" {
+		glog.Warningf("Unable to release public IP %s: no tenant CNCI", address)
+		return
+	}
+
+	go c.client.ReleasePublicIP(tenant, instance, address)
+}
+
+func (c *controller) associateFloatingIP(tenantID string, instanceID string, address string) error {
+	ip, err := c.ds.GetFloatingIPByAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if ip.TenantID != tenantID {
+		return datastore.ErrNoFloatingIP
+	}
+
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.IPAddress == "" {
+		return errors.New("Instance has no private IP address")
+	}
+
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	if tenant == nil || tenant.CNCIID == "" {
+		return errors.New("Tenant CNCI is not available")
+	}
+
+	_, err = c.ds.AssociateFloatingIP(ip.ID, instanceID)
+	if err != nil {
+		return err
+	}
+
+	go c.client.AssignPublicIP(tenant, i, ip.Address)
+	return nil
+}
+
+func (c *controller) disassociateFloatingIP(ID string) error {
+	ip, err := c.ds.DisassociateFloatingIP(ID)
+	if err != nil {
+		return err
+	}
+
+	i, err := c.ds.GetInstance(ip.InstanceID)
+	if err != nil {
+		// the instance is gone along with its public IP mapping
+		return nil
+	}
+
+	c.releasePublicIP(i, ip.Address)
+	return nil
+}
+
+// releaseFloatingIP returns a floating IP to the pool, disassociating
+// it from its instance first if needed.
+func (c *controller) releaseFloatingIP(ID string) error {
+	ip, err := c.ds.GetFloatingIP(ID)
+	if err != nil {
+		return err
+	}
+
+	if ip.InstanceID != "" {
+		err = c.disassociateFloatingIP(ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.ds.ReleaseFloatingIP(ID)
+}
+
+// tenantCNCI returns the tenant of a security rules update, if its CNCI
+// is able to receive it.
+func (c *controller) tenantCNCI(tenantID string) *types.Tenant {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil || tenant == nil || tenant.CNCIID == "" {
+		glog.Warningf("Unable to update security rules of tenant %s: no tenant CNCI", tenantID)
+		return nil
+	}
+
+	return tenant
+}
+
+// updateSecurityRules sends the complete security rules of each instance
+// to the tenant CNCI. Instances that no longer belong to any security
+// group have their rules cleared.
+func (c *controller) updateSecurityRules(tenantID string, instances []*types.Instance) {
+	var apply []SecurityRules
+	var clear []*types.Instance
+
+	for _, i := range instances {
+		if i.IPAddress == "" {
+			continue
+		}
+
+		if len(i.SecurityGroups) == 0 {
+			clear = append(clear, i)
+			continue
+		}
+
+		apply = append(apply, SecurityRules{
+			Instance: i,
+			Rules:    c.ds.GetInstanceSecurityRules(i),
+		})
+	}
+
+	if len(clear) > 0 {
+		c.clearSecurityRules(tenantID, clear)
+	}
+
+	if len(apply) == 0 {
+		return
+	}
+
+	tenant := c.tenantCNCI(tenantID)
+	if tenant == nil {
+		return
+	}
+
+	go c.client.ApplySecurityRules(tenant, apply)
+}
+
+// restoreSecurityRules sends the security rules of all the instances of
+// the tenant owning a CNCI which just connected.  A restarted or
+// relaunched CNCI has lost the rules sent to it before.
+func (c *controller) restoreSecurityRules(cnciMAC string) {
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		glog.Warningf("Unable to restore security rules of CNCI %s: %v", cnciMAC, err)
+		return
+	}
+
+	for _, t := range tenants {
+		if t.CNCIMAC != cnciMAC {
+			continue
+		}
+
+		instances, err := c.ds.GetAllInstancesFromTenant(t.ID)
+		if err != nil {
+			glog.Warningf("Unable to restore security rules of tenant %s: %v", t.ID, err)
+			return
+		}
+
+		var members []*types.Instance
+		for _, i := range instances {
+			if len(i.SecurityGroups) > 0 {
+				members = append(members, i)
+			}
+		}
+
+		if len(members) > 0 {
+			c.updateSecurityRules(t.ID, members)
+		}
+
+		return
+	}
+}
+
+// clearSecurityRules asks the tenant CNCI to forward all traffic to the
+// instances again.
+func (c *controller) clearSecurityRules(tenantID string, instances []*types.Instance) {
+	tenant := c.tenantCNCI(tenantID)
+	if tenant == nil {
+		return
+	}
+
+	clear := make([]SecurityRules, 0, len(instances))
+	for _, i := range instances {
+		clear = append(clear, SecurityRules{Instance: i})
+	}
+
+	go c.client.ClearSecurityRules(tenant, clear)
+}
+
+func (c *controller) addSecurityGroupRule(rule types.SecurityGroupRule) error {
+	g, err := c.ds.GetSecurityGroup(rule.GroupID)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.AddSecurityGroupRule(rule)
+	if err != nil {
+		return err
+	}
+
+	c.updateSecurityRules(g.TenantID, c.ds.GetSecurityGroupInstances(g.ID))
+	return nil
+}
+
+func (c *controller) deleteSecurityGroupRule(ID string) error {
+	rule, err := c.ds.DeleteSecurityGroupRule(ID)
+	if err != nil {
+		return err
+	}
+
+	g, err := c.ds.GetSecurityGroup(rule.GroupID)
+	if err != nil {
+		return err
+	}
+
+	c.updateSecurityRules(g.TenantID, c.ds.GetSecurityGroupInstances(g.ID))
+	return nil
+}
+
+func (c *controller) addInstanceSecurityGroup(instanceID string, groupID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.AddInstanceSecurityGroup(instanceID, groupID)
+	if err != nil {
+		return err
+	}
+
+	c.updateSecurityRules(i.TenantID, []*types.Instance{i})
+	return nil
+}
+
+func (c *controller) removeInstanceSecurityGroup(instanceID string, groupID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.RemoveInstanceSecurityGroup(instanceID, groupID)
+	if err != nil {
+		return err
+	}
+
+	c.updateSecurityRules(i.TenantID, []*types.Instance{i})
+	return nil
+}
+
 func (c *controller) confirmTenant(tenantID string) error {
 	tenant, err := c.ds.GetTenant(tenantID)
 	if err != nil {
@@ -122,33 +581,100 @@ func (c *controller) confirmTenant(tenantID string) error {
 	return nil
 }
 
-func (c *controller) startWorkload(workloadID string, tenantID string, instances int, trace bool, label string) ([]*types.Instance, error) {
+// Send the START commands for new instances, tracked by a single task.
+// Several instances are sent as a single StartBatch command, for the
+// scheduler to place them in one pass.  Traced instances have already
+// been sent one START command each.
+func (c *controller) sendStarts(tenantID string, instances []*types.Instance, configs []string, gang bool) {
+	if len(instances) == 0 {
+		return
+	}
+
+	var ids []string
+	for _, i := range instances {
+		ids = append(ids, i.ID)
+	}
+
+	task := c.tasks.add(taskStartInstances, tenantID, ids)
+	if len(configs) > 1 {
+		c.tasks.sent(task, ssntp.StartBatch.String(), "")
+	} else {
+		for _, id := range ids {
+			c.tasks.sent(task, ssntp.START.String(), id)
+		}
+	}
+
+	switch len(configs) {
+	case 0:
+	case 1:
+		go c.client.StartWorkload(configs[0])
+	default:
+		go c.client.StartWorkloadBatch(configs, gang)
+	}
+}
+
+var errTracedGang = errors.New("Traced instances cannot be started as a gang")
+
+// startWorkload creates and starts instances of a workload.  With gang set,
+// the scheduler starts either all of the instances or none of them.
+func (c *controller) startWorkload(workloadID string, tenantID string, instances int, trace bool, label string, gang bool, opts *instanceOptions) ([]*types.Instance, error) {
 	var e error
+	var configs []string
 
 	if instances <= 0 {
 		return nil, errors.New("Missing number of instances to start")
 	}
 
+	// traced instances are started one by one
+	if gang && trace {
+		return nil, errTracedGang
+	}
+
 	wl, err := c.ds.GetWorkload(workloadID)
 	if err != nil {
 		return nil, err
 	}
 
+	if opts == nil {
+		opts = &instanceOptions{}
+	}
+
+	wl, err = c.customizeWorkload(wl, tenantID, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	if !isCNCIWorkload(wl) {
-		err := c.confirmTenant(tenantID)
+		err := c.deletions.create(tenantID)
+		if err != nil {
+			return nil, err
+		}
+		defer c.deletions.created(tenantID)
+
+		err = c.confirmTenant(tenantID)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	var newInstances []*types.Instance
+	overLimits := false
 
 	for i := 0; i < instances; i++ {
 		startTime := time.Now()
-		instance, err := newInstance(c, tenantID, wl)
+
+		instanceOpts := *opts
+		if instances > 1 && opts.name != "" {
+			instanceOpts.name = fmt.Sprintf("%s-%d", opts.name, i+1)
+		}
+
+		instance, err := newInstance(c, tenantID, wl, &instanceOpts)
 		if err != nil {
 			glog.V(2).Info("error newInstance")
 			e = err
+			if gang {
+				break
+			}
 			continue
 		}
 		instance.startTime = startTime
@@ -160,12 +686,15 @@ func (c *controller) startWorkload(workloadID string, tenantID string, instances
 				glog.V(2).Info("error adding instance")
 				instance.Clean()
 				e = err
+				if gang {
+					break
+				}
 				continue
 			}
 
 			newInstances = append(newInstances, &instance.Instance)
 			if trace == false {
-				go c.client.StartWorkload(instance.newConfig.config)
+				configs = append(configs, instance.newConfig.config)
 			} else {
 				go c.client.StartTracedWorkload(instance.newConfig.config, instance.startTime, label)
 			}
@@ -173,17 +702,172 @@ func (c *controller) startWorkload(workloadID string, tenantID string, instances
 			instance.Clean()
 			if err != nil {
 				e = err
+				if gang {
+					break
+				}
 				continue
 			} else {
 				// stop if we are over limits
-				return nil, errors.New("Over Tenant Limits")
+				e = errOverLimits
+				overLimits = true
+				break
 			}
 		}
 	}
 
+	// the instances of a gang are started together or not at all
+	if gang && e != nil {
+		c.cancelStarts(newInstances)
+		return nil, e
+	}
+
+	c.sendStarts(tenantID, newInstances, configs, gang)
+
+	if len(opts.securityGroups) > 0 && len(newInstances) > 0 {
+		c.updateSecurityRules(tenantID, newInstances)
+	}
+
+	if overLimits {
+		return nil, errOverLimits
+	}
+
 	return newInstances, e
 }
 
+// cancelStarts removes instances which were added to the datastore
+// but will not be sent to the scheduler.
+func (c *controller) cancelStarts(instances []*types.Instance) {
+	for _, i := range instances {
+		err := c.ds.DeleteInstance(i.ID)
+		if err != nil {
+			glog.Warningf("Unable to remove instance %s: %v", i.ID, err)
+		}
+	}
+}
+
+// customizeWorkload returns a copy of a workload using the image and
+// user data of a server creation request.
+func (c *controller) customizeWorkload(wl *types.Workload, tenantID string, opts *instanceOptions) (*types.Workload, error) {
+	if (opts.imageID == "" || opts.imageID == wl.ImageID) && opts.userData == "" {
+		return wl, nil
+	}
+
+	custom := *wl
+
+	if opts.imageID != "" && opts.imageID != wl.ImageID {
+		err := c.validateImageOverride(wl, tenantID, opts.imageID)
+		if err != nil {
+			return nil, err
+		}
+
+		custom.ImageID = opts.imageID
+		if wl.VMType == payloads.Docker {
+			custom.ImageName = opts.imageID
+		}
+	}
+
+	if opts.userData != "" {
+		config, err := mergeUserData(wl.Config, opts.userData)
+		if err != nil {
+			return nil, err
+		}
+		custom.Config = config
+	}
+
+	return &custom, nil
+}
+
+// validateImageOverride checks that instances of a workload can be
+// started by a tenant from imageID.  Docker workloads use imageID as
+// the name of the docker image.
+func (c *controller) validateImageOverride(wl *types.Workload, tenantID string, imageID string) error {
+	if imageID == wl.ImageID {
+		return nil
+	}
+
+	if !wl.ImageOverride {
+		return fmt.Errorf("Workload %s does not allow image %s", wl.ID, imageID)
+	}
+
+	if wl.VMType == payloads.Docker {
+		return nil
+	}
+
+	_, err := c.image.GetImagePath(imageID)
+	if err != nil {
+		return fmt.Errorf("Unknown image %s", imageID)
+	}
+
+	return c.checkImage(tenantID, imageID)
+}
+
+// validateWorkload checks that a workload definition can be used
+// to start instances.
+func (c *controller) validateWorkload(wl *types.Workload) error {
+	if wl.Description == "" {
+		return errors.New("Missing workload description")
+	}
+
+	if wl.Config == "" {
+		return errors.New("Missing workload cloud-init template")
+	}
+
+	switch wl.VMType {
+	case payloads.QEMU:
+		if wl.FWType != string(payloads.EFI) && wl.FWType != payloads.Legacy {
+			return fmt.Errorf("Invalid firmware type %q", wl.FWType)
+		}
+
+		if wl.ImageID == "" {
+			return errors.New("Missing workload image")
+		}
+
+		_, err := c.image.GetImagePath(wl.ImageID)
+		if err != nil {
+			return fmt.Errorf("Unknown image %s", wl.ImageID)
+		}
+
+		err = c.checkImage("", wl.ImageID)
+		if err != nil {
+			return err
+		}
+	case payloads.Docker:
+		if wl.ImageName == "" {
+			return errors.New("Missing docker image name")
+		}
+	default:
+		return fmt.Errorf("Invalid VM type %q", wl.VMType)
+	}
+
+	if len(wl.Defaults) == 0 {
+		return errors.New("Missing workload resources")
+	}
+
+	for _, r := range wl.Defaults {
+		if r.Value < 0 {
+			return fmt.Errorf("Invalid %s value %d", r.Type, r.Value)
+		}
+	}
+
+	if wl.RecoveryPolicy != "" && !wl.RecoveryPolicy.Valid() {
+		return fmt.Errorf("Invalid recovery policy %q", wl.RecoveryPolicy)
+	}
+
+	if wl.Storage != nil {
+		switch wl.Storage.SourceType {
+		case types.ImageService, types.VolumeService:
+		default:
+			return fmt.Errorf("Invalid storage source type %q", wl.Storage.SourceType)
+		}
+
+		if wl.Storage.Size < 0 {
+			return fmt.Errorf("Invalid storage size %d", wl.Storage.Size)
+		}
+	}
+
+	return nil
+}
+
 func (c *controller) launchCNCI(tenantID string) error {
 	workloadID, err := c.ds.GetCNCIWorkloadID()
 	if err != nil {
@@ -194,17 +878,23 @@ func (c *controller) launchCNCI(tenantID string) error {
 
 	c.ds.AddTenantChan(ch, tenantID)
 
-	_, err = c.startWorkload(workloadID, tenantID, 1, false, "")
+	task := c.tasks.add(taskLaunchCNCI, tenantID, []string{tenantID})
+
+	instances, err := c.startWorkload(workloadID, tenantID, 1, false, "", false, nil)
 	if err != nil {
+		c.tasks.received(taskLaunchCNCI, "", tenantID, err)
 		return err
 	}
+	c.tasks.sent(task, ssntp.START.String(), instances[0].ID)
 
 	success := <-ch
 
 	if success {
+		c.tasks.received(taskLaunchCNCI, ssntp.ConcentratorInstanceAdded.String(), tenantID, nil)
 		return nil
 	}
 	msg := fmt.Sprintf("Failed to Launch CNCI for %s", tenantID)
+	c.tasks.received(taskLaunchCNCI, ssntp.StartFailure.String(), tenantID, errors.New(msg))
 	return errors.New(msg)
 }
 
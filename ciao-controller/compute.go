@@ -17,10 +17,12 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"sort"
@@ -28,9 +30,11 @@ import (
 	"strings"
 	"time"
 
+	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/ssntp/uuid"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 )
@@ -43,8 +47,45 @@ const (
 	computeActionStart action = iota
 	computeActionStop
 	computeActionDelete
+	computeActionAddFloatingIP
+	computeActionRemoveFloatingIP
+	computeActionAddSecurityGroup
+	computeActionRemoveSecurityGroup
+	computeActionReboot
+	computeActionPause
+	computeActionUnpause
+	computeActionSuspend
+	computeActionResume
+	computeActionGetConsoleOutput
+	computeActionGetSerialConsole
+	computeActionCreateImage
+	computeActionResize
+	computeActionMigrate
 )
 
+// computeActions maps the keys of the server action requests to the actions.
+var computeActions = map[string]action{
+	"os-start":            computeActionStart,
+	"os-stop":             computeActionStop,
+	"addFloatingIp":       computeActionAddFloatingIP,
+	"removeFloatingIp":    computeActionRemoveFloatingIP,
+	"addSecurityGroup":    computeActionAddSecurityGroup,
+	"removeSecurityGroup": computeActionRemoveSecurityGroup,
+	"reboot":              computeActionReboot,
+	"pause":               computeActionPause,
+	"unpause":             computeActionUnpause,
+	"suspend":             computeActionSuspend,
+	"resume":              computeActionResume,
+	"os-getConsoleOutput": computeActionGetConsoleOutput,
+	"os-getSerialConsole": computeActionGetSerialConsole,
+	"createImage":         computeActionCreateImage,
+	"resize":              computeActionResize,
+	"os-migrateLive":      computeActionMigrate,
+}
+
+// floatingIPPool is the name of the controller managed floating IP pool.
+const floatingIPPool = "public"
+
 type pagerFilterType uint8
 
 const (
@@ -425,11 +466,17 @@ func instanceToServer(context *controller, instance *types.Instance) (payloads.S
 	}
 
 	imageID := workload.ImageID
+	if instance.ImageID != "" {
+		imageID = instance.ImageID
+	}
 
 	server := payloads.Server{
 		HostID:   instance.NodeID,
 		ID:       instance.ID,
 		TenantID: instance.TenantID,
+		Name:     instance.Name,
+		Metadata: instance.Metadata,
+		KeyName:  instance.KeyName,
 		Flavor: payloads.Flavor{
 			ID: instance.WorkloadID,
 		},
@@ -445,8 +492,29 @@ func instanceToServer(context *controller, instance *types.Instance) (payloads.S
 				},
 			},
 		},
-		SSHIP:   instance.SSHIP,
-		SSHPort: instance.SSHPort,
+		SSHIP:          instance.SSHIP,
+		SSHPort:        instance.SSHPort,
+		RecoveryPolicy: string(instance.RecoveryPolicy),
+	}
+
+	if instance.PublicIP != "" {
+		server.Addresses.Private = append(server.Addresses.Private,
+			payloads.PrivateAddresses{
+				Addr:               instance.PublicIP,
+				OSEXTIPSMACMacAddr: instance.MACAddress,
+				OSEXTIPSType:       "floating",
+				Version:            4,
+			})
+	}
+
+	for _, ID := range instance.SecurityGroups {
+		g, err := context.ds.GetSecurityGroup(ID)
+		if err != nil {
+			continue
+		}
+
+		server.SecurityGroups = append(server.SecurityGroups,
+			payloads.SecurityGroup{Name: g.Name})
 	}
 
 	return server, nil
@@ -509,6 +577,57 @@ func showServerDetails(w http.ResponseWriter, r *http.Request, context *controll
 	w.Write(b)
 }
 
+func showServerHistory(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	instanceID := vars["server"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	transitions, err := context.ds.GetInstanceHistory(instanceID)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	// the history outlives the instance, so it is the
+	// history that tells which tenant owns the instance.
+	if len(transitions) == 0 || transitions[0].TenantID != tenant {
+		returnErrorCode(w, http.StatusNotFound, "Instance could not be found")
+		return
+	}
+
+	history := payloads.ComputeServerHistory{
+		ID:          instanceID,
+		Transitions: make([]payloads.InstanceTransition, 0, len(transitions)),
+	}
+
+	for _, t := range transitions {
+		history.Transitions = append(history.Transitions, payloads.InstanceTransition{
+			Timestamp: t.Timestamp,
+			From:      t.From,
+			To:        t.To,
+			Source:    t.Source,
+			NodeID:    t.NodeID,
+			Reason:    t.Reason,
+		})
+	}
+
+	b, err := json.Marshal(history)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
 func deleteServer(w http.ResponseWriter, r *http.Request, context *controller) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -539,6 +658,7 @@ func deleteServer(w http.ResponseWriter, r *http.Request, context *controller) {
 		return
 	}
 
+	w.Header().Set("Location", context.TaskLocation(instance))
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -567,6 +687,213 @@ func buildFlavorDetails(workload *types.Workload) (payloads.FlavorDetails, error
 	return details, nil
 }
 
+func workloadFromPayload(w payloads.CiaoWorkload) types.Workload {
+	workload := types.Workload{
+		ID:          w.ID,
+		Description: w.Description,
+		FWType:      string(w.FWType),
+		VMType:      w.VMType,
+		ImageID:     w.ImageID,
+		ImageName:   w.ImageName,
+		Config:      w.Config,
+
+		ImageOverride:  w.ImageOverride,
+		RecoveryPolicy: types.RecoveryPolicy(w.RecoveryPolicy),
+	}
+
+	for _, d := range w.Defaults {
+		workload.Defaults = append(workload.Defaults,
+			payloads.RequestedResource{
+				Type:      d.Type,
+				Value:     d.Value,
+				Mandatory: d.Mandatory,
+			})
+	}
+
+	if w.Storage != nil {
+		workload.Storage = &types.StorageResource{
+			ID:         w.Storage.ID,
+			Bootable:   w.Storage.Bootable,
+			Persistent: w.Storage.Persistent,
+			Size:       w.Storage.Size,
+			SourceType: types.SourceType(w.Storage.SourceType),
+		}
+	}
+
+	return workload
+}
+
+func workloadToPayload(workload *types.Workload) payloads.CiaoWorkload {
+	w := payloads.CiaoWorkload{
+		ID:          workload.ID,
+		Description: workload.Description,
+		FWType:      payloads.Firmware(workload.FWType),
+		VMType:      workload.VMType,
+		ImageID:     workload.ImageID,
+		ImageName:   workload.ImageName,
+		Config:      workload.Config,
+		Defaults:    []payloads.CiaoWorkloadResource{},
+
+		ImageOverride:  workload.ImageOverride,
+		RecoveryPolicy: string(workload.RecoveryPolicy),
+	}
+
+	for _, d := range workload.Defaults {
+		w.Defaults = append(w.Defaults,
+			payloads.CiaoWorkloadResource{
+				Type:      d.Type,
+				Value:     d.Value,
+				Mandatory: d.Mandatory,
+			})
+	}
+
+	if workload.Storage != nil {
+		w.Storage = &payloads.CiaoWorkloadStorage{
+			ID:         workload.Storage.ID,
+			Bootable:   workload.Storage.Bootable,
+			Persistent: workload.Storage.Persistent,
+			Size:       workload.Storage.Size,
+			SourceType: string(workload.Storage.SourceType),
+		}
+	}
+
+	return w
+}
+
+func readWorkloadRequest(r *http.Request) (types.Workload, error) {
+	var req payloads.CiaoWorkloadRequest
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return types.Workload{}, err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return types.Workload{}, err
+	}
+
+	return workloadFromPayload(req.Workload), nil
+}
+
+func returnWorkload(w http.ResponseWriter, httpStatus int, workload *types.Workload) {
+	b, err := json.Marshal(payloads.CiaoWorkloadRequest{
+		Workload: workloadToPayload(workload),
+	})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(b)
+}
+
+func workloadErrorCode(err error) int {
+	switch err {
+	case datastore.ErrNoWorkload:
+		return http.StatusNotFound
+	case datastore.ErrWorkloadExists, datastore.ErrWorkloadInUse:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func createFlavor(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequestBody(r, true)
+
+	if adminToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	workload, err := readWorkloadRequest(r)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// the ID names the file the workload is stored in.
+	if workload.ID == "" {
+		workload.ID = uuid.Generate().String()
+	} else if _, err := uuid.Parse(workload.ID); err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid flavor ID %q", workload.ID)
+		return
+	}
+
+	err = context.validateWorkload(&workload)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	err = context.ds.AddWorkload(workload)
+	if err != nil {
+		returnErrorCode(w, workloadErrorCode(err), "%v", err)
+		return
+	}
+
+	returnWorkload(w, http.StatusCreated, &workload)
+}
+
+func updateFlavor(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	workloadID := vars["flavor"]
+
+	dumpRequestBody(r, true)
+
+	if adminToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	workload, err := readWorkloadRequest(r)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	workload.ID = workloadID
+
+	err = context.validateWorkload(&workload)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	err = context.ds.UpdateWorkload(workload)
+	if err != nil {
+		returnErrorCode(w, workloadErrorCode(err), "%v", err)
+		return
+	}
+
+	returnWorkload(w, http.StatusOK, &workload)
+}
+
+func deleteFlavor(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	workloadID := vars["flavor"]
+
+	dumpRequest(r)
+
+	if adminToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	err := context.ds.DeleteWorkload(workloadID)
+	if err != nil {
+		returnErrorCode(w, workloadErrorCode(err), "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func listFlavors(w http.ResponseWriter, r *http.Request, context *controller) {
 	flavors := payloads.NewComputeFlavors()
 
@@ -683,6 +1010,7 @@ const (
 	vcpu          = 2
 	memory        = 3
 	disk          = 4
+	volumes       = 6
 )
 
 func listTenantQuotas(w http.ResponseWriter, r *http.Request, context *controller) {
@@ -746,6 +1074,10 @@ func listTenantQuotas(w http.ResponseWriter, r *http.Request, context *controlle
 		case disk:
 			tenantResource.DiskLimit = resource.Limit
 			tenantResource.DiskUsage = resource.Usage
+
+		case volumes:
+			tenantResource.VolumeLimit = resource.Limit
+			tenantResource.VolumeUsage = resource.Usage
 		}
 	}
 
@@ -759,106 +1091,131 @@ func listTenantQuotas(w http.ResponseWriter, r *http.Request, context *controlle
 	w.Write(b)
 }
 
-func tenantQueryParse(r *http.Request) (time.Time, time.Time, error) {
-	values := r.URL.Query()
-	var startTime, endTime time.Time
-
-	if values["start_date"] == nil || values["end_date"] == nil {
-		return startTime, endTime, fmt.Errorf("Missing date")
+// quotaLimits maps the resource IDs to the limits of a quota set.
+func quotaLimits(q *payloads.CiaoQuotas) map[int]**int {
+	return map[int]**int{
+		instances: &q.Instances,
+		vcpu:      &q.VCPUs,
+		memory:    &q.MemMB,
+		disk:      &q.DiskMB,
+		volumes:   &q.Volumes,
 	}
+}
 
-	startTime, err := time.Parse(time.RFC3339, values["start_date"][0])
+func readQuotaRequest(r *http.Request) (payloads.CiaoQuotaSet, error) {
+	var req payloads.CiaoQuotaSet
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return startTime, endTime, err
+		return req, err
 	}
 
-	endTime, err = time.Parse(time.RFC3339, values["end_date"][0])
+	err = json.Unmarshal(body, &req)
 	if err != nil {
-		return startTime, endTime, err
+		return req, err
 	}
 
-	return startTime, endTime, nil
+	for _, limit := range quotaLimits(&req.Quotas) {
+		if *limit != nil && **limit < -1 {
+			return req, fmt.Errorf("Invalid limit %d", **limit)
+		}
+	}
+
+	return req, nil
 }
 
-func listTenantResources(w http.ResponseWriter, r *http.Request, context *controller) {
+func updateTenantQuotas(w http.ResponseWriter, r *http.Request, context *controller) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
-	var usage payloads.CiaoUsageHistory
 
-	dumpRequest(r)
+	dumpRequestBody(r, true)
 
-	if validateToken(context, r) == false {
+	if adminToken(context, r) == false {
 		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
-	start, end, err := tenantQueryParse(r)
+	t, err := context.ds.GetTenant(tenant)
 	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
 		return
 	}
 
-	glog.V(2).Infof("Start %v\n", start)
-	glog.V(2).Infof("End %v\n", end)
-
-	usage.Usages, err = context.ds.GetTenantUsage(tenant, start, end)
-	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+	if t == nil {
+		returnErrorCode(w, http.StatusNotFound, "Tenant could not be found")
 		return
 	}
 
-	b, err := json.Marshal(usage)
+	req, err := readQuotaRequest(r)
 	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(b)
+	for resource, limit := range quotaLimits(&req.Quotas) {
+		if *limit == nil {
+			continue
+		}
+
+		err = context.ds.AddLimit(tenant, resource, **limit)
+		if err != nil {
+			returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func listServerDetails(w http.ResponseWriter, r *http.Request, context *controller) {
+func deleteTenantQuotas(w http.ResponseWriter, r *http.Request, context *controller) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
-	workload := vars["flavor"]
-	var instances []*types.Instance
-	var err error
 
 	dumpRequest(r)
 
-	if validateToken(context, r) == false {
+	if adminToken(context, r) == false {
 		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
-	if tenant != "" {
-		instances, err = context.ds.GetAllInstancesFromTenant(tenant)
-	} else {
-		instances, err = context.ds.GetAllInstances()
+	var quotas payloads.CiaoQuotas
+
+	for resource := range quotaLimits(&quotas) {
+		err := context.ds.DeleteLimit(tenant, resource)
+		if err != nil {
+			returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
 	}
 
-	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func showDefaultQuotas(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if adminToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
-	sort.Sort(types.SortedInstancesByID(instances))
+	var quotaSet payloads.CiaoQuotaSet
 
-	pager := serverPager{
-		context:   context,
-		instances: instances,
-	}
+	defaults := context.ds.GetDefaultLimits()
 
-	filterType := none
-	filter := ""
-	if workload != "" {
-		filterType = workloadFilter
-		filter = workload
+	for resource, limit := range quotaLimits(&quotaSet.Quotas) {
+		value, ok := defaults[resource]
+		if !ok {
+			value = -1
+		}
+		*limit = &value
 	}
 
-	b, err := pager.nextPage(filterType, filter, r)
+	b, err := json.Marshal(quotaSet)
 	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
 		return
 	}
 
@@ -866,54 +1223,295 @@ func listServerDetails(w http.ResponseWriter, r *http.Request, context *controll
 	w.Write(b)
 }
 
-func createServer(w http.ResponseWriter, r *http.Request, context *controller) {
-	vars := mux.Vars(r)
-	tenant := vars["tenant"]
-	var server payloads.ComputeCreateServer
-	var servers payloads.ComputeServers
-
+func updateDefaultQuotas(w http.ResponseWriter, r *http.Request, context *controller) {
 	dumpRequestBody(r, true)
 
-	if validateToken(context, r) == false {
+	if adminToken(context, r) == false {
 		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
-	defer r.Body.Close()
-
-	body, err := ioutil.ReadAll(r.Body)
+	req, err := readQuotaRequest(r)
 	if err != nil {
-		returnErrorCode(w, http.StatusBadRequest, "Service cannot read Request Body")
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
 		return
 	}
 
-	err = json.Unmarshal(body, &server)
-	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	for resource, limit := range quotaLimits(&req.Quotas) {
+		if *limit == nil {
+			continue
+		}
 
-	nInstances := 1
+		// resources without a default limit are unlimited
+		if **limit == -1 {
+			err = context.ds.DeleteDefaultLimit(resource)
+		} else {
+			err = context.ds.SetDefaultLimit(resource, **limit)
+		}
 
-	if server.Server.MaxInstances > 0 {
-		nInstances = server.Server.MaxInstances
-	} else if server.Server.MinInstances > 0 {
-		nInstances = server.Server.MinInstances
+		if err != nil {
+			returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
 	}
 
-	trace := false
-	label := ""
-	if server.Server.Name != "" {
-		trace = true
-		label = server.Server.Name
-	}
-	instances, err := context.startWorkload(server.Server.Workload, tenant, nInstances, trace, label)
-	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	for _, instance := range instances {
+func deleteDefaultQuotas(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if adminToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	var quotas payloads.CiaoQuotas
+
+	for resource := range quotaLimits(&quotas) {
+		err := context.ds.DeleteDefaultLimit(resource)
+		if err != nil {
+			returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tenantQueryParse(r *http.Request) (time.Time, time.Time, error) {
+	values := r.URL.Query()
+	var startTime, endTime time.Time
+
+	if values["start_date"] == nil || values["end_date"] == nil {
+		return startTime, endTime, fmt.Errorf("Missing date")
+	}
+
+	startTime, err := time.Parse(time.RFC3339, values["start_date"][0])
+	if err != nil {
+		return startTime, endTime, err
+	}
+
+	endTime, err = time.Parse(time.RFC3339, values["end_date"][0])
+	if err != nil {
+		return startTime, endTime, err
+	}
+
+	return startTime, endTime, nil
+}
+
+func listTenantResources(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	var usage payloads.CiaoUsageHistory
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	start, end, err := tenantQueryParse(r)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	glog.V(2).Infof("Start %v\n", start)
+	glog.V(2).Infof("End %v\n", end)
+
+	usage.Usages, err = context.ds.GetTenantUsage(tenant, start, end)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	b, err := json.Marshal(usage)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func listServerDetails(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	workload := vars["flavor"]
+	var instances []*types.Instance
+	var err error
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	if tenant != "" {
+		instances, err = context.ds.GetAllInstancesFromTenant(tenant)
+	} else {
+		instances, err = context.ds.GetAllInstances()
+	}
+
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sort.Sort(types.SortedInstancesByID(instances))
+
+	pager := serverPager{
+		context:   context,
+		instances: instances,
+	}
+
+	filterType := none
+	filter := ""
+	if workload != "" {
+		filterType = workloadFilter
+		filter = workload
+	}
+
+	b, err := pager.nextPage(filterType, filter, r)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serverOptions validates the parts of a server creation request
+// which customize the workload being started.
+func serverOptions(context *controller, tenant string, server *payloads.ComputeCreateServer) (*instanceOptions, error) {
+	opts := &instanceOptions{
+		name:     server.Server.Name,
+		metadata: server.Server.Metadata,
+	}
+
+	if server.Server.Image != "" {
+		wl, err := context.ds.GetWorkload(server.Server.Workload)
+		if err != nil {
+			return nil, err
+		}
+
+		err = context.validateImageOverride(wl, tenant, server.Server.Image)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.imageID = server.Server.Image
+	}
+
+	if server.Server.RecoveryPolicy != "" {
+		policy := types.RecoveryPolicy(server.Server.RecoveryPolicy)
+		if !policy.Valid() {
+			return nil, fmt.Errorf("Invalid recovery_policy %q", policy)
+		}
+
+		opts.recoveryPolicy = policy
+	}
+
+	if server.Server.UserData != "" {
+		userData, err := base64.StdEncoding.DecodeString(server.Server.UserData)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid user_data: %v", err)
+		}
+
+		err = validateUserData(string(userData))
+		if err != nil {
+			return nil, err
+		}
+
+		opts.userData = string(userData)
+	}
+
+	if server.Server.KeyName != "" {
+		key, err := context.ds.GetKeyPair(tenant, server.Server.KeyName)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key_name %s: %v", server.Server.KeyName, err)
+		}
+
+		opts.keyName = key.Name
+		opts.sshKey = key.PublicKey
+	}
+
+	for _, sg := range server.Server.SecurityGroups {
+		g, err := context.ds.GetSecurityGroupByName(tenant, sg.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid security group %s: %v", sg.Name, err)
+		}
+
+		opts.securityGroups = append(opts.securityGroups, g.ID)
+	}
+
+	return opts, nil
+}
+
+func createServer(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	var server payloads.ComputeCreateServer
+	var servers payloads.ComputeServers
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "Service cannot read Request Body")
+		return
+	}
+
+	err = json.Unmarshal(body, &server)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	nInstances := 1
+
+	if server.Server.MaxInstances > 0 {
+		nInstances = server.Server.MaxInstances
+	} else if server.Server.MinInstances > 0 {
+		nInstances = server.Server.MinInstances
+	}
+
+	// all or nothing when at least as many instances as requested must start
+	gang := nInstances > 1 && server.Server.MinInstances >= nInstances
+
+	opts, err := serverOptions(context, tenant, &server)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	label := server.Server.TraceLabel
+	trace := label != ""
+	instances, err := context.startWorkload(server.Server.Workload, tenant, nInstances, trace, label, gang, opts)
+	if err == errTenantDeleting {
+		returnErrorCode(w, http.StatusConflict, "%v", err)
+		return
+	} else if err == errTracedGang {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	} else if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, instance := range instances {
 		server, err := instanceToServer(context, instance)
 		if err != nil {
 			returnErrorCode(w, http.StatusInternalServerError, err.Error())
@@ -947,6 +1545,7 @@ func createServer(w http.ResponseWriter, r *http.Request, context *controller) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", context.TaskLocation(instances[0].ID))
 	w.WriteHeader(http.StatusAccepted)
 	w.Write(b)
 }
@@ -1037,8 +1636,674 @@ func tenantServersAction(w http.ResponseWriter, r *http.Request, context *contro
 func serverAction(w http.ResponseWriter, r *http.Request, context *controller) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
-	instance := vars["server"]
-	var action action
+	instance := vars["server"]
+	var action action
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	/* First check that the instance belongs to this tenant */
+	i, err := context.ds.GetInstance(instance)
+	if err != nil {
+		returnErrorCode(w, http.StatusNotFound, "Instance could not be found")
+		return
+	}
+
+	if i.TenantID != tenant {
+		returnErrorCode(w, http.StatusNotFound, "Instance does not belong to tenant")
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "Service cannot read Request Body")
+		return
+	}
+
+	// a server action request holds a single action, named by its key.
+	var request map[string]json.RawMessage
+	err = json.Unmarshal(body, &request)
+	if err != nil || len(request) != 1 {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid server action")
+		return
+	}
+
+	for key := range request {
+		var ok bool
+		action, ok = computeActions[key]
+		if !ok {
+			returnErrorCode(w, http.StatusServiceUnavailable, "Unsupported action")
+			return
+		}
+	}
+
+	if action == computeActionGetConsoleOutput {
+		serverConsoleOutput(w, context, instance, body)
+		return
+	} else if action == computeActionGetSerialConsole {
+		serverSerialConsole(w, r, context, tenant, instance, body)
+		return
+	} else if action == computeActionCreateImage {
+		serverCreateImage(w, context, tenant, instance, body)
+		return
+	} else if action == computeActionMigrate {
+		serverMigrate(w, r, context, instance, body)
+		return
+	}
+
+	var ipAction payloads.ComputeFloatingIPAction
+
+	if action == computeActionAddFloatingIP || action == computeActionRemoveFloatingIP {
+		err = json.Unmarshal(body, &ipAction)
+		if err != nil || (ipAction.AddFloatingIP == nil && ipAction.RemoveFloatingIP == nil) {
+			returnErrorCode(w, http.StatusBadRequest, "Invalid floating IP action")
+			return
+		}
+	}
+
+	var sgAction payloads.ComputeSecurityGroupAction
+
+	if action == computeActionAddSecurityGroup || action == computeActionRemoveSecurityGroup {
+		err = json.Unmarshal(body, &sgAction)
+		if err != nil || (sgAction.AddSecurityGroup == nil && sgAction.RemoveSecurityGroup == nil) {
+			returnErrorCode(w, http.StatusBadRequest, "Invalid security group action")
+			return
+		}
+	}
+
+	var hardReboot bool
+
+	if action == computeActionReboot {
+		var rebootAction payloads.ComputeRebootAction
+		err = json.Unmarshal(body, &rebootAction)
+		if err != nil || rebootAction.Reboot == nil {
+			returnErrorCode(w, http.StatusBadRequest, "Invalid reboot action")
+			return
+		}
+
+		switch strings.ToUpper(rebootAction.Reboot.Type) {
+		case "SOFT":
+		case "HARD":
+			hardReboot = true
+		default:
+			returnErrorCode(w, http.StatusBadRequest, "Invalid reboot type")
+			return
+		}
+	}
+
+	var flavorRef string
+
+	if action == computeActionResize {
+		var resizeAction payloads.ComputeResizeAction
+		err = json.Unmarshal(body, &resizeAction)
+		if err != nil || resizeAction.Resize == nil || resizeAction.Resize.FlavorRef == "" {
+			returnErrorCode(w, http.StatusBadRequest, "Invalid resize action")
+			return
+		}
+		flavorRef = resizeAction.Resize.FlavorRef
+	}
+
+	switch action {
+	case computeActionStart:
+		err = context.restartInstance(instance)
+	case computeActionStop:
+		err = context.stopInstance(instance)
+	case computeActionAddFloatingIP:
+		err = context.associateFloatingIP(tenant, instance, ipAction.AddFloatingIP.Address)
+	case computeActionRemoveFloatingIP:
+		err = removeServerFloatingIP(context, tenant, instance, ipAction.RemoveFloatingIP.Address)
+	case computeActionAddSecurityGroup:
+		err = serverSecurityGroup(context, tenant, instance, sgAction.AddSecurityGroup.Name,
+			context.addInstanceSecurityGroup)
+	case computeActionRemoveSecurityGroup:
+		err = serverSecurityGroup(context, tenant, instance, sgAction.RemoveSecurityGroup.Name,
+			context.removeInstanceSecurityGroup)
+	case computeActionReboot:
+		err = context.rebootInstance(instance, hardReboot)
+	case computeActionPause:
+		err = context.pauseInstance(instance)
+	case computeActionUnpause:
+		err = context.unpauseInstance(instance)
+	case computeActionSuspend:
+		err = context.suspendInstance(instance)
+	case computeActionResume:
+		err = context.resumeInstance(instance)
+	case computeActionResize:
+		err = context.resizeInstance(instance, flavorRef)
+	}
+
+	if err != nil {
+		code := http.StatusInternalServerError
+		if action == computeActionAddFloatingIP || action == computeActionRemoveFloatingIP {
+			code = floatingIPErrorCode(err)
+		} else if action == computeActionAddSecurityGroup || action == computeActionRemoveSecurityGroup {
+			code = securityGroupErrorCode(err)
+		} else if action == computeActionResize {
+			code = resizeErrorCode(err)
+		} else if err == errInstanceState {
+			code = http.StatusConflict
+		}
+		returnErrorCode(w, code, "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serverMigrate live migrates an instance.  Only admins know about nodes
+// and can move instances between them.
+func serverMigrate(w http.ResponseWriter, r *http.Request, context *controller, instance string, body []byte) {
+	if adminToken(context, r) == false {
+		returnErrorCode(w, http.StatusForbidden, "Live migration is restricted to admins")
+		return
+	}
+
+	var migrateAction payloads.ComputeMigrateLiveAction
+	err := json.Unmarshal(body, &migrateAction)
+	if err != nil || migrateAction.MigrateLive == nil {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid live migration action")
+		return
+	}
+
+	err = context.migrateInstance(instance, migrateAction.MigrateLive.Host)
+	if err != nil {
+		returnErrorCode(w, migrateErrorCode(err), "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func serverConsoleOutput(w http.ResponseWriter, context *controller, instance string, body []byte) {
+	var consoleAction payloads.ComputeConsoleOutputAction
+
+	err := json.Unmarshal(body, &consoleAction)
+	if err != nil || consoleAction.GetConsoleOutput == nil {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid console output action")
+		return
+	}
+
+	lines := 0
+	if consoleAction.GetConsoleOutput.Length != nil {
+		lines = *consoleAction.GetConsoleOutput.Length
+	}
+
+	output, err := context.getConsoleOutput(instance, lines)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == errInstanceState {
+			code = http.StatusConflict
+		} else if err == errConsoleLogTimeout {
+			code = http.StatusGatewayTimeout
+		}
+		returnErrorCode(w, code, "%v", err)
+		return
+	}
+
+	b, err := json.Marshal(payloads.ComputeConsoleOutputResponse{Output: output})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func removeServerFloatingIP(context *controller, tenant string, instance string, address string) error {
+	ip, err := context.ds.GetFloatingIPByAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if ip.TenantID != tenant {
+		return datastore.ErrNoFloatingIP
+	}
+
+	if ip.InstanceID != instance {
+		return datastore.ErrFloatingIPNotInUse
+	}
+
+	return context.disassociateFloatingIP(ip.ID)
+}
+
+func floatingIPErrorCode(err error) int {
+	switch err {
+	case datastore.ErrNoFloatingIP, datastore.ErrNoFreeFloatingIP:
+		return http.StatusNotFound
+	case datastore.ErrFloatingIPInUse, datastore.ErrFloatingIPNotInUse,
+		datastore.ErrInstanceFloatingIP:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func resizeErrorCode(err error) int {
+	switch err {
+	case errUnknownFlavor, errResizeVM, errResizeDisk:
+		return http.StatusBadRequest
+	case errOverLimits:
+		return http.StatusForbidden
+	case errInstanceState:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func migrateErrorCode(err error) int {
+	switch err {
+	case errMigrateVM, errMigrateNode, errMigrateTarget:
+		return http.StatusBadRequest
+	case errInstanceState:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func floatingIPToPayload(context *controller, ip types.FloatingIP) payloads.FloatingIP {
+	floatingIP := payloads.FloatingIP{
+		ID:   ip.ID,
+		IP:   ip.Address,
+		Pool: floatingIPPool,
+	}
+
+	if ip.InstanceID != "" {
+		instanceID := ip.InstanceID
+		floatingIP.InstanceID = &instanceID
+
+		i, err := context.ds.GetInstance(ip.InstanceID)
+		if err == nil {
+			fixedIP := i.IPAddress
+			floatingIP.FixedIP = &fixedIP
+		}
+	}
+
+	return floatingIP
+}
+
+func returnFloatingIP(w http.ResponseWriter, httpStatus int, context *controller, ip types.FloatingIP) {
+	b, err := json.Marshal(payloads.ComputeFloatingIP{
+		FloatingIP: floatingIPToPayload(context, ip),
+	})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(b)
+}
+
+func getTenantFloatingIP(w http.ResponseWriter, r *http.Request, context *controller) (types.FloatingIP, bool) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	ID := vars["id"]
+
+	ip, err := context.ds.GetFloatingIP(ID)
+	if err != nil || ip.TenantID != tenant || ip.State == types.FloatingIPAvailable {
+		returnErrorCode(w, http.StatusNotFound, "Floating IP could not be found")
+		return ip, false
+	}
+
+	return ip, true
+}
+
+func listFloatingIPs(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	ips := payloads.NewComputeFloatingIPs()
+
+	for _, ip := range context.ds.GetFloatingIPs(tenant) {
+		ips.FloatingIPs = append(ips.FloatingIPs, floatingIPToPayload(context, ip))
+	}
+
+	b, err := json.Marshal(ips)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func createFloatingIP(w http.ResponseWriter, r *http.Request, context *controller) {
+	var req payloads.ComputeCreateFloatingIP
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			returnErrorCode(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+
+	if req.Pool != "" && req.Pool != floatingIPPool {
+		returnErrorCode(w, http.StatusNotFound, "Floating IP pool %s could not be found", req.Pool)
+		return
+	}
+
+	err = context.deletions.create(tenant)
+	if err != nil {
+		returnErrorCode(w, http.StatusConflict, "%v", err)
+		return
+	}
+	defer context.deletions.created(tenant)
+
+	ip, err := context.ds.AllocateFloatingIP(tenant)
+	if err != nil {
+		returnErrorCode(w, floatingIPErrorCode(err), "%v", err)
+		return
+	}
+
+	returnFloatingIP(w, http.StatusOK, context, ip)
+}
+
+func showFloatingIP(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	ip, ok := getTenantFloatingIP(w, r, context)
+	if !ok {
+		return
+	}
+
+	returnFloatingIP(w, http.StatusOK, context, ip)
+}
+
+func deleteFloatingIP(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	ip, ok := getTenantFloatingIP(w, r, context)
+	if !ok {
+		return
+	}
+
+	err := context.releaseFloatingIP(ip.ID)
+	if err != nil {
+		returnErrorCode(w, floatingIPErrorCode(err), "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func serverSecurityGroup(context *controller, tenant string, instance string, name string,
+	update func(instanceID string, groupID string) error) error {
+	g, err := context.ds.GetSecurityGroupByName(tenant, name)
+	if err != nil {
+		return err
+	}
+
+	return update(instance, g.ID)
+}
+
+func securityGroupErrorCode(err error) int {
+	switch err {
+	case datastore.ErrNoSecurityGroup, datastore.ErrNoSecurityGroupRule:
+		return http.StatusNotFound
+	case datastore.ErrSecurityGroupExists, datastore.ErrSecurityGroupInUse,
+		datastore.ErrNotInSecurityGroup:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func securityGroupRuleToPayload(rule types.SecurityGroupRule) payloads.SecurityGroupRule {
+	return payloads.SecurityGroupRule{
+		ID:            rule.ID,
+		ParentGroupID: rule.GroupID,
+		IPProtocol:    rule.Protocol,
+		FromPort:      rule.FromPort,
+		ToPort:        rule.ToPort,
+		IPRange: payloads.SecurityGroupRuleIPRange{
+			CIDR: rule.CIDR,
+		},
+	}
+}
+
+func securityGroupToPayload(group types.SecurityGroup) payloads.SecurityGroupDetails {
+	details := payloads.SecurityGroupDetails{
+		ID:          group.ID,
+		Name:        group.Name,
+		Description: group.Description,
+		TenantID:    group.TenantID,
+		Rules:       []payloads.SecurityGroupRule{},
+	}
+
+	for _, rule := range group.Rules {
+		details.Rules = append(details.Rules, securityGroupRuleToPayload(rule))
+	}
+
+	return details
+}
+
+// validateSecurityGroupRule checks the protocol, port range and CIDR of
+// a new security group rule. Rules without a CIDR apply to all sources.
+func validateSecurityGroupRule(rule *types.SecurityGroupRule) error {
+	if rule.CIDR == "" {
+		rule.CIDR = "0.0.0.0/0"
+	}
+
+	_, ipNet, err := net.ParseCIDR(rule.CIDR)
+	if err != nil || ipNet.IP.To4() == nil {
+		return fmt.Errorf("Invalid CIDR %s", rule.CIDR)
+	}
+
+	switch rule.Protocol {
+	case "tcp", "udp":
+		if rule.FromPort < 1 || rule.ToPort > 65535 || rule.FromPort > rule.ToPort {
+			return fmt.Errorf("Invalid port range %d-%d", rule.FromPort, rule.ToPort)
+		}
+	case "icmp":
+		if rule.FromPort < -1 || rule.FromPort > 255 {
+			return fmt.Errorf("Invalid icmp type %d", rule.FromPort)
+		}
+	default:
+		return fmt.Errorf("Invalid protocol %s", rule.Protocol)
+	}
+
+	return nil
+}
+
+func getTenantSecurityGroup(w http.ResponseWriter, r *http.Request, context *controller) (types.SecurityGroup, bool) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	ID := vars["id"]
+
+	g, err := context.ds.GetSecurityGroup(ID)
+	if err != nil || g.TenantID != tenant {
+		returnErrorCode(w, http.StatusNotFound, "Security group could not be found")
+		return g, false
+	}
+
+	return g, true
+}
+
+func returnSecurityGroup(w http.ResponseWriter, group types.SecurityGroup) {
+	b, err := json.Marshal(payloads.ComputeSecurityGroup{
+		SecurityGroup: securityGroupToPayload(group),
+	})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func listSecurityGroups(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	groups := payloads.NewComputeSecurityGroups()
+
+	for _, g := range context.ds.GetSecurityGroups(tenant) {
+		groups.SecurityGroups = append(groups.SecurityGroups, securityGroupToPayload(g))
+	}
+
+	b, err := json.Marshal(groups)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func createSecurityGroup(w http.ResponseWriter, r *http.Request, context *controller) {
+	var req payloads.ComputeCreateSecurityGroup
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if req.SecurityGroup.Name == "" {
+		returnErrorCode(w, http.StatusBadRequest, "Missing security group name")
+		return
+	}
+
+	group := types.SecurityGroup{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenant,
+		Name:        req.SecurityGroup.Name,
+		Description: req.SecurityGroup.Description,
+	}
+
+	err = context.deletions.create(tenant)
+	if err != nil {
+		returnErrorCode(w, http.StatusConflict, "%v", err)
+		return
+	}
+	defer context.deletions.created(tenant)
+
+	err = context.ds.AddSecurityGroup(group)
+	if err != nil {
+		returnErrorCode(w, securityGroupErrorCode(err), "%v", err)
+		return
+	}
+
+	returnSecurityGroup(w, group)
+}
+
+func showSecurityGroup(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	g, ok := getTenantSecurityGroup(w, r, context)
+	if !ok {
+		return
+	}
+
+	returnSecurityGroup(w, g)
+}
+
+func deleteSecurityGroup(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	g, ok := getTenantSecurityGroup(w, r, context)
+	if !ok {
+		return
+	}
+
+	err := context.ds.DeleteSecurityGroup(g.ID)
+	if err != nil {
+		returnErrorCode(w, securityGroupErrorCode(err), "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func createSecurityGroupRule(w http.ResponseWriter, r *http.Request, context *controller) {
+	var req payloads.ComputeCreateSecurityGroupRule
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
 
 	dumpRequestBody(r, true)
 
@@ -1047,46 +2312,100 @@ func serverAction(w http.ResponseWriter, r *http.Request, context *controller) {
 		return
 	}
 
-	/* First check that the instance belongs to this tenant */
-	i, err := context.ds.GetInstance(instance)
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		returnErrorCode(w, http.StatusNotFound, "Instance could not be found")
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
 		return
 	}
 
-	if i.TenantID != tenant {
-		returnErrorCode(w, http.StatusNotFound, "Instance does not belong to tenant")
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
 		return
 	}
 
-	defer r.Body.Close()
+	if req.SecurityGroupRule.GroupID != "" {
+		returnErrorCode(w, http.StatusBadRequest, "Source security groups are not supported")
+		return
+	}
 
-	body, err := ioutil.ReadAll(r.Body)
+	g, err := context.ds.GetSecurityGroup(req.SecurityGroupRule.ParentGroupID)
+	if err != nil || g.TenantID != tenant {
+		returnErrorCode(w, http.StatusNotFound, "Security group could not be found")
+		return
+	}
+
+	rule := types.SecurityGroupRule{
+		ID:       uuid.Generate().String(),
+		GroupID:  g.ID,
+		Protocol: strings.ToLower(req.SecurityGroupRule.IPProtocol),
+		FromPort: req.SecurityGroupRule.FromPort,
+		ToPort:   req.SecurityGroupRule.ToPort,
+		CIDR:     req.SecurityGroupRule.CIDR,
+	}
+
+	err = validateSecurityGroupRule(&rule)
 	if err != nil {
-		returnErrorCode(w, http.StatusBadRequest, "Service cannot read Request Body")
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
 		return
 	}
 
-	bodyString := string(body)
+	err = context.deletions.create(tenant)
+	if err != nil {
+		returnErrorCode(w, http.StatusConflict, "%v", err)
+		return
+	}
+	defer context.deletions.created(tenant)
 
-	if strings.Contains(bodyString, "os-start") {
-		action = computeActionStart
-	} else if strings.Contains(bodyString, "os-stop") {
-		action = computeActionStop
-	} else {
-		returnErrorCode(w, http.StatusServiceUnavailable, "Unsupported action")
+	err = context.addSecurityGroupRule(rule)
+	if err != nil {
+		returnErrorCode(w, securityGroupErrorCode(err), "%v", err)
 		return
 	}
 
-	switch action {
-	case computeActionStart:
-		err = context.restartInstance(instance)
-	case computeActionStop:
-		err = context.stopInstance(instance)
+	b, err := json.Marshal(payloads.ComputeSecurityGroupRule{
+		SecurityGroupRule: securityGroupRuleToPayload(rule),
+	})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func deleteSecurityGroupRule(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	ID := vars["id"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
 	}
 
+	found := false
+	for _, g := range context.ds.GetSecurityGroups(tenant) {
+		for _, rule := range g.Rules {
+			if rule.ID == ID {
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		returnErrorCode(w, http.StatusNotFound, "Security group rule could not be found")
+		return
+	}
+
+	err := context.deleteSecurityGroupRule(ID)
 	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		returnErrorCode(w, securityGroupErrorCode(err), "%v", err)
 		return
 	}
 
@@ -1131,6 +2450,37 @@ func listTenants(w http.ResponseWriter, r *http.Request, context *controller) {
 	w.Write(b)
 }
 
+func deleteTenant(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
+
+	// the tenant token itself is not enough to delete a tenant.
+	if adminToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	err := context.deleteTenant(tenant)
+	if err != nil {
+		code := http.StatusInternalServerError
+
+		switch err {
+		case datastore.ErrNoTenant:
+			code = http.StatusNotFound
+		case errTenantDeleting:
+			code = http.StatusConflict
+		}
+
+		returnErrorCode(w, code, "%v", err)
+		return
+	}
+
+	w.Header().Set("Location", context.TaskLocation(tenant))
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func listNodes(w http.ResponseWriter, r *http.Request, context *controller) {
 	dumpRequest(r)
 
@@ -1275,13 +2625,12 @@ func listCNCIs(w http.ResponseWriter, r *http.Request, context *controller) {
 		return
 	}
 
-	var subnets []payloads.CiaoCNCISubnet
-
 	for _, cnci := range cncis {
 		if cnci.InstanceID == "" {
 			continue
 		}
 
+		var subnets []payloads.CiaoCNCISubnet
 		for _, subnet := range cnci.Subnets {
 			subnets = append(subnets,
 				payloads.CiaoCNCISubnet{
@@ -1390,6 +2739,58 @@ func listTraces(w http.ResponseWriter, r *http.Request, context *controller) {
 	w.Write(b)
 }
 
+func listTasks(w http.ResponseWriter, r *http.Request, context *controller) {
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	tasks := payloads.ComputeTasks{
+		Tasks: context.tasks.list(),
+	}
+
+	b, err := json.Marshal(tasks)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// showTask returns a task to the administrators, or to the users of the
+// tenant which started it.
+func showTask(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	id := vars["task"]
+
+	dumpRequest(r)
+
+	task, err := context.tasks.get(id)
+	if (err != nil || task.TenantID == "" || !tenantToken(context, r, task.TenantID)) &&
+		!adminToken(context, r) {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	if err != nil {
+		returnErrorCode(w, http.StatusNotFound, "Task could not be found")
+		return
+	}
+
+	b, err := json.Marshal(payloads.ComputeTask{Task: task})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
 func listEvents(w http.ResponseWriter, r *http.Request, context *controller) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -1431,6 +2832,126 @@ func listEvents(w http.ResponseWriter, r *http.Request, context *controller) {
 	w.Write(b)
 }
 
+// eventStreamKeepAlive is how often a comment is sent on an idle event
+// stream, for the clients which went away to be noticed.
+const eventStreamKeepAlive = 30 * time.Second
+
+func streamEventToPayload(e types.Event) payloads.CiaoStreamEvent {
+	return payloads.CiaoStreamEvent{
+		ID:         e.ID,
+		Timestamp:  e.Timestamp,
+		Kind:       string(e.Kind),
+		TenantID:   e.TenantID,
+		InstanceID: e.InstanceID,
+		NodeID:     e.NodeID,
+		EventType:  e.EventType,
+		State:      e.State,
+		Message:    e.Message,
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, e types.Event) error {
+	b, err := json.Marshal(streamEventToPayload(e))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Kind, b)
+	return err
+}
+
+// tenantStreamsEvent returns whether the event stream of tenant carries an
+// event, all the events being streamed to the administrators.
+func tenantStreamsEvent(tenant string, e types.Event) bool {
+	return tenant == "" || e.TenantID == tenant || e.Kind == types.EventGap
+}
+
+// streamEvents pushes the cluster events to the client as Server-Sent
+// Events, as they happen.  The stream starts after the event given by the
+// Last-Event-ID header, or the since query parameter, if it is still in
+// the backlog of the datastore, and with a gap event otherwise.  The tenant
+// streams only carry the events of their tenant, and the gap events.
+func streamEvents(w http.ResponseWriter, r *http.Request, context *controller) {
+	var since uint64
+	var err error
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("since")
+	}
+	if lastID != "" {
+		since, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			returnErrorCode(w, http.StatusBadRequest, "Invalid event ID %q", lastID)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		returnErrorCode(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	backlog, ch := context.ds.WatchEvents(since)
+	defer context.ds.UnwatchEvents(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range backlog {
+		if !tenantStreamsEvent(tenant, e) {
+			continue
+		}
+
+		err = writeStreamEvent(w, e)
+		if err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				// Too far behind, the client has to resume from
+				// the last event it received.
+				return
+			}
+
+			if !tenantStreamsEvent(tenant, e) {
+				continue
+			}
+
+			err = writeStreamEvent(w, e)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
 func clearEvents(w http.ResponseWriter, r *http.Request, context *controller) {
 	if validateToken(context, r) == false {
 		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
@@ -1439,7 +2960,7 @@ func clearEvents(w http.ResponseWriter, r *http.Request, context *controller) {
 
 	err := context.ds.ClearLog()
 	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
 		return
 	}
 
@@ -1476,7 +2997,7 @@ func traceData(w http.ResponseWriter, r *http.Request, context *controller) {
 
 	b, err := json.Marshal(traceData)
 	if err != nil {
-		returnErrorCode(w, http.StatusInternalServerError, err.Error())
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
 		return
 	}
 
@@ -1503,6 +3024,14 @@ func createComputeAPI(context *controller) {
 		deleteServer(w, r, context)
 	}).Methods("DELETE")
 
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/history", func(w http.ResponseWriter, r *http.Request) {
+		showServerHistory(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/servers/{server}/console", func(w http.ResponseWriter, r *http.Request) {
+		serverConsole(w, r, context)
+	}).Methods("GET")
+
 	r.HandleFunc("/v2.1/{tenant}/servers/action", func(w http.ResponseWriter, r *http.Request) {
 		tenantServersAction(w, r, context)
 	}).Methods("POST")
@@ -1511,6 +3040,90 @@ func createComputeAPI(context *controller) {
 		serverAction(w, r, context)
 	}).Methods("POST")
 
+	r.HandleFunc("/v2.1/{tenant}/os-floating-ips", func(w http.ResponseWriter, r *http.Request) {
+		listFloatingIPs(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-floating-ips", func(w http.ResponseWriter, r *http.Request) {
+		createFloatingIP(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/os-floating-ips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		showFloatingIP(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-floating-ips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		deleteFloatingIP(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/os-security-groups", func(w http.ResponseWriter, r *http.Request) {
+		listSecurityGroups(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-security-groups", func(w http.ResponseWriter, r *http.Request) {
+		createSecurityGroup(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/os-security-groups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		showSecurityGroup(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-security-groups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		deleteSecurityGroup(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/os-security-group-rules", func(w http.ResponseWriter, r *http.Request) {
+		createSecurityGroupRule(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/os-security-group-rules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		deleteSecurityGroupRule(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs", func(w http.ResponseWriter, r *http.Request) {
+		listKeyPairs(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs", func(w http.ResponseWriter, r *http.Request) {
+		createKeyPair(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs/{keypair}", func(w http.ResponseWriter, r *http.Request) {
+		showKeyPair(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/os-keypairs/{keypair}", func(w http.ResponseWriter, r *http.Request) {
+		deleteKeyPair(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		listWebhooks(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		createWebhook(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/{tenant}/webhooks/{webhook}", func(w http.ResponseWriter, r *http.Request) {
+		showWebhook(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/webhooks/{webhook}", func(w http.ResponseWriter, r *http.Request) {
+		deleteWebhook(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/{tenant}/webhooks/{webhook}/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		listWebhookDeliveries(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/images", func(w http.ResponseWriter, r *http.Request) {
+		listImages(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/{tenant}/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+		showImage(w, r, context)
+	}).Methods("GET")
+
 	r.HandleFunc("/v2.1/{tenant}/flavors", func(w http.ResponseWriter, r *http.Request) {
 		listFlavors(w, r, context)
 	}).Methods("GET")
@@ -1531,10 +3144,22 @@ func createComputeAPI(context *controller) {
 		listTenantQuotas(w, r, context)
 	}).Methods("GET")
 
+	r.HandleFunc("/v2.1/{tenant}/quotas", func(w http.ResponseWriter, r *http.Request) {
+		updateTenantQuotas(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/{tenant}/quotas", func(w http.ResponseWriter, r *http.Request) {
+		deleteTenantQuotas(w, r, context)
+	}).Methods("DELETE")
+
 	r.HandleFunc("/v2.1/{tenant}/events", func(w http.ResponseWriter, r *http.Request) {
 		listEvents(w, r, context)
 	}).Methods("GET")
 
+	r.HandleFunc("/v2.1/{tenant}/events/stream", func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(w, r, context)
+	}).Methods("GET")
+
 	/* Avoid conflict with {tenant}/servers/detail */
 	r.HandleFunc("/v2.1/nodes/{node}/servers/detail", func(w http.ResponseWriter, r *http.Request) {
 		listNodeServers(w, r, context)
@@ -1544,10 +3169,38 @@ func createComputeAPI(context *controller) {
 		listServerDetails(w, r, context)
 	}).Methods("GET")
 
+	r.HandleFunc("/v2.1/flavors", func(w http.ResponseWriter, r *http.Request) {
+		createFlavor(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/flavors/{flavor}", func(w http.ResponseWriter, r *http.Request) {
+		updateFlavor(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/flavors/{flavor}", func(w http.ResponseWriter, r *http.Request) {
+		deleteFlavor(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/quotas/defaults", func(w http.ResponseWriter, r *http.Request) {
+		showDefaultQuotas(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/quotas/defaults", func(w http.ResponseWriter, r *http.Request) {
+		updateDefaultQuotas(w, r, context)
+	}).Methods("PUT")
+
+	r.HandleFunc("/v2.1/quotas/defaults", func(w http.ResponseWriter, r *http.Request) {
+		deleteDefaultQuotas(w, r, context)
+	}).Methods("DELETE")
+
 	r.HandleFunc("/v2.1/tenants", func(w http.ResponseWriter, r *http.Request) {
 		listTenants(w, r, context)
 	}).Methods("GET")
 
+	r.HandleFunc("/v2.1/tenants/{tenant}", func(w http.ResponseWriter, r *http.Request) {
+		deleteTenant(w, r, context)
+	}).Methods("DELETE")
+
 	r.HandleFunc("/v2.1/nodes", func(w http.ResponseWriter, r *http.Request) {
 		listNodes(w, r, context)
 	}).Methods("GET")
@@ -1572,6 +3225,38 @@ func createComputeAPI(context *controller) {
 		clearEvents(w, r, context)
 	}).Methods("DELETE")
 
+	r.HandleFunc("/v2.1/events/stream", func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		listWebhooks(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		createWebhook(w, r, context)
+	}).Methods("POST")
+
+	r.HandleFunc("/v2.1/webhooks/{webhook}", func(w http.ResponseWriter, r *http.Request) {
+		showWebhook(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/webhooks/{webhook}", func(w http.ResponseWriter, r *http.Request) {
+		deleteWebhook(w, r, context)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/v2.1/webhooks/{webhook}/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		listWebhookDeliveries(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/tasks", func(w http.ResponseWriter, r *http.Request) {
+		listTasks(w, r, context)
+	}).Methods("GET")
+
+	r.HandleFunc("/v2.1/tasks/{task}", func(w http.ResponseWriter, r *http.Request) {
+		showTask(w, r, context)
+	}).Methods("GET")
+
 	r.HandleFunc("/v2.1/traces", func(w http.ResponseWriter, r *http.Request) {
 		listTraces(w, r, context)
 	}).Methods("GET")
@@ -1580,6 +3265,10 @@ func createComputeAPI(context *controller) {
 		traceData(w, r, context)
 	}).Methods("GET")
 
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		showMetrics(w, r, context)
+	}).Methods("GET")
+
 	service := fmt.Sprintf(":%d", computeAPIPort)
-	log.Fatal(http.ListenAndServeTLS(service, httpsCAcert, httpsKey, r))
+	log.Fatal(http.ListenAndServeTLS(service, httpsCAcert, httpsKey, instrumentAPI(r)))
 }
@@ -15,20 +15,32 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/openstack/block"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/ssntp/uuid"
 	"github.com/01org/ciao/testutil"
+	"github.com/gorilla/websocket"
 )
 
 func testHTTPRequest(t *testing.T, method string, URL string, expectedResponse int, data []byte, validToken bool) []byte {
@@ -110,6 +122,243 @@ func testCreateServer(t *testing.T, n int) payloads.ComputeServers {
 	return servers
 }
 
+// testCreateVMServer creates an instance of a QEMU workload, for the
+// server actions only supported by VMs.
+func testCreateVMServer(t *testing.T) payloads.ComputeServers {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	for _, wl := range wls {
+		if wl.VMType == payloads.QEMU && !isCNCIWorkload(wl) {
+			server.Server.Workload = wl.ID
+			break
+		}
+	}
+
+	if server.Server.Workload == "" {
+		t.Fatal("No valid VM workloads")
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers"
+
+	b, err := json.Marshal(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := testHTTPRequest(t, "POST", url, http.StatusAccepted, b, true)
+
+	servers := payloads.NewComputeServers()
+
+	err = json.Unmarshal(body, &servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return servers
+}
+
+func testCreateServerRequest(t *testing.T, server payloads.ComputeCreateServer, httpExpectedStatus int) payloads.ComputeServers {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No valid workloads")
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers"
+
+	server.Server.Workload = wls[0].ID
+
+	b, err := json.Marshal(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := testHTTPRequest(t, "POST", url, httpExpectedStatus, b, true)
+
+	servers := payloads.NewComputeServers()
+	if httpExpectedStatus != http.StatusAccepted {
+		return servers
+	}
+
+	err = json.Unmarshal(body, &servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return servers
+}
+
+func TestCreateServerNameMetadataUserData(t *testing.T) {
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	server.Server.Name = "Test Server"
+	server.Server.Metadata = map[string]string{"role": "test"}
+	server.Server.UserData = base64.StdEncoding.EncodeToString([]byte("#cloud-config\npackages:\n  - vim\n"))
+
+	servers := testCreateServerRequest(t, server, http.StatusAccepted)
+	if servers.TotalServers != 1 {
+		t.Fatal("Not enough servers returned")
+	}
+
+	if servers.Servers[0].Name != server.Server.Name ||
+		reflect.DeepEqual(servers.Servers[0].Metadata, server.Server.Metadata) == false {
+		t.Fatalf("Unexpected server returned: %+v", servers.Servers[0])
+	}
+
+	instance, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Name != server.Server.Name {
+		t.Fatalf("Instance name not stored: %q", instance.Name)
+	}
+}
+
+func TestCreateServerInvalidUserData(t *testing.T) {
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	server.Server.UserData = base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\necho hello\n"))
+
+	_ = testCreateServerRequest(t, server, http.StatusBadRequest)
+
+	server.Server.UserData = "not base64!"
+	_ = testCreateServerRequest(t, server, http.StatusBadRequest)
+}
+
+func TestCreateServerRecoveryPolicy(t *testing.T) {
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	server.Server.RecoveryPolicy = "unknown"
+
+	_ = testCreateServerRequest(t, server, http.StatusBadRequest)
+
+	server.Server.RecoveryPolicy = string(types.RecoveryReschedule)
+	servers := testCreateServerRequest(t, server, http.StatusAccepted)
+	if servers.TotalServers != 1 {
+		t.Fatal("Not enough servers returned")
+	}
+
+	instance, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.RecoveryPolicy != types.RecoveryReschedule {
+		t.Fatalf("Instance recovery policy %q, expected %q", instance.RecoveryPolicy, types.RecoveryReschedule)
+	}
+
+	volume, err := bootVolume(instance.StartConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if volume != "" {
+		t.Fatalf("Unexpected boot volume %s", volume)
+	}
+
+	testStartPersistence(t, instance.StartConfig, payloads.All)
+
+	server.Server.RecoveryPolicy = ""
+	servers = testCreateServerRequest(t, server, http.StatusAccepted)
+	if servers.TotalServers != 1 {
+		t.Fatal("Not enough servers returned")
+	}
+
+	instance, err = context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStartPersistence(t, instance.StartConfig, payloads.Host)
+}
+
+func testStartPersistence(t *testing.T, startConfig string, expected payloads.Persistence) {
+	start, _, err := parseStartConfig(startConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start.Start.InstancePersistence != expected {
+		t.Fatalf("Instance persistence %q, expected %q", start.Start.InstancePersistence, expected)
+	}
+}
+
+func TestStartConfigForWorkload(t *testing.T) {
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	server.Server.RecoveryPolicy = string(types.RecoveryReschedule)
+
+	servers := testCreateServerRequest(t, server, http.StatusAccepted)
+	if servers.TotalServers != 1 {
+		t.Fatal("Not enough servers returned")
+	}
+
+	instance, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err := context.ds.GetWorkload(instance.WorkloadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resizedID := testResizeWorkload(t, wl, 0, 256)
+	defer testDeleteWorkload(t, resizedID)
+
+	resized, err := context.ds.GetWorkload(resizedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startConfig, err := startConfigForWorkload(instance.StartConfig, resized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start, rest, err := parseStartConfig(startConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start.Start.InstanceUUID != instance.ID ||
+		!reflect.DeepEqual(start.Start.RequestedResources, resized.Defaults) {
+		t.Fatalf("Unexpected START payload %+v", start.Start)
+	}
+
+	_, launchedRest, err := parseStartConfig(instance.StartConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rest != launchedRest {
+		t.Fatalf("Start configuration not preserved:\n%s\nvs\n%s", rest, launchedRest)
+	}
+}
+
+func TestCreateServerImageNotOverridable(t *testing.T) {
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	server.Server.Image = "notanimage"
+
+	_ = testCreateServerRequest(t, server, http.StatusBadRequest)
+}
+
 func testListServerDetailsTenant(t *testing.T, tenantID string) payloads.ComputeServers {
 	url := testutil.ComputeURL + "/v2.1/" + tenantID + "/servers/detail"
 
@@ -434,217 +683,1774 @@ func TestServersActionStopWrongAction(t *testing.T) {
 	testServersActionStop(t, http.StatusServiceUnavailable, "wrong-action")
 }
 
-func testServerActionStop(t *testing.T, httpExpectedStatus int, validToken bool) {
-	action := "os-stop"
+func testServerActionStop(t *testing.T, httpExpectedStatus int, validToken bool) {
+	action := `{"os-stop":null}`
+
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionStop", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+	_ = testHTTPRequest(t, "POST", url, httpExpectedStatus, []byte(action), validToken)
+}
+
+func TestServerActionStop(t *testing.T) {
+	testServerActionStop(t, http.StatusAccepted, true)
+}
+
+func TestServerActionStopInvalidToken(t *testing.T) {
+	testServerActionStop(t, http.StatusUnauthorized, false)
+}
+
+func TestServerActionStart(t *testing.T) {
+	action := `{"os-start":null}`
+
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionStart", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	serverCh := server.AddCmdChan(ssntp.STOP)
+
+	err = context.stopInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.STOP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action), true)
+}
+
+func testServerAction(t *testing.T, url string, instance string, action string, cmd ssntp.Command) {
+	serverCh := server.AddCmdChan(cmd)
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action), true)
+
+	result, err := server.GetCmdChanResult(serverCh, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instance {
+		t.Fatalf("%s sent for wrong instance %s", cmd, result.InstanceUUID)
+	}
+}
+
+func TestServerActionInvalidBody(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal("Not enough servers returned")
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte("os-stop"), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"os-stop":null,"os-start":null}`), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusServiceUnavailable, []byte(`{"os-restart":null}`), true)
+
+	// action names in the action arguments are ignored
+	_ = testHTTPRequest(t, "POST", url, http.StatusNotFound, []byte(`{"addSecurityGroup":{"name":"os-stop resize"}}`), true)
+}
+
+func TestServerActionPause(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionPause", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, []byte(`{"unpause":null}`), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, []byte(`{"resume":null}`), true)
+
+	testServerAction(t, url, servers.Servers[0].ID, `{"pause":null}`, ssntp.PAUSE)
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	i, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.State != payloads.Paused {
+		t.Fatalf("Expected paused instance, got %s", i.State)
+	}
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, []byte(`{"suspend":null}`), true)
+
+	testServerAction(t, url, servers.Servers[0].ID, `{"unpause":null}`, ssntp.UNPAUSE)
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	testServerAction(t, url, servers.Servers[0].ID, `{"suspend":null}`, ssntp.SUSPEND)
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	i, err = context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.State != payloads.Paused {
+		t.Fatalf("Expected suspended instance to be paused, got %s", i.State)
+	}
+
+	testServerAction(t, url, servers.Servers[0].ID, `{"resume":null}`, ssntp.RESUME)
+}
+
+func TestServerActionReboot(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionReboot", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"reboot":null}`), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"reboot":{"type":"WARM"}}`), true)
+
+	testServerAction(t, url, servers.Servers[0].ID, `{"reboot":{"type":"SOFT"}}`, ssntp.REBOOT)
+	testServerAction(t, url, servers.Servers[0].ID, `{"reboot":{"type":"HARD"}}`, ssntp.REBOOT)
+}
+
+// testResizeWorkload adds a copy of a workload whose disk and memory
+// are changed by the given amounts.
+func testResizeWorkload(t *testing.T, wl *types.Workload, diskMB int, memMB int) string {
+	resized := *wl
+	resized.ID = uuid.Generate().String()
+	resized.Defaults = nil
+	for _, r := range wl.Defaults {
+		switch r.Type {
+		case payloads.DiskMB:
+			r.Value += diskMB
+		case payloads.MemMB:
+			r.Value += memMB
+		}
+		resized.Defaults = append(resized.Defaults, r)
+	}
+
+	err := context.ds.AddWorkload(resized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resized.ID
+}
+
+// testDeleteWorkload removes a workload added by testResizeWorkload,
+// along with the cloud-init file written for it in the workloads path.
+func testDeleteWorkload(t *testing.T, ID string) {
+	err := context.ds.DeleteWorkload(ID)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServerActionResize(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionResize", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateVMServer(t)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	instance := servers.Servers[0].ID
+	i, err := context.ds.GetInstance(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err := context.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := i.Usage
+	bigger := testResizeWorkload(t, wl, 1024, 512)
+	smaller := testResizeWorkload(t, wl, -1, 0)
+	defer func() {
+		// restore the original workload so that the test ones can be removed
+		err := context.ds.ResizeInstance(instance, wl.ID, usage)
+		if err != nil {
+			t.Error(err)
+		}
+
+		testDeleteWorkload(t, bigger)
+		testDeleteWorkload(t, smaller)
+	}()
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + instance + "/action"
+	resize := func(flavor string) []byte {
+		return []byte(`{"resize":{"flavorRef":"` + flavor + `"}}`)
+	}
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, resize(bigger), true)
+
+	serverCh := server.AddCmdChan(ssntp.STOP)
+
+	err = context.stopInstance(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.STOP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"resize":{}}`), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, resize("unknown"), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, resize(smaller), true)
+
+	testServerAction(t, url, instance, string(resize(bigger)), ssntp.RESTART)
+
+	i, err = context.ds.GetInstance(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.WorkloadID != bigger {
+		t.Fatalf("Instance not resized, workload %s", i.WorkloadID)
+	}
+}
+
+func TestServerActionMigrateLive(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionMigrateLive", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateVMServer(t)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"os-migrateLive":null}`), true)
+
+	sameNode := `{"os-migrateLive":{"host":"` + testutil.AgentUUID + `"}}`
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(sameNode), true)
+
+	unknownNode := `{"os-migrateLive":{"host":"` + uuid.Generate().String() + `"}}`
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(unknownNode), true)
+
+	testServerAction(t, url, servers.Servers[0].ID, `{"os-migrateLive":{}}`, ssntp.MIGRATE)
+
+	time.Sleep(1 * time.Second)
+
+	i, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.NodeID != testutil.AgentUUID {
+		t.Fatalf("Instance moved to node %s by a failed migration", i.NodeID)
+	}
+}
+
+func TestServerActionGetConsoleOutput(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionGetConsoleOutput", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"os-getConsoleOutput":null}`), true)
+
+	body := testHTTPRequest(t, "POST", url, http.StatusOK, []byte(`{"os-getConsoleOutput":{"length":50}}`), true)
+
+	var console payloads.ComputeConsoleOutputResponse
+	err = json.Unmarshal(body, &console)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if console.Output != testutil.ConsoleOutput {
+		t.Fatalf("Unexpected console output %q", console.Output)
+	}
+}
+
+func TestServerActionCreateImage(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionCreateImage", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateVMServer(t)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"createImage":{"name":""}}`), true)
+
+	body := testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(`{"createImage":{"name":"snapshot"}}`), true)
+
+	var created payloads.ComputeCreateImageResponse
+	err = json.Unmarshal(body, &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url = testutil.ComputeURL + "/v2.1/" + tenant.ID + "/images/" + created.ImageID
+
+	var image payloads.ComputeImageResponse
+	for i := 0; i < 10; i++ {
+		body = testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+		err = json.Unmarshal(body, &image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if image.Image.Status != "SAVING" {
+			break
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	if image.Image.Status != "ACTIVE" || image.Image.Name != "snapshot" ||
+		image.Image.Size != testutil.ImageSize ||
+		image.Image.Server.ID != servers.Servers[0].ID {
+		t.Fatalf("Unexpected image %+v", image.Image)
+	}
+
+	err = context.checkImage(tenant.ID, created.ImageID)
+	if err != nil {
+		t.Fatalf("Image not usable by its tenant: %v", err)
+	}
+
+	err = context.checkImage("other", created.ImageID)
+	if err == nil {
+		t.Fatal("Image usable by another tenant")
+	}
+
+	url = testutil.ComputeURL + "/v2.1/" + tenant.ID + "/images"
+	body = testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var images payloads.ComputeImages
+	err = json.Unmarshal(body, &images)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, i := range images.Images {
+		found = found || i.ID == created.ImageID
+	}
+
+	if !found {
+		t.Fatal("Image not listed")
+	}
+}
+
+// startTestConsoleServer starts a fake launcher console server echoing the
+// console input of instance.
+func startTestConsoleServer(t *testing.T, instance string) net.Listener {
+	cfg := &ssntp.Config{
+		CAcert: ssntp.DefaultCACert,
+		Cert:   ssntp.RoleToDefaultCertName(ssntp.AGENT),
+	}
+
+	tlsConfig, err := cfg.PeerTLSConfig(true, ssntp.Controller)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		header, err := r.ReadString('\n')
+		if err != nil || header != instance+"\n" {
+			return
+		}
+
+		_, _ = io.Copy(conn, r)
+	}()
+
+	return ln
+}
+
+func TestServerActionGetSerialConsole(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("ServerActionGetSerialConsole", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	sendStatsCmd(client, t)
+
+	time.Sleep(1 * time.Second)
+
+	ln := startTestConsoleServer(t, servers.Servers[0].ID)
+	defer ln.Close()
+
+	savedPort := launcherConsolePort
+	launcherConsolePort = ln.Addr().(*net.TCPAddr).Port
+	defer func() { launcherConsolePort = savedPort }()
+
+	err = context.ds.HandleStats(payloads.Stat{
+		NodeUUID: testutil.AgentUUID,
+		Load:     1,
+		Networks: []payloads.NetworkStat{{NodeIP: "127.0.0.1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
+
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, []byte(`{"os-getSerialConsole":{"type":"novnc"}}`), true)
+
+	body := testHTTPRequest(t, "POST", url, http.StatusOK, []byte(`{"os-getSerialConsole":{"type":"serial"}}`), true)
+
+	var console payloads.ComputeSerialConsoleResponse
+	err = json.Unmarshal(body, &console)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(console.Console.URL+"invalid", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Console connection with an invalid token should fail")
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(console.Console.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	err = ws.WriteMessage(websocket.BinaryMessage, []byte("root\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "root\n" {
+		t.Fatalf("Unexpected console output %q", data)
+	}
+
+	_, resp, err = websocket.DefaultDialer.Dial(console.Console.URL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Console tokens should only be used once")
+	}
+}
+
+func testListFlavors(t *testing.T, httpExpectedStatus int, data []byte, validToken bool) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/flavors"
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := testHTTPRequest(t, "GET", url, httpExpectedStatus, data, validToken)
+	// stop evaluating in case the scenario is InvalidToken
+	if httpExpectedStatus == 401 {
+		return
+	}
+
+	var flavors payloads.ComputeFlavors
+	err = json.Unmarshal(body, &flavors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(flavors.Flavors) != len(wls) {
+		t.Fatal("Incorrect number of flavors returned")
+	}
+
+	var matched int
+
+	for _, f := range flavors.Flavors {
+		for _, w := range wls {
+			if w.ID == f.ID && w.Description == f.Name {
+				matched++
+			}
+		}
+	}
+
+	if matched != len(wls) {
+		t.Fatal("Flavor information didn't match workload information")
+	}
+}
+
+func TestListFlavors(t *testing.T) {
+	testListFlavors(t, http.StatusOK, nil, true)
+}
+
+func TestListFlavorsInvalidToken(t *testing.T) {
+	testListFlavors(t, http.StatusUnauthorized, nil, false)
+}
+
+func testShowFlavorDetails(t *testing.T, httpExpectedStatus int, validToken bool) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tURL := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/flavors/"
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range wls {
+		details := payloads.FlavorDetails{
+			OsFlavorAccessIsPublic: true,
+			ID:   w.ID,
+			Disk: w.ImageID,
+			Name: w.Description,
+		}
+
+		defaults := w.Defaults
+		for r := range defaults {
+			switch defaults[r].Type {
+			case payloads.VCPUs:
+				details.Vcpus = defaults[r].Value
+			case payloads.MemMB:
+				details.RAM = defaults[r].Value
+			}
+		}
+
+		url := tURL + w.ID
+		body := testHTTPRequest(t, "GET", url, httpExpectedStatus, nil, validToken)
+		// stop evaluating in case the scenario is InvalidToken
+		if httpExpectedStatus == 401 {
+			return
+		}
+
+		var f payloads.ComputeFlavorDetails
+
+		err = json.Unmarshal(body, &f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reflect.DeepEqual(details, f.Flavor) == false {
+			t.Fatal("Flavor details not correct")
+		}
+	}
+}
+
+func TestShowFlavorDetails(t *testing.T) {
+	testShowFlavorDetails(t, http.StatusOK, true)
+}
+
+func TestShowFlavorDetailsInvalidToken(t *testing.T) {
+	testShowFlavorDetails(t, http.StatusUnauthorized, false)
+}
+
+func testListFlavorsDetails(t *testing.T, httpExpectedStatus int, data []byte, validToken bool) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/flavors/detail"
+	_ = testHTTPRequest(t, "GET", url, httpExpectedStatus, data, validToken)
+}
+
+func TestListFlavorsDetails(t *testing.T) {
+	testListFlavorsDetails(t, http.StatusOK, nil, true)
+}
+
+func TestListFlavorsDetailsInvalidToken(t *testing.T) {
+	testListFlavorsDetails(t, http.StatusUnauthorized, nil, false)
+}
+
+func testWorkloadRequest(t *testing.T, imageID string) payloads.CiaoWorkloadRequest {
+	return payloads.CiaoWorkloadRequest{
+		Workload: payloads.CiaoWorkload{
+			Description: "test workload",
+			FWType:      payloads.EFI,
+			VMType:      payloads.QEMU,
+			ImageID:     imageID,
+			Config:      "---\n#cloud-config\n...\n",
+			Defaults: []payloads.CiaoWorkloadResource{
+				{Type: payloads.VCPUs, Value: 2, Mandatory: true},
+				{Type: payloads.MemMB, Value: 256, Mandatory: true},
+			},
+		},
+	}
+}
+
+func testCreateFlavor(t *testing.T, httpExpectedStatus int, imageID string, validToken bool) payloads.CiaoWorkload {
+	var result payloads.CiaoWorkloadRequest
+
+	url := testutil.ComputeURL + "/v2.1/flavors"
+
+	b, err := json.Marshal(testWorkloadRequest(t, imageID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := testHTTPRequest(t, "POST", url, httpExpectedStatus, b, validToken)
+	if httpExpectedStatus != http.StatusCreated {
+		return result.Workload
+	}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Workload.ID == "" {
+		t.Fatal("Workload created without an ID")
+	}
+
+	return result.Workload
+}
+
+func TestCreateUpdateDeleteFlavor(t *testing.T) {
+	tmpfile, err := ioutil.TempFile(context.image.MountPoint, "testImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	imageID := filepath.Base(tmpfile.Name())
+
+	created := testCreateFlavor(t, http.StatusCreated, imageID, true)
+
+	wl, err := context.ds.GetWorkload(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wl.ImageID != imageID || len(wl.Defaults) != 2 {
+		t.Fatalf("Workload not created correctly: %+v", wl)
+	}
+
+	req := testWorkloadRequest(t, imageID)
+	req.Workload.Description = "updated test workload"
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/flavors/" + created.ID
+	_ = testHTTPRequest(t, "PUT", url, http.StatusOK, b, true)
+
+	wl, err = context.ds.GetWorkload(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wl.Description != req.Workload.Description {
+		t.Fatal("Workload not updated")
+	}
+
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusAccepted, nil, true)
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusNotFound, nil, true)
+}
+
+func TestCreateFlavorInvalidImage(t *testing.T) {
+	_ = testCreateFlavor(t, http.StatusBadRequest, "notanimage", true)
+}
+
+func TestCreateFlavorInvalidToken(t *testing.T) {
+	_ = testCreateFlavor(t, http.StatusUnauthorized, "notanimage", false)
+}
+
+func TestCreateFlavorInvalidID(t *testing.T) {
+	tmpfile, err := ioutil.TempFile(context.image.MountPoint, "testImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	req := testWorkloadRequest(t, filepath.Base(tmpfile.Name()))
+	req.Workload.ID = "../../workload"
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/flavors"
+	_ = testHTTPRequest(t, "POST", url, http.StatusBadRequest, b, true)
+}
+
+func TestUpdateFlavorNotFound(t *testing.T) {
+	tmpfile, err := ioutil.TempFile(context.image.MountPoint, "testImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	b, err := json.Marshal(testWorkloadRequest(t, filepath.Base(tmpfile.Name())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/flavors/notaworkload"
+	_ = testHTTPRequest(t, "PUT", url, http.StatusNotFound, b, true)
+}
+
+func testCreateFloatingIP(t *testing.T, tenantID string, httpExpectedStatus int, validToken bool) payloads.FloatingIP {
+	var ip payloads.ComputeFloatingIP
+
+	b, err := json.Marshal(payloads.ComputeCreateFloatingIP{Pool: "public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenantID + "/os-floating-ips"
+	body := testHTTPRequest(t, "POST", url, httpExpectedStatus, b, validToken)
+	if httpExpectedStatus != http.StatusOK {
+		return ip.FloatingIP
+	}
+
+	err = json.Unmarshal(body, &ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ip.FloatingIP
+}
+
+func TestFloatingIPs(t *testing.T) {
+	err := context.ds.SetFloatingIPPool([]string{"198.51.100.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := testCreateFloatingIP(t, tenant.ID, http.StatusOK, true)
+	if ip.IP != "198.51.100.10" || ip.InstanceID != nil || ip.Pool != "public" {
+		t.Fatalf("Floating IP not allocated correctly: %+v", ip)
+	}
+
+	_ = testCreateFloatingIP(t, tenant.ID, http.StatusNotFound, true)
+
+	var ips payloads.ComputeFloatingIPs
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/os-floating-ips"
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+	err = json.Unmarshal(body, &ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ips.FloatingIPs) != 1 || ips.FloatingIPs[0].ID != ip.ID {
+		t.Fatalf("Floating IPs not listed correctly: %+v", ips)
+	}
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal("Server not created")
+	}
+	instanceID := servers.Servers[0].ID
+
+	serverCh := server.AddCmdChan(ssntp.AssignPublicIP)
+
+	action := `{"addFloatingIp":{"address":"198.51.100.10"}}`
+	url = testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + instanceID + "/action"
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, []byte(action), true)
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.AssignPublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instanceID || result.TenantUUID != tenant.ID {
+		t.Fatalf("Unexpected AssignPublicIP command: %+v", result)
+	}
+
+	err = context.ds.PublicIPAssigned(payloads.PublicIPEvent{
+		ConcentratorUUID: tenant.CNCIID,
+		InstanceUUID:     instanceID,
+		PublicIP:         ip.IP,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s payloads.ComputeServer
+
+	body = testHTTPRequest(t, "GET", testutil.ComputeURL+"/v2.1/"+tenant.ID+"/servers/"+instanceID,
+		http.StatusOK, nil, true)
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := s.Server.Addresses.Private
+	if len(addresses) != 2 || addresses[1].Addr != ip.IP || addresses[1].OSEXTIPSType != "floating" {
+		t.Fatalf("Floating IP not reported: %+v", addresses)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.ReleasePublicIP)
+
+	action = `{"removeFloatingIp":{"address":"198.51.100.10"}}`
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action), true)
+
+	result, err = server.GetCmdChanResult(serverCh, ssntp.ReleasePublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instanceID {
+		t.Fatalf("Unexpected ReleasePublicIP command: %+v", result)
+	}
+
+	url = testutil.ComputeURL + "/v2.1/" + tenant.ID + "/os-floating-ips/" + ip.ID
+	_ = testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusAccepted, nil, true)
+	_ = testHTTPRequest(t, "GET", url, http.StatusNotFound, nil, true)
+}
+
+func TestCreateFloatingIPInvalidToken(t *testing.T) {
+	_ = testCreateFloatingIP(t, testutil.ComputeUser, http.StatusUnauthorized, false)
+}
+
+func testCreateSecurityGroup(t *testing.T, tenantID string, name string, httpExpectedStatus int, validToken bool) payloads.SecurityGroupDetails {
+	var req payloads.ComputeCreateSecurityGroup
+	var group payloads.ComputeSecurityGroup
+
+	req.SecurityGroup.Name = name
+	req.SecurityGroup.Description = "test group"
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenantID + "/os-security-groups"
+	body := testHTTPRequest(t, "POST", url, httpExpectedStatus, b, validToken)
+	if httpExpectedStatus != http.StatusOK {
+		return group.SecurityGroup
+	}
+
+	err = json.Unmarshal(body, &group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return group.SecurityGroup
+}
+
+func testCreateSecurityGroupRule(t *testing.T, tenantID string, groupID string, protocol string,
+	fromPort int, toPort int, httpExpectedStatus int) payloads.SecurityGroupRule {
+	var req payloads.ComputeCreateSecurityGroupRule
+	var rule payloads.ComputeSecurityGroupRule
+
+	req.SecurityGroupRule.ParentGroupID = groupID
+	req.SecurityGroupRule.IPProtocol = protocol
+	req.SecurityGroupRule.FromPort = fromPort
+	req.SecurityGroupRule.ToPort = toPort
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenantID + "/os-security-group-rules"
+	body := testHTTPRequest(t, "POST", url, httpExpectedStatus, b, true)
+	if httpExpectedStatus != http.StatusOK {
+		return rule.SecurityGroupRule
+	}
+
+	err = json.Unmarshal(body, &rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rule.SecurityGroupRule
+}
+
+func TestSecurityGroups(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group := testCreateSecurityGroup(t, tenant.ID, "ssh", http.StatusOK, true)
+	if group.Name != "ssh" || group.TenantID != tenant.ID || len(group.Rules) != 0 {
+		t.Fatalf("Security group not created correctly: %+v", group)
+	}
+
+	_ = testCreateSecurityGroup(t, tenant.ID, "ssh", http.StatusConflict, true)
+
+	_ = testCreateSecurityGroupRule(t, tenant.ID, group.ID, "sctp", 22, 22, http.StatusBadRequest)
+	_ = testCreateSecurityGroupRule(t, tenant.ID, group.ID, "tcp", 22, 21, http.StatusBadRequest)
+	_ = testCreateSecurityGroupRule(t, tenant.ID, "unknown", "tcp", 22, 22, http.StatusNotFound)
+
+	rule := testCreateSecurityGroupRule(t, tenant.ID, group.ID, "tcp", 22, 22, http.StatusOK)
+	if rule.ParentGroupID != group.ID || rule.IPRange.CIDR != "0.0.0.0/0" {
+		t.Fatalf("Security group rule not created correctly: %+v", rule)
+	}
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal("Server not created")
+	}
+	instanceID := servers.Servers[0].ID
+
+	serverCh := server.AddCmdChan(ssntp.ApplySecurityRules)
+
+	action := `{"addSecurityGroup":{"name":"ssh"}}`
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + instanceID + "/action"
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action), true)
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.ApplySecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instanceID || result.TenantUUID != tenant.ID {
+		t.Fatalf("Unexpected ApplySecurityRules command: %+v", result)
+	}
+
+	action = `{"addSecurityGroup":{"name":"unknown"}}`
+	_ = testHTTPRequest(t, "POST", url, http.StatusNotFound, []byte(action), true)
+
+	var s payloads.ComputeServer
+
+	body := testHTTPRequest(t, "GET", testutil.ComputeURL+"/v2.1/"+tenant.ID+"/servers/"+instanceID,
+		http.StatusOK, nil, true)
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Server.SecurityGroups) != 1 || s.Server.SecurityGroups[0].Name != "ssh" {
+		t.Fatalf("Security groups not reported: %+v", s.Server.SecurityGroups)
+	}
+
+	groupURL := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/os-security-groups/" + group.ID
+	_ = testHTTPRequest(t, "DELETE", groupURL, http.StatusConflict, nil, true)
+
+	var details payloads.ComputeSecurityGroup
+
+	body = testHTTPRequest(t, "GET", groupURL, http.StatusOK, nil, true)
+	err = json.Unmarshal(body, &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(details.SecurityGroup.Rules) != 1 || details.SecurityGroup.Rules[0].ID != rule.ID {
+		t.Fatalf("Security group rules not reported: %+v", details)
+	}
+
+	// a restarted CNCI gets the rules of the instances back
+	serverCh = server.AddCmdChan(ssntp.ApplySecurityRules)
+
+	context.restoreSecurityRules(tenant.CNCIMAC)
+
+	result, err = server.GetCmdChanResult(serverCh, ssntp.ApplySecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instanceID {
+		t.Fatalf("Unexpected ApplySecurityRules command: %+v", result)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.ApplySecurityRules)
+
+	ruleURL := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/os-security-group-rules/" + rule.ID
+	_ = testHTTPRequest(t, "DELETE", ruleURL, http.StatusAccepted, nil, true)
+	_ = testHTTPRequest(t, "DELETE", ruleURL, http.StatusNotFound, nil, true)
+
+	result, err = server.GetCmdChanResult(serverCh, ssntp.ApplySecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instanceID {
+		t.Fatalf("Unexpected ApplySecurityRules command: %+v", result)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.ClearSecurityRules)
+
+	action = `{"removeSecurityGroup":{"name":"ssh"}}`
+	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action), true)
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, []byte(action), true)
+
+	result, err = server.GetCmdChanResult(serverCh, ssntp.ClearSecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != instanceID {
+		t.Fatalf("Unexpected ClearSecurityRules command: %+v", result)
+	}
+
+	var groups payloads.ComputeSecurityGroups
+
+	body = testHTTPRequest(t, "GET", testutil.ComputeURL+"/v2.1/"+tenant.ID+"/os-security-groups",
+		http.StatusOK, nil, true)
+	err = json.Unmarshal(body, &groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups.SecurityGroups) != 1 || groups.SecurityGroups[0].ID != group.ID {
+		t.Fatalf("Security groups not listed correctly: %+v", groups)
+	}
+
+	_ = testHTTPRequest(t, "DELETE", groupURL, http.StatusAccepted, nil, true)
+	_ = testHTTPRequest(t, "GET", groupURL, http.StatusNotFound, nil, true)
+}
+
+func TestCreateSecurityGroupInvalidToken(t *testing.T) {
+	_ = testCreateSecurityGroup(t, testutil.ComputeUser, "invalid", http.StatusUnauthorized, false)
+}
+
+func TestServerHistory(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := testCreateServer(t, 1)
+	if servers.TotalServers != 1 {
+		t.Fatal("Server not created")
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/history"
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var history payloads.ComputeServerHistory
+	err = json.Unmarshal(body, &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if history.ID != servers.Servers[0].ID || len(history.Transitions) == 0 ||
+		history.Transitions[0].From != "" || history.Transitions[0].To != payloads.Pending {
+		t.Fatalf("Unexpected server history: %+v", history)
+	}
+
+	url = testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/unknown/history"
+	_ = testHTTPRequest(t, "GET", url, http.StatusNotFound, nil, true)
+}
+
+func TestServerHistoryInvalidToken(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/" + testutil.ComputeUser + "/servers/unknown/history"
+	_ = testHTTPRequest(t, "GET", url, http.StatusUnauthorized, nil, false)
+}
+
+func TestShowTask(t *testing.T) {
+	servers := testCreateServer(t, 2)
+
+	location := context.TaskLocation(servers.Servers[0].ID)
+	if location == "" || location != context.TaskLocation(servers.Servers[1].ID) {
+		t.Fatalf("Unexpected task location %q", location)
+	}
+
+	body := testHTTPRequest(t, "GET", testutil.ComputeURL+location, http.StatusOK, nil, true)
+
+	var task payloads.ComputeTask
+	err := json.Unmarshal(body, &task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if task.Task.Operation != taskStartInstances || task.Task.TenantID != testutil.ComputeUser ||
+		len(task.Task.Resources) != 2 || len(task.Task.Frames) != 1 ||
+		task.Task.Frames[0].Frame != ssntp.StartBatch.String() {
+		t.Fatalf("Unexpected task %+v", task.Task)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/tasks/unknown"
+	_ = testHTTPRequest(t, "GET", url, http.StatusNotFound, nil, true)
+}
+
+func TestShowTaskInvalidToken(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/tasks/unknown"
+	_ = testHTTPRequest(t, "GET", url, http.StatusUnauthorized, nil, false)
+}
+
+func TestDeleteTenant(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	tenantID := instances[0].TenantID
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+
+	image := types.Image{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenantID,
+		Name:       "snapshot",
+		InstanceID: instances[0].ID,
+		State:      types.ImageActive,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	err := context.ds.UpdateImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imagePath := filepath.Join(context.image.MountPoint, image.ID)
+	err = ioutil.WriteFile(imagePath, []byte("image"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/tenants/" + tenantID
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusAccepted, nil, true)
+
+	location := context.TaskLocation(tenantID)
+	if location == "" || location != context.TaskLocation(instances[0].ID) {
+		t.Fatalf("Unexpected task location %q", location)
+	}
+
+	// the instance is not deleted until it is assigned a node.
+	time.Sleep(2 * tenantDeletionPoll)
+
+	_, err = context.ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal("Instance deleted before being assigned a node")
+	}
+
+	sendStatsCmd(client, t)
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+
+	_, err = context.createImage(instances[0].ID, "deleting")
+	if err != errTenantDeleting {
+		t.Fatalf("Expected %v, got %v", errTenantDeleting, err)
+	}
+
+	client.SendDeleteEvent(instances[0].ID)
+
+	var task payloads.ComputeTask
+	for i := 0; i < 10; i++ {
+		body := testHTTPRequest(t, "GET", testutil.ComputeURL+location, http.StatusOK, nil, true)
+
+		err = json.Unmarshal(body, &task)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if task.Task.Status != payloads.TaskRunning {
+			break
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+
+	if task.Task.Operation != taskDeleteTenant || task.Task.Status != payloads.TaskCompleted {
+		t.Fatalf("Unexpected task %+v", task.Task)
+	}
+
+	_, err = context.ds.GetInstance(instances[0].ID)
+	if err == nil {
+		t.Fatal("Instance not deleted")
+	}
+
+	deleted, err := context.ds.GetTenant(tenantID)
+	if err != nil || deleted != nil {
+		t.Fatal("Tenant not deleted")
+	}
+
+	_, err = context.ds.GetImage(image.ID)
+	if err == nil {
+		t.Fatal("Image not deleted")
+	}
+
+	_, err = os.Stat(imagePath)
+	if !os.IsNotExist(err) {
+		t.Fatal("Image file not removed")
+	}
+
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusNotFound, nil, true)
+}
+
+func TestDeleteTenantRejectsCreation(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group := testCreateSecurityGroup(t, tenant.ID, "deleting", http.StatusOK, true)
+
+	err = context.deletions.start(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer context.deletions.end(tenant.ID)
+
+	url := testutil.ComputeURL + "/v2.1/tenants/" + tenant.ID
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusConflict, nil, true)
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 1, false, "", false, nil)
+	if err != errTenantDeleting {
+		t.Fatalf("Expected %v, got %v", errTenantDeleting, err)
+	}
+
+	_, err = context.CreateVolume(tenant.ID, block.RequestedVolume{Size: 1})
+	if err != block.ErrTenantNotFound {
+		t.Fatalf("Expected %v, got %v", block.ErrTenantNotFound, err)
+	}
+
+	url = testutil.ComputeURL + "/v2.1/" + tenant.ID + "/os-floating-ips"
+	_ = testHTTPRequest(t, "POST", url, http.StatusConflict, nil, true)
+
+	_ = testCreateKeyPair(t, tenant.ID, "deleting", "", http.StatusConflict, true)
+	_ = testCreateSecurityGroup(t, tenant.ID, "created", http.StatusConflict, true)
+	_ = testCreateSecurityGroupRule(t, tenant.ID, group.ID, "tcp", 22, 22, http.StatusConflict)
+	_ = testCreateWebhook(t, testutil.ComputeURL+"/v2.1/"+tenant.ID+"/webhooks",
+		"https://example.com/hook", nil, http.StatusConflict, true)
+}
+
+func TestDeleteTenantInvalidToken(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/tenants/" + testutil.ComputeUser
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusUnauthorized, nil, false)
+}
+
+func testCreateKeyPair(t *testing.T, tenant string, name string, publicKey string, httpExpectedStatus int, validToken bool) payloads.KeyPair {
+	var req payloads.ComputeCreateKeyPair
+	req.KeyPair.Name = name
+	req.KeyPair.PublicKey = publicKey
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant + "/os-keypairs"
+	body := testHTTPRequest(t, "POST", url, httpExpectedStatus, b, validToken)
+
+	var key payloads.ComputeKeyPair
+	if httpExpectedStatus != http.StatusOK {
+		return key.KeyPair
+	}
+
+	err = json.Unmarshal(body, &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.KeyPair
+}
 
+func TestKeyPairs(t *testing.T) {
 	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	client, err := testutil.NewSsntpTestClientConnection("ServerActionStop", ssntp.AGENT, testutil.AgentUUID)
-	if err != nil {
-		t.Fatal(err)
+	generated := testCreateKeyPair(t, tenant.ID, "generated", "", http.StatusOK, true)
+	if generated.PrivateKey == "" || generated.PublicKey == "" || generated.Fingerprint == "" {
+		t.Fatalf("Keypair not generated correctly: %+v", generated)
 	}
-	defer client.Shutdown()
 
-	servers := testCreateServer(t, 1)
-	if servers.TotalServers != 1 {
+	uploaded := testCreateKeyPair(t, tenant.ID, "uploaded", generated.PublicKey, http.StatusOK, true)
+	if uploaded.PrivateKey != "" || uploaded.Fingerprint != generated.Fingerprint {
+		t.Fatalf("Keypair not uploaded correctly: %+v", uploaded)
+	}
+
+	_ = testCreateKeyPair(t, tenant.ID, "uploaded", generated.PublicKey, http.StatusConflict, true)
+	_ = testCreateKeyPair(t, tenant.ID, "invalid", "ssh-rsa invalid", http.StatusBadRequest, true)
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/os-keypairs"
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var keys payloads.ComputeKeyPairs
+	err = json.Unmarshal(body, &keys)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	time.Sleep(2 * time.Second)
+	if len(keys.KeyPairs) != 2 || keys.KeyPairs[0].KeyPair.Name != "generated" ||
+		keys.KeyPairs[0].KeyPair.PrivateKey != "" {
+		t.Fatalf("Unexpected keypair list: %+v", keys)
+	}
 
-	sendStatsCmd(client, t)
+	var server payloads.ComputeCreateServer
+	server.Server.MaxInstances = 1
+	server.Server.KeyName = "unknown"
+	_ = testCreateServerRequest(t, server, http.StatusBadRequest)
 
-	time.Sleep(1 * time.Second)
+	server.Server.KeyName = "uploaded"
+	servers := testCreateServerRequest(t, server, http.StatusAccepted)
+	if servers.TotalServers != 1 {
+		t.Fatal("Server not created")
+	}
 
-	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
-	_ = testHTTPRequest(t, "POST", url, httpExpectedStatus, []byte(action), validToken)
-}
+	instance, err := context.ds.GetInstance(servers.Servers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestServerActionStop(t *testing.T) {
-	testServerActionStop(t, http.StatusAccepted, true)
-}
+	if instance.KeyName != "uploaded" {
+		t.Fatalf("Expected key_name uploaded, got %q", instance.KeyName)
+	}
 
-func TestServerActionStopInvalidToken(t *testing.T) {
-	testServerActionStop(t, http.StatusUnauthorized, false)
+	_ = testHTTPRequest(t, "GET", url+"/uploaded", http.StatusOK, nil, true)
+	_ = testHTTPRequest(t, "DELETE", url+"/uploaded", http.StatusAccepted, nil, true)
+	_ = testHTTPRequest(t, "DELETE", url+"/generated", http.StatusAccepted, nil, true)
+	_ = testHTTPRequest(t, "GET", url+"/uploaded", http.StatusNotFound, nil, true)
 }
 
-func TestServerActionStart(t *testing.T) {
-	action := "os-start"
+func testCreateWebhook(t *testing.T, url string, hookURL string, eventTypes []string, httpExpectedStatus int, validToken bool) payloads.CiaoWebhook {
+	var req payloads.CiaoCreateWebhook
+	req.Webhook.URL = hookURL
+	req.Webhook.EventTypes = eventTypes
+	req.Webhook.Secret = "mysecret"
 
-	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	b, err := json.Marshal(req)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	client, err := testutil.NewSsntpTestClientConnection("ServerActionStart", ssntp.AGENT, testutil.AgentUUID)
+	body := testHTTPRequest(t, "POST", url, httpExpectedStatus, b, validToken)
+
+	var webhook payloads.CiaoWebhookResponse
+	if httpExpectedStatus != http.StatusCreated {
+		return webhook.Webhook
+	}
+
+	err = json.Unmarshal(body, &webhook)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer client.Shutdown()
 
-	servers := testCreateServer(t, 1)
-	if servers.TotalServers != 1 {
+	return webhook.Webhook
+}
+
+func TestWebhooks(t *testing.T) {
+	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	time.Sleep(1 * time.Second)
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/webhooks"
 
-	sendStatsCmd(client, t)
+	webhook := testCreateWebhook(t, url, "https://example.com/hooks",
+		[]string{"start_failure", "instance_state"}, http.StatusCreated, true)
+	if webhook.ID == "" || webhook.TenantID != tenant.ID || len(webhook.EventTypes) != 2 {
+		t.Fatalf("Webhook not created correctly: %+v", webhook)
+	}
 
-	time.Sleep(1 * time.Second)
+	_ = testCreateWebhook(t, url, "ftp://example.com/hooks", nil, http.StatusBadRequest, true)
+	_ = testCreateWebhook(t, url, "https://example.com/hooks", []string{"unknown"}, http.StatusBadRequest, true)
+	_ = testCreateWebhook(t, url, "http://127.0.0.1:8080/hooks", nil, http.StatusBadRequest, true)
+	_ = testCreateWebhook(t, url, "http://[fe80::1]/hooks", nil, http.StatusBadRequest, true)
+	_ = testCreateWebhook(t, url, "http://10.0.0.1/hooks", nil, http.StatusBadRequest, true)
 
-	serverCh := server.AddCmdChan(ssntp.STOP)
+	admin := testCreateWebhook(t, testutil.ComputeURL+"/v2.1/webhooks",
+		"http://example.com/admin", nil, http.StatusCreated, true)
+	if admin.TenantID != "" || len(admin.EventTypes) != 0 {
+		t.Fatalf("Admin webhook not created correctly: %+v", admin)
+	}
 
-	err = context.stopInstance(servers.Servers[0].ID)
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var webhooks payloads.CiaoWebhooks
+	err = json.Unmarshal(body, &webhooks)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = server.GetCmdChanResult(serverCh, ssntp.STOP)
+	if len(webhooks.Webhooks) != 1 || webhooks.Webhooks[0].ID != webhook.ID {
+		t.Fatalf("Unexpected webhook list: %+v", webhooks)
+	}
+
+	body = testHTTPRequest(t, "GET", url+"/"+webhook.ID, http.StatusOK, nil, true)
+	if strings.Contains(string(body), "mysecret") {
+		t.Fatal("Webhook secret returned")
+	}
+
+	body = testHTTPRequest(t, "GET", url+"/"+webhook.ID+"/deliveries", http.StatusOK, nil, true)
+
+	var deliveries payloads.CiaoWebhookDeliveries
+	err = json.Unmarshal(body, &deliveries)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	time.Sleep(1 * time.Second)
-
-	sendStatsCmd(client, t)
+	if len(deliveries.Deliveries) != 0 {
+		t.Fatalf("Unexpected deliveries: %+v", deliveries)
+	}
 
-	time.Sleep(1 * time.Second)
+	_ = testHTTPRequest(t, "GET", url+"/"+admin.ID, http.StatusNotFound, nil, true)
+	_ = testHTTPRequest(t, "DELETE", url+"/"+webhook.ID, http.StatusAccepted, nil, true)
+	_ = testHTTPRequest(t, "GET", url+"/"+webhook.ID, http.StatusNotFound, nil, true)
 
-	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/servers/" + servers.Servers[0].ID + "/action"
-	_ = testHTTPRequest(t, "POST", url, http.StatusAccepted, []byte(action), true)
+	adminURL := testutil.ComputeURL + "/v2.1/webhooks/" + admin.ID
+	_ = testHTTPRequest(t, "DELETE", adminURL, http.StatusAccepted, nil, true)
 }
 
-func testListFlavors(t *testing.T, httpExpectedStatus int, data []byte, validToken bool) {
+func TestMetrics(t *testing.T) {
 	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/flavors"
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/os-keypairs"
+	_ = testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
 
-	wls, err := context.ds.GetWorkloads()
-	if err != nil {
-		t.Fatal(err)
-	}
+	_ = testHTTPRequest(t, "GET", testutil.ComputeURL+"/metrics", http.StatusUnauthorized, nil, false)
 
-	body := testHTTPRequest(t, "GET", url, httpExpectedStatus, data, validToken)
-	// stop evaluating in case the scenario is InvalidToken
-	if httpExpectedStatus == 401 {
-		return
-	}
+	body := string(testHTTPRequest(t, "GET", testutil.ComputeURL+"/metrics", http.StatusOK, nil, true))
 
-	var flavors payloads.ComputeFlavors
-	err = json.Unmarshal(body, &flavors)
-	if err != nil {
-		t.Fatal(err)
+	expected := []string{
+		`ciao_controller_http_requests_total{method="GET",route="/v2.1/{tenant}/os-keypairs",code="200"} `,
+		`ciao_controller_http_request_duration_seconds_count{method="GET",route="/v2.1/{tenant}/os-keypairs"} `,
+		`# TYPE ciao_controller_ssntp_frames_received_total counter`,
+		`ciao_controller_datastore_query_duration_seconds_bucket{operation="getAllKeyPairs",le="+Inf"} 1`,
+		`# TYPE ciao_controller_instances gauge`,
+		`# TYPE ciao_controller_node_memory_total_mb gauge`,
+		fmt.Sprintf(`ciao_controller_tenant_quota_usage{tenant="%s",resource="instances"} `, tenant.ID),
+		fmt.Sprintf(`ciao_controller_tenant_quota_limit{tenant="%s",resource="instances"} `, tenant.ID),
 	}
 
-	if len(flavors.Flavors) != len(wls) {
-		t.Fatal("Incorrect number of flavors returned")
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Fatalf("Metric %q not found in:\n%s", e, body)
+		}
 	}
 
-	var matched int
+	metricsToken = "scrapetoken"
+	defer func() { metricsToken = "" }()
 
-	for _, f := range flavors.Flavors {
-		for _, w := range wls {
-			if w.ID == f.ID && w.Description == f.Name {
-				matched++
-			}
+	for token, status := range map[string]int{
+		"Bearer scrapetoken": http.StatusOK,
+		"Bearer othertoken":  http.StatusUnauthorized,
+	} {
+		req, err := http.NewRequest("GET", testutil.ComputeURL+"/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
 		}
-	}
+		req.Header.Set("Authorization", token)
 
-	if matched != len(wls) {
-		t.Fatal("Flavor information didn't match workload information")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != status {
+			t.Fatalf("Expected %d with %q, got %d", status, token, resp.StatusCode)
+		}
 	}
 }
 
-func TestListFlavors(t *testing.T) {
-	testListFlavors(t, http.StatusOK, nil, true)
+func TestWebhooksInvalidToken(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/webhooks"
+	_ = testCreateWebhook(t, url, "https://example.com/hooks", nil, http.StatusUnauthorized, false)
+	_ = testHTTPRequest(t, "GET", url, http.StatusUnauthorized, nil, false)
 }
 
-func TestListFlavorsInvalidToken(t *testing.T) {
-	testListFlavors(t, http.StatusUnauthorized, nil, false)
+func TestCreateKeyPairInvalidToken(t *testing.T) {
+	_ = testCreateKeyPair(t, testutil.ComputeUser, "invalid", "", http.StatusUnauthorized, false)
 }
 
-func testShowFlavorDetails(t *testing.T, httpExpectedStatus int, validToken bool) {
-	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+func testTenantQuotas(t *testing.T, tenantID string) payloads.CiaoTenantResources {
+	url := testutil.ComputeURL + "/v2.1/" + tenantID + "/quotas"
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var resources payloads.CiaoTenantResources
+	err := json.Unmarshal(body, &resources)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tURL := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/flavors/"
+	return resources
+}
 
-	wls, err := context.ds.GetWorkloads()
+func TestUpdateTenantQuotas(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	for _, w := range wls {
-		details := payloads.FlavorDetails{
-			OsFlavorAccessIsPublic: true,
-			ID:   w.ID,
-			Disk: w.ImageID,
-			Name: w.Description,
-		}
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/quotas"
 
-		defaults := w.Defaults
-		for r := range defaults {
-			switch defaults[r].Type {
-			case payloads.VCPUs:
-				details.Vcpus = defaults[r].Value
-			case payloads.MemMB:
-				details.RAM = defaults[r].Value
-			}
-		}
+	_ = testHTTPRequest(t, "PUT", url, http.StatusBadRequest,
+		[]byte(`{"quota_set":{"vcpus":-2}}`), true)
+	_ = testHTTPRequest(t, "PUT", url, http.StatusUnauthorized,
+		[]byte(`{"quota_set":{"vcpus":4}}`), false)
 
-		url := tURL + w.ID
-		body := testHTTPRequest(t, "GET", url, httpExpectedStatus, nil, validToken)
-		// stop evaluating in case the scenario is InvalidToken
-		if httpExpectedStatus == 401 {
-			return
-		}
+	_ = testHTTPRequest(t, "PUT", url, http.StatusNoContent,
+		[]byte(`{"quota_set":{"instances":2,"vcpus":4,"volumes":0}}`), true)
 
-		var f payloads.ComputeFlavorDetails
+	quotas := testTenantQuotas(t, tenant.ID)
+	if quotas.InstanceLimit != 2 || quotas.VCPULimit != 4 ||
+		quotas.VolumeLimit != 0 || quotas.MemLimit != -1 {
+		t.Fatalf("Quotas not updated: %+v", quotas)
+	}
 
-		err = json.Unmarshal(body, &f)
-		if err != nil {
-			t.Fatal(err)
-		}
+	_ = testHTTPRequest(t, "DELETE", url, http.StatusNoContent, nil, true)
 
-		if reflect.DeepEqual(details, f.Flavor) == false {
-			t.Fatal("Flavor details not correct")
-		}
+	quotas = testTenantQuotas(t, tenant.ID)
+	if quotas.InstanceLimit != -1 || quotas.VCPULimit != -1 || quotas.VolumeLimit != -1 {
+		t.Fatalf("Quotas not deleted: %+v", quotas)
 	}
 }
 
-func TestShowFlavorDetails(t *testing.T) {
-	testShowFlavorDetails(t, http.StatusOK, true)
-}
+func TestUpdateTenantQuotasNotFound(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/" + uuid.Generate().String() + "/quotas"
 
-func TestShowFlavorDetailsInvalidToken(t *testing.T) {
-	testShowFlavorDetails(t, http.StatusUnauthorized, false)
+	_ = testHTTPRequest(t, "PUT", url, http.StatusNotFound,
+		[]byte(`{"quota_set":{"vcpus":4}}`), true)
 }
 
-func testListFlavorsDetails(t *testing.T, httpExpectedStatus int, data []byte, validToken bool) {
-	tenant, err := context.ds.GetTenant(testutil.ComputeUser)
+func TestDefaultQuotas(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/flavors/detail"
-	_ = testHTTPRequest(t, "GET", url, httpExpectedStatus, data, validToken)
-}
+	url := testutil.ComputeURL + "/v2.1/quotas/defaults"
 
-func TestListFlavorsDetails(t *testing.T) {
-	testListFlavorsDetails(t, http.StatusOK, nil, true)
+	_ = testHTTPRequest(t, "PUT", url, http.StatusNoContent,
+		[]byte(`{"quota_set":{"mem_mb":4096,"volumes":5}}`), true)
+
+	defer testHTTPRequest(t, "DELETE", url, http.StatusNoContent, nil, true)
+
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var defaults payloads.CiaoQuotaSet
+	err = json.Unmarshal(body, &defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *defaults.Quotas.MemMB != 4096 || *defaults.Quotas.Volumes != 5 ||
+		*defaults.Quotas.Instances != -1 {
+		t.Fatalf("Unexpected default quotas: %+v", defaults.Quotas)
+	}
+
+	// tenant limits override the defaults
+	tenantURL := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/quotas"
+	_ = testHTTPRequest(t, "PUT", tenantURL, http.StatusNoContent,
+		[]byte(`{"quota_set":{"volumes":-1}}`), true)
+
+	quotas := testTenantQuotas(t, tenant.ID)
+	if quotas.MemLimit != 4096 || quotas.VolumeLimit != -1 {
+		t.Fatalf("Default quotas not applied: %+v", quotas)
+	}
+
+	_ = testHTTPRequest(t, "PUT", url, http.StatusNoContent,
+		[]byte(`{"quota_set":{"mem_mb":-1}}`), true)
+
+	quotas = testTenantQuotas(t, tenant.ID)
+	if quotas.MemLimit != -1 {
+		t.Fatalf("Default quota not removed: %+v", quotas)
+	}
+
+	_ = testHTTPRequest(t, "DELETE", tenantURL, http.StatusNoContent, nil, true)
 }
 
-func TestListFlavorsDetailsInvalidToken(t *testing.T) {
-	testListFlavorsDetails(t, http.StatusUnauthorized, nil, false)
+func TestDefaultQuotasInvalidToken(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/quotas/defaults"
+	_ = testHTTPRequest(t, "GET", url, http.StatusUnauthorized, nil, false)
 }
 
 func testListTenantResources(t *testing.T, httpExpectedStatus int, validToken bool) {
@@ -724,6 +2530,10 @@ func testListTenantQuotas(t *testing.T, httpExpectedStatus int, validToken bool)
 		case disk:
 			expected.DiskLimit = resource.Limit
 			expected.DiskUsage = resource.Usage
+
+		case volumes:
+			expected.VolumeLimit = resource.Limit
+			expected.VolumeUsage = resource.Usage
 		}
 	}
 
@@ -804,6 +2614,111 @@ func TestListEventsTenant(t *testing.T) {
 	testListEventsTenant(t, http.StatusOK, true)
 }
 
+// testStreamEvent reads the events of an event stream until one is found
+// for an instance.
+func testStreamEvent(t *testing.T, stream *bufio.Reader, instanceID string) payloads.CiaoStreamEvent {
+	eventCh := make(chan payloads.CiaoStreamEvent)
+	errCh := make(chan error, 1)
+
+	go func() {
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var event payloads.CiaoStreamEvent
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if event.InstanceID == instanceID {
+				eventCh <- event
+				return
+			}
+		}
+	}()
+
+	select {
+	case event := <-eventCh:
+		return event
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("No event for instance %s", instanceID)
+	}
+
+	return payloads.CiaoStreamEvent{}
+}
+
+func testOpenEventStream(t *testing.T, lastEventID uint64) *http.Response {
+	url := testutil.ComputeURL + "/v2.1/" + testutil.ComputeUser + "/events/stream"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Auth-Token", "imavalidtoken")
+	if lastEventID > 0 {
+		req.Header.Set("Last-Event-ID", fmt.Sprintf("%d", lastEventID))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("Unexpected event stream response %s", resp.Status)
+	}
+
+	return resp
+}
+
+func TestStreamEvents(t *testing.T) {
+	resp := testOpenEventStream(t, 0)
+	defer resp.Body.Close()
+
+	servers := testCreateServer(t, 1)
+	instanceID := servers.Servers[0].ID
+
+	event := testStreamEvent(t, bufio.NewReader(resp.Body), instanceID)
+	if event.Kind != string(types.EventInstanceState) || event.State != payloads.Pending ||
+		event.TenantID != testutil.ComputeUser {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+
+	resumed := testOpenEventStream(t, event.ID-1)
+	defer resumed.Body.Close()
+
+	replayed := testStreamEvent(t, bufio.NewReader(resumed.Body), instanceID)
+	if replayed.ID != event.ID {
+		t.Fatalf("Expected event %d to be replayed, got %d", event.ID, replayed.ID)
+	}
+
+	// event 1 is from before the controller started
+	gap := testOpenEventStream(t, 1)
+	defer gap.Body.Close()
+
+	missed := testStreamEvent(t, bufio.NewReader(gap.Body), "")
+	if missed.Kind != string(types.EventGap) {
+		t.Fatalf("Expected a gap event, got %+v", missed)
+	}
+}
+
+func TestStreamEventsInvalidToken(t *testing.T) {
+	url := testutil.ComputeURL + "/v2.1/" + testutil.ComputeUser + "/events/stream"
+	_ = testHTTPRequest(t, "GET", url, http.StatusUnauthorized, nil, false)
+}
+
 func testListNodeServers(t *testing.T, httpExpectedStatus int, validToken bool) {
 	computeNodes := context.ds.GetNodeLastStats()
 
@@ -1004,13 +2919,12 @@ func testListCNCIs(t *testing.T, httpExpectedStatus int, validToken bool) {
 		t.Fatal(err)
 	}
 
-	var subnets []payloads.CiaoCNCISubnet
-
 	for _, cnci := range cncis {
 		if cnci.InstanceID == "" {
 			continue
 		}
 
+		var subnets []payloads.CiaoCNCISubnet
 		for _, subnet := range cnci.Subnets {
 			subnets = append(subnets,
 				payloads.CiaoCNCISubnet{
@@ -1057,6 +2971,53 @@ func TestListCNCIsInvalidToken(t *testing.T) {
 	testListCNCIs(t, http.StatusUnauthorized, false)
 }
 
+// TestListCNCIsSubnets checks that each CNCI is listed with the subnets of
+// its own tenant only.
+func TestListCNCIsSubnets(t *testing.T) {
+	tenants := make(map[string]bool)
+
+	for i := 0; i < 2; i++ {
+		tenant, err := addTestTenant()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = context.ds.AllocateTenantIP(tenant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tenants[tenant.ID] = true
+	}
+
+	url := testutil.ComputeURL + "/v2.1/cncis"
+
+	body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+	var result payloads.CiaoCNCIs
+
+	err := json.Unmarshal(body, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := 0
+	for _, cnci := range result.CNCIs {
+		if !tenants[cnci.TenantID] {
+			continue
+		}
+
+		if len(cnci.Subnets) != 1 {
+			t.Fatalf("CNCI %s listed with subnets %+v", cnci.ID, cnci.Subnets)
+		}
+		listed++
+	}
+
+	if listed != len(tenants) {
+		t.Fatalf("Expected %d CNCIs, got %d", len(tenants), listed)
+	}
+}
+
 func testListCNCIDetails(t *testing.T, httpExpectedStatus int, validToken bool) {
 	cncis, err := context.ds.GetTenantCNCISummary("")
 	if err != nil {
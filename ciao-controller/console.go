@@ -0,0 +1,241 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// consoleTokenTTL is how long the URL returned by os-getSerialConsole can
+// be used to attach to the console of an instance.
+const consoleTokenTTL = 10 * time.Minute
+
+var errSerialConsoleDisabled = errors.New("Serial consoles are disabled")
+
+var consoleUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+type consoleToken struct {
+	tenantID   string
+	instanceID string
+	expires    time.Time
+}
+
+// consoleTokens holds the single use tokens authenticating WebSocket
+// console connections, which cannot carry the keystone token of the user.
+type consoleTokens struct {
+	sync.Mutex
+	tokens map[string]consoleToken
+}
+
+func (ct *consoleTokens) add(tenantID string, instanceID string) string {
+	ct.Lock()
+	defer ct.Unlock()
+
+	if ct.tokens == nil {
+		ct.tokens = make(map[string]consoleToken)
+	}
+
+	now := time.Now()
+	for token, t := range ct.tokens {
+		if now.After(t.expires) {
+			delete(ct.tokens, token)
+		}
+	}
+
+	token := uuid.Generate().String()
+	ct.tokens[token] = consoleToken{
+		tenantID:   tenantID,
+		instanceID: instanceID,
+		expires:    now.Add(consoleTokenTTL),
+	}
+
+	return token
+}
+
+func (ct *consoleTokens) consume(token string, tenantID string, instanceID string) bool {
+	ct.Lock()
+	defer ct.Unlock()
+
+	t, ok := ct.tokens[token]
+	if !ok {
+		return false
+	}
+	delete(ct.tokens, token)
+
+	return t.tenantID == tenantID && t.instanceID == instanceID &&
+		time.Now().Before(t.expires)
+}
+
+func (c *controller) getSerialConsole(tenantID string, instanceID string) (string, error) {
+	if launcherConsolePort == 0 {
+		return "", errSerialConsoleDisabled
+	}
+
+	_, err := c.getActionInstance(instanceID, payloads.Running, payloads.Paused)
+	if err != nil {
+		return "", err
+	}
+
+	return c.consoles.add(tenantID, instanceID), nil
+}
+
+func (c *controller) attachSerialConsole(instanceID string) (net.Conn, error) {
+	i, err := c.getActionInstance(instanceID, payloads.Running, payloads.Paused)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := c.ds.GetNode(i.NodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.IPAddr == "" {
+		return nil, fmt.Errorf("No IP address for node %s", i.NodeID)
+	}
+
+	return c.client.AttachConsole(instanceID, node.IPAddr)
+}
+
+func serverSerialConsole(w http.ResponseWriter, r *http.Request, context *controller,
+	tenant string, instance string, body []byte) {
+	var consoleAction payloads.ComputeSerialConsoleAction
+
+	err := json.Unmarshal(body, &consoleAction)
+	if err != nil || consoleAction.GetSerialConsole == nil {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid serial console action")
+		return
+	}
+
+	if consoleAction.GetSerialConsole.Type != "serial" {
+		returnErrorCode(w, http.StatusBadRequest, "Unsupported console type")
+		return
+	}
+
+	token, err := context.getSerialConsole(tenant, instance)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == errInstanceState {
+			code = http.StatusConflict
+		} else if err == errSerialConsoleDisabled {
+			code = http.StatusServiceUnavailable
+		}
+		returnErrorCode(w, code, "%v", err)
+		return
+	}
+
+	console := payloads.ComputeSerialConsoleResponse{
+		Console: payloads.ComputeConsole{
+			Type: "serial",
+			URL: fmt.Sprintf("wss://%s/v2.1/%s/servers/%s/console?token=%s",
+				r.Host, tenant, instance, token),
+		},
+	}
+
+	b, err := json.Marshal(console)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serverConsole upgrades an HTTP request to a WebSocket connection proxied
+// to the serial console of an instance.  The request is authenticated by
+// the token of a URL returned by os-getSerialConsole.
+func serverConsole(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	instance := vars["server"]
+
+	token := r.URL.Query().Get("token")
+	if !context.consoles.consume(token, tenant, instance) {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid console token")
+		return
+	}
+
+	conn, err := context.attachSerialConsole(instance)
+	if err != nil {
+		code := http.StatusBadGateway
+		if err == errInstanceState {
+			code = http.StatusConflict
+		}
+		returnErrorCode(w, code, "%v", err)
+		return
+	}
+
+	ws, err := consoleUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		glog.Warningf("Unable to upgrade console connection of %s: %v", instance, err)
+		_ = conn.Close()
+		return
+	}
+
+	proxyConsole(ws, conn)
+	glog.Infof("Console of instance %s detached", instance)
+}
+
+// proxyConsole copies the WebSocket messages received from the user to the
+// console connection, and the console output to the user as binary
+// messages, until either side is closed.
+func proxyConsole(ws *websocket.Conn, conn net.Conn) {
+	go func() {
+		defer func() { _ = conn.Close() }()
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			_, err = conn.Write(data)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	defer func() { _ = ws.Close() }()
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+			if werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
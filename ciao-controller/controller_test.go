@@ -17,12 +17,17 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -35,6 +40,7 @@ import (
 	"github.com/01org/ciao/ssntp"
 	"github.com/01org/ciao/ssntp/uuid"
 	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
 )
 
 func addTestTenant() (tenant *types.Tenant, err error) {
@@ -106,7 +112,7 @@ func BenchmarkStartSingleWorkload(b *testing.B) {
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1, false, "")
+		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1, false, "", false, nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -141,7 +147,7 @@ func BenchmarkStart1000Workload(b *testing.B) {
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1000, false, "")
+		_, err = context.startWorkload(wls[0].ID, tuuid.String(), 1000, false, "", false, nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -166,13 +172,121 @@ func BenchmarkNewConfig(b *testing.B) {
 
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		_, err := newConfig(context, wls[0], id.String(), tenant.ID)
+		_, err := newConfig(context, wls[0], id.String(), tenant.ID, &instanceOptions{})
 		if err != nil {
 			b.Error(err)
 		}
 	}
 }
 
+func TestHostnameFromName(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+	}{
+		{"web-1", "web-1"},
+		{"My Server_2", "my-server-2"},
+		{"--odd--", "odd"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		hostname := hostnameFromName(test.name)
+		if hostname != test.hostname {
+			t.Errorf("Expected %q for %q, got %q", test.hostname, test.name, hostname)
+		}
+	}
+}
+
+func TestMergeUserData(t *testing.T) {
+	base := "---\n#cloud-config\nusers:\n  - name: demouser\nhostname: base\n...\n"
+	userData := "#cloud-config\nusers:\n  - name: other\nhostname: custom\n"
+
+	err := validateUserData(userData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := mergeUserData(base, userData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config struct {
+		Users []struct {
+			Name string `yaml:"name"`
+		} `yaml:"users"`
+		Hostname string `yaml:"hostname"`
+	}
+
+	err = yaml.Unmarshal([]byte(merged), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Users) != 2 || config.Hostname != "custom" {
+		t.Fatalf("User data not merged correctly:\n%s", merged)
+	}
+
+	if validateUserData("#!/bin/sh\n") == nil {
+		t.Fatal("Expected error for non cloud-config user data")
+	}
+}
+
+func TestInjectSSHKey(t *testing.T) {
+	public, private, err := generateKeyPair("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(private, "RSA PRIVATE KEY") {
+		t.Fatal("Invalid private key generated")
+	}
+
+	key, fingerprint, err := parseSSHPublicKey(public + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != public || len(fingerprint) != 47 {
+		t.Fatalf("Invalid public key parsing: %q %q", key, fingerprint)
+	}
+
+	_, _, err = parseSSHPublicKey("ssh-dss " + strings.Fields(public)[1])
+	if err == nil {
+		t.Fatal("Expected error for mismatched key type")
+	}
+
+	_, _, err = parseSSHPublicKey("not a key")
+	if err == nil {
+		t.Fatal("Expected error for invalid key")
+	}
+
+	base := "---\n#cloud-config\nusers:\n  - name: demouser\n    ssh-authorized-keys:\n      - ssh-rsa AAAA\n...\n"
+
+	injected, err := injectSSHKey(base, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config struct {
+		Users []struct {
+			Keys []string `yaml:"ssh-authorized-keys"`
+		} `yaml:"users"`
+		Keys []string `yaml:"ssh_authorized_keys"`
+	}
+
+	err = yaml.Unmarshal([]byte(injected), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Users) != 1 || len(config.Users[0].Keys) != 2 ||
+		config.Users[0].Keys[1] != key || len(config.Keys) != 1 {
+		t.Fatalf("Key not injected correctly:\n%s", injected)
+	}
+}
+
 func TestTenantWithinBounds(t *testing.T) {
 	var err error
 
@@ -192,7 +306,7 @@ func TestTenantWithinBounds(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = context.startWorkload(wls[0].ID, tenant.ID, 1, false, "")
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 1, false, "", false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -216,12 +330,81 @@ func TestTenantOutOfBounds(t *testing.T) {
 	}
 
 	/* try to send 2 workload start commands */
-	_, err = context.startWorkload(wls[0].ID, tenant.ID, 2, false, "")
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 2, false, "", false, nil)
 	if err == nil {
 		t.Errorf("Not tracking limits correctly")
 	}
 }
 
+func TestStartWorkloadBatch(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.StartBatch)
+
+	instances, err := context.startWorkload(wls[0].ID, tenant.ID, 2, false, "", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("Wrong number of instances, expected 2, got %d", len(instances))
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.StartBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartWorkloadGangOutOfBounds(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* put tenant limit of 1 instance */
+	err = context.ds.AddLimit(tenant.ID, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := context.ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 2, true, "gang", true, nil)
+	if err != errTracedGang {
+		t.Fatalf("Expected %v, got %v", errTracedGang, err)
+	}
+
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 2, false, "", true, nil)
+	if err != errOverLimits {
+		t.Fatalf("Expected %v, got %v", errOverLimits, err)
+	}
+
+	// none of the instances of the gang may be left pending
+	instances, err := context.ds.GetAllInstancesFromTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 0 {
+		t.Fatalf("%d instances of a rejected gang were kept", len(instances))
+	}
+
+	_, err = context.startWorkload(wls[0].ID, tenant.ID, 1, false, "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestNewTenantHardwareAddr
 // Confirm that the mac addresses generated from a given
 // IP address is as expected.
@@ -411,7 +594,7 @@ func TestEvacuateNode(t *testing.T) {
 
 	// ok to not send workload first?
 
-	err = context.evacuateNode(client.UUID)
+	err = context.evacuateNode(client.UUID, payloads.NodeMaintenance)
 	if err != nil {
 		t.Error(err)
 	}
@@ -425,6 +608,29 @@ func TestEvacuateNode(t *testing.T) {
 	}
 }
 
+func TestRestoreNode(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("RestoreNode", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	serverCh := server.AddCmdChan(ssntp.RESTORE)
+
+	err = context.restoreNode(client.UUID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.RESTORE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+}
+
 func TestAttachVolume(t *testing.T) {
 	client, err := testutil.NewSsntpTestClientConnection("AttachVolume", ssntp.AGENT, testutil.AgentUUID)
 	if err != nil {
@@ -739,16 +945,153 @@ func TestInstanceDeletedEvent(t *testing.T) {
 	if err == nil {
 		t.Error("Instance not deleted")
 	}
+
+	task := testGetTask(t, instances[0].ID)
+	if task.Operation != taskDeleteInstance || task.Status != payloads.TaskCompleted ||
+		task.Progress != 100 || len(task.Frames) != 2 ||
+		task.Frames[0].Frame != ssntp.DELETE.String() || task.Frames[0].Direction != frameSent ||
+		task.Frames[1].Frame != ssntp.InstanceDeleted.String() || task.Frames[1].Direction != frameReceived {
+		t.Fatalf("Unexpected delete task %+v", task)
+	}
+}
+
+func testGetTask(t *testing.T, resource string) payloads.Task {
+	location := context.TaskLocation(resource)
+	if location == "" {
+		t.Fatalf("No task for %s", resource)
+	}
+
+	task, err := context.tasks.get(strings.TrimPrefix(location, "/v2.1/tasks/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return task
+}
+
+func TestTaskNoResources(t *testing.T) {
+	var ts tasks
+
+	task, err := ts.get(ts.add(taskDeleteTenant, testutil.ComputeUser, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if task.Status != payloads.TaskCompleted || task.Progress != 100 {
+		t.Fatalf("Unexpected task without resources %+v", task)
+	}
+}
+
+func TestPublicIPAssignedEvent(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	err := context.ds.SetFloatingIPPool([]string{"198.51.100.11"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	instance := instances[0]
+
+	tenant, err := context.ds.GetTenant(instance.TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := context.ds.AllocateFloatingIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.AssignPublicIP)
+
+	err = context.associateFloatingIP(tenant.ID, instance.ID, ip.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.AssignPublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnci, err := testutil.NewSsntpTestClientConnection("PublicIPAssigned", ssntp.CNCIAGENT, tenant.CNCIID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cnci.Shutdown()
+
+	event := payloads.EventPublicIPAssigned{
+		AssignedIP: payloads.PublicIPEvent{
+			ConcentratorUUID: tenant.CNCIID,
+			InstanceUUID:     instance.ID,
+			PublicIP:         ip.Address,
+			PrivateIP:        instance.IPAddress,
+		},
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverEvtCh := server.AddEventChan(ssntp.PublicIPAssigned)
+	_, err = cnci.Ssntp.SendEvent(ssntp.PublicIPAssigned, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetEventChanResult(serverEvtCh, ssntp.PublicIPAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	i, err := context.ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.PublicIP != ip.Address {
+		t.Fatalf("Expected public IP %s, got %s", ip.Address, i.PublicIP)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.ReleasePublicIP)
+
+	err = context.releaseFloatingIP(ip.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.ReleasePublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(context.ds.GetFloatingIPs(tenant.ID)) != 0 {
+		t.Fatal("Floating IP not released")
+	}
 }
 
 func TestStartFailure(t *testing.T) {
 	reason := payloads.FullCloud
 
-	client, _ := testStartWorkload(t, 1, true, reason)
+	client, instances := testStartWorkload(t, 1, true, reason)
 	defer client.Shutdown()
 
 	// since we had a start failure, we should confirm that the
 	// instance is no longer pending in the database
+
+	time.Sleep(1 * time.Second)
+
+	task := testGetTask(t, instances[0].ID)
+	if task.Operation != taskStartInstances || task.Status != payloads.TaskFailed ||
+		len(task.Errors) != 1 {
+		t.Fatalf("Unexpected start task %+v", task)
+	}
 }
 
 func TestStopFailure(t *testing.T) {
@@ -907,7 +1250,7 @@ func testStartTracedWorkload(t *testing.T) *testutil.SsntpTestClient {
 	clientCh := client.AddCmdChan(ssntp.START)
 	serverCh := server.AddCmdChan(ssntp.START)
 
-	instances, err := context.startWorkload(wls[0].ID, tenant.ID, 1, true, "testtrace1")
+	instances, err := context.startWorkload(wls[0].ID, tenant.ID, 1, true, "testtrace1", false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -957,7 +1300,7 @@ func testStartWorkload(t *testing.T, num int, fail bool, reason payloads.StartFa
 	client.StartFail = fail
 	client.StartFailReason = reason
 
-	instances, err := context.startWorkload(wls[0].ID, tenant.ID, num, false, "")
+	instances, err := context.startWorkload(wls[0].ID, tenant.ID, num, false, "", false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1012,7 +1355,7 @@ func testStartWorkloadLaunchCNCI(t *testing.T, num int) (*testutil.SsntpTestClie
 	instanceCh := make(chan []*types.Instance)
 
 	go func() {
-		instances, err := context.startWorkload(wls[0].ID, newTenant, 1, false, "")
+		instances, err := context.startWorkload(wls[0].ID, newTenant, 1, false, "", false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -1156,6 +1499,42 @@ func TestCreateVolume(t *testing.T) {
 	}
 }
 
+func TestVolumeQuota(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = context.ds.AddLimit(tenant.ID, volumes, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	_, err = context.CreateVolume(tenant.ID, block.RequestedVolume{Size: 20})
+	if err != block.ErrQuota {
+		t.Fatalf("Expected quota error, got %v", err)
+	}
+
+	limits, err := context.GetAbsoluteLimits(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limits.MaxTotalVolumes != 1 || limits.TotalVolumesUsed != 1 ||
+		limits.TotalGigabytesUsed != 20 {
+		t.Fatalf("Unexpected absolute limits: %+v", limits)
+	}
+
+	err = context.DeleteVolume(tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = createTestVolume(tenant.ID, 20, t)
+}
+
 func TestDeleteVolume(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1255,6 +1634,163 @@ var testClients []*testutil.SsntpTestClient
 var context *controller
 var server *testutil.SsntpTestServer
 
+func TestDeliverWebhook(t *testing.T) {
+	var requests []*http.Request
+	var bodies [][]byte
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer hook.Close()
+
+	webhook := types.Webhook{
+		ID:         uuid.Generate().String(),
+		TenantID:   "tenant",
+		URL:        hook.URL,
+		EventTypes: []types.EventKind{types.EventStartFailure},
+		Secret:     "mysecret",
+	}
+
+	e := types.Event{
+		ID:       42,
+		Kind:     types.EventStartFailure,
+		TenantID: "tenant",
+		Message:  "Start failure",
+	}
+
+	if !webhookMatches(webhook, e) {
+		t.Fatal("Event not matched")
+	}
+
+	e.TenantID = "other"
+	if webhookMatches(webhook, e) {
+		t.Fatal("Event of another tenant matched")
+	}
+	e.TenantID = "tenant"
+
+	// the webhooks of the tenants cannot reach the local test server.
+	_, err := postWebhook(webhook, "delivery", e.Kind, []byte("{}"))
+	if err == nil || len(requests) != 0 {
+		t.Fatal("Tenant webhook delivered to a loopback address")
+	}
+	webhook.TenantID = ""
+
+	context.deliverWebhook(webhook, e, 3, time.Millisecond)
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(requests))
+	}
+
+	r := requests[1]
+	if r.Header.Get(webhookEventHeader) != string(types.EventStartFailure) ||
+		r.Header.Get(webhookDeliveryHeader) != requests[0].Header.Get(webhookDeliveryHeader) {
+		t.Fatalf("Unexpected delivery headers: %v", r.Header)
+	}
+
+	if r.Header.Get(webhookSignatureHeader) != webhookSignature("mysecret", bodies[1]) {
+		t.Fatal("Invalid delivery signature")
+	}
+
+	var event payloads.CiaoStreamEvent
+	err = json.Unmarshal(bodies[1], &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.ID != 42 || event.Message != "Start failure" {
+		t.Fatalf("Unexpected event delivered: %+v", event)
+	}
+
+	deliveries, err := context.ds.GetWebhookDeliveries(webhook.ID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 2 || deliveries[0].Delivered ||
+		deliveries[0].StatusCode != http.StatusServiceUnavailable ||
+		!deliveries[1].Delivered || deliveries[1].Attempt != 2 {
+		t.Fatalf("Unexpected delivery log: %+v", deliveries)
+	}
+}
+
+func TestDispatchWebhookEvents(t *testing.T) {
+	var lock sync.Mutex
+	var received []uint64
+
+	delivered := make(chan struct{}, 10)
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event payloads.CiaoStreamEvent
+
+		err := json.NewDecoder(r.Body).Decode(&event)
+		if err == nil {
+			lock.Lock()
+			received = append(received, event.ID)
+			lock.Unlock()
+		}
+		delivered <- struct{}{}
+	}))
+	defer hook.Close()
+
+	webhook := types.Webhook{
+		ID:         uuid.Generate().String(),
+		URL:        hook.URL,
+		EventTypes: []types.EventKind{types.EventStartFailure},
+	}
+
+	err := context.ds.AddWebhook(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workers := make(map[string]*webhookWorker)
+
+	for id := uint64(1); id <= 5; id++ {
+		context.dispatchEvent(workers, types.Event{
+			ID:       id,
+			Kind:     types.EventStartFailure,
+			TenantID: "dispatch",
+		})
+	}
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Events not delivered")
+		}
+	}
+
+	lock.Lock()
+	for i, id := range received {
+		if id != uint64(i+1) {
+			t.Fatalf("Events delivered out of order: %v", received)
+		}
+	}
+	lock.Unlock()
+
+	if workers[webhook.ID] == nil {
+		t.Fatal("No worker for the webhook")
+	}
+
+	err = context.ds.DeleteWebhook(webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context.dispatchEvent(workers, types.Event{ID: 6, Kind: types.EventStartFailure})
+
+	if workers[webhook.ID] != nil {
+		t.Fatal("Worker of a deleted webhook not stopped")
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 
@@ -0,0 +1,234 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+var errImageNotReady = errors.New("Image is not active")
+
+// createImage asks the node running an instance to snapshot its rootfs into
+// a new image owned by the tenant of the instance.  The image is recorded
+// in the saving state until the node reports the outcome.  No image can be
+// created while the tenant is being deleted.
+func (c *controller) createImage(instanceID string, name string) (types.Image, error) {
+	i, err := c.getActionInstance(instanceID, payloads.Running, payloads.Paused,
+		payloads.Exited)
+	if err != nil {
+		return types.Image{}, err
+	}
+
+	err = c.deletions.create(i.TenantID)
+	if err != nil {
+		return types.Image{}, err
+	}
+	defer c.deletions.created(i.TenantID)
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return types.Image{}, err
+	}
+
+	if wl.VMType != payloads.QEMU {
+		return types.Image{}, errors.New("Images can only be created from VMs")
+	}
+
+	image := types.Image{
+		ID:         uuid.Generate().String(),
+		TenantID:   i.TenantID,
+		Name:       name,
+		InstanceID: instanceID,
+		State:      types.ImageSaving,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	err = c.ds.UpdateImage(image)
+	if err != nil {
+		return types.Image{}, err
+	}
+
+	err = c.client.CreateImage(instanceID, i.NodeID, image.ID)
+	if err != nil {
+		image.State = types.ImageError
+		_ = c.ds.UpdateImage(image)
+		return types.Image{}, err
+	}
+
+	return image, nil
+}
+
+// imageCreated records the outcome of a CreateImage command.
+func (c *controller) imageCreated(event payloads.ImageCreatedEvent) {
+	image, err := c.ds.GetImage(event.ImageUUID)
+	if err != nil {
+		glog.Warningf("Image %s created for unknown image", event.ImageUUID)
+		return
+	}
+
+	if event.Error != "" {
+		glog.Errorf("Unable to create image %s from %s: %s", image.ID,
+			event.InstanceUUID, event.Error)
+		image.State = types.ImageError
+	} else {
+		glog.Infof("Image %s created from %s", image.ID, event.InstanceUUID)
+		image.State = types.ImageActive
+		image.Size = event.Size
+	}
+
+	err = c.ds.UpdateImage(image)
+	if err != nil {
+		glog.Warningf("Unable to update image %s: %v", image.ID, err)
+	}
+}
+
+// checkImage verifies that an image created from an instance can be used
+// by a tenant.  Admins, identified by an empty tenantID, can use any active
+// image.  Images which were not created from instances are not checked.
+func (c *controller) checkImage(tenantID string, imageID string) error {
+	image, err := c.ds.GetImage(imageID)
+	if err == datastore.ErrNoImage {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if tenantID != "" && image.TenantID != tenantID {
+		return fmt.Errorf("Unknown image %s", imageID)
+	}
+
+	if image.State != types.ImageActive {
+		return errImageNotReady
+	}
+
+	return nil
+}
+
+func imageToPayload(image types.Image) payloads.ComputeImage {
+	return payloads.ComputeImage{
+		ID:      image.ID,
+		Name:    image.Name,
+		Status:  strings.ToUpper(image.State),
+		Created: image.CreatedAt,
+		Size:    image.Size,
+		Server:  payloads.ComputeImageServer{ID: image.InstanceID},
+	}
+}
+
+func serverCreateImage(w http.ResponseWriter, context *controller, tenant string, instance string, body []byte) {
+	var imageAction payloads.ComputeCreateImageAction
+
+	err := json.Unmarshal(body, &imageAction)
+	if err != nil || imageAction.CreateImage == nil {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid create image action")
+		return
+	}
+
+	name := imageAction.CreateImage.Name
+	if name == "" {
+		returnErrorCode(w, http.StatusBadRequest, "Missing image name")
+		return
+	}
+
+	image, err := context.createImage(instance, name)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == errInstanceState || err == errTenantDeleting {
+			code = http.StatusConflict
+		}
+		returnErrorCode(w, code, "%v", err)
+		return
+	}
+
+	b, err := json.Marshal(payloads.ComputeCreateImageResponse{ImageID: image.ID})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2.1/%s/images/%s", tenant, image.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(b)
+}
+
+func listImages(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	images := payloads.NewComputeImages()
+
+	for _, i := range context.ds.GetImages(tenant) {
+		images.Images = append(images.Images, imageToPayload(i))
+	}
+
+	b, err := json.Marshal(images)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func showImage(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	imageID := vars["image"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	image, err := context.ds.GetImage(imageID)
+	if err != nil || image.TenantID != tenant {
+		returnErrorCode(w, http.StatusNotFound, "%v", datastore.ErrNoImage)
+		return
+	}
+
+	b, err := json.Marshal(payloads.ComputeImageResponse{Image: imageToPayload(image)})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
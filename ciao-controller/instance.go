@@ -18,9 +18,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/ciao-storage"
@@ -45,6 +48,30 @@ type instance struct {
 	startTime time.Time
 }
 
+// instanceOptions contains the settings of a server creation
+// request which are not part of the workload definition.
+type instanceOptions struct {
+	name     string
+	imageID  string
+	metadata map[string]string
+
+	// userData is a decoded cloud-config document which
+	// is merged with the cloud-init template of the workload.
+	userData string
+
+	// securityGroups contains the IDs of the security groups
+	// the instance belongs to.
+	securityGroups []string
+
+	// keyName is the name of the tenant keypair whose public
+	// key, sshKey, is injected into the cloud-init users.
+	keyName string
+	sshKey  string
+
+	// recoveryPolicy overrides the recovery policy of the workload.
+	recoveryPolicy types.RecoveryPolicy
+}
+
 func isCNCIWorkload(workload *types.Workload) bool {
 	for r := range workload.Defaults {
 		if workload.Defaults[r].Type == payloads.NetworkNode {
@@ -55,10 +82,39 @@ func isCNCIWorkload(workload *types.Workload) bool {
 	return false
 }
 
-func newInstance(context *controller, tenantID string, workload *types.Workload) (*instance, error) {
+// recoveryPolicy returns the recovery policy of a new instance of a
+// workload.
+func recoveryPolicy(wl *types.Workload, opts *instanceOptions) types.RecoveryPolicy {
+	if opts.recoveryPolicy != "" {
+		return opts.recoveryPolicy
+	}
+
+	if wl.RecoveryPolicy != "" {
+		return wl.RecoveryPolicy
+	}
+
+	return types.RecoveryNone
+}
+
+// persistence returns the persistence of the instances recovered with
+// a policy.  Instances persist with their host, unless they are recovered
+// by rescheduling them on other nodes.
+func persistence(policy types.RecoveryPolicy) payloads.Persistence {
+	if policy == types.RecoveryReschedule {
+		return payloads.All
+	}
+
+	return payloads.Host
+}
+
+func newInstance(context *controller, tenantID string, workload *types.Workload, opts *instanceOptions) (*instance, error) {
 	id := uuid.Generate()
 
-	config, err := newConfig(context, workload, id.String(), tenantID)
+	if opts == nil {
+		opts = &instanceOptions{}
+	}
+
+	config, err := newConfig(context, workload, id.String(), tenantID, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -68,12 +124,23 @@ func newInstance(context *controller, tenantID string, workload *types.Workload)
 	newInstance := types.Instance{
 		TenantID:   tenantID,
 		WorkloadID: workload.ID,
+		ImageID:    workload.ImageID,
+		Name:       opts.name,
+		Metadata:   opts.metadata,
+		KeyName:    opts.keyName,
 		State:      payloads.Pending,
 		ID:         id.String(),
 		CNCI:       config.cnci,
 		IPAddress:  config.ip,
 		MACAddress: config.mac,
 		Usage:      usage,
+
+		RecoveryPolicy: recoveryPolicy(workload, opts),
+		StartConfig:    config.config,
+	}
+
+	if len(opts.securityGroups) > 0 {
+		newInstance.SecurityGroups = append([]string(nil), opts.securityGroups...)
 	}
 
 	i := &instance{
@@ -125,6 +192,11 @@ func (i *instance) Allowed() (bool, error) {
 			}
 			continue
 		}
+		// resources the instance does not use cannot put
+		// the tenant over its limits.
+		if i.Usage[res.Rname] == 0 {
+			continue
+		}
 		if res.OverLimit(i.Usage[res.Rname]) {
 			return false, nil
 		}
@@ -185,10 +257,91 @@ func getStorage(c *controller, wl *types.Workload, tenant string) (payloads.Stor
 	return payloads.StorageResources{ID: bd.ID, Bootable: s.Bootable}, nil
 }
 
-func newConfig(context *controller, wl *types.Workload, instanceID string, tenantID string) (config, error) {
+// hostnameFromName turns an instance name into a valid host name.
+func hostnameFromName(name string) string {
+	hostname := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, name)
+
+	if len(hostname) > 63 {
+		hostname = hostname[:63]
+	}
+
+	return strings.Trim(hostname, "-")
+}
+
+func parseCloudConfig(data string) (map[interface{}]interface{}, error) {
+	cloudConfig := make(map[interface{}]interface{})
+
+	err := yaml.Unmarshal([]byte(data), &cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return cloudConfig, nil
+}
+
+// validateUserData checks that the user data of a server creation
+// request can be merged with a cloud-init template.
+func validateUserData(userData string) error {
+	if !strings.HasPrefix(userData, "#cloud-config") {
+		return errors.New("Only #cloud-config user data is supported")
+	}
+
+	_, err := parseCloudConfig(userData)
+
+	return err
+}
+
+// mergeUserData merges the user data of a server creation request
+// into the cloud-init template of a workload.  Lists defined in both
+// are concatenated, other values from the user data take precedence.
+func mergeUserData(baseConfig string, userData string) (string, error) {
+	base, err := parseCloudConfig(baseConfig)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := parseCloudConfig(userData)
+	if err != nil {
+		return "", err
+	}
+
+	for k, v := range user {
+		baseList, baseIsList := base[k].([]interface{})
+		userList, userIsList := v.([]interface{})
+		if baseIsList && userIsList {
+			base[k] = append(baseList, userList...)
+		} else {
+			base[k] = v
+		}
+	}
+
+	return marshalCloudConfig(base)
+}
+
+// marshalCloudConfig returns a cloud-init configuration document in
+// the format of the workload cloud-init templates.
+func marshalCloudConfig(cloudConfig map[interface{}]interface{}) (string, error) {
+	y, err := yaml.Marshal(cloudConfig)
+	if err != nil {
+		return "", err
+	}
+
+	return "---\n#cloud-config\n" + string(y) + "...\n", nil
+}
+
+func newConfig(context *controller, wl *types.Workload, instanceID string, tenantID string, opts *instanceOptions) (config, error) {
 	type UserData struct {
-		UUID     string `json:"uuid"`
-		Hostname string `json:"hostname"`
+		UUID     string            `json:"uuid"`
+		Hostname string            `json:"hostname"`
+		Meta     map[string]string `json:"meta,omitempty"`
 	}
 
 	var userData UserData
@@ -206,6 +359,13 @@ func newConfig(context *controller, wl *types.Workload, instanceID string, tenan
 
 	config.cnci = isCNCIWorkload(wl)
 
+	if !config.cnci && opts.sshKey != "" {
+		baseConfig, err = injectSSHKey(baseConfig, opts.sshKey)
+		if err != nil {
+			return config, err
+		}
+	}
+
 	var networking payloads.NetworkResources
 	var storage payloads.StorageResources
 
@@ -238,7 +398,11 @@ func newConfig(context *controller, wl *types.Workload, instanceID string, tenan
 
 		// set the hostname and uuid for userdata
 		userData.UUID = instanceID
-		userData.Hostname = instanceID
+		userData.Hostname = hostnameFromName(opts.name)
+		if userData.Hostname == "" {
+			userData.Hostname = instanceID
+		}
+		userData.Meta = opts.metadata
 
 		// handle storage resources
 		if wl.Storage != nil {
@@ -257,16 +421,15 @@ func newConfig(context *controller, wl *types.Workload, instanceID string, tenan
 		userData.Hostname = "cnci-" + tenantID
 	}
 
-	// hardcode persistence until changes can be made to workload
-	// template datastore.  Estimated resources can be blank
-	// for now because we don't support it yet.
+	// Estimated resources can be blank for now because we
+	// don't support it yet.
 	startCmd := payloads.StartCmd{
 		TenantUUID:          tenantID,
 		InstanceUUID:        instanceID,
 		ImageUUID:           imageID,
 		FWType:              payloads.Firmware(fwType),
 		VMType:              wl.VMType,
-		InstancePersistence: payloads.Host,
+		InstancePersistence: persistence(recoveryPolicy(wl, opts)),
 		RequestedResources:  defaults,
 		Networking:          networking,
 		Storage:             storage,
@@ -26,9 +26,11 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/01org/ciao/ciao-controller/internal/metrics"
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp/uuid"
@@ -38,8 +40,28 @@ import (
 // custom errors
 var (
 	ErrNoTenant            = errors.New("Tenant not found")
+	ErrTenantInUse         = errors.New("Tenant has instances or volumes")
 	ErrNoBlockData         = errors.New("Block Device not found")
 	ErrNoStorageAttachment = errors.New("No Volume Attached")
+	ErrNoWorkload          = errors.New("Workload not found")
+	ErrWorkloadExists      = errors.New("Workload already exists")
+	ErrWorkloadInUse       = errors.New("Workload has instances")
+	ErrNoFloatingIP        = errors.New("Floating IP not found")
+	ErrNoFreeFloatingIP    = errors.New("No floating IP available")
+	ErrFloatingIPInUse     = errors.New("Floating IP is associated with an instance")
+	ErrFloatingIPNotInUse  = errors.New("Floating IP is not associated with an instance")
+	ErrInstanceFloatingIP  = errors.New("Instance already has a floating IP")
+	ErrNoSecurityGroup     = errors.New("Security group not found")
+	ErrSecurityGroupExists = errors.New("Security group already exists")
+	ErrSecurityGroupInUse  = errors.New("Security group has instances")
+	ErrNoSecurityGroupRule = errors.New("Security group rule not found")
+	ErrNotInSecurityGroup  = errors.New("Instance is not in security group")
+	ErrNoKeyPair           = errors.New("Keypair not found")
+	ErrKeyPairExists       = errors.New("Keypair already exists")
+	ErrNoImage             = errors.New("Image not found")
+	ErrNoWebhook           = errors.New("Webhook not found")
+	ErrNoNode              = errors.New("Node not found")
+	ErrNoInstance          = errors.New("Instance Not Found")
 )
 
 // Config contains configuration information for the datastore.
@@ -50,6 +72,29 @@ type Config struct {
 	InitWorkloadsPath string
 }
 
+// eventBacklog is the number of the latest events kept for the event
+// watchers resuming from a previous event, and eventWatcherQueue the number
+// of events queued for a watcher before it is dropped.
+const (
+	eventBacklog      = 1000
+	eventWatcherQueue = 100
+)
+
+// webhookDeliveryRetention is the number of the latest delivery attempts
+// kept in the delivery log of a webhook.
+const webhookDeliveryRetention = 1000
+
+// instanceHistoryRetention is the number of the latest instance state
+// transitions kept in the instance history, across all instances.
+const instanceHistoryRetention = 100000
+
+// eventIDShift sets the first event ID of a controller run to its start
+// time, in seconds, shifted by eventIDShift.  The IDs of a run stay below
+// those of the next run, unless it published more than 65536 events per
+// second, and a client resuming from an event of a previous run is told
+// it missed events.  The IDs stay below 2^53, for the JSON clients.
+const eventIDShift = 16
+
 type userEventType string
 
 const (
@@ -58,6 +103,20 @@ const (
 	userError userEventType = "error"
 )
 
+// The sources of the instance state transitions.
+const (
+	sourceController      = "controller"
+	sourceStats           = "STATS"
+	sourceStartFailure    = "StartFailure"
+	sourceStopFailure     = "StopFailure"
+	sourceRestartFailure  = "RestartFailure"
+	sourceInstanceDeleted = "InstanceDeleted"
+	sourceNodeEvacuation  = "NodeEvacuation"
+	sourceMigration       = "InstanceMigrated"
+	sourceReconciler      = "reconciler"
+	sourceNodeRecovery    = "NodeDisconnected"
+)
+
 type workload struct {
 	types.Workload
 	filename string
@@ -76,11 +135,36 @@ type node struct {
 	instances map[string]*types.Instance
 }
 
+// resize is the workload and resource usage an instance had before being
+// resized.
+type resize struct {
+	workloadID string
+	usage      map[string]int
+}
+
 type attachment struct {
 	instanceID string
 	volumeID   string
 }
 
+// nodeReport is the list of instances reported by a node in its last STATS.
+type nodeReport struct {
+	timestamp time.Time
+	instances []payloads.InstanceStat
+}
+
+type orphan struct {
+	types.OrphanInstance
+	confirmed bool
+}
+
+// staleCopy is the copy of a rescheduled instance left on the node the
+// instance was rescheduled from.
+type staleCopy struct {
+	nodeID   string
+	deleting bool
+}
+
 type persistentStore interface {
 	disconnect()
 
@@ -93,13 +177,22 @@ type persistentStore interface {
 	getCNCIWorkloadID() (id string, err error)
 	getWorkloadNoCache(id string) (*workload, error)
 	getWorkloadsNoCache() ([]*workload, error)
+	addWorkload(wl *workload) error
+	updateWorkload(wl *workload) error
+	deleteWorkload(ID string) error
 
 	// interfaces related to tenants
 	addLimit(tenantID string, resourceID int, limit int) (err error)
+	deleteLimit(tenantID string, resourceID int) (err error)
+	getDefaultLimits() (map[int]int, error)
+	setDefaultLimit(resourceID int, limit int) (err error)
+	deleteDefaultLimit(resourceID int) (err error)
+	getTenantResources(id string) ([]*types.Resource, error)
 	addTenant(id string, MAC string) (err error)
 	getTenantNoCache(id string) (t *tenant, err error)
 	getTenantsNoCache() ([]*tenant, error)
 	updateTenant(t *tenant) (err error)
+	deleteTenant(id string) (err error)
 	releaseTenantIP(tenantID string, subnetInt int, rest int) (err error)
 	claimTenantIP(tenantID string, subnetInt int, rest int) (err error)
 
@@ -107,6 +200,7 @@ type persistentStore interface {
 	getInstances() (instances []*types.Instance, err error)
 	addInstance(instance *types.Instance) (err error)
 	removeInstance(instanceID string) (err error)
+	updateInstanceWorkload(instanceID string, workloadID string, usage map[string]int) (err error)
 
 	// interfaces related to statistics
 	addNodeStatDB(stat payloads.Stat) (err error)
@@ -126,11 +220,49 @@ type persistentStore interface {
 	createStorageAttachment(a types.StorageAttachment) error
 	getAllStorageAttachments() (map[string]types.StorageAttachment, error)
 	deleteStorageAttachment(ID string) error
+
+	// floating IP interfaces
+	getAllFloatingIPs() (map[string]types.FloatingIP, error)
+	createFloatingIP(ip types.FloatingIP) error
+	updateFloatingIP(ip types.FloatingIP) error
+	deleteFloatingIP(ID string) error
+
+	// security group interfaces
+	getAllSecurityGroups() (map[string]types.SecurityGroup, error)
+	createSecurityGroup(g types.SecurityGroup) error
+	deleteSecurityGroup(ID string) error
+	createSecurityGroupRule(r types.SecurityGroupRule) error
+	deleteSecurityGroupRule(ID string) error
+	getAllInstanceSecurityGroups() (map[string][]string, error)
+	addInstanceSecurityGroup(instanceID string, groupID string) error
+	removeInstanceSecurityGroup(instanceID string, groupID string) error
+
+	// keypair interfaces
+	getAllKeyPairs() ([]types.KeyPair, error)
+	createKeyPair(k types.KeyPair) error
+	deleteKeyPair(tenantID string, name string) error
+
+	// image interfaces
+	getAllImages() ([]types.Image, error)
+	updateImage(i types.Image) error
+	deleteImage(ID string) error
+
+	// interfaces related to instance history
+	addInstanceTransitions(transitions []types.InstanceTransition) error
+	getInstanceHistory(instanceID string) ([]types.InstanceTransition, error)
+
+	// webhook interfaces
+	getAllWebhooks() ([]types.Webhook, error)
+	createWebhook(w types.Webhook) error
+	deleteWebhook(ID string) error
+	addWebhookDelivery(d types.WebhookDelivery) error
+	getWebhookDeliveries(webhookID string, limit int, offset int) ([]types.WebhookDelivery, error)
 }
 
 // Datastore provides context for the datastore package.
 type Datastore struct {
-	db persistentStore
+	db      persistentStore
+	queries *timedStore
 
 	cnciAddedChans map[string]chan bool
 	cnciAddedLock  *sync.Mutex
@@ -141,6 +273,32 @@ type Datastore struct {
 	instanceLastStat     map[string]payloads.CiaoServerStats
 	instanceLastStatLock *sync.RWMutex
 
+	nodeReports     map[string]nodeReport
+	nodeReportsLock *sync.RWMutex
+
+	orphans     map[string]*orphan
+	orphansLock *sync.Mutex
+
+	staleCopies     map[string]*staleCopy
+	staleCopiesLock *sync.Mutex
+
+	// events holds the latest events, oldest first, for the watchers
+	// resuming from a previous event.
+	events        []types.Event
+	lastEventID   uint64
+	eventWatchers map[chan types.Event]bool
+	eventsLock    *sync.Mutex
+
+	// transitions holds the instance state transitions waiting to be
+	// written to the instance history, oldest first.  They are written
+	// in batches by writeTransitions, historyLock serializing the writes
+	// so that the transitions are stored in the order they happened.
+	transitions     []types.InstanceTransition
+	transitionsLock *sync.Mutex
+	transitionsCh   chan struct{}
+	historyLock     *sync.Mutex
+	exitCh          chan struct{}
+
 	tenants     map[string]*tenant
 	tenantsLock *sync.RWMutex
 	allSubnets  map[int]bool
@@ -153,6 +311,7 @@ type Datastore struct {
 	nodesLock *sync.RWMutex
 
 	instances     map[string]*types.Instance
+	resizes       map[string]resize
 	instancesLock *sync.RWMutex
 
 	tenantUsage     map[string][]payloads.CiaoUsage
@@ -164,6 +323,24 @@ type Datastore struct {
 	attachments     map[string]types.StorageAttachment
 	instanceVolumes map[attachment]string
 	attachLock      *sync.RWMutex
+
+	floatingIPs     map[string]types.FloatingIP
+	floatingIPsLock *sync.RWMutex
+
+	securityGroups     map[string]types.SecurityGroup
+	securityGroupsLock *sync.RWMutex
+
+	keyPairs     map[keyPairID]types.KeyPair
+	keyPairsLock *sync.RWMutex
+
+	images     map[string]types.Image
+	imagesLock *sync.RWMutex
+
+	webhooks     map[string]types.Webhook
+	webhooksLock *sync.RWMutex
+
+	defaultLimits     map[int]int
+	defaultLimitsLock *sync.RWMutex
 	// maybe add a map[instanceid][]types.StorageAttachment
 	// to make retrieval of volumes faster.
 }
@@ -179,7 +356,8 @@ func (ds *Datastore) Init(config Config) error {
 		return err
 	}
 
-	ds.db = ps
+	ds.queries = newTimedStore(ps)
+	ds.db = ds.queries
 
 	ds.cnciAddedChans = make(map[string]chan bool)
 	ds.cnciAddedLock = &sync.Mutex{}
@@ -190,6 +368,25 @@ func (ds *Datastore) Init(config Config) error {
 	ds.instanceLastStat = make(map[string]payloads.CiaoServerStats)
 	ds.instanceLastStatLock = &sync.RWMutex{}
 
+	ds.nodeReports = make(map[string]nodeReport)
+	ds.nodeReportsLock = &sync.RWMutex{}
+
+	ds.orphans = make(map[string]*orphan)
+	ds.orphansLock = &sync.Mutex{}
+
+	ds.staleCopies = make(map[string]*staleCopy)
+	ds.staleCopiesLock = &sync.Mutex{}
+
+	ds.eventWatchers = make(map[chan types.Event]bool)
+	ds.eventsLock = &sync.Mutex{}
+	ds.lastEventID = uint64(time.Now().Unix()) << eventIDShift
+
+	ds.transitionsLock = &sync.Mutex{}
+	ds.transitionsCh = make(chan struct{}, 1)
+	ds.historyLock = &sync.Mutex{}
+	ds.exitCh = make(chan struct{})
+	go ds.writeTransitions()
+
 	// warning, do not use the tenant cache to get
 	// networking information right now.  that is not
 	// updated, just the resources
@@ -200,6 +397,7 @@ func (ds *Datastore) Init(config Config) error {
 	// cache all our instances prior to getting tenants
 	ds.instancesLock = &sync.RWMutex{}
 	ds.instances = make(map[string]*types.Instance)
+	ds.resizes = make(map[string]resize)
 
 	instances, err := ds.db.getInstances()
 	if err != nil {
@@ -283,11 +481,94 @@ func (ds *Datastore) Init(config Config) error {
 
 	ds.attachLock = &sync.RWMutex{}
 
+	ds.floatingIPs, err = ds.db.getAllFloatingIPs()
+	if err != nil {
+		glog.Warning(err)
+	}
+
+	ds.floatingIPsLock = &sync.RWMutex{}
+
+	for _, ip := range ds.floatingIPs {
+		if ip.State != types.FloatingIPAssigned {
+			continue
+		}
+
+		i, ok := ds.instances[ip.InstanceID]
+		if ok {
+			i.PublicIP = ip.Address
+		}
+	}
+
+	ds.securityGroups, err = ds.db.getAllSecurityGroups()
+	if err != nil {
+		glog.Warning(err)
+	}
+
+	ds.securityGroupsLock = &sync.RWMutex{}
+
+	members, err := ds.db.getAllInstanceSecurityGroups()
+	if err != nil {
+		glog.Warning(err)
+	}
+
+	for instanceID, groups := range members {
+		i, ok := ds.instances[instanceID]
+		if ok {
+			i.SecurityGroups = groups
+		}
+	}
+
+	ds.keyPairs = make(map[keyPairID]types.KeyPair)
+	ds.keyPairsLock = &sync.RWMutex{}
+
+	keys, err := ds.db.getAllKeyPairs()
+	if err != nil {
+		glog.Warning(err)
+	}
+
+	for _, k := range keys {
+		ds.keyPairs[keyPairID{tenantID: k.TenantID, name: k.Name}] = k
+	}
+
+	ds.images = make(map[string]types.Image)
+	ds.imagesLock = &sync.RWMutex{}
+
+	images, err := ds.db.getAllImages()
+	if err != nil {
+		glog.Warning(err)
+	}
+
+	for _, i := range images {
+		ds.images[i.ID] = i
+	}
+
+	ds.webhooks = make(map[string]types.Webhook)
+	ds.webhooksLock = &sync.RWMutex{}
+
+	webhooks, err := ds.db.getAllWebhooks()
+	if err != nil {
+		glog.Warning(err)
+	}
+
+	for _, w := range webhooks {
+		ds.webhooks[w.ID] = w
+	}
+
+	ds.defaultLimitsLock = &sync.RWMutex{}
+
+	ds.defaultLimits, err = ds.db.getDefaultLimits()
+	if err != nil {
+		glog.Warning(err)
+	}
+
 	return err
 }
 
-// Exit will disconnect the backing database.
+// Exit will write the pending instance state transitions, and disconnect
+// the backing database.
 func (ds *Datastore) Exit() {
+	close(ds.exitCh)
+	ds.flushTransitions()
 	ds.db.disconnect()
 }
 
@@ -328,6 +609,107 @@ func (ds *Datastore) AddLimit(tenantID string, resourceID int, limit int) error
 	return err
 }
 
+// DeleteLimit removes the limit of a tenant for a specific resource.
+// The cluster wide default limit of the resource applies instead.
+func (ds *Datastore) DeleteLimit(tenantID string, resourceID int) error {
+	err := ds.db.deleteLimit(tenantID, resourceID)
+	if err != nil {
+		return err
+	}
+
+	return ds.updateTenantLimits(tenantID)
+}
+
+// GetDefaultLimits returns the cluster wide default limits, indexed by
+// resource ID. Resources without a default limit are unlimited.
+func (ds *Datastore) GetDefaultLimits() map[int]int {
+	limits := make(map[int]int)
+
+	ds.defaultLimitsLock.RLock()
+	for id, limit := range ds.defaultLimits {
+		limits[id] = limit
+	}
+	ds.defaultLimitsLock.RUnlock()
+
+	return limits
+}
+
+// SetDefaultLimit sets the cluster wide default limit for a specific
+// resource. It applies to all the tenants without a limit of their own
+// for this resource.
+func (ds *Datastore) SetDefaultLimit(resourceID int, limit int) error {
+	err := ds.db.setDefaultLimit(resourceID, limit)
+	if err != nil {
+		return err
+	}
+
+	ds.defaultLimitsLock.Lock()
+	ds.defaultLimits[resourceID] = limit
+	ds.defaultLimitsLock.Unlock()
+
+	return ds.updateAllTenantLimits()
+}
+
+// DeleteDefaultLimit removes the cluster wide default limit for a
+// specific resource.
+func (ds *Datastore) DeleteDefaultLimit(resourceID int) error {
+	err := ds.db.deleteDefaultLimit(resourceID)
+	if err != nil {
+		return err
+	}
+
+	ds.defaultLimitsLock.Lock()
+	delete(ds.defaultLimits, resourceID)
+	ds.defaultLimitsLock.Unlock()
+
+	return ds.updateAllTenantLimits()
+}
+
+// updateTenantLimits reloads the limits of a cached tenant after its
+// own limits or the default ones changed.
+func (ds *Datastore) updateTenantLimits(tenantID string) error {
+	resources, err := ds.db.getTenantResources(tenantID)
+	if err != nil {
+		return err
+	}
+
+	ds.tenantsLock.Lock()
+
+	tenant := ds.tenants[tenantID]
+	if tenant != nil {
+		for _, r := range resources {
+			for i := range tenant.Resources {
+				if tenant.Resources[i].Rtype == r.Rtype {
+					tenant.Resources[i].Limit = r.Limit
+				}
+			}
+		}
+	}
+
+	ds.tenantsLock.Unlock()
+
+	return nil
+}
+
+func (ds *Datastore) updateAllTenantLimits() error {
+	var tenantIDs []string
+
+	ds.tenantsLock.RLock()
+	for id := range ds.tenants {
+		tenantIDs = append(tenantIDs, id)
+	}
+	ds.tenantsLock.RUnlock()
+
+	for _, id := range tenantIDs {
+		err := ds.updateTenantLimits(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func newHardwareAddr() (net.HardwareAddr, error) {
 	buf := make([]byte, 6)
 	_, err := rand.Read(buf)
@@ -459,6 +841,78 @@ func (ds *Datastore) GetWorkloads() ([]*types.Workload, error) {
 	return workloads, nil
 }
 
+// AddWorkload stores a new workload definition.
+func (ds *Datastore) AddWorkload(w types.Workload) error {
+	ds.workloadsLock.Lock()
+	defer ds.workloadsLock.Unlock()
+
+	if _, ok := ds.workloads[w.ID]; ok {
+		return ErrWorkloadExists
+	}
+
+	wl := &workload{Workload: w}
+
+	err := ds.db.addWorkload(wl)
+	if err != nil {
+		return err
+	}
+
+	ds.workloads[w.ID] = wl
+
+	return nil
+}
+
+// UpdateWorkload replaces the definition of an existing workload.
+// Instances already running keep the definition they were started with.
+func (ds *Datastore) UpdateWorkload(w types.Workload) error {
+	ds.workloadsLock.Lock()
+	defer ds.workloadsLock.Unlock()
+
+	if _, ok := ds.workloads[w.ID]; !ok {
+		return ErrNoWorkload
+	}
+
+	wl := &workload{Workload: w}
+
+	err := ds.db.updateWorkload(wl)
+	if err != nil {
+		return err
+	}
+
+	ds.workloads[w.ID] = wl
+
+	return nil
+}
+
+// DeleteWorkload removes a workload definition.  Workloads which still
+// have instances cannot be deleted.
+func (ds *Datastore) DeleteWorkload(ID string) error {
+	ds.workloadsLock.Lock()
+	defer ds.workloadsLock.Unlock()
+
+	if _, ok := ds.workloads[ID]; !ok {
+		return ErrNoWorkload
+	}
+
+	ds.instancesLock.RLock()
+	for _, i := range ds.instances {
+		if i.WorkloadID == ID {
+			ds.instancesLock.RUnlock()
+			return ErrWorkloadInUse
+		}
+	}
+	ds.instancesLock.RUnlock()
+
+	err := ds.db.deleteWorkload(ID)
+	if err != nil {
+		return err
+	}
+
+	delete(ds.workloads, ID)
+
+	return nil
+}
+
 // AddCNCIIP will associate a new IP address with an existing CNCI
 // via the mac address
 func (ds *Datastore) AddCNCIIP(cnciMAC string, ip string) error {
@@ -542,6 +996,55 @@ func (ds *Datastore) removeTenantCNCI(tenantID string) error {
 	return ds.db.updateTenant(tenant)
 }
 
+// DeleteTenant removes a tenant from the datastore. Its subnets and
+// IP addresses are released, and its usage, limits and instance history
+// are purged. The instances and volumes of the tenant must have been
+// deleted first.
+func (ds *Datastore) DeleteTenant(tenantID string) error {
+	// the history of the tenant instances is purged with the tenant,
+	// their last transitions must not be written after it.
+	ds.flushTransitions()
+
+	ds.tenantsLock.Lock()
+
+	tenant, ok := ds.tenants[tenantID]
+	if !ok {
+		ds.tenantsLock.Unlock()
+		return ErrNoTenant
+	}
+
+	if len(tenant.instances) > 0 || len(tenant.devices) > 0 {
+		ds.tenantsLock.Unlock()
+		return ErrTenantInUse
+	}
+
+	err := ds.db.deleteTenant(tenantID)
+	if err != nil {
+		ds.tenantsLock.Unlock()
+		return err
+	}
+
+	for _, subnet := range tenant.subnets {
+		delete(ds.allSubnets, subnet)
+	}
+	delete(ds.tenants, tenantID)
+
+	ds.tenantsLock.Unlock()
+
+	ds.tenantUsageLock.Lock()
+	delete(ds.tenantUsage, tenantID)
+	ds.tenantUsageLock.Unlock()
+
+	ds.cnciAddedLock.Lock()
+	delete(ds.cnciAddedChans, tenantID)
+	ds.cnciAddedLock.Unlock()
+
+	msg := fmt.Sprintf("Deleted tenant %s", tenantID)
+	ds.logEvent(tenantID, string(userInfo), msg)
+
+	return nil
+}
+
 func (ds *Datastore) getTenants() ([]*tenant, error) {
 	var tenants []*tenant
 
@@ -563,7 +1066,7 @@ func (ds *Datastore) getTenants() ([]*tenant, error) {
 	return ds.db.getTenantsNoCache()
 }
 
-// GetAllTenants returns all the tenants from the datastore.
+// GetAllTenants returns all the tenants from the datastore, sorted by ID.
 func (ds *Datastore) GetAllTenants() ([]*types.Tenant, error) {
 	var tenants []*types.Tenant
 
@@ -583,9 +1086,17 @@ func (ds *Datastore) GetAllTenants() ([]*types.Tenant, error) {
 		}
 	}
 
+	sort.Sort(sortedTenantsByID(tenants))
+
 	return tenants, nil
 }
 
+type sortedTenantsByID []*types.Tenant
+
+func (s sortedTenantsByID) Len() int           { return len(s) }
+func (s sortedTenantsByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortedTenantsByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
 // ReleaseTenantIP will return an IP address previously allocated to the pool.
 // Once a tenant IP address is released, it can be reassigned to another
 // instance.
@@ -738,7 +1249,7 @@ func (ds *Datastore) GetInstance(id string) (*types.Instance, error) {
 	ds.instancesLock.RUnlock()
 
 	if !ok {
-		return nil, errors.New("Instance Not Found")
+		return nil, ErrNoInstance
 	}
 
 	return value, nil
@@ -784,6 +1295,20 @@ func (ds *Datastore) GetAllInstancesByNode(nodeID string) ([]*types.Instance, er
 	return instances, nil
 }
 
+// GetNode returns the description of a node, as last reported by its
+// statistics.
+func (ds *Datastore) GetNode(nodeID string) (types.Node, error) {
+	ds.nodesLock.RLock()
+	defer ds.nodesLock.RUnlock()
+
+	n, ok := ds.nodes[nodeID]
+	if !ok {
+		return types.Node{}, ErrNoNode
+	}
+
+	return n.Node, nil
+}
+
 // AddInstance will store a new instance in the datastore.
 // The instance will be updated both in the cache and in the database
 func (ds *Datastore) AddInstance(instance *types.Instance) error {
@@ -835,32 +1360,199 @@ func (ds *Datastore) AddInstance(instance *types.Instance) error {
 	// update database asynchronously
 	go ds.db.addInstance(instance)
 
+	ds.addTransition(types.InstanceTransition{
+		InstanceID: instance.ID,
+		TenantID:   instance.TenantID,
+		To:         instance.State,
+		Source:     sourceController,
+		NodeID:     instance.NodeID,
+	})
+
 	return nil
 }
 
-// RestartFailure logs a RestartFailure in the datastore
-func (ds *Datastore) RestartFailure(instanceID string, reason payloads.RestartFailureReason) error {
-	i, err := ds.GetInstance(instanceID)
-	if err != nil {
-		return err
-	}
+// ResizeInstance moves an instance to the workload whose resources it is
+// resized to, and updates the resource usage of its tenant accordingly.
+// The previous workload of the instance is kept until the instance is
+// reported running, so that the resize can be rolled back if the node
+// fails to restart the instance with its new resources.
+func (ds *Datastore) ResizeInstance(instanceID string, workloadID string, usage map[string]int) error {
+	return ds.setInstanceWorkload(instanceID, workloadID, usage, true)
+}
 
-	msg := fmt.Sprintf("Restart Failure %s: %s", instanceID, reason.String())
-	ds.db.logEvent(i.TenantID, string(userError), msg)
+// rollbackResize moves a resized instance back to its previous workload.
+func (ds *Datastore) rollbackResize(instanceID string) error {
+	ds.instancesLock.Lock()
+	r, ok := ds.resizes[instanceID]
+	delete(ds.resizes, instanceID)
+	ds.instancesLock.Unlock()
 
-	return nil
+	if !ok {
+		return nil
+	}
+
+	return ds.setInstanceWorkload(instanceID, r.workloadID, r.usage, false)
 }
 
-// StopFailure logs a StopFailure in the datastore
-func (ds *Datastore) StopFailure(instanceID string, reason payloads.StopFailureReason) error {
-	i, err := ds.GetInstance(instanceID)
-	if err != nil {
-		return err
+func (ds *Datastore) setInstanceWorkload(instanceID string, workloadID string, usage map[string]int, resizing bool) error {
+	ds.instancesLock.Lock()
+	i, ok := ds.instances[instanceID]
+	if !ok {
+		ds.instancesLock.Unlock()
+		return errors.New("Instance Not Found")
+	}
+	oldUsage := i.Usage
+	if _, pending := ds.resizes[instanceID]; resizing && !pending {
+		ds.resizes[instanceID] = resize{
+			workloadID: i.WorkloadID,
+			usage:      oldUsage,
+		}
 	}
+	i.WorkloadID = workloadID
+	i.Usage = usage
+	ds.instancesLock.Unlock()
 
-	msg := fmt.Sprintf("Stop Failure %s: %s", instanceID, reason.String())
+	ds.tenantsLock.Lock()
+	tenant := ds.tenants[i.TenantID]
+	if tenant != nil {
+		for _, res := range tenant.Resources {
+			res.Usage += usage[res.Rname] - oldUsage[res.Rname]
+		}
+	}
+	ds.tenantsLock.Unlock()
 
-	ds.db.logEvent(i.TenantID, string(userError), msg)
+	return ds.db.updateInstanceWorkload(instanceID, workloadID, usage)
+}
+
+// addTransition queues a state transition for the history of an instance,
+// and publishes it to the event watchers.  The transition is written to the
+// database asynchronously by writeTransitions.
+func (ds *Datastore) addTransition(t types.InstanceTransition) {
+	t.Timestamp = time.Now().UTC()
+
+	ds.transitionsLock.Lock()
+	ds.transitions = append(ds.transitions, t)
+	ds.transitionsLock.Unlock()
+
+	select {
+	case ds.transitionsCh <- struct{}{}:
+	default:
+	}
+
+	msg := fmt.Sprintf("Instance %s state changed from %q to %q", t.InstanceID, t.From, t.To)
+	if t.Reason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, t.Reason)
+	}
+
+	ds.publishEvent(types.Event{
+		Timestamp:  t.Timestamp,
+		Kind:       types.EventInstanceState,
+		TenantID:   t.TenantID,
+		InstanceID: t.InstanceID,
+		NodeID:     t.NodeID,
+		State:      t.To,
+		Message:    msg,
+	})
+}
+
+// writeTransitions writes the queued instance state transitions to the
+// database until the datastore exits.  The transitions queued while a batch
+// is being written are written together in the next one.
+func (ds *Datastore) writeTransitions() {
+	for {
+		select {
+		case <-ds.transitionsCh:
+			ds.flushTransitions()
+		case <-ds.exitCh:
+			return
+		}
+	}
+}
+
+// flushTransitions writes all the queued instance state transitions to the
+// database in a single transaction.
+func (ds *Datastore) flushTransitions() {
+	ds.historyLock.Lock()
+	defer ds.historyLock.Unlock()
+
+	ds.transitionsLock.Lock()
+	transitions := ds.transitions
+	ds.transitions = nil
+	ds.transitionsLock.Unlock()
+
+	if len(transitions) == 0 {
+		return
+	}
+
+	err := ds.db.addInstanceTransitions(transitions)
+	if err != nil {
+		glog.Warningf("Unable to record %d instance transitions: %v", len(transitions), err)
+	}
+}
+
+// addFailureTransition records a failure reported for an instance, which
+// stays in its current state.
+func (ds *Datastore) addFailureTransition(i *types.Instance, source string, reason string) {
+	ds.instancesLock.RLock()
+	t := types.InstanceTransition{
+		InstanceID: i.ID,
+		TenantID:   i.TenantID,
+		From:       i.State,
+		To:         i.State,
+		Source:     source,
+		NodeID:     i.NodeID,
+		Reason:     reason,
+	}
+	ds.instancesLock.RUnlock()
+
+	ds.addTransition(t)
+}
+
+// GetInstanceHistory returns the state transitions of an instance, oldest
+// first.  The history of an instance is kept after it is deleted, until its
+// transitions are no longer among the latest instanceHistoryRetention ones.
+func (ds *Datastore) GetInstanceHistory(instanceID string) ([]types.InstanceTransition, error) {
+	ds.flushTransitions()
+
+	return ds.db.getInstanceHistory(instanceID)
+}
+
+// RestartFailure logs a RestartFailure in the datastore, and rolls back
+// the resize of the instance if it failed to restart with its new resources.
+func (ds *Datastore) RestartFailure(instanceID string, reason payloads.RestartFailureReason) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	ds.addFailureTransition(i, sourceRestartFailure, reason.String())
+
+	// the instance could not be restarted with its new resources.
+	if reason == payloads.RestartResizeFailure || reason == payloads.RestartNoCapacity {
+		err = ds.rollbackResize(instanceID)
+		if err != nil {
+			glog.Warningf("Unable to roll back the resize of %s: %v", instanceID, err)
+		}
+	}
+
+	msg := fmt.Sprintf("Restart Failure %s: %s", instanceID, reason.String())
+	ds.logEvent(i.TenantID, string(userError), msg)
+
+	return nil
+}
+
+// StopFailure logs a StopFailure in the datastore
+func (ds *Datastore) StopFailure(instanceID string, reason payloads.StopFailureReason) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	ds.addFailureTransition(i, sourceStopFailure, reason.String())
+
+	msg := fmt.Sprintf("Stop Failure %s: %s", instanceID, reason.String())
+
+	ds.logEvent(i.TenantID, string(userError), msg)
 
 	return nil
 }
@@ -895,7 +1587,13 @@ func (ds *Datastore) StartFailure(instanceID string, reason payloads.StartFailur
 		}
 
 		msg := fmt.Sprintf("CNCI Start Failure %s: %s", instanceID, reason.String())
-		ds.db.logEvent(tenantID, string(userError), msg)
+		ds.logEvent(tenantID, string(userError), msg)
+		ds.publishEvent(types.Event{
+			Kind:       types.EventStartFailure,
+			TenantID:   tenantID,
+			InstanceID: instanceID,
+			Message:    msg,
+		})
 
 		ds.cnciAddedLock.Lock()
 
@@ -926,17 +1624,41 @@ func (ds *Datastore) StartFailure(instanceID string, reason payloads.StartFailur
 		payloads.InvalidPayload,
 		payloads.InvalidData,
 		payloads.ImageFailure,
-		payloads.NetworkFailure:
+		payloads.NetworkFailure,
+		payloads.TenantThrottled,
+		payloads.GangFailure:
+
+		ds.instancesLock.RLock()
+		t := types.InstanceTransition{
+			InstanceID: i.ID,
+			TenantID:   i.TenantID,
+			From:       i.State,
+			To:         types.InstanceDeleted,
+			Source:     sourceStartFailure,
+			NodeID:     i.NodeID,
+			Reason:     reason.String(),
+		}
+		ds.instancesLock.RUnlock()
 
 		ds.deleteInstance(instanceID)
+		ds.addTransition(t)
 
 	case payloads.LaunchFailure,
 		payloads.AlreadyRunning,
 		payloads.InstanceExists:
+
+		ds.addFailureTransition(i, sourceStartFailure, reason.String())
 	}
 
 	msg := fmt.Sprintf("Start Failure %s: %s", instanceID, reason.String())
-	ds.db.logEvent(i.TenantID, string(userError), msg)
+	ds.logEvent(i.TenantID, string(userError), msg)
+	ds.publishEvent(types.Event{
+		Kind:       types.EventStartFailure,
+		TenantID:   i.TenantID,
+		InstanceID: instanceID,
+		NodeID:     i.NodeID,
+		Message:    msg,
+	})
 
 	return nil
 }
@@ -960,7 +1682,7 @@ func (ds *Datastore) AttachVolumeFailure(instanceID string, volumeID string, rea
 
 	msg := fmt.Sprintf("Attach Volume Failure %s to %s: %s", volumeID, instanceID, reason.String())
 
-	ds.db.logEvent(i.TenantID, string(userError), msg)
+	ds.logEvent(i.TenantID, string(userError), msg)
 }
 
 // DetachVolumeFailure will clean up after a failure to detach a volume.
@@ -986,7 +1708,7 @@ func (ds *Datastore) DetachVolumeFailure(instanceID string, volumeID string, rea
 
 	msg := fmt.Sprintf("Detach Volume Failure %s from %s: %s", volumeID, instanceID, reason.String())
 
-	ds.db.logEvent(i.TenantID, string(userError), msg)
+	ds.logEvent(i.TenantID, string(userError), msg)
 }
 
 func (ds *Datastore) deleteInstance(instanceID string) error {
@@ -997,6 +1719,7 @@ func (ds *Datastore) deleteInstance(instanceID string) error {
 	ds.instancesLock.Lock()
 	i := ds.instances[instanceID]
 	delete(ds.instances, instanceID)
+	delete(ds.resizes, instanceID)
 	ds.instancesLock.Unlock()
 
 	ds.tenantsLock.Lock()
@@ -1021,18 +1744,27 @@ func (ds *Datastore) deleteInstance(instanceID string) error {
 	}
 	ds.tenantsLock.Unlock()
 
-	// we may not have received any node stats for this instance
+	// we may not have received any node stats for this instance,
+	// and its node may have disconnected.
 	if i.NodeID != "" {
 		ds.nodesLock.Lock()
-		delete(ds.nodes[i.NodeID].instances, instanceID)
+		if n, ok := ds.nodes[i.NodeID]; ok {
+			delete(n.instances, instanceID)
+		}
 		ds.nodesLock.Unlock()
 	}
 
+	ds.staleCopiesLock.Lock()
+	delete(ds.staleCopies, instanceID)
+	ds.staleCopiesLock.Unlock()
+
 	err := ds.db.removeInstance(i.ID)
 	if err != nil {
 		glog.V(2).Info("deleteInstance: ", err)
 	}
 
+	ds.clearInstanceFloatingIP(i.ID)
+
 	err = ds.ReleaseTenantIP(i.TenantID, i.IPAddress)
 	if err != nil {
 		glog.V(2).Info("deleteInstance: ", err)
@@ -1041,19 +1773,192 @@ func (ds *Datastore) deleteInstance(instanceID string) error {
 	return err
 }
 
-// DeleteInstance removes an instance from the datastore.
+// DeleteInstance removes an instance from the datastore, unless the
+// instance deleted is the stale copy of a rescheduled instance.
 func (ds *Datastore) DeleteInstance(instanceID string) error {
+	var t types.InstanceTransition
+
+	ds.staleCopiesLock.Lock()
+	c, stale := ds.staleCopies[instanceID]
+	if stale && c.deleting {
+		delete(ds.staleCopies, instanceID)
+	}
+	ds.staleCopiesLock.Unlock()
+
+	if stale && c.deleting {
+		glog.Infof("Stale copy of instance %s deleted from node %s", instanceID, c.nodeID)
+		return nil
+	}
+
+	ds.instancesLock.RLock()
+	i, ok := ds.instances[instanceID]
+	if ok {
+		t = types.InstanceTransition{
+			InstanceID: i.ID,
+			TenantID:   i.TenantID,
+			From:       i.State,
+			To:         types.InstanceDeleted,
+			Source:     sourceInstanceDeleted,
+			NodeID:     i.NodeID,
+		}
+	}
+	ds.instancesLock.RUnlock()
+
 	err := ds.deleteInstance(instanceID)
 	if err != nil {
 		return err
 	}
 
+	if ok {
+		ds.addTransition(t)
+	}
+
 	msg := fmt.Sprintf("Deleted Instance %s", instanceID)
-	ds.db.logEvent(instanceID, string(userInfo), msg)
+	ds.logEvent(instanceID, string(userInfo), msg)
+
+	return nil
+}
+
+// NodeDisconnected marks the instances of a node which disconnected as
+// unknown, and removes the node from the node cache.  It returns copies of
+// the instances, in the state they were in before the node disconnected.
+func (ds *Datastore) NodeDisconnected(nodeID string) ([]types.Instance, error) {
+	var instances []types.Instance
+	var transitions []types.InstanceTransition
+
+	ds.publishEvent(types.Event{
+		Kind:    types.EventNodeDisconnected,
+		NodeID:  nodeID,
+		Message: fmt.Sprintf("Node %s disconnected", nodeID),
+	})
+
+	ds.instancesLock.Lock()
+	ds.nodesLock.RLock()
+
+	if n, ok := ds.nodes[nodeID]; ok {
+		for _, i := range n.instances {
+			if i.NodeID != nodeID || ds.instances[i.ID] == nil {
+				continue
+			}
+
+			instances = append(instances, *i)
+
+			if i.State == types.InstanceUnknown {
+				continue
+			}
+
+			transitions = append(transitions, types.InstanceTransition{
+				InstanceID: i.ID,
+				TenantID:   i.TenantID,
+				From:       i.State,
+				To:         types.InstanceUnknown,
+				Source:     sourceNodeRecovery,
+				NodeID:     nodeID,
+			})
+			i.State = types.InstanceUnknown
+		}
+	}
+
+	ds.nodesLock.RUnlock()
+	ds.instancesLock.Unlock()
+
+	for _, t := range transitions {
+		ds.addTransition(t)
+
+		msg := fmt.Sprintf("Node %s of instance %s disconnected", t.NodeID, t.InstanceID)
+		ds.logEvent(t.TenantID, string(userWarn), msg)
+	}
+
+	return instances, ds.DeleteNode(nodeID)
+}
+
+// RescheduleInstance prepares an instance of a disconnected node to be
+// launched on another node.  Only instances without volumes attached,
+// besides the bootVolume they boot from, can be rescheduled.  The instance
+// becomes pending, and its copy is ignored, and deleted, if its former
+// node reconnects.
+func (ds *Datastore) RescheduleInstance(instanceID string, nodeID string, bootVolume string) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := ds.GetStorageAttachments(instanceID)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		if a.BlockID != bootVolume {
+			msg := fmt.Sprintf("Instance %s of disconnected node %s not rescheduled, volume %s is attached",
+				instanceID, nodeID, a.BlockID)
+			ds.logEvent(i.TenantID, string(userError), msg)
+			return errors.New("Instance has volumes attached")
+		}
+	}
+
+	ds.instancesLock.Lock()
+	if i.NodeID != nodeID || i.State != types.InstanceUnknown {
+		ds.instancesLock.Unlock()
+		return fmt.Errorf("Instance %s no longer waits for node %s", instanceID, nodeID)
+	}
+
+	t := types.InstanceTransition{
+		InstanceID: i.ID,
+		TenantID:   i.TenantID,
+		From:       i.State,
+		To:         payloads.Pending,
+		Source:     sourceNodeRecovery,
+		NodeID:     nodeID,
+		Reason:     "rescheduled from node " + nodeID,
+	}
+	i.State = payloads.Pending
+	i.NodeID = ""
+	ds.instancesLock.Unlock()
+
+	ds.staleCopiesLock.Lock()
+	ds.staleCopies[instanceID] = &staleCopy{nodeID: nodeID}
+	ds.staleCopiesLock.Unlock()
+
+	ds.addTransition(t)
+
+	msg := fmt.Sprintf("Instance %s rescheduled from disconnected node %s", instanceID, nodeID)
+	ds.logEvent(t.TenantID, string(userWarn), msg)
 
 	return nil
 }
 
+// StaleInstanceCopies returns the rescheduled instances still reported by
+// the node they were rescheduled from, which have to be deleted from that
+// node.  Each copy is only returned once.
+func (ds *Datastore) StaleInstanceCopies(nodeID string) []string {
+	var copies []string
+
+	ds.nodeReportsLock.RLock()
+	r := ds.nodeReports[nodeID]
+	ds.nodeReportsLock.RUnlock()
+
+	ds.staleCopiesLock.Lock()
+	for _, stat := range r.instances {
+		c, ok := ds.staleCopies[stat.InstanceUUID]
+		if ok && c.nodeID == nodeID && !c.deleting {
+			c.deleting = true
+			copies = append(copies, stat.InstanceUUID)
+		}
+	}
+	ds.staleCopiesLock.Unlock()
+
+	return copies
+}
+
+func (ds *Datastore) isStaleCopy(instanceID string, nodeID string) bool {
+	ds.staleCopiesLock.Lock()
+	defer ds.staleCopiesLock.Unlock()
+
+	c, ok := ds.staleCopies[instanceID]
+	return ok && c.nodeID == nodeID
+}
+
 // DeleteNode removes a node from the node cache.
 func (ds *Datastore) DeleteNode(nodeID string) error {
 	ds.nodesLock.Lock()
@@ -1064,120 +1969,450 @@ func (ds *Datastore) DeleteNode(nodeID string) error {
 	delete(ds.nodeLastStat, nodeID)
 	ds.nodeLastStatLock.Unlock()
 
+	ds.nodeReportsLock.Lock()
+	delete(ds.nodeReports, nodeID)
+	ds.nodeReportsLock.Unlock()
+
 	return nil
 }
 
-// HandleStats makes sure that the data from the stat payload is stored.
-func (ds *Datastore) HandleStats(stat payloads.Stat) error {
-	if stat.Load != -1 {
-		ds.addNodeStat(stat)
+// InstanceMigrated logs the outcome of a live migration, and moves the
+// instance to its new node when the migration succeeded.
+func (ds *Datastore) InstanceMigrated(event payloads.InstanceMigratedEvent) error {
+	i, err := ds.GetInstance(event.InstanceUUID)
+	if err != nil {
+		return err
 	}
 
-	return ds.addInstanceStats(stat.Instances, stat.NodeUUID)
-}
+	if event.Error != "" {
+		msg := fmt.Sprintf("Instance %s not migrated from node %s: %s",
+			i.ID, event.SourceAgentUUID, event.Error)
+		ds.logEvent(i.TenantID, string(userError), msg)
+		return nil
+	}
 
-// HandleTraceReport stores the provided trace data in the datastore.
-func (ds *Datastore) HandleTraceReport(trace payloads.Trace) error {
-	for index := range trace.Frames {
-		i := trace.Frames[index]
+	ds.instancesLock.Lock()
+	i.NodeID = event.TargetAgentUUID
+	state := i.State
+	ds.instancesLock.Unlock()
 
-		err := ds.db.addFrameStat(i)
-		if err != nil {
-			glog.Warning(err)
-		}
+	ds.addTransition(types.InstanceTransition{
+		InstanceID: i.ID,
+		TenantID:   i.TenantID,
+		From:       state,
+		To:         state,
+		Source:     sourceMigration,
+		NodeID:     event.TargetAgentUUID,
+		Reason:     "migrated from node " + event.SourceAgentUUID,
+	})
+
+	ds.nodesLock.Lock()
+	if n, ok := ds.nodes[event.SourceAgentUUID]; ok {
+		delete(n.instances, i.ID)
 	}
+	ds.nodesLock.Unlock()
+
+	msg := fmt.Sprintf("Instance %s migrated from node %s to node %s",
+		i.ID, event.SourceAgentUUID, event.TargetAgentUUID)
+	ds.logEvent(i.TenantID, string(userInfo), msg)
 
 	return nil
 }
 
-// GetInstanceLastStats retrieves the last instances stats received for this node.
-// It returns it in a format suitable for the compute API.
-func (ds *Datastore) GetInstanceLastStats(nodeID string) payloads.CiaoServersStats {
-	var serversStats payloads.CiaoServersStats
+// NodeEvacuation logs the outcome of a completed node evacuation for each
+// of the node instances, and moves the instances restarted on other nodes
+// out of the evacuated node.
+func (ds *Datastore) NodeEvacuation(evacuation payloads.EventNodeEvacuation) error {
+	if evacuation.Status != payloads.EvacuationComplete {
+		return nil
+	}
 
-	ds.instanceLastStatLock.RLock()
-	for _, instance := range ds.instanceLastStat {
-		if instance.NodeID != nodeID {
+	// instances are handed back without a next state by nodes which are
+	// not evacuated, e.g. resized instances their node cannot host.
+	moved := "evacuated"
+	if evacuation.NextState == "" {
+		moved = "relocated"
+	}
+
+	for _, outcome := range evacuation.Instances {
+		i, err := ds.GetInstance(outcome.InstanceUUID)
+		if err != nil {
+			glog.Warningf("Evacuated instance %s: %v", outcome.InstanceUUID, err)
 			continue
 		}
-		serversStats.Servers = append(serversStats.Servers, instance)
+
+		eventType := userInfo
+		var msg string
+
+		switch outcome.Status {
+		case payloads.InstanceReplaced:
+			msg = fmt.Sprintf("Instance %s %s from node %s to node %s",
+				i.ID, moved, evacuation.NodeUUID, outcome.NodeUUID)
+
+			ds.instancesLock.Lock()
+			i.NodeID = outcome.NodeUUID
+			state := i.State
+			ds.instancesLock.Unlock()
+
+			ds.addTransition(types.InstanceTransition{
+				InstanceID: i.ID,
+				TenantID:   i.TenantID,
+				From:       state,
+				To:         state,
+				Source:     sourceNodeEvacuation,
+				NodeID:     outcome.NodeUUID,
+				Reason:     moved + " from node " + evacuation.NodeUUID,
+			})
+
+			ds.nodesLock.Lock()
+			if n, ok := ds.nodes[evacuation.NodeUUID]; ok {
+				delete(n.instances, i.ID)
+			}
+			ds.nodesLock.Unlock()
+		case payloads.InstanceReplaceFailed:
+			eventType = userError
+			msg = fmt.Sprintf("Instance %s %s from node %s, no node to restart it on",
+				i.ID, moved, evacuation.NodeUUID)
+		default:
+			eventType = userWarn
+			msg = fmt.Sprintf("Instance %s stopped by node %s evacuation",
+				i.ID, evacuation.NodeUUID)
+		}
+
+		ds.logEvent(i.TenantID, string(eventType), msg)
 	}
-	ds.instanceLastStatLock.RUnlock()
 
-	return serversStats
+	return nil
 }
 
-// GetNodeLastStats retrieves the last nodes stats received for this node.
-// It returns it in a format suitable for the compute API.
-func (ds *Datastore) GetNodeLastStats() payloads.CiaoComputeNodes {
-	var computeNodes payloads.CiaoComputeNodes
+// HandleStats makes sure that the data from the stat payload is stored.
+func (ds *Datastore) HandleStats(stat payloads.Stat) error {
+	if stat.Load != -1 {
+		ds.addNodeStat(stat)
+	}
 
-	ds.nodeLastStatLock.RLock()
-	for _, node := range ds.nodeLastStat {
-		computeNodes.Nodes = append(computeNodes.Nodes, node)
+	ds.nodeReportsLock.Lock()
+	ds.nodeReports[stat.NodeUUID] = nodeReport{
+		timestamp: time.Now(),
+		instances: stat.Instances,
 	}
-	ds.nodeLastStatLock.RUnlock()
+	ds.nodeReportsLock.Unlock()
 
-	return computeNodes
+	return ds.addInstanceStats(stat.Instances, stat.NodeUUID)
 }
 
-func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
-	ds.nodesLock.Lock()
-
-	n, ok := ds.nodes[stat.NodeUUID]
-	if !ok {
-		n = &node{}
-		n.instances = make(map[string]*types.Instance)
-		ds.nodes[stat.NodeUUID] = n
+// Reconcile compares the instances of each node with the instances reported
+// by the node in its last STATS.  Instances not reported by their node for
+// longer than grace are marked lost, and the storage attachments are updated
+// to match the volumes reported.  Instances reported by a node but unknown to
+// the datastore for longer than grace are returned as orphans.  Every
+// correction is recorded in the event log.
+func (ds *Datastore) Reconcile(grace time.Duration) []types.OrphanInstance {
+	ds.nodeReportsLock.RLock()
+	reports := make(map[string]nodeReport, len(ds.nodeReports))
+	for nodeID, r := range ds.nodeReports {
+		reports[nodeID] = r
 	}
+	ds.nodeReportsLock.RUnlock()
 
-	n.ID = stat.NodeUUID
-	n.Hostname = stat.NodeHostName
+	ds.markLostInstances(reports, grace)
+	ds.reconcileAttachments(reports)
 
-	ds.nodesLock.Unlock()
+	return ds.updateOrphans(reports, grace)
+}
 
-	cnStat := payloads.CiaoComputeNode{
-		ID:            stat.NodeUUID,
-		Status:        stat.Status,
-		Load:          stat.Load,
-		MemTotal:      stat.MemTotalMB,
-		MemAvailable:  stat.MemAvailableMB,
-		DiskTotal:     stat.DiskTotalMB,
-		DiskAvailable: stat.DiskAvailableMB,
-		OnlineCPUs:    stat.CpusOnline,
+func (ds *Datastore) markLostInstances(reports map[string]nodeReport, grace time.Duration) {
+	var transitions []types.InstanceTransition
+
+	lastSeen := make(map[string]time.Time)
+	ds.instanceLastStatLock.RLock()
+	for id, stat := range ds.instanceLastStat {
+		lastSeen[id] = stat.Timestamp
 	}
+	ds.instanceLastStatLock.RUnlock()
 
-	ds.nodeLastStatLock.Lock()
+	ds.instancesLock.Lock()
+	ds.nodesLock.RLock()
 
-	delete(ds.nodeLastStat, stat.NodeUUID)
-	ds.nodeLastStat[stat.NodeUUID] = cnStat
+	for nodeID, r := range reports {
+		n, ok := ds.nodes[nodeID]
+		if !ok {
+			continue
+		}
 
-	ds.nodeLastStatLock.Unlock()
+		reported := make(map[string]bool)
+		for _, stat := range r.instances {
+			reported[stat.InstanceUUID] = true
+		}
 
-	return ds.db.addNodeStatDB(stat)
-}
+		for _, i := range n.instances {
+			if reported[i.ID] || i.NodeID != nodeID || i.State == types.InstanceLost {
+				continue
+			}
 
-var tenantUsagePeriodMinutes float64 = 5
+			if ds.instances[i.ID] == nil || r.timestamp.Sub(lastSeen[i.ID]) <= grace {
+				continue
+			}
 
-func (ds *Datastore) updateTenantUsageNeeded(delta payloads.CiaoUsage, tenantID string) bool {
-	if delta.VCPU == 0 &&
-		delta.Memory == 0 &&
-		delta.Disk == 0 {
-		return false
+			transitions = append(transitions, types.InstanceTransition{
+				InstanceID: i.ID,
+				TenantID:   i.TenantID,
+				From:       i.State,
+				To:         types.InstanceLost,
+				Source:     sourceReconciler,
+				NodeID:     nodeID,
+				Reason:     "Not reported by its node",
+			})
+			i.State = types.InstanceLost
+		}
 	}
 
-	return true
-}
+	ds.nodesLock.RUnlock()
+	ds.instancesLock.Unlock()
 
-func (ds *Datastore) updateTenantUsage(delta payloads.CiaoUsage, tenantID string) {
-	if ds.updateTenantUsageNeeded(delta, tenantID) == false {
-		return
+	for _, t := range transitions {
+		ds.addTransition(t)
+
+		msg := fmt.Sprintf("Instance %s lost by node %s", t.InstanceID, t.NodeID)
+		ds.logEvent(t.TenantID, string(userWarn), msg)
 	}
+}
 
-	createNewUsage := true
-	lastUsage := payloads.CiaoUsage{}
+func (ds *Datastore) reconcileAttachments(reports map[string]nodeReport) {
+	var stale []types.StorageAttachment
+	volumes := make(map[string][]string)
+	tenants := make(map[string]string)
 
-	ds.tenantUsageLock.Lock()
+	ds.instancesLock.RLock()
+
+	for nodeID, r := range reports {
+		for _, stat := range r.instances {
+			i, ok := ds.instances[stat.InstanceUUID]
+			if ok && i.NodeID == nodeID {
+				volumes[i.ID] = stat.Volumes
+				tenants[i.ID] = i.TenantID
+			}
+		}
+	}
+
+	ds.attachLock.RLock()
+	for _, a := range ds.attachments {
+		if _, ok := ds.instances[a.InstanceID]; !ok {
+			stale = append(stale, a)
+		}
+	}
+	ds.attachLock.RUnlock()
+
+	ds.instancesLock.RUnlock()
+
+	for instanceID, v := range volumes {
+		attached, detached := ds.updateStorageAttachments(instanceID, v)
+		for _, volumeID := range attached {
+			msg := fmt.Sprintf("Volume %s found attached to instance %s", volumeID, instanceID)
+			ds.logEvent(tenants[instanceID], string(userWarn), msg)
+		}
+		for _, volumeID := range detached {
+			msg := fmt.Sprintf("Volume %s found detached from instance %s", volumeID, instanceID)
+			ds.logEvent(tenants[instanceID], string(userWarn), msg)
+		}
+	}
+
+	for _, a := range stale {
+		err := ds.deleteStorageAttachment(a.ID)
+		if err != nil {
+			glog.Warning(err)
+			continue
+		}
+
+		bd, err := ds.GetBlockDevice(a.BlockID)
+		if err == nil {
+			bd.State = types.Available
+			err = ds.UpdateBlockDevice(bd)
+		}
+		if err != nil {
+			glog.Warning(err)
+		}
+
+		msg := fmt.Sprintf("Volume %s detached from unknown instance %s", a.BlockID, a.InstanceID)
+		ds.logEvent(bd.TenantID, string(userWarn), msg)
+	}
+}
+
+func (ds *Datastore) updateOrphans(reports map[string]nodeReport, grace time.Duration) []types.OrphanInstance {
+	var orphans []types.OrphanInstance
+	var found []types.OrphanInstance
+
+	unknown := make(map[string]string)
+
+	ds.instancesLock.RLock()
+	for nodeID, r := range reports {
+		for _, stat := range r.instances {
+			if _, ok := ds.instances[stat.InstanceUUID]; !ok {
+				unknown[stat.InstanceUUID] = nodeID
+			}
+		}
+	}
+	ds.instancesLock.RUnlock()
+
+	now := time.Now()
+
+	ds.orphansLock.Lock()
+
+	for id := range ds.orphans {
+		if _, ok := unknown[id]; !ok {
+			delete(ds.orphans, id)
+		}
+	}
+
+	for id, nodeID := range unknown {
+		o, ok := ds.orphans[id]
+		if !ok || o.NodeID != nodeID {
+			o = &orphan{
+				OrphanInstance: types.OrphanInstance{
+					ID:     id,
+					NodeID: nodeID,
+					Since:  now,
+				},
+			}
+			ds.orphans[id] = o
+		}
+
+		if now.Sub(o.Since) < grace {
+			continue
+		}
+
+		if !o.confirmed {
+			o.confirmed = true
+			found = append(found, o.OrphanInstance)
+		}
+		orphans = append(orphans, o.OrphanInstance)
+	}
+
+	ds.orphansLock.Unlock()
+
+	for _, o := range found {
+		msg := fmt.Sprintf("Unknown instance %s running on node %s", o.ID, o.NodeID)
+		ds.logEvent("", string(userWarn), msg)
+	}
+
+	return orphans
+}
+
+// HandleTraceReport stores the provided trace data in the datastore.
+func (ds *Datastore) HandleTraceReport(trace payloads.Trace) error {
+	var label string
+	if len(trace.Frames) > 0 {
+		label = trace.Frames[0].Label
+	}
+
+	ds.publishEvent(types.Event{
+		Kind:    types.EventTraceReport,
+		Message: fmt.Sprintf("Trace report of %d frame(s) labelled %q", len(trace.Frames), label),
+	})
+
+	for index := range trace.Frames {
+		i := trace.Frames[index]
+
+		err := ds.db.addFrameStat(i)
+		if err != nil {
+			glog.Warning(err)
+		}
+	}
+
+	return nil
+}
+
+// GetInstanceLastStats retrieves the last instances stats received for this node.
+// It returns it in a format suitable for the compute API.
+func (ds *Datastore) GetInstanceLastStats(nodeID string) payloads.CiaoServersStats {
+	var serversStats payloads.CiaoServersStats
+
+	ds.instanceLastStatLock.RLock()
+	for _, instance := range ds.instanceLastStat {
+		if instance.NodeID != nodeID {
+			continue
+		}
+		serversStats.Servers = append(serversStats.Servers, instance)
+	}
+	ds.instanceLastStatLock.RUnlock()
+
+	return serversStats
+}
+
+// GetNodeLastStats retrieves the last nodes stats received for this node.
+// It returns it in a format suitable for the compute API.
+func (ds *Datastore) GetNodeLastStats() payloads.CiaoComputeNodes {
+	var computeNodes payloads.CiaoComputeNodes
+
+	ds.nodeLastStatLock.RLock()
+	for _, node := range ds.nodeLastStat {
+		computeNodes.Nodes = append(computeNodes.Nodes, node)
+	}
+	ds.nodeLastStatLock.RUnlock()
+
+	return computeNodes
+}
+
+func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
+	ds.nodesLock.Lock()
+
+	n, ok := ds.nodes[stat.NodeUUID]
+	if !ok {
+		n = &node{}
+		n.instances = make(map[string]*types.Instance)
+		ds.nodes[stat.NodeUUID] = n
+	}
+
+	n.ID = stat.NodeUUID
+	n.Hostname = stat.NodeHostName
+	if len(stat.Networks) > 0 {
+		n.IPAddr = stat.Networks[0].NodeIP
+	}
+
+	ds.nodesLock.Unlock()
+
+	cnStat := payloads.CiaoComputeNode{
+		ID:            stat.NodeUUID,
+		Status:        stat.Status,
+		Load:          stat.Load,
+		MemTotal:      stat.MemTotalMB,
+		MemAvailable:  stat.MemAvailableMB,
+		DiskTotal:     stat.DiskTotalMB,
+		DiskAvailable: stat.DiskAvailableMB,
+		OnlineCPUs:    stat.CpusOnline,
+	}
+
+	ds.nodeLastStatLock.Lock()
+
+	delete(ds.nodeLastStat, stat.NodeUUID)
+	ds.nodeLastStat[stat.NodeUUID] = cnStat
+
+	ds.nodeLastStatLock.Unlock()
+
+	return ds.db.addNodeStatDB(stat)
+}
+
+var tenantUsagePeriodMinutes float64 = 5
+
+func (ds *Datastore) updateTenantUsageNeeded(delta payloads.CiaoUsage, tenantID string) bool {
+	if delta.VCPU == 0 &&
+		delta.Memory == 0 &&
+		delta.Disk == 0 {
+		return false
+	}
+
+	return true
+}
+
+func (ds *Datastore) updateTenantUsage(delta payloads.CiaoUsage, tenantID string) {
+	if ds.updateTenantUsageNeeded(delta, tenantID) == false {
+		return
+	}
+
+	createNewUsage := true
+	lastUsage := payloads.CiaoUsage{}
+
+	ds.tenantUsageLock.Lock()
 
 	tenantUsage := ds.tenantUsage[tenantID]
 	if len(tenantUsage) != 0 {
@@ -1248,9 +2483,18 @@ func reduceToZero(v int) int {
 }
 
 func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+	var transitions []types.InstanceTransition
+	var reported []payloads.InstanceStat
+
 	for index := range stats {
 		stat := stats[index]
 
+		// the copies of rescheduled instances are about to be deleted.
+		if ds.isStaleCopy(stat.InstanceUUID, nodeID) {
+			continue
+		}
+		reported = append(reported, stat)
+
 		instanceStat := payloads.CiaoServerStats{
 			ID:        stat.InstanceUUID,
 			NodeID:    nodeID,
@@ -1283,6 +2527,22 @@ func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID stri
 		ds.instancesLock.Lock()
 		instance, ok := ds.instances[stat.InstanceUUID]
 		if ok {
+			if instance.State != stat.State {
+				transitions = append(transitions, types.InstanceTransition{
+					InstanceID: instance.ID,
+					TenantID:   instance.TenantID,
+					From:       instance.State,
+					To:         stat.State,
+					Source:     sourceStats,
+					NodeID:     nodeID,
+				})
+			}
+
+			// resized instances are running with their new resources.
+			if stat.State == payloads.Running {
+				delete(ds.resizes, instance.ID)
+			}
+
 			instance.State = stat.State
 			instance.NodeID = nodeID
 			instance.SSHIP = stat.SSHIP
@@ -1296,13 +2556,17 @@ func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID stri
 		ds.updateStorageAttachments(stat.InstanceUUID, stat.Volumes)
 	}
 
-	return ds.db.addInstanceStatsDB(stats, nodeID)
+	for _, t := range transitions {
+		ds.addTransition(t)
+	}
+
+	return ds.db.addInstanceStatsDB(reported, nodeID)
 }
 
 // GetTenantCNCISummary retrieves information about a given CNCI id, or all CNCIs
 // If the cnci string is the null string, then this function will retrieve all
 // tenants.  If cnci is not null, it will only provide information about a specific
-// cnci.
+// cnci.  The CNCIs are sorted by tenant ID.
 func (ds *Datastore) GetTenantCNCISummary(cnci string) ([]types.TenantCNCI, error) {
 	var cncis []types.TenantCNCI
 	subnetBytes := []byte{0, 0}
@@ -1335,9 +2599,17 @@ func (ds *Datastore) GetTenantCNCISummary(cnci string) ([]types.TenantCNCI, erro
 
 	ds.tenantsLock.RUnlock()
 
+	sort.Sort(sortedCNCIsByTenant(cncis))
+
 	return cncis, nil
 }
 
+type sortedCNCIsByTenant []types.TenantCNCI
+
+func (s sortedCNCIsByTenant) Len() int           { return len(s) }
+func (s sortedCNCIsByTenant) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortedCNCIsByTenant) Less(i, j int) bool { return s[i].TenantID < s[j].TenantID }
+
 // GetCNCIWorkloadID returns the UUID of the workload template
 // for the CNCI workload
 func (ds *Datastore) GetCNCIWorkloadID() (string, error) {
@@ -1382,6 +2654,114 @@ func (ds *Datastore) ClearLog() error {
 	return ds.db.clearLog()
 }
 
+// logEvent adds an entry to the event log, and pushes it to the event
+// watchers.
+func (ds *Datastore) logEvent(tenantID string, eventType string, message string) error {
+	ds.publishEvent(types.Event{
+		Kind:      types.EventLog,
+		TenantID:  tenantID,
+		EventType: eventType,
+		Message:   message,
+	})
+
+	return ds.db.logEvent(tenantID, eventType, message)
+}
+
+// NodeConnected pushes the connection of a node to the event watchers.
+func (ds *Datastore) NodeConnected(nodeID string) {
+	ds.publishEvent(types.Event{
+		Kind:    types.EventNodeConnected,
+		NodeID:  nodeID,
+		Message: fmt.Sprintf("Node %s connected", nodeID),
+	})
+}
+
+// publishEvent numbers an event, adds it to the backlog of the latest
+// events and pushes it to the event watchers.  The watchers which fall
+// too far behind are dropped, their channel is closed.
+func (ds *Datastore) publishEvent(e types.Event) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	ds.eventsLock.Lock()
+	defer ds.eventsLock.Unlock()
+
+	ds.lastEventID++
+	e.ID = ds.lastEventID
+
+	ds.events = append(ds.events, e)
+	if len(ds.events) > eventBacklog {
+		ds.events = append([]types.Event(nil), ds.events[len(ds.events)-eventBacklog:]...)
+	}
+
+	for ch := range ds.eventWatchers {
+		select {
+		case ch <- e:
+		default:
+			delete(ds.eventWatchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// WatchEvents returns the events of the backlog which happened after the
+// event since, and a channel receiving the events which happen next.  The
+// channel is closed if the watcher falls behind, in which case it can
+// resume from the last event it received.  It must be released with
+// UnwatchEvents.  If the event since is no longer in the backlog, or was
+// published before the controller restarted, the backlog starts with an
+// EventGap event.
+func (ds *Datastore) WatchEvents(since uint64) ([]types.Event, chan types.Event) {
+	var backlog []types.Event
+
+	ds.eventsLock.Lock()
+	defer ds.eventsLock.Unlock()
+
+	// the ID of the oldest event the watcher can resume from.
+	oldest := ds.lastEventID
+	if len(ds.events) > 0 {
+		oldest = ds.events[0].ID - 1
+	}
+
+	if since != 0 && (since < oldest || since > ds.lastEventID) {
+		backlog = append(backlog, types.Event{
+			ID:        oldest,
+			Timestamp: time.Now().UTC(),
+			Kind:      types.EventGap,
+			Message:   fmt.Sprintf("Events after %d were missed", since),
+		})
+		since = oldest
+	}
+
+	for _, e := range ds.events {
+		if e.ID > since {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch := make(chan types.Event, eventWatcherQueue)
+	ds.eventWatchers[ch] = true
+
+	return backlog, ch
+}
+
+// UnwatchEvents releases a channel returned by WatchEvents.
+func (ds *Datastore) UnwatchEvents(ch chan types.Event) {
+	ds.eventsLock.Lock()
+	defer ds.eventsLock.Unlock()
+
+	if ds.eventWatchers[ch] {
+		delete(ds.eventWatchers, ch)
+		close(ch)
+	}
+}
+
+// QueryMetrics returns the latency histograms of the datastore queries.
+func (ds *Datastore) QueryMetrics() metrics.Metric {
+	return ds.queries.duration
+}
+
 // AddBlockDevice will store information about new BlockData into
 // the datastore.
 func (ds *Datastore) AddBlockDevice(device types.BlockData) error {
@@ -1392,8 +2772,11 @@ func (ds *Datastore) AddBlockDevice(device types.BlockData) error {
 
 	// update tenants cache
 	ds.tenantsLock.Lock()
-	devices := ds.tenants[device.TenantID].devices
-	devices[device.ID] = device
+	tenant := ds.tenants[device.TenantID]
+	tenant.devices[device.ID] = device
+	if !update {
+		updateVolumesUsage(tenant, 1)
+	}
 	ds.tenantsLock.Unlock()
 
 	// store persistently
@@ -1406,6 +2789,16 @@ func (ds *Datastore) AddBlockDevice(device types.BlockData) error {
 	return nil
 }
 
+// updateVolumesUsage updates the volumes usage of a cached tenant.
+// The caller must hold the tenants lock.
+func updateVolumesUsage(t *tenant, delta int) {
+	for i := range t.Resources {
+		if t.Resources[i].Rname == "volumes" {
+			t.Resources[i].Usage += delta
+		}
+	}
+}
+
 // DeleteBlockDevice will delete a volume from the datastore.
 // It also deletes it from the tenant's list of devices.
 func (ds *Datastore) DeleteBlockDevice(ID string) error {
@@ -1419,6 +2812,7 @@ func (ds *Datastore) DeleteBlockDevice(ID string) error {
 	if ok {
 		delete(ds.blockDevices, ID)
 		delete(ds.tenants[dev.TenantID].devices, ID)
+		updateVolumesUsage(ds.tenants[dev.TenantID], -1)
 	}
 
 	ds.tenantsLock.Unlock()
@@ -1520,8 +2914,9 @@ func (ds *Datastore) GetStorageAttachments(instanceID string) ([]types.StorageAt
 	return links, nil
 }
 
-func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []string) {
-
+// updateStorageAttachments makes the attachments of an instance match the
+// volumes reported for it, returning the volumes attached and detached.
+func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []string) (attached []string, detached []string) {
 	m := make(map[string]bool)
 
 	// this for handy searching.
@@ -1548,6 +2943,7 @@ func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []strin
 			}
 			ds.attachments[a.ID] = a
 			ds.instanceVolumes[key] = a.ID
+			attached = append(attached, v)
 
 			// not sure what to do with an error here.
 			err := ds.db.createStorageAttachment(a)
@@ -1575,10 +2971,16 @@ func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []strin
 
 	// finally, check to see if all the attachments we already
 	// know about are in the list.
-	for _, ID := range ds.instanceVolumes {
+	for key, ID := range ds.instanceVolumes {
+		if key.instanceID != instanceID {
+			continue
+		}
+
 		a := ds.attachments[ID]
 
 		if !m[a.BlockID] {
+			detached = append(detached, a.BlockID)
+
 			bd, err := ds.GetBlockDevice(a.BlockID)
 			if err != nil {
 				glog.Warning(err)
@@ -1593,16 +2995,18 @@ func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []strin
 			}
 
 			// delete the attachment.
-			key := attachment{
-				instanceID: a.InstanceID,
-				volumeID:   a.BlockID,
-			}
-
 			delete(ds.attachments, ID)
 			delete(ds.instanceVolumes, key)
+
+			err = ds.db.deleteStorageAttachment(ID)
+			if err != nil {
+				glog.Warning(err)
+			}
 		}
 	}
 	ds.attachLock.Unlock()
+
+	return attached, detached
 }
 
 func (ds *Datastore) getStorageAttachment(instanceID string, volumeID string) (types.StorageAttachment, error) {
@@ -1648,6 +3052,34 @@ func (ds *Datastore) deleteStorageAttachment(ID string) error {
 	return ds.db.deleteStorageAttachment(ID)
 }
 
+// DeleteVolumeAttachments removes all the attachments of a volume, whose
+// instances are gone, and makes the volume available again.
+func (ds *Datastore) DeleteVolumeAttachments(volume string) error {
+	attachments, err := ds.GetVolumeAttachments(volume)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		err = ds.deleteStorageAttachment(a.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	bd, err := ds.GetBlockDevice(volume)
+	if err != nil {
+		return err
+	}
+
+	if bd.State == types.Available {
+		return nil
+	}
+
+	bd.State = types.Available
+	return ds.UpdateBlockDevice(bd)
+}
+
 // GetVolumeAttachments will return a list of attachments associated with
 // this volume ID.
 func (ds *Datastore) GetVolumeAttachments(volume string) ([]types.StorageAttachment, error) {
@@ -1665,3 +3097,917 @@ func (ds *Datastore) GetVolumeAttachments(volume string) ([]types.StorageAttachm
 
 	return attachments, nil
 }
+
+// maxFloatingIPPoolSize limits the number of addresses a floating IP pool
+// CIDR range can expand to.
+const maxFloatingIPPoolSize = 65536
+
+// parseFloatingIPPool expands the IPv4 addresses and CIDR ranges of a
+// floating IP pool into a set of addresses. The network and broadcast
+// addresses of CIDR ranges are left out.
+func parseFloatingIPPool(pool []string) (map[string]bool, error) {
+	addresses := make(map[string]bool)
+
+	for _, entry := range pool {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil || ip.To4() == nil {
+				return nil, fmt.Errorf("Invalid floating IP address %s", entry)
+			}
+			addresses[ip.To4().String()] = true
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil || ipNet.IP.To4() == nil {
+			return nil, fmt.Errorf("Invalid floating IP range %s", entry)
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		size := 1 << uint(bits-ones)
+		if len(addresses)+size > maxFloatingIPPoolSize {
+			return nil, fmt.Errorf("Floating IP range %s is too large", entry)
+		}
+
+		first := binary.BigEndian.Uint32(ipNet.IP.To4())
+		for n := 0; n < size; n++ {
+			if size > 2 && (n == 0 || n == size-1) {
+				continue
+			}
+
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, first+uint32(n))
+			addresses[ip.String()] = true
+		}
+	}
+
+	return addresses, nil
+}
+
+// SetFloatingIPPool updates the floating IP pool to the given list of
+// IPv4 addresses and CIDR ranges. Addresses new to the pool become
+// available for allocation, and available addresses no longer in the
+// pool are removed. Allocated addresses are kept until released. An
+// empty list, as given by a configuration without a pool, leaves the
+// pool unchanged.
+func (ds *Datastore) SetFloatingIPPool(pool []string) error {
+	if len(pool) == 0 {
+		return nil
+	}
+
+	addresses, err := parseFloatingIPPool(pool)
+	if err != nil {
+		return err
+	}
+
+	ds.floatingIPsLock.Lock()
+	defer ds.floatingIPsLock.Unlock()
+
+	for ID, ip := range ds.floatingIPs {
+		if addresses[ip.Address] {
+			delete(addresses, ip.Address)
+			continue
+		}
+
+		if ip.State != types.FloatingIPAvailable {
+			glog.Warningf("Floating IP %s is allocated but no longer in pool", ip.Address)
+			continue
+		}
+
+		err = ds.db.deleteFloatingIP(ID)
+		if err != nil {
+			return err
+		}
+		delete(ds.floatingIPs, ID)
+	}
+
+	for address := range addresses {
+		ip := types.FloatingIP{
+			ID:      uuid.Generate().String(),
+			Address: address,
+			State:   types.FloatingIPAvailable,
+		}
+
+		err = ds.db.createFloatingIP(ip)
+		if err != nil {
+			return err
+		}
+		ds.floatingIPs[ip.ID] = ip
+	}
+
+	return nil
+}
+
+// GetFloatingIPs returns the floating IPs allocated to a tenant, sorted
+// by address.
+func (ds *Datastore) GetFloatingIPs(tenantID string) []types.FloatingIP {
+	var ips []types.FloatingIP
+
+	ds.floatingIPsLock.RLock()
+	for _, ip := range ds.floatingIPs {
+		if ip.State != types.FloatingIPAvailable && ip.TenantID == tenantID {
+			ips = append(ips, ip)
+		}
+	}
+	ds.floatingIPsLock.RUnlock()
+
+	sort.Sort(sortedFloatingIPsByAddress(ips))
+
+	return ips
+}
+
+// GetFloatingIP returns a floating IP from the datastore.
+func (ds *Datastore) GetFloatingIP(ID string) (types.FloatingIP, error) {
+	ds.floatingIPsLock.RLock()
+	ip, ok := ds.floatingIPs[ID]
+	ds.floatingIPsLock.RUnlock()
+
+	if !ok {
+		return types.FloatingIP{}, ErrNoFloatingIP
+	}
+
+	return ip, nil
+}
+
+func (ds *Datastore) findFloatingIP(match func(ip types.FloatingIP) bool) (types.FloatingIP, error) {
+	for _, ip := range ds.floatingIPs {
+		if match(ip) {
+			return ip, nil
+		}
+	}
+
+	return types.FloatingIP{}, ErrNoFloatingIP
+}
+
+// GetFloatingIPByAddress returns the floating IP with the given
+// public address.
+func (ds *Datastore) GetFloatingIPByAddress(address string) (types.FloatingIP, error) {
+	ds.floatingIPsLock.RLock()
+	defer ds.floatingIPsLock.RUnlock()
+
+	return ds.findFloatingIP(func(ip types.FloatingIP) bool {
+		return ip.Address == address
+	})
+}
+
+// GetInstanceFloatingIP returns the floating IP associated with an
+// instance.
+func (ds *Datastore) GetInstanceFloatingIP(instanceID string) (types.FloatingIP, error) {
+	ds.floatingIPsLock.RLock()
+	defer ds.floatingIPsLock.RUnlock()
+
+	return ds.findFloatingIP(func(ip types.FloatingIP) bool {
+		return ip.InstanceID == instanceID
+	})
+}
+
+// AllocateFloatingIP allocates an available floating IP from the pool
+// to a tenant.
+func (ds *Datastore) AllocateFloatingIP(tenantID string) (types.FloatingIP, error) {
+	var available []types.FloatingIP
+
+	ds.floatingIPsLock.Lock()
+	defer ds.floatingIPsLock.Unlock()
+
+	for _, ip := range ds.floatingIPs {
+		if ip.State == types.FloatingIPAvailable {
+			available = append(available, ip)
+		}
+	}
+
+	if len(available) == 0 {
+		return types.FloatingIP{}, ErrNoFreeFloatingIP
+	}
+
+	sort.Sort(sortedFloatingIPsByAddress(available))
+
+	ip := available[0]
+	ip.TenantID = tenantID
+	ip.State = types.FloatingIPAllocated
+
+	err := ds.db.updateFloatingIP(ip)
+	if err != nil {
+		return types.FloatingIP{}, err
+	}
+	ds.floatingIPs[ip.ID] = ip
+
+	msg := fmt.Sprintf("Allocated floating IP %s", ip.Address)
+	ds.logEvent(tenantID, string(userInfo), msg)
+
+	return ip, nil
+}
+
+// ReleaseFloatingIP returns a floating IP that is not associated with
+// any instance to the pool.
+func (ds *Datastore) ReleaseFloatingIP(ID string) error {
+	ds.floatingIPsLock.Lock()
+	defer ds.floatingIPsLock.Unlock()
+
+	ip, ok := ds.floatingIPs[ID]
+	if !ok || ip.State == types.FloatingIPAvailable {
+		return ErrNoFloatingIP
+	}
+
+	if ip.InstanceID != "" {
+		return ErrFloatingIPInUse
+	}
+
+	tenantID := ip.TenantID
+	ip.TenantID = ""
+	ip.State = types.FloatingIPAvailable
+
+	err := ds.db.updateFloatingIP(ip)
+	if err != nil {
+		return err
+	}
+	ds.floatingIPs[ID] = ip
+
+	msg := fmt.Sprintf("Released floating IP %s", ip.Address)
+	ds.logEvent(tenantID, string(userInfo), msg)
+
+	return nil
+}
+
+// AssociateFloatingIP associates an allocated floating IP with an
+// instance. The address is only reported as the instance public IP
+// once the tenant CNCI has assigned it.
+func (ds *Datastore) AssociateFloatingIP(ID string, instanceID string) (types.FloatingIP, error) {
+	ds.floatingIPsLock.Lock()
+	defer ds.floatingIPsLock.Unlock()
+
+	ip, ok := ds.floatingIPs[ID]
+	if !ok || ip.State == types.FloatingIPAvailable {
+		return types.FloatingIP{}, ErrNoFloatingIP
+	}
+
+	if ip.InstanceID != "" {
+		return types.FloatingIP{}, ErrFloatingIPInUse
+	}
+
+	_, err := ds.findFloatingIP(func(ip types.FloatingIP) bool {
+		return ip.InstanceID == instanceID
+	})
+	if err == nil {
+		return types.FloatingIP{}, ErrInstanceFloatingIP
+	}
+
+	ip.InstanceID = instanceID
+	ip.State = types.FloatingIPAssociating
+
+	err = ds.db.updateFloatingIP(ip)
+	if err != nil {
+		return types.FloatingIP{}, err
+	}
+	ds.floatingIPs[ID] = ip
+
+	return ip, nil
+}
+
+// DisassociateFloatingIP removes the association between a floating IP
+// and its instance. It returns the floating IP as it was before being
+// disassociated.
+func (ds *Datastore) DisassociateFloatingIP(ID string) (types.FloatingIP, error) {
+	ds.floatingIPsLock.Lock()
+	defer ds.floatingIPsLock.Unlock()
+
+	ip, ok := ds.floatingIPs[ID]
+	if !ok || ip.State == types.FloatingIPAvailable {
+		return types.FloatingIP{}, ErrNoFloatingIP
+	}
+
+	if ip.InstanceID == "" {
+		return types.FloatingIP{}, ErrFloatingIPNotInUse
+	}
+
+	err := ds.disassociateFloatingIP(ip)
+	if err != nil {
+		return types.FloatingIP{}, err
+	}
+
+	return ip, nil
+}
+
+// disassociateFloatingIP must be called with the floatingIPsLock held.
+func (ds *Datastore) disassociateFloatingIP(ip types.FloatingIP) error {
+	instanceID := ip.InstanceID
+
+	ip.InstanceID = ""
+	ip.State = types.FloatingIPAllocated
+
+	err := ds.db.updateFloatingIP(ip)
+	if err != nil {
+		return err
+	}
+	ds.floatingIPs[ip.ID] = ip
+
+	ds.instancesLock.Lock()
+	i, ok := ds.instances[instanceID]
+	if ok {
+		i.PublicIP = ""
+	}
+	ds.instancesLock.Unlock()
+
+	return nil
+}
+
+// clearInstanceFloatingIP disassociates the floating IP of a deleted
+// instance, keeping it allocated to the tenant.
+func (ds *Datastore) clearInstanceFloatingIP(instanceID string) {
+	ds.floatingIPsLock.Lock()
+	defer ds.floatingIPsLock.Unlock()
+
+	ip, err := ds.findFloatingIP(func(ip types.FloatingIP) bool {
+		return ip.InstanceID == instanceID
+	})
+	if err != nil {
+		return
+	}
+
+	err = ds.disassociateFloatingIP(ip)
+	if err != nil {
+		glog.Warningf("Unable to disassociate floating IP %s: %v", ip.Address, err)
+	}
+}
+
+// PublicIPAssigned marks a floating IP as assigned by the tenant CNCI
+// and reports it as the public IP of its instance.
+func (ds *Datastore) PublicIPAssigned(event payloads.PublicIPEvent) error {
+	ds.floatingIPsLock.Lock()
+	defer ds.floatingIPsLock.Unlock()
+
+	ip, err := ds.findFloatingIP(func(ip types.FloatingIP) bool {
+		return ip.Address == event.PublicIP
+	})
+	if err != nil {
+		return err
+	}
+
+	if ip.InstanceID != event.InstanceUUID {
+		return ErrFloatingIPNotInUse
+	}
+
+	ip.State = types.FloatingIPAssigned
+
+	err = ds.db.updateFloatingIP(ip)
+	if err != nil {
+		return err
+	}
+	ds.floatingIPs[ip.ID] = ip
+
+	ds.instancesLock.Lock()
+	i, ok := ds.instances[ip.InstanceID]
+	if ok {
+		i.PublicIP = ip.Address
+	}
+	ds.instancesLock.Unlock()
+
+	msg := fmt.Sprintf("Assigned public IP %s to instance %s", ip.Address, ip.InstanceID)
+	ds.logEvent(ip.TenantID, string(userInfo), msg)
+
+	return nil
+}
+
+type sortedFloatingIPsByAddress []types.FloatingIP
+
+func (s sortedFloatingIPsByAddress) Len() int      { return len(s) }
+func (s sortedFloatingIPsByAddress) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortedFloatingIPsByAddress) Less(i, j int) bool {
+	a := net.ParseIP(s[i].Address).To4()
+	b := net.ParseIP(s[j].Address).To4()
+	return binary.BigEndian.Uint32(a) < binary.BigEndian.Uint32(b)
+}
+
+// AddSecurityGroup adds a new security group to the datastore. Group
+// names must be unique within a tenant.
+func (ds *Datastore) AddSecurityGroup(group types.SecurityGroup) error {
+	ds.securityGroupsLock.Lock()
+	defer ds.securityGroupsLock.Unlock()
+
+	for _, g := range ds.securityGroups {
+		if g.TenantID == group.TenantID && g.Name == group.Name {
+			return ErrSecurityGroupExists
+		}
+	}
+
+	err := ds.db.createSecurityGroup(group)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range group.Rules {
+		err = ds.db.createSecurityGroupRule(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	ds.securityGroups[group.ID] = group
+
+	return nil
+}
+
+// GetSecurityGroups returns the security groups of a tenant, sorted by
+// name.
+func (ds *Datastore) GetSecurityGroups(tenantID string) []types.SecurityGroup {
+	var groups []types.SecurityGroup
+
+	ds.securityGroupsLock.RLock()
+	for _, g := range ds.securityGroups {
+		if g.TenantID == tenantID {
+			groups = append(groups, g)
+		}
+	}
+	ds.securityGroupsLock.RUnlock()
+
+	sort.Sort(sortedSecurityGroupsByName(groups))
+
+	return groups
+}
+
+// GetSecurityGroup returns a security group from the datastore.
+func (ds *Datastore) GetSecurityGroup(ID string) (types.SecurityGroup, error) {
+	ds.securityGroupsLock.RLock()
+	defer ds.securityGroupsLock.RUnlock()
+
+	g, ok := ds.securityGroups[ID]
+	if !ok {
+		return types.SecurityGroup{}, ErrNoSecurityGroup
+	}
+
+	return g, nil
+}
+
+// GetSecurityGroupByName returns the security group of a tenant with the
+// given name.
+func (ds *Datastore) GetSecurityGroupByName(tenantID string, name string) (types.SecurityGroup, error) {
+	ds.securityGroupsLock.RLock()
+	defer ds.securityGroupsLock.RUnlock()
+
+	for _, g := range ds.securityGroups {
+		if g.TenantID == tenantID && g.Name == name {
+			return g, nil
+		}
+	}
+
+	return types.SecurityGroup{}, ErrNoSecurityGroup
+}
+
+// GetSecurityGroupInstances returns the instances that belong to a
+// security group.
+func (ds *Datastore) GetSecurityGroupInstances(groupID string) []*types.Instance {
+	var instances []*types.Instance
+
+	ds.instancesLock.RLock()
+	defer ds.instancesLock.RUnlock()
+
+	for _, i := range ds.instances {
+		for _, g := range i.SecurityGroups {
+			if g == groupID {
+				instances = append(instances, i)
+				break
+			}
+		}
+	}
+
+	sort.Sort(types.SortedInstancesByID(instances))
+
+	return instances
+}
+
+// DeleteSecurityGroup removes a security group and its rules from the
+// datastore. Groups that still have instances can not be deleted.
+func (ds *Datastore) DeleteSecurityGroup(ID string) error {
+	ds.securityGroupsLock.Lock()
+	defer ds.securityGroupsLock.Unlock()
+
+	_, ok := ds.securityGroups[ID]
+	if !ok {
+		return ErrNoSecurityGroup
+	}
+
+	if len(ds.GetSecurityGroupInstances(ID)) > 0 {
+		return ErrSecurityGroupInUse
+	}
+
+	err := ds.db.deleteSecurityGroup(ID)
+	if err != nil {
+		return err
+	}
+
+	delete(ds.securityGroups, ID)
+
+	return nil
+}
+
+// AddSecurityGroupRule adds a rule to an existing security group.
+func (ds *Datastore) AddSecurityGroupRule(rule types.SecurityGroupRule) error {
+	ds.securityGroupsLock.Lock()
+	defer ds.securityGroupsLock.Unlock()
+
+	g, ok := ds.securityGroups[rule.GroupID]
+	if !ok {
+		return ErrNoSecurityGroup
+	}
+
+	err := ds.db.createSecurityGroupRule(rule)
+	if err != nil {
+		return err
+	}
+
+	rules := make([]types.SecurityGroupRule, 0, len(g.Rules)+1)
+	g.Rules = append(append(rules, g.Rules...), rule)
+	ds.securityGroups[g.ID] = g
+
+	return nil
+}
+
+// DeleteSecurityGroupRule removes a rule from its security group. It
+// returns the deleted rule.
+func (ds *Datastore) DeleteSecurityGroupRule(ID string) (types.SecurityGroupRule, error) {
+	ds.securityGroupsLock.Lock()
+	defer ds.securityGroupsLock.Unlock()
+
+	for _, g := range ds.securityGroups {
+		for i, r := range g.Rules {
+			if r.ID != ID {
+				continue
+			}
+
+			err := ds.db.deleteSecurityGroupRule(ID)
+			if err != nil {
+				return types.SecurityGroupRule{}, err
+			}
+
+			rules := make([]types.SecurityGroupRule, 0, len(g.Rules)-1)
+			rules = append(rules, g.Rules[:i]...)
+			g.Rules = append(rules, g.Rules[i+1:]...)
+			ds.securityGroups[g.ID] = g
+
+			return r, nil
+		}
+	}
+
+	return types.SecurityGroupRule{}, ErrNoSecurityGroupRule
+}
+
+// AddInstanceSecurityGroup adds an instance to a security group of the
+// same tenant. Adding an instance to a group it already belongs to is
+// not an error.
+func (ds *Datastore) AddInstanceSecurityGroup(instanceID string, groupID string) error {
+	ds.securityGroupsLock.RLock()
+	g, ok := ds.securityGroups[groupID]
+	ds.securityGroupsLock.RUnlock()
+
+	ds.instancesLock.Lock()
+	defer ds.instancesLock.Unlock()
+
+	i, found := ds.instances[instanceID]
+	if !found {
+		return errors.New("Instance Not Found")
+	}
+
+	if !ok || g.TenantID != i.TenantID {
+		return ErrNoSecurityGroup
+	}
+
+	for _, ID := range i.SecurityGroups {
+		if ID == groupID {
+			return nil
+		}
+	}
+
+	err := ds.db.addInstanceSecurityGroup(instanceID, groupID)
+	if err != nil {
+		return err
+	}
+
+	groups := make([]string, 0, len(i.SecurityGroups)+1)
+	i.SecurityGroups = append(append(groups, i.SecurityGroups...), groupID)
+
+	return nil
+}
+
+// RemoveInstanceSecurityGroup removes an instance from a security group.
+func (ds *Datastore) RemoveInstanceSecurityGroup(instanceID string, groupID string) error {
+	ds.instancesLock.Lock()
+	defer ds.instancesLock.Unlock()
+
+	i, ok := ds.instances[instanceID]
+	if !ok {
+		return errors.New("Instance Not Found")
+	}
+
+	for index, ID := range i.SecurityGroups {
+		if ID != groupID {
+			continue
+		}
+
+		err := ds.db.removeInstanceSecurityGroup(instanceID, groupID)
+		if err != nil {
+			return err
+		}
+
+		groups := make([]string, 0, len(i.SecurityGroups)-1)
+		groups = append(groups, i.SecurityGroups[:index]...)
+		i.SecurityGroups = append(groups, i.SecurityGroups[index+1:]...)
+
+		return nil
+	}
+
+	return ErrNotInSecurityGroup
+}
+
+// GetInstanceSecurityRules returns the rules of all the security groups
+// an instance belongs to.
+func (ds *Datastore) GetInstanceSecurityRules(instance *types.Instance) []types.SecurityGroupRule {
+	var rules []types.SecurityGroupRule
+
+	ds.instancesLock.RLock()
+	groups := instance.SecurityGroups
+	ds.instancesLock.RUnlock()
+
+	ds.securityGroupsLock.RLock()
+	defer ds.securityGroupsLock.RUnlock()
+
+	for _, ID := range groups {
+		g, ok := ds.securityGroups[ID]
+		if ok {
+			rules = append(rules, g.Rules...)
+		}
+	}
+
+	return rules
+}
+
+type sortedSecurityGroupsByName []types.SecurityGroup
+
+func (s sortedSecurityGroupsByName) Len() int           { return len(s) }
+func (s sortedSecurityGroupsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortedSecurityGroupsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// keyPairID identifies a keypair, whose names are only unique within
+// a tenant.
+type keyPairID struct {
+	tenantID string
+	name     string
+}
+
+// AddKeyPair adds a new keypair to the datastore.
+func (ds *Datastore) AddKeyPair(key types.KeyPair) error {
+	ID := keyPairID{tenantID: key.TenantID, name: key.Name}
+
+	ds.keyPairsLock.Lock()
+	defer ds.keyPairsLock.Unlock()
+
+	_, ok := ds.keyPairs[ID]
+	if ok {
+		return ErrKeyPairExists
+	}
+
+	err := ds.db.createKeyPair(key)
+	if err != nil {
+		return err
+	}
+
+	ds.keyPairs[ID] = key
+
+	return nil
+}
+
+// GetKeyPairs returns the keypairs of a tenant, sorted by name.
+func (ds *Datastore) GetKeyPairs(tenantID string) []types.KeyPair {
+	var keys []types.KeyPair
+
+	ds.keyPairsLock.RLock()
+	for ID, k := range ds.keyPairs {
+		if ID.tenantID == tenantID {
+			keys = append(keys, k)
+		}
+	}
+	ds.keyPairsLock.RUnlock()
+
+	sort.Sort(sortedKeyPairsByName(keys))
+
+	return keys
+}
+
+// GetKeyPair returns the keypair of a tenant with the given name.
+func (ds *Datastore) GetKeyPair(tenantID string, name string) (types.KeyPair, error) {
+	ds.keyPairsLock.RLock()
+	defer ds.keyPairsLock.RUnlock()
+
+	k, ok := ds.keyPairs[keyPairID{tenantID: tenantID, name: name}]
+	if !ok {
+		return types.KeyPair{}, ErrNoKeyPair
+	}
+
+	return k, nil
+}
+
+// DeleteKeyPair removes a keypair from the datastore. Instances already
+// started with the key keep it.
+func (ds *Datastore) DeleteKeyPair(tenantID string, name string) error {
+	ID := keyPairID{tenantID: tenantID, name: name}
+
+	ds.keyPairsLock.Lock()
+	defer ds.keyPairsLock.Unlock()
+
+	_, ok := ds.keyPairs[ID]
+	if !ok {
+		return ErrNoKeyPair
+	}
+
+	err := ds.db.deleteKeyPair(tenantID, name)
+	if err != nil {
+		return err
+	}
+
+	delete(ds.keyPairs, ID)
+
+	return nil
+}
+
+type sortedKeyPairsByName []types.KeyPair
+
+func (s sortedKeyPairsByName) Len() int           { return len(s) }
+func (s sortedKeyPairsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortedKeyPairsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// AddWebhook adds a new webhook to the datastore.
+func (ds *Datastore) AddWebhook(webhook types.Webhook) error {
+	ds.webhooksLock.Lock()
+	defer ds.webhooksLock.Unlock()
+
+	err := ds.db.createWebhook(webhook)
+	if err != nil {
+		return err
+	}
+
+	ds.webhooks[webhook.ID] = webhook
+
+	return nil
+}
+
+// GetWebhooks returns the webhooks of a tenant, oldest first.  The webhooks
+// of the administrators have no tenant.
+func (ds *Datastore) GetWebhooks(tenantID string) []types.Webhook {
+	var webhooks []types.Webhook
+
+	ds.webhooksLock.RLock()
+	for _, w := range ds.webhooks {
+		if w.TenantID == tenantID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	ds.webhooksLock.RUnlock()
+
+	sort.Sort(sortedWebhooksByCreation(webhooks))
+
+	return webhooks
+}
+
+// GetAllWebhooks returns the webhooks of all the tenants and of the
+// administrators.
+func (ds *Datastore) GetAllWebhooks() []types.Webhook {
+	var webhooks []types.Webhook
+
+	ds.webhooksLock.RLock()
+	for _, w := range ds.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	ds.webhooksLock.RUnlock()
+
+	return webhooks
+}
+
+// GetWebhook returns a webhook by ID.
+func (ds *Datastore) GetWebhook(ID string) (types.Webhook, error) {
+	ds.webhooksLock.RLock()
+	defer ds.webhooksLock.RUnlock()
+
+	w, ok := ds.webhooks[ID]
+	if !ok {
+		return types.Webhook{}, ErrNoWebhook
+	}
+
+	return w, nil
+}
+
+// DeleteWebhook removes a webhook and its delivery log from the datastore.
+// The deliveries in progress are not cancelled.
+func (ds *Datastore) DeleteWebhook(ID string) error {
+	ds.webhooksLock.Lock()
+	defer ds.webhooksLock.Unlock()
+
+	_, ok := ds.webhooks[ID]
+	if !ok {
+		return ErrNoWebhook
+	}
+
+	err := ds.db.deleteWebhook(ID)
+	if err != nil {
+		return err
+	}
+
+	delete(ds.webhooks, ID)
+
+	return nil
+}
+
+// AddWebhookDelivery logs an attempt to deliver an event to a webhook.  Only
+// the latest webhookDeliveryRetention attempts of a webhook are kept.
+func (ds *Datastore) AddWebhookDelivery(delivery types.WebhookDelivery) error {
+	return ds.db.addWebhookDelivery(delivery)
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook, oldest
+// attempt first, skipping the first offset attempts.  At most limit
+// attempts are returned, all of them if limit is 0.
+func (ds *Datastore) GetWebhookDeliveries(webhookID string, limit int, offset int) ([]types.WebhookDelivery, error) {
+	return ds.db.getWebhookDeliveries(webhookID, limit, offset)
+}
+
+type sortedWebhooksByCreation []types.Webhook
+
+func (s sortedWebhooksByCreation) Len() int      { return len(s) }
+func (s sortedWebhooksByCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortedWebhooksByCreation) Less(i, j int) bool {
+	return s[i].CreatedAt.Before(s[j].CreatedAt)
+}
+
+// UpdateImage adds an image to the datastore, or updates it if it is
+// already known.
+func (ds *Datastore) UpdateImage(image types.Image) error {
+	ds.imagesLock.Lock()
+	defer ds.imagesLock.Unlock()
+
+	err := ds.db.updateImage(image)
+	if err != nil {
+		return err
+	}
+
+	ds.images[image.ID] = image
+
+	return nil
+}
+
+// DeleteImage removes an image from the datastore.
+func (ds *Datastore) DeleteImage(ID string) error {
+	ds.imagesLock.Lock()
+	defer ds.imagesLock.Unlock()
+
+	_, ok := ds.images[ID]
+	if !ok {
+		return ErrNoImage
+	}
+
+	err := ds.db.deleteImage(ID)
+	if err != nil {
+		return err
+	}
+
+	delete(ds.images, ID)
+
+	return nil
+}
+
+// GetImage returns the image with the given ID.
+func (ds *Datastore) GetImage(ID string) (types.Image, error) {
+	ds.imagesLock.RLock()
+	defer ds.imagesLock.RUnlock()
+
+	i, ok := ds.images[ID]
+	if !ok {
+		return types.Image{}, ErrNoImage
+	}
+
+	return i, nil
+}
+
+// GetImages returns the images of a tenant, oldest first.
+func (ds *Datastore) GetImages(tenantID string) []types.Image {
+	var images []types.Image
+
+	ds.imagesLock.RLock()
+	for _, i := range ds.images {
+		if i.TenantID == tenantID {
+			images = append(images, i)
+		}
+	}
+	ds.imagesLock.RUnlock()
+
+	sort.Sort(sortedImagesByCreation(images))
+
+	return images
+}
+
+type sortedImagesByCreation []types.Image
+
+func (s sortedImagesByCreation) Len() int      { return len(s) }
+func (s sortedImagesByCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortedImagesByCreation) Less(i, j int) bool {
+	if s[i].CreatedAt.Equal(s[j].CreatedAt) {
+		return s[i].ID < s[j].ID
+	}
+	return s[i].CreatedAt.Before(s[j].CreatedAt)
+}
@@ -22,8 +22,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -430,6 +432,54 @@ func TestGetAllInstances(t *testing.T) {
 	}
 }
 
+func TestInstanceNameMetadata(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance := &types.Instance{
+		TenantID:   tenant.ID,
+		WorkloadID: wls[0].ID,
+		ImageID:    wls[0].ImageID,
+		Name:       "test's instance",
+		Metadata:   map[string]string{"role": "test"},
+		State:      payloads.Pending,
+		ID:         uuid.Generate().String(),
+		Usage:      map[string]int{},
+	}
+
+	// AddInstance updates the database asynchronously
+	err = ds.db.addInstance(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := ds.db.getInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range instances {
+		if i.ID != instance.ID {
+			continue
+		}
+
+		if i.Name != instance.Name || i.ImageID != instance.ImageID ||
+			i.Metadata["role"] != "test" {
+			t.Fatalf("Instance not stored correctly: %+v", i)
+		}
+		return
+	}
+
+	t.Fatal("Instance not found")
+}
+
 func TestGetAllInstancesFromTenant(t *testing.T) {
 	var err error
 
@@ -913,6 +963,7 @@ func TestAddNodeStats(t *testing.T) {
 		Load:            20,
 		CpusOnline:      4,
 		NodeHostName:    "test",
+		Networks:        []payloads.NetworkStat{{NodeIP: "192.168.1.1"}},
 		Instances:       stats,
 	}
 
@@ -920,6 +971,20 @@ func TestAddNodeStats(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	node, err := ds.GetNode(stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node.Hostname != "test" || node.IPAddr != "192.168.1.1" {
+		t.Fatalf("Unexpected node %v", node)
+	}
+
+	_, err = ds.GetNode(uuid.Generate().String())
+	if err != ErrNoNode {
+		t.Fatalf("Expected ErrNoNode, got %v", err)
+	}
 }
 
 func TestAllocateTenantIP(t *testing.T) {
@@ -1046,6 +1111,91 @@ func TestAddLimit(t *testing.T) {
 	}
 }
 
+func getTestLimit(t *testing.T, tenantID string, resourceID int) int {
+	tenant, err := ds.GetTenant(tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range tenant.Resources {
+		if r.Rtype == resourceID {
+			return r.Limit
+		}
+	}
+
+	t.Fatalf("Resource %d not found", resourceID)
+	return 0
+}
+
+func TestDefaultLimits(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.SetDefaultLimit(6, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = ds.DeleteDefaultLimit(6) }()
+
+	if ds.GetDefaultLimits()[6] != 2 || getTestLimit(t, tenant.ID, 6) != 2 {
+		t.Fatal("Default limit not applied")
+	}
+
+	err = ds.AddLimit(tenant.ID, 6, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tenant limit overrides the default, and replaces
+	// the previous tenant limit.
+	err = ds.AddLimit(tenant.ID, 6, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.SetDefaultLimit(6, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if getTestLimit(t, tenant.ID, 6) != 4 {
+		t.Fatal("Tenant limit not applied")
+	}
+
+	err = ds.DeleteLimit(tenant.ID, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if getTestLimit(t, tenant.ID, 6) != 3 {
+		t.Fatal("Default limit not restored")
+	}
+
+	err = ds.DeleteDefaultLimit(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ds.GetDefaultLimits()[6]; ok || getTestLimit(t, tenant.ID, 6) != -1 {
+		t.Fatal("Default limit not deleted")
+	}
+
+	// make sure the datastore matches the cache
+	resources, err := ds.db.getTenantResources(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range resources {
+		if r.Rtype == 6 && r.Limit != -1 {
+			t.Fatalf("Expected no volumes limit, got %d", r.Limit)
+		}
+	}
+}
+
 func TestRemoveTenantCNCI(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1093,12 +1243,36 @@ func TestGetTenant(t *testing.T) {
 }
 
 func TestGetAllTenants(t *testing.T) {
-	_, err := ds.GetAllTenants()
+	for i := 0; i < 2; i++ {
+		_, err := addTestTenant()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tenants, err := ds.GetAllTenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < len(tenants); i++ {
+		if tenants[i-1].ID >= tenants[i].ID {
+			t.Fatalf("Tenants not sorted by ID: %s before %s",
+				tenants[i-1].ID, tenants[i].ID)
+		}
+	}
+
+	cncis, err := ds.GetTenantCNCISummary("")
 	if err != nil {
 		t.Fatal(err)
 	}
-	// for now, just check that the query has no
-	// errors.
+
+	for i := 1; i < len(cncis); i++ {
+		if cncis[i-1].TenantID >= cncis[i].TenantID {
+			t.Fatalf("CNCIs not sorted by tenant: %s before %s",
+				cncis[i-1].TenantID, cncis[i].TenantID)
+		}
+	}
 }
 
 func TestAddCNCIIP(t *testing.T) {
@@ -1262,6 +1436,84 @@ func TestReleaseTenantIP(t *testing.T) {
 	}
 }
 
+func TestDeleteTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddLimit(tenant.ID, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipBytes := net.ParseIP(instance.IPAddress).To4()
+	subnetInt := int(binary.BigEndian.Uint16(ipBytes[1:3]))
+
+	err = ds.DeleteTenant(tenant.ID)
+	if err != ErrTenantInUse {
+		t.Fatalf("Expected %v, got %v", ErrTenantInUse, err)
+	}
+
+	err = ds.DeleteInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ds.allSubnets[subnetInt] {
+		t.Fatal("Tenant subnet not released")
+	}
+
+	deleted, err := ds.GetTenant(tenant.ID)
+	if err != nil || deleted != nil {
+		t.Fatal("Tenant not deleted")
+	}
+
+	limits, err := ds.db.getTenantResources(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range limits {
+		if r.Rtype == 1 && r.Limit == 10 {
+			t.Fatal("Tenant limits not deleted")
+		}
+	}
+
+	history, err := ds.GetInstanceHistory(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 0 {
+		t.Fatalf("Tenant instance history not deleted: %+v", history)
+	}
+
+	err = ds.DeleteTenant(tenant.ID)
+	if err != ErrNoTenant {
+		t.Fatalf("Expected %v, got %v", ErrNoTenant, err)
+	}
+}
+
 func TestAddTenantChan(t *testing.T) {
 	c := make(chan bool)
 
@@ -1324,64 +1576,155 @@ func TestGetWorkload(t *testing.T) {
 	ds.workloadsLock.Unlock()
 }
 
-func TestRestartFailure(t *testing.T) {
-	tenant, err := addTestTenant()
-	if err != nil {
-		t.Fatal(err)
+func TestAddUpdateDeleteWorkload(t *testing.T) {
+	wl := types.Workload{
+		ID:          uuid.Generate().String(),
+		Description: "test workload",
+		FWType:      string(payloads.EFI),
+		VMType:      payloads.QEMU,
+		ImageID:     uuid.Generate().String(),
+		Config:      "---\n#cloud-config\n...\n",
+		Defaults: []payloads.RequestedResource{
+			{Type: payloads.VCPUs, Value: 2, Mandatory: true},
+			{Type: payloads.MemMB, Value: 256, Mandatory: true},
+		},
 	}
 
-	wls, err := ds.GetWorkloads()
+	err := ds.AddWorkload(wl)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	err = ds.AddWorkload(wl)
+	if err != ErrWorkloadExists {
+		t.Fatalf("Expected %v, got %v", ErrWorkloadExists, err)
+	}
+
+	work, err := ds.db.getWorkloadNoCache(wl.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	time.Sleep(1 * time.Second)
-	reason := payloads.RestartNoInstance
+	if work.Description != wl.Description || work.Config != wl.Config ||
+		len(work.Defaults) != len(wl.Defaults) {
+		t.Fatalf("Workload not stored correctly: %v", work.Workload)
+	}
 
-	err = ds.RestartFailure(instance.ID, reason)
+	if work.Storage == nil || work.Storage.SourceType != types.ImageService {
+		t.Fatal("Expected default workload storage")
+	}
+
+	wl.Description = "updated test workload"
+	wl.Storage = &types.StorageResource{
+		Bootable:   true,
+		Size:       20,
+		SourceType: types.ImageService,
+	}
+
+	err = ds.UpdateWorkload(wl)
 	if err != nil {
 		t.Fatal(err)
 	}
-}
 
-func TestStopFailure(t *testing.T) {
-	tenant, err := addTestTenant()
+	work, err = ds.db.getWorkloadNoCache(wl.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	if work.Description != wl.Description || work.Storage.Size != 20 ||
+		work.Storage.Persistent {
+		t.Fatalf("Workload not updated correctly: %v", work.Workload)
+	}
+
+	err = ds.DeleteWorkload(wl.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	_, err = ds.GetWorkload(wl.ID)
+	if err == nil {
+		t.Fatal("Workload not deleted")
+	}
+
+	err = ds.DeleteWorkload(wl.ID)
+	if err != ErrNoWorkload {
+		t.Fatalf("Expected %v, got %v", ErrNoWorkload, err)
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/%s.yaml", *workloadsPath, wl.ID)); !os.IsNotExist(err) {
+		t.Fatal("Workload config not removed")
+	}
+}
+
+func TestAddUpdateWorkloadFailure(t *testing.T) {
+	wl := types.Workload{
+		ID:          uuid.Generate().String(),
+		Description: "test workload",
+		FWType:      string(payloads.EFI),
+		VMType:      payloads.QEMU,
+		ImageID:     uuid.Generate().String(),
+		Config:      "---\n#cloud-config\n...\n",
+		Defaults: []payloads.RequestedResource{
+			{Type: "unknown", Value: 2, Mandatory: true},
+		},
+	}
+
+	path := fmt.Sprintf("%s/%s.yaml", *workloadsPath, wl.ID)
+	tmpFiles := fmt.Sprintf("%s/.%s.yaml*", *workloadsPath, wl.ID)
+
+	err := ds.AddWorkload(wl)
+	if err == nil {
+		t.Fatal("Workload with an unknown resource added")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Workload config written for a failed addition")
+	}
+
+	wl.Defaults = []payloads.RequestedResource{
+		{Type: payloads.VCPUs, Value: 2, Mandatory: true},
+	}
+
+	err = ds.AddWorkload(wl)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer ds.DeleteWorkload(wl.ID)
 
-	time.Sleep(1 * time.Second)
-	reason := payloads.StopNoInstance
+	updated := wl
+	updated.Config = "---\n#cloud-config\nhostname: updated\n...\n"
+	updated.Defaults = []payloads.RequestedResource{
+		{Type: "unknown", Value: 2, Mandatory: true},
+	}
 
-	err = ds.StopFailure(instance.ID, reason)
+	err = ds.UpdateWorkload(updated)
+	if err == nil {
+		t.Fatal("Workload updated with an unknown resource")
+	}
+
+	config, err := ioutil.ReadFile(path)
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	if string(config) != wl.Config {
+		t.Fatal("Workload config changed by a failed update")
+	}
+
+	matches, err := filepath.Glob(tmpFiles)
+	if err != nil || len(matches) != 0 {
+		t.Fatalf("Temporary workload configs left behind: %v", matches)
+	}
 }
 
-func TestStartFailureFullCloud(t *testing.T) {
+func TestResizeInstance(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	wls, err := ds.GetWorkloads()
-	if err != nil {
+	if err != nil || len(wls) == 0 {
 		t.Fatal(err)
 	}
 
@@ -1390,77 +1733,916 @@ func TestStartFailureFullCloud(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	time.Sleep(1 * time.Second)
-
-	tenantBefore, err := ds.GetTenant(tenant.ID)
+	tenantBefore, err := ds.getTenant(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	resourcesBefore := make(map[string]int)
-	for i := range tenantBefore.Resources {
-		r := tenantBefore.Resources[i]
+	for _, r := range tenantBefore.Resources {
 		resourcesBefore[r.Rname] = r.Usage
 	}
 
-	reason := payloads.FullCloud
+	usage := make(map[string]int)
+	for name, val := range instance.Usage {
+		usage[name] = val
+	}
+	usage["vcpus"] += 2
+	usage["mem_mb"] += 1024
 
-	err = ds.StartFailure(instance.ID, reason)
+	wl := wls[len(wls)-1]
+	err = ds.ResizeInstance(instance.ID, wl.ID, usage)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tenantAfter, err := ds.GetTenant(tenant.ID)
+	i, err := ds.GetInstance(instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	defaults := wls[0].Defaults
-
-	usage := make(map[string]int)
-	for i := range defaults {
-		usage[string(defaults[i].Type)] = defaults[i].Value
+	if i.WorkloadID != wl.ID || i.Usage["mem_mb"] != usage["mem_mb"] {
+		t.Fatalf("Instance not resized: %s %v", i.WorkloadID, i.Usage)
 	}
 
-	resourcesAfter := make(map[string]int)
-	for i := range tenantAfter.Resources {
-		r := tenantAfter.Resources[i]
-		resourcesAfter[r.Rname] = r.Usage
+	tenantAfter, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// make sure usage was reduced by workload defaults values
-	for name, val := range resourcesAfter {
-		before := resourcesBefore[name]
-		delta := usage[name]
-
-		if name == "instances" {
-			if val != before-1 {
-				t.Fatal("instances not decremented")
-			}
-		} else if val != before-delta {
-			t.Fatal("usage not reduced")
+	for _, r := range tenantAfter.Resources {
+		expected := resourcesBefore[r.Rname]
+		switch r.Rname {
+		case "vcpus":
+			expected += 2
+		case "mem_mb":
+			expected += 1024
+		}
+		if r.Usage != expected {
+			t.Errorf("Unexpected %s usage %d, expected %d", r.Rname, r.Usage, expected)
 		}
 	}
-}
 
-func TestAttachVolumeFailure(t *testing.T) {
+	err = ds.ResizeInstance(uuid.Generate().String(), wl.ID, usage)
+	if err == nil {
+		t.Error("Unknown instance resized")
+	}
+}
+
+func TestResizeInstanceRollback(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := make(map[string]int)
+	for name, val := range instance.Usage {
+		usage[name] = val
+	}
+	usage["mem_mb"] += 1024
+
+	err = ds.ResizeInstance(instance.ID, wls[len(wls)-1].ID, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.RestartFailure(instance.ID, payloads.RestartNoCapacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.WorkloadID != wls[0].ID || i.Usage["mem_mb"] != instance.Usage["mem_mb"] {
+		t.Fatalf("Resize not rolled back: %s %v", i.WorkloadID, i.Usage)
+	}
+
+	tenantAfter, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range tenantAfter.Resources {
+		if r.Rname == "mem_mb" && r.Usage != instance.Usage["mem_mb"] {
+			t.Errorf("Unexpected mem_mb usage %d, expected %d", r.Usage, instance.Usage["mem_mb"])
+		}
+	}
+
+	err = ds.ResizeInstance(instance.ID, wls[len(wls)-1].ID, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat := payloads.Stat{
+		NodeUUID:        uuid.Generate().String(),
+		MemTotalMB:      256,
+		MemAvailableMB:  256,
+		DiskTotalMB:     1024,
+		DiskAvailableMB: 1024,
+		CpusOnline:      4,
+		NodeHostName:    "test",
+		Instances: []payloads.InstanceStat{
+			{
+				InstanceUUID: instance.ID,
+				State:        payloads.ComputeStatusRunning,
+			},
+		},
+	}
+
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.RestartFailure(instance.ID, payloads.RestartNoCapacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err = ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.WorkloadID != wls[len(wls)-1].ID {
+		t.Fatalf("Running resized instance rolled back to %s", i.WorkloadID)
+	}
+}
+
+func TestDeleteWorkloadInUse(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteWorkload(instance.WorkloadID)
+	if err != ErrWorkloadInUse {
+		t.Fatalf("Expected %v, got %v", ErrWorkloadInUse, err)
+	}
+}
+
+func TestRestartFailure(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+	reason := payloads.RestartNoInstance
+
+	err = ds.RestartFailure(instance.ID, reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopFailure(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+	reason := payloads.StopNoInstance
+
+	err = ds.StopFailure(instance.ID, reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNodeEvacuation(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newNodeID := uuid.Generate().String()
+	evacuation := payloads.EventNodeEvacuation{
+		NodeUUID:  instance.NodeID,
+		Status:    payloads.EvacuationComplete,
+		NextState: payloads.NodeMaintenance,
+		Instances: []payloads.NodeEvacuationInstance{
+			{
+				InstanceUUID: instance.ID,
+				Status:       payloads.InstanceReplaced,
+				NodeUUID:     newNodeID,
+			},
+		},
+	}
+
+	err = ds.NodeEvacuation(evacuation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.NodeID != newNodeID {
+		t.Fatalf("Evacuated instance on node %s, expected %s", i.NodeID, newNodeID)
+	}
+}
+
+func TestInstanceMigrated(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceNodeID := instance.NodeID
+	targetNodeID := uuid.Generate().String()
+	event := payloads.InstanceMigratedEvent{
+		InstanceUUID:    instance.ID,
+		SourceAgentUUID: sourceNodeID,
+		TargetAgentUUID: targetNodeID,
+		Error:           "Migration failed",
+	}
+
+	err = ds.InstanceMigrated(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.NodeID != sourceNodeID {
+		t.Fatalf("Instance moved to node %s by a failed migration", i.NodeID)
+	}
+
+	event.Error = ""
+	err = ds.InstanceMigrated(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.NodeID != targetNodeID {
+		t.Fatalf("Migrated instance on node %s, expected %s", i.NodeID, targetNodeID)
+	}
+
+	event.InstanceUUID = uuid.Generate().String()
+	err = ds.InstanceMigrated(event)
+	if err == nil {
+		t.Fatal("Error expected for unknown instance")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	data := types.BlockData{
+		BlockDevice: storage.BlockDevice{
+			ID: uuid.Generate().String(),
+		},
+		State:      types.InUse,
+		TenantID:   instances[0].TenantID,
+		CreateTime: time.Now(),
+	}
+
+	err := ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknownID := uuid.Generate().String()
+	_, err = ds.createStorageAttachment(unknownID, data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lost := instances[len(instances)-1]
+	stat.Instances = stat.Instances[:len(stat.Instances)-1]
+	stat.Instances = append(stat.Instances, payloads.InstanceStat{
+		InstanceUUID: unknownID,
+		State:        payloads.ComputeStatusRunning,
+	})
+
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphans := ds.Reconcile(0)
+
+	found := false
+	for _, o := range orphans {
+		if o.ID == unknownID && o.NodeID == stat.NodeUUID {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Instance %s not reported as an orphan", unknownID)
+	}
+
+	i, err := ds.GetInstance(lost.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.State != types.InstanceLost {
+		t.Fatalf("Instance %s is %s, expected %s", i.ID, i.State, types.InstanceLost)
+	}
+
+	i, err = ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.State != payloads.ComputeStatusRunning {
+		t.Fatalf("Instance %s is %s, expected %s", i.ID, i.State, payloads.ComputeStatusRunning)
+	}
+
+	attachments, err := ds.GetVolumeAttachments(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 0 {
+		t.Fatal("Attachment to unknown instance not deleted")
+	}
+
+	bd, err := ds.GetBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd.State != types.Available {
+		t.Fatalf("Volume is %s, expected %s", bd.State, types.Available)
+	}
+
+	stat.Instances[len(stat.Instances)-1].InstanceUUID = lost.ID
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds.Reconcile(0)) != len(orphans)-1 {
+		t.Fatal("Orphan still reported once unknown to its node")
+	}
+
+	i, err = ds.GetInstance(lost.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.State != payloads.ComputeStatusRunning {
+		t.Fatalf("Instance %s is %s, expected %s", i.ID, i.State, payloads.ComputeStatusRunning)
+	}
+}
+
+func TestNodeDisconnected(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+	nodeID := stat.NodeUUID
+
+	disconnected, err := ds.NodeDisconnected(nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(disconnected) != len(instances) {
+		t.Fatalf("%d instances disconnected, expected %d", len(disconnected), len(instances))
+	}
+
+	for _, i := range disconnected {
+		if i.State != payloads.ComputeStatusRunning {
+			t.Fatalf("Disconnected instance %s was %s, expected %s", i.ID, i.State, payloads.ComputeStatusRunning)
+		}
+	}
+
+	for _, instance := range instances {
+		if instance.State != types.InstanceUnknown {
+			t.Fatalf("Instance %s is %s, expected %s", instance.ID, instance.State, types.InstanceUnknown)
+		}
+	}
+
+	_, err = ds.GetNode(nodeID)
+	if err != ErrNoNode {
+		t.Fatal("Disconnected node not removed")
+	}
+
+	rescheduled := instances[0]
+	err = ds.RescheduleInstance(rescheduled.ID, nodeID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rescheduled.State != payloads.Pending || rescheduled.NodeID != "" {
+		t.Fatalf("Rescheduled instance is %s on node %q", rescheduled.State, rescheduled.NodeID)
+	}
+
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rescheduled.State != payloads.Pending {
+		t.Fatal("Rescheduled instance updated by its former node")
+	}
+
+	if instances[1].State != payloads.ComputeStatusRunning {
+		t.Fatalf("Instance %s is %s, expected %s", instances[1].ID, instances[1].State, payloads.ComputeStatusRunning)
+	}
+
+	copies := ds.StaleInstanceCopies(nodeID)
+	if len(copies) != 1 || copies[0] != rescheduled.ID {
+		t.Fatalf("Unexpected stale copies %v", copies)
+	}
+
+	if len(ds.StaleInstanceCopies(nodeID)) != 0 {
+		t.Fatal("Stale copy returned twice")
+	}
+
+	err = ds.DeleteInstance(rescheduled.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetInstance(rescheduled.ID)
+	if err != nil {
+		t.Fatal("Rescheduled instance deleted with its stale copy")
+	}
+
+	err = ds.RescheduleInstance(instances[1].ID, nodeID, "")
+	if err == nil {
+		t.Fatal("Running instance rescheduled")
+	}
+}
+
+func TestStartFailureFullCloud(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	tenantBefore, err := ds.GetTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resourcesBefore := make(map[string]int)
+	for i := range tenantBefore.Resources {
+		r := tenantBefore.Resources[i]
+		resourcesBefore[r.Rname] = r.Usage
+	}
+
+	reason := payloads.FullCloud
+
+	err = ds.StartFailure(instance.ID, reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantAfter, err := ds.GetTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := wls[0].Defaults
+
+	usage := make(map[string]int)
+	for i := range defaults {
+		usage[string(defaults[i].Type)] = defaults[i].Value
+	}
+
+	resourcesAfter := make(map[string]int)
+	for i := range tenantAfter.Resources {
+		r := tenantAfter.Resources[i]
+		resourcesAfter[r.Rname] = r.Usage
+	}
+
+	// make sure usage was reduced by workload defaults values
+	for name, val := range resourcesAfter {
+		before := resourcesBefore[name]
+		delta := usage[name]
+
+		if name == "instances" {
+			if val != before-1 {
+				t.Fatal("instances not decremented")
+			}
+		} else if val != before-delta {
+			t.Fatal("usage not reduced")
+		}
+	}
+}
+
+func TestAttachVolumeFailure(t *testing.T) {
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add test instances
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(newTenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add test block data
+	blockDevice := storage.BlockDevice{
+		ID: "validID",
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    newTenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// update block data to indicate it is attaching
+	data.State = types.Attaching
+
+	err = ds.UpdateBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pretend we got a failure to attach.
+	ds.AttachVolumeFailure(instance.ID, data.ID, payloads.AttachVolumeAlreadyAttached)
+
+	// make sure state has been switched to Available again.
+	bd, err := ds.GetBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.Available {
+		t.Fatalf("expected state: %s, got %s\n", types.Available, bd.State)
+	}
+}
+
+func TestDetachVolumeFailure(t *testing.T) {
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add test instances
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(newTenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add test block data
+	blockDevice := storage.BlockDevice{
+		ID: "validID",
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    newTenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// update block data to indicate it is detaching
+	data.State = types.Detaching
+
+	err = ds.UpdateBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pretend we got a failure to attach.
+	ds.DetachVolumeFailure(instance.ID, data.ID, payloads.DetachVolumeNotAttached)
+
+	// make sure state has been switched to InUse again.
+	bd, err := ds.GetBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.InUse {
+		t.Fatalf("expected state: %s, got %s\n", types.InUse, bd.State)
+	}
+}
+
+func testAllocateTenantIPs(t *testing.T, nIPs int) {
+	nIPsPerSubnet := 253
+
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make this tenant have some network hosts assigned to them.
+	for n := 0; n < nIPs; n++ {
+		_, err = ds.AllocateTenantIP(newTenant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// get private tenant type
+	tenant, err := ds.getTenant(newTenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tenant.subnets) != (nIPs/nIPsPerSubnet)+1 {
+		t.Fatal("Too many subnets created")
+	}
+
+	for i, subnet := range tenant.subnets {
+		if ((i + 1) * nIPsPerSubnet) < nIPs {
+			if len(tenant.network[subnet]) != nIPsPerSubnet {
+				t.Fatal("Missing IPs")
+			}
+		} else {
+			if len(tenant.network[subnet]) != nIPs%nIPsPerSubnet {
+				t.Fatal("Missing IPs")
+			}
+		}
+	}
+}
+
+func TestAllocate100IPs(t *testing.T) {
+	testAllocateTenantIPs(t, 100)
+}
+
+func TestAllocate1024IPs(t *testing.T) {
+	testAllocateTenantIPs(t, 1024)
+}
+
+func TestAddBlockDevice(t *testing.T) {
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockDevice := storage.BlockDevice{
+		ID: "validID",
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    newTenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we can retrieve the block data.
+	_, err = ds.GetBlockDevice("validID")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that this is associate with our tenant.
+	devices, err := ds.GetBlockDevices(newTenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range devices {
+		if d.ID != "validID" {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteBlockDevice(t *testing.T) {
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Error(err)
+	}
+
+	blockDevice := storage.BlockDevice{
+		ID: "validID",
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    newTenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we can retrieve the block data.
+	_, err = ds.GetBlockDevice("validID")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// remove the block device
+	err = ds.DeleteBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that it is no longer there.
+	_, err = ds.GetBlockDevice(data.ID)
+	if err == nil {
+		t.Fatal("Did not delete block device")
+	}
+
+	// attempt to delete a non-existing block device
+	err = ds.DeleteBlockDevice("unknownID")
+	if err != ErrNoBlockData {
+		t.Fatalf("expecting %s error, received %s\n", ErrNoBlockData, err)
+	}
+}
+
+func TestUpdateBlockDevice(t *testing.T) {
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockDevice := storage.BlockDevice{
+		ID: uuid.Generate().String(),
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    newTenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we can retrieve the block data.
+	_, err = ds.GetBlockDevice(blockDevice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// update the state of the block device.
+	data.State = types.Attaching
+
+	err = ds.UpdateBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we can retrieve the block data.
+	d, err := ds.GetBlockDevice(blockDevice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.State != types.Attaching {
+		t.Fatalf("expected State == %s, got %s\n", types.Attaching, d.State)
+	}
+}
+
+func TestGetBlockDevicesErr(t *testing.T) {
+	// confirm that sending a bad tenant id results in error
+	_, err := ds.GetBlockDevices("badID")
+	if err != ErrNoTenant {
+		t.Fatal(err)
+	}
+}
+
+func TestGetBlockDeviceErr(t *testing.T) {
+	// confirm that we get the correct error for missing id
+	_, err := ds.GetBlockDevice("badID")
+	if err != ErrNoBlockData {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateBlockDeviceErr(t *testing.T) {
 	newTenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// add test instances
-	wls, err := ds.GetWorkloads()
-	if err != nil {
+	blockDevice := storage.BlockDevice{
+		ID: uuid.Generate().String(),
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    newTenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	// confirm that we get the correct error for missing id
+	err = ds.UpdateBlockDevice(data)
+	if err != ErrNoBlockData {
 		t.Fatal(err)
 	}
+}
 
-	instance, err := addTestInstance(newTenant, wls[0])
+func TestCreateStorageAttachment(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// add test block data
 	blockDevice := storage.BlockDevice{
 		ID: "validID",
 	}
@@ -1469,7 +2651,7 @@ func TestAttachVolumeFailure(t *testing.T) {
 		BlockDevice: blockDevice,
 		Size:        0,
 		State:       types.Available,
-		TenantID:    newTenant.ID,
+		TenantID:    tenant.ID,
 		CreateTime:  time.Now(),
 	}
 
@@ -1478,46 +2660,109 @@ func TestAttachVolumeFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// update block data to indicate it is attaching
-	data.State = types.Attaching
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err = ds.UpdateBlockDevice(data)
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// pretend we got a failure to attach.
-	ds.AttachVolumeFailure(instance.ID, data.ID, payloads.AttachVolumeAlreadyAttached)
+	_, err = ds.createStorageAttachment(instance.ID, data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// make sure state has been switched to Available again.
-	bd, err := ds.GetBlockDevice(data.ID)
+	// get the attachments associated with this instance
+	a1, err := ds.GetStorageAttachments(instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if bd.State != types.Available {
-		t.Fatalf("expected state: %s, got %s\n", types.Available, bd.State)
+	if len(a1) != 1 {
+		t.Fatal(err)
+	}
+
+	if a1[0].InstanceID != instance.ID {
+		t.Fatal(err)
 	}
 }
 
-func TestDetachVolumeFailure(t *testing.T) {
-	newTenant, err := addTestTenant()
+func TestUpdateStorageAttachmentExisting(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockDevice := storage.BlockDevice{
+		ID: "validID",
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    tenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// add test instances
 	wls, err := ds.GetWorkloads()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	instance, err := addTestInstance(newTenant, wls[0])
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.createStorageAttachment(instance.ID, data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get the attachments associated with this instance
+	a1, err := ds.GetStorageAttachments(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a1) != 1 {
+		t.Fatal(err)
+	}
+
+	if a1[0].InstanceID != instance.ID {
+		t.Fatal(err)
+	}
+
+	attachments := []string{data.ID}
+
+	// this doesn't return an error, but we can still exercise
+	// the code to see if anything panics.
+	ds.updateStorageAttachments(instance.ID, attachments)
+}
+
+func TestUpdateStorageAttachmentNotExisting(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// add test block data
 	blockDevice := storage.BlockDevice{
 		ID: "validID",
 	}
@@ -1526,7 +2771,7 @@ func TestDetachVolumeFailure(t *testing.T) {
 		BlockDevice: blockDevice,
 		Size:        0,
 		State:       types.Available,
-		TenantID:    newTenant.ID,
+		TenantID:    tenant.ID,
 		CreateTime:  time.Now(),
 	}
 
@@ -1535,77 +2780,79 @@ func TestDetachVolumeFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// update block data to indicate it is detaching
-	data.State = types.Detaching
-
-	err = ds.UpdateBlockDevice(data)
+	wls, err := ds.GetWorkloads()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// pretend we got a failure to attach.
-	ds.DetachVolumeFailure(instance.ID, data.ID, payloads.DetachVolumeNotAttached)
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
 
-	// make sure state has been switched to InUse again.
-	bd, err := ds.GetBlockDevice(data.ID)
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if bd.State != types.InUse {
-		t.Fatalf("expected state: %s, got %s\n", types.InUse, bd.State)
-	}
-}
+	attachments := []string{data.ID}
 
-func testAllocateTenantIPs(t *testing.T, nIPs int) {
-	nIPsPerSubnet := 253
+	// this doesn't return an error, but we can still exercise
+	// the code to see if anything panics.
+	ds.updateStorageAttachments(instance.ID, attachments)
+}
 
-	newTenant, err := addTestTenant()
+func TestUpdateStorageAttachmentDeleted(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// make this tenant have some network hosts assigned to them.
-	for n := 0; n < nIPs; n++ {
-		_, err = ds.AllocateTenantIP(newTenant.ID)
-		if err != nil {
-			t.Fatal(err)
-		}
+	blockDevice := storage.BlockDevice{
+		ID: "validID",
 	}
 
-	// get private tenant type
-	tenant, err := ds.getTenant(newTenant.ID)
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		Size:        0,
+		State:       types.Available,
+		TenantID:    tenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(tenant.subnets) != (nIPs/nIPsPerSubnet)+1 {
-		t.Fatal("Too many subnets created")
+	wls, err := ds.GetWorkloads()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for i, subnet := range tenant.subnets {
-		if ((i + 1) * nIPsPerSubnet) < nIPs {
-			if len(tenant.network[subnet]) != nIPsPerSubnet {
-				t.Fatal("Missing IPs")
-			}
-		} else {
-			if len(tenant.network[subnet]) != nIPs%nIPsPerSubnet {
-				t.Fatal("Missing IPs")
-			}
-		}
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
 	}
-}
 
-func TestAllocate100IPs(t *testing.T) {
-	testAllocateTenantIPs(t, 100)
-}
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestAllocate1024IPs(t *testing.T) {
-	testAllocateTenantIPs(t, 1024)
+	_, err = ds.createStorageAttachment(instance.ID, data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments := []string{}
+
+	// this doesn't return an error, but we can still exercise
+	// the code to see if anything panics.
+	// send in an empty list.
+	ds.updateStorageAttachments(instance.ID, attachments)
 }
 
-func TestAddBlockDevice(t *testing.T) {
-	newTenant, err := addTestTenant()
+func TestGetStorageAttachment(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1618,7 +2865,7 @@ func TestAddBlockDevice(t *testing.T) {
 		BlockDevice: blockDevice,
 		Size:        0,
 		State:       types.Available,
-		TenantID:    newTenant.ID,
+		TenantID:    tenant.ID,
 		CreateTime:  time.Now(),
 	}
 
@@ -1627,29 +2874,39 @@ func TestAddBlockDevice(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the block data.
-	_, err = ds.GetBlockDevice("validID")
+	wls, err := ds.GetWorkloads()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// confirm that this is associate with our tenant.
-	devices, err := ds.GetBlockDevices(newTenant.ID)
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	for _, d := range devices {
-		if d.ID != "validID" {
-			t.Fatal(err)
-		}
+	_, err = ds.createStorageAttachment(instance.ID, data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ds.getStorageAttachment(instance.ID, data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.InstanceID != instance.ID || a.BlockID != data.ID {
+		t.Fatal(err)
 	}
 }
 
-func TestDeleteBlockDevice(t *testing.T) {
-	newTenant, err := addTestTenant()
+func TestGetStorageAttachmentError(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
 	blockDevice := storage.BlockDevice{
@@ -1660,7 +2917,7 @@ func TestDeleteBlockDevice(t *testing.T) {
 		BlockDevice: blockDevice,
 		Size:        0,
 		State:       types.Available,
-		TenantID:    newTenant.ID,
+		TenantID:    tenant.ID,
 		CreateTime:  time.Now(),
 	}
 
@@ -1669,46 +2926,41 @@ func TestDeleteBlockDevice(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the block data.
-	_, err = ds.GetBlockDevice("validID")
+	wls, err := ds.GetWorkloads()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// remove the block device
-	err = ds.DeleteBlockDevice(data.ID)
-	if err != nil {
-		t.Fatal(err)
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
 	}
 
-	// confirm that it is no longer there.
-	_, err = ds.GetBlockDevice(data.ID)
-	if err == nil {
-		t.Fatal("Did not delete block device")
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// attempt to delete a non-existing block device
-	err = ds.DeleteBlockDevice("unknownID")
-	if err != ErrNoBlockData {
-		t.Fatalf("expecting %s error, received %s\n", ErrNoBlockData, err)
+	_, err = ds.getStorageAttachment(instance.ID, data.ID)
+	if err != ErrNoStorageAttachment {
+		t.Fatal(err)
 	}
 }
 
-func TestUpdateBlockDevice(t *testing.T) {
-	newTenant, err := addTestTenant()
+func TestDeleteStorageAttachment(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	blockDevice := storage.BlockDevice{
-		ID: uuid.Generate().String(),
+		ID: "validID",
 	}
 
 	data := types.BlockData{
 		BlockDevice: blockDevice,
 		Size:        0,
 		State:       types.Available,
-		TenantID:    newTenant.ID,
+		TenantID:    tenant.ID,
 		CreateTime:  time.Now(),
 	}
 
@@ -1717,73 +2969,46 @@ func TestUpdateBlockDevice(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the block data.
-	_, err = ds.GetBlockDevice(blockDevice.ID)
+	wls, err := ds.GetWorkloads()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// update the state of the block device.
-	data.State = types.Attaching
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
 
-	err = ds.UpdateBlockDevice(data)
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the block data.
-	d, err := ds.GetBlockDevice(blockDevice.ID)
+	_, err = ds.createStorageAttachment(instance.ID, data.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if d.State != types.Attaching {
-		t.Fatalf("expected State == %s, got %s\n", types.Attaching, d.State)
-	}
-}
-
-func TestGetBlockDevicesErr(t *testing.T) {
-	// confirm that sending a bad tenant id results in error
-	_, err := ds.GetBlockDevices("badID")
-	if err != ErrNoTenant {
+	a, err := ds.getStorageAttachment(instance.ID, data.ID)
+	if err != nil {
 		t.Fatal(err)
 	}
-}
 
-func TestGetBlockDeviceErr(t *testing.T) {
-	// confirm that we get the correct error for missing id
-	_, err := ds.GetBlockDevice("badID")
-	if err != ErrNoBlockData {
+	if a.InstanceID != instance.ID || a.BlockID != data.ID {
 		t.Fatal(err)
 	}
-}
 
-func TestUpdateBlockDeviceErr(t *testing.T) {
-	newTenant, err := addTestTenant()
+	err = ds.deleteStorageAttachment(a.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: uuid.Generate().String(),
-	}
-
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    newTenant.ID,
-		CreateTime:  time.Now(),
-	}
-
-	// confirm that we get the correct error for missing id
-	err = ds.UpdateBlockDevice(data)
-	if err != ErrNoBlockData {
+	_, err = ds.getStorageAttachment(instance.ID, data.ID)
+	if err != ErrNoStorageAttachment {
 		t.Fatal(err)
 	}
 }
 
-func TestCreateStorageAttachment(t *testing.T) {
+func TestDeleteStorageAttachmentError(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
@@ -1825,29 +3050,39 @@ func TestCreateStorageAttachment(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// get the attachments associated with this instance
-	a1, err := ds.GetStorageAttachments(instance.ID)
+	a, err := ds.getStorageAttachment(instance.ID, data.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(a1) != 1 {
+	if a.InstanceID != instance.ID || a.BlockID != data.ID {
 		t.Fatal(err)
 	}
 
-	if a1[0].InstanceID != instance.ID {
+	err = ds.deleteStorageAttachment(a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.getStorageAttachment(instance.ID, data.ID)
+	if err != ErrNoStorageAttachment {
+		t.Fatal(err)
+	}
+
+	err = ds.deleteStorageAttachment(a.ID)
+	if err != ErrNoStorageAttachment {
 		t.Fatal(err)
 	}
 }
 
-func TestUpdateStorageAttachmentExisting(t *testing.T) {
+func TestGetVolumeAttachments(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	blockDevice := storage.BlockDevice{
-		ID: "validID",
+		ID: uuid.Generate().String(),
 	}
 
 	data := types.BlockData{
@@ -1882,100 +3117,251 @@ func TestUpdateStorageAttachmentExisting(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// get the attachments associated with this instance
-	a1, err := ds.GetStorageAttachments(instance.ID)
+	attachments, err := ds.GetVolumeAttachments(data.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(a1) != 1 {
+	if len(attachments) != 1 {
+		t.Fatalf("expected 1 attachment, found %d", len(attachments))
+	}
+
+	for _, a := range attachments {
+		if a.InstanceID != instance.ID || a.BlockID != data.ID {
+			t.Fatal("Returned incorrect attachment")
+		}
+	}
+}
+
+func TestDeleteVolumeAttachments(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	if a1[0].InstanceID != instance.ID {
+	data := types.BlockData{
+		BlockDevice: storage.BlockDevice{
+			ID: uuid.Generate().String(),
+		},
+		State:      types.InUse,
+		TenantID:   tenant.ID,
+		CreateTime: time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	attachments := []string{data.ID}
+	_, err = ds.createStorageAttachment(uuid.Generate().String(), data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// this doesn't return an error, but we can still exercise
-	// the code to see if anything panics.
-	ds.updateStorageAttachments(instance.ID, attachments)
+	err = ds.DeleteVolumeAttachments(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments, err := ds.GetVolumeAttachments(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(attachments) != 0 {
+		t.Fatalf("expected no attachment, found %d", len(attachments))
+	}
+
+	bd, err := ds.GetBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.Available {
+		t.Fatalf("expected volume %s, got %s", types.Available, bd.State)
+	}
 }
 
-func TestUpdateStorageAttachmentNotExisting(t *testing.T) {
-	tenant, err := addTestTenant()
+func TestSetFloatingIPPool(t *testing.T) {
+	err := ds.SetFloatingIPPool([]string{"203.0.113.0/29", "198.51.100.7"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: "validID",
+	ips, err := ds.db.getAllFloatingIPs()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    tenant.ID,
-		CreateTime:  time.Now(),
+	// network and broadcast addresses are not part of the pool
+	if len(ips) != 7 {
+		t.Fatalf("Expected 7 floating IPs, got %d", len(ips))
 	}
 
-	err = ds.AddBlockDevice(data)
+	for _, ip := range ips {
+		if ip.Address == "203.0.113.0" || ip.Address == "203.0.113.7" {
+			t.Fatalf("Unexpected floating IP %s", ip.Address)
+		}
+	}
+
+	err = ds.SetFloatingIPPool([]string{"198.51.100.7"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	ips, err = ds.db.getAllFloatingIPs()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	if len(ips) != 1 || len(ds.floatingIPs) != 1 {
+		t.Fatalf("Expected 1 floating IP, got %d", len(ips))
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	err = ds.SetFloatingIPPool([]string{"not an address"})
+	if err == nil {
+		t.Fatal("Invalid floating IP pool accepted")
+	}
+
+	err = ds.SetFloatingIPPool(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	attachments := []string{data.ID}
+	ips, err = ds.db.getAllFloatingIPs()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// this doesn't return an error, but we can still exercise
-	// the code to see if anything panics.
-	ds.updateStorageAttachments(instance.ID, attachments)
+	if len(ips) != 1 || len(ds.floatingIPs) != 1 {
+		t.Fatalf("Empty floating IP pool removed floating IPs")
+	}
 }
 
-func TestUpdateStorageAttachmentDeleted(t *testing.T) {
+func TestFloatingIPAllocateAssociate(t *testing.T) {
+	err := ds.SetFloatingIPPool([]string{"198.51.100.8", "198.51.100.9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: "validID",
+	wls, err := ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No Workloads Found")
 	}
 
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    tenant.ID,
-		CreateTime:  time.Now(),
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	err = ds.AddBlockDevice(data)
+	ip, err := ds.AllocateFloatingIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip.Address != "198.51.100.8" || ip.TenantID != tenant.ID ||
+		ip.State != types.FloatingIPAllocated {
+		t.Fatalf("Floating IP not allocated correctly: %+v", ip)
+	}
+
+	ip2, err := ds.AllocateFloatingIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.AllocateFloatingIP(tenant.ID)
+	if err != ErrNoFreeFloatingIP {
+		t.Fatalf("Expected %v, got %v", ErrNoFreeFloatingIP, err)
+	}
+
+	if len(ds.GetFloatingIPs(tenant.ID)) != 2 {
+		t.Fatal("Tenant floating IPs not returned")
+	}
+
+	_, err = ds.AssociateFloatingIP(ip.ID, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.AssociateFloatingIP(ip2.ID, instance.ID)
+	if err != ErrInstanceFloatingIP {
+		t.Fatalf("Expected %v, got %v", ErrInstanceFloatingIP, err)
+	}
+
+	err = ds.ReleaseFloatingIP(ip.ID)
+	if err != ErrFloatingIPInUse {
+		t.Fatalf("Expected %v, got %v", ErrFloatingIPInUse, err)
+	}
+
+	event := payloads.PublicIPEvent{
+		ConcentratorUUID: tenant.CNCIID,
+		InstanceUUID:     instance.ID,
+		PublicIP:         ip.Address,
+		PrivateIP:        instance.IPAddress,
+	}
+
+	err = ds.PublicIPAssigned(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.PublicIP != ip.Address {
+		t.Fatalf("Expected public IP %s, got %s", ip.Address, i.PublicIP)
+	}
+
+	ips, err := ds.db.getAllFloatingIPs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ips[ip.ID].State != types.FloatingIPAssigned || ips[ip.ID].InstanceID != instance.ID {
+		t.Fatalf("Floating IP not stored correctly: %+v", ips[ip.ID])
+	}
+
+	old, err := ds.DisassociateFloatingIP(ip.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	if old.InstanceID != instance.ID || i.PublicIP != "" {
+		t.Fatal("Floating IP not disassociated")
+	}
+
+	_, err = ds.DisassociateFloatingIP(ip.ID)
+	if err != ErrFloatingIPNotInUse {
+		t.Fatalf("Expected %v, got %v", ErrFloatingIPNotInUse, err)
+	}
+
+	for _, ID := range []string{ip.ID, ip2.ID} {
+		err = ds.ReleaseFloatingIP(ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(ds.GetFloatingIPs(tenant.ID)) != 0 {
+		t.Fatal("Released floating IPs still allocated")
+	}
+}
+
+func TestSecurityGroups(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
+	wls, err := ds.GetWorkloads()
+	if err != nil || len(wls) == 0 {
 		t.Fatal("No Workloads Found")
 	}
 
@@ -1984,298 +3370,408 @@ func TestUpdateStorageAttachmentDeleted(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = ds.createStorageAttachment(instance.ID, data.ID)
-	if err != nil {
-		t.Fatal(err)
+	group := types.SecurityGroup{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenant.ID,
+		Name:        "web",
+		Description: "it's the web",
 	}
 
-	attachments := []string{}
-
-	// this doesn't return an error, but we can still exercise
-	// the code to see if anything panics.
-	// send in an empty list.
-	ds.updateStorageAttachments(instance.ID, attachments)
-}
-
-func TestGetStorageAttachment(t *testing.T) {
-	tenant, err := addTestTenant()
+	err = ds.AddSecurityGroup(group)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: "validID",
+	dup := group
+	dup.ID = uuid.Generate().String()
+	err = ds.AddSecurityGroup(dup)
+	if err != ErrSecurityGroupExists {
+		t.Fatalf("Expected %v, got %v", ErrSecurityGroupExists, err)
 	}
 
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    tenant.ID,
-		CreateTime:  time.Now(),
+	rule := types.SecurityGroupRule{
+		ID:       uuid.Generate().String(),
+		GroupID:  group.ID,
+		Protocol: "tcp",
+		FromPort: 80,
+		ToPort:   443,
+		CIDR:     "0.0.0.0/0",
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddSecurityGroupRule(rule)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	g, err := ds.GetSecurityGroupByName(tenant.ID, "web")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	if g.ID != group.ID || len(g.Rules) != 1 || g.Rules[0] != rule {
+		t.Fatalf("Security group not stored correctly: %+v", g)
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	groups, err := ds.db.getAllSecurityGroups()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.createStorageAttachment(instance.ID, data.ID)
+	if groups[group.ID].Description != group.Description ||
+		len(groups[group.ID].Rules) != 1 {
+		t.Fatalf("Security group not persisted correctly: %+v", groups[group.ID])
+	}
+
+	err = ds.AddInstanceSecurityGroup(instance.ID, group.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	a, err := ds.getStorageAttachment(instance.ID, data.ID)
+	rules := ds.GetInstanceSecurityRules(instance)
+	if len(rules) != 1 || rules[0] != rule {
+		t.Fatalf("Instance security rules mismatch: %+v", rules)
+	}
+
+	members := ds.GetSecurityGroupInstances(group.ID)
+	if len(members) != 1 || members[0].ID != instance.ID {
+		t.Fatalf("Security group instances mismatch: %v", members)
+	}
+
+	err = ds.DeleteSecurityGroup(group.ID)
+	if err != ErrSecurityGroupInUse {
+		t.Fatalf("Expected %v, got %v", ErrSecurityGroupInUse, err)
+	}
+
+	err = ds.RemoveInstanceSecurityGroup(instance.ID, group.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if a.InstanceID != instance.ID || a.BlockID != data.ID {
-		t.Fatal(err)
+	err = ds.RemoveInstanceSecurityGroup(instance.ID, group.ID)
+	if err != ErrNotInSecurityGroup {
+		t.Fatalf("Expected %v, got %v", ErrNotInSecurityGroup, err)
 	}
-}
 
-func TestGetStorageAttachmentError(t *testing.T) {
-	tenant, err := addTestTenant()
+	deleted, err := ds.DeleteSecurityGroupRule(rule.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: "validID",
+	if deleted != rule {
+		t.Fatalf("Wrong rule deleted: %+v", deleted)
 	}
 
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    tenant.ID,
-		CreateTime:  time.Now(),
+	_, err = ds.DeleteSecurityGroupRule(rule.ID)
+	if err != ErrNoSecurityGroupRule {
+		t.Fatalf("Expected %v, got %v", ErrNoSecurityGroupRule, err)
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.DeleteSecurityGroup(group.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	if len(ds.GetSecurityGroups(tenant.ID)) != 0 {
+		t.Fatal("Security group not deleted")
+	}
+}
+
+func TestKeyPairs(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	key := types.KeyPair{
+		TenantID:    tenant.ID,
+		Name:        "mykey",
+		PublicKey:   "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ== test@ciao",
+		Fingerprint: "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff",
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	err = ds.AddKeyPair(key)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.getStorageAttachment(instance.ID, data.ID)
-	if err != ErrNoStorageAttachment {
-		t.Fatal(err)
+	err = ds.AddKeyPair(key)
+	if err != ErrKeyPairExists {
+		t.Fatalf("Expected %v, got %v", ErrKeyPairExists, err)
 	}
-}
 
-func TestDeleteStorageAttachment(t *testing.T) {
-	tenant, err := addTestTenant()
+	k, err := ds.GetKeyPair(tenant.ID, "mykey")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: "validID",
+	if k != key {
+		t.Fatalf("Keypair mismatch: %+v", k)
 	}
 
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    tenant.ID,
-		CreateTime:  time.Now(),
+	_, err = ds.GetKeyPair("other", "mykey")
+	if err != ErrNoKeyPair {
+		t.Fatalf("Expected %v, got %v", ErrNoKeyPair, err)
 	}
 
-	err = ds.AddBlockDevice(data)
+	keys, err := ds.db.getAllKeyPairs()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	found := false
+	for _, k := range keys {
+		if k.TenantID == tenant.ID && k.Name == key.Name {
+			found = k.PublicKey == key.PublicKey && k.CreatedAt.Equal(key.CreatedAt)
+		}
+	}
+
+	if !found {
+		t.Fatalf("Keypair not persisted correctly: %+v", keys)
+	}
+
+	if len(ds.GetKeyPairs(tenant.ID)) != 1 {
+		t.Fatal("Tenant keypairs not returned")
+	}
+
+	err = ds.DeleteKeyPair(tenant.ID, "mykey")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	err = ds.DeleteKeyPair(tenant.ID, "mykey")
+	if err != ErrNoKeyPair {
+		t.Fatalf("Expected %v, got %v", ErrNoKeyPair, err)
 	}
+}
 
-	instance, err := addTestInstance(tenant, wls[0])
+func TestWebhooks(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.createStorageAttachment(instance.ID, data.ID)
+	webhook := types.Webhook{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		URL:        "https://example.com/hooks/ciao",
+		EventTypes: []types.EventKind{types.EventStartFailure, types.EventNodeDisconnected},
+		Secret:     "mysecret",
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+
+	err = ds.AddWebhook(webhook)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	a, err := ds.getStorageAttachment(instance.ID, data.ID)
+	w, err := ds.GetWebhook(webhook.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if a.InstanceID != instance.ID || a.BlockID != data.ID {
-		t.Fatal(err)
+	if w.URL != webhook.URL || w.Secret != webhook.Secret {
+		t.Fatalf("Webhook mismatch: %+v", w)
 	}
 
-	err = ds.deleteStorageAttachment(a.ID)
+	webhooks, err := ds.db.getAllWebhooks()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.getStorageAttachment(instance.ID, data.ID)
-	if err != ErrNoStorageAttachment {
-		t.Fatal(err)
+	found := false
+	for _, w := range webhooks {
+		if w.ID == webhook.ID {
+			found = w.TenantID == tenant.ID && len(w.EventTypes) == 2 &&
+				w.EventTypes[1] == types.EventNodeDisconnected &&
+				w.CreatedAt.Equal(webhook.CreatedAt)
+		}
 	}
-}
 
-func TestDeleteStorageAttachmentError(t *testing.T) {
-	tenant, err := addTestTenant()
-	if err != nil {
-		t.Fatal(err)
+	if !found {
+		t.Fatalf("Webhook not persisted correctly: %+v", webhooks)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: "validID",
+	if len(ds.GetWebhooks(tenant.ID)) != 1 {
+		t.Fatal("Tenant webhooks not returned")
 	}
 
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    tenant.ID,
-		CreateTime:  time.Now(),
+	for attempt := 1; attempt <= 2; attempt++ {
+		delivery := types.WebhookDelivery{
+			ID:         "delivery",
+			WebhookID:  webhook.ID,
+			EventID:    42,
+			EventKind:  types.EventStartFailure,
+			Attempt:    attempt,
+			Timestamp:  time.Now(),
+			StatusCode: 500,
+			Error:      "500 Internal Server Error",
+		}
+
+		err = ds.AddWebhookDelivery(delivery)
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	err = ds.AddBlockDevice(data)
+	deliveries, err := ds.GetWebhookDeliveries(webhook.ID, 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	if len(deliveries) != 2 || deliveries[1].Attempt != 2 ||
+		deliveries[1].EventID != 42 || deliveries[1].StatusCode != 500 {
+		t.Fatalf("Unexpected delivery log: %+v", deliveries)
+	}
+
+	deliveries, err = ds.GetWebhookDeliveries(webhook.ID, 1, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	if len(deliveries) != 1 || deliveries[0].Attempt != 2 {
+		t.Fatalf("Unexpected delivery log page: %+v", deliveries)
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	err = ds.DeleteWebhook(webhook.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.createStorageAttachment(instance.ID, data.ID)
-	if err != nil {
-		t.Fatal(err)
+	err = ds.DeleteWebhook(webhook.ID)
+	if err != ErrNoWebhook {
+		t.Fatalf("Expected %v, got %v", ErrNoWebhook, err)
 	}
 
-	a, err := ds.getStorageAttachment(instance.ID, data.ID)
+	deliveries, err = ds.GetWebhookDeliveries(webhook.ID, 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if a.InstanceID != instance.ID || a.BlockID != data.ID {
-		t.Fatal(err)
+	if len(deliveries) != 0 {
+		t.Fatal("Delivery log not deleted with the webhook")
 	}
+}
 
-	err = ds.deleteStorageAttachment(a.ID)
-	if err != nil {
-		t.Fatal(err)
+func TestWebhookDeliveryRetention(t *testing.T) {
+	webhookID := uuid.Generate().String()
+
+	for attempt := 1; attempt <= webhookDeliveryRetention+5; attempt++ {
+		err := ds.AddWebhookDelivery(types.WebhookDelivery{
+			ID:        uuid.Generate().String(),
+			WebhookID: webhookID,
+			EventID:   uint64(attempt),
+			EventKind: types.EventStartFailure,
+			Attempt:   1,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	_, err = ds.getStorageAttachment(instance.ID, data.ID)
-	if err != ErrNoStorageAttachment {
+	deliveries, err := ds.GetWebhookDeliveries(webhookID, 0, 0)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.deleteStorageAttachment(a.ID)
-	if err != ErrNoStorageAttachment {
-		t.Fatal(err)
+	if len(deliveries) != webhookDeliveryRetention || deliveries[0].EventID != 6 {
+		t.Fatalf("Expected the latest %d deliveries, got %d from event %d",
+			webhookDeliveryRetention, len(deliveries), deliveries[0].EventID)
 	}
 }
 
-func TestGetVolumeAttachments(t *testing.T) {
+func TestImages(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: uuid.Generate().String(),
+	image := types.Image{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		Name:       "snapshot",
+		InstanceID: uuid.Generate().String(),
+		State:      types.ImageSaving,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
 	}
 
-	data := types.BlockData{
-		BlockDevice: blockDevice,
-		Size:        0,
-		State:       types.Available,
-		TenantID:    tenant.ID,
-		CreateTime:  time.Now(),
+	err = ds.UpdateImage(image)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	err = ds.AddBlockDevice(data)
+	image.State = types.ImageActive
+	image.Size = 1024
+
+	err = ds.UpdateImage(image)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads()
+	i, err := ds.GetImage(image.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	if i != image {
+		t.Fatalf("Image mismatch: %+v", i)
 	}
 
-	instance, err := addTestInstance(tenant, wls[0])
+	_, err = ds.GetImage(uuid.Generate().String())
+	if err != ErrNoImage {
+		t.Fatalf("Expected %v, got %v", ErrNoImage, err)
+	}
+
+	images, err := ds.db.getAllImages()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = ds.createStorageAttachment(instance.ID, data.ID)
+	found := false
+	for _, i := range images {
+		if i.ID == image.ID {
+			found = i.State == types.ImageActive && i.Size == image.Size &&
+				i.CreatedAt.Equal(image.CreatedAt)
+		}
+	}
+
+	if !found {
+		t.Fatalf("Image not persisted correctly: %+v", images)
+	}
+
+	if len(ds.GetImages(tenant.ID)) != 1 {
+		t.Fatal("Tenant images not returned")
+	}
+
+	if len(ds.GetImages("other")) != 0 {
+		t.Fatal("Images returned for the wrong tenant")
+	}
+
+	err = ds.DeleteImage(image.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	attachments, err := ds.GetVolumeAttachments(data.ID)
+	_, err = ds.GetImage(image.ID)
+	if err != ErrNoImage {
+		t.Fatalf("Expected %v, got %v", ErrNoImage, err)
+	}
+
+	images, err = ds.db.getAllImages()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(attachments) != 1 {
-		t.Fatalf("expected 1 attachment, found %d", len(attachments))
+	for _, i := range images {
+		if i.ID == image.ID {
+			t.Fatal("Image not removed from the database")
+		}
 	}
 
-	for _, a := range attachments {
-		if a.InstanceID != instance.ID || a.BlockID != data.ID {
-			t.Fatal("Returned incorrect attachment")
-		}
+	err = ds.DeleteImage(image.ID)
+	if err != ErrNoImage {
+		t.Fatalf("Expected %v, got %v", ErrNoImage, err)
 	}
 }
 
@@ -2307,3 +3803,133 @@ func TestMain(m *testing.M) {
 
 	os.Exit(code)
 }
+
+func TestInstanceHistory(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+	instance := instances[0]
+
+	err := ds.StopFailure(instance.ID, payloads.StopNoInstance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := ds.GetInstanceHistory(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []types.InstanceTransition{
+		{From: "", To: payloads.Pending, Source: sourceController},
+		{From: payloads.Pending, To: payloads.Running, Source: sourceStats, NodeID: stat.NodeUUID},
+		{From: payloads.Running, To: payloads.Running, Source: sourceStopFailure,
+			NodeID: stat.NodeUUID, Reason: payloads.StopNoInstance.String()},
+		{From: payloads.Running, To: types.InstanceDeleted, Source: sourceInstanceDeleted,
+			NodeID: stat.NodeUUID},
+	}
+
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d transitions, got %+v", len(expected), history)
+	}
+
+	for i, e := range expected {
+		h := history[i]
+		if h.InstanceID != instance.ID || h.TenantID != instance.TenantID ||
+			h.From != e.From || h.To != e.To || h.Source != e.Source ||
+			h.NodeID != e.NodeID || h.Reason != e.Reason || h.Timestamp.IsZero() {
+			t.Fatalf("Unexpected transition %d: %+v", i, h)
+		}
+	}
+}
+
+func TestInstanceHistoryRetention(t *testing.T) {
+	instanceID := uuid.Generate().String()
+
+	var transitions []types.InstanceTransition
+	for i := 0; i < instanceHistoryRetention+10; i++ {
+		transitions = append(transitions, types.InstanceTransition{
+			InstanceID: instanceID,
+			Timestamp:  time.Now().UTC(),
+			Source:     sourceController,
+			Reason:     fmt.Sprintf("%d", i),
+		})
+	}
+
+	err := ds.db.addInstanceTransitions(transitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := ds.GetInstanceHistory(instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != instanceHistoryRetention {
+		t.Fatalf("Expected %d transitions, got %d", instanceHistoryRetention, len(history))
+	}
+
+	if history[0].Reason != "10" {
+		t.Fatalf("Expected the latest transitions, got %+v first", history[0])
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	nodeID := uuid.Generate().String()
+
+	ds.NodeConnected(nodeID)
+
+	backlog, ch := ds.WatchEvents(0)
+	if len(backlog) == 0 {
+		t.Fatal("Expected a backlog of events")
+	}
+
+	last := backlog[len(backlog)-1]
+	if last.Kind != types.EventNodeConnected || last.NodeID != nodeID {
+		t.Fatalf("Unexpected last event %+v", last)
+	}
+
+	backlog, resumed := ds.WatchEvents(last.ID)
+	if len(backlog) != 0 {
+		t.Fatalf("Unexpected events after %d: %+v", last.ID, backlog)
+	}
+	ds.UnwatchEvents(resumed)
+
+	// the events of a previous run of the controller are missed
+	backlog, resumed = ds.WatchEvents(1)
+	if len(backlog) == 0 || backlog[0].Kind != types.EventGap ||
+		backlog[len(backlog)-1].ID != last.ID {
+		t.Fatalf("Expected a gap before the backlog: %+v", backlog)
+	}
+	ds.UnwatchEvents(resumed)
+
+	_, err := ds.NodeDisconnected(nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-ch
+	if e.ID != last.ID+1 || e.Kind != types.EventNodeDisconnected || e.NodeID != nodeID {
+		t.Fatalf("Unexpected event %+v", e)
+	}
+
+	// a watcher falling behind is dropped
+	for i := 0; i <= eventWatcherQueue; i++ {
+		ds.NodeConnected(nodeID)
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+
+	if n != eventWatcherQueue {
+		t.Fatalf("Expected %d queued events, got %d", eventWatcherQueue, n)
+	}
+
+	ds.UnwatchEvents(ch)
+}
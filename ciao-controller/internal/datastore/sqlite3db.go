@@ -18,6 +18,7 @@ package datastore
 import (
 	"database/sql"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -166,6 +167,22 @@ func (d limitsData) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+// Handling of the cluster wide default limits
+type defaultLimitsData struct {
+	namedData
+}
+
+func (d defaultLimitsData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS default_limits
+		(
+		resource_id integer primary key,
+		max_value integer,
+		foreign key(resource_id) references resources(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 // Handling of Instance specific data
 type instanceData struct {
 	namedData
@@ -179,12 +196,48 @@ func (d instanceData) Init() error {
 		workload_id string,
 		mac_address string,
 		ip string,
+		name text DEFAULT '',
+		metadata text DEFAULT '',
+		image_id varchar(32) DEFAULT '',
+		key_name text DEFAULT '',
+		recovery_policy text DEFAULT '',
+		start_config text DEFAULT '',
 		foreign key(tenant_id) references tenants(id),
 		foreign key(workload_id) references workload_template(id),
 		unique(tenant_id, ip, mac_address)
 		);`
 
-	return d.ds.exec(d.db, cmd)
+	err := d.ds.exec(d.db, cmd)
+	if err != nil {
+		return err
+	}
+
+	err = d.ds.addColumn(d.db, d.name, "name", "text DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = d.ds.addColumn(d.db, d.name, "metadata", "text DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = d.ds.addColumn(d.db, d.name, "image_id", "varchar(32) DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = d.ds.addColumn(d.db, d.name, "key_name", "text DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	err = d.ds.addColumn(d.db, d.name, "recovery_policy", "text DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	return d.ds.addColumn(d.db, d.name, "start_config", "text DEFAULT ''")
 }
 
 // Volume Data
@@ -330,6 +383,9 @@ func (d workloadResourceData) Populate() error {
 
 	for _, line := range lines {
 		workloadID := line[0]
+		if d.ds.workloadDeleted(workloadID) {
+			continue
+		}
 		resourceID, _ := strconv.Atoi(line[1])
 		defaultValue, _ := strconv.Atoi(line[2])
 		estimatedValue, _ := strconv.Atoi(line[3])
@@ -373,6 +429,9 @@ func (d workloadTemplateData) Populate() error {
 
 	for _, line := range lines {
 		id := line[0]
+		if d.ds.workloadDeleted(id) {
+			continue
+		}
 		description := line[1]
 		filename := line[2]
 		fwType := line[3]
@@ -380,7 +439,15 @@ func (d workloadTemplateData) Populate() error {
 		imageID := line[5]
 		imageName := line[6]
 		internal := line[7]
-		err = d.ds.create(d.name, id, description, filename, fwType, vmType, imageID, imageName, internal)
+		imageOverride := 0
+		if len(line) > 8 {
+			imageOverride, _ = strconv.Atoi(line[8])
+		}
+		recoveryPolicy := ""
+		if len(line) > 9 {
+			recoveryPolicy = line[9]
+		}
+		err = d.ds.create(d.name, id, description, filename, fwType, vmType, imageID, imageName, internal, imageOverride, recoveryPolicy)
 		if err != nil {
 			glog.V(2).Info("could not add workload: ", err)
 		}
@@ -399,7 +466,230 @@ func (d workloadTemplateData) Init() error {
 		vm_type text,
 		image_id varchar(32),
 		image_name text,
-		internal integer
+		internal integer,
+		image_override integer DEFAULT 0,
+		recovery_policy text DEFAULT ''
+		);`
+
+	err := d.ds.exec(d.db, cmd)
+	if err != nil {
+		return err
+	}
+
+	err = d.ds.addColumn(d.db, d.name, "image_override", "integer DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	return d.ds.addColumn(d.db, d.name, "recovery_policy", "text DEFAULT ''")
+}
+
+// workload storage data
+type workloadStorageData struct {
+	namedData
+}
+
+func (d workloadStorageData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS workload_storage
+		(
+		workload_id varchar(32) primary key,
+		volume_id varchar(32),
+		bootable integer,
+		persistent integer,
+		size integer,
+		source_type text,
+		foreign key(workload_id) references workload_template(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// deleted workloads are remembered so that they are not
+// populated again from the workload csv tables.
+type deletedWorkloadData struct {
+	namedData
+}
+
+func (d deletedWorkloadData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS deleted_workloads
+		(
+		id varchar(32) primary key
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// floating IP data
+type floatingIPData struct {
+	namedData
+}
+
+func (d floatingIPData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS floating_ips
+		(
+		id varchar(32) primary key,
+		address varchar(32) unique,
+		tenant_id varchar(32),
+		instance_id varchar(32),
+		state string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// security group data
+type securityGroupData struct {
+	namedData
+}
+
+func (d securityGroupData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS security_groups
+		(
+		id varchar(32) primary key,
+		tenant_id varchar(32),
+		name string,
+		description string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type securityGroupRuleData struct {
+	namedData
+}
+
+func (d securityGroupRuleData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS security_group_rules
+		(
+		id varchar(32) primary key,
+		group_id varchar(32),
+		protocol string,
+		from_port int,
+		to_port int,
+		cidr string,
+		foreign key(group_id) references security_groups(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// keypair data
+type keyPairData struct {
+	namedData
+}
+
+func (d keyPairData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS keypairs
+		(
+		tenant_id varchar(32),
+		name text,
+		public_key text,
+		fingerprint string,
+		created_at DATETIME,
+		unique(tenant_id, name)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// webhook data
+type webhookData struct {
+	namedData
+}
+
+func (d webhookData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS webhooks
+		(
+		id varchar(32) primary key,
+		tenant_id varchar(32),
+		url text,
+		event_types text,
+		secret text,
+		created_at DATETIME
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// webhook delivery log
+type webhookDeliveryData struct {
+	namedData
+}
+
+func (d webhookDeliveryData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS webhook_deliveries
+		(
+		id integer primary key,
+		delivery_id varchar(32),
+		webhook_id varchar(32),
+		event_id integer,
+		event_kind string,
+		attempt integer,
+		timestamp DATETIME,
+		status_code integer,
+		error string,
+		delivered integer
+		);
+		CREATE INDEX IF NOT EXISTS webhook_deliveries_index
+		ON webhook_deliveries(webhook_id);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// image data
+type imageData struct {
+	namedData
+}
+
+func (d imageData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS images
+		(
+		id varchar(32) primary key,
+		tenant_id varchar(32),
+		name text,
+		instance_id varchar(32),
+		state string,
+		size int,
+		created_at DATETIME
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type instanceHistoryData struct {
+	namedData
+}
+
+func (d instanceHistoryData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS instance_history
+		(
+		id integer primary key,
+		instance_id varchar(32),
+		tenant_id varchar(32),
+		timestamp DATETIME,
+		from_state string,
+		to_state string,
+		source string,
+		node_id varchar(32),
+		reason string
+		);
+		CREATE INDEX IF NOT EXISTS instance_history_index
+		ON instance_history(instance_id);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type instanceSecurityGroupData struct {
+	namedData
+}
+
+func (d instanceSecurityGroupData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS instance_security_groups
+		(
+		instance_id varchar(32),
+		group_id varchar(32),
+		unique(instance_id, group_id),
+		foreign key(group_id) references security_groups(id)
 		);`
 
 	return d.ds.exec(d.db, cmd)
@@ -504,6 +794,37 @@ func (ds *sqliteDB) exec(db *sql.DB, cmd string) error {
 	return err
 }
 
+// addColumn adds a column to a table created by an earlier version
+// of the controller.
+func (ds *sqliteDB) addColumn(db *sql.DB, tableName string, column string, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + tableName + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return ds.exec(db, "ALTER TABLE "+tableName+" ADD COLUMN "+column+" "+definition)
+}
+
 func (ds *sqliteDB) create(tableName string, record ...interface{}) error {
 	// get database location of this table
 	db := ds.getTableDB(tableName)
@@ -563,9 +884,12 @@ func getPersistentStore(config Config) (persistentStore, error) {
 		resourceData{namedData{ds: ds, name: "resources", db: ds.db}},
 		tenantData{namedData{ds: ds, name: "tenants", db: ds.db}},
 		limitsData{namedData{ds: ds, name: "limits", db: ds.db}},
+		defaultLimitsData{namedData{ds: ds, name: "default_limits", db: ds.db}},
 		instanceData{namedData{ds: ds, name: "instances", db: ds.db}},
 		workloadTemplateData{namedData{ds: ds, name: "workload_template", db: ds.db}},
 		workloadResourceData{namedData{ds: ds, name: "workload_resources", db: ds.db}},
+		workloadStorageData{namedData{ds: ds, name: "workload_storage", db: ds.db}},
+		deletedWorkloadData{namedData{ds: ds, name: "deleted_workloads", db: ds.db}},
 		usageData{namedData{ds: ds, name: "usage", db: ds.db}},
 		nodeStatisticsData{namedData{ds: ds, name: "node_statistics", db: ds.tdb}},
 		logData{namedData{ds: ds, name: "log", db: ds.tdb}},
@@ -575,6 +899,15 @@ func getPersistentStore(config Config) (persistentStore, error) {
 		traceData{namedData{ds: ds, name: "trace_data", db: ds.tdb}},
 		blockData{namedData{ds: ds, name: "block_data", db: ds.db}},
 		attachments{namedData{ds: ds, name: "attachments", db: ds.db}},
+		floatingIPData{namedData{ds: ds, name: "floating_ips", db: ds.db}},
+		securityGroupData{namedData{ds: ds, name: "security_groups", db: ds.db}},
+		securityGroupRuleData{namedData{ds: ds, name: "security_group_rules", db: ds.db}},
+		instanceSecurityGroupData{namedData{ds: ds, name: "instance_security_groups", db: ds.db}},
+		keyPairData{namedData{ds: ds, name: "keypairs", db: ds.db}},
+		imageData{namedData{ds: ds, name: "images", db: ds.db}},
+		instanceHistoryData{namedData{ds: ds, name: "instance_history", db: ds.db}},
+		webhookData{namedData{ds: ds, name: "webhooks", db: ds.db}},
+		webhookDeliveryData{namedData{ds: ds, name: "webhook_deliveries", db: ds.tdb}},
 	}
 
 	ds.tableInitPath = config.InitTablesPath
@@ -786,94 +1119,388 @@ func (ds *sqliteDB) getWorkloadDefaults(ID string) ([]payloads.RequestedResource
 }
 
 func (ds *sqliteDB) getWorkloadStorage(ID string) (*types.StorageResource, error) {
-	// fake this for now. We are going to always request a
-	// new bootable image which will persist.
-	return &types.StorageResource{
-		ID:         "",
-		Bootable:   true,
-		Persistent: true,
-		SourceType: types.ImageService,
-	}, nil
-}
-
-func (ds *sqliteDB) addLimit(tenantID string, resourceID int, limit int) error {
-	ds.dbLock.Lock()
-	err := ds.create("limits", resourceID, tenantID, limit)
-	ds.dbLock.Unlock()
-
-	return err
-}
+	query := `SELECT volume_id, bootable, persistent, size, source_type
+		  FROM workload_storage
+		  WHERE workload_id = ?`
 
-func (ds *sqliteDB) getTenantResources(ID string) ([]*types.Resource, error) {
-	query := `WITH instances_usage AS
-		 (
-			 SELECT resource_id, value
-			 FROM usage
-			 LEFT JOIN instances
-			 ON usage.instance_id = instances.id
-			 WHERE instances.tenant_id = ?
-		 )
-		 SELECT resources.name, resources.id, limits.max_value,
-		 CASE resources.id
-		 WHEN resources.id = 1 then
-		 (
-			 SELECT COUNT(instances.id)
-			 FROM instances
-			 WHERE instances.tenant_id = ?
-		 )
-		 ELSE SUM(instances_usage.value)
-		 END
-		 FROM resources
-		 LEFT JOIN instances_usage
-		 ON instances_usage.resource_id = resources.id
-		 LEFT JOIN limits
-		 ON resources.id=limits.resource_id
-		 AND limits.tenant_id = ?
-		 GROUP BY resources.id`
+	db := ds.getTableDB("workload_storage")
 
-	datastore := ds.db
+	var s types.StorageResource
+	var sourceType string
 
-	rows, err := datastore.Query(query, ID, ID, ID)
-	if err != nil {
-		glog.Warning("Failed to get tenant usage")
+	err := db.QueryRow(query, ID).Scan(&s.ID, &s.Bootable, &s.Persistent, &s.Size, &sourceType)
+	switch {
+	case err == sql.ErrNoRows:
+		// workloads without a storage definition always request
+		// a new bootable image which will persist.
+		return &types.StorageResource{
+			ID:         "",
+			Bootable:   true,
+			Persistent: true,
+			SourceType: types.ImageService,
+		}, nil
+	case err != nil:
 		return nil, err
 	}
-	defer rows.Close()
 
-	var resources []*types.Resource
+	s.SourceType = types.SourceType(sourceType)
 
-	for rows.Next() {
-		var id int
-		var name string
-		var sqlMaxVal sql.NullInt64
-		var sqlCurVal sql.NullInt64
-		var maxVal = -1
-		var curVal = 0
+	return &s, nil
+}
 
-		err = rows.Scan(&name, &id, &sqlMaxVal, &sqlCurVal)
-		if err != nil {
-			return nil, err
-		}
+func (ds *sqliteDB) workloadDeleted(ID string) bool {
+	var deleted string
 
-		if sqlMaxVal.Valid {
-			maxVal = int(sqlMaxVal.Int64)
-		}
+	db := ds.getTableDB("deleted_workloads")
 
-		if sqlCurVal.Valid {
-			curVal = int(sqlCurVal.Int64)
-		}
+	err := db.QueryRow("SELECT id FROM deleted_workloads WHERE id = ?", ID).Scan(&deleted)
 
-		r := types.Resource{
-			Rname: name,
-			Rtype: id,
-			Limit: maxVal,
-			Usage: curVal,
-		}
+	return err == nil
+}
 
-		resources = append(resources, &r)
-	}
+// writeWorkloadConfig stores the cloud-init template of a workload
+// in a temporary file of the workloads path, which is only renamed
+// next to the templates read from the initial tables once the workload
+// is committed to the database.
+func (ds *sqliteDB) writeWorkloadConfig(wl *workload) (string, error) {
+	wl.filename = fmt.Sprintf("%s.yaml", wl.ID)
 
-	return resources, nil
+	f, err := ioutil.TempFile(ds.workloadsPath, "."+wl.filename)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.WriteString(wl.Config)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// updateWorkloadTemplate runs the statements updating the definition
+// of a workload in a single transaction, and only replaces its
+// cloud-init template when that transaction is committed.
+func (ds *sqliteDB) updateWorkloadTemplate(wl *workload, update func(tx *sql.Tx) error) error {
+	tmpPath, err := ds.writeWorkloadConfig(wl)
+	if err != nil {
+		return err
+	}
+
+	err = ds.commitWorkloadTemplate(update)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	path := fmt.Sprintf("%s/%s", ds.workloadsPath, wl.filename)
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+func (ds *sqliteDB) commitWorkloadTemplate(update func(tx *sql.Tx) error) error {
+	db := ds.getTableDB("workload_template")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = update(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertWorkloadResources(tx *sql.Tx, wl *workload) error {
+	for _, r := range wl.Defaults {
+		var resourceID int
+
+		err := tx.QueryRow("SELECT id FROM resources WHERE name = ?", string(r.Type)).Scan(&resourceID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("Unknown resource %q", r.Type)
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO workload_resources VALUES (?, ?, ?, ?, ?)",
+			wl.ID, resourceID, r.Value, r.Value, r.Mandatory)
+		if err != nil {
+			return err
+		}
+	}
+
+	if wl.Storage == nil {
+		return nil
+	}
+
+	s := wl.Storage
+	_, err := tx.Exec("INSERT INTO workload_storage VALUES (?, ?, ?, ?, ?, ?)",
+		wl.ID, s.ID, s.Bootable, s.Persistent, s.Size, string(s.SourceType))
+
+	return err
+}
+
+func (ds *sqliteDB) addWorkload(wl *workload) error {
+	return ds.updateWorkloadTemplate(wl, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO workload_template VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)",
+			wl.ID, wl.Description, wl.filename, wl.FWType, string(wl.VMType), wl.ImageID, wl.ImageName, wl.ImageOverride, string(wl.RecoveryPolicy))
+		if err != nil {
+			return err
+		}
+
+		return insertWorkloadResources(tx, wl)
+	})
+}
+
+func (ds *sqliteDB) updateWorkload(wl *workload) error {
+	return ds.updateWorkloadTemplate(wl, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE workload_template
+				  SET description = ?, filename = ?, fw_type = ?, vm_type = ?, image_id = ?, image_name = ?, image_override = ?, recovery_policy = ?
+				  WHERE id = ?`,
+			wl.Description, wl.filename, wl.FWType, string(wl.VMType), wl.ImageID, wl.ImageName, wl.ImageOverride, string(wl.RecoveryPolicy), wl.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM workload_resources WHERE workload_id = ?", wl.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM workload_storage WHERE workload_id = ?", wl.ID)
+		if err != nil {
+			return err
+		}
+
+		return insertWorkloadResources(tx, wl)
+	})
+}
+
+func (ds *sqliteDB) deleteWorkload(ID string) error {
+	var filename string
+
+	db := ds.getTableDB("workload_template")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	err := db.QueryRow("SELECT filename FROM workload_template WHERE id = ?", ID).Scan(&filename)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	cmds := []string{
+		"DELETE FROM workload_resources WHERE workload_id = ?",
+		"DELETE FROM workload_storage WHERE workload_id = ?",
+		"DELETE FROM workload_template WHERE id = ?",
+		"INSERT OR IGNORE INTO deleted_workloads VALUES (?)",
+	}
+
+	for _, cmd := range cmds {
+		_, err = tx.Exec(cmd, ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// the cloud-init template may be shared by several workloads
+	var users int
+	err = db.QueryRow("SELECT count(*) FROM workload_template WHERE filename = ?", filename).Scan(&users)
+	if err == nil && users == 0 {
+		path := fmt.Sprintf("%s/%s", ds.workloadsPath, filename)
+		if err := os.Remove(path); err != nil {
+			glog.Warningf("Unable to remove workload config %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (ds *sqliteDB) addLimit(tenantID string, resourceID int, limit int) error {
+	db := ds.getTableDB("limits")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// a tenant has a single limit per resource, replace the
+	// previous one if any.
+	_, err = tx.Exec("DELETE FROM limits WHERE tenant_id = ? AND resource_id = ?",
+		tenantID, resourceID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO limits (resource_id, tenant_id, max_value) VALUES (?, ?, ?)",
+		resourceID, tenantID, limit)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *sqliteDB) deleteLimit(tenantID string, resourceID int) error {
+	return ds.execArgs("limits",
+		"DELETE FROM limits WHERE tenant_id = ? AND resource_id = ?",
+		tenantID, resourceID)
+}
+
+func (ds *sqliteDB) getDefaultLimits() (map[int]int, error) {
+	limits := make(map[int]int)
+
+	datastore := ds.getTableDB("default_limits")
+
+	rows, err := datastore.Query("SELECT resource_id, max_value FROM default_limits")
+	if err != nil {
+		return limits, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var resourceID, limit int
+
+		err = rows.Scan(&resourceID, &limit)
+		if err != nil {
+			continue
+		}
+
+		limits[resourceID] = limit
+	}
+
+	return limits, rows.Err()
+}
+
+func (ds *sqliteDB) setDefaultLimit(resourceID int, limit int) error {
+	return ds.execArgs("default_limits",
+		"INSERT OR REPLACE INTO default_limits (resource_id, max_value) VALUES (?, ?)",
+		resourceID, limit)
+}
+
+func (ds *sqliteDB) deleteDefaultLimit(resourceID int) error {
+	return ds.execArgs("default_limits",
+		"DELETE FROM default_limits WHERE resource_id = ?", resourceID)
+}
+
+func (ds *sqliteDB) getTenantResources(ID string) ([]*types.Resource, error) {
+	query := `WITH instances_usage AS
+		 (
+			 SELECT resource_id, value
+			 FROM usage
+			 LEFT JOIN instances
+			 ON usage.instance_id = instances.id
+			 WHERE instances.tenant_id = ?
+		 )
+		 SELECT resources.name, resources.id,
+		 IFNULL(limits.max_value, default_limits.max_value),
+		 CASE resources.id
+		 WHEN resources.id = 1 then
+		 (
+			 SELECT COUNT(instances.id)
+			 FROM instances
+			 WHERE instances.tenant_id = ?
+		 )
+		 WHEN 6 then
+		 (
+			 SELECT COUNT(block_data.id)
+			 FROM block_data
+			 WHERE block_data.tenant_id = ?
+		 )
+		 ELSE SUM(instances_usage.value)
+		 END
+		 FROM resources
+		 LEFT JOIN instances_usage
+		 ON instances_usage.resource_id = resources.id
+		 LEFT JOIN limits
+		 ON resources.id=limits.resource_id
+		 AND limits.tenant_id = ?
+		 LEFT JOIN default_limits
+		 ON resources.id=default_limits.resource_id
+		 GROUP BY resources.id`
+
+	datastore := ds.db
+
+	rows, err := datastore.Query(query, ID, ID, ID, ID)
+	if err != nil {
+		glog.Warning("Failed to get tenant usage")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resources []*types.Resource
+
+	for rows.Next() {
+		var id int
+		var name string
+		var sqlMaxVal sql.NullInt64
+		var sqlCurVal sql.NullInt64
+		var maxVal = -1
+		var curVal = 0
+
+		err = rows.Scan(&name, &id, &sqlMaxVal, &sqlCurVal)
+		if err != nil {
+			return nil, err
+		}
+
+		if sqlMaxVal.Valid {
+			maxVal = int(sqlMaxVal.Int64)
+		}
+
+		if sqlCurVal.Valid {
+			curVal = int(sqlCurVal.Int64)
+		}
+
+		r := types.Resource{
+			Rname: name,
+			Rtype: id,
+			Limit: maxVal,
+			Usage: curVal,
+		}
+
+		resources = append(resources, &r)
+	}
+
+	return resources, nil
 }
 
 func (ds *sqliteDB) addTenant(ID string, MAC string) error {
@@ -937,15 +1564,18 @@ func (ds *sqliteDB) getWorkloadNoCache(id string) (*workload, error) {
 			 fw_type,
 			 vm_type,
 			 image_id,
-			 image_name
+			 image_name,
+			 image_override,
+			 IFNULL(recovery_policy, "")
 		  FROM workload_template
 		  WHERE id = ?`
 
 	work := new(workload)
 
 	var VMType string
+	var recoveryPolicy string
 
-	err := datastore.QueryRow(query, id).Scan(&work.ID, &work.Description, &work.filename, &work.FWType, &VMType, &work.ImageID, &work.ImageName)
+	err := datastore.QueryRow(query, id).Scan(&work.ID, &work.Description, &work.filename, &work.FWType, &VMType, &work.ImageID, &work.ImageName, &work.ImageOverride, &recoveryPolicy)
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, fmt.Errorf("Workload %q not found", id)
@@ -954,6 +1584,7 @@ func (ds *sqliteDB) getWorkloadNoCache(id string) (*workload, error) {
 	}
 
 	work.VMType = payloads.Hypervisor(VMType)
+	work.RecoveryPolicy = types.RecoveryPolicy(recoveryPolicy)
 
 	work.Config, err = ds.getConfigNoCache(id)
 	if err != nil {
@@ -984,7 +1615,9 @@ func (ds *sqliteDB) getWorkloadsNoCache() ([]*workload, error) {
 			 fw_type,
 			 vm_type,
 			 image_id,
-			 image_name
+			 image_name,
+			 image_override,
+			 IFNULL(recovery_policy, "")
 		  FROM workload_template
 		  WHERE internal = 0`
 
@@ -998,8 +1631,9 @@ func (ds *sqliteDB) getWorkloadsNoCache() ([]*workload, error) {
 		wl := new(workload)
 
 		var VMType string
+		var recoveryPolicy string
 
-		err = rows.Scan(&wl.ID, &wl.Description, &wl.filename, &wl.FWType, &VMType, &wl.ImageID, &wl.ImageName)
+		err = rows.Scan(&wl.ID, &wl.Description, &wl.filename, &wl.FWType, &VMType, &wl.ImageID, &wl.ImageName, &wl.ImageOverride, &recoveryPolicy)
 		if err != nil {
 			return nil, err
 		}
@@ -1020,6 +1654,7 @@ func (ds *sqliteDB) getWorkloadsNoCache() ([]*workload, error) {
 		}
 
 		wl.VMType = payloads.Hypervisor(VMType)
+		wl.RecoveryPolicy = types.RecoveryPolicy(recoveryPolicy)
 
 		workloads = append(workloads, wl)
 	}
@@ -1054,6 +1689,42 @@ func (ds *sqliteDB) updateTenant(t *tenant) error {
 	return err
 }
 
+// deleteTenant removes a tenant along with its network addresses and
+// its limits.
+func (ds *sqliteDB) deleteTenant(ID string) error {
+	db := ds.getTableDB("tenants")
+
+	ds.dbLock.Lock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	cmds := []string{
+		"DELETE FROM tenant_network WHERE tenant_id = ?",
+		"DELETE FROM limits WHERE tenant_id = ?",
+		"DELETE FROM instance_history WHERE tenant_id = ?",
+		"DELETE FROM tenants WHERE id = ?",
+	}
+
+	for _, cmd := range cmds {
+		_, err = tx.Exec(cmd, ID)
+		if err != nil {
+			tx.Rollback()
+			ds.dbLock.Unlock()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+
+	ds.dbLock.Unlock()
+
+	return err
+}
+
 func (ds *sqliteDB) getTenantsNoCache() ([]*tenant, error) {
 	var tenants []*tenant
 
@@ -1267,7 +1938,13 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		latest.ssh_port as ssh_port,
 		IFNULL(latest.node_id, "Not Assigned") as node_id,
 		mac_address,
-		ip
+		ip,
+		IFNULL(instances.name, "") AS name,
+		IFNULL(instances.metadata, "") AS metadata,
+		IFNULL(instances.image_id, "") AS image_id,
+		IFNULL(instances.key_name, "") AS key_name,
+		IFNULL(instances.recovery_policy, "") AS recovery_policy,
+		IFNULL(instances.start_config, "") AS start_config
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1285,16 +1962,27 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		var i types.Instance
 
 		var sshPort sql.NullInt64
+		var metadata string
+		var recoveryPolicy string
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.IPAddress)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.IPAddress, &i.Name, &metadata, &i.ImageID, &i.KeyName, &recoveryPolicy, &i.StartConfig)
 		if err != nil {
 			tx.Rollback()
 			ds.tdbLock.RUnlock()
 			return nil, err
 		}
 
-		if sshPort.Valid {
-			i.SSHPort = int(sshPort.Int64)
+		i.RecoveryPolicy = types.RecoveryPolicy(recoveryPolicy)
+
+		i.Metadata, err = unmarshalInstanceMetadata(metadata)
+		if err != nil {
+			tx.Rollback()
+			ds.tdbLock.RUnlock()
+			return nil, err
+		}
+
+		if sshPort.Valid {
+			i.SSHPort = int(sshPort.Int64)
 		}
 
 		defaults, err := ds.getWorkloadDefaults(i.WorkloadID)
@@ -1360,7 +2048,13 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		workload_id,
 		latest.node_id,
 		mac_address,
-		ip
+		ip,
+		IFNULL(instances.name, "") AS name,
+		IFNULL(instances.metadata, "") AS metadata,
+		IFNULL(instances.image_id, "") AS image_id,
+		IFNULL(instances.key_name, "") AS key_name,
+		IFNULL(instances.recovery_policy, "") AS recovery_policy,
+		IFNULL(instances.start_config, "") AS start_config
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1380,10 +2074,21 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		var nodeID sql.NullString
 		var sshIP sql.NullString
 		var sshPort sql.NullInt64
+		var metadata string
+		var recoveryPolicy string
 
 		i := &types.Instance{}
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.IPAddress)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.IPAddress, &i.Name, &metadata, &i.ImageID, &i.KeyName, &recoveryPolicy, &i.StartConfig)
+		if err != nil {
+			tx.Rollback()
+			ds.tdbLock.RUnlock()
+			return nil, err
+		}
+
+		i.RecoveryPolicy = types.RecoveryPolicy(recoveryPolicy)
+
+		i.Metadata, err = unmarshalInstanceMetadata(metadata)
 		if err != nil {
 			tx.Rollback()
 			ds.tdbLock.RUnlock()
@@ -1432,10 +2137,41 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 	return instances, nil
 }
 
+func unmarshalInstanceMetadata(metadata string) (map[string]string, error) {
+	if metadata == "" {
+		return nil, nil
+	}
+
+	var m map[string]string
+	err := json.Unmarshal([]byte(metadata), &m)
+
+	return m, err
+}
+
 func (ds *sqliteDB) addInstance(instance *types.Instance) error {
+	var metadata []byte
+
+	if len(instance.Metadata) > 0 {
+		var err error
+
+		metadata, err = json.Marshal(instance.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	db := ds.getTableDB("instances")
+
 	ds.dbLock.Lock()
 
-	err := ds.create("instances", instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.IPAddress)
+	// the name and metadata are user supplied, so we do not
+	// use ds.create here.
+	_, err := db.Exec(`INSERT or IGNORE INTO instances
+			   (id, tenant_id, workload_id, mac_address, ip, name, metadata, image_id, key_name, recovery_policy, start_config)
+			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress,
+		instance.IPAddress, instance.Name, string(metadata), instance.ImageID, instance.KeyName,
+		string(instance.RecoveryPolicy), instance.StartConfig)
 
 	ds.dbLock.Unlock()
 
@@ -1443,6 +2179,13 @@ func (ds *sqliteDB) addInstance(instance *types.Instance) error {
 		return err
 	}
 
+	for _, groupID := range instance.SecurityGroups {
+		err = ds.addInstanceSecurityGroup(instance.ID, groupID)
+		if err != nil {
+			return err
+		}
+	}
+
 	return ds.addUsage(instance.ID, instance.Usage)
 }
 
@@ -1471,6 +2214,13 @@ func (ds *sqliteDB) removeInstance(instanceID string) error {
 		return err
 	}
 
+	_, err = tx.Exec("DELETE FROM instance_security_groups WHERE instance_id = ?", instanceID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
 	tx.Commit()
 
 	ds.dbLock.Unlock()
@@ -1478,6 +2228,21 @@ func (ds *sqliteDB) removeInstance(instanceID string) error {
 	return err
 }
 
+func (ds *sqliteDB) updateInstanceWorkload(instanceID string, workloadID string, usage map[string]int) error {
+	err := ds.execArgs("instances", "UPDATE instances SET workload_id = ? WHERE id = ?",
+		workloadID, instanceID)
+	if err != nil {
+		return err
+	}
+
+	err = ds.execArgs("usage", "DELETE FROM usage WHERE instance_id = ?", instanceID)
+	if err != nil {
+		return err
+	}
+
+	return ds.addUsage(instanceID, usage)
+}
+
 func (ds *sqliteDB) addUsage(instanceID string, usage map[string]int) error {
 	datastore := ds.getTableDB("usage")
 
@@ -2283,3 +3048,563 @@ func (ds *sqliteDB) deleteStorageAttachment(ID string) error {
 
 	return err
 }
+
+func (ds *sqliteDB) getAllFloatingIPs() (map[string]types.FloatingIP, error) {
+	ips := make(map[string]types.FloatingIP)
+
+	datastore := ds.getTableDB("floating_ips")
+
+	query := `SELECT	floating_ips.id,
+				floating_ips.address,
+				floating_ips.tenant_id,
+				floating_ips.instance_id,
+				floating_ips.state
+		  FROM	floating_ips `
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return ips, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ip types.FloatingIP
+		var state string
+
+		err = rows.Scan(&ip.ID, &ip.Address, &ip.TenantID, &ip.InstanceID, &state)
+		if err != nil {
+			continue
+		}
+
+		ip.State = types.FloatingIPState(state)
+		ips[ip.ID] = ip
+	}
+
+	if err = rows.Err(); err != nil {
+		return ips, err
+	}
+
+	return ips, nil
+}
+
+func (ds *sqliteDB) createFloatingIP(ip types.FloatingIP) error {
+	ds.dbLock.Lock()
+	err := ds.create("floating_ips", ip.ID, ip.Address, ip.TenantID, ip.InstanceID, string(ip.State))
+	ds.dbLock.Unlock()
+
+	return err
+}
+
+func (ds *sqliteDB) updateFloatingIP(ip types.FloatingIP) error {
+	db := ds.getTableDB("floating_ips")
+
+	ds.dbLock.Lock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE floating_ips SET tenant_id = ?, instance_id = ?, state = ? WHERE id = ?",
+		ip.TenantID, ip.InstanceID, string(ip.State), ip.ID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	tx.Commit()
+
+	ds.dbLock.Unlock()
+
+	return err
+}
+
+func (ds *sqliteDB) deleteFloatingIP(ID string) error {
+	datastore := ds.getTableDB("floating_ips")
+
+	ds.dbLock.Lock()
+	tx, err := datastore.Begin()
+	if err != nil {
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM floating_ips WHERE id = ?", ID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	tx.Commit()
+	ds.dbLock.Unlock()
+
+	return err
+}
+
+func (ds *sqliteDB) getAllSecurityGroups() (map[string]types.SecurityGroup, error) {
+	groups := make(map[string]types.SecurityGroup)
+
+	datastore := ds.getTableDB("security_groups")
+
+	query := `SELECT	security_groups.id,
+				security_groups.tenant_id,
+				security_groups.name,
+				security_groups.description
+		  FROM	security_groups `
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return groups, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g types.SecurityGroup
+
+		err = rows.Scan(&g.ID, &g.TenantID, &g.Name, &g.Description)
+		if err != nil {
+			continue
+		}
+
+		groups[g.ID] = g
+	}
+
+	if err = rows.Err(); err != nil {
+		return groups, err
+	}
+
+	query = `SELECT	security_group_rules.id,
+			security_group_rules.group_id,
+			security_group_rules.protocol,
+			security_group_rules.from_port,
+			security_group_rules.to_port,
+			security_group_rules.cidr
+		 FROM	security_group_rules `
+
+	ruleRows, err := datastore.Query(query)
+	if err != nil {
+		return groups, err
+	}
+	defer ruleRows.Close()
+
+	for ruleRows.Next() {
+		var r types.SecurityGroupRule
+
+		err = ruleRows.Scan(&r.ID, &r.GroupID, &r.Protocol, &r.FromPort, &r.ToPort, &r.CIDR)
+		if err != nil {
+			continue
+		}
+
+		g, ok := groups[r.GroupID]
+		if !ok {
+			continue
+		}
+
+		g.Rules = append(g.Rules, r)
+		groups[g.ID] = g
+	}
+
+	return groups, ruleRows.Err()
+}
+
+// execArgs runs a single statement with arguments in a transaction on the
+// database of a table.
+func (ds *sqliteDB) execArgs(table string, cmd string, args ...interface{}) error {
+	db := ds.getTableDB(table)
+
+	ds.dbLock.Lock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	// the arguments may be user supplied, so we do not
+	// use ds.create here.
+	_, err = tx.Exec(cmd, args...)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	tx.Commit()
+
+	ds.dbLock.Unlock()
+
+	return err
+}
+
+func (ds *sqliteDB) createSecurityGroup(g types.SecurityGroup) error {
+	return ds.execArgs("security_groups",
+		"INSERT INTO security_groups (id, tenant_id, name, description) VALUES (?, ?, ?, ?)",
+		g.ID, g.TenantID, g.Name, g.Description)
+}
+
+func (ds *sqliteDB) deleteSecurityGroup(ID string) error {
+	err := ds.execArgs("security_group_rules",
+		"DELETE FROM security_group_rules WHERE group_id = ?", ID)
+	if err != nil {
+		return err
+	}
+
+	return ds.execArgs("security_groups",
+		"DELETE FROM security_groups WHERE id = ?", ID)
+}
+
+func (ds *sqliteDB) createSecurityGroupRule(r types.SecurityGroupRule) error {
+	return ds.execArgs("security_group_rules",
+		`INSERT INTO security_group_rules (id, group_id, protocol, from_port, to_port, cidr)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		r.ID, r.GroupID, r.Protocol, r.FromPort, r.ToPort, r.CIDR)
+}
+
+func (ds *sqliteDB) deleteSecurityGroupRule(ID string) error {
+	return ds.execArgs("security_group_rules",
+		"DELETE FROM security_group_rules WHERE id = ?", ID)
+}
+
+func (ds *sqliteDB) getAllInstanceSecurityGroups() (map[string][]string, error) {
+	members := make(map[string][]string)
+
+	datastore := ds.getTableDB("instance_security_groups")
+
+	query := `SELECT	instance_security_groups.instance_id,
+				instance_security_groups.group_id
+		  FROM	instance_security_groups `
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return members, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var instanceID, groupID string
+
+		err = rows.Scan(&instanceID, &groupID)
+		if err != nil {
+			continue
+		}
+
+		members[instanceID] = append(members[instanceID], groupID)
+	}
+
+	return members, rows.Err()
+}
+
+func (ds *sqliteDB) addInstanceSecurityGroup(instanceID string, groupID string) error {
+	return ds.execArgs("instance_security_groups",
+		"INSERT or IGNORE INTO instance_security_groups (instance_id, group_id) VALUES (?, ?)",
+		instanceID, groupID)
+}
+
+func (ds *sqliteDB) removeInstanceSecurityGroup(instanceID string, groupID string) error {
+	return ds.execArgs("instance_security_groups",
+		"DELETE FROM instance_security_groups WHERE instance_id = ? AND group_id = ?",
+		instanceID, groupID)
+}
+
+func (ds *sqliteDB) getAllKeyPairs() ([]types.KeyPair, error) {
+	var keys []types.KeyPair
+
+	datastore := ds.getTableDB("keypairs")
+
+	query := `SELECT	keypairs.tenant_id,
+				keypairs.name,
+				keypairs.public_key,
+				keypairs.fingerprint,
+				keypairs.created_at
+		  FROM	keypairs `
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k types.KeyPair
+
+		err = rows.Scan(&k.TenantID, &k.Name, &k.PublicKey, &k.Fingerprint, &k.CreatedAt)
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (ds *sqliteDB) createKeyPair(k types.KeyPair) error {
+	return ds.execArgs("keypairs",
+		`INSERT INTO keypairs (tenant_id, name, public_key, fingerprint, created_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		k.TenantID, k.Name, k.PublicKey, k.Fingerprint, k.CreatedAt)
+}
+
+func (ds *sqliteDB) deleteKeyPair(tenantID string, name string) error {
+	return ds.execArgs("keypairs",
+		"DELETE FROM keypairs WHERE tenant_id = ? AND name = ?", tenantID, name)
+}
+
+func (ds *sqliteDB) getAllImages() ([]types.Image, error) {
+	var images []types.Image
+
+	datastore := ds.getTableDB("images")
+
+	query := `SELECT	images.id,
+				images.tenant_id,
+				images.name,
+				images.instance_id,
+				images.state,
+				images.size,
+				images.created_at
+		  FROM	images `
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return images, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i types.Image
+
+		err = rows.Scan(&i.ID, &i.TenantID, &i.Name, &i.InstanceID, &i.State, &i.Size, &i.CreatedAt)
+		if err != nil {
+			continue
+		}
+
+		images = append(images, i)
+	}
+
+	return images, rows.Err()
+}
+
+func (ds *sqliteDB) updateImage(i types.Image) error {
+	return ds.execArgs("images",
+		`INSERT OR REPLACE INTO images (id, tenant_id, name, instance_id, state, size, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		i.ID, i.TenantID, i.Name, i.InstanceID, i.State, i.Size, i.CreatedAt)
+}
+
+func (ds *sqliteDB) deleteImage(ID string) error {
+	return ds.execArgs("images", "DELETE FROM images WHERE id = ?", ID)
+}
+
+// addInstanceTransitions stores a batch of instance state transitions in a
+// single transaction, and drops the transitions which are no longer among
+// the latest instanceHistoryRetention ones.
+func (ds *sqliteDB) addInstanceTransitions(transitions []types.InstanceTransition) error {
+	db := ds.getTableDB("instance_history")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO instance_history
+		 (instance_id, tenant_id, timestamp, from_state, to_state, source, node_id, reason)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, t := range transitions {
+		_, err = stmt.Exec(t.InstanceID, t.TenantID, t.Timestamp, t.From, t.To,
+			t.Source, t.NodeID, t.Reason)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM instance_history
+		 WHERE id <= (SELECT MAX(id) FROM instance_history) - ?`,
+		instanceHistoryRetention)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *sqliteDB) getInstanceHistory(instanceID string) ([]types.InstanceTransition, error) {
+	var history []types.InstanceTransition
+
+	datastore := ds.getTableDB("instance_history")
+
+	query := `SELECT	instance_id,
+				tenant_id,
+				timestamp,
+				from_state,
+				to_state,
+				source,
+				node_id,
+				reason
+		  FROM	instance_history
+		  WHERE instance_id = ?
+		  ORDER BY id`
+
+	rows, err := datastore.Query(query, instanceID)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t types.InstanceTransition
+
+		err = rows.Scan(&t.InstanceID, &t.TenantID, &t.Timestamp, &t.From,
+			&t.To, &t.Source, &t.NodeID, &t.Reason)
+		if err != nil {
+			continue
+		}
+
+		history = append(history, t)
+	}
+
+	return history, rows.Err()
+}
+
+func (ds *sqliteDB) getAllWebhooks() ([]types.Webhook, error) {
+	var webhooks []types.Webhook
+
+	datastore := ds.getTableDB("webhooks")
+
+	query := `SELECT	webhooks.id,
+				webhooks.tenant_id,
+				webhooks.url,
+				webhooks.event_types,
+				webhooks.secret,
+				webhooks.created_at
+		  FROM	webhooks `
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return webhooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w types.Webhook
+		var eventTypes string
+
+		err = rows.Scan(&w.ID, &w.TenantID, &w.URL, &eventTypes, &w.Secret, &w.CreatedAt)
+		if err != nil {
+			continue
+		}
+
+		for _, kind := range strings.Split(eventTypes, ",") {
+			if kind != "" {
+				w.EventTypes = append(w.EventTypes, types.EventKind(kind))
+			}
+		}
+
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (ds *sqliteDB) createWebhook(w types.Webhook) error {
+	var eventTypes []string
+	for _, kind := range w.EventTypes {
+		eventTypes = append(eventTypes, string(kind))
+	}
+
+	return ds.execArgs("webhooks",
+		`INSERT INTO webhooks (id, tenant_id, url, event_types, secret, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		w.ID, w.TenantID, w.URL, strings.Join(eventTypes, ","), w.Secret, w.CreatedAt)
+}
+
+func (ds *sqliteDB) deleteWebhook(ID string) error {
+	err := ds.execArgs("webhooks", "DELETE FROM webhooks WHERE id = ?", ID)
+	if err != nil {
+		return err
+	}
+
+	return ds.execArgs("webhook_deliveries",
+		"DELETE FROM webhook_deliveries WHERE webhook_id = ?", ID)
+}
+
+func (ds *sqliteDB) addWebhookDelivery(d types.WebhookDelivery) error {
+	err := ds.execArgs("webhook_deliveries",
+		`INSERT INTO webhook_deliveries
+		 (delivery_id, webhook_id, event_id, event_kind, attempt, timestamp, status_code, error, delivered)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.WebhookID, int64(d.EventID), string(d.EventKind), d.Attempt, d.Timestamp,
+		d.StatusCode, d.Error, d.Delivered)
+	if err != nil {
+		return err
+	}
+
+	return ds.execArgs("webhook_deliveries",
+		`DELETE FROM webhook_deliveries
+		 WHERE webhook_id = ? AND id NOT IN
+		 (SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?)`,
+		d.WebhookID, d.WebhookID, webhookDeliveryRetention)
+}
+
+func (ds *sqliteDB) getWebhookDeliveries(webhookID string, limit int, offset int) ([]types.WebhookDelivery, error) {
+	var deliveries []types.WebhookDelivery
+
+	datastore := ds.getTableDB("webhook_deliveries")
+
+	query := `SELECT	delivery_id,
+				webhook_id,
+				event_id,
+				event_kind,
+				attempt,
+				timestamp,
+				status_code,
+				error,
+				delivered
+		  FROM	webhook_deliveries
+		  WHERE webhook_id = ?
+		  ORDER BY id
+		  LIMIT ? OFFSET ?`
+
+	// a negative limit returns all the rows.
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := datastore.Query(query, webhookID, limit, offset)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d types.WebhookDelivery
+		var eventID int64
+		var eventKind string
+
+		err = rows.Scan(&d.ID, &d.WebhookID, &eventID, &eventKind, &d.Attempt,
+			&d.Timestamp, &d.StatusCode, &d.Error, &d.Delivered)
+		if err != nil {
+			continue
+		}
+
+		d.EventID = uint64(eventID)
+		d.EventKind = types.EventKind(eventKind)
+
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,380 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/01org/ciao/ciao-controller/internal/metrics"
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
+)
+
+// timedStore is a persistentStore recording the latency of the operations
+// of the store it wraps.
+type timedStore struct {
+	store    persistentStore
+	duration *metrics.HistogramVec
+}
+
+func newTimedStore(store persistentStore) *timedStore {
+	return &timedStore{
+		store: store,
+		duration: metrics.NewHistogramVec("ciao_controller_datastore_query_duration_seconds",
+			"Latency of the datastore queries, by operation.", metrics.DefaultBuckets,
+			"operation"),
+	}
+}
+
+func (s *timedStore) observe(operation string, start time.Time) {
+	s.duration.Observe(time.Since(start).Seconds(), operation)
+}
+
+func (s *timedStore) disconnect() {
+	defer s.observe("disconnect", time.Now())
+	s.store.disconnect()
+}
+
+func (s *timedStore) logEvent(tenantID string, eventType string, message string) error {
+	defer s.observe("logEvent", time.Now())
+	return s.store.logEvent(tenantID, eventType, message)
+}
+
+func (s *timedStore) clearLog() error {
+	defer s.observe("clearLog", time.Now())
+	return s.store.clearLog()
+}
+
+func (s *timedStore) getEventLog() (logEntries []*types.LogEntry, err error) {
+	defer s.observe("getEventLog", time.Now())
+	return s.store.getEventLog()
+}
+
+func (s *timedStore) getCNCIWorkloadID() (id string, err error) {
+	defer s.observe("getCNCIWorkloadID", time.Now())
+	return s.store.getCNCIWorkloadID()
+}
+
+func (s *timedStore) getWorkloadNoCache(id string) (*workload, error) {
+	defer s.observe("getWorkloadNoCache", time.Now())
+	return s.store.getWorkloadNoCache(id)
+}
+
+func (s *timedStore) getWorkloadsNoCache() ([]*workload, error) {
+	defer s.observe("getWorkloadsNoCache", time.Now())
+	return s.store.getWorkloadsNoCache()
+}
+
+func (s *timedStore) addWorkload(wl *workload) error {
+	defer s.observe("addWorkload", time.Now())
+	return s.store.addWorkload(wl)
+}
+
+func (s *timedStore) updateWorkload(wl *workload) error {
+	defer s.observe("updateWorkload", time.Now())
+	return s.store.updateWorkload(wl)
+}
+
+func (s *timedStore) deleteWorkload(ID string) error {
+	defer s.observe("deleteWorkload", time.Now())
+	return s.store.deleteWorkload(ID)
+}
+
+func (s *timedStore) addLimit(tenantID string, resourceID int, limit int) (err error) {
+	defer s.observe("addLimit", time.Now())
+	return s.store.addLimit(tenantID, resourceID, limit)
+}
+
+func (s *timedStore) deleteLimit(tenantID string, resourceID int) (err error) {
+	defer s.observe("deleteLimit", time.Now())
+	return s.store.deleteLimit(tenantID, resourceID)
+}
+
+func (s *timedStore) getDefaultLimits() (map[int]int, error) {
+	defer s.observe("getDefaultLimits", time.Now())
+	return s.store.getDefaultLimits()
+}
+
+func (s *timedStore) setDefaultLimit(resourceID int, limit int) (err error) {
+	defer s.observe("setDefaultLimit", time.Now())
+	return s.store.setDefaultLimit(resourceID, limit)
+}
+
+func (s *timedStore) deleteDefaultLimit(resourceID int) (err error) {
+	defer s.observe("deleteDefaultLimit", time.Now())
+	return s.store.deleteDefaultLimit(resourceID)
+}
+
+func (s *timedStore) getTenantResources(id string) ([]*types.Resource, error) {
+	defer s.observe("getTenantResources", time.Now())
+	return s.store.getTenantResources(id)
+}
+
+func (s *timedStore) addTenant(id string, MAC string) (err error) {
+	defer s.observe("addTenant", time.Now())
+	return s.store.addTenant(id, MAC)
+}
+
+func (s *timedStore) getTenantNoCache(id string) (t *tenant, err error) {
+	defer s.observe("getTenantNoCache", time.Now())
+	return s.store.getTenantNoCache(id)
+}
+
+func (s *timedStore) getTenantsNoCache() ([]*tenant, error) {
+	defer s.observe("getTenantsNoCache", time.Now())
+	return s.store.getTenantsNoCache()
+}
+
+func (s *timedStore) updateTenant(t *tenant) (err error) {
+	defer s.observe("updateTenant", time.Now())
+	return s.store.updateTenant(t)
+}
+
+func (s *timedStore) deleteTenant(id string) (err error) {
+	defer s.observe("deleteTenant", time.Now())
+	return s.store.deleteTenant(id)
+}
+
+func (s *timedStore) releaseTenantIP(tenantID string, subnetInt int, rest int) (err error) {
+	defer s.observe("releaseTenantIP", time.Now())
+	return s.store.releaseTenantIP(tenantID, subnetInt, rest)
+}
+
+func (s *timedStore) claimTenantIP(tenantID string, subnetInt int, rest int) (err error) {
+	defer s.observe("claimTenantIP", time.Now())
+	return s.store.claimTenantIP(tenantID, subnetInt, rest)
+}
+
+func (s *timedStore) getInstances() (instances []*types.Instance, err error) {
+	defer s.observe("getInstances", time.Now())
+	return s.store.getInstances()
+}
+
+func (s *timedStore) addInstance(instance *types.Instance) (err error) {
+	defer s.observe("addInstance", time.Now())
+	return s.store.addInstance(instance)
+}
+
+func (s *timedStore) removeInstance(instanceID string) (err error) {
+	defer s.observe("removeInstance", time.Now())
+	return s.store.removeInstance(instanceID)
+}
+
+func (s *timedStore) updateInstanceWorkload(instanceID string, workloadID string, usage map[string]int) (err error) {
+	defer s.observe("updateInstanceWorkload", time.Now())
+	return s.store.updateInstanceWorkload(instanceID, workloadID, usage)
+}
+
+func (s *timedStore) addNodeStatDB(stat payloads.Stat) (err error) {
+	defer s.observe("addNodeStatDB", time.Now())
+	return s.store.addNodeStatDB(stat)
+}
+
+func (s *timedStore) getNodeSummary() (Summary []*types.NodeSummary, err error) {
+	defer s.observe("getNodeSummary", time.Now())
+	return s.store.getNodeSummary()
+}
+
+func (s *timedStore) addInstanceStatsDB(stats []payloads.InstanceStat, nodeID string) (err error) {
+	defer s.observe("addInstanceStatsDB", time.Now())
+	return s.store.addInstanceStatsDB(stats, nodeID)
+}
+
+func (s *timedStore) addFrameStat(stat payloads.FrameTrace) (err error) {
+	defer s.observe("addFrameStat", time.Now())
+	return s.store.addFrameStat(stat)
+}
+
+func (s *timedStore) getBatchFrameSummary() (stats []types.BatchFrameSummary, err error) {
+	defer s.observe("getBatchFrameSummary", time.Now())
+	return s.store.getBatchFrameSummary()
+}
+
+func (s *timedStore) getBatchFrameStatistics(label string) (stats []types.BatchFrameStat, err error) {
+	defer s.observe("getBatchFrameStatistics", time.Now())
+	return s.store.getBatchFrameStatistics(label)
+}
+
+func (s *timedStore) getWorkloadStorage(ID string) (*types.StorageResource, error) {
+	defer s.observe("getWorkloadStorage", time.Now())
+	return s.store.getWorkloadStorage(ID)
+}
+
+func (s *timedStore) getAllBlockData() (map[string]types.BlockData, error) {
+	defer s.observe("getAllBlockData", time.Now())
+	return s.store.getAllBlockData()
+}
+
+func (s *timedStore) createBlockData(data types.BlockData) error {
+	defer s.observe("createBlockData", time.Now())
+	return s.store.createBlockData(data)
+}
+
+func (s *timedStore) updateBlockData(data types.BlockData) error {
+	defer s.observe("updateBlockData", time.Now())
+	return s.store.updateBlockData(data)
+}
+
+func (s *timedStore) deleteBlockData(ID string) error {
+	defer s.observe("deleteBlockData", time.Now())
+	return s.store.deleteBlockData(ID)
+}
+
+func (s *timedStore) getTenantDevices(tenantID string) (map[string]types.BlockData, error) {
+	defer s.observe("getTenantDevices", time.Now())
+	return s.store.getTenantDevices(tenantID)
+}
+
+func (s *timedStore) createStorageAttachment(a types.StorageAttachment) error {
+	defer s.observe("createStorageAttachment", time.Now())
+	return s.store.createStorageAttachment(a)
+}
+
+func (s *timedStore) getAllStorageAttachments() (map[string]types.StorageAttachment, error) {
+	defer s.observe("getAllStorageAttachments", time.Now())
+	return s.store.getAllStorageAttachments()
+}
+
+func (s *timedStore) deleteStorageAttachment(ID string) error {
+	defer s.observe("deleteStorageAttachment", time.Now())
+	return s.store.deleteStorageAttachment(ID)
+}
+
+func (s *timedStore) getAllFloatingIPs() (map[string]types.FloatingIP, error) {
+	defer s.observe("getAllFloatingIPs", time.Now())
+	return s.store.getAllFloatingIPs()
+}
+
+func (s *timedStore) createFloatingIP(ip types.FloatingIP) error {
+	defer s.observe("createFloatingIP", time.Now())
+	return s.store.createFloatingIP(ip)
+}
+
+func (s *timedStore) updateFloatingIP(ip types.FloatingIP) error {
+	defer s.observe("updateFloatingIP", time.Now())
+	return s.store.updateFloatingIP(ip)
+}
+
+func (s *timedStore) deleteFloatingIP(ID string) error {
+	defer s.observe("deleteFloatingIP", time.Now())
+	return s.store.deleteFloatingIP(ID)
+}
+
+func (s *timedStore) getAllSecurityGroups() (map[string]types.SecurityGroup, error) {
+	defer s.observe("getAllSecurityGroups", time.Now())
+	return s.store.getAllSecurityGroups()
+}
+
+func (s *timedStore) createSecurityGroup(g types.SecurityGroup) error {
+	defer s.observe("createSecurityGroup", time.Now())
+	return s.store.createSecurityGroup(g)
+}
+
+func (s *timedStore) deleteSecurityGroup(ID string) error {
+	defer s.observe("deleteSecurityGroup", time.Now())
+	return s.store.deleteSecurityGroup(ID)
+}
+
+func (s *timedStore) createSecurityGroupRule(r types.SecurityGroupRule) error {
+	defer s.observe("createSecurityGroupRule", time.Now())
+	return s.store.createSecurityGroupRule(r)
+}
+
+func (s *timedStore) deleteSecurityGroupRule(ID string) error {
+	defer s.observe("deleteSecurityGroupRule", time.Now())
+	return s.store.deleteSecurityGroupRule(ID)
+}
+
+func (s *timedStore) getAllInstanceSecurityGroups() (map[string][]string, error) {
+	defer s.observe("getAllInstanceSecurityGroups", time.Now())
+	return s.store.getAllInstanceSecurityGroups()
+}
+
+func (s *timedStore) addInstanceSecurityGroup(instanceID string, groupID string) error {
+	defer s.observe("addInstanceSecurityGroup", time.Now())
+	return s.store.addInstanceSecurityGroup(instanceID, groupID)
+}
+
+func (s *timedStore) removeInstanceSecurityGroup(instanceID string, groupID string) error {
+	defer s.observe("removeInstanceSecurityGroup", time.Now())
+	return s.store.removeInstanceSecurityGroup(instanceID, groupID)
+}
+
+func (s *timedStore) getAllKeyPairs() ([]types.KeyPair, error) {
+	defer s.observe("getAllKeyPairs", time.Now())
+	return s.store.getAllKeyPairs()
+}
+
+func (s *timedStore) createKeyPair(k types.KeyPair) error {
+	defer s.observe("createKeyPair", time.Now())
+	return s.store.createKeyPair(k)
+}
+
+func (s *timedStore) deleteKeyPair(tenantID string, name string) error {
+	defer s.observe("deleteKeyPair", time.Now())
+	return s.store.deleteKeyPair(tenantID, name)
+}
+
+func (s *timedStore) getAllImages() ([]types.Image, error) {
+	defer s.observe("getAllImages", time.Now())
+	return s.store.getAllImages()
+}
+
+func (s *timedStore) updateImage(i types.Image) error {
+	defer s.observe("updateImage", time.Now())
+	return s.store.updateImage(i)
+}
+
+func (s *timedStore) deleteImage(ID string) error {
+	defer s.observe("deleteImage", time.Now())
+	return s.store.deleteImage(ID)
+}
+
+func (s *timedStore) addInstanceTransitions(transitions []types.InstanceTransition) error {
+	defer s.observe("addInstanceTransitions", time.Now())
+	return s.store.addInstanceTransitions(transitions)
+}
+
+func (s *timedStore) getInstanceHistory(instanceID string) ([]types.InstanceTransition, error) {
+	defer s.observe("getInstanceHistory", time.Now())
+	return s.store.getInstanceHistory(instanceID)
+}
+
+func (s *timedStore) getAllWebhooks() ([]types.Webhook, error) {
+	defer s.observe("getAllWebhooks", time.Now())
+	return s.store.getAllWebhooks()
+}
+
+func (s *timedStore) createWebhook(w types.Webhook) error {
+	defer s.observe("createWebhook", time.Now())
+	return s.store.createWebhook(w)
+}
+
+func (s *timedStore) deleteWebhook(ID string) error {
+	defer s.observe("deleteWebhook", time.Now())
+	return s.store.deleteWebhook(ID)
+}
+
+func (s *timedStore) addWebhookDelivery(d types.WebhookDelivery) error {
+	defer s.observe("addWebhookDelivery", time.Now())
+	return s.store.addWebhookDelivery(d)
+}
+
+func (s *timedStore) getWebhookDeliveries(webhookID string, limit int, offset int) ([]types.WebhookDelivery, error) {
+	defer s.observe("getWebhookDeliveries", time.Now())
+	return s.store.getWebhookDeliveries(webhookID, limit, offset)
+}
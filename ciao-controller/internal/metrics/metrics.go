@@ -0,0 +1,289 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+// Package metrics implements the counters, gauges and histograms of the
+// ciao controller, and their exposition in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4"
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of the
+// latency histograms.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric is a family of metrics sharing a name.
+type Metric interface {
+	// Write writes the metrics in the Prometheus text format.
+	Write(w io.Writer) error
+}
+
+// WriteAll writes a list of metrics in the Prometheus text format.
+func WriteAll(w io.Writer, metrics ...Metric) error {
+	for _, m := range metrics {
+		err := m.Write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// family holds the series of a metric, one per combination of label
+// values.
+type family struct {
+	sync.Mutex
+
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]interface{}
+}
+
+func newFamily(name string, help string, kind string, labels []string) family {
+	return family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]interface{}),
+	}
+}
+
+// key returns the key of the series with the given label values.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d",
+			f.name, len(f.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the label values of a series, with an extra label
+// if name is not empty.
+func (f *family) labelPairs(key string, name string, value string) string {
+	var pairs []string
+
+	if len(f.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labels[i], labelEscaper.Replace(v)))
+		}
+	}
+
+	if name != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// write writes the header of the family, and calls sample for each of its
+// series, sorted by label values.
+func (f *family) write(w io.Writer, sample func(key string, s interface{}) error) error {
+	f.Lock()
+	defer f.Unlock()
+
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+		f.name, helpEscaper.Replace(f.help), f.name, f.kind)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err = sample(k, f.series[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CounterVec is a family of counters.
+type CounterVec struct {
+	family
+}
+
+// NewCounterVec creates a family of counters partitioned by the given
+// labels.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{newFamily(name, help, "counter", labels)}
+}
+
+// Add adds a positive value to the counter with the given label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	k := c.key(values)
+	current, _ := c.series[k].(float64)
+	c.series[k] = current + v
+}
+
+// Inc increments the counter with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Write writes the counters in the Prometheus text format.
+func (c *CounterVec) Write(w io.Writer) error {
+	return c.write(w, func(key string, s interface{}) error {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key, "", ""),
+			formatValue(s.(float64)))
+		return err
+	})
+}
+
+// GaugeVec is a family of gauges.
+type GaugeVec struct {
+	family
+}
+
+// NewGaugeVec creates a family of gauges partitioned by the given labels.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newFamily(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.series[g.key(values)] = v
+}
+
+// Add adds a value to the gauge with the given label values.
+func (g *GaugeVec) Add(v float64, values ...string) {
+	g.Lock()
+	defer g.Unlock()
+
+	k := g.key(values)
+	current, _ := g.series[k].(float64)
+	g.series[k] = current + v
+}
+
+// Write writes the gauges in the Prometheus text format.
+func (g *GaugeVec) Write(w io.Writer) error {
+	return g.write(w, func(key string, s interface{}) error {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(key, "", ""),
+			formatValue(s.(float64)))
+		return err
+	})
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a family of histograms.
+type HistogramVec struct {
+	family
+	buckets []float64
+}
+
+// NewHistogramVec creates a family of histograms partitioned by the given
+// labels, whose buckets have the given upper bounds, in increasing order.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: buckets,
+	}
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.Lock()
+	defer h.Unlock()
+
+	k := h.key(values)
+	s, ok := h.series[k].(*histogram)
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Write writes the histograms in the Prometheus text format.
+func (h *HistogramVec) Write(w io.Writer) error {
+	return h.write(w, func(key string, s interface{}) error {
+		hist := s.(*histogram)
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			_, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				h.labelPairs(key, "le", formatValue(bound)), cumulative)
+			if err != nil {
+				return err
+			}
+		}
+
+		labels := h.labelPairs(key, "", "")
+		_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, h.labelPairs(key, "le", "+Inf"), hist.count,
+			h.name, labels, formatValue(hist.sum),
+			h.name, labels, hist.count)
+		return err
+	})
+}
@@ -0,0 +1,97 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("requests_total", "Requests.\nAll of them.", "route", "code")
+	c.Inc("/b", "200")
+	c.Inc("/a", "404")
+	c.Add(2, "/b", "200")
+
+	var b bytes.Buffer
+	err := c.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP requests_total Requests.\nAll of them.
+# TYPE requests_total counter
+requests_total{route="/a",code="404"} 1
+requests_total{route="/b",code="200"} 3
+`
+	if b.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := NewGaugeVec("instances", "Instances.", "state")
+	g.Set(2, `a "quoted\" state`)
+	g.Add(1.5, "running")
+
+	unlabelled := NewGaugeVec("up", "Up.")
+	unlabelled.Set(1)
+
+	var b bytes.Buffer
+	err := WriteAll(&b, g, unlabelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP instances Instances.
+# TYPE instances gauge
+instances{state="a \"quoted\\\" state"} 2
+instances{state="running"} 1.5
+# HELP up Up.
+# TYPE up gauge
+up 1
+`
+	if b.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("latency_seconds", "Latency.", []float64{0.5, 1}, "op")
+	h.Observe(0.25, "get")
+	h.Observe(0.5, "get")
+	h.Observe(0.75, "get")
+	h.Observe(3, "get")
+
+	var b bytes.Buffer
+	err := h.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.5"} 2
+latency_seconds_bucket{op="get",le="1"} 3
+latency_seconds_bucket{op="get",le="+Inf"} 4
+latency_seconds_sum{op="get"} 4.5
+latency_seconds_count{op="get"} 4
+`
+	if b.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
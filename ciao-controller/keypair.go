@@ -0,0 +1,340 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
+	"github.com/gorilla/mux"
+)
+
+// keyPairBits is the size of the RSA keys generated by the controller.
+const keyPairBits = 2048
+
+var sshKeyTypes = map[string]bool{
+	"ssh-rsa":             true,
+	"ssh-dss":             true,
+	"ssh-ed25519":         true,
+	"ecdsa-sha2-nistp256": true,
+	"ecdsa-sha2-nistp384": true,
+	"ecdsa-sha2-nistp521": true,
+}
+
+// sshString encodes a string or byte slice in the SSH wire format.
+func sshString(b []byte) []byte {
+	buf := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	return append(buf, b...)
+}
+
+// sshMPInt encodes a positive big integer in the SSH wire format.
+func sshMPInt(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return sshString(b)
+}
+
+// sshFingerprint returns the MD5 fingerprint of a public key blob, as
+// printed by ssh-keygen -l -E md5.
+func sshFingerprint(blob []byte) string {
+	sum := md5.Sum(blob)
+
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+
+	return strings.Join(hex, ":")
+}
+
+// parseSSHPublicKey checks that a public key is in the OpenSSH
+// authorized_keys format and returns it on a single line together with
+// its fingerprint.
+func parseSSHPublicKey(key string) (string, string, error) {
+	fields := strings.Fields(key)
+	if len(fields) < 2 || !sshKeyTypes[fields[0]] {
+		return "", "", errors.New("Unsupported public key format")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid public key: %v", err)
+	}
+
+	keyType := sshString([]byte(fields[0]))
+	if !bytes.HasPrefix(blob, keyType) || len(blob) == len(keyType) {
+		return "", "", errors.New("Invalid public key: key type mismatch")
+	}
+
+	return strings.Join(fields, " "), sshFingerprint(blob), nil
+}
+
+// generateKeyPair generates a RSA keypair. It returns the public key in
+// the OpenSSH authorized_keys format and the PEM encoded private key.
+func generateKeyPair(comment string) (string, string, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, keyPairBits)
+	if err != nil {
+		return "", "", err
+	}
+
+	var blob []byte
+	blob = append(blob, sshString([]byte("ssh-rsa"))...)
+	blob = append(blob, sshMPInt(big.NewInt(int64(priv.E)))...)
+	blob = append(blob, sshMPInt(priv.N)...)
+
+	public := "ssh-rsa " + base64.StdEncoding.EncodeToString(blob)
+	if comment != "" {
+		public += " " + comment
+	}
+
+	private := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	})
+
+	return public, string(private), nil
+}
+
+// injectSSHKey adds a public key to the authorized keys of all the users
+// of a cloud-init configuration, and of its default user.
+func injectSSHKey(baseConfig string, key string) (string, error) {
+	config, err := parseCloudConfig(baseConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if users, ok := config["users"].([]interface{}); ok {
+		for _, u := range users {
+			user, ok := u.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+
+			keys, _ := user["ssh-authorized-keys"].([]interface{})
+			user["ssh-authorized-keys"] = append(keys, key)
+		}
+	}
+
+	keys, _ := config["ssh_authorized_keys"].([]interface{})
+	config["ssh_authorized_keys"] = append(keys, key)
+
+	return marshalCloudConfig(config)
+}
+
+func keyPairToPayload(key types.KeyPair) payloads.KeyPair {
+	return payloads.KeyPair{
+		Name:        key.Name,
+		PublicKey:   key.PublicKey,
+		Fingerprint: key.Fingerprint,
+	}
+}
+
+func keyPairErrorCode(err error) int {
+	switch err {
+	case datastore.ErrNoKeyPair:
+		return http.StatusNotFound
+	case datastore.ErrKeyPairExists:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func returnKeyPair(w http.ResponseWriter, key payloads.KeyPair) {
+	b, err := json.Marshal(payloads.ComputeKeyPair{KeyPair: key})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func listKeyPairs(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	keys := payloads.NewComputeKeyPairs()
+
+	for _, k := range context.ds.GetKeyPairs(tenant) {
+		keys.KeyPairs = append(keys.KeyPairs, payloads.ComputeKeyPair{
+			KeyPair: keyPairToPayload(k),
+		})
+	}
+
+	b, err := json.Marshal(keys)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func createKeyPair(w http.ResponseWriter, r *http.Request, context *controller) {
+	var req payloads.ComputeCreateKeyPair
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	name := req.KeyPair.Name
+	if name == "" || strings.ContainsAny(name, "/\n") {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid keypair name")
+		return
+	}
+
+	_, err = context.ds.GetKeyPair(tenant, name)
+	if err == nil {
+		returnErrorCode(w, http.StatusConflict, "%v", datastore.ErrKeyPairExists)
+		return
+	}
+
+	var privateKey string
+	publicKey := req.KeyPair.PublicKey
+
+	if publicKey == "" {
+		publicKey, privateKey, err = generateKeyPair(name)
+		if err != nil {
+			returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+
+	publicKey, fingerprint, err := parseSSHPublicKey(publicKey)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	key := types.KeyPair{
+		TenantID:    tenant,
+		Name:        name,
+		PublicKey:   publicKey,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	err = context.deletions.create(tenant)
+	if err != nil {
+		returnErrorCode(w, http.StatusConflict, "%v", err)
+		return
+	}
+	defer context.deletions.created(tenant)
+
+	err = context.ds.AddKeyPair(key)
+	if err != nil {
+		returnErrorCode(w, keyPairErrorCode(err), "%v", err)
+		return
+	}
+
+	k := keyPairToPayload(key)
+	k.PrivateKey = privateKey
+
+	returnKeyPair(w, k)
+}
+
+func showKeyPair(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	name := vars["keypair"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	key, err := context.ds.GetKeyPair(tenant, name)
+	if err != nil {
+		returnErrorCode(w, keyPairErrorCode(err), "%v", err)
+		return
+	}
+
+	returnKeyPair(w, keyPairToPayload(key))
+}
+
+func deleteKeyPair(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	name := vars["keypair"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	err := context.ds.DeleteKeyPair(tenant, name)
+	if err != nil {
+		returnErrorCode(w, keyPairErrorCode(err), "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
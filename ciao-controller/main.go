@@ -21,6 +21,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
 	image "github.com/01org/ciao/ciao-image/client"
@@ -34,10 +35,14 @@ import (
 type controller struct {
 	storage.BlockDriver
 
-	client *ssntpClient
-	ds     *datastore.Datastore
-	id     *identity
-	image  image.Client
+	client     *ssntpClient
+	ds         *datastore.Datastore
+	id         *identity
+	image      image.Client
+	consoles   consoleTokens
+	recoveries recoveries
+	tasks      tasks
+	deletions  tenantDeletions
 }
 
 var singleMachine = flag.Bool("single", false, "Enable single machine test")
@@ -48,6 +53,7 @@ var identityURL = "identity:35357"
 var serviceUser = "csr"
 var servicePassword = ""
 var computeAPIPort = 8774
+var launcherConsolePort = 8890
 var httpsCAcert = "/etc/pki/ciao/ciao-controller-cacert.pem"
 var httpsKey = "/etc/pki/ciao/ciao-controller-key.pem"
 var tablesInitPath = flag.String("tables_init_path", "./tables", "path to csv files")
@@ -62,6 +68,11 @@ var imagesPath = flag.String("images_path", "/var/lib/ciao/images", "path to cia
 var keyringPath = flag.String("ceph_keyring", "", "path to ceph client keyring")
 var cephID = flag.String("ceph_id", "", "ceph client id")
 
+var reconcilePeriod = flag.Duration("reconcile_period", 5*time.Minute, "period of the datastore reconciliation with the node statistics, 0 to disable")
+var deleteOrphans = flag.Bool("delete_orphans", false, "delete the instances reported by nodes but unknown to the controller")
+var metricsTokenFile = flag.String("metrics_token_file", "", "file holding the bearer token Prometheus scrapes /metrics with, besides the admin tokens")
+var recoveryGrace = flag.Duration("recovery_grace", 5*time.Minute, "how long a disconnected node has to reconnect before its instances are rescheduled")
+
 func init() {
 	flag.Parse()
 
@@ -90,6 +101,14 @@ func main() {
 
 	context.image = image.Client{MountPoint: *imagesPath}
 
+	if *metricsTokenFile != "" {
+		metricsToken, err = readMetricsToken(*metricsTokenFile)
+		if err != nil {
+			glog.Fatalf("Unable to read metrics token: %v", err)
+			return
+		}
+	}
+
 	dsConfig := datastore.Config{
 		PersistentURI:     *persistentDatastoreLocation,
 		TransientURI:      *transientDatastoreLocation,
@@ -126,6 +145,7 @@ func main() {
 	computeAPIPort = clusterConfig.Configure.Controller.ComputePort
 	httpsCAcert = clusterConfig.Configure.Controller.HTTPSCACert
 	httpsKey = clusterConfig.Configure.Controller.HTTPSKey
+	launcherConsolePort = clusterConfig.Configure.Launcher.ConsolePort
 	identityURL = clusterConfig.Configure.IdentityService.URL
 	serviceUser = clusterConfig.Configure.Controller.IdentityUser
 	servicePassword = clusterConfig.Configure.Controller.IdentityPassword
@@ -136,6 +156,12 @@ func main() {
 		*cephID = clusterConfig.Configure.Storage.CephID
 	}
 
+	err = context.ds.SetFloatingIPPool(clusterConfig.Configure.Controller.FloatingIPPool)
+	if err != nil {
+		glog.Fatalf("Invalid floating IP pool: %v", err)
+		return
+	}
+
 	osprepare.InstallDeps(controllerDeps)
 
 	if *singleMachine {
@@ -183,6 +209,14 @@ func main() {
 	wg.Add(1)
 	go context.startVolumeService()
 
+	if *reconcilePeriod > 0 {
+		wg.Add(1)
+		go context.startReconciler(*reconcilePeriod, *deleteOrphans)
+	}
+
+	wg.Add(1)
+	go context.startWebhookDispatcher()
+
 	wg.Wait()
 	context.ds.Exit()
 	context.client.Disconnect()
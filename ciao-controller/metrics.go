@@ -0,0 +1,220 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/01org/ciao/ciao-controller/internal/metrics"
+	"github.com/gorilla/mux"
+)
+
+var httpRequests = metrics.NewCounterVec("ciao_controller_http_requests_total",
+	"HTTP requests served by the compute API, by method, route and status code.",
+	"method", "route", "code")
+
+var httpRequestDuration = metrics.NewHistogramVec("ciao_controller_http_request_duration_seconds",
+	"Latency of the HTTP requests served by the compute API, by method and route.",
+	metrics.DefaultBuckets, "method", "route")
+
+var ssntpFrames = metrics.NewCounterVec("ciao_controller_ssntp_frames_received_total",
+	"SSNTP frames received by the controller, by frame type and name.",
+	"type", "name")
+
+// statusRecorder records the status code of a response.  The event streams
+// need to flush it, and the consoles to hijack its connection.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Connection cannot be hijacked")
+	}
+
+	rec.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// instrumentAPI counts the requests served by the router and measures their
+// latency, labelled by the path template of their route.
+func instrumentAPI(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+
+		var match mux.RouteMatch
+		if router.Match(r, &match) {
+			template, err := match.Route.GetPathTemplate()
+			if err == nil {
+				route = template
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		router.ServeHTTP(rec, r)
+
+		httpRequests.Inc(r.Method, route, strconv.Itoa(rec.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// clusterMetrics returns gauges of the current state of the cluster: its
+// instances, the capacity of its nodes and the quota usage of its tenants.
+func clusterMetrics(context *controller) ([]metrics.Metric, error) {
+	instances := metrics.NewGaugeVec("ciao_controller_instances",
+		"Instances, by tenant and state.", "tenant", "state")
+
+	all, err := context.ds.GetAllInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range all {
+		instances.Add(1, i.TenantID, i.State)
+	}
+
+	memTotal := metrics.NewGaugeVec("ciao_controller_node_memory_total_mb",
+		"Memory of the nodes, in MB.", "node")
+	memAvailable := metrics.NewGaugeVec("ciao_controller_node_memory_available_mb",
+		"Memory available on the nodes, in MB.", "node")
+	diskTotal := metrics.NewGaugeVec("ciao_controller_node_disk_total_mb",
+		"Disk space of the nodes, in MB.", "node")
+	diskAvailable := metrics.NewGaugeVec("ciao_controller_node_disk_available_mb",
+		"Disk space available on the nodes, in MB.", "node")
+	load := metrics.NewGaugeVec("ciao_controller_node_load",
+		"Load of the nodes.", "node")
+	cpus := metrics.NewGaugeVec("ciao_controller_node_online_cpus",
+		"Online CPUs of the nodes.", "node")
+	nodeInstances := metrics.NewGaugeVec("ciao_controller_node_instances",
+		"Instances reported by the nodes.", "node")
+
+	for _, n := range context.ds.GetNodeLastStats().Nodes {
+		memTotal.Set(float64(n.MemTotal), n.ID)
+		memAvailable.Set(float64(n.MemAvailable), n.ID)
+		diskTotal.Set(float64(n.DiskTotal), n.ID)
+		diskAvailable.Set(float64(n.DiskAvailable), n.ID)
+		load.Set(float64(n.Load), n.ID)
+		cpus.Set(float64(n.OnlineCPUs), n.ID)
+		nodeInstances.Set(float64(n.TotalInstances), n.ID)
+	}
+
+	usage := metrics.NewGaugeVec("ciao_controller_tenant_quota_usage",
+		"Resources used by the tenants, by resource.", "tenant", "resource")
+	limit := metrics.NewGaugeVec("ciao_controller_tenant_quota_limit",
+		"Resource limits of the tenants, -1 if unlimited.", "tenant", "resource")
+
+	tenants, err := context.ds.GetAllTenants()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tenants {
+		for _, r := range t.Resources {
+			usage.Set(float64(r.Usage), t.ID, r.Rname)
+			limit.Set(float64(r.Limit), t.ID, r.Rname)
+		}
+	}
+
+	return []metrics.Metric{instances, memTotal, memAvailable, diskTotal,
+		diskAvailable, load, cpus, nodeInstances, usage, limit}, nil
+}
+
+// metricsToken is the bearer token, read from the -metrics_token_file file,
+// which Prometheus scrapes /metrics with.  The metrics expose the tenants
+// and their usage, so the administrators can scrape them too, but not the
+// tenants.
+var metricsToken string
+
+func readMetricsToken(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", errors.New("Empty metrics token")
+	}
+
+	return token, nil
+}
+
+// metricsAuthorized tells whether a request carries the metrics bearer
+// token, or an admin token.
+func metricsAuthorized(context *controller, r *http.Request) bool {
+	if metricsToken != "" {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+metricsToken)) == 1 {
+			return true
+		}
+	}
+
+	return adminToken(context, r)
+}
+
+// showMetrics exposes the metrics of the controller in the Prometheus text
+// format, to the bearer of the metrics token or of an admin token.
+func showMetrics(w http.ResponseWriter, r *http.Request, context *controller) {
+	if !metricsAuthorized(context, r) {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	cluster, err := clusterMetrics(context)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	var b bytes.Buffer
+
+	all := append([]metrics.Metric{httpRequests, httpRequestDuration, ssntpFrames,
+		context.ds.QueryMetrics()}, cluster...)
+
+	err = metrics.WriteAll(&b, all...)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Write(b.Bytes())
+}
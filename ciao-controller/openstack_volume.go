@@ -24,18 +24,75 @@ import (
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/openstack/block"
 	osIdentity "github.com/01org/ciao/openstack/identity"
+	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 )
 
 // Implement the Block Service interface
 func (c *controller) GetAbsoluteLimits(tenant string) (block.AbsoluteLimits, error) {
-	return block.AbsoluteLimits{}, nil
+	// only the number of volumes is limited.
+	limits := block.AbsoluteLimits{
+		MaxTotalBackups:         -1,
+		MaxTotalVolumeGigabytes: -1,
+		MaxTotalSnapshots:       -1,
+		MaxTotalBackupGigabytes: -1,
+		MaxTotalVolumes:         -1,
+	}
+
+	if limit, ok := c.ds.GetDefaultLimits()[volumes]; ok {
+		limits.MaxTotalVolumes = limit
+	}
+
+	t, err := c.ds.GetTenant(tenant)
+	if err != nil || t == nil {
+		return limits, err
+	}
+
+	for _, r := range t.Resources {
+		if r.Rtype == volumes {
+			limits.MaxTotalVolumes = r.Limit
+			limits.TotalVolumesUsed = r.Usage
+		}
+	}
+
+	devices, err := c.ds.GetBlockDevices(tenant)
+	if err != nil {
+		return limits, err
+	}
+
+	for _, d := range devices {
+		limits.TotalGigabytesUsed += d.Size
+	}
+
+	return limits, nil
+}
+
+// volumeAllowed checks whether a tenant may create one more volume.
+func (c *controller) volumeAllowed(tenant string) (bool, error) {
+	t, err := c.ds.GetTenant(tenant)
+	if err != nil || t == nil {
+		return false, err
+	}
+
+	for _, r := range t.Resources {
+		if r.Rtype == volumes && r.OverLimit(1) {
+			return false, nil
+		}
+	}
+
+	return true, nil
 }
 
 // CreateVolume will create a new block device and store it in the datastore.
 // TBD: we need a better way to do bootable.
 func (c *controller) CreateVolume(tenant string, req block.RequestedVolume) (block.Volume, error) {
+	// the block API has no error for a tenant being deleted.
+	err := c.deletions.create(tenant)
+	if err != nil {
+		return block.Volume{}, block.ErrTenantNotFound
+	}
+	defer c.deletions.created(tenant)
 
 	t, err := c.ds.GetTenant(tenant)
 	if err != nil {
@@ -57,7 +114,15 @@ func (c *controller) CreateVolume(tenant string, req block.RequestedVolume) (blo
 		}
 	}
 
-	// no limits checking for now.
+	allowed, err := c.volumeAllowed(tenant)
+	if err != nil {
+		return block.Volume{}, err
+	}
+
+	if !allowed {
+		return block.Volume{}, block.ErrQuota
+	}
+
 	bd, err := c.CreateBlockDevice(req.ImageRef, req.Size)
 	if err != nil {
 		return block.Volume{}, err
@@ -174,6 +239,9 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 		return err
 	}
 
+	task := c.tasks.add(taskAttachVolume, tenant, []string{volume})
+	c.tasks.sent(task, ssntp.AttachVolume.String(), volume)
+
 	return nil
 }
 
@@ -0,0 +1,55 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// How long an instance may be missing from the statistics of its node, or
+// unknown to the datastore, before the reconciler corrects the datastore.
+// Nodes send their statistics every 30 seconds.
+const reconcileGrace = 2 * time.Minute
+
+// reconcile corrects the datastore with the instances and volumes last
+// reported by the nodes, and deletes the instances unknown to the datastore
+// if deleteOrphans is set.
+func (c *controller) reconcile(deleteOrphans bool) {
+	orphans := c.ds.Reconcile(reconcileGrace)
+	if !deleteOrphans {
+		return
+	}
+
+	for _, o := range orphans {
+		glog.Infof("Deleting unknown instance %s from node %s", o.ID, o.NodeID)
+
+		err := c.client.DeleteInstance(o.ID, o.NodeID)
+		if err != nil {
+			glog.Warningf("Unable to delete instance %s: %v", o.ID, err)
+		}
+	}
+}
+
+func (c *controller) startReconciler(period time.Duration, deleteOrphans bool) {
+	glog.Infof("Reconciling the datastore every %v", period)
+
+	for range time.Tick(period) {
+		c.reconcile(deleteOrphans)
+	}
+}
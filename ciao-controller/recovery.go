@@ -0,0 +1,273 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// recoveries tracks the instances of the nodes which disconnected, and
+// which are to be recovered according to their recovery policy.
+type recoveries struct {
+	sync.Mutex
+
+	// timers end the grace period of the disconnected nodes.
+	timers map[string]*time.Timer
+
+	// reschedule holds, per node, the instances to launch on other
+	// nodes if their node does not reconnect within the grace period.
+	reschedule map[string][]string
+
+	// restart holds, per node, the instances to restart if their
+	// node reports them exited once it reconnects.
+	restart map[string]map[string]bool
+}
+
+// nodeDisconnected marks the instances of a node as unknown, and starts
+// the grace period after which the instances whose recovery policy is
+// reschedule-elsewhere are launched on other nodes.
+func (c *controller) nodeDisconnected(nodeID string) {
+	instances, err := c.ds.NodeDisconnected(nodeID)
+	if err != nil {
+		glog.Warningf("Unable to remove node %s: %v", nodeID, err)
+	}
+
+	var reschedule []string
+	restart := make(map[string]bool)
+
+	for _, i := range instances {
+		switch i.RecoveryPolicy {
+		case types.RecoveryRestart:
+			if i.State == payloads.Running {
+				restart[i.ID] = true
+			}
+		case types.RecoveryReschedule:
+			reschedule = append(reschedule, i.ID)
+		}
+	}
+
+	r := &c.recoveries
+	r.Lock()
+	defer r.Unlock()
+
+	if len(restart) > 0 {
+		if r.restart == nil {
+			r.restart = make(map[string]map[string]bool)
+		}
+		r.restart[nodeID] = restart
+	}
+
+	if len(reschedule) == 0 {
+		return
+	}
+
+	if r.timers == nil {
+		r.timers = make(map[string]*time.Timer)
+		r.reschedule = make(map[string][]string)
+	}
+
+	if t, ok := r.timers[nodeID]; ok {
+		t.Stop()
+	}
+
+	glog.Infof("Rescheduling %d instances of node %s in %v", len(reschedule), nodeID, *recoveryGrace)
+
+	r.reschedule[nodeID] = reschedule
+	r.timers[nodeID] = time.AfterFunc(*recoveryGrace, func() {
+		c.recoverNode(nodeID)
+	})
+}
+
+// nodeConnected ends the grace period of a node which reconnected.
+func (c *controller) nodeConnected(nodeID string) {
+	r := &c.recoveries
+	r.Lock()
+	defer r.Unlock()
+
+	t, ok := r.timers[nodeID]
+	if !ok {
+		return
+	}
+
+	glog.Infof("Node %s reconnected, its instances are not rescheduled", nodeID)
+
+	t.Stop()
+	delete(r.timers, nodeID)
+	delete(r.reschedule, nodeID)
+}
+
+// nodeStats restarts the instances a reconnected node reports exited, if
+// their recovery policy is restart-on-reconnect, and deletes the copies of
+// the instances rescheduled from the node.
+func (c *controller) nodeStats(stat payloads.Stat) {
+	var restart []string
+
+	r := &c.recoveries
+	r.Lock()
+	pending := r.restart[stat.NodeUUID]
+	for _, s := range stat.Instances {
+		if !pending[s.InstanceUUID] {
+			continue
+		}
+
+		delete(pending, s.InstanceUUID)
+		if s.State == payloads.Exited {
+			restart = append(restart, s.InstanceUUID)
+		}
+	}
+	if len(pending) == 0 {
+		delete(r.restart, stat.NodeUUID)
+	}
+	r.Unlock()
+
+	for _, id := range restart {
+		glog.Infof("Restarting instance %s on reconnected node %s", id, stat.NodeUUID)
+
+		err := c.client.RestartInstance(id, stat.NodeUUID)
+		if err != nil {
+			glog.Warningf("Unable to restart instance %s: %v", id, err)
+		}
+	}
+
+	for _, id := range c.ds.StaleInstanceCopies(stat.NodeUUID) {
+		glog.Infof("Deleting stale copy of instance %s from node %s", id, stat.NodeUUID)
+
+		err := c.client.DeleteInstance(id, stat.NodeUUID)
+		if err != nil {
+			glog.Warningf("Unable to delete instance %s: %v", id, err)
+		}
+	}
+}
+
+// recoverNode launches the instances of a node which did not reconnect
+// within its grace period on other nodes.
+func (c *controller) recoverNode(nodeID string) {
+	r := &c.recoveries
+	r.Lock()
+	instances := r.reschedule[nodeID]
+	delete(r.reschedule, nodeID)
+	delete(r.timers, nodeID)
+	r.Unlock()
+
+	for _, id := range instances {
+		err := c.rescheduleInstance(id, nodeID)
+		if err != nil {
+			glog.Warningf("Unable to reschedule instance %s: %v", id, err)
+		}
+	}
+}
+
+// rescheduleInstance sends the START command an instance was launched with
+// to the scheduler, which picks another node for the instance.  Only the
+// instances booting from a volume, and the stateless ones, are rescheduled.
+// The requested resources are those of the current workload of the
+// instance, which differs from the one it was launched with once resized.
+func (c *controller) rescheduleInstance(instanceID string, nodeID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return err
+	}
+
+	startConfig, err := startConfigForWorkload(i.StartConfig, wl)
+	if err != nil {
+		return err
+	}
+
+	volume, err := bootVolume(startConfig)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.RescheduleInstance(instanceID, nodeID, volume)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Rescheduling instance %s of node %s", instanceID, nodeID)
+
+	return c.client.StartWorkload(startConfig)
+}
+
+// parseStartConfig returns the START command payload an instance was
+// launched with, and the documents following it in its start
+// configuration.
+func parseStartConfig(startConfig string) (payloads.Start, string, error) {
+	var start payloads.Start
+
+	if startConfig == "" {
+		return start, "", errors.New("Unknown start configuration")
+	}
+
+	docs := strings.SplitN(startConfig, "...\n", 2)
+	err := yaml.Unmarshal([]byte(docs[0]), &start)
+	if err != nil {
+		return start, "", err
+	}
+
+	if len(docs) == 1 {
+		return start, "", nil
+	}
+
+	return start, docs[1], nil
+}
+
+// startConfigForWorkload returns the start configuration of an instance
+// with the resources requested by the START command replaced by the
+// defaults of the workload wl.
+func startConfigForWorkload(startConfig string, wl *types.Workload) (string, error) {
+	start, rest, err := parseStartConfig(startConfig)
+	if err != nil {
+		return "", err
+	}
+
+	start.Start.RequestedResources = wl.Defaults
+
+	y, err := yaml.Marshal(&start)
+	if err != nil {
+		return "", err
+	}
+
+	return "---\n" + string(y) + "...\n" + rest, nil
+}
+
+// bootVolume returns the volume an instance boots from, as given by the
+// START command payload the instance was launched with.
+func bootVolume(startConfig string) (string, error) {
+	start, _, err := parseStartConfig(startConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if !start.Start.Storage.Bootable {
+		return "", nil
+	}
+
+	return start.Start.Storage.ID, nil
+}
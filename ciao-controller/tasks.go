@@ -0,0 +1,360 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/ssntp/uuid"
+)
+
+// taskRetention is how long a finished task can be polled, and taskTimeout
+// how long a task waits for the outcome of its commands before failing.
+const (
+	taskRetention = time.Hour
+	taskTimeout   = 30 * time.Minute
+)
+
+// The operations tracked by tasks.
+const (
+	taskStartInstances = "start_instances"
+	taskDeleteInstance = "delete_instance"
+	taskEvacuateNode   = "evacuate_node"
+	taskAttachVolume   = "attach_volume"
+	taskLaunchCNCI     = "launch_cnci"
+	taskDeleteTenant   = "delete_tenant"
+)
+
+const (
+	frameSent     = "sent"
+	frameReceived = "received"
+)
+
+var errTaskNotFound = errors.New("Task not found")
+
+type sortedTasksByCreation []payloads.Task
+
+func (s sortedTasksByCreation) Len() int      { return len(s) }
+func (s sortedTasksByCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortedTasksByCreation) Less(i, j int) bool {
+	return s[i].Created.Before(s[j].Created)
+}
+
+type task struct {
+	payloads.Task
+
+	// pending holds the resources whose outcome is still unknown.
+	pending map[string]bool
+}
+
+func (t *task) frame(direction string, frame string, resource string, now time.Time) {
+	t.Frames = append(t.Frames, payloads.TaskFrame{
+		Frame:     frame,
+		Direction: direction,
+		Resource:  resource,
+		Timestamp: now,
+	})
+	t.Updated = now
+}
+
+func (t *task) done(resource string, err error, now time.Time) {
+	delete(t.pending, resource)
+	if err != nil {
+		t.Errors = append(t.Errors, fmt.Sprintf("%s: %v", resource, err))
+	}
+
+	if len(t.Resources) > 0 {
+		t.Progress = 100 * (len(t.Resources) - len(t.pending)) / len(t.Resources)
+	}
+	if len(t.pending) == 0 {
+		if len(t.Errors) == 0 {
+			t.Status = payloads.TaskCompleted
+		} else {
+			t.Status = payloads.TaskFailed
+		}
+	}
+	t.Updated = now
+}
+
+func (t *task) copy() payloads.Task {
+	c := t.Task
+	c.Resources = append([]string{}, t.Resources...)
+	c.Errors = append([]string(nil), t.Errors...)
+	c.Frames = append([]payloads.TaskFrame{}, t.Frames...)
+	return c
+}
+
+// tasks tracks the multi-step operations of the controller, for the clients
+// to poll their progress at /v2.1/tasks/{task}.  A task waits for the outcome
+// of each of its resources, the instances, volumes, nodes or tenants its
+// SSNTP commands were sent for.
+type tasks struct {
+	sync.Mutex
+	tasks map[string]*task
+}
+
+func (ts *tasks) add(operation string, tenantID string, resources []string) string {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if ts.tasks == nil {
+		ts.tasks = make(map[string]*task)
+	}
+
+	now := time.Now()
+	ts.expire(now)
+
+	t := &task{
+		Task: payloads.Task{
+			ID:        uuid.Generate().String(),
+			Operation: operation,
+			TenantID:  tenantID,
+			Status:    payloads.TaskRunning,
+			Resources: resources,
+			Frames:    []payloads.TaskFrame{},
+			Created:   now,
+			Updated:   now,
+		},
+		pending: make(map[string]bool),
+	}
+	for _, resource := range resources {
+		t.pending[resource] = true
+	}
+
+	// there is nothing to wait for.
+	if len(resources) == 0 {
+		t.Progress = 100
+		t.Status = payloads.TaskCompleted
+	}
+
+	ts.tasks[t.ID] = t
+
+	return t.ID
+}
+
+// expire fails the tasks which timed out, and forgets the tasks which
+// ended more than taskRetention ago.
+func (ts *tasks) expire(now time.Time) {
+	for id, t := range ts.tasks {
+		if t.Status != payloads.TaskRunning {
+			if now.Sub(t.Updated) > taskRetention {
+				delete(ts.tasks, id)
+			}
+			continue
+		}
+
+		if now.Sub(t.Created) > taskTimeout {
+			for resource := range t.pending {
+				t.done(resource, errors.New("Timed out"), now)
+			}
+		}
+	}
+}
+
+// sent records a frame sent on behalf of a task.
+func (ts *tasks) sent(id string, frame string, resource string) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	t, ok := ts.tasks[id]
+	if !ok {
+		return
+	}
+
+	t.frame(frameSent, frame, resource, time.Now())
+}
+
+// received records a frame reporting the outcome of a resource, for the
+// running tasks of an operation waiting for it.  The resource has failed
+// if err is not nil.  No frame is recorded for the failures found by the
+// controller itself, whose frame is empty.
+func (ts *tasks) received(operation string, frame string, resource string, err error) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	now := time.Now()
+	for _, t := range ts.tasks {
+		if t.Operation != operation || !t.pending[resource] {
+			continue
+		}
+
+		if frame != "" {
+			t.frame(frameReceived, frame, resource, now)
+		}
+		t.done(resource, err, now)
+	}
+}
+
+// progress records a frame reporting the progress of a resource, without
+// ending it.
+func (ts *tasks) progress(operation string, frame string, resource string) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	now := time.Now()
+	for _, t := range ts.tasks {
+		if t.Operation == operation && t.pending[resource] {
+			t.frame(frameReceived, frame, resource, now)
+		}
+	}
+}
+
+// fail ends the pending resources of a task with an error.
+func (ts *tasks) fail(id string, err error) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	t, ok := ts.tasks[id]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	for resource := range t.pending {
+		t.done(resource, err, now)
+	}
+}
+
+// ended tells whether the outcome of a resource of a task is known.
+func (ts *tasks) ended(id string, resource string) bool {
+	ts.Lock()
+	defer ts.Unlock()
+
+	t, ok := ts.tasks[id]
+	if !ok {
+		return true
+	}
+
+	return !t.pending[resource]
+}
+
+// find returns the ID of the latest task of a resource.
+func (ts *tasks) find(resource string) (string, bool) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	var latest *task
+	for _, t := range ts.tasks {
+		if latest != nil && !t.Created.After(latest.Created) {
+			continue
+		}
+
+		for _, r := range t.Resources {
+			if r == resource {
+				latest = t
+				break
+			}
+		}
+	}
+
+	if latest == nil {
+		return "", false
+	}
+
+	return latest.ID, true
+}
+
+func (ts *tasks) get(id string) (payloads.Task, error) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	ts.expire(time.Now())
+
+	t, ok := ts.tasks[id]
+	if !ok {
+		return payloads.Task{}, errTaskNotFound
+	}
+
+	return t.copy(), nil
+}
+
+// list returns all the tasks, oldest first.
+func (ts *tasks) list() []payloads.Task {
+	ts.Lock()
+	defer ts.Unlock()
+
+	ts.expire(time.Now())
+
+	list := []payloads.Task{}
+	for _, t := range ts.tasks {
+		list = append(list, t.copy())
+	}
+
+	sort.Sort(sortedTasksByCreation(list))
+
+	return list
+}
+
+// TaskLocation returns the location of the latest task of a resource, or
+// an empty string if the resource has no task.
+func (c *controller) TaskLocation(resource string) string {
+	id, ok := c.tasks.find(resource)
+	if !ok {
+		return ""
+	}
+
+	return "/v2.1/tasks/" + id
+}
+
+// taskStats ends the instance starts and volume attachments reported by
+// the statistics of a node.
+func (c *controller) taskStats(stat payloads.Stat) {
+	frame := ssntp.STATS.String()
+
+	for _, i := range stat.Instances {
+		if i.State == payloads.Running {
+			c.tasks.received(taskStartInstances, frame, i.InstanceUUID, nil)
+		}
+
+		for _, volume := range i.Volumes {
+			c.tasks.received(taskAttachVolume, frame, volume, nil)
+		}
+	}
+}
+
+// taskEvacuation ends the evacuation of a node once complete.  The
+// evacuation fails if some of the node instances could not be restarted
+// on other nodes.
+func (c *controller) taskEvacuation(evacuation payloads.EventNodeEvacuation) {
+	frame := ssntp.NodeEvacuation.String()
+
+	if evacuation.Status != payloads.EvacuationComplete {
+		c.tasks.progress(taskEvacuateNode, frame, evacuation.NodeUUID)
+		return
+	}
+
+	var failed []string
+	for _, i := range evacuation.Instances {
+		if i.Status == payloads.InstanceReplaceFailed {
+			failed = append(failed, i.InstanceUUID)
+		}
+	}
+
+	var err error
+	if len(failed) > 0 {
+		err = fmt.Errorf("No node to restart %s", strings.Join(failed, ", "))
+	}
+
+	c.tasks.received(taskEvacuateNode, frame, evacuation.NodeUUID, err)
+}
@@ -0,0 +1,443 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+)
+
+// tenantDeletionTimeout is how long the deletion of a tenant waits for its
+// instances, then for its CNCI, to be deleted, and tenantDeletionPoll how
+// often it checks whether they are gone.
+const (
+	tenantDeletionTimeout = 5 * time.Minute
+	tenantDeletionPoll    = time.Second
+)
+
+var errTenantDeleting = errors.New("Tenant is being deleted")
+var errTenantDeletionTimeout = errors.New("Timed out waiting for the instances to be deleted")
+var errTenantInstances = errors.New("Instances could not be deleted")
+
+// tenantDeletions tracks the tenants being deleted, and the creations of
+// instances, volumes, floating IPs, keypairs, security groups, webhooks and
+// images in progress for each tenant.  No resource is created for a tenant once it
+// is marked as being deleted, and its teardown waits for the creations which
+// were in progress.
+type tenantDeletions struct {
+	sync.Mutex
+	cond     *sync.Cond
+	deleting map[string]bool
+	creating map[string]int
+}
+
+func (d *tenantDeletions) init() {
+	if d.cond == nil {
+		d.cond = sync.NewCond(&d.Mutex)
+		d.deleting = make(map[string]bool)
+		d.creating = make(map[string]int)
+	}
+}
+
+// start marks a tenant as being deleted, and returns errTenantDeleting if
+// it already is.
+func (d *tenantDeletions) start(tenantID string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.init()
+
+	if d.deleting[tenantID] {
+		return errTenantDeleting
+	}
+	d.deleting[tenantID] = true
+
+	return nil
+}
+
+// wait waits for the creations in progress for a tenant being deleted.
+func (d *tenantDeletions) wait(tenantID string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.init()
+
+	for d.creating[tenantID] > 0 {
+		d.cond.Wait()
+	}
+}
+
+// end clears the mark of a tenant whose deletion has ended.
+func (d *tenantDeletions) end(tenantID string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.init()
+
+	delete(d.deleting, tenantID)
+}
+
+// create registers the creation of resources for a tenant, and returns
+// errTenantDeleting if the tenant is being deleted.  The creation must be
+// ended with created.
+func (d *tenantDeletions) create(tenantID string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.init()
+
+	if d.deleting[tenantID] {
+		return errTenantDeleting
+	}
+	d.creating[tenantID]++
+
+	return nil
+}
+
+// created ends a creation registered with create.
+func (d *tenantDeletions) created(tenantID string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.creating[tenantID]--
+	if d.creating[tenantID] <= 0 {
+		delete(d.creating, tenantID)
+	}
+	d.cond.Broadcast()
+}
+
+// tenantSubnet is a subnet of a tenant on a node, which the tenant CNCI
+// has a tunnel to.
+type tenantSubnet struct {
+	nodeID string
+	subnet string
+}
+
+// deleteTenant tears a tenant down in the background.  Its instances are
+// deleted first, once those still starting are assigned a node, then its
+// volumes.  The tenant CNCI is asked to remove its
+// subnets before being deleted as well, and the tenant is finally removed
+// from the datastore.  The progress of the deletion is tracked by a task,
+// whose resources are the instances, the volumes, the CNCI and the tenant.
+// No instance, volume, floating IP, keypair, security group, webhook or
+// image can be created for the tenant until its deletion has ended.
+func (c *controller) deleteTenant(tenantID string) (err error) {
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	if t == nil {
+		return datastore.ErrNoTenant
+	}
+
+	err = c.deletions.start(tenantID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.deletions.end(tenantID)
+		}
+	}()
+
+	c.deletions.wait(tenantID)
+
+	tenant := *t
+
+	all, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	volumes, err := c.ds.GetBlockDevices(tenantID)
+	if err != nil {
+		return err
+	}
+
+	var instances []*types.Instance
+	var resources []string
+
+	for _, i := range all {
+		if i.ID == tenant.CNCIID {
+			continue
+		}
+		instances = append(instances, i)
+		resources = append(resources, i.ID)
+	}
+
+	for _, v := range volumes {
+		resources = append(resources, v.ID)
+	}
+
+	if tenant.CNCIID != "" {
+		resources = append(resources, tenant.CNCIID)
+	}
+
+	resources = append(resources, tenantID)
+
+	task := c.tasks.add(taskDeleteTenant, tenantID, resources)
+
+	go c.teardownTenant(task, tenant, instances, volumes)
+
+	return nil
+}
+
+func (c *controller) teardownTenant(task string, tenant types.Tenant, instances []*types.Instance, volumes []types.BlockData) {
+	defer c.deletions.end(tenant.ID)
+
+	var ids []string
+	for _, i := range instances {
+		ids = append(ids, i.ID)
+	}
+
+	placed, err := c.waitInstancesPlaced(ids)
+	if err != nil {
+		glog.Warningf("Unable to delete tenant %s: %v", tenant.ID, err)
+		c.tasks.fail(task, err)
+		return
+	}
+
+	subnets := c.deleteTenantInstances(task, placed)
+
+	err = c.waitInstancesDeleted(task, ids)
+	if err != nil {
+		glog.Warningf("Unable to delete tenant %s: %v", tenant.ID, err)
+		c.tasks.fail(task, err)
+		return
+	}
+
+	for _, v := range volumes {
+		err := c.ds.DeleteVolumeAttachments(v.ID)
+		if err == nil {
+			err = c.DeleteVolume(tenant.ID, v.ID)
+		}
+		c.tasks.received(taskDeleteTenant, "", v.ID, err)
+	}
+
+	if tenant.CNCIID != "" {
+		c.removeTenantSubnets(task, &tenant, subnets)
+
+		err = c.deleteTenantCNCI(task, &tenant)
+		if err != nil {
+			glog.Warningf("Unable to delete CNCI of tenant %s: %v", tenant.ID, err)
+			c.tasks.fail(task, err)
+			return
+		}
+	}
+
+	c.purgeTenantResources(tenant.ID)
+
+	err = c.ds.DeleteTenant(tenant.ID)
+	c.tasks.received(taskDeleteTenant, "", tenant.ID, err)
+}
+
+// waitInstancesPlaced waits for the instances which were not assigned a
+// node yet, and whose START may still be in flight, to either be assigned
+// a node or be removed after failing to start.  It returns the instances
+// which are left, with their node.
+func (c *controller) waitInstancesPlaced(instanceIDs []string) ([]*types.Instance, error) {
+	deadline := time.Now().Add(tenantDeletionTimeout)
+
+	for {
+		var placed []*types.Instance
+		pending := 0
+		for _, id := range instanceIDs {
+			i, err := c.ds.GetInstance(id)
+			if err != nil {
+				// the instance failed to start.
+				c.tasks.received(taskDeleteTenant, "", id, nil)
+				continue
+			}
+
+			if i.NodeID == "" {
+				pending++
+				continue
+			}
+
+			placed = append(placed, i)
+		}
+
+		if pending == 0 {
+			return placed, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errTenantDeletionTimeout
+		}
+
+		time.Sleep(tenantDeletionPoll)
+	}
+}
+
+// deleteTenantInstances sends the deletion of the instances of a tenant,
+// and returns the subnets they were connected to.
+func (c *controller) deleteTenantInstances(task string, instances []*types.Instance) map[tenantSubnet]bool {
+	subnets := make(map[tenantSubnet]bool)
+	mask := net.IPv4Mask(255, 255, 255, 0)
+
+	for _, i := range instances {
+		ip := net.ParseIP(i.IPAddress)
+		if ip != nil {
+			subnet := net.IPNet{
+				IP:   ip.Mask(mask),
+				Mask: mask,
+			}
+			subnets[tenantSubnet{nodeID: i.NodeID, subnet: subnet.String()}] = true
+		}
+
+		c.tasks.sent(task, ssntp.DELETE.String(), i.ID)
+		go c.client.DeleteInstance(i.ID, i.NodeID)
+	}
+
+	return subnets
+}
+
+// waitInstancesDeleted waits for instances to be removed from the
+// datastore.  It gives up as soon as one of them failed to be deleted.
+func (c *controller) waitInstancesDeleted(task string, instanceIDs []string) error {
+	deadline := time.Now().Add(tenantDeletionTimeout)
+
+	for {
+		remaining := 0
+		for _, id := range instanceIDs {
+			_, err := c.ds.GetInstance(id)
+			if err != nil {
+				continue
+			}
+
+			// the deletion of the instance failed.
+			if c.tasks.ended(task, id) {
+				return errTenantInstances
+			}
+
+			remaining++
+		}
+
+		if remaining == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errTenantDeletionTimeout
+		}
+
+		time.Sleep(tenantDeletionPoll)
+	}
+}
+
+// removeTenantSubnets asks the tenant CNCI to remove its tunnels to the
+// subnets of the tenant.
+func (c *controller) removeTenantSubnets(task string, tenant *types.Tenant, subnets map[tenantSubnet]bool) {
+	for s := range subnets {
+		node, err := c.ds.GetNode(s.nodeID)
+		if err != nil || node.IPAddr == "" {
+			glog.Warningf("Unable to remove subnet %s of tenant %s: unknown node %s",
+				s.subnet, tenant.ID, s.nodeID)
+			continue
+		}
+
+		_, subnet, err := net.ParseCIDR(s.subnet)
+		if err != nil {
+			glog.Warning(err)
+			continue
+		}
+
+		c.tasks.sent(task, ssntp.TenantRemoved.String(), tenant.CNCIID)
+
+		err = c.client.TenantRemoved(tenant, s.nodeID, node.IPAddr, subnet)
+		if err != nil {
+			glog.Warning(err)
+		}
+	}
+}
+
+// deleteTenantCNCI deletes the CNCI instance of a tenant once it is
+// assigned a node, and waits for it to be gone.
+func (c *controller) deleteTenantCNCI(task string, tenant *types.Tenant) error {
+	cnci, err := c.ds.GetInstance(tenant.CNCIID)
+	if err != nil {
+		// the CNCI never started, or is already gone.
+		c.tasks.received(taskDeleteTenant, "", tenant.CNCIID, nil)
+		return nil
+	}
+
+	placed, err := c.waitInstancesPlaced([]string{cnci.ID})
+	if err != nil {
+		return err
+	}
+
+	if len(placed) == 0 {
+		// the CNCI failed to start.
+		return nil
+	}
+	cnci = placed[0]
+
+	c.tasks.sent(task, ssntp.DELETE.String(), cnci.ID)
+	go c.client.DeleteInstance(cnci.ID, cnci.NodeID)
+
+	return c.waitInstancesDeleted(task, []string{cnci.ID})
+}
+
+// purgeTenantResources releases the floating IPs of a tenant, and removes
+// its security groups, keypairs, webhooks and images.
+func (c *controller) purgeTenantResources(tenantID string) {
+	for _, ip := range c.ds.GetFloatingIPs(tenantID) {
+		err := c.ds.ReleaseFloatingIP(ip.ID)
+		if err != nil {
+			glog.Warningf("Unable to release floating IP %s: %v", ip.Address, err)
+		}
+	}
+
+	for _, g := range c.ds.GetSecurityGroups(tenantID) {
+		err := c.ds.DeleteSecurityGroup(g.ID)
+		if err != nil {
+			glog.Warningf("Unable to delete security group %s: %v", g.ID, err)
+		}
+	}
+
+	for _, k := range c.ds.GetKeyPairs(tenantID) {
+		err := c.ds.DeleteKeyPair(tenantID, k.Name)
+		if err != nil {
+			glog.Warningf("Unable to delete keypair %s: %v", k.Name, err)
+		}
+	}
+
+	for _, w := range c.ds.GetWebhooks(tenantID) {
+		err := c.ds.DeleteWebhook(w.ID)
+		if err != nil {
+			glog.Warningf("Unable to delete webhook %s: %v", w.ID, err)
+		}
+	}
+
+	for _, i := range c.ds.GetImages(tenantID) {
+		err := c.image.DeleteImage(i.ID)
+		if err == nil {
+			err = c.ds.DeleteImage(i.ID)
+		}
+		if err != nil {
+			glog.Warningf("Unable to delete image %s: %v", i.ID, err)
+		}
+	}
+}
@@ -70,22 +70,69 @@ type Workload struct {
 	Config      string                       `json:"-"`
 	Defaults    []payloads.RequestedResource `json:"-"`
 	Storage     *StorageResource             `json:"-"`
+
+	// ImageOverride indicates whether instances of the workload may
+	// be started from an image other than ImageID.
+	ImageOverride bool `json:"-"`
+
+	// RecoveryPolicy is the default recovery policy of the instances
+	// of the workload.
+	RecoveryPolicy RecoveryPolicy `json:"-"`
+}
+
+// RecoveryPolicy defines what happens to the instances of a compute node
+// which disconnects from the cluster.
+type RecoveryPolicy string
+
+const (
+	// RecoveryNone leaves the instances in the unknown state until their
+	// node reconnects.
+	RecoveryNone RecoveryPolicy = "none"
+
+	// RecoveryRestart restarts the instances which were running when
+	// their node disconnected, once the node reconnects.
+	RecoveryRestart RecoveryPolicy = "restart-on-reconnect"
+
+	// RecoveryReschedule starts the instances on other nodes when their
+	// node does not reconnect within a grace period.
+	RecoveryReschedule RecoveryPolicy = "reschedule-elsewhere"
+)
+
+// Valid returns true if p is a known recovery policy.
+func (p RecoveryPolicy) Valid() bool {
+	switch p {
+	case RecoveryNone, RecoveryRestart, RecoveryReschedule:
+		return true
+	}
+
+	return false
 }
 
 // Instance contains information about an instance of a workload.
 type Instance struct {
-	ID          string              `json:"instance_id"`
-	TenantID    string              `json:"tenant_id"`
-	State       string              `json:"instance_state"`
-	WorkloadID  string              `json:"workload_id"`
-	NodeID      string              `json:"node_id"`
-	MACAddress  string              `json:"mac_address"`
-	IPAddress   string              `json:"ip_address"`
-	SSHIP       string              `json:"ssh_ip"`
-	SSHPort     int                 `json:"ssh_port"`
-	CNCI        bool                `json:"-"`
-	Usage       map[string]int      `json:"-"`
-	Attachments []StorageAttachment `json:"-"`
+	ID             string              `json:"instance_id"`
+	TenantID       string              `json:"tenant_id"`
+	State          string              `json:"instance_state"`
+	WorkloadID     string              `json:"workload_id"`
+	ImageID        string              `json:"image_id"`
+	NodeID         string              `json:"node_id"`
+	MACAddress     string              `json:"mac_address"`
+	IPAddress      string              `json:"ip_address"`
+	SSHIP          string              `json:"ssh_ip"`
+	SSHPort        int                 `json:"ssh_port"`
+	PublicIP       string              `json:"public_ip"`
+	Name           string              `json:"name"`
+	SecurityGroups []string            `json:"security_groups"`
+	KeyName        string              `json:"key_name"`
+	Metadata       map[string]string   `json:"metadata"`
+	RecoveryPolicy RecoveryPolicy      `json:"recovery_policy"`
+	CNCI           bool                `json:"-"`
+	Usage          map[string]int      `json:"-"`
+	Attachments    []StorageAttachment `json:"-"`
+
+	// StartConfig is the START command payload the instance was
+	// launched with, used to launch it again on another node.
+	StartConfig string `json:"-"`
 }
 
 // SortedInstancesByID implements sort.Interface for Instance by ID string
@@ -121,8 +168,9 @@ type Resource struct {
 }
 
 // OverLimit calculates whether a request will put a tenant over it's limit.
+// A negative limit means the resource is unlimited.
 func (r *Resource) OverLimit(request int) bool {
-	if r.Limit > 0 && r.Usage+request > r.Limit {
+	if r.Limit >= 0 && r.Usage+request > r.Limit {
 		return true
 	}
 	return false
@@ -136,6 +184,81 @@ type LogEntry struct {
 	Message   string    `json:"message"`
 }
 
+// EventKind is the kind of a cluster event.
+type EventKind string
+
+const (
+	// EventLog is an entry added to the event log.
+	EventLog EventKind = "log"
+
+	// EventInstanceState is a state transition of an instance.
+	EventInstanceState = "instance_state"
+
+	// EventStartFailure is a failure to start an instance.
+	EventStartFailure = "start_failure"
+
+	// EventNodeConnected is the connection of a node to the scheduler.
+	EventNodeConnected = "node_connected"
+
+	// EventNodeDisconnected is the disconnection of a node.
+	EventNodeDisconnected = "node_disconnected"
+
+	// EventTraceReport is the reception of a trace report.
+	EventTraceReport = "trace_report"
+
+	// EventGap tells a client resuming from an event that it missed the
+	// events which followed it.
+	EventGap = "gap"
+)
+
+// Event is a cluster event, pushed to the clients watching the events as
+// it happens.  Events are numbered in the order they happen, the numbers
+// growing across the restarts of the controller.  Events without a tenant
+// are only visible to administrators.
+type Event struct {
+	ID         uint64
+	Timestamp  time.Time
+	Kind       EventKind
+	TenantID   string
+	InstanceID string
+	NodeID     string
+
+	// EventType is the type of the entries of the event log, e.g.
+	// info, and State the new state of the instance of a state
+	// transition.
+	EventType string
+	State     string
+
+	Message string
+}
+
+// Webhook is a subscription of an external service to the cluster events.
+// The webhooks of a tenant receive the events of the tenant, and the
+// webhooks without a tenant, registered by the administrators, receive
+// all the events.
+type Webhook struct {
+	ID         string
+	TenantID   string
+	URL        string
+	EventTypes []EventKind // all the events if empty
+	Secret     string      // key of the HMAC signature of the deliveries
+	CreatedAt  time.Time
+}
+
+// WebhookDelivery is an attempt to deliver an event to a webhook.  The
+// attempts to deliver the same event share their ID.
+type WebhookDelivery struct {
+	ID         string
+	WebhookID  string
+	EventID    uint64
+	EventKind  EventKind
+	Attempt    int
+	Timestamp  time.Time
+	StatusCode int    // 0 if no response was received
+	Error      string // why the attempt failed
+	Delivered  bool
+}
+
 // NodeStats stores statistics for individual nodes in the cluster.
 type NodeStats struct {
 	NodeID          string    `json:"node_id"`
@@ -241,3 +364,117 @@ type StorageAttachment struct {
 	InstanceID string // the instance this volume is attached to
 	BlockID    string // the ID of the block device
 }
+
+// FloatingIPState represents the state of a floating IP in the controller
+// datastore.
+type FloatingIPState string
+
+const (
+	// FloatingIPAvailable means that the address is in the pool and
+	// has not been allocated to a tenant.
+	FloatingIPAvailable FloatingIPState = "available"
+
+	// FloatingIPAllocated means that the address has been allocated to
+	// a tenant but is not associated with any instance.
+	FloatingIPAllocated FloatingIPState = "allocated"
+
+	// FloatingIPAssociating means that the address has been associated
+	// with an instance and the tenant CNCI has been asked to assign it.
+	FloatingIPAssociating FloatingIPState = "associating"
+
+	// FloatingIPAssigned means that the tenant CNCI has reported the
+	// address as assigned to the instance.
+	FloatingIPAssigned FloatingIPState = "assigned"
+)
+
+// FloatingIP represents a public IP address from the controller managed
+// floating IP pool.
+type FloatingIP struct {
+	ID         string          // a uuid
+	Address    string          // the public IP address
+	TenantID   string          // the tenant the address is allocated to
+	InstanceID string          // the instance the address is associated with
+	State      FloatingIPState // allocation state of the address
+}
+
+// SecurityGroupRule represents an ingress rule of a security group.
+type SecurityGroupRule struct {
+	ID       string // a uuid
+	GroupID  string // the security group the rule belongs to
+	Protocol string // tcp, udp or icmp
+	FromPort int    // first port of the range, or icmp type
+	ToPort   int    // last port of the range
+	CIDR     string // source network the rule applies to
+}
+
+// SecurityGroup represents a named set of ingress rules owned by a tenant.
+// The rules are enforced by the tenant CNCI for all the instances that
+// belong to the group.
+type SecurityGroup struct {
+	ID          string // a uuid
+	TenantID    string // the tenant owning the group
+	Name        string // unique within a tenant
+	Description string
+	Rules       []SecurityGroupRule
+}
+
+// KeyPair represents a SSH public key owned by a tenant, which can be
+// injected into the cloud-init configuration of its instances.
+type KeyPair struct {
+	TenantID    string    // the tenant owning the key
+	Name        string    // unique within a tenant
+	PublicKey   string    // OpenSSH authorized_keys format
+	Fingerprint string    // MD5 fingerprint of the public key
+	CreatedAt   time.Time // when the key was added
+}
+
+// States of the images created from instances.
+const (
+	ImageSaving = "saving"
+	ImageActive = "active"
+	ImageError  = "error"
+)
+
+// Image represents an image created by a tenant from the rootfs of one of
+// its instances.  Such images are private to the tenant.
+type Image struct {
+	ID         string    // also the name of the image file
+	TenantID   string    // the tenant owning the image
+	Name       string    // set by the user, not necessarily unique
+	InstanceID string    // the instance the image was created from
+	State      string    // one of ImageSaving, ImageActive or ImageError
+	Size       int64     // size of the image in bytes, once active
+	CreatedAt  time.Time // when the image was requested
+}
+
+// InstanceDeleted is the state recorded in the history of an instance
+// once it has been deleted.
+const InstanceDeleted = "deleted"
+
+// InstanceLost is the state of an instance which is no longer reported by
+// the node it was running on.
+const InstanceLost = "lost"
+
+// InstanceUnknown is the state of an instance whose node disconnected.
+const InstanceUnknown = "unknown"
+
+// OrphanInstance is an instance reported by a node but unknown to the
+// controller.
+type OrphanInstance struct {
+	ID     string    // the instance
+	NodeID string    // the node reporting the instance
+	Since  time.Time // when the instance was first found unknown
+}
+
+// InstanceTransition records a state change of an instance, or a failure
+// reported for an instance, in which case From and To may be the same.
+type InstanceTransition struct {
+	InstanceID string    // the instance
+	TenantID   string    // the tenant owning the instance
+	Timestamp  time.Time // when the controller recorded the transition
+	From       string    // the previous state, empty for a new instance
+	To         string    // the new state
+	Source     string    // the frame or component reporting the transition
+	NodeID     string    // the node the instance was on, if known
+	Reason     string    // the failure reason, if any
+}
@@ -0,0 +1,592 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	gocontext "context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	datastore "github.com/01org/ciao/ciao-controller/internal/datastore"
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// A delivery is attempted webhookAttempts times, waiting webhookBackoff
+// after the first failed attempt and twice as long after each next one.
+// Up to webhookQueue events wait for the deliveries to a webhook to catch
+// up, the next ones are dropped.
+const (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+	webhookTimeout  = 10 * time.Second
+	webhookQueue    = 100
+)
+
+// The headers of the webhook deliveries.  The signature is the hex encoded
+// HMAC-SHA256 of the body keyed with the webhook secret, prefixed with
+// "sha256=".
+const (
+	webhookEventHeader     = "X-Ciao-Event"
+	webhookDeliveryHeader  = "X-Ciao-Delivery"
+	webhookSignatureHeader = "X-Ciao-Signature"
+)
+
+var webhookEventKinds = map[types.EventKind]bool{
+	types.EventLog:              true,
+	types.EventInstanceState:    true,
+	types.EventStartFailure:     true,
+	types.EventNodeConnected:    true,
+	types.EventNodeDisconnected: true,
+	types.EventTraceReport:      true,
+}
+
+// webhookClient delivers the events to the webhooks of the administrators,
+// and tenantWebhookClient to those of the tenants, which are not allowed to
+// reach the addresses of the cluster network.
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+var tenantWebhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         dialPublicAddress,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+}
+
+var privateNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+}
+
+// publicAddress tells whether ip is neither a loopback, link-local,
+// multicast nor private address.
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, cidr := range privateNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dialPublicAddress connects to addr, after checking that its host resolves
+// to public addresses only.  The address is checked when it is dialed, for
+// a webhook not to be redirected to the cluster network by its DNS
+// records or by an HTTP redirect.
+func dialPublicAddress(ctx gocontext.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("No address for %s", host)
+	}
+
+	for _, a := range addrs {
+		if !publicAddress(a.IP) {
+			return nil, fmt.Errorf("Address %s of %s is not allowed", a.IP, host)
+		}
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+// webhookMatches tells whether an event is to be delivered to a webhook.
+func webhookMatches(webhook types.Webhook, e types.Event) bool {
+	if webhook.TenantID != "" && webhook.TenantID != e.TenantID {
+		return false
+	}
+
+	if len(webhook.EventTypes) == 0 {
+		return true
+	}
+
+	for _, kind := range webhook.EventTypes {
+		if kind == e.Kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook makes one attempt to deliver an event to a webhook, and
+// returns the status code of the response, if any.
+func postWebhook(webhook types.Webhook, deliveryID string, kind types.EventKind, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, string(kind))
+	req.Header.Set(webhookDeliveryHeader, deliveryID)
+	if webhook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(webhook.Secret, body))
+	}
+
+	client := webhookClient
+	if webhook.TenantID != "" {
+		client = tenantWebhookClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// deliverWebhook POSTs an event to a webhook, retrying up to attempts
+// times with an exponential backoff.  Each attempt is logged in the
+// datastore.
+func (c *controller) deliverWebhook(webhook types.Webhook, e types.Event, attempts int, backoff time.Duration) {
+	body, err := json.Marshal(streamEventToPayload(e))
+	if err != nil {
+		glog.Warningf("Unable to marshal event %d: %v", e.ID, err)
+		return
+	}
+
+	delivery := types.WebhookDelivery{
+		ID:        uuid.Generate().String(),
+		WebhookID: webhook.ID,
+		EventID:   e.ID,
+		EventKind: e.Kind,
+	}
+
+	for delivery.Attempt = 1; ; delivery.Attempt++ {
+		delivery.Timestamp = time.Now()
+
+		delivery.StatusCode, err = postWebhook(webhook, delivery.ID, e.Kind, body)
+		if err != nil {
+			delivery.Error = err.Error()
+		} else {
+			delivery.Error = ""
+			delivery.Delivered = true
+		}
+
+		logErr := c.ds.AddWebhookDelivery(delivery)
+		if logErr != nil {
+			glog.Warningf("Unable to log delivery %s: %v", delivery.ID, logErr)
+		}
+
+		if delivery.Delivered {
+			return
+		}
+
+		if delivery.Attempt >= attempts {
+			glog.Warningf("Giving up delivering event %d to webhook %s: %v",
+				e.ID, webhook.ID, err)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// webhookWorker delivers the events of a webhook one at a time, in the
+// order they happened.
+type webhookWorker struct {
+	webhook types.Webhook
+	queue   chan types.Event
+	stop    chan struct{}
+}
+
+func (c *controller) startWebhookWorker(webhook types.Webhook) *webhookWorker {
+	w := &webhookWorker{
+		webhook: webhook,
+		queue:   make(chan types.Event, webhookQueue),
+		stop:    make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case e := <-w.queue:
+				c.deliverWebhook(w.webhook, e, webhookAttempts, webhookBackoff)
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+
+	return w
+}
+
+// dispatchEvent queues an event for the workers of the webhooks subscribed
+// to it, starting the workers of the new webhooks.  The workers of the
+// webhooks which were deleted are stopped.
+func (c *controller) dispatchEvent(workers map[string]*webhookWorker, e types.Event) {
+	if e.Kind == types.EventGap {
+		glog.Warningf("Webhook dispatcher: %s", e.Message)
+		return
+	}
+
+	webhooks := make(map[string]bool)
+
+	for _, webhook := range c.ds.GetAllWebhooks() {
+		webhooks[webhook.ID] = true
+
+		if !webhookMatches(webhook, e) {
+			continue
+		}
+
+		w, ok := workers[webhook.ID]
+		if !ok {
+			w = c.startWebhookWorker(webhook)
+			workers[webhook.ID] = w
+		}
+
+		select {
+		case w.queue <- e:
+		default:
+			glog.Warningf("Deliveries to webhook %s falling behind, dropping event %d",
+				webhook.ID, e.ID)
+		}
+	}
+
+	for id, w := range workers {
+		if !webhooks[id] {
+			close(w.stop)
+			delete(workers, id)
+		}
+	}
+}
+
+// startWebhookDispatcher watches the cluster events and delivers them to
+// the webhooks.  When it falls behind, it resumes from the last event it
+// dispatched.
+func (c *controller) startWebhookDispatcher() {
+	var last uint64
+
+	workers := make(map[string]*webhookWorker)
+
+	glog.Info("Starting the webhook dispatcher")
+
+	for {
+		backlog, ch := c.ds.WatchEvents(last)
+
+		for _, e := range backlog {
+			c.dispatchEvent(workers, e)
+			last = e.ID
+		}
+
+		for e := range ch {
+			c.dispatchEvent(workers, e)
+			last = e.ID
+		}
+
+		glog.Warningf("Webhook dispatcher fell behind, resuming after event %d", last)
+		c.ds.UnwatchEvents(ch)
+	}
+}
+
+func webhookToPayload(webhook types.Webhook) payloads.CiaoWebhook {
+	w := payloads.CiaoWebhook{
+		ID:         webhook.ID,
+		TenantID:   webhook.TenantID,
+		URL:        webhook.URL,
+		EventTypes: []string{},
+		CreatedAt:  webhook.CreatedAt,
+	}
+
+	for _, kind := range webhook.EventTypes {
+		w.EventTypes = append(w.EventTypes, string(kind))
+	}
+
+	return w
+}
+
+// getTenantWebhook returns a webhook of a tenant, the webhooks without a
+// tenant being those of the administrators.
+func getTenantWebhook(context *controller, tenant string, ID string) (types.Webhook, error) {
+	webhook, err := context.ds.GetWebhook(ID)
+	if err != nil {
+		return webhook, err
+	}
+
+	if webhook.TenantID != tenant {
+		return types.Webhook{}, datastore.ErrNoWebhook
+	}
+
+	return webhook, nil
+}
+
+func webhookErrorCode(err error) int {
+	if err == datastore.ErrNoWebhook {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	webhooks := payloads.NewCiaoWebhooks()
+
+	for _, webhook := range context.ds.GetWebhooks(tenant) {
+		webhooks.Webhooks = append(webhooks.Webhooks, webhookToPayload(webhook))
+	}
+
+	b, err := json.Marshal(webhooks)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request, context *controller) {
+	var req payloads.CiaoCreateWebhook
+
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	dumpRequestBody(r, true)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		returnErrorCode(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	u, err := url.Parse(req.Webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		returnErrorCode(w, http.StatusBadRequest, "Invalid webhook URL")
+		return
+	}
+
+	// the addresses of host names are checked on delivery.
+	ip := net.ParseIP(u.Hostname())
+	if tenant != "" && (u.Hostname() == "localhost" || (ip != nil && !publicAddress(ip))) {
+		returnErrorCode(w, http.StatusBadRequest, "Webhook URL address not allowed")
+		return
+	}
+
+	webhook := types.Webhook{
+		ID:        uuid.Generate().String(),
+		TenantID:  tenant,
+		URL:       req.Webhook.URL,
+		Secret:    req.Webhook.Secret,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	for _, kind := range req.Webhook.EventTypes {
+		if !webhookEventKinds[types.EventKind(kind)] {
+			returnErrorCode(w, http.StatusBadRequest, "Invalid event type %q", kind)
+			return
+		}
+
+		webhook.EventTypes = append(webhook.EventTypes, types.EventKind(kind))
+	}
+
+	err = context.deletions.create(tenant)
+	if err != nil {
+		returnErrorCode(w, http.StatusConflict, "%v", err)
+		return
+	}
+	defer context.deletions.created(tenant)
+
+	err = context.ds.AddWebhook(webhook)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	b, err := json.Marshal(payloads.CiaoWebhookResponse{Webhook: webhookToPayload(webhook)})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+func showWebhook(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	ID := vars["webhook"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	webhook, err := getTenantWebhook(context, tenant, ID)
+	if err != nil {
+		returnErrorCode(w, webhookErrorCode(err), "%v", err)
+		return
+	}
+
+	b, err := json.Marshal(payloads.CiaoWebhookResponse{Webhook: webhookToPayload(webhook)})
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	ID := vars["webhook"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	_, err := getTenantWebhook(context, tenant, ID)
+	if err == nil {
+		err = context.ds.DeleteWebhook(ID)
+	}
+	if err != nil {
+		returnErrorCode(w, webhookErrorCode(err), "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func listWebhookDeliveries(w http.ResponseWriter, r *http.Request, context *controller) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	ID := vars["webhook"]
+
+	dumpRequest(r)
+
+	if validateToken(context, r) == false {
+		returnErrorCode(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	_, err := getTenantWebhook(context, tenant, ID)
+	if err != nil {
+		returnErrorCode(w, webhookErrorCode(err), "%v", err)
+		return
+	}
+
+	limit, offset, _ := pagerQueryParse(r)
+
+	log, err := context.ds.GetWebhookDeliveries(ID, limit, offset)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	deliveries := payloads.NewCiaoWebhookDeliveries()
+
+	for _, d := range log {
+		deliveries.Deliveries = append(deliveries.Deliveries, payloads.CiaoWebhookDelivery{
+			ID:         d.ID,
+			EventID:    d.EventID,
+			EventKind:  string(d.EventKind),
+			Attempt:    d.Attempt,
+			Timestamp:  d.Timestamp,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Delivered:  d.Delivered,
+		})
+	}
+
+	b, err := json.Marshal(deliveries)
+	if err != nil {
+		returnErrorCode(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
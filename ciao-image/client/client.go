@@ -37,3 +37,14 @@ func (c Client) GetImagePath(ID string) (string, error) {
 
 	return path, nil
 }
+
+// DeleteImage removes an image from the file system.  Removing an image
+// which does not exist is not an error.
+func (c Client) DeleteImage(ID string) error {
+	err := os.Remove(fmt.Sprintf("%s/%s", c.MountPoint, ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+)
+
+const (
+	// consoleHandshakeTimeout bounds the time a controller has to
+	// complete the TLS handshake and name the instance it attaches to.
+	consoleHandshakeTimeout = 10 * time.Second
+
+	// consoleWriteTimeout bounds the time the output of an instance
+	// waits for a slow console session before the session is dropped.
+	consoleWriteTimeout = 5 * time.Second
+)
+
+// serialConsole owns the connection to the serial console socket of a QEMU
+// instance.  The console output is copied to the console log and to the
+// attached interactive session, if any, whose input is written back to the
+// console.  Only one session can be attached at a time, a new session
+// replacing the previous one.
+type serialConsole struct {
+	instance string
+	conn     net.Conn
+
+	sync.Mutex
+	session net.Conn
+}
+
+func (sc *serialConsole) copy(w io.Writer, r io.Reader) error {
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			sc.forward(buf[:n])
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (sc *serialConsole) forward(p []byte) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if sc.session == nil {
+		return
+	}
+
+	_ = sc.session.SetWriteDeadline(time.Now().Add(consoleWriteTimeout))
+	_, err := sc.session.Write(p)
+	if err != nil {
+		glog.Warningf("Dropping console session of %s: %v", sc.instance, err)
+		_ = sc.session.Close()
+		sc.session = nil
+	}
+}
+
+func (sc *serialConsole) attach(session net.Conn) {
+	sc.Lock()
+	if sc.session != nil {
+		glog.Infof("Replacing console session of %s", sc.instance)
+		_ = sc.session.Close()
+	}
+	sc.session = session
+	sc.Unlock()
+
+	glog.Infof("Console session attached to %s", sc.instance)
+
+	go func() {
+		_, _ = io.Copy(sc.conn, session)
+		sc.detach(session)
+	}()
+}
+
+func (sc *serialConsole) detach(session net.Conn) {
+	sc.Lock()
+	if sc.session == session {
+		sc.session = nil
+		glog.Infof("Console session detached from %s", sc.instance)
+	}
+	sc.Unlock()
+
+	_ = session.Close()
+}
+
+func (sc *serialConsole) Close() error {
+	sc.Lock()
+	if sc.session != nil {
+		_ = sc.session.Close()
+		sc.session = nil
+	}
+	sc.Unlock()
+
+	return sc.conn.Close()
+}
+
+// startConsoleServer accepts the serial console connections of the
+// controller on port.  Controllers authenticate with their SSNTP
+// certificate and send the UUID of the instance they attach to, terminated
+// by a new line, after which the connection carries the raw console stream.
+func startConsoleServer(cfg *ssntp.Config, port int, cmdCh chan<- *cmdWrapper,
+	doneCh <-chan struct{}) (net.Listener, error) {
+	tlsConfig, err := cfg.PeerTLSConfig(true, ssntp.Controller)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := tls.Listen("tcp", fmt.Sprintf(":%d", port), tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Accepting console connections on port %d", port)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				glog.Infof("Console server exiting: %v", err)
+				return
+			}
+			go acceptConsole(conn, cmdCh, doneCh)
+		}
+	}()
+
+	return ln, nil
+}
+
+func acceptConsole(conn net.Conn, cmdCh chan<- *cmdWrapper, doneCh <-chan struct{}) {
+	_ = conn.SetReadDeadline(time.Now().Add(consoleHandshakeTimeout))
+	line, err := readConsoleHeader(conn)
+	if err != nil {
+		glog.Warningf("Invalid console connection from %s: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	instance := strings.TrimSpace(line)
+	if !uuidRegexp.MatchString(instance) {
+		glog.Warningf("Invalid console instance %s from %s", instance, conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case cmdCh <- &cmdWrapper{instance, &insAttachConsoleCmd{conn}}:
+	case <-doneCh:
+		_ = conn.Close()
+	}
+}
+
+// readConsoleHeader reads the line naming the instance one byte at a time,
+// so that no console input following it is consumed.
+func readConsoleHeader(r io.Reader) (string, error) {
+	var header [64]byte
+	var b [1]byte
+
+	for i := 0; i < len(header); i++ {
+		_, err := io.ReadFull(r, b[:])
+		if err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(header[:i]), nil
+		}
+		header[i] = b[0]
+	}
+
+	return "", fmt.Errorf("Console header too long")
+}
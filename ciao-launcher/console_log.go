@@ -0,0 +1,222 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	consoleLogFile   = "console.log"
+	consoleSocket    = "console"
+	consoleLogMaxLen = 1024 * 1024
+)
+
+// consoleLog is an io.Writer appending to the console log of an instance.
+// Once the log grows beyond consoleLogMaxLen, its oldest half is dropped.
+// The log is trimmed by renaming a new file over it, so readers always see
+// a complete log.
+type consoleLog struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+func openConsoleLog(instanceDir string) (*consoleLog, error) {
+	logPath := path.Join(instanceDir, consoleLogFile)
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &consoleLog{path: logPath, f: f, size: fi.Size()}, nil
+}
+
+func (c *consoleLog) Write(p []byte) (int, error) {
+	n, err := c.f.Write(p)
+	c.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	if c.size > consoleLogMaxLen {
+		err = c.trim()
+	}
+
+	return n, err
+}
+
+func (c *consoleLog) trim() error {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	data = data[len(data)-consoleLogMaxLen/2:]
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+
+	tmpPath := c.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, c.path)
+	if err != nil {
+		return err
+	}
+
+	_ = c.f.Close()
+	c.f, err = os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0600)
+	c.size = int64(len(data))
+	return err
+}
+
+func (c *consoleLog) Close() error {
+	return c.f.Close()
+}
+
+// captureConsole copies the output read from r to the console log of the
+// instance until r returns an error.
+func captureConsole(instance, instanceDir string, r io.Reader,
+	copyFn func(io.Writer, io.Reader) error) {
+	cl, err := openConsoleLog(instanceDir)
+	if err != nil {
+		glog.Errorf("Unable to open console log of %s: %v", instance, err)
+		return
+	}
+	defer func() { _ = cl.Close() }()
+
+	err = copyFn(cl, r)
+	if err != nil {
+		glog.Warningf("Console capture of %s stopped: %v", instance, err)
+	}
+}
+
+// connectConsole connects to the serial console socket of a QEMU instance
+// and captures its output in a separate go routine.  The capture ends when
+// the instance exits or the returned console is closed.
+func connectConsole(instance, instanceDir string) *serialConsole {
+	conn, err := net.Dial("unix", path.Join(instanceDir, consoleSocket))
+	if err != nil {
+		glog.Warningf("Unable to connect to console of %s: %v", instance, err)
+		return nil
+	}
+
+	sc := &serialConsole{instance: instance, conn: conn}
+	go captureConsole(instance, instanceDir, conn, sc.copy)
+	return sc
+}
+
+// copyDockerLogs demultiplexes the stdout and stderr streams of a docker
+// container that has no tty.  Each chunk of output is prefixed by an 8 byte
+// header holding the stream type and the big endian length of the chunk.
+func copyDockerLogs(w io.Writer, r io.Reader) error {
+	var hdr [8]byte
+
+	for {
+		_, err := io.ReadFull(r, hdr[:])
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(hdr[4:]))
+		_, err = io.CopyN(w, r, size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readConsoleLog returns the last lines of the console log of an instance,
+// or the whole log if lines is not positive.
+func readConsoleLog(instanceDir string, lines int) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(instanceDir, consoleLogFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	log := string(data)
+	if lines <= 0 {
+		return log, nil
+	}
+
+	end := len(log)
+	if strings.HasSuffix(log, "\n") {
+		end--
+	}
+
+	start := end
+	for ; lines > 0 && start >= 0; lines-- {
+		start = strings.LastIndex(log[:start], "\n")
+	}
+
+	return log[start+1:], nil
+}
+
+// sendConsoleLog replies to a GetConsoleLog command with a ConsoleLog event.
+func sendConsoleLog(conn serverConn, instance, requestID, output string, logErr error) {
+	if !conn.isConnected() {
+		return
+	}
+
+	event := payloads.EventConsoleLog{
+		ConsoleLog: payloads.ConsoleLogEvent{
+			InstanceUUID: instance,
+			RequestID:    requestID,
+			Output:       output,
+		},
+	}
+	if logErr != nil {
+		event.ConsoleLog.Error = logErr.Error()
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to marshal console log of %s: %v", instance, err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.ConsoleLog, payload)
+	if err != nil {
+		glog.Errorf("Unable to send console log of %s: %v", instance, err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestReadConsoleLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console-log-test")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	output, err := readConsoleLog(dir, 10)
+	if err != nil || output != "" {
+		t.Fatalf("Expected empty log, got %q, %v", output, err)
+	}
+
+	err = ioutil.WriteFile(path.Join(dir, consoleLogFile), []byte("a\nb\nc\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write console log: %v", err)
+	}
+
+	tests := []struct {
+		lines  int
+		output string
+	}{
+		{0, "a\nb\nc\n"},
+		{1, "c\n"},
+		{2, "b\nc\n"},
+		{3, "a\nb\nc\n"},
+		{10, "a\nb\nc\n"},
+	}
+
+	for _, test := range tests {
+		output, err = readConsoleLog(dir, test.lines)
+		if err != nil || output != test.output {
+			t.Errorf("Expected %q for %d lines, got %q, %v",
+				test.output, test.lines, output, err)
+		}
+	}
+}
+
+func TestConsoleLogTrim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console-log-test")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cl, err := openConsoleLog(dir)
+	if err != nil {
+		t.Fatalf("Unable to open console log: %v", err)
+	}
+	defer func() { _ = cl.Close() }()
+
+	line := strings.Repeat("x", 1023) + "\n"
+	for i := 0; i < consoleLogMaxLen/len(line)+1; i++ {
+		_, err = cl.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("Unable to write to console log: %v", err)
+		}
+	}
+
+	_, err = cl.Write([]byte("last\n"))
+	if err != nil {
+		t.Fatalf("Unable to write to console log: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, consoleLogFile))
+	if err != nil {
+		t.Fatalf("Unable to read console log: %v", err)
+	}
+
+	if len(data) > consoleLogMaxLen {
+		t.Fatalf("Console log not trimmed: %d bytes", len(data))
+	}
+
+	if !bytes.HasPrefix(data, []byte(line)) || !bytes.HasSuffix(data, []byte("last\n")) {
+		t.Fatalf("Console log not trimmed at a line boundary")
+	}
+}
+
+func TestCopyDockerLogs(t *testing.T) {
+	var stream bytes.Buffer
+
+	for i, chunk := range []string{"stdout\n", "stderr\n"} {
+		hdr := make([]byte, 8)
+		hdr[0] = byte(i + 1)
+		binary.BigEndian.PutUint32(hdr[4:], uint32(len(chunk)))
+		stream.Write(hdr)
+		stream.WriteString(chunk)
+	}
+
+	var out bytes.Buffer
+	err := copyDockerLogs(&out, &stream)
+	if err != nil {
+		t.Fatalf("copyDockerLogs failed: %v", err)
+	}
+
+	if out.String() != "stdout\nstderr\n" {
+		t.Fatalf("Unexpected docker log output %q", out.String())
+	}
+}
@@ -0,0 +1,93 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSerialConsole(t *testing.T) {
+	consoleConn, qemuConn := net.Pipe()
+	sc := &serialConsole{instance: "testInstance", conn: consoleConn}
+
+	var log bytes.Buffer
+	copyCh := make(chan error)
+	go func() {
+		copyCh <- sc.copy(&log, consoleConn)
+	}()
+
+	session, client := net.Pipe()
+	sc.attach(session)
+
+	go func() {
+		_, _ = qemuConn.Write([]byte("login:"))
+	}()
+
+	buf := make([]byte, 6)
+	_, err := io.ReadFull(client, buf)
+	if err != nil || string(buf) != "login:" {
+		t.Fatalf("Unexpected console output %q: %v", buf, err)
+	}
+
+	go func() {
+		_, _ = client.Write([]byte("root\n"))
+	}()
+
+	buf = make([]byte, 5)
+	_, err = io.ReadFull(qemuConn, buf)
+	if err != nil || string(buf) != "root\n" {
+		t.Fatalf("Unexpected console input %q: %v", buf, err)
+	}
+
+	_ = qemuConn.Close()
+	err = <-copyCh
+	if err != nil {
+		t.Fatalf("Console copy failed: %v", err)
+	}
+
+	if log.String() != "login:" {
+		t.Fatalf("Unexpected console log %q", log.String())
+	}
+
+	_ = sc.Close()
+	_, err = client.Read(buf)
+	if err == nil {
+		t.Fatalf("Console session not closed")
+	}
+}
+
+func TestReadConsoleHeader(t *testing.T) {
+	r := strings.NewReader("67d86208-b46c-4465-9018-e14187d4010\ninput")
+
+	header, err := readConsoleHeader(r)
+	if err != nil || header != "67d86208-b46c-4465-9018-e14187d4010" {
+		t.Fatalf("Unexpected console header %q: %v", header, err)
+	}
+
+	if r.Len() != len("input") {
+		t.Fatalf("Console input consumed by header")
+	}
+
+	_, err = readConsoleHeader(strings.NewReader(strings.Repeat("x", 128)))
+	if err == nil {
+		t.Fatalf("Error expected for long console header")
+	}
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"path"
@@ -257,8 +258,12 @@ func (d *docker) startVM(vnicName, ipAddress string) error {
 	return nil
 }
 
-func dockerConnect(dockerChannel chan interface{}, instance, dockerID string, closedCh chan struct{},
-	connectedCh chan struct{}, wg *sync.WaitGroup, boot bool) {
+func (d *docker) startIncomingVM(vnicName, ipAddress, uri string, diskPort int, devices map[string]string) error {
+	return errors.New("Containers cannot be live migrated")
+}
+
+func dockerConnect(dockerChannel chan interface{}, instance, instanceDir, dockerID string,
+	closedCh chan struct{}, connectedCh chan struct{}, wg *sync.WaitGroup, boot bool) {
 
 	defer func() {
 		if closedCh != nil {
@@ -289,6 +294,30 @@ func dockerConnect(dockerChannel chan interface{}, instance, dockerID string, cl
 	close(connectedCh)
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	// On a restart of the launcher the earlier output of the container
+	// has already been captured, so we only follow new output.
+
+	tail := "0"
+	if boot {
+		tail = "all"
+	}
+	logs, logErr := cli.ContainerLogs(ctx, types.ContainerLogsOptions{
+		ContainerID: dockerID,
+		ShowStdout:  true,
+		ShowStderr:  true,
+		Follow:      true,
+		Tail:        tail,
+	})
+	if logErr != nil {
+		glog.Warningf("Unable to capture logs of %s:%s: %v", instance, dockerID, logErr)
+	} else {
+		go func() {
+			defer func() { _ = logs.Close() }()
+			captureConsole(instance, instanceDir, logs, copyDockerLogs)
+		}()
+	}
+
 	lostContainerCh := make(chan struct{})
 	go func() {
 		defer close(lostContainerCh)
@@ -311,11 +340,26 @@ DONE:
 				cancelFunc()
 				_ = <-lostContainerCh
 				break DONE
-			} else if _, isStop := cmd.(virtualizerStopCmd); isStop {
+			}
+			switch cmd := cmd.(type) {
+			case virtualizerStopCmd:
 				err := cli.ContainerKill(context.Background(), dockerID, "KILL")
 				if err != nil {
 					glog.Errorf("Unable to stop instance %s:%s", instance, dockerID)
 				}
+			case virtualizerPowerdownCmd:
+				err := cli.ContainerStop(context.Background(), dockerID,
+					int(powerdownTimeout/time.Second))
+				if err != nil {
+					glog.Errorf("Unable to power down instance %s:%s", instance, dockerID)
+				}
+			case virtualizerPauseCmd:
+				cmd.responseCh <- cli.ContainerPause(context.Background(), dockerID)
+			case virtualizerUnpauseCmd:
+				cmd.responseCh <- cli.ContainerUnpause(context.Background(), dockerID)
+			case virtualizerAttachConsoleCmd:
+				glog.Warningf("Instance %s:%s has no serial console", instance, dockerID)
+				_ = cmd.conn.Close()
 			}
 		}
 	}
@@ -339,7 +383,8 @@ func (d *docker) monitorVM(closedCh chan struct{}, connectedCh chan struct{},
 	}
 	dockerChannel := make(chan interface{})
 	wg.Add(1)
-	go dockerConnect(dockerChannel, d.cfg.Instance, d.dockerID, closedCh, connectedCh, wg, boot)
+	go dockerConnect(dockerChannel, d.cfg.Instance, d.instanceDir, d.dockerID, closedCh,
+		connectedCh, wg, boot)
 	return dockerChannel
 }
 
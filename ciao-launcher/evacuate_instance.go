@@ -0,0 +1,230 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// How long we wait for all the instances to be stopped before reporting
+// the evacuation to the scheduler.
+const evacuationTimeout = 5 * time.Minute
+
+// restartCmd returns the description of an instance needed to restart it
+// on another node.  The instance is booted from the same volume on the new
+// node and its other volumes are re-attached.
+func (cfg *vmConfig) restartCmd(volumes []string) payloads.RestartCmd {
+	restart := payloads.RestartCmd{
+		TenantUUID:          cfg.TennantUUID,
+		InstanceUUID:        cfg.Instance,
+		FWType:              payloads.EFI,
+		InstancePersistence: cfg.Persistence,
+		VMType:              payloads.QEMU,
+		RequestedResources: []payloads.RequestedResource{
+			{Type: payloads.VCPUs, Value: cfg.Cpus, Mandatory: true},
+			{Type: payloads.MemMB, Value: cfg.Mem, Mandatory: true},
+			{Type: payloads.DiskMB, Value: cfg.Disk, Mandatory: true},
+		},
+		Networking: payloads.NetworkResources{
+			VnicMAC:          cfg.VnicMAC,
+			VnicUUID:         cfg.VnicUUID,
+			ConcentratorUUID: cfg.ConcUUID,
+			ConcentratorIP:   cfg.ConcIP,
+			Subnet:           cfg.SubnetIP,
+			PrivateIP:        cfg.VnicIP,
+		},
+		Volumes: volumes,
+	}
+
+	// Instances created before the persistence was recorded in their
+	// configuration are host persistent.
+	if restart.InstancePersistence == "" {
+		restart.InstancePersistence = payloads.Host
+	}
+
+	if cfg.BootVolume != "" {
+		restart.Storage = payloads.StorageResources{
+			ID:       cfg.BootVolume,
+			Bootable: true,
+		}
+	}
+
+	if cfg.Legacy {
+		restart.FWType = payloads.Legacy
+	}
+
+	if cfg.Container {
+		restart.VMType = payloads.Docker
+		restart.DockerImage = cfg.Image
+	} else {
+		restart.ImageUUID = cfg.Image
+	}
+
+	if cfg.NetworkNode {
+		restart.RequestedResources = append(restart.RequestedResources,
+			payloads.RequestedResource{Type: payloads.NetworkNode, Value: 1, Mandatory: true})
+	}
+
+	return restart
+}
+
+// movable returns true if the instance boots from a volume.  The state of
+// other instances lives in their local rootfs, which would be lost if they
+// were restarted on another node, whether or not they have data volumes.
+func (cfg *vmConfig) movable() bool {
+	return !cfg.NetworkNode && cfg.BootVolume != ""
+}
+
+func (id *instanceData) evacuateCommand(cmd *insEvacuateCmd) {
+	if id.monitorCh != nil {
+		glog.Infof("Powerdown %s before evacuation", id.instance)
+		id.evacuation = cmd
+		id.monitorCh <- virtualizerStopCmd{}
+		return
+	}
+
+	id.completeEvacuation(cmd)
+}
+
+// completeEvacuation reports a stopped instance to the evacuation.  Instances
+// booting from a volume can be restarted on another node, so their volumes
+// are unmapped and the local copy of the instance is deleted.
+func (id *instanceData) completeEvacuation(cmd *insEvacuateCmd) {
+	volumes := id.getVolumes()
+	evacuated := payloads.EvacuatedInstance{
+		Restart: id.cfg.restartCmd(volumes),
+		Movable: !id.shuttingDown && id.cfg.movable(),
+	}
+
+	if evacuated.Movable {
+		glog.Infof("Instance %s evacuated, removing it", id.instance)
+		id.handOver(volumes)
+	}
+
+	cmd.resultCh <- evacuated
+}
+
+// handOver unmaps the volumes of a stopped instance and deletes the local
+// copy of the instance, which is restarted on another node.
+func (id *instanceData) handOver(volumes []string) {
+	for _, volume := range volumes {
+		_ = id.storageDriver.UnmapVolumeFromNode(volume)
+	}
+
+	killMe(id.instance, id.doneCh, id.ac, &id.instanceWg)
+	id.shuttingDown = true
+}
+
+// sendInstancesEvacuated reports stopped instances to the scheduler.
+func sendInstancesEvacuated(conn serverConn, event *payloads.InstancesEvacuated) {
+	payload, err := yaml.Marshal(event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall InstancesEvacuated %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.InstancesEvacuated, payload)
+	if err != nil {
+		glog.Errorf("Failed to send InstancesEvacuated event %v", err)
+	}
+}
+
+// evacuateInstances asks all the instances of the node to stop and reports
+// them to the scheduler in an InstancesEvacuated event.  The launcher exits
+// once the instances are reported if the node is to be shut down.
+func evacuateInstances(conn serverConn, nextState payloads.NodeNextState, targets []chan<- interface{},
+	doneCh chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	resultCh := make(chan payloads.EvacuatedInstance, len(targets))
+	timeout := time.After(evacuationTimeout)
+	sent := 0
+
+SEND:
+	for _, target := range targets {
+		select {
+		case target <- &insEvacuateCmd{resultCh}:
+			sent++
+		case <-doneCh:
+			return
+		case <-timeout:
+			glog.Warningf("Timed out evacuating instances, %d not stopped", len(targets)-sent)
+			break SEND
+		}
+	}
+
+	event := payloads.InstancesEvacuated{
+		Evacuated: payloads.EventInstancesEvacuated{
+			NodeUUID:  conn.UUID(),
+			NextState: nextState,
+		},
+	}
+
+COLLECT:
+	for len(event.Evacuated.Instances) < sent {
+		select {
+		case instance := <-resultCh:
+			event.Evacuated.Instances = append(event.Evacuated.Instances, instance)
+		case <-doneCh:
+			return
+		case <-timeout:
+			glog.Warningf("Timed out evacuating instances, %d not stopped",
+				sent-len(event.Evacuated.Instances))
+			break COLLECT
+		}
+	}
+
+	sendInstancesEvacuated(conn, &event)
+
+	glog.Infof("Node evacuated, %d instances stopped", len(event.Evacuated.Instances))
+
+	if nextState == payloads.NodeShutdown {
+		glog.Info("Shutting down evacuated node")
+		_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	}
+}
+
+// restartEvacuatedInstance creates an instance evacuated from another node
+// and re-attaches its volumes.
+func restartEvacuatedInstance(conn serverConn, instance string, cmd *insRestartCmd, ovsCh chan<- interface{}) {
+	targetCh := make(chan ovsAddResult)
+	ovsCh <- &ovsAddCmd{instance, cmd.cfg, targetCh, false}
+	addResult := <-targetCh
+	if !addResult.canAdd {
+		glog.Errorf("Evacuated instance will make node full: Disk %d Mem %d CPUs %d",
+			cmd.cfg.Disk, cmd.cfg.Mem, cmd.cfg.Cpus)
+		re := restartError{nil, payloads.RestartLaunchFailure}
+		re.send(conn, instance)
+		return
+	}
+
+	glog.Infof("Restarting evacuated instance %s", instance)
+
+	target := addResult.cmdCh
+	target <- &insStartCmd{cfg: cmd.cfg, rcvStamp: time.Now(), restart: true}
+	for _, volume := range cmd.volumes {
+		target <- &insAttachVolumeCmd{volume}
+	}
+}
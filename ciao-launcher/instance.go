@@ -17,6 +17,8 @@
 package main
 
 import (
+	"errors"
+	"net"
 	"path"
 	"sync"
 	"time"
@@ -46,6 +48,22 @@ type instanceData struct {
 	rcvStamp       time.Time
 	st             *startTimes
 	storageDriver  storage.BlockDriver
+	evacuation     *insEvacuateCmd
+	rebooting      bool
+	paused         bool
+	snapshotCh     chan snapshotResult
+
+	// State of a live migration.  migrationTarget is only set on the
+	// node the instance is migrated from, and incoming on the node it is
+	// migrated to.
+	migrationTarget string
+	migrationSource string
+	migrationCh     chan error
+	migrationTimer  <-chan time.Time
+	migrationPort   int
+	diskPort        int
+	incoming        bool
+	handingOver     bool
 }
 
 type insStartCmd struct {
@@ -54,14 +72,64 @@ type insStartCmd struct {
 	frame    *ssntp.Frame
 	cfg      *vmConfig
 	rcvStamp time.Time
+	restart  bool
+}
+
+// insRestartCmd only carries a configuration and volumes for instances
+// evacuated from another node, and new resources for resized instances.
+type insRestartCmd struct {
+	cfg     *vmConfig
+	volumes []string
+	resize  *vmResize
 }
-type insRestartCmd struct{}
 type insDeleteCmd struct {
 	suicide bool
 	running ovsRunningState
 }
 type insStopCmd struct{}
 type insMonitorCmd struct{}
+type insRebootCmd struct {
+	hard bool
+}
+
+// insPauseCmd and insUnpauseCmd are used for both PAUSE/UNPAUSE and
+// SUSPEND/RESUME.  A suspended instance is simply paused.
+type insPauseCmd struct{}
+type insUnpauseCmd struct{}
+type insAttachConsoleCmd struct {
+	conn net.Conn
+}
+type insConsoleLogCmd struct {
+	requestID string
+	lines     int
+}
+type insCreateImageCmd struct {
+	imageID string
+}
+
+type insEvacuateCmd struct {
+	resultCh chan<- payloads.EvacuatedInstance
+}
+
+// insMigrateCmd and insMigrationReadyCmd are processed by the node an
+// instance is migrated from, insMigrateIncomingCmd and insInstanceMigratedCmd
+// by the node it is migrated to.
+type insMigrateCmd struct {
+	target string
+}
+type insMigrationReadyCmd struct {
+	uri     string
+	diskURI string
+	err     string
+}
+type insMigrateIncomingCmd struct {
+	cfg     *vmConfig
+	volumes []string
+	source  string
+}
+type insInstanceMigratedCmd struct {
+	err string
+}
 
 type insAttachVolumeCmd struct {
 	volumeUUID string
@@ -115,7 +183,12 @@ func (id *instanceData) startCommand(cmd *insStartCmd) {
 	st, startErr := processStart(cmd, id.instanceDir, id.vm, id.ac.conn)
 	if startErr != nil {
 		glog.Errorf("Unable to start instance[%s]: %v", string(startErr.code), startErr.err)
-		startErr.send(id.ac.conn, id.instance)
+		if cmd.restart {
+			restartErr := &restartError{startErr.err, payloads.RestartLaunchFailure}
+			restartErr.send(id.ac.conn, id.instance)
+		} else {
+			startErr.send(id.ac.conn, id.instance)
+		}
 
 		if startErr.code == payloads.LaunchFailure {
 			id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
@@ -154,6 +227,10 @@ func (id *instanceData) restartCommand(cmd *insRestartCmd) {
 		return
 	}
 
+	if cmd.resize != nil && !id.resizeCommand(cmd.resize) {
+		return
+	}
+
 	restartErr := processRestart(id.instanceDir, id.vm, id.ac.conn, id.cfg)
 
 	if restartErr != nil {
@@ -192,6 +269,149 @@ func (id *instanceData) stopCommand(cmd *insStopCmd) {
 	id.monitorCh <- virtualizerStopCmd{}
 }
 
+func (id *instanceData) rebootCommand(cmd *insRebootCmd) {
+	if id.shuttingDown || id.monitorCh == nil {
+		glog.Errorf("Unable to reboot instance %s: not running", id.instance)
+		return
+	}
+
+	glog.Infof("Rebooting %s, hard %v", id.instance, cmd.hard)
+	id.rebooting = true
+
+	// A paused guest, or one frozen for a snapshot, cannot react to a
+	// powerdown request
+	if cmd.hard || id.paused || id.snapshotCh != nil {
+		id.monitorCh <- virtualizerStopCmd{}
+	} else {
+		id.monitorCh <- virtualizerPowerdownCmd{}
+	}
+}
+
+func (id *instanceData) pauseCommand(cmd *insPauseCmd) {
+	if id.shuttingDown || id.monitorCh == nil || id.paused {
+		glog.Errorf("Unable to pause instance %s: not running", id.instance)
+		return
+	}
+
+	responseCh := make(chan error)
+	id.monitorCh <- virtualizerPauseCmd{responseCh}
+	err := <-responseCh
+	if err != nil {
+		glog.Errorf("Unable to pause instance %s: %v", id.instance, err)
+		return
+	}
+
+	glog.Infof("Instance %s paused", id.instance)
+	id.paused = true
+	id.ovsCh <- &ovsStateChange{id.instance, ovsPaused}
+}
+
+func (id *instanceData) unpauseCommand(cmd *insUnpauseCmd) {
+	if id.shuttingDown || id.monitorCh == nil || !id.paused {
+		glog.Errorf("Unable to unpause instance %s: not paused", id.instance)
+		return
+	}
+
+	responseCh := make(chan error)
+	id.monitorCh <- virtualizerUnpauseCmd{responseCh}
+	err := <-responseCh
+	if err != nil {
+		glog.Errorf("Unable to unpause instance %s: %v", id.instance, err)
+		return
+	}
+
+	glog.Infof("Instance %s unpaused", id.instance)
+	id.paused = false
+	id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+}
+
+func (id *instanceData) attachConsoleCommand(cmd *insAttachConsoleCmd) {
+	if id.shuttingDown || id.monitorCh == nil {
+		glog.Errorf("Unable to attach console to %s: not running", id.instance)
+		_ = cmd.conn.Close()
+		return
+	}
+
+	id.monitorCh <- virtualizerAttachConsoleCmd{cmd.conn}
+}
+
+func (id *instanceData) consoleLogCommand(cmd *insConsoleLogCmd) {
+	output, err := readConsoleLog(id.instanceDir, cmd.lines)
+	if err != nil {
+		glog.Errorf("Unable to read console log of %s: %v", id.instance, err)
+	}
+	sendConsoleLog(id.ac.conn, id.instance, cmd.requestID, output, err)
+}
+
+func (id *instanceData) createImageCommand(cmd *insCreateImageCmd) {
+	var err error
+
+	if id.cfg.Container {
+		err = errors.New("Images can only be created from VMs")
+	} else if !sharedImages {
+		err = errNoImageStore
+	} else if id.shuttingDown {
+		err = errors.New("Instance not found")
+	} else if id.snapshotCh != nil {
+		err = errors.New("Image creation already in progress")
+	}
+	if err != nil {
+		glog.Errorf("Unable to create image from %s: %v", id.instance, err)
+		sendImageCreated(id.ac.conn, id.instance, cmd.imageID, 0, err)
+		return
+	}
+
+	// The guest is frozen while its rootfs is copied to get a
+	// consistent image.
+	running := id.monitorCh != nil
+	resume := false
+	if running && !id.paused {
+		responseCh := make(chan error)
+		id.monitorCh <- virtualizerPauseCmd{responseCh}
+		err = <-responseCh
+		if err != nil {
+			glog.Errorf("Unable to pause instance %s: %v", id.instance, err)
+			sendImageCreated(id.ac.conn, id.instance, cmd.imageID, 0, err)
+			return
+		}
+		resume = true
+	}
+
+	glog.Infof("Creating image %s from instance %s", cmd.imageID, id.instance)
+
+	snapshotCh := make(chan snapshotResult, 1)
+	id.snapshotCh = snapshotCh
+	rootfs := path.Join(id.instanceDir, "image.qcow2")
+	imageID := cmd.imageID
+
+	id.instanceWg.Add(1)
+	go func() {
+		defer id.instanceWg.Done()
+		size, err := snapshotRootfs(rootfs, imageID, running)
+		snapshotCh <- snapshotResult{imageID, size, err, resume}
+	}()
+}
+
+func (id *instanceData) snapshotDone(res snapshotResult) {
+	id.snapshotCh = nil
+
+	if res.resume && id.monitorCh != nil && !id.paused {
+		responseCh := make(chan error)
+		id.monitorCh <- virtualizerUnpauseCmd{responseCh}
+		err := <-responseCh
+		if err != nil {
+			glog.Errorf("Unable to unpause instance %s: %v", id.instance, err)
+		}
+	}
+
+	if res.err != nil {
+		glog.Errorf("Unable to create image %s from %s: %v", res.imageID, id.instance, res.err)
+	} else {
+		glog.Infof("Image %s created from %s, %d bytes", res.imageID, id.instance, res.size)
+	}
+	sendImageCreated(id.ac.conn, id.instance, res.imageID, res.size, res.err)
+}
+
 func (id *instanceData) deleteCommand(cmd *insDeleteCmd) bool {
 	if id.shuttingDown && !cmd.suicide {
 		deleteErr := &deleteError{nil, payloads.DeleteNoInstance}
@@ -289,10 +509,32 @@ func (id *instanceData) instanceCommand(cmd interface{}) bool {
 		id.monitorCommand(cmd)
 	case *insStopCmd:
 		id.stopCommand(cmd)
+	case *insRebootCmd:
+		id.rebootCommand(cmd)
+	case *insPauseCmd:
+		id.pauseCommand(cmd)
+	case *insUnpauseCmd:
+		id.unpauseCommand(cmd)
+	case *insConsoleLogCmd:
+		id.consoleLogCommand(cmd)
+	case *insCreateImageCmd:
+		id.createImageCommand(cmd)
+	case *insAttachConsoleCmd:
+		id.attachConsoleCommand(cmd)
 	case *insAttachVolumeCmd:
 		id.attachVolumeCommand(cmd)
 	case *insDetachVolumeCmd:
 		id.detachVolumeCommand(cmd)
+	case *insEvacuateCmd:
+		id.evacuateCommand(cmd)
+	case *insMigrateCmd:
+		id.migrateCommand(cmd)
+	case *insMigrationReadyCmd:
+		id.migrationReadyCommand(cmd)
+	case *insMigrateIncomingCmd:
+		id.migrateIncomingCommand(cmd)
+	case *insInstanceMigratedCmd:
+		id.instanceMigratedCommand(cmd)
 	case *insDeleteCmd:
 		if id.deleteCommand(cmd) {
 			return false
@@ -332,6 +574,12 @@ DONE:
 			if !id.instanceCommand(cmd) {
 				break DONE
 			}
+		case res := <-id.snapshotCh:
+			id.snapshotDone(res)
+		case err := <-id.migrationCh:
+			id.migrationDone(err)
+		case <-id.migrationTimer:
+			id.migrationTimedOut()
 		case <-id.monitorCloseCh:
 			// Means we've lost VM for now
 			id.vm.lostVM()
@@ -344,13 +592,33 @@ DONE:
 			close(id.monitorCh)
 			id.monitorCh = nil
 			id.statsTimer = nil
-			id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
+			// Instances migrated away, or which failed to migrate to
+			// this node, are deleted rather than stopped.
+			handingOver := id.handingOver || id.incoming
+			if !handingOver {
+				id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
+			}
 			id.st = nil
+			id.paused = false
+			rebooting := id.rebooting
+			id.rebooting = false
+			if handingOver {
+				id.discardMigratedInstance()
+			} else if id.migrationTarget != "" && id.migrationCh == nil {
+				id.migrationDone(errors.New("Instance stopped"))
+			} else if id.evacuation != nil {
+				id.completeEvacuation(id.evacuation)
+				id.evacuation = nil
+			} else if rebooting {
+				id.restartCommand(&insRestartCmd{})
+			}
 		case <-id.connectedCh:
 			id.logStartTrace()
 			id.connectedCh = nil
 			id.vm.connected()
-			id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+			if !id.incoming {
+				id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+			}
 			d, m, c := id.vm.stats()
 			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
 			id.statsTimer = time.After(time.Second * resourcePeriod)
@@ -93,6 +93,10 @@ func (v *instanceTestState) startVM(vnicName, ipAddress string) error {
 	return nil
 }
 
+func (v *instanceTestState) startIncomingVM(vnicName, ipAddress, uri string, diskPort int, devices map[string]string) error {
+	return v.startVM(vnicName, ipAddress)
+}
+
 func (v *instanceTestState) monitorVM(closedCh chan struct{}, connectedCh chan struct{},
 	wg *sync.WaitGroup, boot bool) chan interface{} {
 
@@ -1078,3 +1082,143 @@ func TestDetachNonexistingVolumeFromInstance(t *testing.T) {
 
 	wg.Wait()
 }
+
+// Check we can evacuate a volume backed instance
+//
+// We start the instance loop, attach a volume and evacuate the instance.  The
+// test virtualizer closes the monitor channel once asked to stop the VM.
+//
+// The instance should be stopped and reported as movable, with its volumes, and
+// should then ask to be deleted.
+func TestEvacuateInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	cfg.Volumes = make(map[string]struct{})
+	cfg.BootVolume = testutil.BootVolumeUUID
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	select {
+	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending attach volume command")
+	}
+
+	select {
+	case monCmd := <-state.monitorCh:
+		monCmd.(virtualizerAttachCmd).responseCh <- nil
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for attach volume command result")
+	}
+
+	resultCh := make(chan payloads.EvacuatedInstance, 1)
+	select {
+	case cmdCh <- &insEvacuateCmd{resultCh}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending evacuate command")
+	}
+
+	var evacuated payloads.EvacuatedInstance
+	timeout := time.After(time.Second)
+DONE:
+	for {
+		select {
+		case monCmd := <-state.monitorCh:
+			if _, stopCmd := monCmd.(virtualizerStopCmd); !stopCmd {
+				t.Errorf("Invalid monitor command found %t, expected virtualizerStopCmd", monCmd)
+			}
+			close(state.monitorClosedCh)
+			state.monitorCh = nil
+		case <-ovsCh:
+		case evacuated = <-resultCh:
+			break DONE
+		case <-timeout:
+			cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+		}
+	}
+
+	if !evacuated.Movable || evacuated.Restart.InstanceUUID != cfg.Instance ||
+		len(evacuated.Restart.Volumes) != 1 || evacuated.Restart.Volumes[0] != testutil.VolumeUUID ||
+		!evacuated.Restart.Storage.Bootable || evacuated.Restart.Storage.ID != testutil.BootVolumeUUID {
+		t.Errorf("Unexpected evacuated instance %v", evacuated)
+	}
+
+	select {
+	case cmd := <-state.ac.cmdCh:
+		if delCmd, ok := cmd.cmd.(*insDeleteCmd); !ok || !delCmd.suicide {
+			t.Errorf("Unexpected command %v, expected suicide delete", cmd.cmd)
+		}
+	case <-ovsCh:
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for evacuated instance deletion")
+	}
+
+	shutdownInstanceLoop(doneCh, ovsCh, &wg, t)
+	_ = os.RemoveAll(path.Join(instancesDir, cfg.Instance))
+}
+
+// Check an instance with a data volume is not moved by an evacuation
+//
+// We start the instance loop of an instance booting from its local rootfs,
+// attach a volume and evacuate the instance.
+//
+// The instance should be stopped and reported as not movable, and should not
+// ask to be deleted.
+func TestEvacuateDataVolumeInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	cfg.Volumes = make(map[string]struct{})
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	select {
+	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending attach volume command")
+	}
+
+	select {
+	case monCmd := <-state.monitorCh:
+		monCmd.(virtualizerAttachCmd).responseCh <- nil
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for attach volume command result")
+	}
+
+	resultCh := make(chan payloads.EvacuatedInstance, 1)
+	select {
+	case cmdCh <- &insEvacuateCmd{resultCh}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending evacuate command")
+	}
+
+	var evacuated payloads.EvacuatedInstance
+	timeout := time.After(time.Second)
+DONE:
+	for {
+		select {
+		case monCmd := <-state.monitorCh:
+			if _, stopCmd := monCmd.(virtualizerStopCmd); !stopCmd {
+				t.Errorf("Invalid monitor command found %t, expected virtualizerStopCmd", monCmd)
+			}
+			close(state.monitorClosedCh)
+			state.monitorCh = nil
+		case <-ovsCh:
+		case evacuated = <-resultCh:
+			break DONE
+		case <-timeout:
+			cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+		}
+	}
+
+	if evacuated.Movable || evacuated.Restart.InstancePersistence != payloads.Host ||
+		evacuated.Restart.Storage.ID != "" {
+		t.Errorf("Unexpected evacuated instance %v", evacuated)
+	}
+
+	select {
+	case cmd := <-state.ac.cmdCh:
+		t.Errorf("Unexpected command %v", cmd.cmd)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	shutdownInstanceLoop(doneCh, ovsCh, &wg, t)
+	_ = os.RemoveAll(path.Join(instancesDir, cfg.Instance))
+}
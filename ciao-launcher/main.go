@@ -68,6 +68,7 @@ var memLimit bool
 var secretPath string
 var cephID string
 var simulate bool
+var sharedImages bool
 var maxInstances = int(math.MaxInt32)
 
 func init() {
@@ -78,6 +79,7 @@ func init() {
 	flag.BoolVar(&simulate, "simulation", false, "Launcher simulation")
 	flag.StringVar(&secretPath, "ceph_keyring", "", "path to ceph client keyring")
 	flag.StringVar(&cephID, "ceph_id", "", "ceph client id")
+	flag.BoolVar(&sharedImages, "shared_images", false, "Images directory is the ciao-image store shared with the other nodes")
 }
 
 const (
@@ -95,6 +97,10 @@ type cmdWrapper struct {
 	cmd      interface{}
 }
 type statusCmd struct{}
+type evacuateCmd struct {
+	nextState payloads.NodeNextState
+}
+type restoreCmd struct{}
 
 type serverConn interface {
 	SendError(error ssntp.Error, payload []byte) (int, error)
@@ -164,9 +170,13 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			glog.Errorf("Unable to parse YAML: %v", payloadErr.err)
 			return
 		}
-		client.cmdCh <- &cmdWrapper{cfg.Instance, &insStartCmd{cn, md, frame, cfg, time.Now()}}
+		client.cmdCh <- &cmdWrapper{cfg.Instance, &insStartCmd{cn, md, frame, cfg, time.Now(), false}}
 	case ssntp.RESTART:
-		instance, payloadErr := parseRestartPayload(payload)
+		instance, cfg, volumes, payloadErr := parseRestartPayload(payload)
+		var resize *vmResize
+		if payloadErr == nil {
+			resize, payloadErr = parseResizePayload(payload)
+		}
 		if payloadErr != nil {
 			restartError := &restartError{
 				payloadErr.err,
@@ -176,7 +186,7 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			glog.Errorf("Unable to parse YAML: %v", payloadErr.err)
 			return
 		}
-		client.cmdCh <- &cmdWrapper{instance, &insRestartCmd{}}
+		client.cmdCh <- &cmdWrapper{instance, &insRestartCmd{cfg, volumes, resize}}
 	case ssntp.STOP:
 		instance, payloadErr := parseStopPayload(payload)
 		if payloadErr != nil {
@@ -225,11 +235,94 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			return
 		}
 		client.cmdCh <- &cmdWrapper{instance, &insDetachVolumeCmd{volume}}
+	case ssntp.REBOOT:
+		instance, hard, err := parseRebootPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insRebootCmd{hard}}
+	case ssntp.PAUSE, ssntp.SUSPEND:
+		instance, err := parsePausePayload(cmd, payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insPauseCmd{}}
+	case ssntp.UNPAUSE, ssntp.RESUME:
+		instance, err := parsePausePayload(cmd, payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insUnpauseCmd{}}
+	case ssntp.GetConsoleLog:
+		instance, requestID, lines, err := parseConsoleLogPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insConsoleLogCmd{requestID, lines}}
+	case ssntp.CreateImage:
+		instance, imageID, err := parseCreateImagePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insCreateImageCmd{imageID}}
+	case ssntp.MIGRATE:
+		instance, target, err := parseMigratePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insMigrateCmd{target}}
+	case ssntp.MigrateIncoming:
+		cfg, volumes, source, err := parseMigrateIncomingPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{cfg.Instance, &insMigrateIncomingCmd{cfg, volumes, source}}
+	case ssntp.EVACUATE:
+		nextState, err := parseEvacuatePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{"", &evacuateCmd{nextState}}
+	case ssntp.RESTORE:
+		client.cmdCh <- &cmdWrapper{"", &restoreCmd{}}
 	}
 }
 
 func (client *agentClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 	glog.Infof("EVENT %s", event)
+
+	payload := frame.Payload
+
+	switch event {
+	case ssntp.MigrationReady:
+		ready, err := parseMigrationReadyPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		if ready.SourceAgentUUID != client.conn.UUID() {
+			return
+		}
+		client.cmdCh <- &cmdWrapper{ready.InstanceUUID, &insMigrationReadyCmd{ready.URI, ready.DiskURI, ready.Error}}
+	case ssntp.InstanceMigrated:
+		migrated, err := parseInstanceMigratedPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		if migrated.TargetAgentUUID != client.conn.UUID() {
+			return
+		}
+		client.cmdCh <- &cmdWrapper{migrated.InstanceUUID, &insInstanceMigratedCmd{migrated.Error}}
+	}
 }
 
 func (client *agentClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
@@ -267,9 +360,15 @@ func processCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- interface{})
 	case *statusCmd:
 		ovsCh <- &ovsStatsStatusCmd{}
 		return
+	case *evacuateCmd:
+		ovsCh <- &ovsEvacuateCmd{insCmd.nextState}
+		return
+	case *restoreCmd:
+		ovsCh <- &ovsRestoreCmd{}
+		return
 	case *insStartCmd:
 		targetCh := make(chan ovsAddResult)
-		ovsCh <- &ovsAddCmd{cmd.instance, insCmd.cfg, targetCh}
+		ovsCh <- &ovsAddCmd{cmd.instance, insCmd.cfg, targetCh, false}
 		addResult := <-targetCh
 		if !addResult.canAdd {
 			glog.Errorf("Instance will make node full: Disk %d Mem %d CPUs %d",
@@ -300,12 +399,65 @@ func processCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- interface{})
 		}
 	case *insRestartCmd:
 		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil && insCmd.cfg != nil {
+			restartEvacuatedInstance(conn, cmd.instance, insCmd, ovsCh)
+			return
+		}
 		if target == nil {
 			glog.Errorf("Instance %s does not exist", cmd.instance)
 			re := restartError{nil, payloads.RestartNoInstance}
 			re.send(conn, cmd.instance)
 			return
 		}
+	case *insAttachConsoleCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			_ = insCmd.conn.Close()
+			return
+		}
+	case *insConsoleLogCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			sendConsoleLog(conn, cmd.instance, insCmd.requestID, "",
+				fmt.Errorf("Instance not found"))
+			return
+		}
+	case *insCreateImageCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			sendImageCreated(conn, cmd.instance, insCmd.imageID, 0,
+				fmt.Errorf("Instance not found"))
+			return
+		}
+	case *insMigrateCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			sendInstanceMigrated(conn, cmd.instance, insCmd.target,
+				fmt.Errorf("Instance not found"))
+			return
+		}
+	case *insMigrateIncomingCmd:
+		if insCmdChannel(cmd.instance, ovsCh) != nil {
+			glog.Errorf("Instance %s already exists", cmd.instance)
+			sendMigrationReady(conn, cmd.instance, insCmd.source, "", "",
+				fmt.Errorf("Instance already exists on the target node"))
+			return
+		}
+		targetCh := make(chan ovsAddResult)
+		ovsCh <- &ovsAddCmd{cmd.instance, insCmd.cfg, targetCh, true}
+		addResult := <-targetCh
+		if !addResult.canAdd {
+			glog.Errorf("Migrated instance will make node full: Disk %d Mem %d CPUs %d",
+				insCmd.cfg.Disk, insCmd.cfg.Mem, insCmd.cfg.Cpus)
+			sendMigrationReady(conn, cmd.instance, insCmd.source, "", "",
+				fmt.Errorf("Target node is full"))
+			return
+		}
+		target = addResult.cmdCh
 	default:
 		target = insCmdChannel(cmd.instance, ovsCh)
 	}
@@ -424,6 +576,16 @@ DONE:
 			defer shutdownNetwork()
 
 			ovsCh = startOverseer(&wg, client)
+
+			consolePort := clusterConfig.Configure.Launcher.ConsolePort
+			if consolePort != 0 {
+				ln, err := startConsoleServer(cfg, consolePort, client.cmdCh, doneCh)
+				if err != nil {
+					glog.Warningf("Unable to start console server: %v", err)
+				} else {
+					defer func() { _ = ln.Close() }()
+				}
+			}
 		case <-doneCh:
 			client.conn.Close()
 			if !dialing {
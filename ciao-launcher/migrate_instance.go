@@ -0,0 +1,350 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+A live migration involves the launchers of two nodes, coordinated by the
+scheduler:
+
+1. The source node receives a MIGRATE command and describes the instance to
+   the target node in a MigrationPrepare event.
+2. The target node receives the description in a MigrateIncoming command,
+   maps the instance volumes, creates its vnic and rootfs and starts a QEMU
+   process waiting for the instance state, which exports the new rootfs
+   over NBD.  It then sends a MigrationReady event, telling the source node
+   where to copy the rootfs and where to send the state.
+3. The source node mirrors its rootfs to the target node, migrates the
+   instance and reports the outcome in an InstanceMigrated event, sent to
+   the controllers and the target node.  The volumes are shared between the
+   nodes and are not copied.
+4. Whichever node no longer runs the instance stops it, unmaps its volumes
+   and deletes its local copy, without reporting the instance as deleted.
+   The target node stops exporting the rootfs once it runs the instance.
+
+The incoming instance is not reported in the STATS of the target node until
+the migration has succeeded.
+*/
+
+// How long the source node of a migration waits for the target node to be
+// ready.
+const migrationPrepareTimeout = 5 * time.Minute
+
+func processIncoming(instanceDir string, vm virtualizer, conn serverConn, cfg *vmConfig,
+	devices map[string]string, uri string, diskPort int) error {
+	var vnicName string
+	var bridge string
+
+	err := ensureBackingImage(vm)
+	if err != nil {
+		return err
+	}
+
+	if networking {
+		vnicCfg, err := createVnicCfg(cfg)
+		if err != nil {
+			glog.Errorf("Could not create VnicCFG: %s", err)
+			return err
+		}
+		vnicName, bridge, err = createVnic(conn, vnicCfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = createInstance(vm, instanceDir, cfg, bridge, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return vm.startIncomingVM(vnicName, getNodeIPAddress(), uri, diskPort, devices)
+}
+
+func (id *instanceData) migrateCommand(cmd *insMigrateCmd) {
+	var err error
+
+	if id.cfg.Container {
+		err = errors.New("Only VMs can be migrated")
+	} else if id.shuttingDown || id.incoming {
+		err = errors.New("Instance not found")
+	} else if id.monitorCh == nil || id.paused {
+		err = errors.New("Instance not running")
+	} else if id.migrationTarget != "" {
+		err = errors.New("Migration already in progress")
+	} else if id.snapshotCh != nil {
+		err = errors.New("Image creation in progress")
+	}
+	if err != nil {
+		glog.Errorf("Unable to migrate %s: %v", id.instance, err)
+		sendInstanceMigrated(id.ac.conn, id.instance, cmd.target, err)
+		return
+	}
+
+	glog.Infof("Preparing migration of %s to %s", id.instance, cmd.target)
+
+	id.migrationTarget = cmd.target
+	id.migrationTimer = time.After(migrationPrepareTimeout)
+	sendMigrationPrepare(id.ac.conn, &payloads.MigrateIncomingCmd{
+		SourceAgentUUID: id.ac.conn.UUID(),
+		TargetAgentUUID: cmd.target,
+		Instance:        id.cfg.restartCmd(id.getVolumes()),
+	})
+}
+
+func (id *instanceData) migrationReadyCommand(cmd *insMigrationReadyCmd) {
+	if id.migrationTarget == "" || id.migrationCh != nil {
+		glog.Warningf("Unexpected MigrationReady for %s", id.instance)
+		return
+	}
+
+	id.migrationTimer = nil
+
+	if cmd.err != "" {
+		id.migrationDone(errors.New(cmd.err))
+		return
+	}
+
+	glog.Infof("Migrating %s to %s", id.instance, cmd.uri)
+
+	migrationCh := make(chan error, 1)
+	id.migrationCh = migrationCh
+	id.monitorCh <- virtualizerMigrateCmd{migrationCh, cmd.uri, cmd.diskURI}
+}
+
+// migrationDone reports the outcome of a migration from this node.  The
+// instance is stopped and deleted once it runs on the target node.
+func (id *instanceData) migrationDone(err error) {
+	target := id.migrationTarget
+	id.migrationTarget = ""
+	id.migrationCh = nil
+	id.migrationTimer = nil
+
+	sendInstanceMigrated(id.ac.conn, id.instance, target, err)
+
+	if err != nil {
+		glog.Errorf("Unable to migrate %s to %s: %v", id.instance, target, err)
+		return
+	}
+
+	glog.Infof("Instance %s migrated to %s", id.instance, target)
+	id.stopMigratedInstance()
+}
+
+func (id *instanceData) migrationTimedOut() {
+	id.migrationTimer = nil
+
+	if id.incoming {
+		glog.Errorf("Timed out waiting for instance %s to be migrated", id.instance)
+		id.stopMigratedInstance()
+		return
+	}
+
+	id.migrationDone(errors.New("Timed out waiting for the target node"))
+}
+
+// prepareIncoming maps the volumes of an instance migrated to this node and
+// starts the QEMU process receiving its state, returning the URI on which
+// the state is to be sent and the URI of the NBD export its rootfs is to be
+// mirrored to.
+func (id *instanceData) prepareIncoming(volumes []string) (string, string, error) {
+	if id.cfg.Container {
+		return "", "", errors.New("Only VMs can be migrated")
+	}
+
+	devices := make(map[string]string)
+	for _, volume := range volumes {
+		device, err := id.storageDriver.MapVolumeToNode(volume)
+		if err != nil {
+			return "", "", fmt.Errorf("Unable to map volume %s: %v", volume, err)
+		}
+		devices[volume] = device
+		id.cfg.Volumes[volume] = struct{}{}
+	}
+
+	port := migrationPortGrabber.grabPort()
+	if port == 0 {
+		return "", "", errors.New("No port available for the migration")
+	}
+	id.migrationPort = port
+
+	diskPort := migrationPortGrabber.grabPort()
+	if diskPort == 0 {
+		return "", "", errors.New("No port available for the migration")
+	}
+	id.diskPort = diskPort
+
+	err := processIncoming(id.instanceDir, id.vm, id.ac.conn, id.cfg, devices,
+		fmt.Sprintf("tcp:0:%d", port), diskPort)
+	if err != nil {
+		return "", "", err
+	}
+
+	uri := fmt.Sprintf("tcp:%s:%d", getNodeIPAddress(), port)
+	diskURI := fmt.Sprintf("nbd:%s:%d:exportname=%s", getNodeIPAddress(), diskPort, rootfsDrive)
+	return uri, diskURI, nil
+}
+
+func (id *instanceData) migrateIncomingCommand(cmd *insMigrateIncomingCmd) {
+	glog.Infof("Preparing migration of %s from %s", id.instance, cmd.source)
+
+	id.incoming = true
+	id.migrationSource = cmd.source
+
+	uri, diskURI, err := id.prepareIncoming(cmd.volumes)
+	if err != nil {
+		glog.Errorf("Unable to prepare migration of %s: %v", id.instance, err)
+		sendMigrationReady(id.ac.conn, id.instance, cmd.source, "", "", err)
+		id.discardMigratedInstance()
+		return
+	}
+
+	id.connectedCh = make(chan struct{})
+	id.monitorCloseCh = make(chan struct{})
+	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, &id.instanceWg, false)
+	id.migrationTimer = time.After(migrationPrepareTimeout + migrationTimeout)
+	id.ovsCh <- &ovsStatusCmd{}
+
+	sendMigrationReady(id.ac.conn, id.instance, cmd.source, uri, diskURI, nil)
+}
+
+func (id *instanceData) instanceMigratedCommand(cmd *insInstanceMigratedCmd) {
+	if !id.incoming {
+		glog.Warningf("Unexpected InstanceMigrated for %s", id.instance)
+		return
+	}
+
+	if cmd.err != "" {
+		glog.Errorf("Migration of %s from %s failed: %s", id.instance, id.migrationSource, cmd.err)
+		id.stopMigratedInstance()
+		return
+	}
+
+	glog.Infof("Instance %s migrated from %s", id.instance, id.migrationSource)
+	id.monitorCh <- virtualizerStopDiskExportCmd{}
+	id.endIncoming()
+	id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
+}
+
+func (id *instanceData) endIncoming() {
+	if id.migrationPort != 0 {
+		migrationPortGrabber.releasePort(id.migrationPort)
+		id.migrationPort = 0
+	}
+	if id.diskPort != 0 {
+		migrationPortGrabber.releasePort(id.diskPort)
+		id.diskPort = 0
+	}
+	id.incoming = false
+	id.migrationSource = ""
+	id.migrationTimer = nil
+}
+
+// stopMigratedInstance stops the copy of an instance which now runs on
+// another node, or which failed to migrate to this node.  The copy is
+// deleted once its VM has exited.
+func (id *instanceData) stopMigratedInstance() {
+	if id.monitorCh == nil {
+		id.discardMigratedInstance()
+		return
+	}
+
+	id.handingOver = true
+	id.monitorCh <- virtualizerStopCmd{}
+}
+
+func (id *instanceData) discardMigratedInstance() {
+	id.endIncoming()
+	id.handingOver = false
+	id.handOver(id.getVolumes())
+}
+
+func sendMigrationPrepare(conn serverConn, cmd *payloads.MigrateIncomingCmd) {
+	event := payloads.EventMigrationPrepare{
+		MigrationPrepare: *cmd,
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall MigrationPrepare %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.MigrationPrepare, payload)
+	if err != nil {
+		glog.Errorf("Failed to send MigrationPrepare event %v", err)
+	}
+}
+
+func sendMigrationReady(conn serverConn, instance, source, uri, diskURI string, readyErr error) {
+	event := payloads.EventMigrationReady{
+		MigrationReady: payloads.MigrationReadyEvent{
+			InstanceUUID:    instance,
+			SourceAgentUUID: source,
+			TargetAgentUUID: conn.UUID(),
+			URI:             uri,
+			DiskURI:         diskURI,
+		},
+	}
+	if readyErr != nil {
+		event.MigrationReady.Error = readyErr.Error()
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall MigrationReady %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.MigrationReady, payload)
+	if err != nil {
+		glog.Errorf("Failed to send MigrationReady event %v", err)
+	}
+}
+
+func sendInstanceMigrated(conn serverConn, instance, target string, migrateErr error) {
+	event := payloads.EventInstanceMigrated{
+		InstanceMigrated: payloads.InstanceMigratedEvent{
+			InstanceUUID:    instance,
+			SourceAgentUUID: conn.UUID(),
+			TargetAgentUUID: target,
+		},
+	}
+	if migrateErr != nil {
+		event.InstanceMigrated.Error = migrateErr.Error()
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall InstanceMigrated %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.InstanceMigrated, payload)
+	if err != nil {
+		glog.Errorf("Failed to send InstanceMigrated event %v", err)
+	}
+}
@@ -44,6 +44,7 @@ type ovsAddCmd struct {
 	instance string
 	cfg      *vmConfig
 	targetCh chan<- ovsAddResult
+	incoming bool
 }
 
 type ovsGetResult struct {
@@ -67,6 +68,13 @@ type ovsStateChange struct {
 	state    ovsRunningState
 }
 
+type ovsResizeCmd struct {
+	instance string
+	cpus     int
+	mem      int
+	disk     int
+}
+
 type ovsStatsUpdateCmd struct {
 	instance      string
 	memoryUsageMB int
@@ -81,6 +89,10 @@ type ovsTraceFrame struct {
 
 type ovsStatusCmd struct{}
 type ovsStatsStatusCmd struct{}
+type ovsEvacuateCmd struct {
+	nextState payloads.NodeNextState
+}
+type ovsRestoreCmd struct{}
 
 type ovsRunningState int
 
@@ -88,6 +100,11 @@ const (
 	ovsPending ovsRunningState = iota
 	ovsRunning
 	ovsStopped
+	ovsPaused
+
+	// ovsIncoming instances are being live migrated to this node.  They
+	// are not reported in the STATS until the migration has succeeded.
+	ovsIncoming
 )
 
 const (
@@ -130,6 +147,7 @@ type overseer struct {
 	stat               string
 	loadavg            string
 	statsInterval      time.Duration
+	nextState          payloads.NodeNextState // set once evacuating
 }
 
 type cnStats struct {
@@ -287,6 +305,11 @@ func getLoadAvg() int {
 
 func (ovs *overseer) roomAvailable(cfg *vmConfig) bool {
 
+	if ovs.nextState != "" {
+		glog.Warning("Node is being evacuated")
+		return false
+	}
+
 	if len(ovs.instances) >= maxInstances {
 		glog.Warningf("We're FULL.  Too many instances %d", len(ovs.instances))
 		return false
@@ -343,6 +366,10 @@ func (ovs *overseer) updateAvailableResources(cns *cnStats) {
 
 func (ovs *overseer) computeStatus() ssntp.Status {
 
+	if ovs.nextState != "" {
+		return ssntp.MAINTENANCE
+	}
+
 	if len(ovs.instances) >= maxInstances {
 		return ssntp.FULL
 	}
@@ -405,12 +432,18 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 	s.Instances = make([]payloads.InstanceStat, len(ovs.instances))
 	i := 0
 	for uuid, state := range ovs.instances {
+		if state.running == ovsIncoming {
+			continue
+		}
 		s.Instances[i].InstanceUUID = uuid
-		if state.running == ovsRunning {
+		switch state.running {
+		case ovsRunning:
 			s.Instances[i].State = payloads.Running
-		} else if state.running == ovsStopped {
+		case ovsStopped:
 			s.Instances[i].State = payloads.Exited
-		} else {
+		case ovsPaused:
+			s.Instances[i].State = payloads.Paused
+		default:
 			s.Instances[i].State = payloads.Pending
 		}
 		s.Instances[i].MemoryUsageMB = state.memoryUsageMB
@@ -421,6 +454,7 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 		s.Instances[i].Volumes = state.volumes
 		i++
 	}
+	s.Instances = s.Instances[:i]
 
 	payload, err := yaml.Marshal(&s)
 	if err != nil {
@@ -522,9 +556,13 @@ func (ovs *overseer) processAddCommand(cmd *ovsAddCmd) {
 		ovs.memoryAllocated += cfg.Mem
 		targetCh = startInstance(cmd.instance, cfg, ovs.childWg, ovs.childDoneCh,
 			ovs.ac, ovs.ovsInstanceCh)
+		running := ovsPending
+		if cmd.incoming {
+			running = ovsIncoming
+		}
 		ovs.instances[cmd.instance] = &ovsInstanceState{
 			cmdCh:          targetCh,
-			running:        ovsPending,
+			running:        running,
 			diskUsageMB:    -1,
 			CPUUsage:       -1,
 			memoryUsageMB:  -1,
@@ -592,6 +630,46 @@ func (ovs *overseer) processStatsStatusCommand(cmd *ovsStatsStatusCmd) {
 	ovs.sendStats(cns, status)
 }
 
+func (ovs *overseer) processEvacuateCommand(cmd *ovsEvacuateCmd) {
+	glog.Infof("Overseer: Received Evacuate Command, next state %s", cmd.nextState)
+	if ovs.nextState != "" {
+		glog.Warning("Node is already being evacuated")
+		return
+	}
+	ovs.nextState = cmd.nextState
+
+	targets := make([]chan<- interface{}, 0, len(ovs.instances))
+	for _, target := range ovs.instances {
+		targets = append(targets, target.cmdCh)
+	}
+
+	ovs.childWg.Add(1)
+	go evacuateInstances(ovs.ac.conn, cmd.nextState, targets, ovs.childDoneCh, ovs.childWg)
+
+	if !ovs.ac.conn.isConnected() {
+		return
+	}
+	cns := getStats(ovs.instancesDir)
+	ovs.updateAvailableResources(cns)
+	ovs.sendStatusCommand(cns, ovs.computeStatus())
+}
+
+func (ovs *overseer) processRestoreCommand(cmd *ovsRestoreCmd) {
+	glog.Info("Overseer: Received Restore Command")
+	if ovs.nextState != payloads.NodeMaintenance {
+		glog.Warningf("Node cannot be restored, next state %q", ovs.nextState)
+		return
+	}
+	ovs.nextState = ""
+
+	if !ovs.ac.conn.isConnected() {
+		return
+	}
+	cns := getStats(ovs.instancesDir)
+	ovs.updateAvailableResources(cns)
+	ovs.sendStatusCommand(cns, ovs.computeStatus())
+}
+
 func (ovs *overseer) processStateChangeCommand(cmd *ovsStateChange) {
 	glog.Infof("Overseer: Received State Change %v", *cmd)
 	target := ovs.instances[cmd.instance]
@@ -600,6 +678,22 @@ func (ovs *overseer) processStateChangeCommand(cmd *ovsStateChange) {
 	}
 }
 
+func (ovs *overseer) processResizeCommand(cmd *ovsResizeCmd) {
+	glog.Infof("Overseer: resizing %s", cmd.instance)
+	target := ovs.instances[cmd.instance]
+	if target == nil {
+		return
+	}
+
+	ovs.vcpusAllocated += cmd.cpus - target.maxVCPUs
+	ovs.memoryAllocated += cmd.mem - target.maxMemoryMB
+	ovs.diskSpaceAllocated += cmd.disk - target.maxDiskUsageMB
+
+	target.maxVCPUs = cmd.cpus
+	target.maxMemoryMB = cmd.mem
+	target.maxDiskUsageMB = cmd.disk
+}
+
 func (ovs *overseer) processStatusUpdateCommand(cmd *ovsStatsUpdateCmd) {
 	if glog.V(1) {
 		glog.Infof("STATS Update for %s: Mem %d Disk %d Cpu %d",
@@ -632,8 +726,14 @@ func (ovs *overseer) processCommand(cmd interface{}) {
 		ovs.processStatusCommand(cmd)
 	case *ovsStatsStatusCmd:
 		ovs.processStatsStatusCommand(cmd)
+	case *ovsEvacuateCmd:
+		ovs.processEvacuateCommand(cmd)
+	case *ovsRestoreCmd:
+		ovs.processRestoreCommand(cmd)
 	case *ovsStateChange:
 		ovs.processStateChangeCommand(cmd)
+	case *ovsResizeCmd:
+		ovs.processResizeCommand(cmd)
 	case *ovsStatsUpdateCmd:
 		ovs.processStatusUpdateCommand(cmd)
 	case *ovsTraceFrame:
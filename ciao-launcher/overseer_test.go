@@ -809,3 +809,55 @@ func TestStateChange(t *testing.T) {
 	shutdownOverseer(ovsCh, state)
 	wg.Wait()
 }
+
+// Check that instances being migrated to the node are not reported.
+//
+// Start the overseer, add an instance, set the instances state to
+// incoming and then issue a statsStatusCommand.
+//
+// A stats command without instances should be received.
+func TestIncomingState(t *testing.T) {
+	diskLimit = false
+	memLimit = false
+
+	instancesDir, err := ioutil.TempDir("", "overseer-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(instancesDir) }()
+
+	pp, err := createGoodProcFiles()
+	if err != nil {
+		t.Fatalf("Unable to create proc files")
+	}
+	defer func() { _ = os.RemoveAll(pp.procDir) }()
+
+	var wg sync.WaitGroup
+	state := &overseerTestState{
+		t:       t,
+		statsCh: make(chan *payloads.Stat),
+	}
+	state.ac = &agentClient{conn: state, cmdCh: make(chan *cmdWrapper)}
+
+	ovsCh := startOverseerFull(instancesDir, &wg, state.ac, time.Second*1000,
+		pp.memInfo, pp.stat, pp.loadavg)
+
+	_ = addInstance(t, ovsCh, state, false)
+
+	select {
+	case ovsCh <- &ovsStateChange{
+		instance: "test-instance",
+		state:    ovsIncoming,
+	}:
+	case <-time.After(time.Second):
+		t.Fatal("Unable to send ovsStateChange")
+	}
+
+	_, stats := getStatusStats(t, ovsCh, state)
+	if len(stats.Instances) != 0 {
+		t.Errorf("Zero instances expected.  Found %d", len(stats.Instances))
+	}
+
+	shutdownOverseer(ovsCh, state)
+	wg.Wait()
+}
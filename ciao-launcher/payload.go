@@ -26,6 +26,7 @@ import (
 
 	"github.com/01org/ciao/networking/libsnnet"
 	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
 	"gopkg.in/yaml.v2"
 )
@@ -107,7 +108,11 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 	}
 	printCloudinit(&clouddata)
 
-	start := &clouddata.Start
+	return parseStartCmd(&clouddata.Start)
+}
+
+func parseStartCmd(start *payloads.StartCmd) (*vmConfig, *payloadError) {
+	var err error
 
 	instance := strings.TrimSpace(start.InstanceUUID)
 	if !uuidRegexp.MatchString(instance) {
@@ -156,6 +161,11 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 	vnicIP := strings.TrimSpace(net.PrivateIP)
 	sshPort := computeSSHPort(networkNode, vnicIP)
 
+	var bootVolume string
+	if start.Storage.Bootable {
+		bootVolume = strings.TrimSpace(start.Storage.ID)
+	}
+
 	return &vmConfig{Cpus: cpus,
 		Mem:         mem,
 		Disk:        disk,
@@ -173,6 +183,8 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 		VnicUUID:    strings.TrimSpace(net.VnicUUID),
 		SSHPort:     sshPort,
 		Volumes:     make(map[string]struct{}),
+		Persistence: start.InstancePersistence,
+		BootVolume:  bootVolume,
 	}, nil
 }
 
@@ -255,20 +267,102 @@ func generateNetEventPayload(ssntpEvent *libsnnet.SsntpEventInfo, agentUUID stri
 	return yaml.Marshal(event)
 }
 
-func parseRestartPayload(data []byte) (string, *payloadError) {
+// parseRestartPayload returns the configuration and volumes of the instance
+// to restart when the payload carries its full description, i.e. when the
+// instance was evacuated from another node.
+func parseRestartPayload(data []byte) (string, *vmConfig, []string, *payloadError) {
 	var clouddata payloads.Restart
 
 	err := yaml.Unmarshal(data, &clouddata)
 	if err != nil {
-		return "", &payloadError{err, payloads.RestartInvalidPayload}
+		return "", nil, nil, &payloadError{err, payloads.RestartInvalidPayload}
 	}
 
-	instance := strings.TrimSpace(clouddata.Restart.InstanceUUID)
+	return parseRestartCmd(&clouddata.Restart)
+}
+
+func parseRestartCmd(restart *payloads.RestartCmd) (string, *vmConfig, []string, *payloadError) {
+	instance := strings.TrimSpace(restart.InstanceUUID)
 	if !uuidRegexp.MatchString(instance) {
-		err = fmt.Errorf("Invalid instance id received: %s", instance)
-		return "", &payloadError{err, payloads.RestartInvalidData}
+		err := fmt.Errorf("Invalid instance id received: %s", instance)
+		return "", nil, nil, &payloadError{err, payloads.RestartInvalidData}
 	}
-	return instance, nil
+
+	if restart.VMType == "" {
+		return instance, nil, nil, nil
+	}
+
+	cfg, payloadErr := parseStartCmd(&payloads.StartCmd{
+		TenantUUID:          restart.TenantUUID,
+		InstanceUUID:        restart.InstanceUUID,
+		ImageUUID:           restart.ImageUUID,
+		DockerImage:         restart.DockerImage,
+		FWType:              restart.FWType,
+		InstancePersistence: restart.InstancePersistence,
+		VMType:              restart.VMType,
+		RequestedResources:  restart.RequestedResources,
+		Networking:          restart.Networking,
+		Storage:             restart.Storage,
+	})
+	if payloadErr != nil {
+		return "", nil, nil, &payloadError{payloadErr.err, payloads.RestartInvalidData}
+	}
+
+	return instance, cfg, restart.Volumes, nil
+}
+
+// parseResizePayload returns the new resources of an instance resized by a
+// RESTART command, or nil if the command does not resize the instance.
+func parseResizePayload(data []byte) (*vmResize, *payloadError) {
+	var clouddata payloads.Restart
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return nil, &payloadError{err, payloads.RestartInvalidPayload}
+	}
+
+	restart := &clouddata.Restart
+	if !restart.Resize {
+		return nil, nil
+	}
+
+	res := &vmResize{relocate: restart.Relocate}
+	for _, r := range restart.RequestedResources {
+		switch r.Type {
+		case payloads.VCPUs:
+			res.cpus = r.Value
+		case payloads.MemMB:
+			res.mem = r.Value
+		case payloads.DiskMB:
+			res.disk = r.Value
+		}
+	}
+
+	if res.cpus <= 0 || res.mem <= 0 {
+		err = fmt.Errorf("Invalid resources for resized instance: vcpus %d mem_mb %d",
+			res.cpus, res.mem)
+		return nil, &payloadError{err, payloads.RestartInvalidData}
+	}
+
+	return res, nil
+}
+
+func parseEvacuatePayload(data []byte) (payloads.NodeNextState, error) {
+	var clouddata payloads.Evacuate
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return "", err
+	}
+
+	switch clouddata.Evacuate.NextState {
+	case "":
+		return payloads.NodeMaintenance, nil
+	case payloads.NodeMaintenance, payloads.NodeShutdown:
+		return clouddata.Evacuate.NextState, nil
+	}
+
+	return "", fmt.Errorf("Invalid next state received: %s", clouddata.Evacuate.NextState)
 }
 
 func parseDeletePayload(data []byte) (string, *payloadError) {
@@ -343,6 +437,64 @@ func parseDetachVolumePayload(data []byte) (string, string, *payloadError) {
 	return extractVolumeInfo(&clouddata.Detach, payloads.DetachVolumeInvalidData)
 }
 
+func parseRebootPayload(data []byte) (string, bool, error) {
+	var clouddata payloads.Reboot
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return "", false, err
+	}
+
+	instance := strings.TrimSpace(clouddata.Reboot.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", false, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	switch clouddata.Reboot.Type {
+	case payloads.RebootSoft:
+		return instance, false, nil
+	case payloads.RebootHard:
+		return instance, true, nil
+	}
+
+	return "", false, fmt.Errorf("Invalid reboot type received: %s", clouddata.Reboot.Type)
+}
+
+// parsePausePayload parses PAUSE, UNPAUSE, SUSPEND and RESUME payloads
+func parsePausePayload(cmd ssntp.Command, data []byte) (string, error) {
+	var err error
+	var stop payloads.StopCmd
+
+	switch cmd {
+	case ssntp.PAUSE:
+		var clouddata payloads.Pause
+		err = yaml.Unmarshal(data, &clouddata)
+		stop = clouddata.Pause
+	case ssntp.UNPAUSE:
+		var clouddata payloads.Unpause
+		err = yaml.Unmarshal(data, &clouddata)
+		stop = clouddata.Unpause
+	case ssntp.SUSPEND:
+		var clouddata payloads.Suspend
+		err = yaml.Unmarshal(data, &clouddata)
+		stop = clouddata.Suspend
+	case ssntp.RESUME:
+		var clouddata payloads.Resume
+		err = yaml.Unmarshal(data, &clouddata)
+		stop = clouddata.Resume
+	}
+	if err != nil {
+		return "", err
+	}
+
+	instance := strings.TrimSpace(stop.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	return instance, nil
+}
+
 func linesToBytes(doc []string, buf *bytes.Buffer) {
 	for _, line := range doc {
 		_, _ = buf.WriteString(line)
@@ -508,3 +660,117 @@ func splitYaml(data []byte) ([]byte, []byte, []byte) {
 
 	return s.Bytes(), ci.Bytes(), md.Bytes()
 }
+
+func parseConsoleLogPayload(data []byte) (string, string, int, error) {
+	var clouddata payloads.GetConsoleLog
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	instance := strings.TrimSpace(clouddata.GetConsoleLog.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", "", 0, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	return instance, clouddata.GetConsoleLog.RequestID, clouddata.GetConsoleLog.Lines, nil
+}
+
+func parseCreateImagePayload(data []byte) (string, string, error) {
+	var clouddata payloads.CreateImage
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return "", "", err
+	}
+
+	instance := strings.TrimSpace(clouddata.CreateImage.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", "", fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	imageID := strings.TrimSpace(clouddata.CreateImage.ImageUUID)
+	if !uuidRegexp.MatchString(imageID) {
+		return "", "", fmt.Errorf("Invalid image id received: %s", imageID)
+	}
+
+	return instance, imageID, nil
+}
+
+func parseMigratePayload(data []byte) (string, string, error) {
+	var clouddata payloads.Migrate
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return "", "", err
+	}
+
+	instance := strings.TrimSpace(clouddata.Migrate.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return "", "", fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	target := strings.TrimSpace(clouddata.Migrate.TargetAgentUUID)
+	if target == "" {
+		return "", "", fmt.Errorf("No target node received for %s", instance)
+	}
+
+	return instance, target, nil
+}
+
+// parseMigrateIncomingPayload returns the configuration and volumes of an
+// instance migrated to this node, along with the node it is migrated from.
+func parseMigrateIncomingPayload(data []byte) (*vmConfig, []string, string, error) {
+	var clouddata payloads.MigrateIncoming
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	incoming := &clouddata.MigrateIncoming
+	_, cfg, volumes, payloadErr := parseRestartCmd(&incoming.Instance)
+	if payloadErr != nil {
+		return nil, nil, "", payloadErr.err
+	}
+
+	if cfg == nil {
+		return nil, nil, "", fmt.Errorf("Missing description of instance %s",
+			incoming.Instance.InstanceUUID)
+	}
+
+	return cfg, volumes, strings.TrimSpace(incoming.SourceAgentUUID), nil
+}
+
+func parseMigrationReadyPayload(data []byte) (*payloads.MigrationReadyEvent, error) {
+	var clouddata payloads.EventMigrationReady
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.MigrationReady.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	return &clouddata.MigrationReady, nil
+}
+
+func parseInstanceMigratedPayload(data []byte) (*payloads.InstanceMigratedEvent, error) {
+	var clouddata payloads.EventInstanceMigrated
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := strings.TrimSpace(clouddata.InstanceMigrated.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		return nil, fmt.Errorf("Invalid instance id received: %s", instance)
+	}
+
+	return &clouddata.InstanceMigrated, nil
+}
@@ -17,10 +17,12 @@
 package main
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
 )
 
 func TestParseAttachVolumePayload(t *testing.T) {
@@ -62,3 +64,218 @@ func TestParseDetachVolumePayload(t *testing.T) {
 		t.Fatalf("DetachVolumeInvalidData error expected")
 	}
 }
+
+func TestParseRestartPayload(t *testing.T) {
+	instance, cfg, volumes, err := parseRestartPayload([]byte(testutil.RestartYaml))
+	if err != nil {
+		t.Fatalf("parseRestartPayload failed: %v", err)
+	}
+	if instance != testutil.InstanceUUID || cfg != nil || volumes != nil {
+		t.Fatalf("Unexpected instance description in RESTART payload")
+	}
+
+	_, _, _, err = parseRestartPayload([]byte("  -"))
+	if err == nil || err.code != payloads.RestartInvalidPayload {
+		t.Fatalf("RestartInvalidPayload error expected")
+	}
+}
+
+func TestParseResizePayload(t *testing.T) {
+	resize, err := parseResizePayload([]byte(testutil.RestartYaml))
+	if err != nil || resize != nil {
+		t.Fatalf("RESTART payload should not resize instance: %v %v", resize, err)
+	}
+
+	resize, err = parseResizePayload([]byte(testutil.ResizeRestartYaml))
+	if err != nil {
+		t.Fatalf("parseResizePayload failed: %v", err)
+	}
+	expected := vmResize{cpus: 4, mem: 8192, disk: 20000}
+	if resize == nil || *resize != expected {
+		t.Fatalf("Unexpected resources %v, expected %v", resize, expected)
+	}
+
+	resize, err = parseResizePayload([]byte(testutil.ResizeRestartYaml + "  relocate: true\n"))
+	if err != nil || resize == nil || !resize.relocate {
+		t.Fatalf("Relocation expected: %v %v", resize, err)
+	}
+
+	_, err = parseResizePayload([]byte(testutil.PartialRestartYaml + "  resize: true\n"))
+	if err == nil || err.code != payloads.RestartInvalidData {
+		t.Fatalf("RestartInvalidData error expected")
+	}
+}
+
+func TestParseEvacuatedRestartPayload(t *testing.T) {
+	evacuated := standardCfg
+	evacuated.Instance = testutil.InstanceUUID
+	evacuated.SSHPort = computeSSHPort(false, evacuated.VnicIP)
+	evacuated.Volumes = make(map[string]struct{})
+	evacuated.Persistence = payloads.VM
+	evacuated.BootVolume = testutil.BootVolumeUUID
+
+	restart := payloads.Restart{
+		Restart: evacuated.restartCmd([]string{testutil.VolumeUUID}),
+	}
+	y, yerr := yaml.Marshal(&restart)
+	if yerr != nil {
+		t.Fatal(yerr)
+	}
+
+	instance, cfg, volumes, err := parseRestartPayload(y)
+	if err != nil {
+		t.Fatalf("parseRestartPayload failed: %v", err)
+	}
+	if instance != testutil.InstanceUUID {
+		t.Fatalf("Invalid InstanceUUID %s", instance)
+	}
+	if cfg == nil || !reflect.DeepEqual(*cfg, evacuated) {
+		t.Fatalf("Evacuated instance configuration mismatch: %v vs %v", cfg, evacuated)
+	}
+	if len(volumes) != 1 || volumes[0] != testutil.VolumeUUID {
+		t.Fatalf("Evacuated instance volumes mismatch: %v", volumes)
+	}
+}
+
+func TestParseEvacuatePayload(t *testing.T) {
+	nextState, err := parseEvacuatePayload([]byte(testutil.EvacuateYaml))
+	if err != nil || nextState != payloads.NodeMaintenance {
+		t.Fatalf("Maintenance next state expected: %s %v", nextState, err)
+	}
+
+	nextState, err = parseEvacuatePayload([]byte(testutil.EvacuateYaml + "  next_state: shutdown\n"))
+	if err != nil || nextState != payloads.NodeShutdown {
+		t.Fatalf("Shutdown next state expected: %s %v", nextState, err)
+	}
+
+	_, err = parseEvacuatePayload([]byte(testutil.EvacuateYaml + "  next_state: reboot\n"))
+	if err == nil {
+		t.Fatalf("Invalid next state accepted")
+	}
+}
+
+func TestParseConsoleLogPayload(t *testing.T) {
+	instance, requestID, lines, err := parseConsoleLogPayload([]byte(testutil.GetConsoleLogYaml))
+	if err != nil {
+		t.Fatalf("parseConsoleLogPayload failed: %v", err)
+	}
+	if instance != testutil.InstanceUUID || requestID != testutil.RequestID || lines != 50 {
+		t.Fatalf("Unexpected GET_CONSOLE_LOG payload")
+	}
+
+	_, _, _, err = parseConsoleLogPayload([]byte("  -"))
+	if err == nil {
+		t.Fatalf("Error expected for invalid payload")
+	}
+}
+
+func TestParseCreateImagePayload(t *testing.T) {
+	instance, imageID, err := parseCreateImagePayload([]byte(testutil.CreateImageYaml))
+	if err != nil {
+		t.Fatalf("parseCreateImagePayload failed: %v", err)
+	}
+	if instance != testutil.InstanceUUID || imageID != testutil.ImageUUID {
+		t.Fatalf("Unexpected CreateImage payload")
+	}
+
+	_, _, err = parseCreateImagePayload([]byte("  -"))
+	if err == nil {
+		t.Fatalf("Error expected for invalid payload")
+	}
+}
+
+func TestParseMigratePayload(t *testing.T) {
+	_, _, err := parseMigratePayload([]byte(testutil.MigrateYaml))
+	if err == nil {
+		t.Fatalf("Error expected for MIGRATE without target node")
+	}
+
+	var cmd payloads.Migrate
+	err = yaml.Unmarshal([]byte(testutil.MigrateYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Migrate.TargetAgentUUID = testutil.TargetAgentUUID
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, target, err := parseMigratePayload(y)
+	if err != nil {
+		t.Fatalf("parseMigratePayload failed: %v", err)
+	}
+	if instance != testutil.InstanceUUID || target != testutil.TargetAgentUUID {
+		t.Fatalf("Unexpected MIGRATE payload: %s %s", instance, target)
+	}
+}
+
+func TestParseMigrateIncomingPayload(t *testing.T) {
+	migrated := standardCfg
+	migrated.Instance = testutil.InstanceUUID
+	migrated.SSHPort = computeSSHPort(false, migrated.VnicIP)
+	migrated.Volumes = make(map[string]struct{})
+	migrated.Persistence = payloads.Host
+
+	cmd := payloads.MigrateIncoming{
+		MigrateIncoming: payloads.MigrateIncomingCmd{
+			SourceAgentUUID: testutil.AgentUUID,
+			TargetAgentUUID: testutil.TargetAgentUUID,
+			Instance:        migrated.restartCmd([]string{testutil.VolumeUUID}),
+		},
+	}
+	y, yerr := yaml.Marshal(&cmd)
+	if yerr != nil {
+		t.Fatal(yerr)
+	}
+
+	cfg, volumes, source, err := parseMigrateIncomingPayload(y)
+	if err != nil {
+		t.Fatalf("parseMigrateIncomingPayload failed: %v", err)
+	}
+	if !reflect.DeepEqual(*cfg, migrated) {
+		t.Fatalf("Migrated instance configuration mismatch: %v vs %v", cfg, migrated)
+	}
+	if len(volumes) != 1 || volumes[0] != testutil.VolumeUUID {
+		t.Fatalf("Migrated instance volumes mismatch: %v", volumes)
+	}
+	if source != testutil.AgentUUID {
+		t.Fatalf("Unexpected source node %s", source)
+	}
+
+	cmd.MigrateIncoming.Instance = payloads.RestartCmd{InstanceUUID: testutil.InstanceUUID}
+	y, yerr = yaml.Marshal(&cmd)
+	if yerr != nil {
+		t.Fatal(yerr)
+	}
+	_, _, _, err = parseMigrateIncomingPayload(y)
+	if err == nil {
+		t.Fatalf("Error expected for instance without description")
+	}
+}
+
+func TestParseMigrationEvents(t *testing.T) {
+	ready, err := parseMigrationReadyPayload([]byte(testutil.MigrationReadyYaml))
+	if err != nil {
+		t.Fatalf("parseMigrationReadyPayload failed: %v", err)
+	}
+	if ready.InstanceUUID != testutil.InstanceUUID || ready.SourceAgentUUID != testutil.AgentUUID ||
+		ready.URI != "tcp:192.168.0.2:49152" ||
+		ready.DiskURI != "nbd:192.168.0.2:49153:exportname=virtio0" {
+		t.Fatalf("Unexpected MigrationReady payload %v", ready)
+	}
+
+	migrated, err := parseInstanceMigratedPayload([]byte(testutil.InstanceMigratedYaml))
+	if err != nil {
+		t.Fatalf("parseInstanceMigratedPayload failed: %v", err)
+	}
+	if migrated.InstanceUUID != testutil.InstanceUUID ||
+		migrated.TargetAgentUUID != testutil.TargetAgentUUID || migrated.Error != "" {
+		t.Fatalf("Unexpected InstanceMigrated payload %v", migrated)
+	}
+
+	_, err = parseInstanceMigratedPayload([]byte("  -"))
+	if err == nil {
+		t.Fatalf("Error expected for invalid payload")
+	}
+}
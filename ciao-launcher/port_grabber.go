@@ -25,6 +25,9 @@ import (
 const (
 	portGrabberStart = 5900
 	portGrabberMax   = 6900
+
+	migrationPortStart = 49152
+	migrationPortMax   = 49216
 )
 
 /*
@@ -43,16 +46,27 @@ a port was in use or not, a la libvirt, but there'd still be a race condition.
 
 type portGrabber struct {
 	sync.Mutex
-	free map[int]struct{}
+	start int
+	max   int
+	free  map[int]struct{}
 }
 
-var uiPortGrabber = portGrabber{}
-
-func init() {
-	uiPortGrabber.free = make(map[int]struct{})
-	for i := portGrabberStart; i < portGrabberMax; i++ {
-		uiPortGrabber.free[i] = struct{}{}
+// uiPortGrabber hands out the ports of the spice and VNC servers of the
+// instances, migrationPortGrabber those on which the target node of a live
+// migration receives the instance state.
+var uiPortGrabber = newPortGrabber(portGrabberStart, portGrabberMax)
+var migrationPortGrabber = newPortGrabber(migrationPortStart, migrationPortMax)
+
+func newPortGrabber(start, max int) *portGrabber {
+	pg := &portGrabber{
+		start: start,
+		max:   max,
+		free:  make(map[int]struct{}),
+	}
+	for i := start; i < max; i++ {
+		pg.free[i] = struct{}{}
 	}
+	return pg
 }
 
 func (pg *portGrabber) grabPort() int {
@@ -77,7 +91,7 @@ func (pg *portGrabber) grabPort() int {
 func (pg *portGrabber) releasePort(port int) {
 	glog.Infof("Releasing port: %d", port)
 
-	if port < portGrabberStart || port >= portGrabberMax {
+	if port < pg.start || port >= pg.max {
 		glog.Warningf("Unable to release invalid port number %d", port)
 		return
 	}
@@ -19,6 +19,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,6 +27,7 @@ import (
 	"os/exec"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -42,6 +44,10 @@ const (
 	seedImage  = "seed.iso"
 	imagesPath = "/var/lib/ciao/images"
 	vcTries    = 10
+
+	// rootfsDrive is the id QEMU gives to the drive of the rootfs, the
+	// first virtio drive on the command line.
+	rootfsDrive = "virtio0"
 )
 
 type qmpGlogLogger struct{}
@@ -418,6 +424,20 @@ func launchQemuWithSpice(params []string, fds []*os.File, ipAddress string) (int
 	return port, err
 }
 
+// capturesConsole returns false when the serial console of the instances is
+// exported over TCP by the nc UI.
+func capturesConsole() bool {
+	return launchWithUI.String() != "nc"
+}
+
+// consoleParams connects the serial console of an instance to a unix socket
+// in the instance directory, from which launcher captures its output.
+func consoleParams(instanceDir string) []string {
+	consoleParam := fmt.Sprintf("socket,id=console0,path=%s,server,nowait",
+		path.Join(instanceDir, consoleSocket))
+	return []string{"-chardev", consoleParam, "-serial", "chardev:console0"}
+}
+
 func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string, networkParams []string) []string {
 	vmImage := path.Join(instanceDir, "image.qcow2")
 	qmpSocket := path.Join(instanceDir, "socket")
@@ -450,7 +470,77 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string, networ
 	return params
 }
 
+// volumeParams returns the parameters attaching the volumes mapped to
+// devices to a VM on its command line, using the ids given to the volumes
+// hot plugged by qmpAttach.  The volumes are sorted so that their PCI slots
+// do not depend on the iteration order of the map.
+func volumeParams(devices map[string]string) []string {
+	volumes := make([]string, 0, len(devices))
+	for volume := range devices {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+
+	params := make([]string, 0, 4*len(volumes))
+	for _, volume := range volumes {
+		driveParam := fmt.Sprintf("file=%s,if=none,id=drive_%s,format=raw", devices[volume], volume)
+		deviceParam := fmt.Sprintf("virtio-blk-pci,drive=drive_%s,id=device_%s", volume, volume)
+		params = append(params, "-drive", driveParam)
+		params = append(params, "-device", deviceParam)
+	}
+	return params
+}
+
 func (q *qemuV) startVM(vnicName, ipAddress string) error {
+	return q.launch(vnicName, ipAddress, nil)
+}
+
+func (q *qemuV) startIncomingVM(vnicName, ipAddress, uri string, diskPort int, devices map[string]string) error {
+	params := volumeParams(devices)
+	params = append(params, "-incoming", uri)
+	err := q.launch(vnicName, ipAddress, params)
+	if err != nil {
+		return err
+	}
+	return q.exportRootfs(ipAddress, diskPort)
+}
+
+// exportRootfs exports the rootfs of a VM waiting for an incoming migration
+// over NBD.  It uses its own QMP connection, closed before the VM is
+// monitored.
+func (q *qemuV) exportRootfs(ipAddress string, port int) error {
+	ctx := context.Background()
+	disconnectedCh := make(chan struct{})
+	socket := path.Join(q.instanceDir, "socket")
+	cfg := qemu.QMPConfig{Logger: qmpGlogLogger{}}
+	qmp, _, err := qemu.QMPStart(ctx, socket, cfg, disconnectedCh)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to QEMU: %v", err)
+	}
+	defer func() {
+		qmp.Shutdown()
+		<-disconnectedCh
+	}()
+
+	err = qmp.ExecuteQMPCapabilities(ctx)
+	if err != nil {
+		return fmt.Errorf("Unable to send qmp_capabilities command: %v", err)
+	}
+
+	err = qmp.ExecuteNBDServerStart(ctx, ipAddress, port)
+	if err != nil {
+		return fmt.Errorf("Unable to start NBD server: %v", err)
+	}
+
+	err = qmp.ExecuteNBDServerAdd(ctx, rootfsDrive, true)
+	if err != nil {
+		return fmt.Errorf("Unable to export rootfs: %v", err)
+	}
+
+	return nil
+}
+
+func (q *qemuV) launch(vnicName, ipAddress string, extraParams []string) error {
 
 	var fds []*os.File
 
@@ -483,6 +573,10 @@ func (q *qemuV) startVM(vnicName, ipAddress string) error {
 	}
 
 	params := generateQEMULaunchParams(q.cfg, q.isoPath, q.instanceDir, networkParams)
+	params = append(params, extraParams...)
+	if capturesConsole() {
+		params = append(params, consoleParams(q.instanceDir)...)
+	}
 
 	var err error
 
@@ -522,6 +616,35 @@ func (q *qemuV) lostVM() {
 	q.prevCPUTime = -1
 }
 
+// qmpPowerdown asks the guest to shut itself down, returning a timer which
+// fires if the guest is still running after powerdownTimeout.  The monitor
+// loop does not wait for the guest, so that it can still process other
+// commands, such as a stop, while the guest is powering down.  nil is
+// returned if the request could not be sent, in which case the instance is
+// quit straight away.
+func qmpPowerdown(q *qemu.QMP) *time.Timer {
+	err := q.ExecuteSystemPowerdownNoWait(context.Background())
+	if err == nil {
+		return time.NewTimer(powerdownTimeout)
+	}
+
+	glog.Warningf("Failed to power down instance cleanly, quitting: %v", err)
+	err = q.ExecuteQuit(context.Background())
+	if err != nil {
+		glog.Warningf("Failed to execute quit command: %v", err)
+	}
+	return nil
+}
+
+// powerdownExpired returns the channel of a powerdown timer, or nil if no
+// powerdown is in progress.
+func powerdownExpired(powerdown *time.Timer) <-chan time.Time {
+	if powerdown == nil {
+		return nil
+	}
+	return powerdown.C
+}
+
 func qmpAttach(cmd virtualizerAttachCmd, q *qemu.QMP) {
 	glog.Info("Attach command received")
 	blockdevID := fmt.Sprintf("drive_%s", cmd.volumeUUID)
@@ -555,14 +678,169 @@ func qmpDetach(cmd virtualizerDetachCmd, q *qemu.QMP) {
 	cmd.responseCh <- err
 }
 
+const (
+	// migrationTimeout bounds the time a live migration can take before
+	// it is cancelled.
+	migrationTimeout = 30 * time.Minute
+
+	migrationPollInterval = time.Second
+)
+
+// qmpMigration tracks a live migration started by qmpMigrate until it
+// completes, fails or times out.  The rootfs is mirrored to the target node
+// first, and the state of the VM is only sent once the mirror is ready.  The
+// mirror keeps the rootfs of both nodes in sync until the migration ends.
+type qmpMigration struct {
+	responseCh chan error
+	uri        string
+	migrating  bool
+	ticker     *time.Ticker
+	deadline   time.Time
+}
+
+func qmpMigrate(cmd virtualizerMigrateCmd, q *qemu.QMP) *qmpMigration {
+	glog.Infof("Migrate command received: %s", cmd.uri)
+
+	// Volumes are shared between the nodes but the rootfs is a local
+	// qcow2 image.  Only the clusters that are not in the backing image
+	// are mirrored to the rootfs the target node created.
+	if cmd.diskURI == "" {
+		glog.Errorf("Target node does not export the rootfs")
+		cmd.responseCh <- errors.New("Target node does not export the rootfs")
+		return nil
+	}
+
+	err := q.ExecuteDriveMirror(context.Background(), rootfsDrive, cmd.diskURI)
+	if err != nil {
+		glog.Errorf("Failed to execute drive-mirror: %v", err)
+		cmd.responseCh <- err
+		return nil
+	}
+
+	return &qmpMigration{
+		responseCh: cmd.responseCh,
+		uri:        cmd.uri,
+		ticker:     time.NewTicker(migrationPollInterval),
+		deadline:   time.Now().Add(migrationTimeout),
+	}
+}
+
+func (m *qmpMigration) tick() <-chan time.Time {
+	if m == nil {
+		return nil
+	}
+	return m.ticker.C
+}
+
+func (m *qmpMigration) done(err error) {
+	m.ticker.Stop()
+	m.responseCh <- err
+}
+
+// finish stops mirroring the rootfs and reports the outcome of the
+// migration.
+func (m *qmpMigration) finish(q *qemu.QMP, err error) {
+	cancelErr := q.ExecuteBlockJobCancel(context.Background(), rootfsDrive)
+	if cancelErr != nil {
+		glog.Warningf("Failed to execute block-job-cancel: %v", cancelErr)
+	}
+	m.done(err)
+}
+
+// check polls the status of the mirror, then of the migration, returning
+// true once the outcome of the migration has been reported.
+func (m *qmpMigration) check(q *qemu.QMP) bool {
+	if !m.migrating {
+		return m.checkMirror(q)
+	}
+
+	status, err := q.ExecuteQueryMigrate(context.Background())
+	if err != nil {
+		glog.Errorf("Failed to execute query-migrate: %v", err)
+		m.finish(q, err)
+		return true
+	}
+
+	switch status.Status {
+	case "completed":
+		m.finish(q, nil)
+		return true
+	case "failed", "cancelled":
+		if status.Error != "" {
+			err = fmt.Errorf("Migration %s: %s", status.Status, status.Error)
+		} else {
+			err = fmt.Errorf("Migration %s", status.Status)
+		}
+		m.finish(q, err)
+		return true
+	}
+
+	if time.Now().After(m.deadline) {
+		err = q.ExecuteMigrateCancel(context.Background())
+		if err != nil {
+			glog.Warningf("Failed to execute migrate_cancel: %v", err)
+		}
+		m.finish(q, fmt.Errorf("Migration timed out"))
+		return true
+	}
+
+	return false
+}
+
+// checkMirror starts the migration of the VM state once the rootfs mirror
+// is ready.
+func (m *qmpMigration) checkMirror(q *qemu.QMP) bool {
+	jobs, err := q.ExecuteQueryBlockJobs(context.Background())
+	if err != nil {
+		glog.Errorf("Failed to execute query-block-jobs: %v", err)
+		m.finish(q, err)
+		return true
+	}
+
+	var job *qemu.BlockJobStatus
+	for i := range jobs {
+		if jobs[i].Device == rootfsDrive {
+			job = &jobs[i]
+			break
+		}
+	}
+
+	if job == nil {
+		m.done(errors.New("Mirror of the rootfs stopped"))
+		return true
+	}
+
+	if !job.Ready {
+		if time.Now().After(m.deadline) {
+			m.finish(q, fmt.Errorf("Migration timed out"))
+			return true
+		}
+		return false
+	}
+
+	err = q.ExecuteMigrate(context.Background(), m.uri, false, false)
+	if err != nil {
+		glog.Errorf("Failed to execute migrate: %v", err)
+		m.finish(q, err)
+		return true
+	}
+
+	m.migrating = true
+	return false
+}
+
 func qmpConnect(qmpChannel chan interface{}, instance, instanceDir string, closedCh chan struct{},
 	connectedCh chan struct{}, wg *sync.WaitGroup, boot bool) {
 
 	var q *qemu.QMP
+	var console *serialConsole
 	defer func() {
 		if q != nil {
 			q.Shutdown()
 		}
+		if console != nil {
+			_ = console.Close()
+		}
 		glog.Infof("Monitor function for %s exitting", instance)
 		wg.Done()
 	}()
@@ -587,22 +865,72 @@ func qmpConnect(qmpChannel chan interface{}, instance, instanceDir string, close
 
 	close(connectedCh)
 
+	if capturesConsole() {
+		console = connectConsole(instance, instanceDir)
+	}
+
+	var migration *qmpMigration
+	var powerdown *time.Timer
+	defer func() {
+		if migration != nil {
+			migration.done(fmt.Errorf("Monitor of %s closed", instance))
+		}
+		if powerdown != nil {
+			powerdown.Stop()
+		}
+	}()
+
 DONE:
 	for {
-		cmd, ok := <-qmpChannel
-		if !ok {
-			break DONE
-		}
-		switch cmd := cmd.(type) {
-		case virtualizerStopCmd:
+		select {
+		case cmd, ok := <-qmpChannel:
+			if !ok {
+				break DONE
+			}
+			switch cmd := cmd.(type) {
+			case virtualizerStopCmd:
+				err = q.ExecuteQuit(context.Background())
+				if err != nil {
+					glog.Warningf("Failed to execute stop command: %v", err)
+				}
+			case virtualizerPowerdownCmd:
+				if powerdown == nil {
+					powerdown = qmpPowerdown(q)
+				}
+			case virtualizerPauseCmd:
+				cmd.responseCh <- q.ExecuteStop(context.Background())
+			case virtualizerUnpauseCmd:
+				cmd.responseCh <- q.ExecuteCont(context.Background())
+			case virtualizerAttachConsoleCmd:
+				if console == nil {
+					glog.Warningf("No serial console for %s", instance)
+					_ = cmd.conn.Close()
+				} else {
+					console.attach(cmd.conn)
+				}
+			case virtualizerAttachCmd:
+				qmpAttach(cmd, q)
+			case virtualizerDetachCmd:
+				qmpDetach(cmd, q)
+			case virtualizerMigrateCmd:
+				migration = qmpMigrate(cmd, q)
+			case virtualizerStopDiskExportCmd:
+				err = q.ExecuteNBDServerStop(context.Background())
+				if err != nil {
+					glog.Warningf("Failed to execute nbd-server-stop: %v", err)
+				}
+			}
+		case <-migration.tick():
+			if migration.check(q) {
+				migration = nil
+			}
+		case <-powerdownExpired(powerdown):
+			powerdown = nil
+			glog.Warningf("Instance %s did not power down in time, quitting", instance)
 			err = q.ExecuteQuit(context.Background())
 			if err != nil {
-				glog.Warningf("Failed to execute stop command: %v", err)
+				glog.Warningf("Failed to execute quit command: %v", err)
 			}
-		case virtualizerAttachCmd:
-			qmpAttach(cmd, q)
-		case virtualizerDetachCmd:
-			qmpDetach(cmd, q)
 		}
 	}
 }
@@ -205,6 +205,22 @@ func TestGenerateQEMULaunchParams(t *testing.T) {
 	}
 }
 
+func TestVolumeParams(t *testing.T) {
+	params := volumeParams(map[string]string{
+		"b": "/dev/rbd1",
+		"a": "/dev/rbd0",
+	})
+	expected := []string{
+		"-drive", "file=/dev/rbd0,if=none,id=drive_a,format=raw",
+		"-device", "virtio-blk-pci,drive=drive_a,id=device_a",
+		"-drive", "file=/dev/rbd1,if=none,id=drive_b,format=raw",
+		"-device", "virtio-blk-pci,drive=drive_b,id=device_b",
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Fatalf("%s and %s do not match", params, expected)
+	}
+}
+
 func TestQmpConnectBadSocket(t *testing.T) {
 	var wg sync.WaitGroup
 	qmpChannel := make(chan interface{})
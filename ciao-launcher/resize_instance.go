@@ -0,0 +1,140 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// vmResize contains the new resources of an instance resized by a RESTART
+// command.
+type vmResize struct {
+	cpus int
+	mem  int
+	disk int
+
+	// relocate is set when the node lacks the capacity to restart the
+	// resized instance.
+	relocate bool
+}
+
+func resizeRootfsParams(instanceDir string, diskMB int) []string {
+	vmImage := path.Join(instanceDir, "image.qcow2")
+	return []string{"resize", vmImage, fmt.Sprintf("%dM", diskMB)}
+}
+
+func resizeRootfs(instanceDir string, diskMB int) error {
+	cmd := exec.Command("qemu-img", resizeRootfsParams(instanceDir, diskMB)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("qemu-img resize failed: %v: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// resizeCommand stores the new resources of a stopped instance in its
+// configuration, growing its rootfs if needed.  It returns false if the
+// instance is not to be restarted on this node, either because the resize
+// failed or because the instance was handed back to the scheduler.  An
+// instance which needs to be relocated but cannot be moved is left
+// untouched.
+func (id *instanceData) resizeCommand(res *vmResize) bool {
+	var restartErr *restartError
+
+	if id.cfg.Container {
+		restartErr = &restartError{errors.New("Containers cannot be resized"),
+			payloads.RestartResizeFailure}
+	} else if res.relocate && !id.cfg.movable() {
+		restartErr = &restartError{nil, payloads.RestartNoCapacity}
+	} else {
+		restartErr = id.resize(res)
+	}
+
+	if restartErr != nil {
+		glog.Errorf("Unable to resize instance[%s]: %v", string(restartErr.code),
+			restartErr.err)
+		restartErr.send(id.ac.conn, id.instance)
+		return false
+	}
+
+	glog.Infof("Instance %s resized: Disk %d Mem %d CPUs %d", id.instance,
+		id.cfg.Disk, id.cfg.Mem, id.cfg.Cpus)
+	id.ovsCh <- &ovsResizeCmd{id.instance, id.cfg.Cpus, id.cfg.Mem, id.cfg.Disk}
+
+	if !res.relocate {
+		return true
+	}
+
+	id.relocate()
+	return false
+}
+
+// resize saves the new resources of the instance in its configuration and
+// then grows its rootfs.  The previous configuration is restored if either
+// step fails, so that the instance keeps the resources the controller goes
+// back to.
+func (id *instanceData) resize(res *vmResize) *restartError {
+	prevCfg := *id.cfg
+
+	id.cfg.Cpus, id.cfg.Mem = res.cpus, res.mem
+	if res.disk > id.cfg.Disk {
+		id.cfg.Disk = res.disk
+	}
+
+	err := id.cfg.save(id.instanceDir)
+	if err == nil && id.cfg.Disk > prevCfg.Disk {
+		err = resizeRootfs(id.instanceDir, id.cfg.Disk)
+	}
+	if err == nil {
+		return nil
+	}
+
+	*id.cfg = prevCfg
+	if saveErr := id.cfg.save(id.instanceDir); saveErr != nil {
+		glog.Errorf("Unable to restore configuration of %s: %v", id.instance,
+			saveErr)
+	}
+	return &restartError{err, payloads.RestartResizeFailure}
+}
+
+// relocate hands a resized instance which no longer fits on the node back
+// to the scheduler, so that it gets restarted on another node.  As with
+// evacuations, only instances booting from a volume can be moved, which
+// resizeCommand checks before resizing the instance.
+func (id *instanceData) relocate() {
+	volumes := id.getVolumes()
+	event := payloads.InstancesEvacuated{
+		Evacuated: payloads.EventInstancesEvacuated{
+			NodeUUID: id.ac.conn.UUID(),
+			Instances: []payloads.EvacuatedInstance{
+				{Restart: id.cfg.restartCmd(volumes), Movable: true},
+			},
+		},
+	}
+
+	glog.Infof("Instance %s relocated, removing it", id.instance)
+	id.handOver(volumes)
+	sendInstancesEvacuated(id.ac.conn, &event)
+}
@@ -0,0 +1,93 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+)
+
+func TestResizeRootfsParams(t *testing.T) {
+	params := resizeRootfsParams("/tmp/instance", 20000)
+	expected := []string{"resize", "/tmp/instance/image.qcow2", "20000M"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Unexpected parameters %v, expected %v", params, expected)
+	}
+}
+
+// Checks that an instance which needs to be relocated but does not boot
+// from a volume is left untouched.
+//
+// We save the configuration of an instance without a boot volume and ask
+// for it to be resized and relocated.
+//
+// The resize should fail with the no_capacity reason and the configuration
+// of the instance, both in memory and on disk, should still hold its
+// previous resources.
+func TestResizeRelocateNotMovable(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "resize-instance-test")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	cfg := standardCfg
+	err = cfg.save(instanceDir)
+	if err != nil {
+		t.Fatalf("Unable to save configuration: %v", err)
+	}
+
+	state := &instanceTestState{t: t, instance: cfg.Instance}
+	id := &instanceData{
+		instance:    cfg.Instance,
+		cfg:         &cfg,
+		ac:          &agentClient{conn: state},
+		instanceDir: instanceDir,
+	}
+
+	res := &vmResize{
+		cpus:     cfg.Cpus * 2,
+		mem:      cfg.Mem * 2,
+		disk:     cfg.Disk * 2,
+		relocate: true,
+	}
+	if id.resizeCommand(res) {
+		t.Fatal("Instance should not be restarted")
+	}
+
+	if state.rf.Reason != payloads.RestartNoCapacity {
+		t.Errorf("Unexpected restart failure reason %s, expected %s",
+			state.rf.Reason, payloads.RestartNoCapacity)
+	}
+
+	saved, err := loadVMConfig(instanceDir)
+	if err != nil {
+		t.Fatalf("Unable to load configuration: %v", err)
+	}
+
+	for _, c := range []*vmConfig{id.cfg, saved} {
+		if c.Cpus != standardCfg.Cpus || c.Mem != standardCfg.Mem ||
+			c.Disk != standardCfg.Disk {
+			t.Errorf("Instance resized to %d CPUs %d MB %d MB disk",
+				c.Cpus, c.Mem, c.Disk)
+		}
+	}
+}
@@ -80,8 +80,17 @@ VM:
 				s.monitorCh = nil
 				break VM
 			}
-			if _, stopCmd := cmd.(virtualizerStopCmd); stopCmd {
+			switch cmd := cmd.(type) {
+			case virtualizerStopCmd, virtualizerPowerdownCmd:
 				break VM
+			case virtualizerPauseCmd:
+				cmd.responseCh <- nil
+			case virtualizerUnpauseCmd:
+				cmd.responseCh <- nil
+			case virtualizerMigrateCmd:
+				cmd.responseCh <- nil
+			case virtualizerAttachConsoleCmd:
+				_ = cmd.conn.Close()
 			}
 		case <-s.killCh:
 			break VM
@@ -106,6 +115,14 @@ func (s *simulation) startVM(vnicName, ipAddress string) error {
 	return nil
 }
 
+func (s *simulation) startIncomingVM(vnicName, ipAddress, uri string, diskPort int, devices map[string]string) error {
+	glog.Infof("startIncomingVM %s\n", uri)
+
+	s.killCh = make(chan struct{})
+
+	return nil
+}
+
 func (s *simulation) monitorVM(closedCh chan struct{}, connectedCh chan struct{}, wg *sync.WaitGroup, boot bool) chan interface{} {
 	glog.Infof("monitorVM\n")
 	s.closedCh = closedCh
@@ -0,0 +1,122 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// errNoImageStore is returned when asked to create an image on a node whose
+// images directory is not shared with ciao-image, as the image would then
+// only be usable on that node.
+var errNoImageStore = errors.New("No shared image store configured")
+
+// snapshotResult is sent back to the instance go routine once the rootfs
+// of an instance has been copied into a new image.
+type snapshotResult struct {
+	imageID string
+	size    int64
+	err     error
+
+	// resume is true if the instance was paused for the snapshot and
+	// needs to be unpaused.
+	resume bool
+}
+
+// snapshotParams returns the qemu-img parameters needed to flatten rootfs,
+// together with its backing image, into a standalone qcow2 image.
+// forceShare must be set if rootfs is still opened by a QEMU process, which
+// would otherwise hold a lock on it.
+func snapshotParams(rootfs, dest string, forceShare bool) []string {
+	params := []string{"convert"}
+	if forceShare {
+		params = append(params, "-U")
+	}
+	return append(params, "-O", "qcow2", rootfs, dest)
+}
+
+// snapshotRootfs stores a copy of rootfs in the images directory under
+// imageID.  The image is written to a temporary file first so that it
+// never becomes visible half written.  The size of the new image is
+// returned.
+func snapshotRootfs(rootfs, imageID string, forceShare bool) (int64, error) {
+	dest := path.Join(imagesPath, imageID)
+	if _, err := os.Stat(dest); err == nil {
+		return 0, fmt.Errorf("Image %s already exists", imageID)
+	}
+
+	tmp := dest + ".part"
+	cmd := exec.Command("qemu-img", snapshotParams(rootfs, tmp, forceShare)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, fmt.Errorf("qemu-img convert failed: %v: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+
+	fi, err := os.Stat(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+
+	err = os.Rename(tmp, dest)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+func sendImageCreated(conn serverConn, instance, imageID string, size int64, imageErr error) {
+	if !conn.isConnected() {
+		return
+	}
+
+	event := payloads.EventImageCreated{
+		ImageCreated: payloads.ImageCreatedEvent{
+			InstanceUUID: instance,
+			ImageUUID:    imageID,
+			Size:         size,
+		},
+	}
+	if imageErr != nil {
+		event.ImageCreated.Error = imageErr.Error()
+	}
+
+	payload, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to marshal image created event for %s: %v", imageID, err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.ImageCreated, payload)
+	if err != nil {
+		glog.Errorf("Unable to send image created event for %s: %v", imageID, err)
+	}
+}
@@ -0,0 +1,36 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotParams(t *testing.T) {
+	params := snapshotParams("/tmp/image.qcow2", "/tmp/new", false)
+	expected := []string{"convert", "-O", "qcow2", "/tmp/image.qcow2", "/tmp/new"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Unexpected parameters %v, expected %v", params, expected)
+	}
+
+	params = snapshotParams("/tmp/image.qcow2", "/tmp/new", true)
+	expected = []string{"convert", "-U", "-O", "qcow2", "/tmp/image.qcow2", "/tmp/new"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Unexpected parameters %v, expected %v", params, expected)
+	}
+}
@@ -18,10 +18,27 @@ package main
 
 import (
 	"errors"
+	"net"
 	"sync"
+	"time"
 )
 
 type virtualizerStopCmd struct{}
+
+// virtualizerPowerdownCmd asks the guest to shut itself down cleanly.
+type virtualizerPowerdownCmd struct{}
+type virtualizerPauseCmd struct {
+	responseCh chan error
+}
+type virtualizerUnpauseCmd struct {
+	responseCh chan error
+}
+
+// virtualizerAttachConsoleCmd attaches an interactive session to the serial
+// console of an instance.  The virtualizer owns conn and must close it.
+type virtualizerAttachConsoleCmd struct {
+	conn net.Conn
+}
 type virtualizerAttachCmd struct {
 	responseCh chan error
 	volumeUUID string
@@ -32,6 +49,25 @@ type virtualizerDetachCmd struct {
 	volumeUUID string
 }
 
+// virtualizerMigrateCmd sends the state of a running VM to the QEMU instance
+// waiting for it at uri.  The volumes of the VM are shared between the nodes,
+// so only its rootfs is copied, by mirroring it to the NBD export at diskURI
+// first.  The outcome of the migration is sent to responseCh once it has
+// completed.
+type virtualizerMigrateCmd struct {
+	responseCh chan error
+	uri        string
+	diskURI    string
+}
+
+// virtualizerStopDiskExportCmd stops exporting the rootfs of a VM which has
+// been migrated to this node.
+type virtualizerStopDiskExportCmd struct{}
+
+// powerdownTimeout is how long a guest is given to shut itself down
+// cleanly before being killed.
+const powerdownTimeout = 60 * time.Second
+
 var errImageNotFound = errors.New("Image Not Found")
 
 //BUG(markus): These methods need to be cancellable
@@ -75,6 +111,13 @@ type virtualizer interface {
 	// Boots a VM.  This method is called by both START and RESTART.
 	startVM(vnicName, ipAddress string) error
 
+	// Boots a VM that waits for the state of a live migrated instance on uri
+	// instead of running its guest.  The rootfs of the VM is exported over
+	// NBD on diskPort, so that the node the instance is migrated from can
+	// copy its own rootfs to it.  devices maps the UUIDs of the volumes
+	// attached to the instance to the block devices they are mapped to.
+	startIncomingVM(vnicName, ipAddress, uri string, diskPort int, devices map[string]string) error
+
 	//BUG(markus): Need to use context rather than the monitor channel to
 	//detect when we need to quit.
 
@@ -21,6 +21,7 @@ import (
 	"os"
 	"path"
 
+	"github.com/01org/ciao/payloads"
 	"github.com/golang/glog"
 )
 
@@ -42,6 +43,8 @@ type vmConfig struct {
 	VnicUUID    string
 	SSHPort     int
 	Volumes     map[string]struct{}
+	Persistence payloads.Persistence
+	BootVolume  string
 }
 
 func loadVMConfig(instanceDir string) (*vmConfig, error) {
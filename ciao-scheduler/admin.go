@@ -0,0 +1,313 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const defaultAdminPort = 8889
+
+// maximum number of placement decisions kept for the admin API
+const maxPlacements = 256
+
+// placementLog is a fixed size ring of the most recent placement decisions.
+type placementLog struct {
+	sync.Mutex
+	entries []payloads.SchedulerPlacement
+	next    int
+	full    bool
+}
+
+// record a placement decision for workload.  An empty nodeUUID means the
+// workload could not be dispatched.
+func (pl *placementLog) record(workload *workResources, nodeUUID string, reason string) {
+	var nodeType payloads.Resource = payloads.ComputeNode
+	if workload.networkNode != 0 {
+		nodeType = payloads.NetworkNode
+	}
+
+	pl.Lock()
+	defer pl.Unlock()
+
+	if len(pl.entries) == 0 {
+		return
+	}
+
+	pl.entries[pl.next] = payloads.SchedulerPlacement{
+		Timestamp:  time.Now(),
+		InstanceID: workload.instanceUUID,
+		TenantID:   workload.tenantUUID,
+		NodeID:     nodeUUID,
+		NodeType:   nodeType,
+		MemReqMB:   workload.memReqMB,
+		Dispatched: nodeUUID != "",
+		Reason:     reason,
+	}
+
+	pl.next++
+	if pl.next == len(pl.entries) {
+		pl.next = 0
+		pl.full = true
+	}
+}
+
+// recent returns the recorded placement decisions, most recent first.
+func (pl *placementLog) recent() []payloads.SchedulerPlacement {
+	pl.Lock()
+	defer pl.Unlock()
+
+	count := pl.next
+	if pl.full {
+		count = len(pl.entries)
+	}
+
+	placements := make([]payloads.SchedulerPlacement, 0, count)
+	for i := 1; i <= count; i++ {
+		idx := (pl.next - i + len(pl.entries)) % len(pl.entries)
+		placements = append(placements, pl.entries[idx])
+	}
+
+	return placements
+}
+
+func listControllers(sched *ssntpSchedulerServer) payloads.SchedulerControllers {
+	controllers := payloads.NewSchedulerControllers()
+
+	sched.controllerMutex.RLock()
+	defer sched.controllerMutex.RUnlock()
+
+	for _, c := range sched.controllerList {
+		c.mutex.Lock()
+		controllers.Controllers = append(controllers.Controllers,
+			payloads.SchedulerController{
+				ID:     c.uuid,
+				Status: c.status.String(),
+			})
+		c.mutex.Unlock()
+	}
+
+	return controllers
+}
+
+func nodeStatToSchedulerNode(node *nodeStat, mru bool) payloads.SchedulerNode {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return payloads.SchedulerNode{
+		ID:           node.uuid,
+		Status:       node.status.String(),
+		MemTotal:     node.memTotalMB,
+		MemAvailable: node.memAvailMB,
+		MemReserved:  node.reservedMemMB(),
+		Reservations: len(node.reservations),
+		Draining:     node.draining,
+		Load:         node.load,
+		OnlineCPUs:   node.cpus,
+		MRU:          mru,
+	}
+}
+
+func listNodes(sched *ssntpSchedulerServer) payloads.SchedulerNodes {
+	nodes := payloads.NewSchedulerNodes()
+
+	sched.cnMutex.RLock()
+	for _, node := range sched.cnList {
+		nodes.ComputeNodes = append(nodes.ComputeNodes,
+			nodeStatToSchedulerNode(node, node == sched.cnMRU))
+	}
+	sched.cnMutex.RUnlock()
+
+	sched.nnMutex.RLock()
+	for _, node := range sched.nnMap {
+		nodes.NetworkNodes = append(nodes.NetworkNodes,
+			nodeStatToSchedulerNode(node, node.uuid == sched.nnMRU))
+	}
+	sched.nnMutex.RUnlock()
+
+	return nodes
+}
+
+func listPlacements(sched *ssntpSchedulerServer) payloads.SchedulerPlacements {
+	placements := payloads.NewSchedulerPlacements()
+	placements.Placements = append(placements.Placements, sched.placements.recent()...)
+
+	return placements
+}
+
+// returnAdminError returns a JSON formatted error for an admin API call
+func returnAdminError(w http.ResponseWriter, httpError int, message string) {
+	var returnCode payloads.HTTPReturnErrorCode
+	returnCode.Error.Code = httpError
+	returnCode.Error.Name = http.StatusText(httpError)
+	returnCode.Error.Message = message
+
+	b, err := json.Marshal(returnCode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(w, string(b), httpError)
+}
+
+func returnAdminJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		returnAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Only clients presenting an SSNTP Controller role certificate are allowed
+// to use the admin API.  The certificate chain itself has already been
+// verified against the SSNTP CA by the TLS layer.
+func adminAuthorized(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	role := ssntp.GetRoleFromOIDs(r.TLS.PeerCertificates[0].UnknownExtKeyUsage)
+
+	return role.IsController()
+}
+
+func failoverController(w http.ResponseWriter, r *http.Request, sched *ssntpSchedulerServer) {
+	var req payloads.SchedulerFailover
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		returnAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			returnAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	_, err = forceControllerFailover(sched, req.Master)
+	if err != nil {
+		returnAdminError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	returnAdminJSON(w, listControllers(sched))
+}
+
+func createAdminRouter(sched *ssntpSchedulerServer) *mux.Router {
+	r := mux.NewRouter()
+
+	authorized := func(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if adminAuthorized(r) == false {
+				returnAdminError(w, http.StatusUnauthorized, "Controller certificate required")
+				return
+			}
+
+			handler(w, r)
+		}
+	}
+
+	r.HandleFunc("/controllers", authorized(func(w http.ResponseWriter, r *http.Request) {
+		returnAdminJSON(w, listControllers(sched))
+	})).Methods("GET")
+
+	r.HandleFunc("/controllers/failover", authorized(func(w http.ResponseWriter, r *http.Request) {
+		failoverController(w, r, sched)
+	})).Methods("POST")
+
+	r.HandleFunc("/nodes", authorized(func(w http.ResponseWriter, r *http.Request) {
+		returnAdminJSON(w, listNodes(sched))
+	})).Methods("GET")
+
+	r.HandleFunc("/placements", authorized(func(w http.ResponseWriter, r *http.Request) {
+		returnAdminJSON(w, listPlacements(sched))
+	})).Methods("GET")
+
+	return r
+}
+
+func adminTLSConfig(caCert, cert string) (*tls.Config, error) {
+	caPEM, err := ioutil.ReadFile(caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, err := ioutil.ReadFile(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	// SSNTP certificates bundle the private key with the certificate
+	keyPair, err := tls.X509KeyPair(certPEM, certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	if certPool.AppendCertsFromPEM(caPEM) == false {
+		return nil, fmt.Errorf("Could not append CA %s", caCert)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		ClientCAs:    certPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// startAdminAPI serves the scheduler admin API on the local host.  The
+// server and client certificates are the SSNTP ones.
+func startAdminAPI(sched *ssntpSchedulerServer) {
+	tlsConfig, err := adminTLSConfig(sched.config.CAcert, sched.config.Cert)
+	if err != nil {
+		glog.Errorf("Unable to configure admin API TLS: %v", err)
+		return
+	}
+
+	service := fmt.Sprintf("localhost:%d", sched.adminPort)
+	server := &http.Server{
+		Addr:      service,
+		Handler:   createAdminRouter(sched),
+		TLSConfig: tlsConfig,
+	}
+
+	glog.Infof("Admin API listening on %s", service)
+	err = server.ListenAndServeTLS("", "")
+	if err != nil {
+		glog.Errorf("Admin API failure: %v", err)
+	}
+}
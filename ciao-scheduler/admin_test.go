@@ -0,0 +1,252 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/testutil"
+)
+
+func adminRequest(t *testing.T, sched *ssntpSchedulerServer, method string, path string, body []byte, role asn1.ObjectIdentifier) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if role != nil {
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{
+				{UnknownExtKeyUsage: []asn1.ObjectIdentifier{role}},
+			},
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	createAdminRouter(sched).ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestPlacementLog(t *testing.T) {
+	log := placementLog{
+		entries: make([]payloads.SchedulerPlacement, 4),
+	}
+
+	if len(log.recent()) != 0 {
+		t.Fatal("placements found in empty log")
+	}
+
+	for i := 0; i < 6; i++ {
+		workload := workResources{
+			instanceUUID: fmt.Sprintf("%08d", i),
+			memReqMB:     256,
+		}
+		log.record(&workload, "node", "fits")
+	}
+
+	placements := log.recent()
+	if len(placements) != 4 {
+		t.Fatalf("expected 4 placements, got %d", len(placements))
+	}
+
+	for i, p := range placements {
+		expected := fmt.Sprintf("%08d", 5-i)
+		if p.InstanceID != expected {
+			t.Errorf("expected placement for %s, got %s", expected, p.InstanceID)
+		}
+		if p.Dispatched == false || p.NodeType != payloads.ComputeNode {
+			t.Errorf("bad placement %v", p)
+		}
+	}
+}
+
+func TestForceControllerFailover(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	_, err := forceControllerFailover(sched, "")
+	if err == nil {
+		t.Error("failover succeeded without controllers")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+
+	_, err = forceControllerFailover(sched, "")
+	if err == nil {
+		t.Error("failover succeeded without backup controller")
+	}
+
+	spinUpController(sched, 2, controllerBackup)
+	spinUpController(sched, 3, controllerBackup)
+
+	master, err := forceControllerFailover(sched, fmt.Sprintf("%08d", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master != fmt.Sprintf("%08d", 3) {
+		t.Errorf("wrong new master %s", master)
+	}
+
+	master, err = forceControllerFailover(sched, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master != fmt.Sprintf("%08d", 2) {
+		t.Errorf("wrong new master %s", master)
+	}
+
+	beatTxt := heartBeatControllers(sched)
+	expected := "controller-00000002:MASTER, controller-00000001:BACKUP\t\t"
+	if beatTxt != expected {
+		t.Errorf("expected \"%s\", got \"%s\"", expected, beatTxt)
+	}
+
+	_, err = forceControllerFailover(sched, "unknown")
+	if err == nil {
+		t.Error("failover to unknown controller succeeded")
+	}
+}
+
+func TestAdminAPIUnauthorized(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	rr := adminRequest(t, sched, "GET", "/nodes", nil, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	rr = adminRequest(t, sched, "GET", "/nodes", nil, ssntp.RoleAgentOID)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestAdminAPIControllers(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpController(sched, 2, controllerBackup)
+
+	rr := adminRequest(t, sched, "POST", "/controllers/failover", nil, ssntp.RoleControllerOID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = adminRequest(t, sched, "GET", "/controllers", nil, ssntp.RoleControllerOID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var controllers payloads.SchedulerControllers
+	err := json.Unmarshal(rr.Body.Bytes(), &controllers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []payloads.SchedulerController{
+		{ID: fmt.Sprintf("%08d", 2), Status: "MASTER"},
+		{ID: fmt.Sprintf("%08d", 1), Status: "BACKUP"},
+	}
+	if len(controllers.Controllers) != len(expected) {
+		t.Fatalf("expected %d controllers, got %d", len(expected), len(controllers.Controllers))
+	}
+	for i := range expected {
+		if controllers.Controllers[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], controllers.Controllers[i])
+		}
+	}
+
+	body, _ := json.Marshal(payloads.SchedulerFailover{Master: "unknown"})
+	rr = adminRequest(t, sched, "POST", "/controllers/failover", body, ssntp.RoleControllerOID)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected %d, got %d", http.StatusConflict, rr.Code)
+	}
+}
+
+func TestAdminAPINodesAndPlacements(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNode(sched, 1, 16138)
+	spinUpNetworkNode(sched, 1001, 16138)
+
+	startWorkload(sched, fmt.Sprintf("%08d", 1), []byte(testutil.StartYaml))
+
+	rr := adminRequest(t, sched, "GET", "/nodes", nil, ssntp.RoleControllerOID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var nodes payloads.SchedulerNodes
+	err := json.Unmarshal(rr.Body.Bytes(), &nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes.ComputeNodes) != 1 || len(nodes.NetworkNodes) != 1 {
+		t.Fatalf("wrong node count: %v", nodes)
+	}
+
+	cn := nodes.ComputeNodes[0]
+	if cn.ID != fmt.Sprintf("%08d", 1) || cn.Status != ssntp.READY.String() ||
+		cn.MemTotal != 16138 || cn.MemAvailable >= 16138 || cn.MRU == false {
+		t.Errorf("bad compute node %v", cn)
+	}
+
+	rr = adminRequest(t, sched, "GET", "/placements", nil, ssntp.RoleControllerOID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var placements payloads.SchedulerPlacements
+	err = json.Unmarshal(rr.Body.Bytes(), &placements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(placements.Placements) != 1 {
+		t.Fatalf("expected 1 placement, got %d", len(placements.Placements))
+	}
+
+	p := placements.Placements[0]
+	if p.InstanceID != testutil.InstanceUUID || p.TenantID != testutil.TenantUUID ||
+		p.NodeID != cn.ID || p.Dispatched == false {
+		t.Errorf("bad placement %v", p)
+	}
+}
@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// A single instance START command from a StartBatch command
+type batchStart struct {
+	payload  []byte
+	workload workResources
+}
+
+// Send a START command to the node an instance has been placed on
+func dispatchStart(sched *ssntpSchedulerServer, nodeUUID string, workload *workResources, payload []byte) {
+	_, err := sched.ssntp.SendCommand(nodeUUID, ssntp.START, payload)
+	if err != nil {
+		glog.Errorf("Unable to dispatch START for instance %s to %s: %v",
+			workload.instanceUUID, nodeUUID, err)
+	}
+}
+
+// Parse the START commands of a batch.  Invalid START commands are
+// dropped, and valid reports whether all of them were valid.  The
+// controller is told about the dropped ones whose instance UUID can
+// still be decoded, so that they do not stay pending.
+func parseStartBatch(sched *ssntpSchedulerServer, controllerUUID string, batch *payloads.StartBatch) (starts []batchStart, valid bool) {
+	valid = true
+
+	for i, s := range batch.Batch.Starts {
+		var work payloads.Start
+		err := yaml.Unmarshal([]byte(s), &work)
+		if err != nil {
+			glog.Errorf("Bad START %d workload yaml in batch from Controller %s: %s\n", i, controllerUUID, err)
+			valid = false
+			rejectStart(sched, controllerUUID, []byte(s), payloads.InvalidPayload)
+			continue
+		}
+
+		workload, err := sched.getWorkloadResources(&work)
+		if err != nil {
+			glog.Errorf("Bad START %d workload resource list in batch from Controller %s: %s\n", i, controllerUUID, err)
+			valid = false
+			workload.instanceUUID = work.Start.InstanceUUID
+			workload.tenantUUID = work.Start.TenantUUID
+			if workload.instanceUUID != "" {
+				sched.sendStartFailureError(controllerUUID, &workload, payloads.InvalidData)
+			}
+			continue
+		}
+
+		starts = append(starts, batchStart{
+			payload:  []byte(s),
+			workload: workload,
+		})
+	}
+
+	return starts, valid
+}
+
+// Report a START command of a batch which cannot be parsed, if its
+// instance UUID can at least be decoded.
+func rejectStart(sched *ssntpSchedulerServer, controllerUUID string, payload []byte, reason payloads.StartFailureReason) {
+	var start struct {
+		Start struct {
+			InstanceUUID string `yaml:"instance_uuid"`
+			TenantUUID   string `yaml:"tenant_uuid"`
+		} `yaml:"start"`
+	}
+
+	err := yaml.Unmarshal(payload, &start)
+	if err != nil || start.Start.InstanceUUID == "" {
+		return
+	}
+
+	workload := workResources{
+		instanceUUID: start.Start.InstanceUUID,
+		tenantUUID:   start.Start.TenantUUID,
+	}
+	sched.sendStartFailureError(controllerUUID, &workload, reason)
+}
+
+// Place and dispatch each START command of a batch independently from the
+// other ones.  START commands are rate limited just like single ones.
+func placeBatch(sched *ssntpSchedulerServer, controllerUUID string, starts []batchStart) {
+	now := time.Now()
+
+	for i := range starts {
+		s := &starts[i]
+
+		switch sched.starts.admit(controllerUUID, s.payload, &s.workload, now) {
+		case startQueued:
+			continue
+		case startThrottled:
+			sched.sendStartFailureError(controllerUUID, &s.workload, payloads.TenantThrottled)
+			continue
+		}
+
+		nodeUUID := placeWorkload(sched, controllerUUID, &s.workload)
+		if nodeUUID != "" {
+			dispatchStart(sched, nodeUUID, &s.workload, s.payload)
+		}
+	}
+}
+
+// Place all START commands of a gang before dispatching any of them.  If
+// one of them does not fit, the resources claimed for the already placed
+// ones are released, the START tokens charged for the gang are given
+// back and the whole gang fails.
+func placeGang(sched *ssntpSchedulerServer, controllerUUID string, starts []batchStart) {
+	workloads := make([]workResources, len(starts))
+	for i := range starts {
+		workloads[i] = starts[i].workload
+	}
+
+	if sched.starts.admitGang(workloads, time.Now()) == false {
+		for i := range starts {
+			sched.sendStartFailureError(controllerUUID, &starts[i].workload, payloads.TenantThrottled)
+		}
+		return
+	}
+
+	placed := make([]string, 0, len(starts))
+	for i := range starts {
+		nodeUUID := placeWorkload(sched, controllerUUID, &starts[i].workload)
+		if nodeUUID != "" {
+			placed = append(placed, nodeUUID)
+			continue
+		}
+
+		// placeWorkload reported the failure for this one
+		glog.Warningf("Unable to place instance %s, gang of %d instances not started",
+			starts[i].workload.instanceUUID, len(starts))
+
+		for j, nodeUUID := range placed {
+			sched.releaseInstance(nodeUUID, starts[j].workload.instanceUUID)
+			sched.sendStartFailureError(controllerUUID, &starts[j].workload, payloads.GangFailure)
+		}
+		for j := i + 1; j < len(starts); j++ {
+			sched.sendStartFailureError(controllerUUID, &starts[j].workload, payloads.GangFailure)
+		}
+
+		// none of the gang instances started
+		sched.starts.refundGang(workloads)
+		return
+	}
+
+	for i, nodeUUID := range placed {
+		dispatchStart(sched, nodeUUID, &starts[i].workload, starts[i].payload)
+	}
+}
+
+// startBatch places all instances of a StartBatch command in one pass and
+// sends a START command to the selected node for each of them.  The batch
+// frame itself is never forwarded.
+func startBatch(sched *ssntpSchedulerServer, controllerUUID string, payload []byte) (dest ssntp.ForwardDestination) {
+	dest.SetDecision(ssntp.Discard)
+
+	var batch payloads.StartBatch
+	err := yaml.Unmarshal(payload, &batch)
+	if err != nil {
+		glog.Errorf("Bad StartBatch yaml from Controller %s: %s\n", controllerUUID, err)
+		return
+	}
+
+	starts, valid := parseStartBatch(sched, controllerUUID, &batch)
+
+	if batch.Batch.Gang == false {
+		placeBatch(sched, controllerUUID, starts)
+		return
+	}
+
+	if valid == false {
+		for i := range starts {
+			sched.sendStartFailureError(controllerUUID, &starts[i].workload, payloads.GangFailure)
+		}
+		return
+	}
+
+	placeGang(sched, controllerUUID, starts)
+
+	return
+}
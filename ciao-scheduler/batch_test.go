@@ -0,0 +1,227 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+// A batch of n START payloads, each one requesting 4096 MB
+func startBatchPayload(t *testing.T, n int, gang bool) []byte {
+	var batch payloads.StartBatch
+	batch.Batch.Gang = gang
+
+	for i := 0; i < n; i++ {
+		instanceUUID := fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+		batch.Batch.Starts = append(batch.Batch.Starts,
+			strings.Replace(testutil.StartYaml, testutil.InstanceUUID, instanceUUID, 1))
+	}
+
+	y, err := yaml.Marshal(&batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return y
+}
+
+func countPlacements(sched *ssntpSchedulerServer) (dispatched int, failures map[string]int) {
+	failures = make(map[string]int)
+
+	for _, p := range sched.placements.recent() {
+		if p.Dispatched {
+			dispatched++
+		} else {
+			failures[p.Reason]++
+		}
+	}
+
+	return
+}
+
+func TestStartBatch(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 1)
+	spinUpComputeNodeSmall(sched, 2)
+
+	dest := startBatch(sched, fmt.Sprintf("%08d", 1), startBatchPayload(t, 10, false))
+	if dest.Decision() != ssntp.Discard || len(dest.Recipients()) != 0 {
+		t.Error("StartBatch frame forwarded")
+	}
+
+	dispatched, failures := countPlacements(sched)
+	fullCloud := payloads.StartFailureReason(payloads.FullCloud).String()
+	if dispatched != 8 || failures[fullCloud] != 2 {
+		t.Errorf("expected 8 placements and 2 failures, got %d %v", dispatched, failures)
+	}
+
+	for _, node := range sched.cnList {
+		if len(node.reservations) != 4 {
+			t.Errorf("expected 4 reservations on node %s, got %d", node.uuid, len(node.reservations))
+		}
+	}
+}
+
+func TestStartBatchGang(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 1)
+	spinUpComputeNodeSmall(sched, 2)
+
+	// too large a gang, nothing is left reserved
+	startBatch(sched, fmt.Sprintf("%08d", 1), startBatchPayload(t, 10, true))
+
+	_, failures := countPlacements(sched)
+	fullCloud := payloads.StartFailureReason(payloads.FullCloud).String()
+	gangFailure := payloads.StartFailureReason(payloads.GangFailure).String()
+	if failures[fullCloud] != 1 || failures[gangFailure] != 9 {
+		t.Errorf("expected 1 full cloud and 9 gang failures, got %v", failures)
+	}
+
+	for _, node := range sched.cnList {
+		if len(node.reservations) != 0 || node.memAvailMB != 16384 {
+			t.Errorf("gang reservations left on node %s: %v", node.uuid, node.reservations)
+		}
+	}
+
+	// a gang that fits
+	sched.placements = placementLog{
+		entries: make([]payloads.SchedulerPlacement, maxPlacements),
+	}
+	startBatch(sched, fmt.Sprintf("%08d", 1), startBatchPayload(t, 8, true))
+
+	dispatched, failures := countPlacements(sched)
+	if dispatched != 8 || len(failures) != 0 {
+		t.Errorf("expected 8 placements, got %d %v", dispatched, failures)
+	}
+}
+
+func TestStartBatchGangThrottled(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeLarge(sched, 1)
+
+	sched.starts.setLimits(&payloads.ConfigureScheduler{
+		StartRate:  1,
+		StartBurst: 4,
+	})
+
+	startBatch(sched, fmt.Sprintf("%08d", 1), startBatchPayload(t, 5, true))
+
+	dispatched, failures := countPlacements(sched)
+	throttled := payloads.StartFailureReason(payloads.TenantThrottled).String()
+	if dispatched != 0 || failures[throttled] != 5 {
+		t.Errorf("expected 5 throttled instances, got %d %v", dispatched, failures)
+	}
+
+	startBatch(sched, fmt.Sprintf("%08d", 1), startBatchPayload(t, 4, true))
+
+	dispatched, _ = countPlacements(sched)
+	if dispatched != 4 {
+		t.Errorf("expected 4 placements, got %d", dispatched)
+	}
+}
+
+func TestStartBatchInvalid(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeLarge(sched, 1)
+
+	var batch payloads.StartBatch
+	badYaml := strings.Replace(testutil.StartYaml, "value: 2\n", "value: two\n", 1)
+	badResources := strings.Replace(testutil.StartYaml, "value: 4096\n", "value: 0\n", 1)
+	batch.Batch.Starts = []string{
+		strings.Replace(badYaml, testutil.InstanceUUID, "00000001-0000-0000-0000-000000000000", 1),
+		strings.Replace(badResources, testutil.InstanceUUID, "00000002-0000-0000-0000-000000000000", 1),
+		strings.Replace(testutil.StartYaml, testutil.InstanceUUID, "00000003-0000-0000-0000-000000000000", 1),
+		"not a START command",
+	}
+
+	payload, err := yaml.Marshal(&batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startBatch(sched, fmt.Sprintf("%08d", 1), payload)
+
+	// no instance with a decodable UUID is left pending in the controller
+	dispatched, failures := countPlacements(sched)
+	invalidPayload := payloads.StartFailureReason(payloads.InvalidPayload).String()
+	invalidData := payloads.StartFailureReason(payloads.InvalidData).String()
+	if dispatched != 1 || failures[invalidPayload] != 1 || failures[invalidData] != 1 {
+		t.Errorf("expected 1 placement and 2 invalid instances, got %d %v", dispatched, failures)
+	}
+}
+
+func TestStartBatchGangRefund(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 1)
+	spinUpComputeNodeSmall(sched, 2)
+
+	sched.starts.setLimits(&payloads.ConfigureScheduler{
+		StartRate:  1,
+		StartBurst: 10,
+	})
+
+	// too large a gang, its tokens are given back
+	startBatch(sched, fmt.Sprintf("%08d", 1), startBatchPayload(t, 10, true))
+
+	_, failures := countPlacements(sched)
+	gangFailure := payloads.StartFailureReason(payloads.GangFailure).String()
+	if failures[gangFailure] != 9 {
+		t.Errorf("expected 9 gang failures, got %v", failures)
+	}
+
+	sched.placements = placementLog{
+		entries: make([]payloads.SchedulerPlacement, maxPlacements),
+	}
+	startBatch(sched, fmt.Sprintf("%08d", 1), startBatchPayload(t, 8, true))
+
+	dispatched, failures := countPlacements(sched)
+	if dispatched != 8 || len(failures) != 0 {
+		t.Errorf("expected 8 placements, got %d %v", dispatched, failures)
+	}
+}
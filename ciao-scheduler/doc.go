@@ -132,11 +132,46 @@ restart, or be stopped and updated and restarted, and launcher agents
 will simply reconnect and keep on continually updating the scheduler of
 any changes in their node statistics.
 
+Between two node READY frames, the memory requested by instances placed on
+a node is held as a speculative reservation, so that back to back START
+commands are not all sent to the same node.  A reservation is released
+when the node reports the instance in its STATS, when the node reports a
+StartFailure for the instance, or when it expires after two minutes.  The
+memory a node is considered to have available is the memory it reports
+minus its outstanding reservations.
+
+Controllers starting several instances at once send a single StartBatch
+command.  The scheduler places all of its instances in one pass and sends
+a START command to the selected node for each of them.  A batch can be
+flagged as a gang, in which case its instances are started all or
+nothing: if one of them does not fit, the reservations taken for the
+other ones are released and a "gang_failure" StartFailure error is
+returned for each of them.
+
+Nodes can be evacuated for maintenance.  On an EVACUATE command the
+scheduler marks the node as draining, so that no new instance is placed on
+it, and forwards the command to the node launcher.  The launcher stops all
+of its instances and reports them in an InstancesEvacuated event.  Volume
+backed instances are placed on other nodes and restarted there through a
+RESTART command carrying their full description.  The controllers are
+notified through NodeEvacuation events, once when the node starts draining
+and once the evacuation is complete.  A node left in maintenance mode is put
+back in service by a RESTORE command, which the scheduler forwards to the
+node launcher once it places instances on the node again.
+
 Fairness
 
 Ciao-scheduler currently implements an extremely trivial algorithm to
 prefer not using the most-recently-used compute node.  This is inexpensive
 and leads to sufficient spread of new workloads across a cluster.
 
+Fairness between tenants is optionally enforced on START commands.  When
+the cluster configuration sets a per tenant START rate, each tenant gets
+a token bucket and START commands exceeding the tenant's rate are queued
+instead of being dispatched.  Queued START commands are dispatched in
+weighted fair order, so that a tenant launching hundreds of instances in
+a loop can not starve the other ones.  When a tenant's queue is full, its
+START commands are rejected with a "tenant_throttled" StartFailure error.
+
 */
 package main
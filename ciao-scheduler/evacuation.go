@@ -0,0 +1,209 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// Notify all Controllers about the progress of a node evacuation
+func (sched *ssntpSchedulerServer) sendNodeEvacuationEvents(evacuation *payloads.EventNodeEvacuation) {
+	payload := payloads.NodeEvacuation{
+		Evacuation: *evacuation,
+	}
+
+	b, err := yaml.Marshal(&payload)
+	if err != nil {
+		glog.Errorf("Unable to Marshall NodeEvacuation %v", err)
+		return
+	}
+
+	sched.controllerMutex.RLock()
+	defer sched.controllerMutex.RUnlock()
+
+	for _, c := range sched.controllerMap {
+		sched.ssntp.SendEvent(c.uuid, ssntp.NodeEvacuation, b)
+	}
+}
+
+// evacuateNode stops placing instances on the node referenced by an
+// EVACUATE command and forwards the command to its agent.
+func evacuateNode(sched *ssntpSchedulerServer, controllerUUID string, payload []byte) (dest ssntp.ForwardDestination) {
+	var cmd payloads.Evacuate
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		glog.Errorf("Bad EVACUATE yaml from Controller %s: %s\n", controllerUUID, err)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	nextState := cmd.Evacuate.NextState
+	if nextState == "" {
+		nextState = payloads.NodeMaintenance
+	}
+	if nextState != payloads.NodeMaintenance && nextState != payloads.NodeShutdown {
+		glog.Errorf("Bad EVACUATE next state %s from Controller %s\n", nextState, controllerUUID)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	nodeUUID := cmd.Evacuate.WorkloadAgentUUID
+	node := sched.getNodeStat(nodeUUID)
+	if node == nil {
+		glog.Errorf("EVACUATE for unknown node %s from Controller %s\n", nodeUUID, controllerUUID)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	node.mutex.Lock()
+	node.draining = true
+	node.mutex.Unlock()
+
+	glog.Infof("Node %s draining, next state %s\n", nodeUUID, nextState)
+
+	sched.sendNodeEvacuationEvents(&payloads.EventNodeEvacuation{
+		NodeUUID:  nodeUUID,
+		Status:    payloads.EvacuationDraining,
+		NextState: nextState,
+	})
+
+	dest.AddRecipient(nodeUUID)
+
+	return
+}
+
+// restoreNode places instances again on the node referenced by a RESTORE
+// command and forwards the command to its agent, so that it leaves the
+// maintenance mode an evacuation left it in.
+func restoreNode(sched *ssntpSchedulerServer, controllerUUID string, payload []byte) (dest ssntp.ForwardDestination) {
+	var cmd payloads.Restore
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		glog.Errorf("Bad RESTORE yaml from Controller %s: %s\n", controllerUUID, err)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	nodeUUID := cmd.Restore.WorkloadAgentUUID
+	node := sched.getNodeStat(nodeUUID)
+	if node == nil {
+		glog.Errorf("RESTORE for unknown node %s from Controller %s\n", nodeUUID, controllerUUID)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	node.mutex.Lock()
+	node.draining = false
+	node.mutex.Unlock()
+
+	glog.Infof("Node %s restored\n", nodeUUID)
+
+	dest.AddRecipient(nodeUUID)
+
+	return
+}
+
+// Place an instance stopped by an evacuated node and send a RESTART command
+// carrying its full description to the selected node, returning the node
+// UUID or an empty string if the instance could not be placed.
+func replaceInstance(sched *ssntpSchedulerServer, restart *payloads.RestartCmd) string {
+	work := payloads.Start{
+		Start: payloads.StartCmd{
+			TenantUUID:         restart.TenantUUID,
+			InstanceUUID:       restart.InstanceUUID,
+			RequestedResources: restart.RequestedResources,
+		},
+	}
+
+	workload, err := sched.getWorkloadResources(&work)
+	if err != nil {
+		glog.Errorf("Bad evacuated instance %s resource list: %s\n", restart.InstanceUUID, err)
+		return ""
+	}
+
+	// There is no Controller to report a placement failure to, the
+	// outcome is part of the NodeEvacuation event.
+	nodeUUID := placeWorkload(sched, "", &workload)
+	if nodeUUID == "" {
+		return ""
+	}
+
+	cmd := payloads.Restart{
+		Restart: *restart,
+	}
+	cmd.Restart.WorkloadAgentUUID = nodeUUID
+
+	payload, err := yaml.Marshal(&cmd)
+	if err != nil {
+		glog.Errorf("Unable to Marshall RESTART %v", err)
+		sched.releaseInstance(nodeUUID, workload.instanceUUID)
+		return ""
+	}
+
+	_, err = sched.ssntp.SendCommand(nodeUUID, ssntp.RESTART, payload)
+	if err != nil {
+		glog.Errorf("Unable to dispatch RESTART for instance %s to %s: %v",
+			workload.instanceUUID, nodeUUID, err)
+	}
+
+	return nodeUUID
+}
+
+// replaceEvacuatedInstances restarts the movable instances reported by an
+// InstancesEvacuated event on other nodes, and notifies the Controllers
+// about the evacuation outcome.  Events without a next state report
+// resized instances which no longer fit on a node that is not evacuated.
+func (sched *ssntpSchedulerServer) replaceEvacuatedInstances(uuid string, payload []byte) {
+	var event payloads.InstancesEvacuated
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Errorf("Bad InstancesEvacuated yaml from node %s", uuid)
+		return
+	}
+
+	evacuation := payloads.EventNodeEvacuation{
+		NodeUUID:  uuid,
+		Status:    payloads.EvacuationComplete,
+		NextState: event.Evacuated.NextState,
+	}
+
+	for i := range event.Evacuated.Instances {
+		instance := &event.Evacuated.Instances[i]
+		outcome := payloads.NodeEvacuationInstance{
+			InstanceUUID: instance.Restart.InstanceUUID,
+			Status:       payloads.InstanceStopped,
+		}
+
+		if instance.Movable {
+			outcome.NodeUUID = replaceInstance(sched, &instance.Restart)
+			if outcome.NodeUUID != "" {
+				outcome.Status = payloads.InstanceReplaced
+			} else {
+				outcome.Status = payloads.InstanceReplaceFailed
+			}
+		}
+
+		evacuation.Instances = append(evacuation.Instances, outcome)
+	}
+
+	glog.Infof("Node %s evacuated, %d instances\n", uuid, len(evacuation.Instances))
+
+	sched.sendNodeEvacuationEvents(&evacuation)
+}
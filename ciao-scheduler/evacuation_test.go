@@ -0,0 +1,191 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func evacuatePayload(t *testing.T, nodeUUID string, nextState payloads.NodeNextState) []byte {
+	var cmd payloads.Evacuate
+	cmd.Evacuate.WorkloadAgentUUID = nodeUUID
+	cmd.Evacuate.NextState = nextState
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return y
+}
+
+func TestEvacuateNode(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	controllerUUID := fmt.Sprintf("%08d", 1)
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 2)
+	spinUpComputeNodeSmall(sched, 3)
+
+	evacuated := fmt.Sprintf("%08d", 2)
+
+	dest := evacuateNode(sched, controllerUUID, evacuatePayload(t, evacuated, "reboot"))
+	if dest.Decision() != ssntp.Discard {
+		t.Error("EVACUATE with an invalid next state forwarded")
+	}
+
+	dest = evacuateNode(sched, controllerUUID, evacuatePayload(t, fmt.Sprintf("%08d", 4), ""))
+	if dest.Decision() != ssntp.Discard {
+		t.Error("EVACUATE for an unknown node forwarded")
+	}
+
+	dest = evacuateNode(sched, controllerUUID, evacuatePayload(t, evacuated, ""))
+	if len(dest.Recipients()) != 1 || dest.Recipients()[0] != evacuated {
+		t.Fatalf("EVACUATE not forwarded to %s: %v", evacuated, dest.Recipients())
+	}
+
+	if sched.cnMap[evacuated].draining != true {
+		t.Fatal("evacuated node not draining")
+	}
+
+	for i := 0; i < 4; i++ {
+		var workload workResources
+		workload.instanceUUID = fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+		workload.memReqMB = 1024
+
+		nodeUUID := placeWorkload(sched, controllerUUID, &workload)
+		if nodeUUID != fmt.Sprintf("%08d", 3) {
+			t.Errorf("instance %d placed on %q", i, nodeUUID)
+		}
+	}
+}
+
+func TestRestoreNode(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	controllerUUID := fmt.Sprintf("%08d", 1)
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 2)
+
+	restored := fmt.Sprintf("%08d", 2)
+	sched.cnMap[restored].draining = true
+
+	var cmd payloads.Restore
+	cmd.Restore.WorkloadAgentUUID = fmt.Sprintf("%08d", 3)
+	unknown, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := restoreNode(sched, controllerUUID, unknown)
+	if dest.Decision() != ssntp.Discard {
+		t.Error("RESTORE for an unknown node forwarded")
+	}
+
+	cmd.Restore.WorkloadAgentUUID = restored
+	payload, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest = restoreNode(sched, controllerUUID, payload)
+	if len(dest.Recipients()) != 1 || dest.Recipients()[0] != restored {
+		t.Fatalf("RESTORE not forwarded to %s: %v", restored, dest.Recipients())
+	}
+
+	var workload workResources
+	workload.instanceUUID = testutil.InstanceUUID
+	workload.memReqMB = 1024
+
+	nodeUUID := placeWorkload(sched, controllerUUID, &workload)
+	if nodeUUID != restored {
+		t.Errorf("instance placed on %q instead of the restored node", nodeUUID)
+	}
+}
+
+func TestReplaceEvacuatedInstances(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 2)
+	spinUpComputeNodeSmall(sched, 3)
+
+	evacuated := fmt.Sprintf("%08d", 2)
+	target := fmt.Sprintf("%08d", 3)
+	sched.cnMap[evacuated].draining = true
+
+	var restart payloads.Restart
+	err := yaml.Unmarshal([]byte(testutil.RestartYaml), &restart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RestartYaml requests 4096 MB, the first four instances fit
+	var event payloads.InstancesEvacuated
+	event.Evacuated.NodeUUID = evacuated
+	event.Evacuated.NextState = payloads.NodeMaintenance
+	for i := 0; i < 6; i++ {
+		instance := payloads.EvacuatedInstance{
+			Restart: restart.Restart,
+			Movable: i != 5,
+		}
+		instance.Restart.InstanceUUID = fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+		event.Evacuated.Instances = append(event.Evacuated.Instances, instance)
+	}
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched.replaceEvacuatedInstances(evacuated, y)
+
+	if len(sched.cnMap[evacuated].reservations) != 0 {
+		t.Error("instance placed on the evacuated node")
+	}
+
+	reservations := sched.cnMap[target].reservations
+	if len(reservations) != 4 {
+		t.Fatalf("expected 4 instances placed on %s, got %d", target, len(reservations))
+	}
+	for i := 0; i < 4; i++ {
+		if _, ok := reservations[fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)]; !ok {
+			t.Errorf("instance %d not placed", i)
+		}
+	}
+
+	dispatched, failures := countPlacements(sched)
+	fullCloud := payloads.StartFailureReason(payloads.FullCloud).String()
+	if dispatched != 4 || failures[fullCloud] != 1 {
+		t.Errorf("expected 4 placements and 1 failure, got %d %v", dispatched, failures)
+	}
+}
@@ -0,0 +1,407 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/configuration"
+	"github.com/01org/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+const defaultStartQueueLength = 128
+const defaultTenantWeight = 1
+
+// START rate limits, as set by the cluster configuration
+type startLimits struct {
+	rate     int
+	burst    int
+	queueLen int
+	tenants  map[string]payloads.ConfigureTenantStartLimit
+}
+
+func newStartLimits(conf *payloads.ConfigureScheduler) startLimits {
+	limits := startLimits{
+		rate:     conf.StartRate,
+		burst:    conf.StartBurst,
+		queueLen: conf.StartQueueLength,
+		tenants:  make(map[string]payloads.ConfigureTenantStartLimit),
+	}
+
+	if limits.queueLen <= 0 {
+		limits.queueLen = defaultStartQueueLength
+	}
+
+	for _, t := range conf.TenantStartLimits {
+		limits.tenants[t.TenantUUID] = t
+	}
+
+	return limits
+}
+
+// tenant returns the rate, burst and weight for a given tenant.  A 0 rate
+// means the tenant is not rate limited.
+func (limits *startLimits) tenant(tenantUUID string) (rate int, burst int, weight int) {
+	rate = limits.rate
+	burst = limits.burst
+	weight = defaultTenantWeight
+
+	t, ok := limits.tenants[tenantUUID]
+	if ok {
+		if t.StartRate > 0 {
+			rate = t.StartRate
+		}
+		if t.StartBurst > 0 {
+			burst = t.StartBurst
+		}
+		if t.Weight > 0 {
+			weight = t.Weight
+		}
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return
+}
+
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		b.last = now
+	}
+
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// wait returns how long until the next token is available
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	if b.rate <= 0 {
+		return time.Second
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// a START command waiting for its tenant to get a token
+type queuedStart struct {
+	controllerUUID string
+	payload        []byte
+	workload       workResources
+}
+
+type tenantStarts struct {
+	uuid    string
+	bucket  tokenBucket
+	weight  int
+	vtime   float64 // virtual start time of the tenant's next START
+	pending []queuedStart
+}
+
+type startAdmission uint8
+
+const (
+	startDispatch startAdmission = iota
+	startQueued
+	startThrottled
+)
+
+// startDispatcher enforces per tenant START token buckets and dispatches
+// rate limited START commands in weighted fair order: the tenant with
+// the smallest virtual time goes first, and each dispatched START
+// advances the tenant virtual time by 1/weight.
+type startDispatcher struct {
+	sync.Mutex
+	limits  startLimits
+	tenants map[string]*tenantStarts
+	vclock  float64
+	wakeup  chan struct{}
+}
+
+func newStartDispatcher() *startDispatcher {
+	return &startDispatcher{
+		limits:  newStartLimits(&payloads.ConfigureScheduler{}),
+		tenants: make(map[string]*tenantStarts),
+		wakeup:  make(chan struct{}, 1),
+	}
+}
+
+func (d *startDispatcher) setLimits(conf *payloads.ConfigureScheduler) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.limits = newStartLimits(conf)
+
+	for uuid, t := range d.tenants {
+		rate, burst, weight := d.limits.tenant(uuid)
+		t.bucket.rate = float64(rate)
+		t.bucket.burst = float64(burst)
+		if t.bucket.tokens < 0 {
+			t.bucket.tokens = 0
+		}
+		t.weight = weight
+	}
+
+	glog.Infof("START rate limit %d/s, burst %d, queue length %d, %d tenant overrides",
+		d.limits.rate, d.limits.burst, d.limits.queueLen, len(d.limits.tenants))
+}
+
+// Get the locked dispatcher tenant state, creating it if needed
+func (d *startDispatcher) tenant(uuid string, rate int, burst int, weight int, now time.Time) *tenantStarts {
+	t := d.tenants[uuid]
+	if t == nil {
+		t = &tenantStarts{
+			uuid: uuid,
+			bucket: tokenBucket{
+				rate:   float64(rate),
+				burst:  float64(burst),
+				tokens: float64(burst),
+				last:   now,
+			},
+			weight: weight,
+		}
+		d.tenants[uuid] = t
+	}
+
+	return t
+}
+
+// Charge one START to the locked dispatcher tenant state
+func (d *startDispatcher) charge(t *tenantStarts) {
+	// idle tenants do not accumulate credit
+	if t.vtime < d.vclock {
+		t.vtime = d.vclock
+	}
+
+	d.vclock = t.vtime
+	t.vtime += 1 / float64(t.weight)
+	t.bucket.tokens--
+}
+
+// admit decides if a START command can be dispatched right away, has to
+// be queued until its tenant gets a new token, or has to be rejected.
+func (d *startDispatcher) admit(controllerUUID string, payload []byte, workload *workResources, now time.Time) startAdmission {
+	// CNCIs are not accounted against tenants
+	if workload.networkNode != 0 {
+		return startDispatch
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	rate, burst, weight := d.limits.tenant(workload.tenantUUID)
+	if rate == 0 {
+		return startDispatch
+	}
+
+	t := d.tenant(workload.tenantUUID, rate, burst, weight, now)
+	t.bucket.refill(now)
+
+	// do not overtake already queued STARTs from the same tenant
+	if len(t.pending) == 0 && t.bucket.tokens >= 1 {
+		d.charge(t)
+		return startDispatch
+	}
+
+	if len(t.pending) >= d.limits.queueLen {
+		return startThrottled
+	}
+
+	t.pending = append(t.pending, queuedStart{
+		controllerUUID: controllerUUID,
+		payload:        payload,
+		workload:       *workload,
+	})
+
+	select {
+	case d.wakeup <- struct{}{}:
+	default:
+	}
+
+	return startQueued
+}
+
+// admitGang admits a gang of START commands all or nothing.  Either every
+// rate limited tenant of the gang has no queued START and enough tokens for
+// all its gang instances, or the whole gang is throttled.  Gangs are never
+// queued, so a gang larger than its tenant burst is always throttled.
+func (d *startDispatcher) admitGang(workloads []workResources, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	count := make(map[string]int)
+	for i := range workloads {
+		// CNCIs are not accounted against tenants
+		if workloads[i].networkNode == 0 {
+			count[workloads[i].tenantUUID]++
+		}
+	}
+
+	charged := make(map[*tenantStarts]int)
+	for tenantUUID, n := range count {
+		rate, burst, weight := d.limits.tenant(tenantUUID)
+		if rate == 0 {
+			continue
+		}
+
+		t := d.tenant(tenantUUID, rate, burst, weight, now)
+		t.bucket.refill(now)
+
+		if len(t.pending) > 0 || t.bucket.tokens < float64(n) {
+			return false
+		}
+
+		charged[t] = n
+	}
+
+	for t, n := range charged {
+		for i := 0; i < n; i++ {
+			d.charge(t)
+		}
+	}
+
+	return true
+}
+
+// refundGang gives back the tokens charged by admitGang for a gang which
+// could not be placed, so that its tenants are not throttled for
+// instances which never started.
+func (d *startDispatcher) refundGang(workloads []workResources) {
+	d.Lock()
+	defer d.Unlock()
+
+	for i := range workloads {
+		if workloads[i].networkNode != 0 {
+			continue
+		}
+
+		rate, _, _ := d.limits.tenant(workloads[i].tenantUUID)
+		t := d.tenants[workloads[i].tenantUUID]
+		if rate == 0 || t == nil {
+			continue
+		}
+
+		t.vtime -= 1 / float64(t.weight)
+		t.bucket.tokens++
+		if t.bucket.tokens > t.bucket.burst {
+			t.bucket.tokens = t.bucket.burst
+		}
+	}
+}
+
+// next returns the next queued START to dispatch.  If none can be
+// dispatched yet, it returns how long to wait for a tenant token, or a
+// negative duration when nothing is queued.
+func (d *startDispatcher) next(now time.Time) (*queuedStart, time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+
+	var best *tenantStarts
+	wait := time.Duration(-1)
+
+	for uuid, t := range d.tenants {
+		t.bucket.refill(now)
+
+		if len(t.pending) == 0 {
+			if t.bucket.rate == 0 || t.bucket.tokens >= t.bucket.burst {
+				// idle tenant, back to its initial state
+				delete(d.tenants, uuid)
+			}
+			continue
+		}
+
+		// a 0 rate means the tenant is no longer rate limited
+		if t.bucket.rate > 0 && t.bucket.tokens < 1 {
+			if w := t.bucket.wait(); wait < 0 || w < wait {
+				wait = w
+			}
+			continue
+		}
+
+		if best == nil || t.vtime < best.vtime ||
+			(t.vtime == best.vtime && t.uuid < best.uuid) {
+			best = t
+		}
+	}
+
+	if best == nil {
+		return nil, wait
+	}
+
+	start := best.pending[0]
+	best.pending = best.pending[1:]
+	d.charge(best)
+
+	return &start, 0
+}
+
+// Dispatch a previously queued START command to a compute node
+func dispatchQueuedStart(sched *ssntpSchedulerServer, start *queuedStart) {
+	nodeUUID := placeWorkload(sched, start.controllerUUID, &start.workload)
+	if nodeUUID == "" {
+		return
+	}
+
+	dispatchStart(sched, nodeUUID, &start.workload, start.payload)
+}
+
+func startDispatchLoop(sched *ssntpSchedulerServer) {
+	for {
+		start, wait := sched.starts.next(time.Now())
+		if start != nil {
+			dispatchQueuedStart(sched, start)
+			continue
+		}
+
+		if wait < 0 {
+			<-sched.starts.wakeup
+			continue
+		}
+
+		select {
+		case <-sched.starts.wakeup:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Load the START rate limits from the cluster configuration
+func loadStartLimits(sched *ssntpSchedulerServer, blob []byte) {
+	conf, err := configuration.Payload(blob)
+	if err != nil {
+		glog.Warningf("Unable to load START rate limits: %v", err)
+		return
+	}
+
+	sched.starts.setLimits(&conf.Configure.Scheduler)
+}
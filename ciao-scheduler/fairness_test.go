@@ -0,0 +1,226 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/testutil"
+)
+
+func tenantWorkload(tenant string, i int) *workResources {
+	return &workResources{
+		instanceUUID: fmt.Sprintf("%s-%04d", tenant, i),
+		tenantUUID:   tenant,
+		memReqMB:     256,
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := tokenBucket{
+		rate:   2,
+		burst:  4,
+		tokens: 0,
+		last:   now,
+	}
+
+	if b.wait() != 500*time.Millisecond {
+		t.Errorf("expected 500ms wait, got %s", b.wait())
+	}
+
+	b.refill(now.Add(time.Second))
+	if b.tokens != 2 {
+		t.Errorf("expected 2 tokens, got %f", b.tokens)
+	}
+
+	b.refill(now.Add(time.Minute))
+	if b.tokens != 4 {
+		t.Errorf("expected tokens capped to burst, got %f", b.tokens)
+	}
+
+	if b.wait() != 0 {
+		t.Errorf("expected no wait, got %s", b.wait())
+	}
+}
+
+func TestStartAdmission(t *testing.T) {
+	now := time.Now()
+	d := newStartDispatcher()
+
+	// no limits
+	for i := 0; i < 10; i++ {
+		if d.admit("", nil, tenantWorkload("a", i), now) != startDispatch {
+			t.Fatal("START throttled without rate limits")
+		}
+	}
+
+	d.setLimits(&payloads.ConfigureScheduler{
+		StartRate:        1,
+		StartBurst:       2,
+		StartQueueLength: 3,
+	})
+
+	expected := []startAdmission{
+		startDispatch, startDispatch,
+		startQueued, startQueued, startQueued,
+		startThrottled,
+	}
+	for i, e := range expected {
+		a := d.admit("", nil, tenantWorkload("a", i), now)
+		if a != e {
+			t.Errorf("START %d: expected admission %d, got %d", i, e, a)
+		}
+	}
+
+	// other tenants are not affected
+	if d.admit("", nil, tenantWorkload("b", 0), now) != startDispatch {
+		t.Error("START throttled for an idle tenant")
+	}
+
+	// and neither are CNCIs
+	cnci := tenantWorkload("a", 100)
+	cnci.networkNode = 1
+	if d.admit("", nil, cnci, now) != startDispatch {
+		t.Error("CNCI START throttled")
+	}
+
+	// a token is available after a second, for the first queued START
+	start, wait := d.next(now)
+	if start != nil || wait != time.Second {
+		t.Errorf("expected a 1s wait, got %v %s", start, wait)
+	}
+
+	start, _ = d.next(now.Add(time.Second))
+	if start == nil || start.workload.instanceUUID != "a-0002" {
+		t.Fatalf("wrong queued START dispatched: %v", start)
+	}
+
+	// still queued STARTs, new ones can not overtake them
+	if d.admit("", nil, tenantWorkload("a", 6), now.Add(2*time.Second)) != startQueued {
+		t.Error("START overtook queued STARTs")
+	}
+}
+
+func TestStartWeightedFairness(t *testing.T) {
+	now := time.Now()
+	d := newStartDispatcher()
+
+	d.setLimits(&payloads.ConfigureScheduler{
+		StartRate:        1,
+		StartBurst:       1,
+		StartQueueLength: 100,
+		TenantStartLimits: []payloads.ConfigureTenantStartLimit{
+			{TenantUUID: "b", Weight: 2},
+		},
+	})
+
+	// tenant a floods the queue first
+	for i := 0; i < 20; i++ {
+		d.admit("", nil, tenantWorkload("a", i), now)
+	}
+	for i := 0; i < 20; i++ {
+		d.admit("", nil, tenantWorkload("b", i), now)
+	}
+
+	// give everybody plenty of tokens
+	d.Lock()
+	for _, t := range d.tenants {
+		t.bucket.burst = 100
+		t.bucket.tokens = 100
+	}
+	d.Unlock()
+
+	count := map[string]int{}
+	for i := 0; i < 12; i++ {
+		start, _ := d.next(now)
+		if start == nil {
+			t.Fatal("no queued START dispatched")
+		}
+		count[start.workload.tenantUUID]++
+	}
+
+	if count["a"] != 4 || count["b"] != 8 {
+		t.Errorf("unfair dispatching: %v", count)
+	}
+}
+
+func TestStartWorkloadThrottled(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	var controllerUUID = fmt.Sprintf("%08d", 1)
+	spinUpComputeNodeLarge(sched, 1)
+
+	sched.starts.setLimits(&payloads.ConfigureScheduler{
+		StartRate:        1,
+		StartBurst:       1,
+		StartQueueLength: 1,
+	})
+
+	var decisions []string
+	for i := 0; i < 3; i++ {
+		fwd, _ := startWorkload(sched, controllerUUID, []byte(testutil.StartYaml))
+		decisions = append(decisions, fmt.Sprintf("%d:%d", fwd.Decision(), len(fwd.Recipients())))
+	}
+
+	expected := []string{"0:1", "1:0", "1:0"}
+	for i := range expected {
+		if decisions[i] != expected[i] {
+			t.Errorf("START %d: expected %s, got %s", i, expected[i], decisions[i])
+		}
+	}
+
+	placements := sched.placements.recent()
+	if len(placements) != 2 || placements[0].Dispatched ||
+		placements[0].Reason != payloads.StartFailureReason(payloads.TenantThrottled).String() {
+		t.Errorf("throttled START not recorded: %v", placements)
+	}
+}
+
+func TestConfigureStartLimits(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeLarge(sched, 2)
+
+	conf := strings.Replace(testutil.ConfigureYaml, "  scheduler:\n",
+		"  scheduler:\n    start_rate: 5\n", 1)
+	frame := &ssntp.Frame{Payload: []byte(conf)}
+
+	// only controllers can change the START rate limits
+	sched.CommandNotify(fmt.Sprintf("%08d", 2), ssntp.CONFIGURE, frame)
+	if sched.starts.limits.rate != 0 {
+		t.Errorf("START rate limits changed by a compute node: %d", sched.starts.limits.rate)
+	}
+
+	sched.CommandNotify(fmt.Sprintf("%08d", 1), ssntp.CONFIGURE, frame)
+	if sched.starts.limits.rate != 5 {
+		t.Errorf("START rate limits not changed by the controller: %d", sched.starts.limits.rate)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// Find a compute node other than the source of a live migration for the
+// workload, returning a reference to a locked nodeStat if found.  Only the
+// target chosen by the Controller is considered when there is one.
+func pickMigrationTarget(sched *ssntpSchedulerServer, source, target string, workload *workResources) *nodeStat {
+	sched.cnMutex.RLock()
+	defer sched.cnMutex.RUnlock()
+
+	for _, node := range sched.cnList {
+		if node.uuid == source || (target != "" && node.uuid != target) {
+			continue
+		}
+
+		node.mutex.Lock()
+		if sched.workloadFits(node, workload) {
+			return node // locked nodeStat
+		}
+		node.mutex.Unlock()
+	}
+
+	return nil
+}
+
+// Notify the Controller which sent a MIGRATE command that its instance
+// could not be migrated.
+func (sched *ssntpSchedulerServer) sendMigrationFailure(controllerUUID string, cmd *payloads.MigrateCmd, reason string) {
+	event := payloads.EventInstanceMigrated{
+		InstanceMigrated: payloads.InstanceMigratedEvent{
+			InstanceUUID:    cmd.InstanceUUID,
+			SourceAgentUUID: cmd.WorkloadAgentUUID,
+			TargetAgentUUID: cmd.TargetAgentUUID,
+			Error:           reason,
+		},
+	}
+
+	b, err := yaml.Marshal(&event)
+	if err != nil {
+		glog.Errorf("Unable to Marshall InstanceMigrated %v", err)
+		return
+	}
+
+	_, err = sched.ssntp.SendEvent(controllerUUID, ssntp.InstanceMigrated, b)
+	if err != nil {
+		glog.Errorf("Unable to send InstanceMigrated to %s: %v", controllerUUID, err)
+	}
+}
+
+// migrateInstance picks the target node of a live migration, reserves the
+// instance resources on it and sends the MIGRATE command, completed with the
+// target node, to the node running the instance.
+func migrateInstance(sched *ssntpSchedulerServer, controllerUUID string, payload []byte) (dest ssntp.ForwardDestination, instanceUUID string) {
+	dest.SetDecision(ssntp.Discard)
+
+	var cmd payloads.Migrate
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		glog.Errorf("Bad MIGRATE yaml from Controller %s: %s\n", controllerUUID, err)
+		return
+	}
+
+	instanceUUID = cmd.Migrate.InstanceUUID
+	source := cmd.Migrate.WorkloadAgentUUID
+
+	work := payloads.Start{
+		Start: payloads.StartCmd{
+			InstanceUUID:       instanceUUID,
+			RequestedResources: cmd.Migrate.RequestedResources,
+		},
+	}
+
+	workload, err := sched.getWorkloadResources(&work)
+	if err != nil {
+		glog.Errorf("Bad MIGRATE resource list from Controller %s: %s\n", controllerUUID, err)
+		sched.sendMigrationFailure(controllerUUID, &cmd.Migrate, "Invalid instance resources")
+		return
+	}
+
+	if workload.networkNode != 0 {
+		sched.sendMigrationFailure(controllerUUID, &cmd.Migrate, "Network node instances cannot be migrated")
+		return
+	}
+
+	if sched.getNodeStat(source) == nil {
+		glog.Errorf("MIGRATE for unknown node %s from Controller %s\n", source, controllerUUID)
+		sched.sendMigrationFailure(controllerUUID, &cmd.Migrate, "Unknown source node")
+		return
+	}
+
+	node := pickMigrationTarget(sched, source, cmd.Migrate.TargetAgentUUID, &workload)
+	if node == nil {
+		glog.Errorf("No target node to migrate instance %s from %s\n", instanceUUID, source)
+		sched.sendMigrationFailure(controllerUUID, &cmd.Migrate, "No node can host the instance")
+		return
+	}
+
+	reason := fmt.Sprintf("migration from %s, %d MB requested, %d MB available",
+		source, workload.memReqMB, node.memAvailMB)
+	sched.decrementResourceUsage(node, &workload)
+	sched.placements.record(&workload, node.uuid, reason)
+	cmd.Migrate.TargetAgentUUID = node.uuid
+	node.mutex.Unlock()
+
+	glog.Infof("Migrating instance %s from %s to %s\n", instanceUUID, source, cmd.Migrate.TargetAgentUUID)
+
+	b, err := yaml.Marshal(&cmd)
+	if err != nil {
+		glog.Errorf("Unable to Marshall MIGRATE %v", err)
+		sched.releaseInstance(cmd.Migrate.TargetAgentUUID, instanceUUID)
+		return
+	}
+
+	_, err = sched.ssntp.SendCommand(source, ssntp.MIGRATE, b)
+	if err != nil {
+		glog.Errorf("Unable to dispatch MIGRATE for instance %s to %s: %v", instanceUUID, source, err)
+		sched.releaseInstance(cmd.Migrate.TargetAgentUUID, instanceUUID)
+		sched.sendMigrationFailure(controllerUUID, &cmd.Migrate, "Unable to reach the source node")
+	}
+
+	return
+}
+
+// prepareMigrationTarget asks the target node of a live migration to
+// prepare the instance described by a MigrationPrepare event.
+func (sched *ssntpSchedulerServer) prepareMigrationTarget(uuid string, payload []byte) {
+	var event payloads.EventMigrationPrepare
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Errorf("Bad MigrationPrepare yaml from node %s", uuid)
+		return
+	}
+
+	cmd := payloads.MigrateIncoming{
+		MigrateIncoming: event.MigrationPrepare,
+	}
+	target := cmd.MigrateIncoming.TargetAgentUUID
+	cmd.MigrateIncoming.Instance.WorkloadAgentUUID = target
+
+	b, err := yaml.Marshal(&cmd)
+	if err != nil {
+		glog.Errorf("Unable to Marshall MigrateIncoming %v", err)
+		return
+	}
+
+	_, err = sched.ssntp.SendCommand(target, ssntp.MigrateIncoming, b)
+	if err != nil {
+		glog.Errorf("Unable to dispatch MigrateIncoming for instance %s to %s: %v",
+			cmd.MigrateIncoming.Instance.InstanceUUID, target, err)
+	}
+}
+
+// MigrationReady events go back to the source node of the migration.
+func (sched *ssntpSchedulerServer) fwdMigrationReady(payload []byte) (dest ssntp.ForwardDestination) {
+	var event payloads.EventMigrationReady
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil || event.MigrationReady.SourceAgentUUID == "" {
+		glog.Errorf("Bad MigrationReady yaml")
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	dest.AddRecipient(event.MigrationReady.SourceAgentUUID)
+
+	return
+}
+
+// InstanceMigrated events go to all Controllers, and to the target node of
+// the migration which keeps or discards its copy of the instance.
+func (sched *ssntpSchedulerServer) fwdInstanceMigrated(payload []byte) (dest ssntp.ForwardDestination) {
+	dest.SetDecision(ssntp.Discard)
+
+	var event payloads.EventInstanceMigrated
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Errorf("Bad InstanceMigrated yaml")
+		return
+	}
+
+	if event.InstanceMigrated.TargetAgentUUID != "" {
+		dest.AddRecipient(event.InstanceMigrated.TargetAgentUUID)
+	}
+
+	sched.controllerMutex.RLock()
+	for _, c := range sched.controllerMap {
+		dest.AddRecipient(c.uuid)
+	}
+	sched.controllerMutex.RUnlock()
+
+	return
+}
@@ -0,0 +1,100 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func migratePayload(t *testing.T, source, target string) []byte {
+	var cmd payloads.Migrate
+	err := yaml.Unmarshal([]byte(testutil.MigrateYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Migrate.WorkloadAgentUUID = source
+	cmd.Migrate.TargetAgentUUID = target
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return y
+}
+
+func TestMigrateInstance(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	controllerUUID := fmt.Sprintf("%08d", 1)
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 2)
+	spinUpComputeNodeVerySmall(sched, 3)
+	spinUpComputeNodeSmall(sched, 4)
+
+	source := fmt.Sprintf("%08d", 2)
+	verySmall := fmt.Sprintf("%08d", 3)
+	target := fmt.Sprintf("%08d", 4)
+
+	// MigrateYaml requests 1024 MB, which does not fit on the very
+	// small node, the source node is never picked.
+	dest, instanceUUID := migrateInstance(sched, controllerUUID, migratePayload(t, source, ""))
+	if instanceUUID != testutil.InstanceUUID {
+		t.Errorf("unexpected instance %s", instanceUUID)
+	}
+	if dest.Decision() != ssntp.Discard {
+		t.Error("MIGRATE forwarded without a target node")
+	}
+	placements := sched.placements.recent()
+	if len(placements) == 0 || placements[0].NodeID != target {
+		t.Errorf("instance not placed on the target node: %v", placements)
+	}
+	if len(sched.cnMap[source].reservations) != 0 {
+		t.Error("resources reserved on the source node")
+	}
+
+	// the source node is not connected, MIGRATE cannot be sent to it
+	if len(sched.cnMap[target].reservations) != 0 || sched.cnMap[target].memAvailMB != 16384 {
+		t.Error("resources of a failed migration left reserved on the target node")
+	}
+
+	_, _ = migrateInstance(sched, controllerUUID, migratePayload(t, source, verySmall))
+	if len(sched.cnMap[verySmall].reservations) != 0 {
+		t.Error("resources reserved on a target node without capacity")
+	}
+
+	dest = sched.fwdMigrationReady([]byte(testutil.MigrationReadyYaml))
+	if len(dest.Recipients()) != 1 || dest.Recipients()[0] != testutil.AgentUUID {
+		t.Errorf("MigrationReady not forwarded to %s: %v", testutil.AgentUUID, dest.Recipients())
+	}
+
+	dest = sched.fwdInstanceMigrated([]byte(testutil.InstanceMigratedYaml))
+	recipients := dest.Recipients()
+	if len(recipients) != 2 || recipients[0] != testutil.TargetAgentUUID ||
+		recipients[1] != controllerUUID {
+		t.Errorf("InstanceMigrated not forwarded to target and controller: %v", recipients)
+	}
+}
@@ -0,0 +1,167 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// How long resources claimed for a dispatched START are held, if the node
+// never reports the instance nor a start failure for it.
+const reservationTTL = 2 * time.Minute
+
+// A speculative resource claim for an instance dispatched to a node but
+// not yet accounted for by the node statistics.
+type reservation struct {
+	memMB   int
+	expires time.Time
+}
+
+// Reserve resources for workload on the referenced locked nodeStat object
+func (node *nodeStat) reserve(workload *workResources, now time.Time) {
+	if node.reservations == nil {
+		node.reservations = make(map[string]reservation)
+	}
+
+	// a new START for the same instance replaces the previous claim
+	node.release(workload.instanceUUID)
+
+	node.reservations[workload.instanceUUID] = reservation{
+		memMB:   workload.memReqMB,
+		expires: now.Add(reservationTTL),
+	}
+	node.memAvailMB -= workload.memReqMB
+}
+
+// Release the instance reservation on the referenced locked nodeStat
+// object, returning true if there was one.
+func (node *nodeStat) release(instanceUUID string) bool {
+	r, ok := node.reservations[instanceUUID]
+	if !ok {
+		return false
+	}
+
+	delete(node.reservations, instanceUUID)
+	node.memAvailMB += r.memMB
+
+	return true
+}
+
+// Release the expired reservations on the referenced locked nodeStat object
+func (node *nodeStat) expireReservations(now time.Time) {
+	for instanceUUID, r := range node.reservations {
+		if now.After(r.expires) {
+			glog.Warningf("Reservation for instance %s on node %s expired",
+				instanceUUID, node.uuid)
+			node.release(instanceUUID)
+		}
+	}
+}
+
+// Memory claimed by the outstanding reservations on the referenced locked
+// nodeStat object
+func (node *nodeStat) reservedMemMB() (memMB int) {
+	for _, r := range node.reservations {
+		memMB += r.memMB
+	}
+
+	return
+}
+
+// Get the nodeStat for a connected compute or network node
+func (sched *ssntpSchedulerServer) getNodeStat(uuid string) *nodeStat {
+	sched.cnMutex.RLock()
+	node := sched.cnMap[uuid]
+	sched.cnMutex.RUnlock()
+
+	if node != nil {
+		return node
+	}
+
+	sched.nnMutex.RLock()
+	node = sched.nnMap[uuid]
+	sched.nnMutex.RUnlock()
+
+	return node
+}
+
+// Instances reported in a node STATS command are accounted for by the node
+// available memory, their reservations can be released.  The node available
+// memory is then the one reported, minus the still pending reservations.
+func (sched *ssntpSchedulerServer) releaseReportedInstances(uuid string, payload []byte) {
+	var stats payloads.Stat
+	err := yaml.Unmarshal(payload, &stats)
+	if err != nil {
+		glog.Errorf("Bad STATS yaml from node %s", uuid)
+		return
+	}
+
+	node := sched.getNodeStat(uuid)
+	if node == nil {
+		return
+	}
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	for _, instance := range stats.Instances {
+		if node.release(instance.InstanceUUID) {
+			glog.V(2).Infof("Instance %s reported by node %s, reservation released",
+				instance.InstanceUUID, uuid)
+		}
+	}
+
+	node.expireReservations(time.Now())
+
+	if stats.MemAvailableMB >= 0 {
+		node.memAvailMB = stats.MemAvailableMB - node.reservedMemMB()
+	}
+}
+
+// Release the reservation for an instance on a node, returning true if
+// there was one.
+func (sched *ssntpSchedulerServer) releaseInstance(nodeUUID string, instanceUUID string) bool {
+	node := sched.getNodeStat(nodeUUID)
+	if node == nil {
+		return false
+	}
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return node.release(instanceUUID)
+}
+
+// An instance that failed to start on a node does not consume any of its
+// resources.
+func (sched *ssntpSchedulerServer) releaseFailedInstance(uuid string, payload []byte) {
+	var failure payloads.ErrorStartFailure
+	err := yaml.Unmarshal(payload, &failure)
+	if err != nil {
+		glog.Errorf("Bad StartFailure yaml from node %s", uuid)
+		return
+	}
+
+	if sched.releaseInstance(uuid, failure.InstanceUUID) {
+		glog.V(2).Infof("Instance %s failed to start on node %s, reservation released",
+			failure.InstanceUUID, uuid)
+	}
+}
@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/testutil"
+)
+
+func TestReservationExpiry(t *testing.T) {
+	now := time.Now()
+	node := nodeStat{
+		uuid:       "node",
+		memTotalMB: 4096,
+		memAvailMB: 4096,
+	}
+
+	node.reserve(&workResources{instanceUUID: "a", memReqMB: 1024}, now)
+	node.reserve(&workResources{instanceUUID: "b", memReqMB: 512}, now.Add(time.Minute))
+
+	// a second START for the same instance does not claim twice
+	node.reserve(&workResources{instanceUUID: "b", memReqMB: 512}, now.Add(time.Minute))
+
+	if node.memAvailMB != 4096-1536 || node.reservedMemMB() != 1536 {
+		t.Fatalf("wrong reservations: %d MB available, %d MB reserved",
+			node.memAvailMB, node.reservedMemMB())
+	}
+
+	node.expireReservations(now.Add(reservationTTL + time.Second))
+	if node.memAvailMB != 4096-512 || len(node.reservations) != 1 {
+		t.Errorf("reservation not expired: %d MB available, %v",
+			node.memAvailMB, node.reservations)
+	}
+
+	if node.release("a") {
+		t.Error("expired reservation released")
+	}
+}
+
+func TestReservationReadyUpdate(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpComputeNode(sched, 1, 3896)
+	node := sched.cnMap[fmt.Sprintf("%08d", 1)]
+
+	node.mutex.Lock()
+	node.reserve(&workResources{instanceUUID: testutil.InstanceUUID, memReqMB: 1000}, time.Now())
+	node.mutex.Unlock()
+
+	// the node has not accounted for the instance yet
+	sched.updateNodeStat(node, ssntp.READY, &ssntp.Frame{Payload: []byte(testutil.ReadyYaml)})
+	if node.memAvailMB != 2896 {
+		t.Errorf("expected 2896 MB available, got %d", node.memAvailMB)
+	}
+}
+
+func TestReservationReleasedByStats(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpComputeNode(sched, 1, 3896)
+	nodeUUID := fmt.Sprintf("%08d", 1)
+	node := sched.cnMap[nodeUUID]
+
+	node.mutex.Lock()
+	node.reserve(&workResources{instanceUUID: "fe2970fa-7b36-460b-8b79-9eb4745e62f2", memReqMB: 1000}, time.Now())
+	node.reserve(&workResources{instanceUUID: testutil.InstanceUUID, memReqMB: 500}, time.Now())
+	node.mutex.Unlock()
+
+	// the available memory drifted, the node reports 3896 MB available,
+	// minus the still pending 500 MB
+	node.mutex.Lock()
+	node.memAvailMB = 0
+	node.mutex.Unlock()
+
+	sched.releaseReportedInstances(nodeUUID, []byte(testutil.StatsYaml))
+	if node.memAvailMB != 3396 || len(node.reservations) != 1 {
+		t.Errorf("reported instance reservation not released: %d MB available, %v",
+			node.memAvailMB, node.reservations)
+	}
+
+	// STATS from an unknown node are ignored
+	sched.releaseReportedInstances("unknown", []byte(testutil.StatsYaml))
+}
+
+func TestReservationReleasedByStartFailure(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 1)
+	nodeUUID := fmt.Sprintf("%08d", 1)
+	node := sched.cnMap[nodeUUID]
+
+	startWorkload(sched, fmt.Sprintf("%08d", 1), []byte(testutil.StartYaml))
+
+	if len(node.reservations) != 1 || node.memAvailMB == 16384 {
+		t.Fatalf("START did not reserve resources: %d MB available, %v",
+			node.memAvailMB, node.reservations)
+	}
+
+	sched.releaseFailedInstance(nodeUUID, []byte(testutil.StartFailureYaml))
+	if len(node.reservations) != 0 || node.memAvailMB != 16384 {
+		t.Errorf("failed instance reservation not released: %d MB available, %v",
+			node.memAvailMB, node.reservations)
+	}
+}
@@ -0,0 +1,95 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// restartInstance forwards a RESTART command to the node of the instance.
+// A resized instance is restarted on its node only if the node can host its
+// new resources, otherwise the node is asked to relocate the instance.
+func restartInstance(sched *ssntpSchedulerServer, controllerUUID string, payload []byte) (dest ssntp.ForwardDestination, instanceUUID string) {
+	var cmd payloads.Restart
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil || !cmd.Restart.Resize {
+		return sched.fwdCmdToComputeNode(ssntp.RESTART, payload)
+	}
+
+	instanceUUID = cmd.Restart.InstanceUUID
+	nodeUUID := cmd.Restart.WorkloadAgentUUID
+
+	work := payloads.Start{
+		Start: payloads.StartCmd{
+			TenantUUID:         cmd.Restart.TenantUUID,
+			InstanceUUID:       instanceUUID,
+			RequestedResources: cmd.Restart.RequestedResources,
+		},
+	}
+
+	workload, err := sched.getWorkloadResources(&work)
+	if err != nil {
+		glog.Errorf("Bad resized instance %s resource list from Controller %s: %s\n",
+			instanceUUID, controllerUUID, err)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	node := sched.getNodeStat(nodeUUID)
+	if node == nil {
+		glog.Errorf("RESTART for unknown node %s from Controller %s\n", nodeUUID, controllerUUID)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	node.mutex.Lock()
+	if sched.workloadFits(node, &workload) {
+		reason := fmt.Sprintf("resize, %d MB requested, %d MB available", workload.memReqMB, node.memAvailMB)
+		sched.decrementResourceUsage(node, &workload)
+		sched.placements.record(&workload, nodeUUID, reason)
+		node.mutex.Unlock()
+
+		dest.AddRecipient(nodeUUID)
+		return
+	}
+	node.mutex.Unlock()
+
+	// The instance is placed again once the node hands it back in an
+	// InstancesEvacuated event.
+	glog.Infof("Resized instance %s does not fit on node %s, relocating it\n", instanceUUID, nodeUUID)
+
+	dest.SetDecision(ssntp.Discard)
+
+	cmd.Restart.Relocate = true
+	b, err := yaml.Marshal(&cmd)
+	if err != nil {
+		glog.Errorf("Unable to Marshall RESTART %v", err)
+		return
+	}
+
+	_, err = sched.ssntp.SendCommand(nodeUUID, ssntp.RESTART, b)
+	if err != nil {
+		glog.Errorf("Unable to dispatch RESTART for instance %s to %s: %v", instanceUUID, nodeUUID, err)
+	}
+
+	return
+}
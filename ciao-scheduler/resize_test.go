@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func resizePayload(t *testing.T, nodeUUID string) []byte {
+	var cmd payloads.Restart
+	err := yaml.Unmarshal([]byte(testutil.ResizeRestartYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Restart.WorkloadAgentUUID = nodeUUID
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return y
+}
+
+func TestRestartResizedInstance(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	controllerUUID := fmt.Sprintf("%08d", 1)
+	spinUpController(sched, 1, controllerMaster)
+	spinUpComputeNodeSmall(sched, 2)
+	spinUpComputeNodeVerySmall(sched, 3)
+
+	// ResizeRestartYaml requests 8192 MB, which only fits on the small node
+	small := fmt.Sprintf("%08d", 2)
+	dest, instanceUUID := restartInstance(sched, controllerUUID, resizePayload(t, small))
+	if instanceUUID != testutil.InstanceUUID {
+		t.Errorf("unexpected instance %s", instanceUUID)
+	}
+	if len(dest.Recipients()) != 1 || dest.Recipients()[0] != small {
+		t.Fatalf("RESTART not forwarded to %s: %v", small, dest.Recipients())
+	}
+	if _, ok := sched.cnMap[small].reservations[testutil.InstanceUUID]; !ok {
+		t.Error("resources of the resized instance not reserved")
+	}
+
+	verySmall := fmt.Sprintf("%08d", 3)
+	dest, _ = restartInstance(sched, controllerUUID, resizePayload(t, verySmall))
+	if dest.Decision() != ssntp.Discard {
+		t.Error("RESTART forwarded to a node without capacity")
+	}
+	if len(sched.cnMap[verySmall].reservations) != 0 {
+		t.Error("resources reserved on a node without capacity")
+	}
+
+	dest, _ = restartInstance(sched, controllerUUID, []byte(testutil.RestartYaml))
+	if len(dest.Recipients()) != 1 || dest.Recipients()[0] != testutil.AgentUUID {
+		t.Errorf("RESTART not forwarded to %s: %v", testutil.AgentUUID, dest.Recipients())
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/01org/ciao/configuration"
 	"github.com/01org/ciao/osprepare"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
@@ -37,6 +38,7 @@ var cert = flag.String("cert", "/etc/pki/ciao/cert-Scheduler-localhost.pem", "Se
 var cacert = flag.String("cacert", "/etc/pki/ciao/CAcert-server-localhost.pem", "CA certificate")
 var cpuprofile = flag.String("cpuprofile", "", "Write cpu profile to file")
 var heartbeat = flag.Bool("heartbeat", false, "Emit status heartbeat text")
+var adminPort = flag.Int("admin-port", defaultAdminPort, "Local HTTPS admin API port, 0 to disable")
 var logDir = "/var/lib/ciao/logs/scheduler"
 var configURI = flag.String("configuration-uri", "file:///etc/ciao/configuration.yaml",
 	"Cluster configuration URI")
@@ -45,6 +47,7 @@ type ssntpSchedulerServer struct {
 	// user config overrides ------------------------------------------
 	heartbeat  bool
 	cpuprofile string
+	adminPort  int
 
 	// ssntp ----------------------------------------------------------
 	config *ssntp.Config
@@ -69,6 +72,12 @@ type ssntpSchedulerServer struct {
 	nnMap   map[string]*nodeStat
 	nnMutex sync.RWMutex // Rlock traversing map, Lock modifying map
 	nnMRU   string
+
+	// Recent placement decisions, for the admin API
+	placements placementLog
+
+	// Per tenant START rate limiting and fair dispatching
+	starts *startDispatcher
 }
 
 func newSsntpSchedulerServer() *ssntpSchedulerServer {
@@ -77,6 +86,10 @@ func newSsntpSchedulerServer() *ssntpSchedulerServer {
 		cnMap:         make(map[string]*nodeStat),
 		cnMRUIndex:    -1,
 		nnMap:         make(map[string]*nodeStat),
+		placements: placementLog{
+			entries: make([]payloads.SchedulerPlacement, maxPlacements),
+		},
+		starts: newStartDispatcher(),
 	}
 }
 
@@ -85,9 +98,15 @@ type nodeStat struct {
 	status     ssntp.Status
 	uuid       string
 	memTotalMB int
-	memAvailMB int
+	memAvailMB int // reported available memory minus reservations
 	load       int
 	cpus       int
+
+	// resources claimed by dispatched instances not yet reported by the node
+	reservations map[string]reservation
+
+	// set once the node is being evacuated, no instance is placed on it
+	draining bool
 }
 
 type controllerStatus uint8
@@ -148,6 +167,25 @@ func (sched *ssntpSchedulerServer) sendNodeConnectionEvent(nodeUUID, controllerU
 	return sched.ssntp.SendEvent(controllerUUID, ssntp.NodeDisconnected, b)
 }
 
+func (sched *ssntpSchedulerServer) sendControllerRole(controllerUUID string, role payloads.ControllerRoleType) {
+	payload := payloads.ControllerRole{
+		ControllerRole: payloads.ControllerRoleEvent{
+			Role: role,
+		},
+	}
+
+	b, err := yaml.Marshal(&payload)
+	if err != nil {
+		glog.Errorf("Unable to Marshall ControllerRole %v", err)
+		return
+	}
+
+	_, err = sched.ssntp.SendEvent(controllerUUID, ssntp.ControllerRole, b)
+	if err != nil {
+		glog.Warningf("Unable to send the %s role to controller %s: %v\n", role, controllerUUID, err)
+	}
+}
+
 func (sched *ssntpSchedulerServer) sendNodeConnectedEvents(nodeUUID string, nodeType payloads.Resource) {
 	sched.controllerMutex.RLock()
 	defer sched.controllerMutex.RUnlock()
@@ -223,21 +261,82 @@ func disconnectController(sched *ssntpSchedulerServer, uuid string) {
 	for i, c := range sched.controllerList {
 		c.mutex.Lock()
 		if c.status == controllerBackup {
-			c.status = controllerMaster
-			//TODO: inform the Controller it is master
 			c.mutex.Unlock()
-
-			// move to front of list
-			front := sched.controllerList[:i]
-			back := sched.controllerList[i+1:]
-			sched.controllerList = append([]*controllerStat{c}, front...)
-			sched.controllerList = append(sched.controllerList, back...)
+			promoteController(sched, i)
 			break
 		}
 		c.mutex.Unlock()
 	}
 }
 
+// Promote the backup controller at index i of the locked controller list
+// to master, moving it to the front of the list.
+func promoteController(sched *ssntpSchedulerServer, i int) {
+	c := sched.controllerList[i]
+
+	c.mutex.Lock()
+	c.status = controllerMaster
+	c.mutex.Unlock()
+
+	sched.sendControllerRole(c.uuid, payloads.ControllerMaster)
+
+	// move to front of list
+	front := sched.controllerList[:i]
+	back := sched.controllerList[i+1:]
+	sched.controllerList = append([]*controllerStat{c}, front...)
+	sched.controllerList = append(sched.controllerList, back...)
+}
+
+// Force a controller failover: the current master becomes a backup and the
+// backup controller identified by uuid is promoted to master.  An empty uuid
+// promotes the first backup controller.
+func forceControllerFailover(sched *ssntpSchedulerServer, uuid string) (string, error) {
+	sched.controllerMutex.Lock()
+	defer sched.controllerMutex.Unlock()
+
+	if len(sched.controllerList) == 0 {
+		return "", fmt.Errorf("no controller connected")
+	}
+
+	if uuid != "" && sched.controllerMap[uuid] == nil {
+		return "", fmt.Errorf("unknown controller %s", uuid)
+	}
+
+	newMaster := -1
+	for i, c := range sched.controllerList {
+		c.mutex.Lock()
+		if c.status == controllerBackup && (uuid == "" || c.uuid == uuid) {
+			newMaster = i
+		}
+		c.mutex.Unlock()
+
+		if newMaster != -1 {
+			break
+		}
+	}
+
+	if newMaster == -1 {
+		return "", fmt.Errorf("no backup controller to promote")
+	}
+
+	// demote the current master, and move it at the end of the list
+	master := sched.controllerList[0]
+	master.mutex.Lock()
+	if master.status == controllerMaster {
+		master.status = controllerBackup
+		sched.controllerList = append(sched.controllerList[1:], master)
+		newMaster--
+		sched.sendControllerRole(master.uuid, payloads.ControllerBackup)
+	}
+	master.mutex.Unlock()
+
+	promoteController(sched, newMaster)
+
+	glog.Warningf("Forced controller failover, new master %s\n", sched.controllerList[0].uuid)
+
+	return sched.controllerList[0].uuid, nil
+}
+
 // Add state for newly connected Compute Node
 // This function is symmetric with disconnectComputeNode().
 func connectComputeNode(sched *ssntpSchedulerServer, uuid string) {
@@ -368,8 +467,9 @@ func (sched *ssntpSchedulerServer) updateNodeStat(node *nodeStat, status ssntp.S
 			glog.Errorf("Bad READY yaml for node %s\n", node.uuid)
 			return
 		}
+		node.expireReservations(time.Now())
 		node.memTotalMB = stats.MemTotalMB
-		node.memAvailMB = stats.MemAvailableMB
+		node.memAvailMB = stats.MemAvailableMB - node.reservedMemMB()
 		node.load = stats.Load
 		node.cpus = stats.CpusOnline
 		//TODO pull in other types of payloads.Ready struct data
@@ -410,6 +510,7 @@ func (sched *ssntpSchedulerServer) StatusNotify(uuid string, status ssntp.Status
 
 type workResources struct {
 	instanceUUID string
+	tenantUUID   string
 	memReqMB     int
 	networkNode  int
 }
@@ -438,8 +539,9 @@ func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (w
 		return workload, fmt.Errorf("invalid start payload resource demand: network_node (%d) is not 0 or 1", workload.networkNode)
 	}
 
-	// note the uuid
+	// note the uuids
 	workload.instanceUUID = work.Start.InstanceUUID
+	workload.tenantUUID = work.Start.TenantUUID
 
 	return workload, nil
 }
@@ -448,15 +550,17 @@ func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (w
 func (sched *ssntpSchedulerServer) workloadFits(node *nodeStat, workload *workResources) bool {
 	// simple scheduling policy == first memory fit
 	if node.memAvailMB >= workload.memReqMB &&
-		node.status == ssntp.READY {
+		node.status == ssntp.READY && !node.draining {
 		return true
 	}
 	return false
 }
 
-func (sched *ssntpSchedulerServer) sendStartFailureError(clientUUID string, instanceUUID string, reason payloads.StartFailureReason) {
+func (sched *ssntpSchedulerServer) sendStartFailureError(clientUUID string, workload *workResources, reason payloads.StartFailureReason) {
+	sched.placements.record(workload, "", reason.String())
+
 	error := payloads.ErrorStartFailure{
-		InstanceUUID: instanceUUID,
+		InstanceUUID: workload.instanceUUID,
 		Reason:       reason,
 	}
 
@@ -467,6 +571,10 @@ func (sched *ssntpSchedulerServer) sendStartFailureError(clientUUID string, inst
 	}
 
 	glog.Warningf("Unable to dispatch: %v\n", reason)
+	if clientUUID == "" {
+		// instance placed on behalf of the scheduler itself
+		return
+	}
 	sched.ssntp.SendError(clientUUID, ssntp.StartFailure, payload)
 }
 func (sched *ssntpSchedulerServer) getConcentratorUUID(event ssntp.Event, payload []byte) (string, error) {
@@ -484,6 +592,46 @@ func (sched *ssntpSchedulerServer) getConcentratorUUID(event ssntp.Event, payloa
 	}
 }
 
+func getCNCICommandConcentratorUUID(command ssntp.Command, payload []byte) (string, string, error) {
+	switch command {
+	default:
+		return "", "", fmt.Errorf("unsupported ssntp.Command type \"%s\"", command)
+	case ssntp.AssignPublicIP:
+		var cmd payloads.CommandAssignPublicIP
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.AssignIP.InstanceUUID, cmd.AssignIP.ConcentratorUUID, err
+	case ssntp.ReleasePublicIP:
+		var cmd payloads.CommandReleasePublicIP
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.ReleaseIP.InstanceUUID, cmd.ReleaseIP.ConcentratorUUID, err
+	case ssntp.ApplySecurityRules:
+		var cmd payloads.CommandApplySecurityRules
+		err := yaml.Unmarshal(payload, &cmd)
+		return "", cmd.Apply.ConcentratorUUID, err
+	case ssntp.ClearSecurityRules:
+		var cmd payloads.CommandClearSecurityRules
+		err := yaml.Unmarshal(payload, &cmd)
+		return "", cmd.Clear.ConcentratorUUID, err
+	}
+}
+
+func (sched *ssntpSchedulerServer) fwdCmdToCNCI(command ssntp.Command, payload []byte) (dest ssntp.ForwardDestination, instanceUUID string) {
+	// the public IP and security rules commands are handled by the
+	// tenant CNCI
+
+	instanceUUID, concentratorUUID, err := getCNCICommandConcentratorUUID(command, payload)
+	if err != nil || concentratorUUID == "" {
+		glog.Errorf("Bad %s command yaml from Controller, concentratorUUID == %s\n", command, concentratorUUID)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	glog.V(2).Infof("Forwarding %s command to %s\n", command.String(), concentratorUUID)
+	dest.AddRecipient(concentratorUUID)
+
+	return dest, instanceUUID
+}
+
 func (sched *ssntpSchedulerServer) fwdEventToCNCI(event ssntp.Event, payload []byte) (dest ssntp.ForwardDestination) {
 	// since the scheduler is the primary ssntp server, it needs to
 	// unwrap event payloads and forward them to the approriate recipient
@@ -530,6 +678,35 @@ func getWorkloadAgentUUID(sched *ssntpSchedulerServer, command ssntp.Command, pa
 		var cmd payloads.DetachVolume
 		err := yaml.Unmarshal(payload, &cmd)
 		return cmd.Detach.InstanceUUID, cmd.Detach.WorkloadAgentUUID, err
+
+	case ssntp.REBOOT:
+		var cmd payloads.Reboot
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Reboot.InstanceUUID, cmd.Reboot.WorkloadAgentUUID, err
+	case ssntp.PAUSE:
+		var cmd payloads.Pause
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Pause.InstanceUUID, cmd.Pause.WorkloadAgentUUID, err
+	case ssntp.UNPAUSE:
+		var cmd payloads.Unpause
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Unpause.InstanceUUID, cmd.Unpause.WorkloadAgentUUID, err
+	case ssntp.SUSPEND:
+		var cmd payloads.Suspend
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Suspend.InstanceUUID, cmd.Suspend.WorkloadAgentUUID, err
+	case ssntp.RESUME:
+		var cmd payloads.Resume
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Resume.InstanceUUID, cmd.Resume.WorkloadAgentUUID, err
+	case ssntp.GetConsoleLog:
+		var cmd payloads.GetConsoleLog
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.GetConsoleLog.InstanceUUID, cmd.GetConsoleLog.WorkloadAgentUUID, err
+	case ssntp.CreateImage:
+		var cmd payloads.CreateImage
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.CreateImage.InstanceUUID, cmd.CreateImage.WorkloadAgentUUID, err
 	}
 }
 
@@ -549,9 +726,11 @@ func (sched *ssntpSchedulerServer) fwdCmdToComputeNode(command ssntp.Command, pa
 	return
 }
 
-// Decrement resource claims for the referenced locked nodeStat object
+// Decrement resource claims for the referenced locked nodeStat object.
+// The claim is speculative: it is held until the node reports the instance
+// in its STATS, reports a start failure for it, or the claim expires.
 func (sched *ssntpSchedulerServer) decrementResourceUsage(node *nodeStat, workload *workResources) {
-	node.memAvailMB -= workload.memReqMB
+	node.reserve(workload, time.Now())
 }
 
 // Find suitable compute node, returning referenced to a locked nodeStat if found
@@ -561,7 +740,7 @@ func pickComputeNode(sched *ssntpSchedulerServer, controllerUUID string, workloa
 
 	if len(sched.cnList) == 0 {
 		glog.Errorf("No compute nodes connected, unable to start workload")
-		sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoComputeNodes)
+		sched.sendStartFailureError(controllerUUID, workload, payloads.NoComputeNodes)
 		return nil
 	}
 
@@ -594,7 +773,7 @@ func pickComputeNode(sched *ssntpSchedulerServer, controllerUUID string, workloa
 		node.mutex.Unlock()
 	}
 
-	sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.FullCloud)
+	sched.sendStartFailureError(controllerUUID, workload, payloads.FullCloud)
 	return nil
 }
 
@@ -605,7 +784,7 @@ func (sched *ssntpSchedulerServer) pickNetworkNode(controllerUUID string, worklo
 
 	if len(sched.nnMap) == 0 {
 		glog.Errorf("No network nodes connected, unable to start network workload")
-		sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoNetworkNodes)
+		sched.sendStartFailureError(controllerUUID, workload, payloads.NoNetworkNodes)
 		return nil
 	}
 
@@ -617,12 +796,38 @@ func (sched *ssntpSchedulerServer) pickNetworkNode(controllerUUID string, worklo
 			sched.nnMRU = node.uuid
 			return node // locked nodeStat
 		}
+		node.mutex.Unlock()
 	}
 
-	sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoNetworkNodes)
+	sched.sendStartFailureError(controllerUUID, workload, payloads.NoNetworkNodes)
 	return nil
 }
 
+// Pick a node for the workload and claim its resources, returning the
+// node UUID or an empty string if the workload does not fit anywhere.
+func placeWorkload(sched *ssntpSchedulerServer, controllerUUID string, workload *workResources) string {
+	var targetNode *nodeStat
+
+	if workload.networkNode == 0 {
+		targetNode = pickComputeNode(sched, controllerUUID, workload)
+	} else { //workload.network_node == 1
+		targetNode = sched.pickNetworkNode(controllerUUID, workload)
+	}
+
+	if targetNode == nil {
+		return ""
+	}
+
+	reason := fmt.Sprintf("%d MB requested, %d MB available", workload.memReqMB, targetNode.memAvailMB)
+	sched.decrementResourceUsage(targetNode, workload)
+	sched.placements.record(workload, targetNode.uuid, reason)
+
+	nodeUUID := targetNode.uuid
+	targetNode.mutex.Unlock()
+
+	return nodeUUID
+}
+
 func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload []byte) (dest ssntp.ForwardDestination, instanceUUID string) {
 	var work payloads.Start
 	err := yaml.Unmarshal(payload, &work)
@@ -641,26 +846,22 @@ func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload [
 
 	instanceUUID = workload.instanceUUID
 
-	var targetNode *nodeStat
-
-	if workload.networkNode == 0 {
-		targetNode = pickComputeNode(sched, controllerUUID, &workload)
-	} else { //workload.network_node == 1
-		targetNode = sched.pickNetworkNode(controllerUUID, &workload)
+	switch sched.starts.admit(controllerUUID, payload, &workload, time.Now()) {
+	case startQueued:
+		// dispatched later by the START dispatch loop
+		glog.V(2).Infof("START for instance %s queued, tenant %s is rate limited\n", instanceUUID, workload.tenantUUID)
+		dest.SetDecision(ssntp.Discard)
+		return dest, instanceUUID
+	case startThrottled:
+		sched.sendStartFailureError(controllerUUID, &workload, payloads.TenantThrottled)
+		dest.SetDecision(ssntp.Discard)
+		return dest, instanceUUID
 	}
 
-	if targetNode != nil {
-		//TODO: mark the targetNode as unavailable until next stats / READY checkin?
-		//	or is subtracting mem demand sufficiently speculative enough?
-		//	Goal is to have spread, not schedule "too many" workloads back
-		//	to back on the same targetNode, but also not add latency to dispatch and
-		//	hopefully not queue when all nodes have just started a workload.
-		sched.decrementResourceUsage(targetNode, &workload)
-
-		dest.AddRecipient(targetNode.uuid)
-		targetNode.mutex.Unlock()
+	nodeUUID := placeWorkload(sched, controllerUUID, &workload)
+	if nodeUUID != "" {
+		dest.AddRecipient(nodeUUID)
 	} else {
-		// TODO Queue the frame ?
 		dest.SetDecision(ssntp.Discard)
 	}
 
@@ -696,8 +897,10 @@ func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command
 	// the main command with scheduler processing
 	case ssntp.START:
 		dest, instanceUUID = startWorkload(sched, controllerUUID, payload)
+	case ssntp.StartBatch:
+		dest = startBatch(sched, controllerUUID, payload)
 	case ssntp.RESTART:
-		fallthrough
+		dest, instanceUUID = restartInstance(sched, controllerUUID, payload)
 	case ssntp.STOP:
 		fallthrough
 	case ssntp.DELETE:
@@ -706,8 +909,34 @@ func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command
 		fallthrough
 	case ssntp.DetachVolume:
 		fallthrough
-	case ssntp.EVACUATE:
+	case ssntp.REBOOT:
+		fallthrough
+	case ssntp.PAUSE:
+		fallthrough
+	case ssntp.UNPAUSE:
+		fallthrough
+	case ssntp.SUSPEND:
+		fallthrough
+	case ssntp.RESUME:
+		fallthrough
+	case ssntp.GetConsoleLog:
+		fallthrough
+	case ssntp.CreateImage:
 		dest, instanceUUID = sched.fwdCmdToComputeNode(command, payload)
+	case ssntp.AssignPublicIP:
+		fallthrough
+	case ssntp.ReleasePublicIP:
+		fallthrough
+	case ssntp.ApplySecurityRules:
+		fallthrough
+	case ssntp.ClearSecurityRules:
+		dest, instanceUUID = sched.fwdCmdToCNCI(command, payload)
+	case ssntp.EVACUATE:
+		dest = evacuateNode(sched, controllerUUID, payload)
+	case ssntp.RESTORE:
+		dest = restoreNode(sched, controllerUUID, payload)
+	case ssntp.MIGRATE:
+		dest, instanceUUID = migrateInstance(sched, controllerUUID, payload)
 	default:
 		dest.SetDecision(ssntp.Discard)
 	}
@@ -722,6 +951,23 @@ func (sched *ssntpSchedulerServer) CommandNotify(uuid string, command ssntp.Comm
 	// Currently all commands are handled by CommandForward, the SSNTP command forwader,
 	// or directly by role defined forwarding rules.
 	glog.V(2).Infof("COMMAND %v from %s\n", command, uuid)
+
+	switch command {
+	case ssntp.CONFIGURE:
+		// Only controllers can update the cluster configuration
+		sched.controllerMutex.RLock()
+		controller := sched.controllerMap[uuid]
+		sched.controllerMutex.RUnlock()
+
+		if controller == nil {
+			glog.Warningf("Ignoring CONFIGURE from %s, not a Controller\n", uuid)
+			return
+		}
+
+		loadStartLimits(sched, frame.Payload)
+	case ssntp.STATS:
+		sched.releaseReportedInstances(uuid, frame.Payload)
+	}
 }
 
 func (sched *ssntpSchedulerServer) EventForward(uuid string, event ssntp.Event, frame *ssntp.Frame) (dest ssntp.ForwardDestination) {
@@ -734,6 +980,10 @@ func (sched *ssntpSchedulerServer) EventForward(uuid string, event ssntp.Event,
 		fallthrough
 	case ssntp.TenantRemoved:
 		dest = sched.fwdEventToCNCI(event, payload)
+	case ssntp.MigrationReady:
+		dest = sched.fwdMigrationReady(payload)
+	case ssntp.InstanceMigrated:
+		dest = sched.fwdInstanceMigrated(payload)
 	}
 
 	elapsed := time.Since(start)
@@ -746,10 +996,21 @@ func (sched *ssntpSchedulerServer) EventNotify(uuid string, event ssntp.Event, f
 	// Currently all events are handled by EventForward, the SSNTP command forwader,
 	// or directly by role defined forwarding rules.
 	glog.V(2).Infof("EVENT %v from %s\n", event, uuid)
+
+	switch event {
+	case ssntp.InstancesEvacuated:
+		sched.replaceEvacuatedInstances(uuid, frame.Payload)
+	case ssntp.MigrationPrepare:
+		sched.prepareMigrationTarget(uuid, frame.Payload)
+	}
 }
 
 func (sched *ssntpSchedulerServer) ErrorNotify(uuid string, error ssntp.Error, frame *ssntp.Frame) {
 	glog.V(2).Infof("ERROR %v from %s\n", error, uuid)
+
+	if error == ssntp.StartFailure {
+		sched.releaseFailedInstance(uuid, frame.Payload)
+	}
 }
 
 func setLimits() {
@@ -946,6 +1207,14 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand: ssntp.InstanceDeleted,
 			Dest:    ssntp.Controller,
 		},
+		{ // all ConsoleLog events go to all Controllers
+			Operand: ssntp.ConsoleLog,
+			Dest:    ssntp.Controller,
+		},
+		{ // all ImageCreated events go to all Controllers
+			Operand: ssntp.ImageCreated,
+			Dest:    ssntp.Controller,
+		},
 		{ // all ConcentratorInstanceAdded events go to all Controllers
 			Operand: ssntp.ConcentratorInstanceAdded,
 			Dest:    ssntp.Controller,
@@ -974,6 +1243,10 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.START,
 			CommandForward: sched,
 		},
+		{ // all StartBatch command are processed by the Command forwarder
+			Operand:        ssntp.StartBatch,
+			CommandForward: sched,
+		},
 		{ // all RESTART command are processed by the Command forwarder
 			Operand:        ssntp.RESTART,
 			CommandForward: sched,
@@ -990,6 +1263,10 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.EVACUATE,
 			CommandForward: sched,
 		},
+		{ // all RESTORE command are processed by the Command forwarder
+			Operand:        ssntp.RESTORE,
+			CommandForward: sched,
+		},
 		{ // all TenantAdded events are processed by the Event forwarder
 			Operand:      ssntp.TenantAdded,
 			EventForward: sched,
@@ -1006,6 +1283,62 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.DetachVolume,
 			CommandForward: sched,
 		},
+		{ // all AssignPublicIP commands are processed by the Command forwarder
+			Operand:        ssntp.AssignPublicIP,
+			CommandForward: sched,
+		},
+		{ // all ReleasePublicIP commands are processed by the Command forwarder
+			Operand:        ssntp.ReleasePublicIP,
+			CommandForward: sched,
+		},
+		{ // all ApplySecurityRules commands are processed by the Command forwarder
+			Operand:        ssntp.ApplySecurityRules,
+			CommandForward: sched,
+		},
+		{ // all ClearSecurityRules commands are processed by the Command forwarder
+			Operand:        ssntp.ClearSecurityRules,
+			CommandForward: sched,
+		},
+		{ // all REBOOT commands are processed by the Command forwarder
+			Operand:        ssntp.REBOOT,
+			CommandForward: sched,
+		},
+		{ // all PAUSE commands are processed by the Command forwarder
+			Operand:        ssntp.PAUSE,
+			CommandForward: sched,
+		},
+		{ // all UNPAUSE commands are processed by the Command forwarder
+			Operand:        ssntp.UNPAUSE,
+			CommandForward: sched,
+		},
+		{ // all SUSPEND commands are processed by the Command forwarder
+			Operand:        ssntp.SUSPEND,
+			CommandForward: sched,
+		},
+		{ // all RESUME commands are processed by the Command forwarder
+			Operand:        ssntp.RESUME,
+			CommandForward: sched,
+		},
+		{ // all GetConsoleLog commands are processed by the Command forwarder
+			Operand:        ssntp.GetConsoleLog,
+			CommandForward: sched,
+		},
+		{ // all CreateImage commands are processed by the Command forwarder
+			Operand:        ssntp.CreateImage,
+			CommandForward: sched,
+		},
+		{ // all MIGRATE commands are processed by the Command forwarder
+			Operand:        ssntp.MIGRATE,
+			CommandForward: sched,
+		},
+		{ // all MigrationReady events are processed by the Event forwarder
+			Operand:      ssntp.MigrationReady,
+			EventForward: sched,
+		},
+		{ // all InstanceMigrated events are processed by the Event forwarder
+			Operand:      ssntp.InstanceMigrated,
+			EventForward: sched,
+		},
 	}
 }
 
@@ -1026,6 +1359,7 @@ func configSchedulerServer() (sched *ssntpSchedulerServer) {
 	sched = newSsntpSchedulerServer()
 	sched.cpuprofile = *cpuprofile
 	sched.heartbeat = *heartbeat
+	sched.adminPort = *adminPort
 
 	toggleDebug(sched)
 
@@ -1037,6 +1371,13 @@ func configSchedulerServer() (sched *ssntpSchedulerServer) {
 
 	setSSNTPForwardRules(sched)
 
+	blob, err := configuration.ExtractBlob(sched.config.ConfigURI)
+	if err != nil {
+		glog.Warningf("Unable to load cluster configuration: %v", err)
+	} else {
+		loadStartLimits(sched, blob)
+	}
+
 	return sched
 }
 
@@ -1050,5 +1391,11 @@ func main() {
 		return
 	}
 
+	if sched.adminPort != 0 {
+		go startAdminAPI(sched)
+	}
+
+	go startDispatchLoop(sched)
+
 	sched.ssntp.Serve(sched.config, sched)
 }
@@ -640,6 +640,13 @@ func TestGetWorkloadAgentUUID(t *testing.T) {
 		{ssntp.DELETE, []byte(testutil.DeleteYaml), testutil.InstanceUUID, testutil.AgentUUID},
 		{ssntp.EVACUATE, []byte(testutil.EvacuateYaml), "", testutil.AgentUUID},
 		{ssntp.AttachVolume, []byte(testutil.AttachVolumeYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.REBOOT, []byte(testutil.RebootYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.PAUSE, []byte(testutil.PauseYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.UNPAUSE, []byte(testutil.UnpauseYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.SUSPEND, []byte(testutil.SuspendYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.RESUME, []byte(testutil.ResumeYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.GetConsoleLog, []byte(testutil.GetConsoleLogYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.CreateImage, []byte(testutil.CreateImageYaml), testutil.InstanceUUID, testutil.AgentUUID},
 	}
 	for _, test := range stringTests {
 		instanceUUID, agentUUID, _ := GetWorkloadAgentUUID(sched, test.cmd, test.yaml)
@@ -238,6 +238,100 @@ func TestStop(t *testing.T) {
 	}
 }
 
+func TestInstanceActions(t *testing.T) {
+	var actionTests = []struct {
+		cmd  ssntp.Command
+		yaml string
+	}{
+		{ssntp.REBOOT, testutil.RebootYaml},
+		{ssntp.PAUSE, testutil.PauseYaml},
+		{ssntp.UNPAUSE, testutil.UnpauseYaml},
+		{ssntp.SUSPEND, testutil.SuspendYaml},
+		{ssntp.RESUME, testutil.ResumeYaml},
+	}
+
+	for _, test := range actionTests {
+		agentCh := agent.AddCmdChan(test.cmd)
+
+		_, err := controller.Ssntp.SendCommand(test.cmd, []byte(test.yaml))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := agent.GetCmdChanResult(agentCh, test.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.InstanceUUID != testutil.InstanceUUID {
+			t.Fatalf("%s forwarded for wrong instance %s", test.cmd, result.InstanceUUID)
+		}
+	}
+}
+
+func TestGetConsoleLog(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.GetConsoleLog)
+	controllerCh := controller.AddEventChan(ssntp.ConsoleLog)
+
+	_, err := controller.Ssntp.SendCommand(ssntp.GetConsoleLog, []byte(testutil.GetConsoleLogYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = agent.GetCmdChanResult(agentCh, ssntp.GetConsoleLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := controller.GetEventChanResult(controllerCh, ssntp.ConsoleLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("ConsoleLog received for wrong instance %s", result.InstanceUUID)
+	}
+}
+
+func TestCreateImage(t *testing.T) {
+	agentCh := agent.AddCmdChan(ssntp.CreateImage)
+	controllerCh := controller.AddEventChan(ssntp.ImageCreated)
+
+	_, err := controller.Ssntp.SendCommand(ssntp.CreateImage, []byte(testutil.CreateImageYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = agent.GetCmdChanResult(agentCh, ssntp.CreateImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := controller.GetEventChanResult(controllerCh, ssntp.ImageCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("ImageCreated received for wrong instance %s", result.InstanceUUID)
+	}
+}
+
+func TestMigrateNoTarget(t *testing.T) {
+	controllerCh := controller.AddEventChan(ssntp.InstanceMigrated)
+
+	_, err := controller.Ssntp.SendCommand(ssntp.MIGRATE, []byte(testutil.MigrateYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the agent is the only compute node, the instance cannot be moved
+	result, err := controller.GetEventChanResult(controllerCh, ssntp.InstanceMigrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("InstanceMigrated received for wrong instance %s", result.InstanceUUID)
+	}
+}
+
 func TestStopFailure(t *testing.T) {
 	agentCh := agent.AddCmdChan(ssntp.STOP)
 
@@ -480,6 +574,74 @@ func TestTenantRemoved(t *testing.T) {
 	}
 }
 
+func TestAssignPublicIP(t *testing.T) {
+	cnciAgentCh := cnciAgent.AddCmdChan(ssntp.AssignPublicIP)
+
+	_, err := controller.Ssntp.SendCommand(ssntp.AssignPublicIP, []byte(testutil.AssignIPYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cnciAgent.GetCmdChanResult(cnciAgentCh, ssntp.AssignPublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Expected instance %s, got %s", testutil.InstanceUUID, result.InstanceUUID)
+	}
+}
+
+func TestReleasePublicIP(t *testing.T) {
+	cnciAgentCh := cnciAgent.AddCmdChan(ssntp.ReleasePublicIP)
+
+	_, err := controller.Ssntp.SendCommand(ssntp.ReleasePublicIP, []byte(testutil.ReleaseIPYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cnciAgent.GetCmdChanResult(cnciAgentCh, ssntp.ReleasePublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Expected instance %s, got %s", testutil.InstanceUUID, result.InstanceUUID)
+	}
+}
+
+func TestApplySecurityRules(t *testing.T) {
+	cnciAgentCh := cnciAgent.AddCmdChan(ssntp.ApplySecurityRules)
+
+	_, err := controller.Ssntp.SendCommand(ssntp.ApplySecurityRules, []byte(testutil.ApplySecurityRulesYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cnciAgent.GetCmdChanResult(cnciAgentCh, ssntp.ApplySecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Expected instance %s, got %s", testutil.InstanceUUID, result.InstanceUUID)
+	}
+}
+
+func TestClearSecurityRules(t *testing.T) {
+	cnciAgentCh := cnciAgent.AddCmdChan(ssntp.ClearSecurityRules)
+
+	_, err := controller.Ssntp.SendCommand(ssntp.ClearSecurityRules, []byte(testutil.ClearSecurityRulesYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cnciAgent.GetCmdChanResult(cnciAgentCh, ssntp.ClearSecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != testutil.InstanceUUID {
+		t.Fatalf("Expected instance %s, got %s", testutil.InstanceUUID, result.InstanceUUID)
+	}
+}
+
 func TestPublicIPAssigned(t *testing.T) {
 	controllerCh := controller.AddEventChan(ssntp.PublicIPAssigned)
 
@@ -491,6 +653,49 @@ func TestPublicIPAssigned(t *testing.T) {
 	}
 }
 
+func TestControllerFailover(t *testing.T) {
+	backup, err := testutil.NewSsntpTestControllerConnection("Backup Controller Client", uuid.Generate().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Shutdown()
+	waitForController(backup.UUID)
+
+	masterCh := controller.AddEventChan(ssntp.ControllerRole)
+	backupCh := backup.AddEventChan(ssntp.ControllerRole)
+
+	_, err = forceControllerFailover(server, backup.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(masterCh, ssntp.ControllerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backup.GetEventChanResult(backupCh, ssntp.ControllerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masterCh = controller.AddEventChan(ssntp.ControllerRole)
+	backupCh = backup.AddEventChan(ssntp.ControllerRole)
+
+	_, err = forceControllerFailover(server, controller.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = controller.GetEventChanResult(masterCh, ssntp.ControllerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backup.GetEventChanResult(backupCh, ssntp.ControllerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func waitForController(uuid string) {
 	for {
 		server.controllerMutex.Lock()
@@ -218,7 +218,8 @@ func saneDefaults(conf *payloads.Configure) bool {
 		conf.Configure.ImageService.Type == payloads.Glance &&
 		conf.Configure.IdentityService.Type == payloads.Keystone &&
 		conf.Configure.Launcher.DiskLimit == true &&
-		conf.Configure.Launcher.MemoryLimit == true)
+		conf.Configure.Launcher.MemoryLimit == true &&
+		conf.Configure.Launcher.ConsolePort == 8890)
 }
 
 func TestInitDefaults(t *testing.T) {
@@ -200,7 +200,12 @@ func processCommand(client *ssntpConn, cmd *cmdWrapper) {
 			glog.Infof("Processing: CiaoCommandAssignPublicIP %v", c)
 			err := assignPubIP(c)
 			if err != nil {
-				glog.Infof("Error Processing: CiaoCommandAssignPublicIP %v", err)
+				glog.Errorf("Error Processing: CiaoCommandAssignPublicIP %v", err)
+				return
+			}
+			err = sendNetworkEvent(client, ssntp.PublicIPAssigned, c)
+			if err != nil {
+				glog.Errorf("Unable to send PublicIPAssigned: %v", err)
 			}
 		}(cmd)
 
@@ -215,6 +220,28 @@ func processCommand(client *ssntpConn, cmd *cmdWrapper) {
 			}
 		}(cmd)
 
+	case *payloads.CommandApplySecurityRules:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.Apply
+			glog.Infof("Processing: CiaoCommandApplySecurityRules %v", c)
+			err := applySecurityRules(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoCommandApplySecurityRules %v", err)
+			}
+		}(cmd)
+
+	case *payloads.CommandClearSecurityRules:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.Clear
+			glog.Infof("Processing: CiaoCommandClearSecurityRules %v", c)
+			err := clearSecurityRules(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoCommandClearSecurityRules %v", err)
+			}
+		}(cmd)
+
 	case *statusConnected:
 		//Block and send this as it does not make sense to send other events
 		//or process commands when we have not yet registered
@@ -262,6 +289,34 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			client.cmdCh <- &cmdWrapper{&releaseIP}
 		}(payload)
 
+	case ssntp.ApplySecurityRules:
+		glog.Infof("CMD: ssntp.ApplySecurityRules %v", len(payload))
+
+		go func(payload []byte) {
+			var apply payloads.CommandApplySecurityRules
+			err := yaml.Unmarshal(payload, &apply)
+			if err != nil {
+				glog.Warning("Error unmarshalling ApplySecurityRules")
+				return
+			}
+			glog.Infof("EVENT: ssntp.ApplySecurityRules %v", apply)
+			client.cmdCh <- &cmdWrapper{&apply}
+		}(payload)
+
+	case ssntp.ClearSecurityRules:
+		glog.Infof("CMD: ssntp.ClearSecurityRules %v", len(payload))
+
+		go func(payload []byte) {
+			var clear payloads.CommandClearSecurityRules
+			err := yaml.Unmarshal(payload, &clear)
+			if err != nil {
+				glog.Warning("Error unmarshalling ClearSecurityRules")
+				return
+			}
+			glog.Infof("EVENT: ssntp.ClearSecurityRules %v", clear)
+			client.cmdCh <- &cmdWrapper{&clear}
+		}(payload)
+
 	default:
 		glog.Infof("CMD: %s", cmd)
 	}
@@ -253,6 +253,20 @@ func cnciAddedMarshal(agentUUID string) ([]byte, error) {
 	return yaml.Marshal(&cnciAdded)
 }
 
+func publicIPAssignedMarshal(agentUUID string, cmd *payloads.PublicIPCommand) ([]byte, error) {
+	var assigned payloads.EventPublicIPAssigned
+	evt := &assigned.AssignedIP
+
+	evt.ConcentratorUUID = agentUUID
+	evt.InstanceUUID = cmd.InstanceUUID
+	evt.PublicIP = cmd.PublicIP
+	evt.PrivateIP = cmd.PrivateIP
+
+	glog.Infoln("publicIP Assigned Event ", assigned)
+
+	return yaml.Marshal(&assigned)
+}
+
 func sendNetworkEvent(client *ssntpConn, eventType ssntp.Event, eventInfo interface{}) error {
 
 	if !client.isConnected() {
@@ -280,7 +294,11 @@ func generateNetEventPayload(eventType ssntp.Event, eventInfo interface{}, agent
 		return cnciAddedMarshal(agentUUID)
 	case ssntp.PublicIPAssigned:
 		glog.Infof("generating publicIP Assigned Event Payload %s", agentUUID)
-		return nil, nil
+		cmd, ok := eventInfo.(*payloads.PublicIPCommand)
+		if !ok {
+			return nil, fmt.Errorf("Invalid publicIP Assigned event info: %v", eventInfo)
+		}
+		return publicIPAssignedMarshal(agentUUID, cmd)
 	default:
 		return nil, fmt.Errorf("Unsupported ssntpEventInfo type: %v", eventType)
 	}
@@ -309,6 +327,7 @@ func assignPubIP(cmd *payloads.PublicIPCommand) error {
 
 	if err != nil {
 		glog.Errorf("cnci.assignPubIP invalid params %v %v", err, cmd)
+		return err
 	}
 
 	if enableNetwork {
@@ -324,6 +343,7 @@ func releasePubIP(cmd *payloads.PublicIPCommand) error {
 
 	if err != nil {
 		glog.Errorf("cnci.releasePubIP invalid params %v %v", err, cmd)
+		return err
 	}
 
 	if enableNetwork {
@@ -332,3 +352,68 @@ func releasePubIP(cmd *payloads.PublicIPCommand) error {
 
 	return nil
 }
+
+func applySecurityRules(cmd *payloads.SecurityRulesCommand) error {
+	var lastErr error
+
+	for _, inst := range cmd.Instances {
+		ip := net.ParseIP(inst.PrivateIP)
+		if ip == nil {
+			lastErr = fmt.Errorf("invalid private IP %v for %v",
+				inst.PrivateIP, inst.InstanceUUID)
+			glog.Errorf("cnci.applySecurityRules %v", lastErr)
+			continue
+		}
+
+		rules := make([]libsnnet.SecurityRule, 0, len(inst.Rules))
+		for _, r := range inst.Rules {
+			rules = append(rules, libsnnet.SecurityRule{
+				Protocol: r.Protocol,
+				FromPort: r.FromPort,
+				ToPort:   r.ToPort,
+				CIDR:     r.CIDR,
+			})
+		}
+
+		if !enableNetwork || gFw == nil {
+			continue
+		}
+
+		if err := gFw.SecurityRulesEnable(ip, rules); err != nil {
+			lastErr = err
+			glog.Errorf("cnci.applySecurityRules %v %v", inst.InstanceUUID, err)
+			continue
+		}
+		glog.Infof("cnci.applySecurityRules success %v %v %d rules",
+			inst.InstanceUUID, ip, len(rules))
+	}
+
+	return lastErr
+}
+
+func clearSecurityRules(cmd *payloads.SecurityRulesCommand) error {
+	var lastErr error
+
+	for _, inst := range cmd.Instances {
+		ip := net.ParseIP(inst.PrivateIP)
+		if ip == nil {
+			lastErr = fmt.Errorf("invalid private IP %v for %v",
+				inst.PrivateIP, inst.InstanceUUID)
+			glog.Errorf("cnci.clearSecurityRules %v", lastErr)
+			continue
+		}
+
+		if !enableNetwork || gFw == nil {
+			continue
+		}
+
+		if err := gFw.SecurityRulesDisable(ip); err != nil {
+			lastErr = err
+			glog.Errorf("cnci.clearSecurityRules %v %v", inst.InstanceUUID, err)
+			continue
+		}
+		glog.Infof("cnci.clearSecurityRules success %v %v", inst.InstanceUUID, ip)
+	}
+
+	return lastErr
+}
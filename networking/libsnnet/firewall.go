@@ -276,6 +276,181 @@ func (f *Firewall) ExtPortAccess(action FwAction, protocol string, extDevice str
 	return nil
 }
 
+//SecurityRule defines an ingress rule of a security group
+//Traffic to an instance is accepted if it matches the protocol,
+//destination port range and source CIDR of the rule.
+//For icmp rules FromPort is the ICMP type (-1 for any type)
+//and ToPort is ignored
+type SecurityRule struct {
+	Protocol string
+	FromPort int
+	ToPort   int
+	CIDR     string
+}
+
+const (
+	securityChainPrefix    = "CIAO-SG-"
+	securityChainNewSuffix = "-NEW"
+)
+
+//securityChain returns the name of the chain holding the
+//security rules of the instance with the given IP address
+func securityChain(ip net.IP) (string, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "", fmt.Errorf("invalid instance IP %v", ip)
+	}
+
+	return fmt.Sprintf("%s%02X%02X%02X%02X", securityChainPrefix,
+		ip4[0], ip4[1], ip4[2], ip4[3]), nil
+}
+
+//securityRuleSpec returns the iptables rule specification
+//accepting the traffic matching a security rule
+func securityRuleSpec(rule SecurityRule) ([]string, error) {
+	cidr := rule.CIDR
+	if cidr == "" {
+		cidr = "0.0.0.0/0"
+	}
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return nil, fmt.Errorf("invalid CIDR %v", cidr)
+	}
+
+	spec := []string{"-s", cidr, "-p", rule.Protocol}
+
+	switch rule.Protocol {
+	case "tcp", "udp":
+		if rule.FromPort < 1 || rule.ToPort > 65535 || rule.FromPort > rule.ToPort {
+			return nil, fmt.Errorf("invalid port range %d:%d",
+				rule.FromPort, rule.ToPort)
+		}
+		spec = append(spec, "--dport",
+			strconv.Itoa(rule.FromPort)+":"+strconv.Itoa(rule.ToPort))
+	case "icmp":
+		if rule.FromPort < -1 || rule.FromPort > 255 {
+			return nil, fmt.Errorf("invalid icmp type %d", rule.FromPort)
+		}
+		if rule.FromPort != -1 {
+			spec = append(spec, "--icmp-type", strconv.Itoa(rule.FromPort))
+		}
+	default:
+		return nil, fmt.Errorf("invalid protocol %v", rule.Protocol)
+	}
+
+	return append(spec, "-j", "ACCEPT"), nil
+}
+
+//SecurityRulesEnable restricts the traffic forwarded to an internal IP
+//address to the traffic matching the security rules. Established
+//connections are always accepted and all other traffic is dropped.
+//The rules replace any rules previously enabled for the IP address.
+func (f *Firewall) SecurityRulesEnable(internalIP net.IP, rules []SecurityRule) error {
+	chain, err := securityChain(internalIP)
+	if err != nil {
+		return err
+	}
+
+	specs := make([][]string, 0, len(rules)+2)
+
+	//iptables -A $chain -m state --state RELATED,ESTABLISHED -j ACCEPT
+	specs = append(specs, []string{"-m", "state", "--state",
+		"RELATED,ESTABLISHED", "-j", "ACCEPT"})
+	for _, rule := range rules {
+		spec, err := securityRuleSpec(rule)
+		if err != nil {
+			return fmt.Errorf("invalid security rule for %v: %v", internalIP, err)
+		}
+		specs = append(specs, spec)
+	}
+	//iptables -A $chain -j DROP
+	specs = append(specs, []string{"-j", "DROP"})
+
+	//The rules are built in a new chain, which only replaces the chain
+	//of the previous rules once complete, so that the traffic is never
+	//forwarded without its final DROP rule
+	newChain := chain + securityChainNewSuffix
+
+	//Creates the chain if it does not exist
+	if err := f.ClearChain("filter", newChain); err != nil {
+		return fmt.Errorf("unable to setup chain %v: %v", newChain, err)
+	}
+
+	for _, spec := range specs {
+		if err := f.Append("filter", newChain, spec...); err != nil {
+			f.removeChain(newChain)
+			return fmt.Errorf("unable to append security rule %v %v: %v",
+				newChain, spec, err)
+		}
+	}
+
+	//iptables -I FORWARD 1 -d $internalIP/32 -j $newChain
+	newJump := []string{"-d", internalIP.String() + "/32", "-j", newChain}
+	if err := f.Insert("filter", "FORWARD", 1, newJump...); err != nil {
+		f.removeChain(newChain)
+		return fmt.Errorf("unable to enable security chain %v: %v", newChain, err)
+	}
+
+	//iptables -D FORWARD -d $internalIP/32 -j $chain
+	jump := []string{"-d", internalIP.String() + "/32", "-j", chain}
+	ok, err := f.Exists("filter", "FORWARD", jump...)
+	if err != nil {
+		return fmt.Errorf("unable to check security chain %v: %v", chain, err)
+	}
+	if ok {
+		if err := f.Delete("filter", "FORWARD", jump...); err != nil {
+			return fmt.Errorf("unable to disable security chain %v: %v", chain, err)
+		}
+	}
+	f.removeChain(chain)
+
+	//iptables -E $newChain $chain
+	if err := f.RenameChain("filter", newChain, chain); err != nil {
+		return fmt.Errorf("unable to rename security chain %v: %v", newChain, err)
+	}
+
+	return nil
+}
+
+//removeChain flushes and deletes a chain which is no longer referenced
+func (f *Firewall) removeChain(chain string) {
+	if err := f.ClearChain("filter", chain); err != nil {
+		return
+	}
+	_ = f.DeleteChain("filter", chain)
+}
+
+//SecurityRulesDisable removes all security rules for an internal IP
+//address, so that all traffic is forwarded to it again
+func (f *Firewall) SecurityRulesDisable(internalIP net.IP) error {
+	chain, err := securityChain(internalIP)
+	if err != nil {
+		return err
+	}
+
+	//iptables -D FORWARD -d $internalIP/32 -j $chain
+	jump := []string{"-d", internalIP.String() + "/32", "-j", chain}
+	ok, err := f.Exists("filter", "FORWARD", jump...)
+	if err == nil && !ok {
+		//Nothing was ever enabled for this instance
+		return nil
+	}
+
+	err = f.Delete("filter", "FORWARD", jump...)
+	if err != nil {
+		return fmt.Errorf("unable to disable security chain %v: %v", chain, err)
+	}
+
+	if err := f.ClearChain("filter", chain); err != nil {
+		return fmt.Errorf("unable to clear chain %v: %v", chain, err)
+	}
+
+	if err := f.DeleteChain("filter", chain); err != nil {
+		return fmt.Errorf("unable to delete chain %v: %v", chain, err)
+	}
+
+	return nil
+}
+
 /* Not implemented
 
 func ipAssign(action FwAction, ip net.IP, iface string) error {
@@ -104,6 +104,43 @@ func TestFw_Nat(t *testing.T) {
 	assert.Nil(err)
 }
 
+//Tests security group rules
+//
+//Tests the primitives used by CNCI to restrict the traffic
+//forwarded to an instance to its security group rules
+//
+//Test should pass
+func TestFw_SecurityRules(t *testing.T) {
+	assert := assert.New(t)
+	fwinit()
+	fw, err := InitFirewall(fwIf)
+	require.Nil(t, err)
+
+	ip := net.ParseIP("192.168.0.101")
+	rules := []SecurityRule{
+		{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "0.0.0.0/0"},
+		{Protocol: "icmp", FromPort: -1, ToPort: -1, CIDR: "10.0.0.0/8"},
+	}
+
+	assert.Nil(fw.SecurityRulesEnable(ip, rules))
+	assert.Nil(fw.SecurityRulesEnable(ip, rules[:1]))
+
+	chain, err := securityChain(ip)
+	require.Nil(t, err)
+	list, err := fw.List("filter", chain)
+	assert.Nil(err)
+	//Chain creation plus established, ssh and drop rules
+	assert.Equal(4, len(list))
+	//The chain the rules were built in has replaced the previous one
+	_, err = fw.List("filter", chain+securityChainNewSuffix)
+	assert.NotNil(err)
+
+	assert.Nil(fw.SecurityRulesDisable(ip))
+	assert.Nil(fw.SecurityRulesDisable(ip))
+
+	assert.Nil(fw.ShutdownFirewall())
+}
+
 /*
 //Not fully implemented
 //
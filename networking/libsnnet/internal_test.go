@@ -147,3 +147,47 @@ func TestCN_dbRebuild(t *testing.T) {
 	_, err = cn.dbUpdate(alias.bridge, "", dbInsBr)
 	assert.NotNil(err)
 }
+
+//Tests the security rules to iptables translation
+//
+//Checks the chain naming and the rule specifications
+//generated for valid and invalid security rules
+//
+//Test is expected to pass
+func TestFw_securityRuleSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	chain, err := securityChain(net.ParseIP("172.16.0.2"))
+	assert.Nil(err)
+	assert.Equal("CIAO-SG-AC100002", chain)
+
+	_, err = securityChain(net.ParseIP("fe80::1"))
+	assert.NotNil(err)
+
+	spec, err := securityRuleSpec(SecurityRule{Protocol: "tcp",
+		FromPort: 80, ToPort: 443, CIDR: "10.0.0.0/8"})
+	assert.Nil(err)
+	assert.Equal([]string{"-s", "10.0.0.0/8", "-p", "tcp",
+		"--dport", "80:443", "-j", "ACCEPT"}, spec)
+
+	spec, err = securityRuleSpec(SecurityRule{Protocol: "icmp", FromPort: -1})
+	assert.Nil(err)
+	assert.Equal([]string{"-s", "0.0.0.0/0", "-p", "icmp", "-j", "ACCEPT"}, spec)
+
+	spec, err = securityRuleSpec(SecurityRule{Protocol: "icmp", FromPort: 8})
+	assert.Nil(err)
+	assert.Equal([]string{"-s", "0.0.0.0/0", "-p", "icmp",
+		"--icmp-type", "8", "-j", "ACCEPT"}, spec)
+
+	invalid := []SecurityRule{
+		{Protocol: "sctp", FromPort: 1, ToPort: 2},
+		{Protocol: "tcp", FromPort: 0, ToPort: 22},
+		{Protocol: "udp", FromPort: 100, ToPort: 10},
+		{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "10.0.0.0"},
+		{Protocol: "icmp", FromPort: 256},
+	}
+	for _, rule := range invalid {
+		_, err = securityRuleSpec(rule)
+		assert.NotNil(err, "%v", rule)
+	}
+}
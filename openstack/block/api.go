@@ -349,6 +349,13 @@ type Service interface {
 	ShowVolumeDetails(tenant string, volume string) (VolumeDetail, error)
 }
 
+// TaskLocator is implemented by the services tracking their asynchronous
+// volume actions as tasks.  The location of the task of a volume is
+// returned in the Location header of the response to the action.
+type TaskLocator interface {
+	TaskLocation(volume string) string
+}
+
 // Context contains data and interfaces that the block api will need.
 // TBD: do we really need this, or is just a service interface sufficient?
 type Context struct {
@@ -568,7 +575,7 @@ func deleteVolume(bc *Context, w http.ResponseWriter, r *http.Request) (APIRespo
 	return APIResponse{http.StatusAccepted, nil}, nil
 }
 
-func volumeActionAttach(bc *Context, m map[string]interface{}, tenant string, volume string) (APIResponse, error) {
+func volumeActionAttach(bc *Context, w http.ResponseWriter, m map[string]interface{}, tenant string, volume string) (APIResponse, error) {
 	val := m["os-attach"]
 
 	m = val.(map[string]interface{})
@@ -592,6 +599,12 @@ func volumeActionAttach(bc *Context, m map[string]interface{}, tenant string, vo
 		return errorResponse(err), err
 	}
 
+	if tl, ok := bc.Service.(TaskLocator); ok {
+		if location := tl.TaskLocation(volume); location != "" {
+			w.Header().Set("Location", location)
+		}
+	}
+
 	return APIResponse{http.StatusAccepted, nil}, nil
 }
 
@@ -639,7 +652,7 @@ func volumeAction(bc *Context, w http.ResponseWriter, r *http.Request) (APIRespo
 	// for now, we will support only attach and detach
 
 	if m["os-attach"] != nil {
-		return volumeActionAttach(bc, m, tenant, volume)
+		return volumeActionAttach(bc, w, m, tenant, volume)
 	}
 
 	if m["os-detach"] != nil {
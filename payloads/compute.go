@@ -70,42 +70,48 @@ const (
 	// ComputeStatusStopped is a filter that used to select exited
 	// instances in requests to the controller.
 	ComputeStatusStopped = "exited"
+
+	// ComputeStatusPaused is a filter that used to select paused
+	// instances in requests to the controller.
+	ComputeStatusPaused = "paused"
 )
 
 // Server contains information about a specific instance within a ciao cluster.
 type Server struct {
-	Addresses                        Addresses       `json:"addresses"`
-	Created                          time.Time       `json:"created"`
-	Flavor                           Flavor          `json:"flavor"`
-	HostID                           string          `json:"hostId"`
-	ID                               string          `json:"id"`
-	Image                            Image           `json:"image"`
-	KeyName                          string          `json:"key_name"`
-	Links                            []Link          `json:"links"`
-	Name                             string          `json:"name"`
-	AccessIPv4                       string          `json:"accessIPv4"`
-	AccessIPv6                       string          `json:"accessIPv6"`
-	ConfigDrive                      string          `json:"config_drive"`
-	OSDCFDiskConfig                  string          `json:"OS-DCF:diskConfig"`
-	OSEXTAZAvailabilityZone          string          `json:"OS-EXT-AZ:availability_zone"`
-	OSEXTSRVATTRHost                 string          `json:"OS-EXT-SRV-ATTR:host"`
-	OSEXTSRVATTRHypervisorHostname   string          `json:"OS-EXT-SRV-ATTR:hypervisor_hostname"`
-	OSEXTSRVATTRInstanceName         string          `json:"OS-EXT-SRV-ATTR:instance_name"`
-	OSEXTSTSPowerState               int             `json:"OS-EXT-STS:power_state"`
-	OSEXTSTSTaskState                string          `json:"OS-EXT-STS:task_state"`
-	OSEXTSTSVMState                  string          `json:"OS-EXT-STS:vm_state"`
-	OsExtendedVolumesVolumesAttached []string        `json:"os-extended-volumes:volumes_attached"`
-	OSSRVUSGLaunchedAt               time.Time       `json:"OS-SRV-USG:launched_at"`
-	OSSRVUSGTerminatedAt             time.Time       `json:"OS-SRV-USG:terminated_at"`
-	Progress                         int             `json:"progress"`
-	SecurityGroups                   []SecurityGroup `json:"security_groups"`
-	Status                           string          `json:"status"`
-	HostStatus                       string          `json:"host_status"`
-	TenantID                         string          `json:"tenant_id"`
-	Updated                          time.Time       `json:"updated"`
-	UserID                           string          `json:"user_id"`
-	SSHIP                            string          `json:"ssh_ip"`
-	SSHPort                          int             `json:"ssh_port"`
+	Addresses                        Addresses         `json:"addresses"`
+	Created                          time.Time         `json:"created"`
+	Flavor                           Flavor            `json:"flavor"`
+	HostID                           string            `json:"hostId"`
+	ID                               string            `json:"id"`
+	Image                            Image             `json:"image"`
+	KeyName                          string            `json:"key_name"`
+	Links                            []Link            `json:"links"`
+	Name                             string            `json:"name"`
+	AccessIPv4                       string            `json:"accessIPv4"`
+	AccessIPv6                       string            `json:"accessIPv6"`
+	ConfigDrive                      string            `json:"config_drive"`
+	OSDCFDiskConfig                  string            `json:"OS-DCF:diskConfig"`
+	OSEXTAZAvailabilityZone          string            `json:"OS-EXT-AZ:availability_zone"`
+	OSEXTSRVATTRHost                 string            `json:"OS-EXT-SRV-ATTR:host"`
+	OSEXTSRVATTRHypervisorHostname   string            `json:"OS-EXT-SRV-ATTR:hypervisor_hostname"`
+	OSEXTSRVATTRInstanceName         string            `json:"OS-EXT-SRV-ATTR:instance_name"`
+	OSEXTSTSPowerState               int               `json:"OS-EXT-STS:power_state"`
+	OSEXTSTSTaskState                string            `json:"OS-EXT-STS:task_state"`
+	OSEXTSTSVMState                  string            `json:"OS-EXT-STS:vm_state"`
+	OsExtendedVolumesVolumesAttached []string          `json:"os-extended-volumes:volumes_attached"`
+	OSSRVUSGLaunchedAt               time.Time         `json:"OS-SRV-USG:launched_at"`
+	OSSRVUSGTerminatedAt             time.Time         `json:"OS-SRV-USG:terminated_at"`
+	Progress                         int               `json:"progress"`
+	SecurityGroups                   []SecurityGroup   `json:"security_groups"`
+	Status                           string            `json:"status"`
+	HostStatus                       string            `json:"host_status"`
+	TenantID                         string            `json:"tenant_id"`
+	Updated                          time.Time         `json:"updated"`
+	UserID                           string            `json:"user_id"`
+	Metadata                         map[string]string `json:"metadata"`
+	SSHIP                            string            `json:"ssh_ip"`
+	SSHPort                          int               `json:"ssh_port"`
+	RecoveryPolicy                   string            `json:"recovery_policy,omitempty"`
 }
 
 // ComputeServers represents the unmarshalled version of the contents of a
@@ -132,6 +138,25 @@ type ComputeServer struct {
 	Server Server `json:"server"`
 }
 
+// InstanceTransition contains a state transition of an instance, or a
+// failure reported for the instance.
+type InstanceTransition struct {
+	Timestamp time.Time `json:"timestamp"`
+	From      string    `json:"from_state"`
+	To        string    `json:"to_state"`
+	Source    string    `json:"source"`
+	NodeID    string    `json:"node_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// ComputeServerHistory represents the unmarshalled version of the contents
+// of a /v2.1/{tenant}/servers/{server}/history response.  It contains the
+// state transitions of an instance, oldest first.
+type ComputeServerHistory struct {
+	ID          string               `json:"id"`
+	Transitions []InstanceTransition `json:"transitions"`
+}
+
 // ComputeFlavors represents the unmarshalled version of the contents of a
 // /v2.1/{tenant}/flavors response.  It contains information about all the
 // flavors in a cluster.
@@ -204,6 +229,31 @@ type ComputeCreateServer struct {
 		Workload     string `json:"flavorRef"`
 		MaxInstances int    `json:"max_count"`
 		MinInstances int    `json:"min_count"`
+
+		// Metadata is made available to the instances through
+		// the meta_data.json document of their config drive.
+		Metadata map[string]string `json:"metadata,omitempty"`
+
+		// UserData is a base64 encoded cloud-config document
+		// merged with the cloud-init template of the workload.
+		UserData string `json:"user_data,omitempty"`
+
+		// SecurityGroups lists the names of the tenant security
+		// groups the instances belong to.
+		SecurityGroups []SecurityGroup `json:"security_groups,omitempty"`
+
+		// KeyName is the name of a tenant keypair whose public
+		// key is added to the cloud-init users of the instances.
+		KeyName string `json:"key_name,omitempty"`
+
+		// RecoveryPolicy overrides the recovery policy of the
+		// workload for the instances: none, restart-on-reconnect
+		// or reschedule-elsewhere.
+		RecoveryPolicy string `json:"recovery_policy,omitempty"`
+
+		// TraceLabel enables the tracing of the launch of the
+		// instances, whose frames are recorded under this label.
+		TraceLabel string `json:"trace_label,omitempty"`
 	} `json:"server"`
 }
 
@@ -277,6 +327,27 @@ type CiaoTenantResources struct {
 	MemUsage      int       `json:"ram_usage"`
 	DiskLimit     int       `json:"disk_limit"`
 	DiskUsage     int       `json:"disk_usage"`
+	VolumeLimit   int       `json:"volumes_limit"`
+	VolumeUsage   int       `json:"volumes_usage"`
+}
+
+// CiaoQuotas contains the resource limits set by an administrator, either
+// for a tenant or as the cluster wide defaults.  A limit of -1 means the
+// resource is unlimited.  Limits that are not set are left unchanged by
+// an update.
+type CiaoQuotas struct {
+	Instances *int `json:"instances,omitempty"`
+	VCPUs     *int `json:"vcpus,omitempty"`
+	MemMB     *int `json:"mem_mb,omitempty"`
+	DiskMB    *int `json:"disk_mb,omitempty"`
+	Volumes   *int `json:"volumes,omitempty"`
+}
+
+// CiaoQuotaSet represents the unmarshalled version of the contents of a
+// /v2.1/{tenant}/quotas update request, or of a /v2.1/quotas/defaults
+// request or response.
+type CiaoQuotaSet struct {
+	Quotas CiaoQuotas `json:"quota_set"`
 }
 
 // CiaoUsage contains a snapshot of resource consumption for a tenant.
@@ -453,6 +524,92 @@ func NewCiaoEvents() (events CiaoEvents) {
 	return
 }
 
+// CiaoStreamEvent represents an event of the v2.1/{tenant}/events/stream
+// and v2.1/events/stream Server-Sent Events streams, whose event name is
+// the kind of the event.  Type is only set for the entries of the event
+// log, and State for the instance state transitions.
+type CiaoStreamEvent struct {
+	ID         uint64    `json:"id"`
+	Timestamp  time.Time `json:"time_stamp"`
+	Kind       string    `json:"kind"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	NodeID     string    `json:"node_id,omitempty"`
+	EventType  string    `json:"type,omitempty"`
+	State      string    `json:"state,omitempty"`
+	Message    string    `json:"message"`
+}
+
+// CiaoWebhook represents a webhook subscription, to which the events of
+// the given kinds are POSTed as a CiaoStreamEvent.  A webhook without
+// event types receives all the events.  The secret is never returned.
+type CiaoWebhook struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CiaoWebhookResponse represents the unmarshalled version of the response
+// to a v2.1/{tenant}/webhooks/{webhook} or v2.1/webhooks/{webhook} request.
+type CiaoWebhookResponse struct {
+	Webhook CiaoWebhook `json:"webhook"`
+}
+
+// CiaoWebhooks represents the unmarshalled version of the response to a
+// v2.1/{tenant}/webhooks or v2.1/webhooks request.
+type CiaoWebhooks struct {
+	Webhooks []CiaoWebhook `json:"webhooks"`
+}
+
+// NewCiaoWebhooks allocates a CiaoWebhooks structure.
+// It allocates the Webhooks slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer.
+func NewCiaoWebhooks() (webhooks CiaoWebhooks) {
+	webhooks.Webhooks = []CiaoWebhook{}
+	return
+}
+
+// CiaoCreateWebhook represents the unmarshalled version of the contents
+// of a v2.1/{tenant}/webhooks or v2.1/webhooks POST request.  The deliveries
+// are signed with the secret, if one is given.
+type CiaoCreateWebhook struct {
+	Webhook struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret,omitempty"`
+	} `json:"webhook"`
+}
+
+// CiaoWebhookDelivery represents an attempt to deliver an event to a
+// webhook.  The attempts to deliver the same event share their ID.
+type CiaoWebhookDelivery struct {
+	ID         string    `json:"id"`
+	EventID    uint64    `json:"event_id"`
+	EventKind  string    `json:"event_kind"`
+	Attempt    int       `json:"attempt"`
+	Timestamp  time.Time `json:"time_stamp"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+}
+
+// CiaoWebhookDeliveries represents the unmarshalled version of the
+// response to a v2.1/{tenant}/webhooks/{webhook}/deliveries or
+// v2.1/webhooks/{webhook}/deliveries request.
+type CiaoWebhookDeliveries struct {
+	Deliveries []CiaoWebhookDelivery `json:"deliveries"`
+}
+
+// NewCiaoWebhookDeliveries allocates a CiaoWebhookDeliveries structure.
+// It allocates the Deliveries slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer.
+func NewCiaoWebhookDeliveries() (deliveries CiaoWebhookDeliveries) {
+	deliveries.Deliveries = []CiaoWebhookDelivery{}
+	return
+}
+
 // HTTPErrorData represents the HTTP response body for
 // a compute API request error.
 type HTTPErrorData struct {
@@ -468,3 +625,424 @@ type HTTPErrorData struct {
 type HTTPReturnErrorCode struct {
 	Error HTTPErrorData `json:"error"`
 }
+
+// CiaoWorkloadResource contains the default value of a resource requested
+// by the instances of a workload.
+type CiaoWorkloadResource struct {
+	Type      Resource `json:"type"`
+	Value     int      `json:"value"`
+	Mandatory bool     `json:"mandatory"`
+}
+
+// CiaoWorkloadStorage describes the storage an instance of a workload
+// boots from.
+type CiaoWorkloadStorage struct {
+	ID         string `json:"id,omitempty"`
+	Bootable   bool   `json:"bootable"`
+	Persistent bool   `json:"persistent"`
+	Size       int    `json:"size"`
+	SourceType string `json:"source_type"`
+}
+
+// CiaoWorkload contains the full definition of a workload.
+type CiaoWorkload struct {
+	ID          string                 `json:"id"`
+	Description string                 `json:"description"`
+	FWType      Firmware               `json:"fw_type"`
+	VMType      Hypervisor             `json:"vm_type"`
+	ImageID     string                 `json:"image_id"`
+	ImageName   string                 `json:"image_name"`
+	Config      string                 `json:"config"`
+	Defaults    []CiaoWorkloadResource `json:"defaults"`
+
+	// ImageOverride indicates whether the imageRef of a server
+	// creation request may replace ImageID.
+	ImageOverride bool                 `json:"image_override"`
+	Storage       *CiaoWorkloadStorage `json:"storage,omitempty"`
+
+	// RecoveryPolicy is the default recovery policy of the instances
+	// of the workload when their node disconnects: none,
+	// restart-on-reconnect or reschedule-elsewhere.
+	RecoveryPolicy string `json:"recovery_policy,omitempty"`
+}
+
+// CiaoWorkloadRequest represents the unmarshalled version of the contents
+// of a v2.1/flavors POST or v2.1/flavors/{flavor} PUT request, and of the
+// response to those requests.
+type CiaoWorkloadRequest struct {
+	Workload CiaoWorkload `json:"workload"`
+}
+
+// FloatingIP contains information about a floating IP address allocated
+// from the cluster's public IP pool.
+type FloatingIP struct {
+	ID         string  `json:"id"`
+	IP         string  `json:"ip"`
+	FixedIP    *string `json:"fixed_ip"`
+	InstanceID *string `json:"instance_id"`
+	Pool       string  `json:"pool"`
+}
+
+// ComputeFloatingIP represents the unmarshalled version of the response
+// to a v2.1/{tenant}/os-floating-ips POST or
+// v2.1/{tenant}/os-floating-ips/{id} GET request.
+type ComputeFloatingIP struct {
+	FloatingIP FloatingIP `json:"floating_ip"`
+}
+
+// ComputeFloatingIPs represents the unmarshalled version of the response
+// to a v2.1/{tenant}/os-floating-ips GET request.
+type ComputeFloatingIPs struct {
+	FloatingIPs []FloatingIP `json:"floating_ips"`
+}
+
+// NewComputeFloatingIPs allocates a ComputeFloatingIPs structure.
+// It allocates the FloatingIPs slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer, as specified by the
+// OpenStack APIs.
+func NewComputeFloatingIPs() (ips ComputeFloatingIPs) {
+	ips.FloatingIPs = []FloatingIP{}
+	return
+}
+
+// ComputeCreateFloatingIP represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/os-floating-ips POST request.
+type ComputeCreateFloatingIP struct {
+	Pool string `json:"pool,omitempty"`
+}
+
+// FloatingIPAddress identifies the floating IP address of an
+// addFloatingIp or removeFloatingIp server action.
+type FloatingIPAddress struct {
+	Address string `json:"address"`
+}
+
+// ComputeFloatingIPAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request adding
+// or removing a floating IP.
+type ComputeFloatingIPAction struct {
+	AddFloatingIP    *FloatingIPAddress `json:"addFloatingIp,omitempty"`
+	RemoveFloatingIP *FloatingIPAddress `json:"removeFloatingIp,omitempty"`
+}
+
+// ComputeReboot contains the type of a reboot server action, either SOFT
+// or HARD.
+type ComputeReboot struct {
+	Type string `json:"type"`
+}
+
+// ComputeRebootAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request rebooting
+// an instance.
+type ComputeRebootAction struct {
+	Reboot *ComputeReboot `json:"reboot"`
+}
+
+// ComputeResize contains the flavor, i.e. the workload, whose resources
+// are to be assigned to an instance by a resize server action.
+type ComputeResize struct {
+	FlavorRef string `json:"flavorRef"`
+}
+
+// ComputeResizeAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request resizing
+// an instance.
+type ComputeResizeAction struct {
+	Resize *ComputeResize `json:"resize"`
+}
+
+// ComputeMigrateLive contains the node an instance is live migrated to by
+// an os-migrateLive server action.  The scheduler picks the node when Host
+// is empty.
+type ComputeMigrateLive struct {
+	Host string `json:"host,omitempty"`
+}
+
+// ComputeMigrateLiveAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request live
+// migrating an instance to another node.
+type ComputeMigrateLiveAction struct {
+	MigrateLive *ComputeMigrateLive `json:"os-migrateLive"`
+}
+
+// ComputeConsoleOutput contains the number of lines of console output
+// requested by an os-getConsoleOutput server action.  The whole console
+// log is returned if Length is not set.
+type ComputeConsoleOutput struct {
+	Length *int `json:"length,omitempty"`
+}
+
+// ComputeConsoleOutputAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request retrieving
+// the console output of an instance.
+type ComputeConsoleOutputAction struct {
+	GetConsoleOutput *ComputeConsoleOutput `json:"os-getConsoleOutput"`
+}
+
+// ComputeConsoleOutputResponse represents the response to an
+// os-getConsoleOutput server action.
+type ComputeConsoleOutputResponse struct {
+	Output string `json:"output"`
+}
+
+// ComputeSerialConsole contains the type of console requested by an
+// os-getSerialConsole server action, which must be serial.
+type ComputeSerialConsole struct {
+	Type string `json:"type"`
+}
+
+// ComputeSerialConsoleAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request asking for
+// the serial console of an instance.
+type ComputeSerialConsoleAction struct {
+	GetSerialConsole *ComputeSerialConsole `json:"os-getSerialConsole"`
+}
+
+// ComputeConsole describes a remote console.  URL is the WebSocket URL
+// clients connect to in order to attach to the console.
+type ComputeConsole struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// ComputeSerialConsoleResponse represents the response to an
+// os-getSerialConsole server action.
+type ComputeSerialConsoleResponse struct {
+	Console ComputeConsole `json:"console"`
+}
+
+// ComputeCreateImage contains the name of the image created by a
+// createImage server action.
+type ComputeCreateImage struct {
+	Name string `json:"name"`
+}
+
+// ComputeCreateImageAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request creating an
+// image from the rootfs of an instance.
+type ComputeCreateImageAction struct {
+	CreateImage *ComputeCreateImage `json:"createImage"`
+}
+
+// ComputeCreateImageResponse represents the response to a createImage
+// server action.  The image is only usable once its status is ACTIVE.
+type ComputeCreateImageResponse struct {
+	ImageID string `json:"image_id"`
+}
+
+// ComputeImageServer identifies the instance an image was created from.
+type ComputeImageServer struct {
+	ID string `json:"id"`
+}
+
+// ComputeImage describes an image created from an instance.  Status is
+// SAVING while the image is being created, then ACTIVE or ERROR.
+type ComputeImage struct {
+	ID      string             `json:"id"`
+	Name    string             `json:"name"`
+	Status  string             `json:"status"`
+	Created time.Time          `json:"created"`
+	Size    int64              `json:"OS-EXT-IMG-SIZE:size"`
+	Server  ComputeImageServer `json:"server"`
+}
+
+// ComputeImageResponse represents the response to a
+// v2.1/{tenant}/images/{image} GET request.
+type ComputeImageResponse struct {
+	Image ComputeImage `json:"image"`
+}
+
+// ComputeImages represents the response to a v2.1/{tenant}/images GET
+// request.
+type ComputeImages struct {
+	Images []ComputeImage `json:"images"`
+}
+
+// NewComputeImages allocates a ComputeImages structure.
+// It allocates the Images slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer, as specified by the
+// OpenStack APIs.
+func NewComputeImages() (images ComputeImages) {
+	images.Images = []ComputeImage{}
+	return
+}
+
+// SecurityGroupRuleIPRange contains the source network of a security
+// group rule.
+type SecurityGroupRuleIPRange struct {
+	CIDR string `json:"cidr,omitempty"`
+}
+
+// SecurityGroupRule contains information about an ingress rule of a
+// security group.
+type SecurityGroupRule struct {
+	ID            string                   `json:"id"`
+	ParentGroupID string                   `json:"parent_group_id"`
+	IPProtocol    string                   `json:"ip_protocol"`
+	FromPort      int                      `json:"from_port"`
+	ToPort        int                      `json:"to_port"`
+	IPRange       SecurityGroupRuleIPRange `json:"ip_range"`
+}
+
+// SecurityGroupDetails contains information about a tenant security
+// group and its rules.
+type SecurityGroupDetails struct {
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	TenantID    string              `json:"tenant_id"`
+	Rules       []SecurityGroupRule `json:"rules"`
+}
+
+// ComputeSecurityGroup represents the unmarshalled version of the response
+// to a v2.1/{tenant}/os-security-groups/{group} request.
+type ComputeSecurityGroup struct {
+	SecurityGroup SecurityGroupDetails `json:"security_group"`
+}
+
+// ComputeSecurityGroups represents the unmarshalled version of the response
+// to a v2.1/{tenant}/os-security-groups request.
+type ComputeSecurityGroups struct {
+	SecurityGroups []SecurityGroupDetails `json:"security_groups"`
+}
+
+// NewComputeSecurityGroups allocates a ComputeSecurityGroups structure.
+// It allocates the SecurityGroups slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer, as specified by the
+// OpenStack APIs.
+func NewComputeSecurityGroups() (groups ComputeSecurityGroups) {
+	groups.SecurityGroups = []SecurityGroupDetails{}
+	return
+}
+
+// ComputeCreateSecurityGroup represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/os-security-groups POST request.
+type ComputeCreateSecurityGroup struct {
+	SecurityGroup struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"security_group"`
+}
+
+// ComputeCreateSecurityGroupRule represents the unmarshalled version of
+// the contents of a v2.1/{tenant}/os-security-group-rules POST request.
+type ComputeCreateSecurityGroupRule struct {
+	SecurityGroupRule struct {
+		ParentGroupID string `json:"parent_group_id"`
+		IPProtocol    string `json:"ip_protocol"`
+		FromPort      int    `json:"from_port"`
+		ToPort        int    `json:"to_port"`
+		CIDR          string `json:"cidr,omitempty"`
+
+		// GroupID would allow traffic from the members of another
+		// security group. It is not supported.
+		GroupID string `json:"group_id,omitempty"`
+	} `json:"security_group_rule"`
+}
+
+// ComputeSecurityGroupRule represents the unmarshalled version of the
+// response to a v2.1/{tenant}/os-security-group-rules POST request.
+type ComputeSecurityGroupRule struct {
+	SecurityGroupRule SecurityGroupRule `json:"security_group_rule"`
+}
+
+// ComputeSecurityGroupAction represents the unmarshalled version of the
+// contents of a v2.1/{tenant}/servers/{server}/action request adding or
+// removing a security group.
+type ComputeSecurityGroupAction struct {
+	AddSecurityGroup    *SecurityGroup `json:"addSecurityGroup,omitempty"`
+	RemoveSecurityGroup *SecurityGroup `json:"removeSecurityGroup,omitempty"`
+}
+
+// KeyPair contains information about a SSH keypair of a tenant. The
+// private key is only returned when the keypair is generated by the
+// controller, as it is not stored.
+type KeyPair struct {
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	PrivateKey  string `json:"private_key,omitempty"`
+}
+
+// ComputeKeyPair represents the unmarshalled version of the response to
+// a v2.1/{tenant}/os-keypairs/{keypair} request.
+type ComputeKeyPair struct {
+	KeyPair KeyPair `json:"keypair"`
+}
+
+// ComputeKeyPairs represents the unmarshalled version of the response to
+// a v2.1/{tenant}/os-keypairs request.
+type ComputeKeyPairs struct {
+	KeyPairs []ComputeKeyPair `json:"keypairs"`
+}
+
+// NewComputeKeyPairs allocates a ComputeKeyPairs structure.
+// It allocates the KeyPairs slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer, as specified by the
+// OpenStack APIs.
+func NewComputeKeyPairs() (keys ComputeKeyPairs) {
+	keys.KeyPairs = []ComputeKeyPair{}
+	return
+}
+
+// ComputeCreateKeyPair represents the unmarshalled version of the contents
+// of a v2.1/{tenant}/os-keypairs POST request.  A new keypair is generated
+// if no public key is provided.
+type ComputeCreateKeyPair struct {
+	KeyPair struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key,omitempty"`
+	} `json:"keypair"`
+}
+
+// TaskStatus is the status of a controller task.
+type TaskStatus string
+
+const (
+	// TaskRunning means the task is waiting for some of its SSNTP
+	// commands to complete.
+	TaskRunning TaskStatus = "running"
+
+	// TaskCompleted means all the commands of the task have succeeded.
+	TaskCompleted = "completed"
+
+	// TaskFailed means the task has ended, with at least one error.
+	TaskFailed = "failed"
+)
+
+// TaskFrame is an SSNTP frame sent or received by ciao-controller on
+// behalf of a task.
+type TaskFrame struct {
+	Frame     string    `json:"frame"`
+	Direction string    `json:"direction"`
+	Resource  string    `json:"resource,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Task describes a multi-step operation of ciao-controller, e.g., the
+// start of a batch of instances.  Progress is the percentage of the task
+// resources whose commands have completed.
+type Task struct {
+	ID        string      `json:"id"`
+	Operation string      `json:"operation"`
+	TenantID  string      `json:"tenant_id,omitempty"`
+	Status    TaskStatus  `json:"status"`
+	Progress  int         `json:"progress"`
+	Resources []string    `json:"resources"`
+	Errors    []string    `json:"errors,omitempty"`
+	Frames    []TaskFrame `json:"frames"`
+	Created   time.Time   `json:"created"`
+	Updated   time.Time   `json:"updated"`
+}
+
+// ComputeTask represents the unmarshalled version of the response to a
+// /v2.1/tasks/{task} request.
+type ComputeTask struct {
+	Task Task `json:"task"`
+}
+
+// ComputeTasks represents the unmarshalled version of the response to a
+// /v2.1/tasks request.
+type ComputeTasks struct {
+	Tasks []Task `json:"tasks"`
+}
@@ -62,11 +62,36 @@ func (s StorageType) String() string {
 	return ""
 }
 
+// ConfigureTenantStartLimit contains the START rate limiting settings of
+// a single tenant, overriding the scheduler wide ones.
+type ConfigureTenantStartLimit struct {
+	TenantUUID string `yaml:"tenant_uuid"`
+	StartRate  int    `yaml:"start_rate,omitempty"`
+	StartBurst int    `yaml:"start_burst,omitempty"`
+	Weight     int    `yaml:"weight,omitempty"`
+}
+
 // ConfigureScheduler contains the unmarshalled configurations for the
 // scheduler service.
 type ConfigureScheduler struct {
 	ConfigStorageType StorageType `yaml:"storage_type"`
 	ConfigStorageURI  string      `yaml:"storage_uri"`
+
+	// StartRate is the number of START commands per second each tenant
+	// is allowed to dispatch. 0 disables START rate limiting.
+	StartRate int `yaml:"start_rate,omitempty"`
+
+	// StartBurst is the number of START commands a tenant can dispatch
+	// back to back before being rate limited.
+	StartBurst int `yaml:"start_burst,omitempty"`
+
+	// StartQueueLength is the maximum number of rate limited START
+	// commands queued per tenant.
+	StartQueueLength int `yaml:"start_queue_length,omitempty"`
+
+	// TenantStartLimits overrides the START rate limits and fair
+	// dispatching weight of specific tenants.
+	TenantStartLimits []ConfigureTenantStartLimit `yaml:"tenant_start_limits,omitempty"`
 }
 
 // ConfigureController contains the unmarshalled configurations for the
@@ -77,6 +102,10 @@ type ConfigureController struct {
 	HTTPSKey         string `yaml:"compute_cert"`
 	IdentityUser     string `yaml:"identity_user"`
 	IdentityPassword string `yaml:"identity_password"`
+
+	// FloatingIPPool lists the public IP addresses, or CIDR ranges of
+	// addresses, the controller allocates floating IPs from.
+	FloatingIPPool []string `yaml:"floating_ip_pool,omitempty"`
 }
 
 // ConfigureLauncher contains the unmarshalled configurations for the
@@ -86,6 +115,10 @@ type ConfigureLauncher struct {
 	ManagementNetwork []string `yaml:"mgmt_net"`
 	DiskLimit         bool     `yaml:"disk_limit"`
 	MemoryLimit       bool     `yaml:"mem_limit"`
+
+	// ConsolePort is the TCP port on which launchers accept the serial
+	// console connections of the controller.
+	ConsolePort int `yaml:"console_port,omitempty"`
 }
 
 // ConfigureStorage contains the unmarshalled configurations for the
@@ -127,4 +160,5 @@ func (conf *Configure) InitDefaults() {
 	conf.Configure.IdentityService.Type = Keystone
 	conf.Configure.Launcher.DiskLimit = true
 	conf.Configure.Launcher.MemoryLimit = true
+	conf.Configure.Launcher.ConsolePort = 8890
 }
@@ -0,0 +1,62 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// ConsoleLogCmd contains the information needed to retrieve the console log
+// of an instance.
+type ConsoleLogCmd struct {
+	// InstanceUUID is the UUID of the instance whose console log is
+	// requested
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// RequestID identifies the request.  It is copied into the
+	// ConsoleLog event sent in reply.
+	RequestID string `yaml:"request_id"`
+
+	// Lines is the number of lines to retrieve from the end of the
+	// log.  All the log is returned if Lines is not positive.
+	Lines int `yaml:"lines"`
+}
+
+// GetConsoleLog represents the unmarshalled version of the contents of a
+// SSNTP GetConsoleLog payload.
+type GetConsoleLog struct {
+	// GetConsoleLog contains information about the requested console
+	// log.
+	GetConsoleLog ConsoleLogCmd `yaml:"get_console_log"`
+}
+
+// ConsoleLogEvent contains the console log of an instance, or the reason
+// it could not be retrieved.
+type ConsoleLogEvent struct {
+	InstanceUUID string `yaml:"instance_uuid"`
+	RequestID    string `yaml:"request_id"`
+	Output       string `yaml:"output"`
+	Error        string `yaml:"error,omitempty"`
+}
+
+// EventConsoleLog represents the unmarshalled version of the contents of an
+// SSNTP ssntp.ConsoleLog event.  This event is sent by ciao-launcher in
+// reply to a GetConsoleLog command.
+type EventConsoleLog struct {
+	ConsoleLog ConsoleLogEvent `yaml:"console_log"`
+}
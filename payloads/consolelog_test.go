@@ -0,0 +1,92 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGetConsoleLogUnmarshal(t *testing.T) {
+	var cmd GetConsoleLog
+	err := yaml.Unmarshal([]byte(testutil.GetConsoleLogYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.GetConsoleLog.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.GetConsoleLog.InstanceUUID)
+	}
+
+	if cmd.GetConsoleLog.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.GetConsoleLog.WorkloadAgentUUID)
+	}
+
+	if cmd.GetConsoleLog.RequestID != testutil.RequestID {
+		t.Errorf("Wrong request ID field [%s]", cmd.GetConsoleLog.RequestID)
+	}
+
+	if cmd.GetConsoleLog.Lines != 50 {
+		t.Errorf("Wrong lines field [%d]", cmd.GetConsoleLog.Lines)
+	}
+}
+
+func TestGetConsoleLogMarshal(t *testing.T) {
+	var cmd GetConsoleLog
+	cmd.GetConsoleLog.InstanceUUID = testutil.InstanceUUID
+	cmd.GetConsoleLog.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.GetConsoleLog.RequestID = testutil.RequestID
+	cmd.GetConsoleLog.Lines = 50
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.GetConsoleLogYaml {
+		t.Errorf("GetConsoleLog marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.GetConsoleLogYaml)
+	}
+}
+
+func TestConsoleLogMarshal(t *testing.T) {
+	var event EventConsoleLog
+	event.ConsoleLog.InstanceUUID = testutil.InstanceUUID
+	event.ConsoleLog.RequestID = testutil.RequestID
+	event.ConsoleLog.Output = "login:\n"
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.ConsoleLogYaml {
+		t.Errorf("ConsoleLog marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.ConsoleLogYaml)
+	}
+
+	var result EventConsoleLog
+	err = yaml.Unmarshal(y, &result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != event {
+		t.Errorf("ConsoleLog unmarshalling failed\n[%+v]\n vs\n[%+v]", result, event)
+	}
+}
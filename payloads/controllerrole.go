@@ -0,0 +1,42 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// ControllerRoleType is the role of a controller connected to the scheduler.
+type ControllerRoleType string
+
+const (
+	// ControllerMaster is the role of the controller the scheduler
+	// forwards node events and failures to.
+	ControllerMaster ControllerRoleType = "master"
+
+	// ControllerBackup is the role of the controllers standing by to
+	// take over from the master controller.
+	ControllerBackup ControllerRoleType = "backup"
+)
+
+// ControllerRoleEvent contains the new role of a controller.
+type ControllerRoleEvent struct {
+	Role ControllerRoleType `yaml:"role"`
+}
+
+// ControllerRole represents the unmarshalled version of the contents of an
+// SSNTP ssntp.ControllerRole event payload.  This event is sent by the
+// scheduler to a controller whose role changes.
+type ControllerRole struct {
+	ControllerRole ControllerRoleEvent `yaml:"controller_role"`
+}
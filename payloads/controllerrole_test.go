@@ -0,0 +1,51 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestControllerRoleMarshal(t *testing.T) {
+	var event ControllerRole
+	event.ControllerRole.Role = ControllerMaster
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.ControllerRoleYaml {
+		t.Errorf("ControllerRole marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.ControllerRoleYaml)
+	}
+}
+
+func TestControllerRoleUnmarshal(t *testing.T) {
+	var event ControllerRole
+	err := yaml.Unmarshal([]byte(testutil.ControllerRoleYaml), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.ControllerRole.Role != ControllerMaster {
+		t.Errorf("Wrong controller role [%s]", event.ControllerRole.Role)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// CreateImageCmd contains the information needed to snapshot the rootfs
+// of an instance into a new image.
+type CreateImageCmd struct {
+	// InstanceUUID is the UUID of the instance to snapshot
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// ImageUUID is the UUID of the image to create.  It is chosen by
+	// the controller and copied into the ImageCreated event sent in
+	// reply.
+	ImageUUID string `yaml:"image_uuid"`
+}
+
+// CreateImage represents the unmarshalled version of the contents of a
+// SSNTP CreateImage payload.
+type CreateImage struct {
+	// CreateImage contains information about the image to create.
+	CreateImage CreateImageCmd `yaml:"create_image"`
+}
+
+// ImageCreatedEvent contains the outcome of a CreateImage command.
+type ImageCreatedEvent struct {
+	InstanceUUID string `yaml:"instance_uuid"`
+	ImageUUID    string `yaml:"image_uuid"`
+
+	// Size is the size of the new image, in bytes
+	Size  int64  `yaml:"size"`
+	Error string `yaml:"error,omitempty"`
+}
+
+// EventImageCreated represents the unmarshalled version of the contents of
+// an SSNTP ssntp.ImageCreated event.  This event is sent by ciao-launcher
+// once it has processed a CreateImage command.
+type EventImageCreated struct {
+	ImageCreated ImageCreatedEvent `yaml:"image_created"`
+}
@@ -0,0 +1,87 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCreateImageUnmarshal(t *testing.T) {
+	var cmd CreateImage
+	err := yaml.Unmarshal([]byte(testutil.CreateImageYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.CreateImage.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.CreateImage.InstanceUUID)
+	}
+
+	if cmd.CreateImage.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.CreateImage.WorkloadAgentUUID)
+	}
+
+	if cmd.CreateImage.ImageUUID != testutil.ImageUUID {
+		t.Errorf("Wrong image UUID field [%s]", cmd.CreateImage.ImageUUID)
+	}
+}
+
+func TestCreateImageMarshal(t *testing.T) {
+	var cmd CreateImage
+	cmd.CreateImage.InstanceUUID = testutil.InstanceUUID
+	cmd.CreateImage.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.CreateImage.ImageUUID = testutil.ImageUUID
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.CreateImageYaml {
+		t.Errorf("CreateImage marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.CreateImageYaml)
+	}
+}
+
+func TestImageCreatedMarshal(t *testing.T) {
+	var event EventImageCreated
+	event.ImageCreated.InstanceUUID = testutil.InstanceUUID
+	event.ImageCreated.ImageUUID = testutil.ImageUUID
+	event.ImageCreated.Size = 1073741824
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.ImageCreatedYaml {
+		t.Errorf("ImageCreated marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.ImageCreatedYaml)
+	}
+
+	var result EventImageCreated
+	err = yaml.Unmarshal(y, &result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != event {
+		t.Errorf("ImageCreated unmarshalling failed\n[%+v]\n vs\n[%+v]", result, event)
+	}
+}
@@ -16,12 +16,39 @@
 
 package payloads
 
+// NodeNextState is the state a node is left in once it has been evacuated.
+type NodeNextState string
+
+const (
+	// NodeMaintenance leaves the evacuated node connected, in maintenance
+	// mode, i.e. not accepting any new workload.
+	NodeMaintenance NodeNextState = "maintenance"
+
+	// NodeShutdown stops the launcher of the evacuated node.
+	NodeShutdown = "shutdown"
+)
+
 // EvacuateCmd contains the nodeID of a SSNTP Agent.
 type EvacuateCmd struct {
 	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// NextState is the state to leave the node in once its instances
+	// have been stopped.  It defaults to NodeMaintenance.
+	NextState NodeNextState `yaml:"next_state,omitempty"`
 }
 
 // Evacuate represents the SSNTP EVACUATE command payload.
 type Evacuate struct {
 	Evacuate EvacuateCmd `yaml:"evacuate"`
 }
+
+// RestoreCmd contains the nodeID of a SSNTP Agent left in maintenance mode
+// by an evacuation.
+type RestoreCmd struct {
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+}
+
+// Restore represents the SSNTP RESTORE command payload.
+type Restore struct {
+	Restore RestoreCmd `yaml:"restore"`
+}
@@ -49,3 +49,29 @@ func TestEvacUnmarshal(t *testing.T) {
 		t.Errorf("Wrong Agent UUID field [%s]", cmd.Evacuate.WorkloadAgentUUID)
 	}
 }
+
+func TestRestoreMarshal(t *testing.T) {
+	var cmd Restore
+	cmd.Restore.WorkloadAgentUUID = testutil.AgentUUID
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.RestoreYaml {
+		t.Errorf("RESTORE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.RestoreYaml)
+	}
+}
+
+func TestRestoreUnmarshal(t *testing.T) {
+	var cmd Restore
+	err := yaml.Unmarshal([]byte(testutil.RestoreYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.Restore.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.Restore.WorkloadAgentUUID)
+	}
+}
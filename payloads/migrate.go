@@ -0,0 +1,108 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// MigrateCmd contains the information needed to live migrate an instance
+// to another node.
+type MigrateCmd struct {
+	// InstanceUUID is the UUID of the instance to migrate
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// TargetAgentUUID identifies the node the instance is migrated to.
+	// It is chosen by the scheduler when left empty by the controller.
+	TargetAgentUUID string `yaml:"target_agent_uuid,omitempty"`
+
+	// RequestedResources contains the resources used by the instance,
+	// which are reserved on the target node.
+	RequestedResources []RequestedResource `yaml:"requested_resources"`
+}
+
+// Migrate represents the unmarshalled version of the contents of a
+// SSNTP MIGRATE payload.
+type Migrate struct {
+	// Migrate contains information about the instance to migrate.
+	Migrate MigrateCmd `yaml:"migrate"`
+}
+
+// MigrateIncomingCmd contains the information needed by the target node
+// of a live migration to prepare the migrated instance.
+type MigrateIncomingCmd struct {
+	// SourceAgentUUID identifies the node the instance is migrated from.
+	SourceAgentUUID string `yaml:"source_agent_uuid"`
+
+	// TargetAgentUUID identifies the node the instance is migrated to.
+	TargetAgentUUID string `yaml:"target_agent_uuid"`
+
+	// Instance is the full description of the migrated instance.
+	Instance RestartCmd `yaml:"instance"`
+}
+
+// MigrateIncoming represents the unmarshalled version of the contents of a
+// SSNTP MigrateIncoming payload.
+type MigrateIncoming struct {
+	MigrateIncoming MigrateIncomingCmd `yaml:"migrate_incoming"`
+}
+
+// EventMigrationPrepare represents the unmarshalled version of the contents
+// of an SSNTP ssntp.MigrationPrepare event.  This event is sent by the node
+// running an instance once it has received a MIGRATE command.
+type EventMigrationPrepare struct {
+	MigrationPrepare MigrateIncomingCmd `yaml:"migration_prepare"`
+}
+
+// MigrationReadyEvent contains the outcome of a MigrateIncoming command.
+type MigrationReadyEvent struct {
+	InstanceUUID    string `yaml:"instance_uuid"`
+	SourceAgentUUID string `yaml:"source_agent_uuid"`
+	TargetAgentUUID string `yaml:"target_agent_uuid"`
+
+	// URI is where the source node is to send the instance state,
+	// e.g., tcp:192.168.0.2:49152
+	URI string `yaml:"uri,omitempty"`
+
+	// DiskURI is the NBD export the source node is to mirror the rootfs
+	// of the instance to, e.g., nbd:192.168.0.2:49153:exportname=virtio0.
+	// The volumes of the instance are shared between the nodes and are
+	// not copied.
+	DiskURI string `yaml:"disk_uri,omitempty"`
+	Error   string `yaml:"error,omitempty"`
+}
+
+// EventMigrationReady represents the unmarshalled version of the contents of
+// an SSNTP ssntp.MigrationReady event.
+type EventMigrationReady struct {
+	MigrationReady MigrationReadyEvent `yaml:"migration_ready"`
+}
+
+// InstanceMigratedEvent contains the outcome of a live migration.
+type InstanceMigratedEvent struct {
+	InstanceUUID    string `yaml:"instance_uuid"`
+	SourceAgentUUID string `yaml:"source_agent_uuid"`
+	TargetAgentUUID string `yaml:"target_agent_uuid"`
+	Error           string `yaml:"error,omitempty"`
+}
+
+// EventInstanceMigrated represents the unmarshalled version of the contents
+// of an SSNTP ssntp.InstanceMigrated event.
+type EventInstanceMigrated struct {
+	InstanceMigrated InstanceMigratedEvent `yaml:"instance_migrated"`
+}
@@ -0,0 +1,154 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMigrateUnmarshal(t *testing.T) {
+	var cmd Migrate
+	err := yaml.Unmarshal([]byte(testutil.MigrateYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.Migrate.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.Migrate.InstanceUUID)
+	}
+
+	if cmd.Migrate.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.Migrate.WorkloadAgentUUID)
+	}
+
+	if cmd.Migrate.TargetAgentUUID != "" {
+		t.Errorf("Wrong target Agent UUID field [%s]", cmd.Migrate.TargetAgentUUID)
+	}
+
+	if len(cmd.Migrate.RequestedResources) != 2 ||
+		cmd.Migrate.RequestedResources[1].Type != MemMB ||
+		cmd.Migrate.RequestedResources[1].Value != 1024 {
+		t.Errorf("Wrong requested resources %v", cmd.Migrate.RequestedResources)
+	}
+}
+
+func TestMigrateMarshal(t *testing.T) {
+	var cmd Migrate
+	cmd.Migrate.InstanceUUID = testutil.InstanceUUID
+	cmd.Migrate.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.Migrate.RequestedResources = []RequestedResource{
+		{Type: VCPUs, Value: 2, Mandatory: true},
+		{Type: MemMB, Value: 1024, Mandatory: true},
+	}
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.MigrateYaml {
+		t.Errorf("MIGRATE marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.MigrateYaml)
+	}
+}
+
+func TestMigrateIncomingMarshal(t *testing.T) {
+	var cmd MigrateIncoming
+	cmd.MigrateIncoming.SourceAgentUUID = testutil.AgentUUID
+	cmd.MigrateIncoming.TargetAgentUUID = testutil.TargetAgentUUID
+	cmd.MigrateIncoming.Instance.InstanceUUID = testutil.InstanceUUID
+	cmd.MigrateIncoming.Instance.VMType = QEMU
+	cmd.MigrateIncoming.Instance.Volumes = []string{testutil.VolumeUUID}
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result MigrateIncoming
+	err = yaml.Unmarshal(y, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	incoming := result.MigrateIncoming
+	if incoming.SourceAgentUUID != testutil.AgentUUID ||
+		incoming.TargetAgentUUID != testutil.TargetAgentUUID ||
+		incoming.Instance.InstanceUUID != testutil.InstanceUUID ||
+		incoming.Instance.VMType != QEMU ||
+		len(incoming.Instance.Volumes) != 1 {
+		t.Errorf("MigrateIncoming unmarshalling failed\n[%+v]\n vs\n[%+v]",
+			incoming, cmd.MigrateIncoming)
+	}
+}
+
+func TestMigrationReadyMarshal(t *testing.T) {
+	var event EventMigrationReady
+	event.MigrationReady.InstanceUUID = testutil.InstanceUUID
+	event.MigrationReady.SourceAgentUUID = testutil.AgentUUID
+	event.MigrationReady.TargetAgentUUID = testutil.TargetAgentUUID
+	event.MigrationReady.URI = "tcp:192.168.0.2:49152"
+	event.MigrationReady.DiskURI = "nbd:192.168.0.2:49153:exportname=virtio0"
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.MigrationReadyYaml {
+		t.Errorf("MigrationReady marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.MigrationReadyYaml)
+	}
+
+	var result EventMigrationReady
+	err = yaml.Unmarshal(y, &result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != event {
+		t.Errorf("MigrationReady unmarshalling failed\n[%+v]\n vs\n[%+v]", result, event)
+	}
+}
+
+func TestInstanceMigratedMarshal(t *testing.T) {
+	var event EventInstanceMigrated
+	event.InstanceMigrated.InstanceUUID = testutil.InstanceUUID
+	event.InstanceMigrated.SourceAgentUUID = testutil.AgentUUID
+	event.InstanceMigrated.TargetAgentUUID = testutil.TargetAgentUUID
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.InstanceMigratedYaml {
+		t.Errorf("InstanceMigrated marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.InstanceMigratedYaml)
+	}
+
+	var result EventInstanceMigrated
+	err = yaml.Unmarshal(y, &result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != event {
+		t.Errorf("InstanceMigrated unmarshalling failed\n[%+v]\n vs\n[%+v]", result, event)
+	}
+}
@@ -0,0 +1,113 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads
+
+// EvacuatedInstance describes an instance stopped by the launcher of an
+// evacuated node.
+type EvacuatedInstance struct {
+	// Restart contains everything needed to restart the instance on
+	// another node.
+	Restart RestartCmd `yaml:"restart"`
+
+	// Movable is true if the instance state lives on volumes and can
+	// thus be restarted on another node.  The launcher removes the local
+	// copy of movable instances.
+	Movable bool `yaml:"movable"`
+}
+
+// EventInstancesEvacuated contains the instances stopped by the launcher
+// of an evacuated node.
+type EventInstancesEvacuated struct {
+	// NodeUUID is the SSNTP UUID of the evacuated node.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// NextState is the state the node is left in.
+	NextState NodeNextState `yaml:"next_state"`
+
+	// Instances lists the instances the launcher stopped.
+	Instances []EvacuatedInstance `yaml:"instances"`
+}
+
+// InstancesEvacuated represents the unmarshalled version of the contents of
+// an SSNTP ssntp.InstancesEvacuated event payload.  This event is sent by a
+// launcher to the scheduler once all the instances of its node have been
+// stopped.
+type InstancesEvacuated struct {
+	Evacuated EventInstancesEvacuated `yaml:"instances_evacuated"`
+}
+
+// EvacuationStatus is the progress of a node evacuation.
+type EvacuationStatus string
+
+const (
+	// EvacuationDraining means the node no longer accepts new instances
+	// and its launcher is stopping the running ones.
+	EvacuationDraining EvacuationStatus = "draining"
+
+	// EvacuationComplete means all the node instances have been stopped,
+	// and the movable ones placed on other nodes.
+	EvacuationComplete = "complete"
+)
+
+// EvacuatedInstanceStatus is the outcome of an evacuation for an instance.
+type EvacuatedInstanceStatus string
+
+const (
+	// InstanceStopped means the instance has been stopped and left on
+	// the evacuated node.
+	InstanceStopped EvacuatedInstanceStatus = "stopped"
+
+	// InstanceReplaced means the instance has been restarted on another
+	// node.
+	InstanceReplaced = "replaced"
+
+	// InstanceReplaceFailed means there was no node to restart the
+	// instance on.
+	InstanceReplaceFailed = "failed"
+)
+
+// NodeEvacuationInstance contains the outcome of an evacuation for one
+// instance.
+type NodeEvacuationInstance struct {
+	InstanceUUID string                  `yaml:"instance_uuid"`
+	Status       EvacuatedInstanceStatus `yaml:"status"`
+
+	// NodeUUID is the node the instance has been restarted on, if any.
+	NodeUUID string `yaml:"node_uuid,omitempty"`
+}
+
+// EventNodeEvacuation contains the progress of a node evacuation.
+type EventNodeEvacuation struct {
+	// NodeUUID is the SSNTP UUID of the evacuated node.
+	NodeUUID string `yaml:"node_uuid"`
+
+	Status EvacuationStatus `yaml:"status"`
+
+	// NextState is the state the node is left in once evacuated.
+	NextState NodeNextState `yaml:"next_state"`
+
+	// Instances contains the outcome of the evacuation for each of the
+	// node instances.  It is only set once the evacuation is complete.
+	Instances []NodeEvacuationInstance `yaml:"instances,omitempty"`
+}
+
+// NodeEvacuation represents the unmarshalled version of the contents of an
+// SSNTP ssntp.NodeEvacuation event payload.  This event is sent by the
+// scheduler to the controllers to report the progress of a node evacuation.
+type NodeEvacuation struct {
+	Evacuation EventNodeEvacuation `yaml:"node_evacuation"`
+}
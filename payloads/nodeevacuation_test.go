@@ -0,0 +1,107 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestInstancesEvacuatedMarshal(t *testing.T) {
+	var restart Restart
+	err := yaml.Unmarshal([]byte(testutil.RestartYaml), &restart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restart.Restart.Volumes = []string{testutil.VolumeUUID}
+
+	var event InstancesEvacuated
+	event.Evacuated.NodeUUID = testutil.AgentUUID
+	event.Evacuated.NextState = NodeShutdown
+	event.Evacuated.Instances = []EvacuatedInstance{
+		{Restart: restart.Restart, Movable: true},
+	}
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var evacuated InstancesEvacuated
+	err = yaml.Unmarshal(y, &evacuated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if evacuated.Evacuated.NodeUUID != testutil.AgentUUID ||
+		evacuated.Evacuated.NextState != NodeShutdown ||
+		len(evacuated.Evacuated.Instances) != 1 {
+		t.Fatalf("InstancesEvacuated marshalling mismatch: %v", evacuated)
+	}
+
+	instance := evacuated.Evacuated.Instances[0]
+	if instance.Movable != true ||
+		instance.Restart.InstanceUUID != testutil.InstanceUUID ||
+		len(instance.Restart.Volumes) != 1 ||
+		instance.Restart.Volumes[0] != testutil.VolumeUUID {
+		t.Errorf("Evacuated instance marshalling mismatch: %v", instance)
+	}
+}
+
+func TestNodeEvacuationMarshal(t *testing.T) {
+	var event NodeEvacuation
+	event.Evacuation.NodeUUID = testutil.AgentUUID
+	event.Evacuation.Status = EvacuationComplete
+	event.Evacuation.NextState = NodeMaintenance
+	event.Evacuation.Instances = []NodeEvacuationInstance{
+		{InstanceUUID: testutil.InstanceUUID, Status: InstanceReplaced, NodeUUID: testutil.NetAgentUUID},
+	}
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var evacuation NodeEvacuation
+	err = yaml.Unmarshal(y, &evacuation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if evacuation.Evacuation.NodeUUID != testutil.AgentUUID ||
+		evacuation.Evacuation.Status != EvacuationComplete ||
+		evacuation.Evacuation.NextState != NodeMaintenance ||
+		len(evacuation.Evacuation.Instances) != 1 ||
+		evacuation.Evacuation.Instances[0] != event.Evacuation.Instances[0] {
+		t.Errorf("NodeEvacuation marshalling mismatch: %v", evacuation)
+	}
+}
+
+func TestEvacNextStateUnmarshal(t *testing.T) {
+	var cmd Evacuate
+	err := yaml.Unmarshal([]byte(testutil.EvacuateYaml+"  next_state: shutdown\n"), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Evacuate.NextState != NodeShutdown {
+		t.Errorf("Wrong next state [%s]", cmd.Evacuate.NextState)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// RebootType indicates how an instance should be rebooted.
+type RebootType string
+
+const (
+	// RebootSoft asks the guest to shut down cleanly before restarting it.
+	RebootSoft RebootType = "soft"
+
+	// RebootHard powers the instance off immediately before restarting it.
+	RebootHard RebootType = "hard"
+)
+
+// RebootCmd contains the information needed to reboot a running instance.
+type RebootCmd struct {
+	// InstanceUUID is the UUID of the instance to reboot
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Type is either RebootSoft or RebootHard
+	Type RebootType `yaml:"type"`
+}
+
+// Reboot represents the unmarshalled version of the contents of a SSNTP
+// REBOOT payload.
+type Reboot struct {
+	// Reboot contains information about the instance to reboot.
+	Reboot RebootCmd `yaml:"reboot"`
+}
+
+// Pause represents the unmarshalled version of the contents of a SSNTP PAUSE
+// payload.
+type Pause struct {
+	// Pause contains information about the instance to pause.
+	Pause StopCmd `yaml:"pause"`
+}
+
+// Unpause represents the unmarshalled version of the contents of a SSNTP
+// UNPAUSE payload.
+type Unpause struct {
+	// Unpause contains information about the instance to unpause.
+	Unpause StopCmd `yaml:"unpause"`
+}
+
+// Suspend represents the unmarshalled version of the contents of a SSNTP
+// SUSPEND payload.
+type Suspend struct {
+	// Suspend contains information about the instance to suspend.
+	Suspend StopCmd `yaml:"suspend"`
+}
+
+// Resume represents the unmarshalled version of the contents of a SSNTP
+// RESUME payload.
+type Resume struct {
+	// Resume contains information about the instance to resume.
+	Resume StopCmd `yaml:"resume"`
+}
@@ -117,3 +117,35 @@ func TestRestartUnmarshalPartial(t *testing.T) {
 		t.Error("Unexpected values in Restart")
 	}
 }
+
+func TestRestartResizeMarshal(t *testing.T) {
+	var cmd Restart
+	cmd.Restart.InstanceUUID = testutil.InstanceUUID
+	cmd.Restart.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.Restart.RequestedResources = []RequestedResource{
+		{Type: VCPUs, Value: 4, Mandatory: true},
+		{Type: MemMB, Value: 8192, Mandatory: true},
+		{Type: DiskMB, Value: 20000, Mandatory: true},
+	}
+	cmd.Restart.EstimatedResources = []EstimatedResource{}
+	cmd.Restart.Resize = true
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.ResizeRestartYaml {
+		t.Errorf("Restart marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.ResizeRestartYaml)
+	}
+
+	var restart Restart
+	err = yaml.Unmarshal(y, &restart)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !restart.Restart.Resize || restart.Restart.Relocate {
+		t.Error("Unexpected resize flags in Restart")
+	}
+}
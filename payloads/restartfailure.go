@@ -52,6 +52,14 @@ const (
 	// RestartNetworkFailure indicates that it was not possible to
 	// initialise networking for the instance before restarting it.
 	RestartNetworkFailure = "network_failure"
+
+	// RestartResizeFailure indicates that the new resources of a resized
+	// instance could not be applied, e.g., its rootfs could not be grown.
+	RestartResizeFailure = "resize_failure"
+
+	// RestartNoCapacity indicates that a resized instance does not fit on
+	// its node any more and cannot be moved to another node.
+	RestartNoCapacity = "no_capacity"
 )
 
 // ErrorRestartFailure represents the unmarshalled version of the contents of a
@@ -81,6 +89,10 @@ func (r RestartFailureReason) String() string {
 		return "Failed to launch instance"
 	case RestartNetworkFailure:
 		return "Failed to locate VNIC for instance"
+	case RestartResizeFailure:
+		return "Failed to resize instance"
+	case RestartNoCapacity:
+		return "Not enough capacity to restart resized instance"
 	}
 
 	return ""
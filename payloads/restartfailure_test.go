@@ -67,6 +67,8 @@ func TestRestartFailureString(t *testing.T) {
 		{RestartInstanceCorrupt, "Instance is corrupt"},
 		{RestartLaunchFailure, "Failed to launch instance"},
 		{RestartNetworkFailure, "Failed to locate VNIC for instance"},
+		{RestartResizeFailure, "Failed to resize instance"},
+		{RestartNoCapacity, "Not enough capacity to restart resized instance"},
 	}
 	error := ErrorRestartFailure{
 		InstanceUUID: testutil.InstanceUUID,
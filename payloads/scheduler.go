@@ -0,0 +1,112 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads
+
+import (
+	"time"
+)
+
+// SchedulerController contains the role of a controller connected to
+// ciao-scheduler.
+type SchedulerController struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// SchedulerControllers represents the unmarshalled version of the contents
+// of a scheduler admin /controllers response.
+type SchedulerControllers struct {
+	Controllers []SchedulerController `json:"controllers"`
+}
+
+// NewSchedulerControllers allocates a SchedulerControllers structure.
+// It allocates the Controllers slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer.
+func NewSchedulerControllers() (controllers SchedulerControllers) {
+	controllers.Controllers = []SchedulerController{}
+	return
+}
+
+// SchedulerFailover represents the unmarshalled version of the contents
+// of a scheduler admin /controllers/failover request.  Master is the
+// UUID of the backup controller to promote.  When empty, the first
+// backup controller is promoted.
+type SchedulerFailover struct {
+	Master string `json:"master"`
+}
+
+// SchedulerNode contains the live capacity and status of a compute or
+// network node, as seen by ciao-scheduler.  MemAvailable is the memory
+// reported by the node minus MemReserved, the memory claimed by instances
+// dispatched to the node but not yet reported in its statistics.  Draining
+// nodes are being evacuated and do not accept new instances.
+type SchedulerNode struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	MemTotal     int    `json:"ram_total"`
+	MemAvailable int    `json:"ram_available"`
+	MemReserved  int    `json:"ram_reserved"`
+	Reservations int    `json:"reservations"`
+	Draining     bool   `json:"draining"`
+	Load         int    `json:"load"`
+	OnlineCPUs   int    `json:"online_cpus"`
+	MRU          bool   `json:"mru"`
+}
+
+// SchedulerNodes represents the unmarshalled version of the contents
+// of a scheduler admin /nodes response.
+type SchedulerNodes struct {
+	ComputeNodes []SchedulerNode `json:"compute_nodes"`
+	NetworkNodes []SchedulerNode `json:"network_nodes"`
+}
+
+// NewSchedulerNodes allocates a SchedulerNodes structure.
+// It allocates the node slices as well so that the marshalled
+// JSON contains empty arrays and not nil pointers.
+func NewSchedulerNodes() (nodes SchedulerNodes) {
+	nodes.ComputeNodes = []SchedulerNode{}
+	nodes.NetworkNodes = []SchedulerNode{}
+	return
+}
+
+// SchedulerPlacement describes a single placement decision taken by
+// ciao-scheduler for a START command.
+type SchedulerPlacement struct {
+	Timestamp  time.Time `json:"time_stamp"`
+	InstanceID string    `json:"instance_id"`
+	TenantID   string    `json:"tenant_id"`
+	NodeID     string    `json:"node_id"`
+	NodeType   Resource  `json:"node_type"`
+	MemReqMB   int       `json:"ram_requested"`
+	Dispatched bool      `json:"dispatched"`
+	Reason     string    `json:"reason"`
+}
+
+// SchedulerPlacements represents the unmarshalled version of the contents
+// of a scheduler admin /placements response.  The most recent placement
+// decision comes first.
+type SchedulerPlacements struct {
+	Placements []SchedulerPlacement `json:"placements"`
+}
+
+// NewSchedulerPlacements allocates a SchedulerPlacements structure.
+// It allocates the Placements slice as well so that the marshalled
+// JSON is an empty array and not a nil pointer.
+func NewSchedulerPlacements() (placements SchedulerPlacements) {
+	placements.Placements = []SchedulerPlacement{}
+	return
+}
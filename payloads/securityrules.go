@@ -0,0 +1,69 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads
+
+// SecurityRule describes a single ingress rule of a security group.
+// Traffic is accepted when its protocol, destination port and source
+// address all match the rule.
+type SecurityRule struct {
+	// Protocol is one of tcp, udp or icmp.
+	Protocol string `yaml:"protocol"`
+
+	// FromPort and ToPort delimit the destination port range for tcp
+	// and udp rules.  For icmp rules FromPort is the ICMP type, -1
+	// meaning any type, and ToPort is ignored.
+	FromPort int `yaml:"from_port"`
+	ToPort   int `yaml:"to_port"`
+
+	// CIDR is the source network the rule applies to.
+	CIDR string `yaml:"cidr"`
+}
+
+// InstanceSecurityRules contains the complete set of security rules
+// applying to a tenant instance.
+type InstanceSecurityRules struct {
+	InstanceUUID string         `yaml:"instance_uuid"`
+	PrivateIP    string         `yaml:"private_ip"`
+	Rules        []SecurityRule `yaml:"rules,omitempty"`
+}
+
+// SecurityRulesCommand contains the security rules for a set of instances
+// belonging to the same tenant.  The rules of each instance replace any
+// rules previously applied to it.
+type SecurityRulesCommand struct {
+	// ConcentratorUUID identifies the CNCI of the tenant.
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+
+	// TenantUUID identifies the tenant owning the instances.
+	TenantUUID string `yaml:"tenant_uuid"`
+
+	// Instances contains the rules of each instance.
+	Instances []InstanceSecurityRules `yaml:"instances"`
+}
+
+// CommandApplySecurityRules represents the unmarshalled version of the
+// contents of a SSNTP ApplySecurityRules payload.
+type CommandApplySecurityRules struct {
+	Apply SecurityRulesCommand `yaml:"apply_security_rules"`
+}
+
+// CommandClearSecurityRules represents the unmarshalled version of the
+// contents of a SSNTP ClearSecurityRules payload.  Only the instance UUIDs
+// and private IPs are meaningful.
+type CommandClearSecurityRules struct {
+	Clear SecurityRulesCommand `yaml:"clear_security_rules"`
+}
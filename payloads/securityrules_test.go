@@ -0,0 +1,114 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"gopkg.in/yaml.v2"
+)
+
+const applySecurityRulesYaml = `apply_security_rules:
+  concentrator_uuid: 3390740c-dce9-48d6-b83a-a717417072ce
+  tenant_uuid: 2491851d-dce9-48d6-b83a-a717417072ce
+  instances:
+  - instance_uuid: 67d86208-b46c-4465-9018-e14187d4010
+    private_ip: 172.16.0.2
+    rules:
+    - protocol: tcp
+      from_port: 22
+      to_port: 22
+      cidr: 0.0.0.0/0
+    - protocol: icmp
+      from_port: -1
+      to_port: -1
+      cidr: 10.0.0.0/8
+`
+
+func TestApplySecurityRulesUnmarshal(t *testing.T) {
+	var cmd CommandApplySecurityRules
+	err := yaml.Unmarshal([]byte(applySecurityRulesYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apply := cmd.Apply
+	if apply.ConcentratorUUID != "3390740c-dce9-48d6-b83a-a717417072ce" ||
+		apply.TenantUUID != "2491851d-dce9-48d6-b83a-a717417072ce" {
+		t.Fatalf("Wrong UUIDs: %v", apply)
+	}
+
+	if len(apply.Instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(apply.Instances))
+	}
+
+	expected := InstanceSecurityRules{
+		InstanceUUID: "67d86208-b46c-4465-9018-e14187d4010",
+		PrivateIP:    "172.16.0.2",
+		Rules: []SecurityRule{
+			{Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "0.0.0.0/0"},
+			{Protocol: "icmp", FromPort: -1, ToPort: -1, CIDR: "10.0.0.0/8"},
+		},
+	}
+	if !reflect.DeepEqual(apply.Instances[0], expected) {
+		t.Fatalf("Instance rules mismatch: %v", apply.Instances[0])
+	}
+}
+
+func TestApplySecurityRulesMarshal(t *testing.T) {
+	var cmd CommandApplySecurityRules
+	err := yaml.Unmarshal([]byte(applySecurityRulesYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != applySecurityRulesYaml {
+		t.Fatalf("ApplySecurityRules marshalling failed\n[%s]\n vs\n[%s]",
+			string(y), applySecurityRulesYaml)
+	}
+}
+
+func TestClearSecurityRulesMarshal(t *testing.T) {
+	var cmd CommandClearSecurityRules
+	cmd.Clear.ConcentratorUUID = "3390740c-dce9-48d6-b83a-a717417072ce"
+	cmd.Clear.TenantUUID = "2491851d-dce9-48d6-b83a-a717417072ce"
+	cmd.Clear.Instances = []InstanceSecurityRules{
+		{InstanceUUID: "67d86208-b46c-4465-9018-e14187d4010", PrivateIP: "172.16.0.2"},
+	}
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var clear CommandClearSecurityRules
+	err = yaml.Unmarshal(y, &clear)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cmd, clear) {
+		t.Fatalf("ClearSecurityRules marshalling mismatch: %v", clear)
+	}
+}
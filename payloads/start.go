@@ -220,6 +220,11 @@ type RestartCmd struct {
 	// ImageUUID  is the image ID fo the instance to restart.
 	ImageUUID string `yaml:"image_uuid"`
 
+	// DockerImage is the name of the docker base image of the instance
+	// to restart.  Only set, along with VMType, when the instance is
+	// restarted on a node it was not created on.
+	DockerImage string `yaml:"docker_image,omitempty"`
+
 	// WorkloadAgentUUID identifies the node on which the instance is
 	// running.  This information is needed by the scheduler to route
 	// the command to the correct CN/NN.
@@ -231,6 +236,11 @@ type RestartCmd struct {
 	// InstancePersistence is the persistence type for the instance.
 	InstancePersistence Persistence `yaml:"persistence"`
 
+	// VMType indicates whether the instance to restart is a qemu or a
+	// docker instance.  Only set when the instance is restarted on a
+	// node it was not created on.
+	VMType Hypervisor `yaml:"vm_type,omitempty"`
+
 	// RequestedResources contains a list of the resources that are to be
 	// assigned to the new instance.
 	RequestedResources []RequestedResource `yaml:"requested_resources"`
@@ -241,6 +251,25 @@ type RestartCmd struct {
 	// Networking contains all the information required to set up networking
 	// for the new instance.
 	Networking NetworkResources `yaml:"networking"`
+
+	// Volumes lists the UUIDs of the volumes attached to the instance.
+	// They are re-attached when the instance is restarted on a node it
+	// was not created on.
+	Volumes []string `yaml:"volumes,omitempty"`
+
+	// Storage describes the volume the instance boots from, if any.  Only
+	// set when the instance is restarted on a node it was not created on.
+	Storage StorageResources `yaml:"storage,omitempty"`
+
+	// Resize indicates that RequestedResources differ from the resources
+	// the instance was created with.  The node stores the new resources
+	// in the instance configuration before restarting it.
+	Resize bool `yaml:"resize,omitempty"`
+
+	// Relocate is set by the scheduler on a resize when the node of the
+	// instance lacks the capacity to restart it.  The node then hands the
+	// instance back to the scheduler for placement, if it can be moved.
+	Relocate bool `yaml:"relocate,omitempty"`
 }
 
 // Restart represents the unmarshalled version of the contents of a SSNTP
@@ -0,0 +1,39 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package payloads
+
+// StartBatchCmd contains the instances to be scheduled by a single
+// StartBatch command.
+type StartBatchCmd struct {
+	// Gang requests the batch to be started all or nothing.  If one of
+	// the instances can not be placed, none of them is started and a
+	// StartFailure error is returned for each of them.
+	Gang bool `yaml:"gang"`
+
+	// Starts contains one complete SSNTP START payload per instance,
+	// i.e. the START YAML document followed by the instance cloud-init
+	// configuration.  The scheduler forwards each of them to the node
+	// selected for the instance.
+	Starts []string `yaml:"starts"`
+}
+
+// StartBatch represents the unmarshalled version of the contents of a SSNTP
+// StartBatch payload.  The structure contains enough information to create
+// and launch several CN or NN instances at once.
+type StartBatch struct {
+	Batch StartBatchCmd `yaml:"start_batch"`
+}
@@ -0,0 +1,58 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestStartBatchMarshal(t *testing.T) {
+	var batch StartBatch
+	batch.Batch.Gang = true
+	batch.Batch.Starts = []string{testutil.StartYaml, testutil.CNCIStartYaml}
+
+	y, err := yaml.Marshal(&batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cmd StartBatch
+	err = yaml.Unmarshal(y, &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Batch.Gang != true || len(cmd.Batch.Starts) != 2 {
+		t.Fatalf("StartBatch marshalling mismatch: %v", cmd)
+	}
+
+	for i, s := range cmd.Batch.Starts {
+		if s != batch.Batch.Starts[i] {
+			t.Errorf("START payload %d mismatch:\n%s\n%s", i, s, batch.Batch.Starts[i])
+		}
+
+		var start Start
+		err = yaml.Unmarshal([]byte(s), &start)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
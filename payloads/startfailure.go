@@ -72,6 +72,16 @@ const (
 	// NetworkFailure indicates that it was not possible to initialise
 	// networking for the instance.
 	NetworkFailure = "network_failure"
+
+	// TenantThrottled is returned by the scheduler when a tenant exceeded
+	// its START rate limit and its queue of pending START commands is
+	// full.
+	TenantThrottled = "tenant_throttled"
+
+	// GangFailure is returned by the scheduler for every instance of a
+	// gang START batch when one of the batch instances could not be
+	// placed, in which case none of them is started.
+	GangFailure = "gang_failure"
 )
 
 // ErrorStartFailure represents the unmarshalled version of the contents of a
@@ -109,6 +119,10 @@ func (r StartFailureReason) String() string {
 		return "Failed to launch instance"
 	case NetworkFailure:
 		return "Failed to create VNIC for instance"
+	case TenantThrottled:
+		return "Tenant START rate limit exceeded"
+	case GangFailure:
+		return "Instance gang could not be placed"
 	}
 
 	return ""
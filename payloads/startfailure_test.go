@@ -72,6 +72,8 @@ func TestStartFailureString(t *testing.T) {
 		{ImageFailure, "Failed to create instance image"},
 		{LaunchFailure, "Failed to launch instance"},
 		{NetworkFailure, "Failed to create VNIC for instance"},
+		{TenantThrottled, "Tenant START rate limit exceeded"},
+		{GangFailure, "Instance gang could not be placed"},
 	}
 	error := ErrorStartFailure{
 		InstanceUUID: testutil.InstanceUUID,
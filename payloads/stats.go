@@ -119,6 +119,11 @@ const (
 	// is not currently running, either because it failed to start or was
 	// explicitly stopped by a STOP command or perhaps by a CN reboot.
 	Exited = ComputeStatusStopped
+
+	// Paused indicates that the execution of an instance has been frozen
+	// by a PAUSE command.
+	Paused = ComputeStatusPaused
+
 	// ExitFailed is not currently used
 	ExitFailed = "exit_failed"
 	// ExitPaused is not currently used
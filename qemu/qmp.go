@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"time"
 
 	"golang.org/x/net/context"
@@ -108,7 +109,7 @@ type QMPEvent struct {
 
 type qmpResult struct {
 	err  error
-	data map[string]interface{}
+	data interface{}
 }
 
 type qmpCommand struct {
@@ -118,6 +119,7 @@ type qmpCommand struct {
 	args           map[string]interface{}
 	filter         *qmpEventFilter
 	resultReceived bool
+	data           interface{}
 }
 
 // QMP is a structure that contains the internal state used by startQMPLoop and
@@ -176,7 +178,7 @@ func (q *QMP) processQMPEvent(cmdQueue *list.List, name interface{}, data interf
 				}
 				if match {
 					if cmd.resultReceived {
-						q.finaliseCommand(cmdEl, cmdQueue, true)
+						q.finaliseCommand(cmdEl, cmdQueue, true, cmd.data)
 					} else {
 						cmd.filter = nil
 					}
@@ -203,14 +205,15 @@ func (q *QMP) processQMPEvent(cmdQueue *list.List, name interface{}, data interf
 	}
 }
 
-func (q *QMP) finaliseCommand(cmdEl *list.Element, cmdQueue *list.List, succeeded bool) {
+func (q *QMP) finaliseCommand(cmdEl *list.Element, cmdQueue *list.List, succeeded bool,
+	data interface{}) {
 	cmd := cmdEl.Value.(*qmpCommand)
 	cmdQueue.Remove(cmdEl)
 	select {
 	case <-cmd.ctx.Done():
 	default:
 		if succeeded {
-			cmd.res <- qmpResult{}
+			cmd.res <- qmpResult{data: data}
 		} else {
 			cmd.res <- qmpResult{err: fmt.Errorf("QMP command failed")}
 		}
@@ -233,7 +236,7 @@ func (q *QMP) processQMPInput(line []byte, cmdQueue *list.List) {
 		return
 	}
 
-	_, succeeded := vmData["return"]
+	ret, succeeded := vmData["return"]
 	_, failed := vmData["error"]
 
 	if !succeeded && !failed {
@@ -248,9 +251,10 @@ func (q *QMP) processQMPInput(line []byte, cmdQueue *list.List) {
 	}
 	cmd := cmdEl.Value.(*qmpCommand)
 	if failed || cmd.filter == nil {
-		q.finaliseCommand(cmdEl, cmdQueue, succeeded)
+		q.finaliseCommand(cmdEl, cmdQueue, succeeded, ret)
 	} else {
 		cmd.resultReceived = true
+		cmd.data = ret
 	}
 }
 
@@ -400,7 +404,14 @@ func startQMPLoop(conn io.ReadWriteCloser, cfg QMPConfig,
 
 func (q *QMP) executeCommand(ctx context.Context, name string, args map[string]interface{},
 	filter *qmpEventFilter) error {
+	_, err := q.executeCommandWithResponse(ctx, name, args, filter)
+	return err
+}
+
+func (q *QMP) executeCommandWithResponse(ctx context.Context, name string,
+	args map[string]interface{}, filter *qmpEventFilter) (interface{}, error) {
 	var err error
+	var data interface{}
 	resCh := make(chan qmpResult)
 	select {
 	case <-q.disconnectedCh:
@@ -415,17 +426,18 @@ func (q *QMP) executeCommand(ctx context.Context, name string, args map[string]i
 	}
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	select {
 	case res := <-resCh:
 		err = res.err
+		data = res.data
 	case <-ctx.Done():
 		err = ctx.Err()
 	}
 
-	return err
+	return data, err
 }
 
 // QMPStart connects to a unix domain socket maintained by a QMP instance.  It
@@ -527,6 +539,15 @@ func (q *QMP) ExecuteSystemPowerdown(ctx context.Context) error {
 	return q.executeCommand(ctx, "system_powerdown", nil, filter)
 }
 
+// ExecuteSystemPowerdownNoWait sends the system_powerdown command to the
+// instance.  Unlike ExecuteSystemPowerdown, it returns as soon as the
+// command has been acknowledged, without waiting for the guest to shut
+// down, so that other commands can still be sent to the instance while it
+// is powering down.
+func (q *QMP) ExecuteSystemPowerdownNoWait(ctx context.Context) error {
+	return q.executeCommand(ctx, "system_powerdown", nil, nil)
+}
+
 // ExecuteQuit sends the quit command to the instance, terminating
 // the QMP instance immediately.
 func (q *QMP) ExecuteQuit(ctx context.Context) error {
@@ -595,3 +616,154 @@ func (q *QMP) ExecuteDeviceDel(ctx context.Context, devID string) error {
 	}
 	return q.executeCommand(ctx, "device_del", args, filter)
 }
+
+// ExecuteMigrate starts the migration of the instance to the QEMU instance
+// listening on uri, e.g., tcp:192.168.0.2:4444, by sending the migrate command.
+// If blk is true the contents of the instance's local disks are copied along
+// with its memory.  inc requests an incremental copy, i.e., only the
+// contents of the top image of each disk are copied, the destination already
+// having access to the backing files.  The command returns as soon as the
+// migration has started.  ExecuteQueryMigrate can be used to determine when
+// it has finished.
+func (q *QMP) ExecuteMigrate(ctx context.Context, uri string, blk, inc bool) error {
+	args := map[string]interface{}{
+		"uri": uri,
+	}
+	if blk {
+		args["blk"] = true
+	}
+	if inc {
+		args["inc"] = true
+	}
+	return q.executeCommand(ctx, "migrate", args, nil)
+}
+
+// ExecuteMigrateCancel cancels an outstanding migration by sending the
+// migrate_cancel command.
+func (q *QMP) ExecuteMigrateCancel(ctx context.Context) error {
+	return q.executeCommand(ctx, "migrate_cancel", nil, nil)
+}
+
+// MigrationStatus contains the state of a migration, as reported by the
+// query-migrate command.
+type MigrationStatus struct {
+	// Status is the state of the migration, e.g., active, completed or failed.
+	// It is empty if no migration has been started.
+	Status string
+
+	// Error contains a description of the problem if Status is failed.
+	Error string
+}
+
+// ExecuteQueryMigrate sends the query-migrate command to the instance and
+// returns the status of the last migration.
+func (q *QMP) ExecuteQueryMigrate(ctx context.Context) (MigrationStatus, error) {
+	var status MigrationStatus
+	response, err := q.executeCommandWithResponse(ctx, "query-migrate", nil, nil)
+	if err != nil {
+		return status, err
+	}
+	data, _ := response.(map[string]interface{})
+	status.Status, _ = data["status"].(string)
+	status.Error, _ = data["error-desc"].(string)
+	return status, nil
+}
+
+// ExecuteNBDServerStart starts an NBD server listening on host and port by
+// sending the nbd-server-start command.  The server exports the block
+// devices added with ExecuteNBDServerAdd.
+func (q *QMP) ExecuteNBDServerStart(ctx context.Context, host string, port int) error {
+	args := map[string]interface{}{
+		"addr": map[string]interface{}{
+			"type": "inet",
+			"data": map[string]interface{}{
+				"host": host,
+				"port": strconv.Itoa(port),
+			},
+		},
+	}
+	return q.executeCommand(ctx, "nbd-server-start", args, nil)
+}
+
+// ExecuteNBDServerAdd exports the block device whose drive id is device
+// through the NBD server by sending the nbd-server-add command.  The export
+// is named after device.  If writable is true, NBD clients can write to
+// the block device.
+func (q *QMP) ExecuteNBDServerAdd(ctx context.Context, device string, writable bool) error {
+	args := map[string]interface{}{
+		"device":   device,
+		"writable": writable,
+	}
+	return q.executeCommand(ctx, "nbd-server-add", args, nil)
+}
+
+// ExecuteNBDServerStop stops the NBD server and removes all its exports by
+// sending the nbd-server-stop command.
+func (q *QMP) ExecuteNBDServerStop(ctx context.Context) error {
+	return q.executeCommand(ctx, "nbd-server-stop", nil, nil)
+}
+
+// ExecuteDriveMirror starts mirroring the top image of the block device
+// whose drive id is device to the existing raw image target, e.g., an NBD
+// export such as nbd:192.168.0.2:4445:exportname=virtio0, by sending the
+// drive-mirror command.  The destination must already have access to the
+// backing files of the device.  The mirror runs as a block job which
+// becomes ready, as reported by ExecuteQueryBlockJobs, once both images are
+// in sync.  From then on it keeps them in sync until it is cancelled.
+func (q *QMP) ExecuteDriveMirror(ctx context.Context, device, target string) error {
+	args := map[string]interface{}{
+		"device": device,
+		"target": target,
+		"sync":   "top",
+		"mode":   "existing",
+		"format": "raw",
+	}
+	return q.executeCommand(ctx, "drive-mirror", args, nil)
+}
+
+// ExecuteBlockJobCancel stops the block job running on the block device
+// whose drive id is device by sending the block-job-cancel command.  A
+// ready mirror leaves its destination in sync with the device.
+func (q *QMP) ExecuteBlockJobCancel(ctx context.Context, device string) error {
+	args := map[string]interface{}{
+		"device": device,
+	}
+	return q.executeCommand(ctx, "block-job-cancel", args, nil)
+}
+
+// BlockJobStatus contains the state of a block job, as reported by the
+// query-block-jobs command.
+type BlockJobStatus struct {
+	// Device is the drive id of the block device the job runs on.
+	Device string
+
+	// Type is the type of the job, e.g., mirror.
+	Type string
+
+	// Ready is true once a mirror job keeps its destination in sync.
+	Ready bool
+}
+
+// ExecuteQueryBlockJobs sends the query-block-jobs command to the instance
+// and returns the status of its running block jobs.
+func (q *QMP) ExecuteQueryBlockJobs(ctx context.Context) ([]BlockJobStatus, error) {
+	response, err := q.executeCommandWithResponse(ctx, "query-block-jobs", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := response.([]interface{})
+	jobs := make([]BlockJobStatus, 0, len(data))
+	for _, j := range data {
+		job, ok := j.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var status BlockJobStatus
+		status.Device, _ = job["device"].(string)
+		status.Type, _ = job["type"].(string)
+		status.Ready, _ = job["ready"].(bool)
+		jobs = append(jobs, status)
+	}
+	return jobs, nil
+}
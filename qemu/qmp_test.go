@@ -77,7 +77,7 @@ type qmpTestEvent struct {
 
 type qmpTestResult struct {
 	result string
-	data   map[string]interface{}
+	data   interface{}
 }
 
 type qmpTestCommandBuffer struct {
@@ -134,7 +134,7 @@ func (b *qmpTestCommandBuffer) startEventLoop(wg *sync.WaitGroup) {
 }
 
 func (b *qmpTestCommandBuffer) AddCommmand(name string, args map[string]interface{},
-	result string, data map[string]interface{}) {
+	result string, data interface{}) {
 	b.cmds = append(b.cmds, qmpTestCommand{name, args})
 	if data == nil {
 		data = make(map[string]interface{})
@@ -582,6 +582,229 @@ func TestQMPSystemPowerdown(t *testing.T) {
 	wg.Wait()
 }
 
+// Checks that the system_powerdown command can be sent without waiting
+// for the guest to shut down.
+//
+// We start a QMPLoop, send the system_powerdown command and stop the loop.
+//
+// The system_powerdown command should return even though no SHUTDOWN event
+// has been provisioned.  The QMP loop should exit gracefully.
+func TestQMPSystemPowerdownNoWait(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("system_powerdown", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteSystemPowerdownNoWait(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the migrate command is correctly sent.
+//
+// We start a QMPLoop, send the migrate command and stop the loop.
+//
+// The migrate command should be correctly sent and the QMP loop should
+// exit gracefully.
+func TestQMPMigrate(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("migrate", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteMigrate(context.Background(), "tcp:192.168.0.2:4444",
+		true, true)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the query-migrate command is correctly sent and that its
+// response is parsed.
+//
+// We start a QMPLoop, send the query-migrate command and stop the loop.
+//
+// The query-migrate command should be correctly sent, the status and error
+// description returned by the command should be reported and the QMP loop
+// should exit gracefully.
+func TestQMPQueryMigrate(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("query-migrate", nil, "return",
+		map[string]interface{}{
+			"status":     "failed",
+			"error-desc": "Connection refused",
+		})
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	status, err := q.ExecuteQueryMigrate(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if status.Status != "failed" || status.Error != "Connection refused" {
+		t.Errorf("Unexpected migration status %+v", status)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the nbd-server-start command is correctly sent.
+//
+// We start a QMPLoop, send the nbd-server-start command and stop the loop.
+//
+// The nbd-server-start command should be correctly sent and the QMP loop should exit
+// gracefully.
+func TestQMPNBDServerStart(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("nbd-server-start", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteNBDServerStart(context.Background(), "192.168.0.2", 49153)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the nbd-server-add command is correctly sent.
+//
+// We start a QMPLoop, send the nbd-server-add command and stop the loop.
+//
+// The nbd-server-add command should be correctly sent and the QMP loop should exit
+// gracefully.
+func TestQMPNBDServerAdd(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("nbd-server-add", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteNBDServerAdd(context.Background(), "virtio0", true)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the nbd-server-stop command is correctly sent.
+//
+// We start a QMPLoop, send the nbd-server-stop command and stop the loop.
+//
+// The nbd-server-stop command should be correctly sent and the QMP loop should exit
+// gracefully.
+func TestQMPNBDServerStop(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("nbd-server-stop", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteNBDServerStop(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the drive-mirror command is correctly sent.
+//
+// We start a QMPLoop, send the drive-mirror command and stop the loop.
+//
+// The drive-mirror command should be correctly sent and the QMP loop should exit
+// gracefully.
+func TestQMPDriveMirror(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("drive-mirror", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteDriveMirror(context.Background(), "virtio0",
+		"nbd:192.168.0.2:49153:exportname=virtio0")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the block-job-cancel command is correctly sent.
+//
+// We start a QMPLoop, send the block-job-cancel command and stop the loop.
+//
+// The block-job-cancel command should be correctly sent and the QMP loop should exit
+// gracefully.
+func TestQMPBlockJobCancel(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("block-job-cancel", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteBlockJobCancel(context.Background(), "virtio0")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the query-block-jobs command is correctly sent and that its
+// response is parsed.
+//
+// We start a QMPLoop, send the query-block-jobs command and stop the loop.
+//
+// The query-block-jobs command should be correctly sent, the status of the
+// mirror job returned by the command should be reported and the QMP loop
+// should exit gracefully.
+func TestQMPQueryBlockJobs(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommmand("query-block-jobs", nil, "return",
+		[]interface{}{
+			map[string]interface{}{
+				"device": "virtio0",
+				"type":   "mirror",
+				"ready":  true,
+			},
+		})
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	jobs, err := q.ExecuteQueryBlockJobs(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Device != "virtio0" || jobs[0].Type != "mirror" ||
+		!jobs[0].Ready {
+		t.Errorf("Unexpected block jobs %+v", jobs)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
 // Checks that events can be received and parsed.
 //
 // Two events are provisioned and the QMPLoop is started with an valid eventCh.
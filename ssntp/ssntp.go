@@ -31,6 +31,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/01org/ciao/ssntp/uuid"
 	"github.com/golang/glog"
@@ -42,7 +43,10 @@ type Type uint8
 
 // Command is the SSNTP Command operand.
 // It can be CONNECT, START, STOP, STATS, EVACUATE, DELETE, RESTART,
-// AssignPublicIP, ReleasePublicIP, CONFIGURE, AttachVolume or DetachVolume.
+// AssignPublicIP, ReleasePublicIP, CONFIGURE, AttachVolume, DetachVolume,
+// StartBatch, ApplySecurityRules, ClearSecurityRules, REBOOT, PAUSE,
+// UNPAUSE, SUSPEND, RESUME, GetConsoleLog, CreateImage, MIGRATE,
+// MigrateIncoming or RESTORE.
 type Command uint8
 
 // Status is the SSNTP Status operand.
@@ -62,7 +66,9 @@ type Error uint8
 // Event is the SSNTP Event operand.
 // It can be TenantAdded, TenantRemoval, InstanceDeleted,
 // ConcentratorInstanceAdded, PublicIPAssigned, TraceReport,
-// NodeConnected or NodeDisconnected
+// NodeConnected, NodeDisconnected, InstancesEvacuated, NodeEvacuation,
+// ConsoleLog, ImageCreated, MigrationPrepare, MigrationReady or
+// InstanceMigrated
 type Event uint8
 
 const (
@@ -253,6 +259,192 @@ const (
 	//	|       |       | (0x0) |  (0xb)  |                 |                         |
 	//	+-----------------------------------------------------------------------------+
 	DetachVolume
+
+	// StartBatch is a command sent by the Controller to the Scheduler for
+	// scheduling several new instances at once. The Scheduler places the whole
+	// batch and forwards a START command to the selected agent for each
+	// instance.
+	//
+	// The StartBatch command payload includes a list of START workload
+	// descriptions and an optional gang flag, asking for all or none of the
+	// instances to be started.
+	//
+	//                                       SSNTP StartBatch Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xc)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	StartBatch
+
+	// ApplySecurityRules is a command sent by the Controller to set the
+	// security group rules of a set of tenant instances. It is sent to the
+	// Scheduler and must be forwarded to the right CNCI, which only lets
+	// ingress traffic matching those rules through to the instances.
+	//
+	// The ApplySecurityRules YAML payload schema is made of the CNCI and
+	// tenant UUIDs, and the full list of rules of each instance together
+	// with the instance private IP.
+	//
+	//                                       SSNTP ApplySecurityRules Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xd)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	ApplySecurityRules
+
+	// ClearSecurityRules is a command sent by the Controller to remove all
+	// security group rules of a set of tenant instances. It is sent to the
+	// Scheduler and must be forwarded to the right CNCI.
+	//
+	// The ClearSecurityRules YAML payload uses the same schema as the
+	// ApplySecurityRules one, without any rules.
+	//
+	//                                       SSNTP ClearSecurityRules Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xe)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	ClearSecurityRules
+
+	// REBOOT is a command sent to ciao-launcher for rebooting a running
+	// instance. A soft reboot asks the guest to shut down cleanly before
+	// restarting it, while a hard reboot powers it off immediately.
+	//
+	// The REBOOT command payload includes an instance UUID, the UUID of the
+	// agent running it and the reboot type.
+	//
+	//                                       SSNTP REBOOT Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xf)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	REBOOT
+
+	// PAUSE is a command sent to ciao-launcher for freezing the execution of
+	// a running instance. The instance keeps its resources and can be
+	// resumed through the UNPAUSE command.
+	//
+	// The PAUSE command payload includes an instance UUID and the UUID of
+	// the agent running it.
+	//
+	//                                       SSNTP PAUSE Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x10) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	PAUSE
+
+	// UNPAUSE is a command sent to ciao-launcher for resuming the execution
+	// of a paused instance.
+	//
+	// The UNPAUSE command payload uses the same schema as the PAUSE one.
+	//
+	//                                       SSNTP UNPAUSE Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x11) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	UNPAUSE
+
+	// SUSPEND is a command sent to ciao-launcher for suspending a running
+	// instance. The instance can be brought back through the RESUME command.
+	//
+	// The SUSPEND command payload uses the same schema as the PAUSE one.
+	//
+	//                                       SSNTP SUSPEND Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x12) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	SUSPEND
+
+	// RESUME is a command sent to ciao-launcher for resuming a suspended
+	// instance.
+	//
+	// The RESUME command payload uses the same schema as the PAUSE one.
+	//
+	//                                       SSNTP RESUME Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x13) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	RESUME
+
+	// GetConsoleLog is a command sent by the Controller to retrieve the last
+	// lines of the console log of an instance. It is sent to the Scheduler,
+	// which forwards it to the CN Agent running the instance. The agent
+	// replies with a ConsoleLog event.
+	//
+	// The GetConsoleLog command payload includes an instance UUID, the UUID
+	// of the agent running it, a request identifier and the number of lines
+	// to retrieve.
+	//
+	//                                       SSNTP GetConsoleLog Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x14) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	GetConsoleLog
+
+	// CreateImage is a command sent by the Controller to snapshot the
+	// rootfs of an instance into a new image. It is sent to the Scheduler,
+	// which forwards it to the CN Agent running the instance. The agent
+	// replies with an ImageCreated event once the image is stored.
+	//
+	// The CreateImage command payload includes an instance UUID, the UUID
+	// of the agent running it and the UUID of the image to create.
+	//
+	//                                       SSNTP CreateImage Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x15) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	CreateImage
+
+	// MIGRATE is a command sent by the Controller to live migrate a running
+	// instance to another node. The Scheduler picks the target node, unless
+	// the Controller chose one, and forwards the command to the CN Agent
+	// running the instance. That agent then describes the instance to the
+	// Scheduler with a MigrationPrepare event.
+	//
+	// The MIGRATE command payload includes the instance UUID, the UUIDs of
+	// the source and target agents and the resources used by the instance.
+	//
+	//                                       SSNTP MIGRATE Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x16) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	MIGRATE
+
+	// MigrateIncoming is a command sent by the Scheduler to the target node
+	// of a live migration. The CN Agent prepares the instance networking
+	// and volumes and starts an instance waiting for the migrated state,
+	// before replying with a MigrationReady event.
+	//
+	// The MigrateIncoming command payload contains the UUIDs of the source
+	// and target agents and the full description of the instance.
+	//
+	//                                       SSNTP MigrateIncoming Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x17) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	MigrateIncoming
+
+	// RESTORE is a command sent by the Controller to put a node left in
+	// maintenance mode by an EVACUATE command back in service. The Scheduler
+	// places instances on the node again and forwards the command to its
+	// CN Agent, which leaves maintenance mode.
+	//
+	// The RESTORE command payload contains the UUID of the CN Agent.
+	//
+	//                                       SSNTP RESTORE Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x18) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	RESTORE
 )
 
 const (
@@ -438,6 +630,116 @@ const (
 	//	|       |       | (0x3) |  (0x7)  |                 |                        |
 	//	+----------------------------------------------------------------------------+
 	NodeDisconnected
+
+	// InstancesEvacuated events are sent by an Agent to the Scheduler once it has
+	// stopped all of its instances after receiving an EVACUATE command.
+	// The InstancesEvacuated event payload describes each stopped instance and
+	// whether it can be restarted on another node.
+	//
+	//					 SSNTP InstancesEvacuated Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0x8)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	InstancesEvacuated
+
+	// NodeEvacuation events are sent by the Scheduler to notify the Controllers about
+	// the progress of a node evacuation.
+	// The NodeEvacuation event payload contains the evacuated node UUID, the evacuation
+	// status, the node next state and, once complete, the outcome for each instance.
+	//
+	//					 SSNTP NodeEvacuation Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0x9)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	NodeEvacuation
+
+	// ConsoleLog events are sent by CN Agents in reply to a GetConsoleLog
+	// command, and forwarded to the Controllers by the Scheduler.
+	// The ConsoleLog event payload contains the instance UUID, the request
+	// identifier of the GetConsoleLog command and either the requested
+	// console output or an error.
+	//
+	//					 SSNTP ConsoleLog Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xa)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	ConsoleLog
+
+	// ImageCreated events are sent by CN Agents in reply to a CreateImage
+	// command, and forwarded to the Controllers by the Scheduler.
+	// The ImageCreated event payload contains the instance and image UUIDs,
+	// and either the size of the new image or an error.
+	//
+	//					 SSNTP ImageCreated Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xb)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	ImageCreated
+
+	// MigrationPrepare events are sent by the CN Agent running an instance
+	// in reply to a MIGRATE command. The Scheduler turns them into a
+	// MigrateIncoming command for the target node.
+	// The MigrationPrepare event payload has the same contents as the
+	// MigrateIncoming command payload.
+	//
+	//					 SSNTP MigrationPrepare Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xc)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	MigrationPrepare
+
+	// MigrationReady events are sent by the target node of a live migration
+	// once it waits for the migrated instance, and forwarded to the source
+	// node by the Scheduler.
+	// The MigrationReady event payload contains the instance UUID, the
+	// source and target agent UUIDs and either the URI the migration is to
+	// be sent to or an error.
+	//
+	//					 SSNTP MigrationReady Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xd)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	MigrationReady
+
+	// InstanceMigrated events report the outcome of a live migration. They
+	// are sent by the source node, or by the Scheduler when no target node
+	// is found, and forwarded to the Controllers and to the target node.
+	// The InstanceMigrated event payload contains the instance UUID, the
+	// source and target agent UUIDs and an error if the migration failed.
+	//
+	//					 SSNTP InstanceMigrated Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xe)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	InstanceMigrated
+
+	// ControllerRole events are sent by the Scheduler to a Controller whose
+	// role changes, i.e. when a backup Controller is promoted to master or
+	// when a failover demotes the master Controller.
+	// The ControllerRole event payload contains the new role of the
+	// Controller.
+	//
+	//					 SSNTP ControllerRole Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xf)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	ControllerRole
 )
 
 // SSNTP clients and servers can have one or several roles and are expected to declare their
@@ -580,6 +882,32 @@ func (command Command) String() string {
 		return "Attach storage volume"
 	case DetachVolume:
 		return "Detach storage volume"
+	case StartBatch:
+		return "Start instance batch"
+	case ApplySecurityRules:
+		return "Apply security rules"
+	case ClearSecurityRules:
+		return "Clear security rules"
+	case REBOOT:
+		return "REBOOT"
+	case PAUSE:
+		return "PAUSE"
+	case UNPAUSE:
+		return "UNPAUSE"
+	case SUSPEND:
+		return "SUSPEND"
+	case RESUME:
+		return "RESUME"
+	case GetConsoleLog:
+		return "Get console log"
+	case CreateImage:
+		return "Create image"
+	case MIGRATE:
+		return "MIGRATE"
+	case MigrateIncoming:
+		return "Migrate incoming"
+	case RESTORE:
+		return "RESTORE"
 	}
 
 	return ""
@@ -620,6 +948,22 @@ func (status Event) String() string {
 		return "Node Connected"
 	case NodeDisconnected:
 		return "Node Disconnected"
+	case InstancesEvacuated:
+		return "Instances Evacuated"
+	case NodeEvacuation:
+		return "Node Evacuation"
+	case ConsoleLog:
+		return "Console Log"
+	case ImageCreated:
+		return "Image Created"
+	case MigrationPrepare:
+		return "Migration Prepare"
+	case MigrationReady:
+		return "Migration Ready"
+	case InstanceMigrated:
+		return "Instance Migrated"
+	case ControllerRole:
+		return "Controller Role"
 	}
 
 	return ""
@@ -981,6 +1325,80 @@ func prepareTLS(caPEM, certPEM []byte, server bool) *tls.Config {
 	}
 }
 
+// PeerTLSConfig returns a TLS configuration for direct connections between
+// SSNTP peers, for streams that do not fit in SSNTP frames.  Both ends
+// authenticate with their SSNTP certificates.  Host names are not verified,
+// the peer certificate must instead be signed by the SSNTP CA and hold the
+// peerRole role.
+func (config *Config) PeerTLSConfig(server bool, peerRole Role) (*tls.Config, error) {
+	config.setCerts()
+
+	caPEM, err := ioutil.ReadFile(config.CAcert)
+	if err != nil {
+		return nil, fmt.Errorf("Load CA certificate: %v", err)
+	}
+
+	certPEM, err := ioutil.ReadFile(config.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("Load Certificate: %v", err)
+	}
+
+	tlsConfig := prepareTLS(caPEM, certPEM, server)
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("Invalid SSNTP certificates")
+	}
+
+	roots := tlsConfig.RootCAs
+	if !server {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		now := time.Now()
+		if tlsConfig.Time != nil {
+			now = tlsConfig.Time()
+		}
+		return verifyPeerCertificate(rawCerts, roots, peerRole, now)
+	}
+
+	return tlsConfig, nil
+}
+
+func verifyPeerCertificate(rawCerts [][]byte, roots *x509.CertPool, role Role, now time.Time) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("Missing peer certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			intermediates.AddCert(cert)
+		}
+		certs[i] = cert
+	}
+
+	vOpts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	_, err := certs[0].Verify(vOpts)
+	if err != nil {
+		return err
+	}
+
+	if GetRoleFromOIDs(certs[0].UnknownExtKeyUsage)&role != role {
+		return fmt.Errorf("Peer certificate does not have the %s role", role.String())
+	}
+
+	return nil
+}
+
 var roleOID = []struct {
 	role Role
 	oid  asn1.ObjectIdentifier
@@ -18,6 +18,7 @@ package ssntp_test
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/asn1"
 	"flag"
 	"fmt"
@@ -830,6 +831,77 @@ func TestMajor(t *testing.T) {
 	server.ssntp.Stop()
 }
 
+func testPeerTLSConfig(t *testing.T, serverPeer, clientRole Role) error {
+	serverConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	clientConfig, err := buildTestConfig(clientRole)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	serverTLS, err := serverConfig.PeerTLSConfig(true, serverPeer)
+	if err != nil {
+		t.Fatalf("Could not build server TLS config: %v", err)
+	}
+
+	clientTLS, err := clientConfig.PeerTLSConfig(false, AGENT)
+	if err != nil {
+		t.Fatalf("Could not build client TLS config: %v", err)
+	}
+
+	// The test certificates may have expired, so we pin the clock
+	// within their validity period.
+	certTime := func() time.Time {
+		return time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	}
+	serverTLS.Time = certTime
+	clientTLS.Time = certTime
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	errCh := make(chan error)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			err = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+		errCh <- err
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientTLS)
+	if err == nil {
+		_ = conn.Close()
+	}
+
+	return <-errCh
+}
+
+// Test direct TLS connections between SSNTP peers
+//
+// Test that an AGENT accepts a direct TLS connection from a Controller
+// and that it rejects a connection from a peer without the expected role.
+//
+// Test is expected to pass.
+func TestPeerTLSConfig(t *testing.T) {
+	err := testPeerTLSConfig(t, Controller, Controller)
+	if err != nil {
+		t.Fatalf("Controller connection failed: %v", err)
+	}
+
+	err = testPeerTLSConfig(t, Controller, SCHEDULER)
+	if err == nil {
+		t.Fatalf("Scheduler connection should have been rejected")
+	}
+}
+
 /* Mark D. Ryan FTW ! */
 func _getCert(CACertFileName, certFileName string, CACert, certString string) (string, string, error) {
 	caPath := path.Join(tempCertPath, CACertFileName)
@@ -2483,6 +2555,19 @@ func TestCommandStringer(t *testing.T) {
 		{CONFIGURE, "CONFIGURE"},
 		{AttachVolume, "Attach storage volume"},
 		{DetachVolume, "Detach storage volume"},
+		{StartBatch, "Start instance batch"},
+		{ApplySecurityRules, "Apply security rules"},
+		{ClearSecurityRules, "Clear security rules"},
+		{REBOOT, "REBOOT"},
+		{PAUSE, "PAUSE"},
+		{UNPAUSE, "UNPAUSE"},
+		{SUSPEND, "SUSPEND"},
+		{RESUME, "RESUME"},
+		{GetConsoleLog, "Get console log"},
+		{CreateImage, "Create image"},
+		{MIGRATE, "MIGRATE"},
+		{MigrateIncoming, "Migrate incoming"},
+		{RESTORE, "RESTORE"},
 	}
 
 	for _, test := range stringTests {
@@ -2526,6 +2611,14 @@ func TestEventStringer(t *testing.T) {
 		{TraceReport, "Trace Report"},
 		{NodeConnected, "Node Connected"},
 		{NodeDisconnected, "Node Disconnected"},
+		{InstancesEvacuated, "Instances Evacuated"},
+		{NodeEvacuation, "Node Evacuation"},
+		{ConsoleLog, "Console Log"},
+		{ImageCreated, "Image Created"},
+		{MigrationPrepare, "Migration Prepare"},
+		{MigrationReady, "Migration Ready"},
+		{InstanceMigrated, "Instance Migrated"},
+		{ControllerRole, "Controller Role"},
 	}
 
 	for _, test := range stringTests {
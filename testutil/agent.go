@@ -52,6 +52,12 @@ type SsntpTestClient struct {
 	traces                 []*ssntp.Frame
 	tracesLock             *sync.Mutex
 
+	// the memory statistics of the last READY status, also reported in
+	// the STATS commands once set.
+	memTotalMB int
+	memAvailMB int
+	memLock    sync.Mutex
+
 	CmdChans        map[ssntp.Command]chan Result
 	CmdChansLock    *sync.Mutex
 	EventChans      map[ssntp.Event]chan Result
@@ -455,6 +461,240 @@ func (client *SsntpTestClient) handleDelete(payload []byte) Result {
 	return result
 }
 
+func getInstanceActionResult(command ssntp.Command, payload []byte) Result {
+	var result Result
+	var actionCmd payloads.StopCmd
+
+	switch command {
+	case ssntp.REBOOT:
+		var cmd payloads.Reboot
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		actionCmd.InstanceUUID = cmd.Reboot.InstanceUUID
+		actionCmd.WorkloadAgentUUID = cmd.Reboot.WorkloadAgentUUID
+	case ssntp.PAUSE:
+		var cmd payloads.Pause
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		actionCmd = cmd.Pause
+	case ssntp.UNPAUSE:
+		var cmd payloads.Unpause
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		actionCmd = cmd.Unpause
+	case ssntp.SUSPEND:
+		var cmd payloads.Suspend
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		actionCmd = cmd.Suspend
+	case ssntp.RESUME:
+		var cmd payloads.Resume
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		actionCmd = cmd.Resume
+	}
+
+	result.InstanceUUID = actionCmd.InstanceUUID
+	result.NodeUUID = actionCmd.WorkloadAgentUUID
+
+	return result
+}
+
+func (client *SsntpTestClient) handleInstanceAction(command ssntp.Command, payload []byte) Result {
+	result := getInstanceActionResult(command, payload)
+	if result.Err != nil {
+		return result
+	}
+
+	state := payloads.Running
+	switch command {
+	case ssntp.PAUSE, ssntp.SUSPEND:
+		state = payloads.Paused
+	}
+
+	client.instancesLock.Lock()
+	defer client.instancesLock.Unlock()
+	for i := range client.instances {
+		istat := client.instances[i]
+		if istat.InstanceUUID == result.InstanceUUID {
+			client.instances[i].State = state
+		}
+	}
+
+	return result
+}
+
+func (client *SsntpTestClient) handleGetConsoleLog(payload []byte) Result {
+	var result Result
+	var cmd payloads.GetConsoleLog
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.InstanceUUID = cmd.GetConsoleLog.InstanceUUID
+
+	event := payloads.EventConsoleLog{
+		ConsoleLog: payloads.ConsoleLogEvent{
+			InstanceUUID: cmd.GetConsoleLog.InstanceUUID,
+			RequestID:    cmd.GetConsoleLog.RequestID,
+			Error:        "Instance not found",
+		},
+	}
+
+	client.instancesLock.Lock()
+	for _, istat := range client.instances {
+		if istat.InstanceUUID == cmd.GetConsoleLog.InstanceUUID {
+			event.ConsoleLog.Output = ConsoleOutput
+			event.ConsoleLog.Error = ""
+		}
+	}
+	client.instancesLock.Unlock()
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	_, result.Err = client.Ssntp.SendEvent(ssntp.ConsoleLog, y)
+
+	return result
+}
+
+func (client *SsntpTestClient) handleCreateImage(payload []byte) Result {
+	var result Result
+	var cmd payloads.CreateImage
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.InstanceUUID = cmd.CreateImage.InstanceUUID
+
+	event := payloads.EventImageCreated{
+		ImageCreated: payloads.ImageCreatedEvent{
+			InstanceUUID: cmd.CreateImage.InstanceUUID,
+			ImageUUID:    cmd.CreateImage.ImageUUID,
+			Error:        "Instance not found",
+		},
+	}
+
+	client.instancesLock.Lock()
+	for _, istat := range client.instances {
+		if istat.InstanceUUID == cmd.CreateImage.InstanceUUID {
+			event.ImageCreated.Size = ImageSize
+			event.ImageCreated.Error = ""
+		}
+	}
+	client.instancesLock.Unlock()
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	_, result.Err = client.Ssntp.SendEvent(ssntp.ImageCreated, y)
+
+	return result
+}
+
+func (client *SsntpTestClient) handleMigrate(payload []byte) Result {
+	var result Result
+	var cmd payloads.Migrate
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.InstanceUUID = cmd.Migrate.InstanceUUID
+
+	// Test agents do not involve the target node, the instance is
+	// reported as migrated straight away.
+	event := payloads.EventInstanceMigrated{
+		InstanceMigrated: payloads.InstanceMigratedEvent{
+			InstanceUUID:    cmd.Migrate.InstanceUUID,
+			SourceAgentUUID: client.UUID,
+			TargetAgentUUID: cmd.Migrate.TargetAgentUUID,
+			Error:           "Instance not found",
+		},
+	}
+
+	client.instancesLock.Lock()
+	for _, istat := range client.instances {
+		if istat.InstanceUUID == cmd.Migrate.InstanceUUID {
+			event.InstanceMigrated.Error = ""
+		}
+	}
+	client.instancesLock.Unlock()
+
+	if cmd.Migrate.TargetAgentUUID == "" {
+		event.InstanceMigrated.Error = "No target node"
+	}
+
+	y, err := yaml.Marshal(event)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	_, result.Err = client.Ssntp.SendEvent(ssntp.InstanceMigrated, y)
+
+	return result
+}
+
+func getPublicIPResult(command ssntp.Command, payload []byte) Result {
+	var result Result
+	var ipCmd payloads.PublicIPCommand
+
+	switch command {
+	case ssntp.AssignPublicIP:
+		var cmd payloads.CommandAssignPublicIP
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		ipCmd = cmd.AssignIP
+	case ssntp.ReleasePublicIP:
+		var cmd payloads.CommandReleasePublicIP
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		ipCmd = cmd.ReleaseIP
+	}
+
+	result.InstanceUUID = ipCmd.InstanceUUID
+	result.TenantUUID = ipCmd.TenantUUID
+
+	return result
+}
+
+func getSecurityRulesResult(command ssntp.Command, payload []byte) Result {
+	var result Result
+	var rulesCmd payloads.SecurityRulesCommand
+
+	switch command {
+	case ssntp.ApplySecurityRules:
+		var cmd payloads.CommandApplySecurityRules
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		rulesCmd = cmd.Apply
+	case ssntp.ClearSecurityRules:
+		var cmd payloads.CommandClearSecurityRules
+
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		rulesCmd = cmd.Clear
+	}
+
+	if len(rulesCmd.Instances) > 0 {
+		result.InstanceUUID = rulesCmd.Instances[0].InstanceUUID
+	}
+	result.TenantUUID = rulesCmd.TenantUUID
+
+	return result
+}
+
 func (client *SsntpTestClient) handleAttachVolume(payload []byte) Result {
 	var result Result
 	var cmd payloads.AttachVolume
@@ -522,8 +762,6 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 	case ssntp.CONNECT:
 	case ssntp.STATS:
 	case ssntp.EVACUATE:
-	case ssntp.AssignPublicIP:
-	case ssntp.ReleasePublicIP:
 	case ssntp.CONFIGURE:
 	*/
 	case ssntp.START:
@@ -544,6 +782,36 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 	case ssntp.DetachVolume:
 		result = client.handleDetachVolume(payload)
 
+	case ssntp.AssignPublicIP:
+		fallthrough
+	case ssntp.ReleasePublicIP:
+		result = getPublicIPResult(command, payload)
+
+	case ssntp.ApplySecurityRules:
+		fallthrough
+	case ssntp.ClearSecurityRules:
+		result = getSecurityRulesResult(command, payload)
+
+	case ssntp.REBOOT:
+		fallthrough
+	case ssntp.PAUSE:
+		fallthrough
+	case ssntp.UNPAUSE:
+		fallthrough
+	case ssntp.SUSPEND:
+		fallthrough
+	case ssntp.RESUME:
+		result = client.handleInstanceAction(command, payload)
+
+	case ssntp.GetConsoleLog:
+		result = client.handleGetConsoleLog(payload)
+
+	case ssntp.CreateImage:
+		result = client.handleCreateImage(payload)
+
+	case ssntp.MIGRATE:
+		result = client.handleMigrate(payload)
+
 	default:
 		fmt.Fprintf(os.Stderr, "client %s unhandled command %s\n", client.Role.String(), command.String())
 	}
@@ -593,6 +861,13 @@ func (client *SsntpTestClient) SendStatsCmd() {
 
 	payload := StatsPayload(client.UUID, client.Name, client.instances, nil)
 
+	client.memLock.Lock()
+	if client.memTotalMB > 0 {
+		payload.MemTotalMB = client.memTotalMB
+		payload.MemAvailableMB = client.memAvailMB
+	}
+	client.memLock.Unlock()
+
 	y, err := yaml.Marshal(payload)
 	if err != nil {
 		result.Err = err
@@ -613,6 +888,11 @@ func (client *SsntpTestClient) SendStatus(memTotal int, memAvail int) {
 
 	payload := ReadyPayload(client.UUID, memTotal, memAvail)
 
+	client.memLock.Lock()
+	client.memTotalMB = memTotal
+	client.memAvailMB = memAvail
+	client.memLock.Unlock()
+
 	y, err := yaml.Marshal(payload)
 	if err != nil {
 		result.Err = err
@@ -309,6 +309,37 @@ func (ctl *SsntpTestController) EventNotify(event ssntp.Event, frame *ssntp.Fram
 		if err != nil {
 			result.Err = err
 		}
+	case ssntp.ConsoleLog:
+		var consoleEvent payloads.EventConsoleLog
+
+		err := yaml.Unmarshal(frame.Payload, &consoleEvent)
+		if err != nil {
+			result.Err = err
+		}
+		result.InstanceUUID = consoleEvent.ConsoleLog.InstanceUUID
+	case ssntp.ImageCreated:
+		var imageEvent payloads.EventImageCreated
+
+		err := yaml.Unmarshal(frame.Payload, &imageEvent)
+		if err != nil {
+			result.Err = err
+		}
+		result.InstanceUUID = imageEvent.ImageCreated.InstanceUUID
+	case ssntp.InstanceMigrated:
+		var migratedEvent payloads.EventInstanceMigrated
+
+		err := yaml.Unmarshal(frame.Payload, &migratedEvent)
+		if err != nil {
+			result.Err = err
+		}
+		result.InstanceUUID = migratedEvent.InstanceMigrated.InstanceUUID
+	case ssntp.ControllerRole:
+		var roleEvent payloads.ControllerRole
+
+		err := yaml.Unmarshal(frame.Payload, &roleEvent)
+		if err != nil {
+			result.Err = err
+		}
 	case ssntp.TraceReport:
 		var traceEvent payloads.Trace
 
@@ -108,9 +108,25 @@ const NetAgentUUID = "6be56328-92e2-4ecd-b426-8fe529c04e0c"
 // AgentUUID is a node UUID for coordinated stop/restart/delete tests
 const AgentUUID = "4cb19522-1e18-439a-883a-f9b2a3a95f5e"
 
+// TargetAgentUUID is the UUID of the node instances are migrated to in
+// live migration tests
+const TargetAgentUUID = "9c0b4f7e-2d5a-4a8e-8f6b-51d3e2a7c640"
+
+// ConsoleOutput is the console log test agents reply with
+const ConsoleOutput = "login:\n"
+
+// ImageSize is the size of the images created by test agents
+const ImageSize = 1073741824
+
+// RequestID is a test request identifier
+const RequestID = "a5e23ab3-7fb2-4c6e-8b4a-3f9a1d6c2e07"
+
 // VolumeUUID is a node UUID for storage tests
 const VolumeUUID = "67d86208-b46c-4465-9018-e14187d4010"
 
+// BootVolumeUUID is the UUID of a bootable volume for storage tests
+const BootVolumeUUID = "67d86208-b46c-4465-9018-e14187d4011"
+
 //////////////////////////////////////////////////////////////////////////////
 
 // StartYaml is a sample workload START ssntp.Command payload for test usage
@@ -236,6 +252,39 @@ const RestartYaml = `restart:
     public_ip: false
 `
 
+// ResizeRestartYaml is a sample RESTART ssntp.Command payload resizing
+// an instance, for test cases
+const ResizeRestartYaml = `restart:
+  tenant_uuid: ""
+  instance_uuid: ` + InstanceUUID + `
+  image_uuid: ""
+  workload_agent_uuid: ` + AgentUUID + `
+  fw_type: ""
+  persistence: ""
+  requested_resources:
+  - type: vcpus
+    value: 4
+    mandatory: true
+  - type: mem_mb
+    value: 8192
+    mandatory: true
+  - type: disk_mb
+    value: 20000
+    mandatory: true
+  estimated_resources: []
+  networking:
+    vnic_mac: ""
+    vnic_uuid: ""
+    concentrator_uuid: ""
+    concentrator_ip: ""
+    subnet: ""
+    subnet_key: ""
+    subnet_uuid: ""
+    private_ip: ""
+    public_ip: false
+  resize: true
+`
+
 // PartialRestartYaml is a sample minimal workload RESTART ssntp.Command payload for test cases
 const PartialRestartYaml = `restart:
   instance_uuid: ` + InstanceUUID + `
@@ -270,11 +319,106 @@ const DeleteYaml = `delete:
   workload_agent_uuid: ` + AgentUUID + `
 `
 
+// RebootYaml is a sample workload REBOOT ssntp.Command payload for test cases
+const RebootYaml = `reboot:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  type: hard
+`
+
+// PauseYaml is a sample workload PAUSE ssntp.Command payload for test cases
+const PauseYaml = `pause:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// UnpauseYaml is a sample workload UNPAUSE ssntp.Command payload for test cases
+const UnpauseYaml = `unpause:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// SuspendYaml is a sample workload SUSPEND ssntp.Command payload for test cases
+const SuspendYaml = `suspend:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// ResumeYaml is a sample workload RESUME ssntp.Command payload for test cases
+const ResumeYaml = `resume:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// GetConsoleLogYaml is a sample GetConsoleLog ssntp.Command payload for test cases
+const GetConsoleLogYaml = `get_console_log:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  request_id: ` + RequestID + `
+  lines: 50
+`
+
+// ConsoleLogYaml is a sample ConsoleLog ssntp.Event payload for test cases
+const ConsoleLogYaml = `console_log:
+  instance_uuid: ` + InstanceUUID + `
+  request_id: ` + RequestID + `
+  output: |
+    login:
+`
+
+// CreateImageYaml is a sample CreateImage ssntp.Command payload for test cases
+const CreateImageYaml = `create_image:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  image_uuid: ` + ImageUUID + `
+`
+
+// ImageCreatedYaml is a sample ImageCreated ssntp.Event payload for test cases
+const ImageCreatedYaml = `image_created:
+  instance_uuid: ` + InstanceUUID + `
+  image_uuid: ` + ImageUUID + `
+  size: 1073741824
+`
+
+// MigrateYaml is a sample MIGRATE ssntp.Command payload for test cases
+const MigrateYaml = `migrate:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  requested_resources:
+  - type: vcpus
+    value: 2
+    mandatory: true
+  - type: mem_mb
+    value: 1024
+    mandatory: true
+`
+
+// MigrationReadyYaml is a sample MigrationReady ssntp.Event payload for test cases
+const MigrationReadyYaml = `migration_ready:
+  instance_uuid: ` + InstanceUUID + `
+  source_agent_uuid: ` + AgentUUID + `
+  target_agent_uuid: ` + TargetAgentUUID + `
+  uri: tcp:192.168.0.2:49152
+  disk_uri: nbd:192.168.0.2:49153:exportname=virtio0
+`
+
+// InstanceMigratedYaml is a sample InstanceMigrated ssntp.Event payload for test cases
+const InstanceMigratedYaml = `instance_migrated:
+  instance_uuid: ` + InstanceUUID + `
+  source_agent_uuid: ` + AgentUUID + `
+  target_agent_uuid: ` + TargetAgentUUID + `
+`
+
 // EvacuateYaml is a sample node EVACUATE ssntp.Command payload for test cases
 const EvacuateYaml = `evacuate:
   workload_agent_uuid: ` + AgentUUID + `
 `
 
+// RestoreYaml is a sample node RESTORE ssntp.Command payload for test cases
+const RestoreYaml = `restore:
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
 // CNCIAddedYaml is a sample ConcentratorInstanceAdded ssntp.Event payload for test cases
 const CNCIAddedYaml = `concentrator_instance_added:
   instance_uuid: ` + CNCIUUID + `
@@ -303,6 +447,29 @@ const ReleaseIPYaml = `release_public_ip:
   vnic_mac: ` + VNICMAC + `
 `
 
+// ApplySecurityRulesYaml is a sample ApplySecurityRules ssntp.Command payload for test cases
+const ApplySecurityRulesYaml = `apply_security_rules:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  instances:
+  - instance_uuid: ` + InstanceUUID + `
+    private_ip: ` + InstancePrivateIP + `
+    rules:
+    - protocol: tcp
+      from_port: 22
+      to_port: 22
+      cidr: 0.0.0.0/0
+`
+
+// ClearSecurityRulesYaml is a sample ClearSecurityRules ssntp.Command payload for test cases
+const ClearSecurityRulesYaml = `clear_security_rules:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  instances:
+  - instance_uuid: ` + InstanceUUID + `
+    private_ip: ` + InstancePrivateIP + `
+`
+
 // AssignedIPYaml is a sample PublicIPAssigned ssntp.Event payload for test cases
 const AssignedIPYaml = `public_ip_assigned:
   concentrator_uuid: ` + CNCIUUID + `
@@ -578,3 +745,8 @@ const DetachVolumeFailureYaml = `instance_uuid: ` + InstanceUUID + `
 volume_uuid: ` + VolumeUUID + `
 reason: detach_failure
 `
+
+// ControllerRoleYaml is a sample ControllerRole ssntp.Event payload for test cases
+const ControllerRoleYaml = `controller_role:
+  role: master
+`
@@ -367,13 +367,16 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 	switch command {
 	/*TODO:
 	case CONNECT:
-	case AssignPublicIP:
-	case ReleasePublicIP:
 	case CONFIGURE:
 	*/
 	case ssntp.START:
 		getStartResults(payload, &result)
 
+	case ssntp.StartBatch:
+		var batchCmd payloads.StartBatch
+
+		result.Err = yaml.Unmarshal(payload, &batchCmd)
+
 	case ssntp.DELETE:
 		var delCmd payloads.Delete
 
@@ -413,6 +416,15 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 			result.NodeUUID = evacCmd.Evacuate.WorkloadAgentUUID
 		}
 
+	case ssntp.RESTORE:
+		var restoreCmd payloads.Restore
+
+		err := yaml.Unmarshal(payload, &restoreCmd)
+		result.Err = err
+		if err == nil {
+			result.NodeUUID = restoreCmd.Restore.WorkloadAgentUUID
+		}
+
 	case ssntp.STATS:
 		var statsCmd payloads.Stat
 
@@ -425,6 +437,60 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 	case ssntp.DetachVolume:
 		getDetachVolumeResult(payload, &result)
 
+	case ssntp.AssignPublicIP:
+		fallthrough
+	case ssntp.ReleasePublicIP:
+		result = getPublicIPResult(command, payload)
+
+	case ssntp.ApplySecurityRules:
+		fallthrough
+	case ssntp.ClearSecurityRules:
+		result = getSecurityRulesResult(command, payload)
+
+	case ssntp.REBOOT:
+		fallthrough
+	case ssntp.PAUSE:
+		fallthrough
+	case ssntp.UNPAUSE:
+		fallthrough
+	case ssntp.SUSPEND:
+		fallthrough
+	case ssntp.RESUME:
+		result = getInstanceActionResult(command, payload)
+		if result.Err == nil {
+			server.Ssntp.SendCommand(result.NodeUUID, command, frame.Payload)
+		}
+
+	case ssntp.GetConsoleLog:
+		var consoleCmd payloads.GetConsoleLog
+
+		err := yaml.Unmarshal(payload, &consoleCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = consoleCmd.GetConsoleLog.InstanceUUID
+			server.Ssntp.SendCommand(consoleCmd.GetConsoleLog.WorkloadAgentUUID, command, frame.Payload)
+		}
+
+	case ssntp.CreateImage:
+		var imageCmd payloads.CreateImage
+
+		err := yaml.Unmarshal(payload, &imageCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = imageCmd.CreateImage.InstanceUUID
+			server.Ssntp.SendCommand(imageCmd.CreateImage.WorkloadAgentUUID, command, frame.Payload)
+		}
+
+	case ssntp.MIGRATE:
+		var migrateCmd payloads.Migrate
+
+		err := yaml.Unmarshal(payload, &migrateCmd)
+		result.Err = err
+		if err == nil {
+			result.InstanceUUID = migrateCmd.Migrate.InstanceUUID
+			server.Ssntp.SendCommand(migrateCmd.Migrate.WorkloadAgentUUID, command, frame.Payload)
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "server unhandled command %s\n", command.String())
 	}
@@ -453,6 +519,21 @@ func (server *SsntpTestServer) EventNotify(uuid string, event ssntp.Event, frame
 		var deleteEvent payloads.EventInstanceDeleted
 
 		result.Err = yaml.Unmarshal(payload, &deleteEvent)
+	case ssntp.ConsoleLog:
+		var consoleEvent payloads.EventConsoleLog
+
+		result.Err = yaml.Unmarshal(payload, &consoleEvent)
+		result.InstanceUUID = consoleEvent.ConsoleLog.InstanceUUID
+	case ssntp.ImageCreated:
+		var imageEvent payloads.EventImageCreated
+
+		result.Err = yaml.Unmarshal(payload, &imageEvent)
+		result.InstanceUUID = imageEvent.ImageCreated.InstanceUUID
+	case ssntp.InstanceMigrated:
+		var migratedEvent payloads.EventInstanceMigrated
+
+		result.Err = yaml.Unmarshal(payload, &migratedEvent)
+		result.InstanceUUID = migratedEvent.InstanceMigrated.InstanceUUID
 	case ssntp.ConcentratorInstanceAdded:
 		// forward rule auto-sends to controllers
 	case ssntp.TenantAdded:
@@ -594,6 +675,28 @@ func (server *SsntpTestServer) handleStart(payload []byte) (dest ssntp.ForwardDe
 	return dest
 }
 
+// handleStartBatch forwards each START command of a batch the same way
+// handleStart does for single START commands
+func (server *SsntpTestServer) handleStartBatch(payload []byte) (dest ssntp.ForwardDestination) {
+	var batch payloads.StartBatch
+
+	dest.SetDecision(ssntp.Discard)
+
+	err := yaml.Unmarshal(payload, &batch)
+	if err != nil {
+		return
+	}
+
+	for _, start := range batch.Batch.Starts {
+		startDest := server.handleStart([]byte(start))
+		for _, recipient := range startDest.Recipients() {
+			server.Ssntp.SendCommand(recipient, ssntp.START, []byte(start))
+		}
+	}
+
+	return
+}
+
 func (server *SsntpTestServer) handleAttachVolume(payload []byte) ssntp.ForwardDestination {
 	var cmd payloads.AttachVolume
 	var dest ssntp.ForwardDestination
@@ -643,12 +746,16 @@ func (server *SsntpTestServer) CommandForward(uuid string, command ssntp.Command
 	switch command {
 	case ssntp.START:
 		dest = server.handleStart(payload)
+	case ssntp.StartBatch:
+		dest = server.handleStartBatch(payload)
 	case ssntp.AttachVolume:
 		dest = server.handleAttachVolume(payload)
 	case ssntp.DetachVolume:
 		dest = server.handleDetachVolume(payload)
 	case ssntp.EVACUATE:
 		fallthrough
+	case ssntp.RESTORE:
+		fallthrough
 	case ssntp.STOP:
 		fallthrough
 	case ssntp.DELETE:
@@ -698,6 +805,18 @@ func StartTestServer() *SsntpTestServer {
 				Operand: ssntp.InstanceDeleted,
 				Dest:    ssntp.Controller,
 			},
+			{ // all ConsoleLog events go to all Controllers
+				Operand: ssntp.ConsoleLog,
+				Dest:    ssntp.Controller,
+			},
+			{ // all ImageCreated events go to all Controllers
+				Operand: ssntp.ImageCreated,
+				Dest:    ssntp.Controller,
+			},
+			{ // all InstanceMigrated events go to all Controllers
+				Operand: ssntp.InstanceMigrated,
+				Dest:    ssntp.Controller,
+			},
 			{ // all ConcentratorInstanceAdded events go to all Controllers
 				Operand: ssntp.ConcentratorInstanceAdded,
 				Dest:    ssntp.Controller,
@@ -734,6 +853,10 @@ func StartTestServer() *SsntpTestServer {
 				Operand:        ssntp.START,
 				CommandForward: server,
 			},
+			{ // all StartBatch command are processed by the Command forwarder
+				Operand:        ssntp.StartBatch,
+				CommandForward: server,
+			},
 			{ // all RESTART command are processed by the Command forwarder
 				Operand:        ssntp.RESTART,
 				CommandForward: server,
@@ -750,6 +873,10 @@ func StartTestServer() *SsntpTestServer {
 				Operand:        ssntp.EVACUATE,
 				CommandForward: server,
 			},
+			{ // all RESTORE command are processed by the Command forwarder
+				Operand:        ssntp.RESTORE,
+				CommandForward: server,
+			},
 			{ // all TenantAdded events are processed by the Event forwarder
 				Operand:      ssntp.TenantAdded,
 				EventForward: server,